@@ -0,0 +1,311 @@
+// Command seed fills a development database with demo data: users, nested
+// folder trees with random file contents, shares between the users and
+// favorites. It uses the same configuration as the server and writes the
+// file contents to its local storage, so the server can be started against
+// the seeded data right away. It is meant for load testing and UI work, never
+// for production databases.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var folderNames = []string{
+	"Dokumenty", "Projekty", "Zdjęcia", "Faktury", "Raporty", "Archiwum",
+	"Prezentacje", "Umowy", "Muzyka", "Notatki", "Wakacje", "Budżet",
+}
+
+var fileKinds = []struct {
+	ext  string
+	mime string
+}{
+	{"txt", "text/plain"},
+	{"pdf", "application/pdf"},
+	{"jpg", "image/jpeg"},
+	{"png", "image/png"},
+	{"docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"zip", "application/zip"},
+	{"mp3", "audio/mpeg"},
+}
+
+type options struct {
+	users     int
+	prefix    string
+	password  string
+	depth     int
+	folders   int
+	files     int
+	minSize   int64
+	maxSize   int64
+	shares    int
+	favorites int
+	seed      int64
+}
+
+type seededUser struct {
+	id    int64
+	nodes []string
+	used  int64
+}
+
+type seeder struct {
+	opts    options
+	rng     *rand.Rand
+	store   *database.Store
+	storage *storage.LocalStorage
+	nodeIDs *ids.Generator
+}
+
+func main() {
+	var opts options
+	flag.IntVar(&opts.users, "users", 5, "liczba użytkowników demonstracyjnych")
+	flag.StringVar(&opts.prefix, "prefix", "demo", "prefiks nazw użytkowników")
+	flag.StringVar(&opts.password, "password", "demo1234", "hasło wszystkich użytkowników demonstracyjnych")
+	flag.IntVar(&opts.depth, "depth", 3, "głębokość drzewa folderów")
+	flag.IntVar(&opts.folders, "folders", 3, "liczba podfolderów w każdym folderze")
+	flag.IntVar(&opts.files, "files", 5, "liczba plików w każdym folderze")
+	flag.Int64Var(&opts.minSize, "min-size", 1<<10, "minimalny rozmiar pliku w bajtach")
+	flag.Int64Var(&opts.maxSize, "max-size", 1<<20, "maksymalny rozmiar pliku w bajtach")
+	flag.IntVar(&opts.shares, "shares", 10, "liczba udostępnień między użytkownikami")
+	flag.IntVar(&opts.favorites, "favorites", 10, "liczba ulubionych")
+	flag.Int64Var(&opts.seed, "seed", 0, "ziarno generatora losowego; 0 oznacza bieżący czas")
+	flag.Parse()
+
+	if opts.users < 1 || opts.depth < 0 || opts.folders < 0 || opts.files < 0 {
+		log.Fatal("Nieprawidłowe parametry: -users musi być dodatnie, a -depth, -folders i -files nieujemne")
+	}
+	if opts.minSize < 0 || opts.maxSize < opts.minSize {
+		log.Fatal("Nieprawidłowe parametry: wymagane 0 <= -min-size <= -max-size")
+	}
+	if opts.seed == 0 {
+		opts.seed = time.Now().UnixNano()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Nie można wczytać konfiguracji: %v", err)
+	}
+
+	ctx := context.Background()
+	dbpool, err := pgxpool.New(ctx, cfg.DB.Source)
+	if err != nil {
+		log.Fatalf("Nie można połączyć się z bazą danych: %v", err)
+	}
+	defer dbpool.Close()
+	if err := dbpool.Ping(ctx); err != nil {
+		log.Fatalf("Nie można pingować bazy danych: %v", err)
+	}
+
+	localStorage, err := storage.NewLocalStorage(cfg.Storage.Path)
+	if err != nil {
+		log.Fatalf("Nie można zainicjować local storage: %v", err)
+	}
+	nodeIDs, err := ids.New("node", cfg.IDs.Alphabet, cfg.IDs.Length)
+	if err != nil {
+		log.Fatalf("Nieprawidłowa konfiguracja identyfikatorów: %v", err)
+	}
+
+	s := &seeder{
+		opts:    opts,
+		rng:     rand.New(rand.NewSource(opts.seed)),
+		store:   database.NewStore(dbpool),
+		storage: localStorage,
+		nodeIDs: nodeIDs,
+	}
+	log.Printf("Generowanie danych demonstracyjnych (ziarno %d)", opts.seed)
+	if err := s.run(ctx); err != nil {
+		log.Fatalf("Generowanie danych nie powiodło się: %v", err)
+	}
+}
+
+func (s *seeder) run(ctx context.Context) error {
+	hash, err := auth.HashPassword(s.opts.password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var users []*seededUser
+	var totalFiles int
+	var totalBytes int64
+	for i := 1; i <= s.opts.users; i++ {
+		username := fmt.Sprintf("%s%d", s.opts.prefix, i)
+		displayName := fmt.Sprintf("Użytkownik demo %d", i)
+		created, err := s.store.CreateUser(ctx, username, hash, &displayName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create user %s: %w", username, err)
+		}
+		if created == nil {
+			log.Printf("Użytkownik %s już istnieje, pomijanie", username)
+			continue
+		}
+
+		user := &seededUser{id: created.ID}
+		files, err := s.seedFolder(ctx, user, nil, 0)
+		if err != nil {
+			return fmt.Errorf("failed to seed files of %s: %w", username, err)
+		}
+		if err := s.store.UpdateUserStorage(ctx, user.id, user.used); err != nil {
+			return fmt.Errorf("failed to update storage usage of %s: %w", username, err)
+		}
+		if user.used > created.StorageQuotaBytes {
+			if _, err := s.store.UpdateUserQuota(ctx, user.id, user.used); err != nil {
+				return fmt.Errorf("failed to raise quota of %s: %w", username, err)
+			}
+		}
+		log.Printf("Utworzono użytkownika %s: %d węzłów, %d plików, %d bajtów", username, len(user.nodes), files, user.used)
+		users = append(users, user)
+		totalFiles += files
+		totalBytes += user.used
+	}
+
+	shares := s.seedShares(ctx, users)
+	favorites := s.seedFavorites(ctx, users)
+	log.Printf("Gotowe: %d użytkowników, %d plików (%d bajtów), %d udostępnień, %d ulubionych",
+		len(users), totalFiles, totalBytes, shares, favorites)
+	return nil
+}
+
+// seedFolder fills a folder, or the user's root for a nil parent, with files
+// and, above the configured depth, subfolders. It returns the number of files
+// created in the subtree.
+func (s *seeder) seedFolder(ctx context.Context, user *seededUser, parentID *string, level int) (int, error) {
+	files := 0
+	for i := 1; i <= s.opts.files; i++ {
+		kind := fileKinds[s.rng.Intn(len(fileKinds))]
+		name := fmt.Sprintf("plik_%d_%d.%s", level, i, kind.ext)
+		if err := s.createFile(ctx, user, parentID, name, kind.mime); err != nil {
+			return files, err
+		}
+		files++
+	}
+	if level >= s.opts.depth {
+		return files, nil
+	}
+
+	for i := 1; i <= s.opts.folders; i++ {
+		name := fmt.Sprintf("%s %d", folderNames[s.rng.Intn(len(folderNames))], i)
+		id, err := s.nodeIDs.Unique(ctx, s.store.NodeExists)
+		if err != nil {
+			return files, err
+		}
+		if _, err := s.store.CreateNode(ctx, database.CreateNodeParams{
+			ID:       id,
+			OwnerID:  user.id,
+			ParentID: parentID,
+			Name:     name,
+			NodeType: "folder",
+		}); err != nil {
+			return files, err
+		}
+		user.nodes = append(user.nodes, id)
+
+		n, err := s.seedFolder(ctx, user, &id, level+1)
+		files += n
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+// createFile stores random content under the node ID, the layout used for
+// files that are not deduplicated, and creates the file's node.
+func (s *seeder) createFile(ctx context.Context, user *seededUser, parentID *string, name, mimeType string) error {
+	id, err := s.nodeIDs.Unique(ctx, s.store.NodeExists)
+	if err != nil {
+		return err
+	}
+	size := s.opts.minSize
+	if s.opts.maxSize > s.opts.minSize {
+		size += s.rng.Int63n(s.opts.maxSize - s.opts.minSize + 1)
+	}
+
+	hasher := sha256.New()
+	content := io.TeeReader(io.LimitReader(s.rng, size), hasher)
+	if err := s.storage.Save(id, content); err != nil {
+		return fmt.Errorf("failed to save content of %s: %w", name, err)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+
+	if _, err := s.store.CreateNode(ctx, database.CreateNodeParams{
+		ID:            id,
+		OwnerID:       user.id,
+		ParentID:      parentID,
+		Name:          name,
+		NodeType:      "file",
+		SizeBytes:     &size,
+		MimeType:      &mimeType,
+		ContentSHA256: &checksum,
+	}); err != nil {
+		_ = s.storage.Delete(id)
+		return err
+	}
+	user.nodes = append(user.nodes, id)
+	user.used += size
+	return nil
+}
+
+// seedShares shares random nodes between random pairs of users. Pairs that
+// already share the node are skipped, so fewer shares than requested may be
+// created.
+func (s *seeder) seedShares(ctx context.Context, users []*seededUser) int {
+	if len(users) < 2 {
+		return 0
+	}
+	created := 0
+	for i := 0; i < s.opts.shares; i++ {
+		sharer := users[s.rng.Intn(len(users))]
+		recipient := users[s.rng.Intn(len(users))]
+		if sharer == recipient || len(sharer.nodes) == 0 {
+			continue
+		}
+		permissions := "read"
+		if s.rng.Intn(3) == 0 {
+			permissions = "write"
+		}
+		if _, err := s.store.ShareNode(ctx, database.ShareNodeParams{
+			NodeID:      sharer.nodes[s.rng.Intn(len(sharer.nodes))],
+			SharerID:    sharer.id,
+			RecipientID: recipient.id,
+			Permissions: permissions,
+		}); err != nil {
+			log.Printf("WARN: Nie udało się utworzyć udostępnienia: %v", err)
+			continue
+		}
+		created++
+	}
+	return created
+}
+
+// seedFavorites marks random nodes of the users as their favorites.
+func (s *seeder) seedFavorites(ctx context.Context, users []*seededUser) int {
+	created := 0
+	for i := 0; i < s.opts.favorites && len(users) > 0; i++ {
+		user := users[s.rng.Intn(len(users))]
+		if len(user.nodes) == 0 {
+			continue
+		}
+		if err := s.store.AddFavorite(ctx, user.id, user.nodes[s.rng.Intn(len(user.nodes))]); err != nil {
+			log.Printf("WARN: Nie udało się dodać ulubionego: %v", err)
+			continue
+		}
+		created++
+	}
+	return created
+}
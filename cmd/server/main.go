@@ -10,15 +10,18 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"serwer-plikow/internal/adminui"
 	"serwer-plikow/internal/api"
 	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/contentpolicy"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/features"
 	"serwer-plikow/internal/storage"
 	"serwer-plikow/internal/websocket"
-
-	"github.com/go-chi/cors"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -36,7 +39,13 @@ func main() {
 		log.Fatalf("Nie można wczytać konfiguracji: %v", err)
 	}
 
-	dbpool, err := pgxpool.New(context.Background(), cfg.DB.Source)
+	poolConfig, err := pgxpool.ParseConfig(cfg.DB.Source)
+	if err != nil {
+		log.Fatalf("Nieprawidłowa konfiguracja bazy danych: %v", err)
+	}
+	poolConfig.ConnConfig.Tracer = database.NewQueryTracer(time.Duration(cfg.DB.SlowQueryThresholdMs) * time.Millisecond)
+
+	dbpool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Fatalf("Nie można połączyć się z bazą danych: %v", err)
 	}
@@ -47,48 +56,114 @@ func main() {
 	}
 	log.Println("Pomyślnie połączono z bazą danych")
 
+	var replicaPool *pgxpool.Pool
+	if cfg.DB.ReplicaSource != "" {
+		replicaConfig, err := pgxpool.ParseConfig(cfg.DB.ReplicaSource)
+		if err != nil {
+			log.Fatalf("Nieprawidłowa konfiguracja repliki bazy danych: %v", err)
+		}
+		replicaConfig.ConnConfig.Tracer = poolConfig.ConnConfig.Tracer
+
+		replicaPool, err = pgxpool.NewWithConfig(context.Background(), replicaConfig)
+		if err != nil {
+			log.Fatalf("Nie można połączyć się z repliką bazy danych: %v", err)
+		}
+		defer replicaPool.Close()
+
+		if err := replicaPool.Ping(context.Background()); err != nil {
+			log.Fatalf("Nie można pingować repliki bazy danych: %v", err)
+		}
+		log.Println("Pomyślnie połączono z repliką bazy danych")
+	}
+
 	localStorage, err := storage.NewLocalStorage(cfg.Storage.Path)
 	if err != nil {
 		log.Fatalf("Nie można zainicjować local storage: %v", err)
 	}
 	log.Printf("Pliki będą przechowywane w: %s", cfg.Storage.Path)
+	if cfg.Storage.UploadsPath != "" {
+		if err := localStorage.SetUploadsPath(cfg.Storage.UploadsPath); err != nil {
+			log.Fatalf("Nieprawidłowy katalog uploadów: %v", err)
+		}
+	}
+	if cfg.Storage.SecureDelete {
+		localStorage.EnableShredding(shredPasses(cfg.Storage))
+		log.Printf("Bezpieczne usuwanie włączone: %d nadpisań przed usunięciem pliku", shredPasses(cfg.Storage))
+	}
+
+	blobRouter, err := newStorageRouter(localStorage, cfg.Storage)
+	if err != nil {
+		log.Fatalf("Nieprawidłowa konfiguracja magazynów plików: %v", err)
+	}
 
-	wsHub := websocket.NewHub()
+	wsHub := websocket.NewHub(websocket.HubOptions{
+		SendBufferSize:       cfg.WebSocket.SendBufferSize,
+		DisconnectOnOverflow: cfg.WebSocket.DisconnectOnOverflow,
+	})
 	go wsHub.Run()
 
 	store := database.NewStore(dbpool)
-	server := api.NewServer(cfg, store, localStorage, wsHub)
+	if replicaPool != nil {
+		store = database.NewStoreWithReplica(dbpool, replicaPool)
+	}
+	server := api.NewServer(cfg, store, localStorage, blobRouter, wsHub)
+	if len(cfg.ContentPolicy.Rules) > 0 {
+		policy, err := newContentPolicy(cfg.ContentPolicy)
+		if err != nil {
+			log.Fatalf("Nieprawidłowa konfiguracja polityki treści: %v", err)
+		}
+		server.SetContentPolicy(policy)
+		log.Printf("Polityka treści: %d reguł", len(cfg.ContentPolicy.Rules))
+	}
+	server.StartBackgroundJobs(context.Background())
+	go server.WatchConfig(context.Background())
 
 	r := chi.NewRouter()
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	r.Use(server.CORS())
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(api.MetricsMiddleware)
 
-	r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("/swagger/doc.json")))
+	if !cfg.Docs.DisableSwagger {
+		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("/swagger/doc.json")))
+	}
 	r.Get("/ws", server.ServeWsHandler)
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Serwer plików działa! Dokumentacja dostępna pod /swagger/index.html"))
-	})
+	r.Get("/", landingHandler(cfg.Docs))
 	r.Get("/health", server.HealthCheckHandler)
 	r.Get("/metrics", metricsHandler())
+	r.Handle("/admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	r.Handle("/admin/*", http.StripPrefix("/admin/", adminui.Handler()))
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/auth/login", server.LoginHandler)
 		r.Post("/auth/refresh", server.RefreshTokenHandler)
+		r.Post("/auth/verify-email", server.VerifyEmailHandler)
+		r.Post("/auth/password-reset/request", server.RequestPasswordResetHandler)
+		r.Post("/auth/password-reset/confirm", server.ConfirmPasswordResetHandler)
+		r.Get("/capabilities", server.GetCapabilitiesHandler)
+		r.Get("/announcements", server.ListActiveAnnouncementsHandler)
+		r.Get("/public/{token}", server.OpenPublicLinkHandler)
+		r.Post("/public/{token}/files", server.UploadToPublicLinkHandler)
+		r.Get("/public/{token}/preview", server.PreviewPublicLinkHandler)
+		r.Get("/public/{token}/oembed", server.PublicLinkOEmbedHandler)
+		r.Get("/public/{token}/thumbnail", server.PublicLinkThumbnailHandler)
+		r.Get("/public/{token}/qr", server.PublicLinkQRHandler)
+
+		r.Route("/federation/shares", func(r chi.Router) {
+			r.Post("/", server.ReceiveFederatedShareHandler)
+			r.Delete("/{token}", server.RevokeFederatedShareHandler)
+			r.Get("/{token}/nodes", server.ListFederatedShareNodesHandler)
+			r.Get("/{token}/download", server.DownloadFederatedShareHandler)
+		})
 
 		r.Group(func(r chi.Router) {
 			r.Use(server.AuthMiddleware)
 
+			r.Post("/auth/logout", server.LogoutHandler)
+			r.Post("/auth/logout-all", server.TerminateAllSessionsHandler)
+
 			r.Route("/sessions", func(r chi.Router) {
 				r.Get("/", server.ListSessionsHandler)
 				r.Post("/terminate_all", server.TerminateAllSessionsHandler)
@@ -98,41 +173,181 @@ func main() {
 			r.Route("/me", func(r chi.Router) {
 				r.Get("/", server.GetCurrentUserHandler)
 				r.Get("/storage", server.GetStorageUsageHandler)
+				r.Get("/storage/breakdown", server.GetStorageBreakdownHandler)
+				r.Get("/features", server.GetMyFeaturesHandler)
 				r.Patch("/password", server.ChangePasswordHandler)
+				r.Get("/email", server.GetMyEmailHandler)
+				r.Put("/email", server.SetMyEmailHandler)
+				r.Get("/versions/policy", server.GetVersionPolicyHandler)
+				r.Put("/versions/policy", server.UpdateVersionPolicyHandler)
 			})
 
 			r.Route("/nodes", func(r chi.Router) {
 				r.Get("/", server.ListNodesHandler)
 				r.Post("/folder", server.CreateFolderHandler)
 				r.Post("/file", server.UploadFileHandler)
+				r.Put("/file", server.PutFileHandler)
+				r.With(server.RequireFeature(features.ResumableUploads)).Post("/file/sessions", server.CreateUploadSessionHandler)
+				r.With(server.RequireFeature(features.InstantUploads)).Post("/file/prepare", server.PrepareUploadHandler)
+				r.Post("/preflight", server.PreflightHandler)
+				r.Post("/compare", server.CompareFoldersHandler)
 				r.Get("/archive", server.DownloadArchiveHandler)
+				r.Post("/import-archive", server.ImportArchiveHandler)
+				r.Post("/import", server.ImportURLHandler)
 
 				r.Route("/{nodeId}", func(r chi.Router) {
 					r.Get("/download", server.DownloadFileHandler)
+					r.Get("/preview", server.PreviewFileHandler)
+					r.Get("/thumbnail", server.GetThumbnailHandler)
+					r.Post("/extract", server.ExtractArchiveHandler)
 					r.Patch("/", server.UpdateNodeHandler)
 					r.Delete("/", server.DeleteNodeHandler)
 					r.Post("/restore", server.RestoreNodeHandler)
+					r.Post("/copy", server.CopyNodeHandler)
 					r.Post("/favorite", server.AddFavoriteHandler)
 					r.Delete("/favorite", server.RemoveFavoriteHandler)
+					r.Post("/offline", server.PinNodeOfflineHandler)
+					r.Delete("/offline", server.UnpinNodeOfflineHandler)
 					r.Post("/share", server.ShareNodeHandler)
+					r.Post("/federated-shares", server.CreateFederatedShareHandler)
+					r.Post("/links", server.CreatePublicLinkHandler)
+					r.Get("/access-log", server.ListNodeAccessLogHandler)
+					r.Get("/transfer-stats", server.GetNodeTransferStatsHandler)
+					r.Get("/effective-access", server.GetEffectiveAccessHandler)
+					r.Get("/shares", server.ListNodeSharesHandler)
+					r.Get("/signature", server.GetFileSignatureHandler)
+					r.Get("/checksum", server.GetChecksumHandler)
+					r.Put("/content", server.ReplaceContentHandler)
+					r.With(server.RequireFeature(features.DeltaUploads)).Put("/content/delta", server.ApplyContentDeltaHandler)
+					r.Get("/versions", server.ListNodeVersionsHandler)
+					r.Get("/versions/diff", server.GetVersionDiffHandler)
+					r.Get("/tags", server.ListNodeTagsHandler)
+					r.Get("/cleanup-policy", server.GetCleanupPolicyHandler)
+					r.Put("/cleanup-policy", server.SetCleanupPolicyHandler)
+					r.Delete("/cleanup-policy", server.DeleteCleanupPolicyHandler)
+					r.Get("/cleanup-policy/preview", server.PreviewCleanupPolicyHandler)
+					r.Get("/hook", server.GetFolderHookHandler)
+					r.Put("/hook", server.SetFolderHookHandler)
+					r.Delete("/hook", server.DeleteFolderHookHandler)
+					r.Post("/transcriptions", server.RequestTranscriptionHandler)
+					r.Get("/diff", server.GetFolderDiffHandler)
+					r.Post("/watch", server.WatchNodeHandler)
+					r.Delete("/watch", server.UnwatchNodeHandler)
 				})
 			})
 
+			r.Route("/uploads/{uploadId}", func(r chi.Router) {
+				r.Get("/", server.GetUploadSessionHandler)
+				r.Patch("/", server.UploadChunkHandler)
+				r.Delete("/", server.CancelUploadSessionHandler)
+				r.Post("/complete", server.CompleteUploadSessionHandler)
+			})
+
 			r.Route("/shares", func(r chi.Router) {
+				r.Get("/incoming", server.ListIncomingSharesHandler)
 				r.Get("/incoming/users", server.ListSharingUsersHandler)
 				r.Get("/incoming/nodes", server.ListSharedNodesHandler)
 				r.Get("/outgoing", server.ListOutgoingSharesHandler)
+				r.Get("/outgoing/stats", server.GetOutgoingShareStatsHandler)
+				r.Post("/outgoing/revoke", server.RevokeSharesHandler)
+				r.Patch("/{shareId}", server.UpdateShareHandler)
 				r.Delete("/{shareId}", server.DeleteShareHandler)
+				r.Get("/{shareId}/activity", server.GetShareActivityHandler)
+			})
+
+			r.Route("/groups", func(r chi.Router) {
+				r.Get("/", server.ListGroupsHandler)
+				r.Post("/", server.CreateGroupHandler)
+				r.Get("/{groupId}", server.GetGroupHandler)
+				r.Patch("/{groupId}", server.UpdateGroupHandler)
+				r.Delete("/{groupId}", server.DeleteGroupHandler)
+				r.Post("/{groupId}/members", server.AddGroupMemberHandler)
+				r.Delete("/{groupId}/members/{userId}", server.RemoveGroupMemberHandler)
+				r.Get("/{groupId}/shares", server.ListGroupSharesHandler)
+				r.Delete("/{groupId}/shares/{shareId}", server.DeleteGroupShareHandler)
+			})
+
+			r.Get("/links", server.ListPublicLinksHandler)
+			r.Patch("/links/{id}", server.UpdatePublicLinkHandler)
+			r.Delete("/links/{id}", server.DeletePublicLinkHandler)
+
+			r.Get("/federated-shares", server.ListFederatedSharesHandler)
+			r.Delete("/federated-shares/{shareId}", server.DeleteFederatedShareHandler)
+
+			r.Route("/remote-shares", func(r chi.Router) {
+				r.Get("/", server.ListRemoteSharesHandler)
+				r.Get("/{shareId}/nodes", server.ListRemoteShareNodesHandler)
+				r.Get("/{shareId}/download", server.DownloadRemoteShareHandler)
 			})
 
 			r.Route("/trash", func(r chi.Router) {
 				r.Get("/", server.ListTrashHandler)
+				r.Get("/summary", server.GetTrashSummaryHandler)
+				r.Get("/batches", server.ListTrashBatchesHandler)
+				r.Post("/batches/{batchId}/restore", server.RestoreTrashBatchHandler)
 				r.Delete("/purge", server.PurgeTrashHandler)
 			})
 
+			r.Post("/undo/{token}", server.UndoHandler)
+			r.Get("/transcriptions/{jobId}", server.GetTranscriptionHandler)
+			r.Get("/archive-imports/{importId}", server.GetArchiveImportHandler)
+			r.Get("/url-imports/{importId}", server.GetURLImportHandler)
+
+			r.Route("/rules", func(r chi.Router) {
+				r.Get("/", server.ListOrganizationRulesHandler)
+				r.Post("/", server.CreateOrganizationRuleHandler)
+				r.Post("/test", server.TestOrganizationRuleHandler)
+				r.Delete("/{ruleId}", server.DeleteOrganizationRuleHandler)
+				r.Post("/{ruleId}/run", server.RunOrganizationRuleHandler)
+			})
+
+			r.Route("/clipboard", func(r chi.Router) {
+				r.Get("/", server.GetClipboardHandler)
+				r.Post("/", server.SetClipboardHandler)
+				r.Delete("/", server.ClearClipboardHandler)
+				r.Post("/paste", server.PasteClipboardHandler)
+			})
+
 			r.Get("/favorites", server.ListFavoritesHandler)
+			r.Get("/offline-pins", server.ListOfflinePinsHandler)
+			r.Post("/thumbnails/batch", server.ThumbnailBatchHandler)
 
 			r.Get("/events", server.GetEventsHandler)
+			r.Get("/sync/snapshot", server.GetSyncSnapshotHandler)
+
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(server.AdminMiddleware)
+
+				r.Get("/nodes/orphans", server.ListOrphanedNodesHandler)
+				r.Post("/nodes/orphans/repair", server.RepairOrphanedNodesHandler)
+				r.With(server.RequireFeature(features.BulkNodes)).Post("/nodes/bulk", server.BulkCreateNodesHandler)
+				r.Get("/access-check", server.AdminAccessCheckHandler)
+				r.Post("/legal-exports", server.CreateLegalExportHandler)
+				r.Get("/legal-exports", server.ListLegalExportsHandler)
+				r.Get("/legal-exports/{exportId}", server.GetLegalExportHandler)
+				r.Get("/legal-exports/{exportId}/download", server.DownloadLegalExportHandler)
+				r.Post("/storage/migrations", server.CreateBlobMigrationHandler)
+				r.Get("/storage/migrations", server.ListBlobMigrationsHandler)
+				r.Get("/storage/migrations/{migrationId}", server.GetBlobMigrationHandler)
+				r.Get("/onboarding/templates", server.ListOnboardingTemplatesHandler)
+				r.Put("/onboarding/templates", server.SetOnboardingTemplatesHandler)
+				r.Get("/announcements", server.ListAnnouncementsHandler)
+				r.Post("/announcements", server.CreateAnnouncementHandler)
+				r.Delete("/announcements/{announcementId}", server.DeleteAnnouncementHandler)
+				r.Get("/quarantine", server.ListQuarantinedNodesHandler)
+				r.Post("/quarantine/{nodeId}/release", server.ReleaseQuarantinedNodeHandler)
+				r.Post("/config/reload", server.ReloadConfigHandler)
+				r.Get("/features", server.ListFeatureFlagsHandler)
+				r.Put("/features/{flag}", server.SetFeatureFlagHandler)
+				r.Delete("/features/{flag}", server.ResetFeatureFlagHandler)
+				r.Put("/features/{flag}/users/{userId}", server.SetFeatureFlagUserHandler)
+				r.Delete("/features/{flag}/users/{userId}", server.DeleteFeatureFlagUserHandler)
+				r.Get("/users", server.ListUsersHandler)
+				r.Post("/users", server.CreateUserHandler)
+				r.Patch("/users/{userId}", server.UpdateUserHandler)
+				r.Get("/jobs", server.ListJobsHandler)
+				r.Get("/stats", server.GetSystemStatsHandler)
+			})
 		})
 	})
 
@@ -145,3 +360,83 @@ func main() {
 func metricsHandler() http.HandlerFunc {
 	return promhttp.Handler().ServeHTTP
 }
+
+// landingHandler serves the root page configured in the docs section.
+func landingHandler(cfg config.DocsConfig) http.HandlerFunc {
+	switch {
+	case cfg.LandingRedirect != "":
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, cfg.LandingRedirect, http.StatusFound)
+		}
+	case cfg.LandingText != "":
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(cfg.LandingText))
+		}
+	case cfg.DisableSwagger:
+		return http.NotFound
+	default:
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Serwer plików działa! Dokumentacja dostępna pod /swagger/index.html"))
+		}
+	}
+}
+
+func shredPasses(cfg config.StorageConfig) int {
+	if cfg.ShredPasses > 0 {
+		return cfg.ShredPasses
+	}
+	return 1
+}
+
+// newStorageRouter registers the configured storage backends next to the local
+// one and the rules routing file content between them.
+func newStorageRouter(primary *storage.LocalStorage, cfg config.StorageConfig) (*storage.Router, error) {
+	router := storage.NewRouter(primary)
+	for name, backendCfg := range cfg.Backends {
+		if backendCfg.Type != "" && backendCfg.Type != "local" {
+			return nil, fmt.Errorf("backend %q: unsupported type %q", name, backendCfg.Type)
+		}
+		backend, err := storage.NewLocalStorage(backendCfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", name, err)
+		}
+		if cfg.SecureDelete {
+			backend.EnableShredding(shredPasses(cfg))
+		}
+		if err := router.Register(name, backend); err != nil {
+			return nil, err
+		}
+		log.Printf("Magazyn plików %q: %s", name, backendCfg.Path)
+	}
+	for _, rule := range cfg.Routing {
+		err := router.AddRule(storage.RoutingRule{
+			Backend:      rule.Backend,
+			MimePrefixes: rule.MimePrefixes,
+			MinSizeBytes: rule.MinSizeMB << 20,
+			MaxSizeBytes: rule.MaxSizeMB << 20,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+// newContentPolicy builds the regex-based content policy from the configured
+// rules.
+func newContentPolicy(cfg config.ContentPolicyConfig) (*contentpolicy.RegexPolicy, error) {
+	rules := make([]contentpolicy.Rule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, contentpolicy.Rule{
+			Name:      rule.Name,
+			Actions:   rule.Actions,
+			FileName:  rule.FileName,
+			MimeTypes: rule.MimeTypes,
+			Content:   rule.Content,
+			Detector:  rule.Detector,
+			Verdict:   contentpolicy.Verdict(rule.Verdict),
+			Reason:    rule.Reason,
+		})
+	}
+	return contentpolicy.NewRegexPolicy(rules, cfg.MaxScanMB<<20)
+}
@@ -7,9 +7,41 @@ CREATE TABLE users (
     display_name VARCHAR(255),
     created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
     storage_quota_bytes BIGINT NOT NULL DEFAULT 5368709120,
-    storage_used_bytes BIGINT NOT NULL DEFAULT 0
+    storage_used_bytes BIGINT NOT NULL DEFAULT 0,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    -- onboarded_at is set once the onboarding templates were copied into the
+    -- user's root.
+    onboarded_at TIMESTAMPTZ,
+    -- ldap_dn links an account synchronized from an LDAP directory; such
+    -- accounts sign in with their directory password.
+    ldap_dn TEXT UNIQUE,
+    -- disabled_at is set for accounts that can no longer sign in, e.g.
+    -- because they were removed from the directory.
+    disabled_at TIMESTAMPTZ,
+    -- email is stored in lower case. It is used for password resets only
+    -- once email_verified_at is set; a verified address belongs to a single
+    -- account.
+    email VARCHAR(254),
+    email_verified_at TIMESTAMPTZ
 );
 
+CREATE UNIQUE INDEX idx_users_verified_email ON users(email) WHERE email_verified_at IS NOT NULL;
+
+-- Single-use tokens sent by email to verify an address or reset a password.
+-- Only their SHA-256 is stored. Used tokens are kept for an hour, so the
+-- emails sent to an account can be rate-limited.
+CREATE TABLE email_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('verify_email', 'password_reset')),
+    email VARCHAR(254) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_email_tokens_user ON email_tokens(user_id, purpose, created_at);
+
 CREATE TABLE sessions (
     id UUID PRIMARY KEY, 
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
@@ -17,10 +49,25 @@ CREATE TABLE sessions (
     user_agent TEXT,
     client_ip TEXT,
     expires_at TIMESTAMPTZ NOT NULL,
-    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    family_id UUID NOT NULL,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_family_id ON sessions(family_id);
+
+-- Refresh tokens already exchanged for a new pair. Presenting one again means
+-- the token leaked, so the whole session family gets revoked.
+CREATE TABLE rotated_refresh_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    family_id UUID NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rotated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_rotated_refresh_tokens_family_id ON rotated_refresh_tokens(family_id);
 
 CREATE TABLE nodes (
     id VARCHAR(21) PRIMARY KEY,
@@ -33,7 +80,11 @@ CREATE TABLE nodes (
     created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
     modified_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
     deleted_at TIMESTAMPTZ,
-    original_parent_id VARCHAR(21)
+    original_parent_id VARCHAR(21),
+    deletion_batch_id UUID,
+    storage_backend VARCHAR(64) NOT NULL DEFAULT 'local',
+    content_sha256 CHAR(64),
+    storage_key VARCHAR(71)
 );
 
 CREATE UNIQUE INDEX unique_name_in_folder ON nodes (owner_id, parent_id, name) WHERE parent_id IS NOT NULL;
@@ -41,24 +92,402 @@ CREATE UNIQUE INDEX unique_name_in_root ON nodes (owner_id, name) WHERE parent_i
 
 CREATE INDEX idx_nodes_owner_id ON nodes(owner_id);
 CREATE INDEX idx_nodes_parent_id ON nodes(parent_id);
+CREATE INDEX idx_nodes_deletion_batch_id ON nodes(deletion_batch_id) WHERE deletion_batch_id IS NOT NULL;
+CREATE INDEX idx_nodes_owner_content ON nodes(owner_id, content_sha256) WHERE content_sha256 IS NOT NULL;
+CREATE INDEX idx_nodes_storage ON nodes(storage_backend, storage_key) WHERE node_type = 'file';
 
 CREATE TABLE shares (
     id SERIAL PRIMARY KEY,
     node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
     sharer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-    permissions VARCHAR(20) NOT NULL CHECK (permissions IN ('read', 'write')),
+    permissions VARCHAR(20) NOT NULL CHECK (permissions IN ('read', 'write', 'manage')),
+    message TEXT,
+    pinned_version INTEGER CHECK (pinned_version > 0),
     shared_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    review_reminded_at TIMESTAMPTZ,
 
     CONSTRAINT unique_share_per_recipient UNIQUE (node_id, recipient_id)
 );
 
+CREATE TABLE groups (
+    id SERIAL PRIMARY KEY,
+    -- Groups synchronized from an LDAP directory have no owner; ldap_dn links
+    -- them to their directory entry, which manages their name and members.
+    owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    ldap_dn TEXT UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT unique_group_name_per_owner UNIQUE (owner_id, name),
+    CONSTRAINT group_owned_or_synchronized CHECK ((owner_id IS NULL) <> (ldap_dn IS NULL))
+);
+
+CREATE TABLE group_members (
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX idx_group_members_user_id ON group_members(user_id);
+
+CREATE TABLE group_shares (
+    id SERIAL PRIMARY KEY,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    sharer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    permissions VARCHAR(20) NOT NULL CHECK (permissions IN ('read', 'write', 'manage')),
+    message TEXT,
+    shared_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT unique_share_per_group UNIQUE (node_id, group_id)
+);
+CREATE INDEX idx_group_shares_group_id ON group_shares(group_id);
+
+-- share_grants lists every user's access to a node through a share: direct
+-- shares, and shares with a group expanded to its current members. Access
+-- checks read it, so membership changes take effect immediately.
+CREATE VIEW share_grants AS
+    SELECT id, node_id, sharer_id, recipient_id, permissions, message, pinned_version, NULL::INTEGER AS group_id, shared_at
+    FROM shares
+    UNION ALL
+    SELECT gs.id, gs.node_id, gs.sharer_id, gm.user_id, gs.permissions, gs.message, NULL::INTEGER, gs.group_id, gs.shared_at
+    FROM group_shares gs
+    JOIN group_members gm ON gm.group_id = gs.group_id;
+
 CREATE TABLE user_favorites (
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
     PRIMARY KEY (user_id, node_id)
 );
 
+CREATE TABLE upload_sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id VARCHAR(21) REFERENCES nodes(id) ON DELETE CASCADE,
+    file_name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(255),
+    total_size BIGINT NOT NULL CHECK (total_size >= 0),
+    temp_location TEXT NOT NULL,
+    expected_sha256 VARCHAR(64),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_upload_sessions_user_id ON upload_sessions(user_id);
+CREATE INDEX idx_upload_sessions_expires_at ON upload_sessions(expires_at);
+
+CREATE TABLE upload_chunks (
+    session_id UUID NOT NULL REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    offset_bytes BIGINT NOT NULL CHECK (offset_bytes >= 0),
+    size_bytes BIGINT NOT NULL CHECK (size_bytes > 0),
+    checksum VARCHAR(64),
+    received_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (session_id, offset_bytes)
+);
+
+CREATE TABLE derived_artifacts (
+    id BIGSERIAL PRIMARY KEY,
+    node_id VARCHAR(21) NOT NULL,
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
+    kind VARCHAR(50) NOT NULL,
+    storage_key VARCHAR(64) NOT NULL UNIQUE,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+
+    CONSTRAINT unique_artifact_per_content UNIQUE (node_id, content_hash, kind)
+);
+
+CREATE INDEX idx_derived_artifacts_node_id ON derived_artifacts(node_id);
+
+CREATE TABLE node_versions (
+    node_id VARCHAR(21) NOT NULL,
+    version INTEGER NOT NULL CHECK (version > 0),
+    storage_key VARCHAR(21) NOT NULL UNIQUE,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    mime_type VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL,
+    archived_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (node_id, version)
+);
+
+CREATE TABLE version_policies (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    max_versions_per_file INTEGER CHECK (max_versions_per_file > 0),
+    max_bytes BIGINT CHECK (max_bytes > 0),
+    max_age_days INTEGER CHECK (max_age_days > 0),
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE undo_tokens (
+    token VARCHAR(40) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    operation VARCHAR(20) NOT NULL CHECK (operation IN ('trash', 'update')),
+    before_state JSONB NOT NULL,
+    after_state JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_undo_tokens_expires_at ON undo_tokens(expires_at);
+
+CREATE TABLE clipboards (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    operation VARCHAR(10) NOT NULL CHECK (operation IN ('cut', 'copy')),
+    node_ids VARCHAR(21)[] NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE node_tags (
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    PRIMARY KEY (node_id, tag)
+);
+
+CREATE TABLE organization_rules (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    name_pattern VARCHAR(255) NOT NULL,
+    source_folder_id VARCHAR(21) REFERENCES nodes(id) ON DELETE CASCADE,
+    target_folder_id VARCHAR(21) REFERENCES nodes(id) ON DELETE CASCADE,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    CHECK (target_folder_id IS NOT NULL OR cardinality(tags) > 0)
+);
+
+CREATE INDEX idx_organization_rules_owner_id ON organization_rules(owner_id);
+
+CREATE TABLE folder_cleanup_policies (
+    folder_id VARCHAR(21) PRIMARY KEY REFERENCES nodes(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    max_age_days INTEGER CHECK (max_age_days > 0),
+    keep_newest INTEGER CHECK (keep_newest > 0),
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    CHECK (max_age_days IS NOT NULL OR keep_newest IS NOT NULL)
+);
+
+CREATE TABLE transcription_jobs (
+    id UUID PRIMARY KEY,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    requested_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('vtt', 'txt')),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    progress INTEGER NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    result_node_id VARCHAR(21) REFERENCES nodes(id) ON DELETE SET NULL,
+    error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_transcription_jobs_status ON transcription_jobs(status, created_at);
+
+CREATE TABLE archive_imports (
+    id UUID PRIMARY KEY,
+    requested_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id VARCHAR(21) REFERENCES nodes(id) ON DELETE CASCADE,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('zip', 'tar', 'tar.gz')),
+    staged_id VARCHAR(21) NOT NULL,
+    source_node_id VARCHAR(21) REFERENCES nodes(id) ON DELETE SET NULL,
+    total_entries INTEGER NOT NULL,
+    total_bytes BIGINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    progress INTEGER NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    created_nodes INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_archive_imports_status ON archive_imports(status, created_at);
+
+CREATE TABLE folder_hooks (
+    folder_id VARCHAR(21) PRIMARY KEY REFERENCES nodes(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(40) NOT NULL,
+    mime_prefixes TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE folder_hook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    folder_id VARCHAR(21) NOT NULL REFERENCES folder_hooks(folder_id) ON DELETE CASCADE,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_folder_hook_deliveries_due ON folder_hook_deliveries(next_attempt_at);
+
+CREATE TABLE legal_exports (
+    id UUID PRIMARY KEY,
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    -- node_id has no foreign key: an export must outlive the nodes it covers.
+    node_id VARCHAR(21) NOT NULL,
+    owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    storage_key VARCHAR(21),
+    size_bytes BIGINT,
+    sha256 VARCHAR(64),
+    entries INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_legal_exports_status ON legal_exports(status, created_at);
+
+CREATE TABLE announcements (
+    id SERIAL PRIMARY KEY,
+    message TEXT NOT NULL,
+    level VARCHAR(20) NOT NULL DEFAULT 'info' CHECK (level IN ('info', 'warning', 'critical')),
+    starts_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    ends_at TIMESTAMPTZ,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    -- published_at is set once the announcement has been pushed over WebSocket.
+    published_at TIMESTAMPTZ,
+    CHECK (ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_announcements_window ON announcements(starts_at, ends_at);
+
+-- Nodes copied into the root of every new user.
+CREATE TABLE onboarding_templates (
+    node_id VARCHAR(21) PRIMARY KEY REFERENCES nodes(id) ON DELETE CASCADE,
+    added_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    added_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_users_not_onboarded ON users(id) WHERE onboarded_at IS NULL;
+
+-- Shares of local nodes with users of federated instances.
+CREATE TABLE federated_shares (
+    id SERIAL PRIMARY KEY,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    sharer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    peer VARCHAR(255) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    shared_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    UNIQUE (node_id, peer, recipient)
+);
+
+CREATE INDEX idx_federated_shares_sharer_id ON federated_shares(sharer_id);
+
+-- Links giving anyone with the token read access to a node, without an account.
+CREATE TABLE public_links (
+    id SERIAL PRIMARY KEY,
+    token VARCHAR(64) UNIQUE NOT NULL,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    download_count INTEGER NOT NULL DEFAULT 0,
+    last_accessed_at TIMESTAMPTZ,
+    password_hash VARCHAR(255),
+    expires_at TIMESTAMPTZ,
+    max_downloads INTEGER CHECK (max_downloads > 0),
+    -- Bytes of file content sent through the link, counted after each
+    -- download; max_transfer_bytes stops the link once they reach it.
+    bytes_served BIGINT NOT NULL DEFAULT 0,
+    max_transfer_bytes BIGINT CHECK (max_transfer_bytes > 0),
+    -- When the owner was told the link reached its transfer cap; cleared
+    -- when the cap changes.
+    transfer_cap_notified_at TIMESTAMPTZ,
+    -- 'upload' links let visitors add files to a folder without seeing it.
+    link_type VARCHAR(10) NOT NULL DEFAULT 'download' CHECK (link_type IN ('download', 'upload')),
+    -- Optional short name opening the link instead of its token; shorter
+    -- than tokens, so the two never collide.
+    slug VARCHAR(30) UNIQUE CHECK (slug ~ '^[a-z0-9-]{3,30}$')
+);
+
+CREATE INDEX idx_public_links_owner_id ON public_links(owner_id);
+CREATE INDEX idx_public_links_node_id ON public_links(node_id);
+
+-- Shares offered to local users by federated instances.
+CREATE TABLE remote_shares (
+    id SERIAL PRIMARY KEY,
+    peer VARCHAR(255) NOT NULL,
+    token VARCHAR(64) NOT NULL,
+    recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    owner VARCHAR(255) NOT NULL,
+    owner_display_name VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    node_type VARCHAR(10) NOT NULL CHECK (node_type IN ('file', 'folder')),
+    size_bytes BIGINT,
+    mime_type VARCHAR(255),
+    permissions VARCHAR(20) NOT NULL DEFAULT 'read',
+    shared_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    UNIQUE (peer, token)
+);
+
+CREATE INDEX idx_remote_shares_recipient_id ON remote_shares(recipient_id);
+
+-- Files the content policy put in quarantine; they cannot be downloaded or
+-- shared until an administrator releases them.
+CREATE TABLE quarantined_nodes (
+    node_id VARCHAR(21) PRIMARY KEY REFERENCES nodes(id) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('upload', 'share')),
+    rule VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    quarantined_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE content_blobs (
+    storage_backend VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(71) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    ref_count INTEGER NOT NULL CHECK (ref_count >= 0),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (storage_backend, storage_key)
+);
+
+CREATE TABLE offline_pins (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(64) NOT NULL,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    pinned_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (user_id, device_id, node_id)
+);
+
+CREATE INDEX idx_offline_pins_node_id ON offline_pins(node_id);
+
+CREATE TABLE url_imports (
+    id UUID PRIMARY KEY,
+    requested_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id VARCHAR(21) REFERENCES nodes(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    file_name VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    node_id VARCHAR(21) REFERENCES nodes(id) ON DELETE SET NULL,
+    size_bytes BIGINT,
+    error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_url_imports_status ON url_imports(status, created_at);
+
+CREATE TABLE node_watches (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    digest_frequency VARCHAR(10) NOT NULL DEFAULT 'daily' CHECK (digest_frequency IN ('daily', 'weekly')),
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    last_digest_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (user_id, node_id)
+);
+
+CREATE INDEX idx_node_watches_node_id ON node_watches(node_id);
+
 CREATE TABLE event_journal (
     id BIGSERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
@@ -69,8 +498,84 @@ CREATE TABLE event_journal (
 
 CREATE INDEX idx_event_journal_user_id_id ON event_journal(user_id, id);
 
-INSERT INTO users (username, password_hash, display_name, storage_quota_bytes)
-VALUES ('admin', '$2a$12$Q5YPzisDD241y55p0fwlJe/myrAlTl4BEzromC5nKzDM6jK33XaBK', 'Administrator', 10485760);
+CREATE TABLE access_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    node_id VARCHAR(21) NOT NULL,
+    owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    action VARCHAR(50) NOT NULL,
+    client_ip TEXT,
+    user_agent TEXT,
+    accessed_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_access_log_node_id ON access_log(node_id, accessed_at);
+CREATE INDEX idx_access_log_accessed_at ON access_log(accessed_at);
+
+-- download_transfers records every response serving file content, including
+-- each ranged request, so full downloads can be told apart from resumed ones
+-- and from partial reads such as seeking in a video. Entries follow the
+-- access log retention.
+CREATE TABLE download_transfers (
+    id BIGSERIAL PRIMARY KEY,
+    node_id VARCHAR(21) NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    public_link BOOLEAN NOT NULL DEFAULT FALSE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('full', 'resumed', 'partial')),
+    range_start BIGINT NOT NULL DEFAULT 0,
+    bytes_requested BIGINT NOT NULL,
+    bytes_served BIGINT NOT NULL,
+    served_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE INDEX idx_download_transfers_node_id ON download_transfers(node_id, served_at);
+CREATE INDEX idx_download_transfers_served_at ON download_transfers(served_at);
+
+CREATE TABLE blob_migrations (
+    id UUID PRIMARY KEY,
+    requested_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    from_backend VARCHAR(64) NOT NULL,
+    to_backend VARCHAR(64) NOT NULL,
+    -- owner_id limits the migration to one user's files; NULL migrates all.
+    owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    total_blobs INTEGER NOT NULL DEFAULT 0,
+    migrated_blobs INTEGER NOT NULL DEFAULT 0,
+    migrated_bytes BIGINT NOT NULL DEFAULT 0,
+    failed_blobs INTEGER NOT NULL DEFAULT 0,
+    -- last_key is the last blob key processed, so a reclaimed migration resumes after it.
+    last_key VARCHAR(71),
+    error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    completed_at TIMESTAMPTZ,
+
+    CHECK (from_backend <> to_backend)
+);
+
+CREATE INDEX idx_blob_migrations_status ON blob_migrations(status, created_at);
+
+CREATE TABLE feature_flags (
+    name VARCHAR(100) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL,
+    rollout_percent INTEGER NOT NULL DEFAULT 0 CHECK (rollout_percent BETWEEN 0 AND 100),
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW() NOT NULL
+);
+
+CREATE TABLE feature_flag_users (
+    flag_name VARCHAR(100) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW() NOT NULL,
+    PRIMARY KEY (flag_name, user_id)
+);
+
+CREATE INDEX idx_feature_flag_users_user_id ON feature_flag_users(user_id);
+
+INSERT INTO users (username, password_hash, display_name, storage_quota_bytes, is_admin)
+VALUES ('admin', '$2a$12$Q5YPzisDD241y55p0fwlJe/myrAlTl4BEzromC5nKzDM6jK33XaBK', 'Administrator', 10485760, TRUE);
 
 INSERT INTO users (username, password_hash, display_name, storage_quota_bytes)
 VALUES ('user', '$2a$12$YVeabseYD5moPjzMWjtMQOgc4sx0U4avHCOW5AdfLm41TTHEYrWlC', 'Test User', 10485760);
\ No newline at end of file
@@ -0,0 +1,32 @@
+// Package adminui serves the embedded administration panel. The panel is a
+// static page that signs in through the regular login endpoint and calls the
+// /api/v1/admin endpoints, so the admin role is enforced by the API and the
+// assets themselves need no authentication.
+package adminui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var assets embed.FS
+
+// Handler serves the panel's assets. It expects the URL prefix under which
+// the panel is mounted to be stripped.
+func Handler() http.Handler {
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.FileServer(http.FS(static))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
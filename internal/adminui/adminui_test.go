@@ -0,0 +1,30 @@
+package adminui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "Panel administracyjny")
+	require.Equal(t, "default-src 'self'", rr.Header().Get("Content-Security-Policy"))
+	require.Equal(t, "DENY", rr.Header().Get("X-Frame-Options"))
+
+	for _, asset := range []string{"/app.js", "/style.css"} {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, asset, nil))
+		require.Equal(t, http.StatusOK, rr.Code, asset)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing.js", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
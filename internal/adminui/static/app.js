@@ -0,0 +1,277 @@
+"use strict";
+
+const API = "/api/v1";
+const PAGE_SIZE = 50;
+const GIB = 1024 * 1024 * 1024;
+
+let token = sessionStorage.getItem("adminToken");
+let usersOffset = 0;
+
+const $ = (selector) => document.querySelector(selector);
+
+function showMessage(text, ok) {
+  const el = $("#message");
+  el.textContent = text;
+  el.classList.toggle("ok", Boolean(ok));
+  el.hidden = false;
+}
+
+function clearMessage() {
+  $("#message").hidden = true;
+}
+
+async function api(method, path, body) {
+  const options = { method, headers: { Authorization: "Bearer " + token } };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  const res = await fetch(API + path, options);
+  if (res.status === 401) {
+    signOut();
+    throw new Error("Sesja wygasła, zaloguj się ponownie.");
+  }
+  if (res.status === 403) {
+    throw new Error("Brak uprawnień administratora.");
+  }
+  if (!res.ok) {
+    throw new Error((await res.text()).trim() || res.statusText);
+  }
+  return res.status === 204 ? null : res.json();
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return value.toFixed(unit === 0 ? 0 : 1) + " " + units[unit];
+}
+
+function formatDate(value) {
+  return value ? new Date(value).toLocaleString("pl-PL") : "—";
+}
+
+function cell(row, text) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  row.appendChild(td);
+  return td;
+}
+
+function signOut() {
+  token = null;
+  sessionStorage.removeItem("adminToken");
+  $("#login-view").hidden = false;
+  $("#app-view").hidden = true;
+  $("#logout").hidden = true;
+}
+
+function signedIn() {
+  $("#login-view").hidden = true;
+  $("#app-view").hidden = false;
+  $("#logout").hidden = false;
+  loadUsers();
+}
+
+async function login(event) {
+  event.preventDefault();
+  clearMessage();
+  const form = event.target;
+  const res = await fetch(API + "/auth/login", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username: form.username.value, password: form.password.value }),
+  });
+  if (!res.ok) {
+    showMessage("Nieprawidłowa nazwa użytkownika lub hasło.");
+    return;
+  }
+  token = (await res.json()).access_token;
+  sessionStorage.setItem("adminToken", token);
+  form.reset();
+  signedIn();
+}
+
+async function loadUsers() {
+  try {
+    const users = await api("GET", "/admin/users?limit=" + PAGE_SIZE + "&offset=" + usersOffset);
+    const tbody = $("#users");
+    tbody.replaceChildren();
+    for (const user of users) {
+      tbody.appendChild(userRow(user));
+    }
+    $("#users-prev").disabled = usersOffset === 0;
+    $("#users-next").disabled = users.length < PAGE_SIZE;
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+function userRow(user) {
+  const row = document.createElement("tr");
+  if (user.disabled_at) {
+    row.classList.add("disabled");
+  }
+  cell(row, user.id);
+  cell(row, user.display_name ? user.display_name + " (" + user.username + ")" : user.username);
+  cell(row, formatBytes(user.storage_used_bytes));
+
+  const quota = document.createElement("input");
+  quota.type = "number";
+  quota.min = "0";
+  quota.step = "0.1";
+  quota.value = (user.storage_quota_bytes / GIB).toFixed(1);
+  const quotaCell = cell(row, "");
+  quotaCell.appendChild(quota);
+  const save = document.createElement("button");
+  save.textContent = "Zapisz";
+  save.addEventListener("click", () =>
+    updateUser(user.id, { storage_quota_bytes: Math.round(Number(quota.value) * GIB) }));
+  quotaCell.appendChild(save);
+
+  let status = user.disabled_at ? "Wyłączony" : "Aktywny";
+  if (user.is_admin) {
+    status += ", administrator";
+  }
+  if (user.directory) {
+    status += ", LDAP";
+  }
+  cell(row, status);
+
+  const actions = cell(row, "");
+  if (!user.directory) {
+    const toggle = document.createElement("button");
+    toggle.textContent = user.disabled_at ? "Włącz" : "Wyłącz";
+    toggle.addEventListener("click", () => updateUser(user.id, { disabled: !user.disabled_at }));
+    actions.appendChild(toggle);
+  }
+  return row;
+}
+
+async function updateUser(id, changes) {
+  clearMessage();
+  try {
+    await api("PATCH", "/admin/users/" + id, changes);
+    showMessage("Zapisano zmiany.", true);
+    loadUsers();
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+async function createUser(event) {
+  event.preventDefault();
+  clearMessage();
+  const form = event.target;
+  const body = { username: form.username.value, password: form.password.value };
+  if (form.display_name.value) {
+    body.display_name = form.display_name.value;
+  }
+  if (form.quota_gib.value) {
+    body.storage_quota_bytes = Math.round(Number(form.quota_gib.value) * GIB);
+  }
+  try {
+    const user = await api("POST", "/admin/users", body);
+    form.reset();
+    showMessage("Utworzono użytkownika " + user.username + ".", true);
+    loadUsers();
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+async function loadJobs() {
+  try {
+    const jobs = await api("GET", "/admin/jobs");
+    const tbody = $("#jobs");
+    tbody.replaceChildren();
+    for (const job of jobs) {
+      const row = document.createElement("tr");
+      cell(row, job.running ? job.name + " (w toku)" : job.name);
+      cell(row, job.interval_seconds + " s");
+      cell(row, job.runs);
+      cell(row, job.failures);
+      cell(row, formatDate(job.last_started_at));
+      cell(row, job.last_finished_at ? job.last_duration_ms + " ms" : "—");
+      cell(row, job.last_error || "");
+      tbody.appendChild(row);
+    }
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+const STATS = [
+  ["users", "Użytkownicy"],
+  ["admin_users", "Administratorzy"],
+  ["disabled_users", "Wyłączone konta"],
+  ["active_sessions", "Aktywne sesje"],
+  ["files", "Pliki"],
+  ["folders", "Foldery"],
+  ["used_bytes", "Zajęte przez pliki", true],
+  ["trashed_files", "Pliki w koszu"],
+  ["trashed_bytes", "Zajęte przez kosz", true],
+  ["versions", "Wersje archiwalne"],
+  ["version_bytes", "Zajęte przez wersje", true],
+  ["stored_blob_bytes", "Zajęte w magazynie", true],
+  ["charged_bytes", "Wliczane do limitów", true],
+  ["quota_bytes", "Suma limitów", true],
+  ["shares", "Udostępnienia"],
+  ["public_links", "Linki publiczne"],
+  ["pending_uploads", "Przesyłania w toku"],
+];
+
+async function loadStats() {
+  try {
+    const stats = await api("GET", "/admin/stats");
+    const dl = $("#stats");
+    dl.replaceChildren();
+    for (const [key, label, bytes] of STATS) {
+      const dt = document.createElement("dt");
+      dt.textContent = label;
+      const dd = document.createElement("dd");
+      dd.textContent = bytes ? formatBytes(stats[key]) : stats[key];
+      dl.append(dt, dd);
+    }
+  } catch (err) {
+    showMessage(err.message);
+  }
+}
+
+const loaders = { users: loadUsers, jobs: loadJobs, stats: loadStats };
+
+function switchTab(name) {
+  clearMessage();
+  for (const button of document.querySelectorAll("nav button")) {
+    button.classList.toggle("active", button.dataset.tab === name);
+  }
+  for (const tab of document.querySelectorAll(".tab")) {
+    tab.hidden = tab.id !== "tab-" + name;
+  }
+  loaders[name]();
+}
+
+$("#login-form").addEventListener("submit", login);
+$("#logout").addEventListener("click", signOut);
+$("#create-user-form").addEventListener("submit", createUser);
+$("#users-prev").addEventListener("click", () => {
+  usersOffset = Math.max(0, usersOffset - PAGE_SIZE);
+  loadUsers();
+});
+$("#users-next").addEventListener("click", () => {
+  usersOffset += PAGE_SIZE;
+  loadUsers();
+});
+$("#jobs-refresh").addEventListener("click", loadJobs);
+$("#stats-refresh").addEventListener("click", loadStats);
+for (const button of document.querySelectorAll("nav button")) {
+  button.addEventListener("click", () => switchTab(button.dataset.tab));
+}
+
+if (token) {
+  signedIn();
+}
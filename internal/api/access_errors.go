@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+)
+
+type AccessErrorResponse struct {
+	Code    string `json:"code" example:"not_shared"`
+	Message string `json:"message" example:"This node is not shared with you"`
+}
+
+// writeNodeNotFound answers a request for a node the user cannot use. By
+// default it is a 404 with the message of code whether or not the node exists,
+// so node IDs cannot be probed. With errors.explicit_forbidden set, a node that
+// exists is answered with a 403 and a reason code instead.
+func (s *Server) writeNodeNotFound(w http.ResponseWriter, r *http.Request, nodeID string, code string, args ...any) {
+	if !s.config.Load().Errors.ExplicitForbidden {
+		writeErrorf(w, r, http.StatusNotFound, code, args...)
+		return
+	}
+
+	claims := GetUserFromContext(r.Context())
+	state, err := s.store.GetNodeAccessState(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to check access of user %d to node %s: %v", claims.UserID, nodeID, err)
+	}
+	if state == nil {
+		writeErrorf(w, r, http.StatusNotFound, code, args...)
+		return
+	}
+
+	reason := i18n.InsufficientPermission
+	switch {
+	case state.Trashed:
+		reason = i18n.NodeTrashed
+	case !state.Readable:
+		reason = i18n.NotShared
+	}
+	lang := requestLanguage(r)
+	response := AccessErrorResponse{Code: reason, Message: i18n.Message(reason, lang)}
+
+	w.Header().Set("X-Error-Code", reason)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func clientIPFromRequest(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// anonymizeIP zeroes the host part of an address: the last octet for IPv4
+// and everything past the /48 prefix for IPv6.
+func anonymizeIP(rawIP string) string {
+	ip := net.ParseIP(rawIP)
+	if ip == nil {
+		return ""
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func (s *Server) recordAccess(r *http.Request, userID int64, nodeID string, ownerID int64, action string) {
+	clientIP := clientIPFromRequest(r)
+	if s.config.Load().AccessLog.AnonymizeIP {
+		clientIP = anonymizeIP(clientIP)
+	}
+
+	params := database.LogAccessParams{
+		UserID:    userID,
+		NodeID:    nodeID,
+		OwnerID:   ownerID,
+		Action:    action,
+		ClientIP:  clientIP,
+		UserAgent: r.UserAgent(),
+	}
+	if err := s.store.LogAccess(r.Context(), params); err != nil {
+		log.Printf("ERROR: Failed to record %s access to node %s by user %d: %v", action, nodeID, userID, err)
+	}
+}
+
+// recordShredded notes in the access log that the content of purged files was
+// securely overwritten, for deployments that must prove erasure.
+func (s *Server) recordShredded(r *http.Request, userID int64, ownerID int64, nodeIDs []string) {
+	if !s.config.Load().Storage.SecureDelete || len(nodeIDs) == 0 {
+		return
+	}
+	clientIP := clientIPFromRequest(r)
+	if s.config.Load().AccessLog.AnonymizeIP {
+		clientIP = anonymizeIP(clientIP)
+	}
+
+	params := database.LogAccessParams{
+		UserID:    userID,
+		OwnerID:   ownerID,
+		Action:    "shredded",
+		ClientIP:  clientIP,
+		UserAgent: r.UserAgent(),
+	}
+	if err := s.store.LogAccessMany(r.Context(), params, nodeIDs); err != nil {
+		log.Printf("CRITICAL: Failed to record shredding of %d files of user %d: %v", len(nodeIDs), ownerID, err)
+	}
+}
+
+func (s *Server) pruneAccessLogs(ctx context.Context) error {
+	if s.config.Load().AccessLog.RetentionDays <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -s.config.Load().AccessLog.RetentionDays)
+	deleted, err := s.store.DeleteAccessLogsBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Access log retention: removed %d entries older than %s", deleted, cutoff.Format(time.RFC3339))
+	}
+
+	deleted, err = s.store.DeleteDownloadTransfersBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Access log retention: removed %d download transfers older than %s", deleted, cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// @Summary      Get node access log
+// @Description  Lists who accessed (downloaded) a node, when and from where. Only the owner of the node can view its access log. Client IPs may be anonymized depending on server configuration.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId   path      string  true   "Node ID"
+// @Param        limit    query     int     false  "Number of items to return" default(100)
+// @Param        offset   query     int     false  "Offset for pagination" default(0)
+// @Success      200      {array}   dto.AccessLogEntry
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found - Node does not exist or user is not the owner"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/access-log [get]
+func (s *Server) ListNodeAccessLogHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+	limit, offset := parsePagination(r)
+
+	node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.NodeNotOwned)
+		return
+	}
+
+	entries, err := s.store.ListAccessLogForNode(r.Context(), nodeID, claims.UserID, limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list access log for node %s: %v", nodeID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.AccessLogLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.AccessLogEntries(entries))
+}
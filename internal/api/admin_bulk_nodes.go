@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"time"
+)
+
+const (
+	maxBulkFolders        = 10000
+	maxBulkFilesPerFolder = 10000
+	maxBulkNodes          = 1000000
+)
+
+type BulkNodesRequest struct {
+	OwnerID int64 `json:"owner_id" example:"2"`
+	// ParentID is the folder of the owner the batch is created in; empty
+	// means the owner's root.
+	ParentID       *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Folders        int     `json:"folders" example:"100"`
+	FilesPerFolder int     `json:"files_per_folder" example:"100"`
+}
+
+type BulkNodesResponse struct {
+	// Batch prefixes the names of the created folders, e.g. to find and
+	// delete them after the test.
+	Batch      string `json:"batch" example:"bulk-Xk3v9QaZ"`
+	Folders    int    `json:"folders" example:"100"`
+	Files      int    `json:"files" example:"10000"`
+	DurationMs int64  `json:"duration_ms" example:"840"`
+}
+
+// @Summary      Bulk create nodes
+// @Description  Creates many node records at once with COPY, for capacity planning and index benchmarks: the given number of folders, each holding the given number of empty files, in a folder of the owner or the owner's root. The files have no stored content, so the endpoint is meant for test instances. Folder names start with the returned batch name. Requires the bulk_nodes feature flag, off by default.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      BulkNodesRequest  true  "Batch size and placement"
+// @Success      201      {object}  BulkNodesResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required or the feature is disabled"
+// @Failure      404      {string}  string "Not Found - Owner or parent folder not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/nodes/bulk [post]
+func (s *Server) BulkCreateNodesHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkNodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.Folders < 1 || req.Folders > maxBulkFolders {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidBulkFolders, maxBulkFolders)
+		return
+	}
+	if req.FilesPerFolder < 0 || req.FilesPerFolder > maxBulkFilesPerFolder {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidBulkFilesPerFolder, maxBulkFilesPerFolder)
+		return
+	}
+	if req.Folders*(req.FilesPerFolder+1) > maxBulkNodes {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.TooManyBulkNodes, maxBulkNodes)
+		return
+	}
+
+	owner, err := s.store.GetUserByID(r.Context(), req.OwnerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.OwnerLookupFailed)
+		return
+	}
+	if owner == nil {
+		writeError(w, r, http.StatusNotFound, i18n.OwnerNotFound)
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == "" {
+		req.ParentID = nil
+	}
+	if req.ParentID != nil {
+		parent, err := s.store.GetNodeByID(r.Context(), *req.ParentID, owner.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.ParentLookupFailed)
+			return
+		}
+		if parent == nil || parent.NodeType != "folder" {
+			writeError(w, r, http.StatusNotFound, i18n.ParentNotFound)
+			return
+		}
+	}
+
+	// Node IDs can be configured shorter than the tag, so it comes from a
+	// fixed-length token instead.
+	token, err := ids.Token()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.BulkCreateFailed)
+		return
+	}
+	batch := "bulk-" + token[:8]
+	nodes := make([]database.CreateNodeParams, 0, req.Folders*(req.FilesPerFolder+1))
+	var size int64
+	mimeType := "application/octet-stream"
+	for i := 1; i <= req.Folders; i++ {
+		folderID := s.nodeIDs.NewID()
+		nodes = append(nodes, database.CreateNodeParams{
+			ID:       folderID,
+			OwnerID:  owner.ID,
+			ParentID: req.ParentID,
+			Name:     fmt.Sprintf("%s-%05d", batch, i),
+			NodeType: "folder",
+		})
+		for j := 1; j <= req.FilesPerFolder; j++ {
+			nodes = append(nodes, database.CreateNodeParams{
+				ID:        s.nodeIDs.NewID(),
+				OwnerID:   owner.ID,
+				ParentID:  &folderID,
+				Name:      fmt.Sprintf("file-%05d.bin", j),
+				NodeType:  "file",
+				SizeBytes: &size,
+				MimeType:  &mimeType,
+			})
+		}
+	}
+
+	start := time.Now()
+	if _, err := s.store.CopyNodes(r.Context(), nodes); err != nil {
+		log.Printf("ERROR: Failed to bulk create %d nodes for user %d: %v", len(nodes), owner.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.BulkCreateFailed)
+		return
+	}
+	elapsed := time.Since(start)
+	log.Printf("Bulk created %d nodes (batch %s) for user %d in %s", len(nodes), batch, owner.ID, elapsed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BulkNodesResponse{
+		Batch:      batch,
+		Folders:    req.Folders,
+		Files:      req.Folders * req.FilesPerFolder,
+		DurationMs: elapsed.Milliseconds(),
+	})
+}
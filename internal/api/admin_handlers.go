@@ -0,0 +1,358 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type RepairOrphansRequest struct {
+	NodeIDs []string `json:"node_ids,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+}
+
+type RepairedNode struct {
+	ID               string `json:"id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID          int64  `json:"owner_id" example:"2"`
+	Issue            string `json:"issue" example:"parent_trashed"`
+	Name             string `json:"name" example:"Raport_Q3.docx"`
+	RecoveryFolderID string `json:"recovery_folder_id" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+}
+
+type RepairOrphansResponse struct {
+	Repaired []RepairedNode `json:"repaired"`
+}
+
+// @Summary      List orphaned nodes
+// @Description  Administrative inspection of inconsistent nodes: active nodes whose parent is trashed, is a file or belongs to another user ("parent_trashed", "parent_not_folder", "owner_mismatch"), and trashed nodes whose original parent no longer exists ("missing_original_parent").
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.OrphanedNode
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/nodes/orphans [get]
+func (s *Server) ListOrphanedNodesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	orphans, err := s.store.ListOrphanedNodes(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list orphaned nodes: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.OrphansListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.OrphanedNodes(orphans))
+}
+
+// @Summary      Repair orphaned nodes
+// @Description  Re-parents orphaned nodes into a recovery folder ("Odzyskane") in their owner's root, creating it when needed. Active nodes are moved there (with their ID appended to the name on conflict); trashed nodes get it as their restore target. Without node_ids, up to 1000 orphans are repaired per call.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      RepairOrphansRequest   false  "Orphaned node IDs to repair"
+// @Success      200      {object}  RepairOrphansResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      409      {string}  string "Conflict - The recovery folder name is taken by a trashed node"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/nodes/orphans/repair [post]
+func (s *Server) RepairOrphanedNodesHandler(w http.ResponseWriter, r *http.Request) {
+	var req RepairOrphansRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+			return
+		}
+	}
+	selected := make(map[string]bool, len(req.NodeIDs))
+	for _, id := range req.NodeIDs {
+		selected[id] = true
+	}
+
+	repaired := []RepairedNode{}
+	createdFolders := []*models.Node{}
+	moves := []map[string]interface{}{}
+	moveOwners := []int64{}
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		orphans, err := q.ListOrphanedNodes(r.Context(), MaxLimit, 0)
+		if err != nil {
+			return err
+		}
+
+		recoveryFolders := make(map[int64]*models.Node)
+		for _, orphan := range orphans {
+			if len(selected) > 0 && !selected[orphan.ID] {
+				continue
+			}
+
+			folder, ok := recoveryFolders[orphan.OwnerID]
+			if !ok {
+				newID, err := s.generateUniqueID(r.Context())
+				if err != nil {
+					return err
+				}
+				var created bool
+				folder, created, err = q.GetOrCreateRecoveryFolder(r.Context(), orphan.OwnerID, newID)
+				if err != nil {
+					return err
+				}
+				if created {
+					if err := q.LogEvent(r.Context(), orphan.OwnerID, "node_created", folder); err != nil {
+						return err
+					}
+					createdFolders = append(createdFolders, folder)
+				}
+				recoveryFolders[orphan.OwnerID] = folder
+			}
+
+			node, err := q.ReparentOrphanedNode(r.Context(), orphan.ID, folder.ID)
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				continue
+			}
+
+			if node.DeletedAt == nil {
+				payload := map[string]interface{}{"id": node.ID, "new_parent_id": folder.ID, "old_parent_id": orphan.ParentID}
+				if err := q.LogEvent(r.Context(), node.OwnerID, "node_moved", payload); err != nil {
+					return err
+				}
+				moves = append(moves, payload)
+				moveOwners = append(moveOwners, node.OwnerID)
+			}
+
+			repaired = append(repaired, RepairedNode{
+				ID:               node.ID,
+				OwnerID:          node.OwnerID,
+				Issue:            orphan.Issue,
+				Name:             node.Name,
+				RecoveryFolderID: folder.ID,
+			})
+		}
+		return nil
+	})
+
+	if txErr != nil {
+		var pgErr *pgconn.PgError
+		if errors.Is(txErr, database.ErrDuplicateNodeName) || (errors.As(txErr, &pgErr) && pgErr.Code == "23505") {
+			writeError(w, r, http.StatusConflict, i18n.RecoveryFolderExists)
+			return
+		}
+		log.Printf("ERROR: Failed to repair orphaned nodes: %v", txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.OrphansRepairFailed)
+		return
+	}
+
+	for _, folder := range createdFolders {
+		eventMsg := map[string]interface{}{"event_type": "node_created", "payload": folder}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(folder.OwnerID, eventBytes)
+	}
+	for i, payload := range moves {
+		eventMsg := map[string]interface{}{"event_type": "node_moved", "payload": payload}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(moveOwners[i], eventBytes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RepairOrphansResponse{Repaired: repaired})
+}
+
+// Access check decisions.
+const (
+	accessDecisionNotFound = "not_found"
+	accessDecisionTrashed  = "trashed"
+	accessDecisionOwner    = "owner"
+	accessDecisionShared   = "shared"
+	accessDecisionDenied   = "denied"
+)
+
+type AccessCheckResponse struct {
+	UserID int64  `json:"user_id" example:"3"`
+	NodeID string `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// Decision is "owner", "shared", "denied", "trashed" or "not_found".
+	Decision string `json:"decision" example:"shared"`
+	// Permission is the effective level: "owner", "manage", "write", "read"
+	// or "none".
+	Permission string `json:"permission" example:"write"`
+	Reason     string `json:"reason" example:"Shared with write permission through ancestor Projekty (share 42)"`
+	// MatchedShare is the nearest share granting the effective permission.
+	MatchedShare *dto.NodeAncestor `json:"matched_share,omitempty"`
+	// Path lists the node and its ancestors up to the root, with the user's
+	// share of each.
+	Path []dto.NodeAncestor `json:"path"`
+	// CanRead, CanWrite and CanManage are the results of the access checks
+	// the API uses, reported separately so a mismatch with Decision stands
+	// out.
+	CanRead   bool `json:"can_read" example:"true"`
+	CanWrite  bool `json:"can_write" example:"true"`
+	CanManage bool `json:"can_manage" example:"false"`
+}
+
+// explainAccess derives the decision path from a node's ancestry, mirroring
+// GetNodeIfAccessible for reads, CheckWritePermission for writes and
+// CheckManagePermission for resharing and deleting.
+func explainAccess(userID int64, ancestry []database.NodeAncestor) (decision, permission, reason string, matched *database.NodeAncestor) {
+	if len(ancestry) == 0 {
+		return accessDecisionNotFound, "none", "The node does not exist", nil
+	}
+	node := ancestry[0]
+	if node.Trashed {
+		return accessDecisionTrashed, "none", "The node is in the trash, where only the owner's trash endpoints reach it", nil
+	}
+	if node.OwnerID == userID {
+		return accessDecisionOwner, "owner", "The user owns the node", nil
+	}
+
+	for i := range ancestry {
+		a := &ancestry[i]
+		if a.ShareID == nil {
+			continue
+		}
+		if matched == nil || sharePermissionRanks[*a.Permissions] > sharePermissionRanks[*matched.Permissions] {
+			matched = a
+		}
+	}
+	if matched == nil {
+		return accessDecisionDenied, "none", "The user does not own the node and no share of it or its ancestors was made to them or their groups", nil
+	}
+
+	via := "the node itself"
+	if matched.Depth > 0 {
+		via = fmt.Sprintf("ancestor %s (%d levels up)", matched.Name, matched.Depth)
+	}
+	if matched.GroupID != nil {
+		reason = fmt.Sprintf("Shared with %s permission through %s, share %d with group %d", *matched.Permissions, via, *matched.ShareID, *matched.GroupID)
+	} else {
+		reason = fmt.Sprintf("Shared with %s permission through %s, share %d", *matched.Permissions, via, *matched.ShareID)
+	}
+	if matched.PinnedVersion != nil {
+		reason += fmt.Sprintf(", pinned to version %d", *matched.PinnedVersion)
+	}
+	return accessDecisionShared, *matched.Permissions, reason, matched
+}
+
+// @Summary      Explain a user's access to a node
+// @Description  Administrative debugging of permissions: runs the access checks for a user and a node and returns the decision path — ownership, the node's ancestry with the user's share of each level, which share matched and the effective permission level.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user  query     string  true  "User ID or username"
+// @Param        node  query     string  true  "Node ID"
+// @Success      200   {object}  AccessCheckResponse
+// @Failure      400   {string}  string "Bad Request"
+// @Failure      401   {string}  string "Unauthorized"
+// @Failure      403   {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404   {string}  string "User not found"
+// @Failure      500   {string}  string "Internal Server Error"
+// @Router       /admin/access-check [get]
+func (s *Server) AdminAccessCheckHandler(w http.ResponseWriter, r *http.Request) {
+	userParam := r.URL.Query().Get("user")
+	nodeID := r.URL.Query().Get("node")
+	if userParam == "" || nodeID == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.UserAndNodeRequired)
+		return
+	}
+
+	var user *models.User
+	var err error
+	if userID, parseErr := strconv.ParseInt(userParam, 10, 64); parseErr == nil {
+		user, err = s.store.GetUserByID(r.Context(), userID)
+	} else {
+		user, err = s.store.GetUserByUsername(r.Context(), userParam)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserLookupFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+
+	ancestry, err := s.store.ListNodeAncestry(r.Context(), nodeID, user.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to load ancestry of node %s: %v", nodeID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	readable, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	canWrite, canManage := false, false
+	if len(ancestry) > 0 && !ancestry[0].Trashed {
+		canWrite, err = s.store.CheckWritePermission(r.Context(), user.ID, &nodeID)
+		if err == nil {
+			canManage, err = s.store.CheckManagePermission(r.Context(), user.ID, nodeID)
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+	}
+
+	response := AccessCheckResponse{UserID: user.ID, NodeID: nodeID, Path: dto.NodeAncestors(ancestry), CanRead: readable != nil, CanWrite: canWrite, CanManage: canManage}
+	var matched *database.NodeAncestor
+	response.Decision, response.Permission, response.Reason, matched = explainAccess(user.ID, ancestry)
+	if matched != nil {
+		share := dto.NodeAncestorFrom(*matched)
+		response.MatchedShare = &share
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      Get system statistics
+// @Description  Summarizes the whole server: accounts, files and folders, storage used by live files, the trash and archived versions, the total of quotas and of the storage counted against them, shares, public links, active sessions and pending resumable uploads.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  dto.SystemStats
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /admin/stats [get]
+func (s *Server) GetSystemStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.GetSystemStats(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to get system statistics: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.StatisticsLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.SystemStatsFrom(*stats))
+}
+
+// @Summary      List background jobs
+// @Description  Reports every background job that ran since the server started: its interval, whether it is running now, how many runs and failures it had, and the time, duration and error of its last run.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   JobStatus
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden - Administrator privileges required"
+// @Router       /admin/jobs [get]
+func (s *Server) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.jobs.snapshot())
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminUserResponse struct {
+	models.User
+	// Directory is true for accounts synchronized from LDAP.
+	Directory bool `json:"directory" example:"false"`
+}
+
+type CreateUserRequest struct {
+	Username    string  `json:"username" example:"jkowalski"`
+	Password    string  `json:"password" example:"password123"`
+	DisplayName *string `json:"display_name,omitempty" example:"Jan Kowalski"`
+	// StorageQuotaBytes defaults to 5 GiB.
+	StorageQuotaBytes *int64 `json:"storage_quota_bytes,omitempty" example:"10737418240"`
+}
+
+type UpdateUserRequest struct {
+	StorageQuotaBytes *int64 `json:"storage_quota_bytes,omitempty" example:"10737418240"`
+	Disabled          *bool  `json:"disabled,omitempty" example:"true"`
+}
+
+func adminUser(user models.User) AdminUserResponse {
+	return AdminUserResponse{User: user, Directory: user.LDAPDN != nil}
+}
+
+// @Summary      List users
+// @Description  Lists the accounts ordered by ID, with their storage usage and quota.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   AdminUserResponse
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/users [get]
+func (s *Server) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	users, err := s.store.ListUsers(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list users: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.UsersListFailed)
+		return
+	}
+	response := make([]AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, adminUser(user))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      Create a user
+// @Description  Creates a local account. The password must be at least 8 characters long. The new user receives the onboarding templates like any other new account.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      CreateUserRequest  true  "New account"
+// @Success      201      {object}  AdminUserResponse
+// @Failure      400      {string}  string "Bad Request - Missing username, weak password or negative quota"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      409      {string}  string "Conflict - The username is taken"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/users [post]
+func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.UsernameRequired)
+		return
+	}
+	if len(req.Password) < 8 {
+		writeError(w, r, http.StatusBadRequest, i18n.PasswordTooShort)
+		return
+	}
+	if req.StorageQuotaBytes != nil && *req.StorageQuotaBytes < 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.NegativeStorageQuota)
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PasswordHashFailed)
+		return
+	}
+	user, err := s.store.CreateUser(r.Context(), req.Username, passwordHash, req.DisplayName, req.StorageQuotaBytes)
+	if err != nil {
+		log.Printf("ERROR: Failed to create user %s: %v", req.Username, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.UserCreateFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusConflict, i18n.UsernameTaken)
+		return
+	}
+	log.Printf("User %s (%d) created by user %d", user.Username, user.ID, claims.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(adminUser(*user))
+}
+
+// @Summary      Update a user
+// @Description  Changes the storage quota of an account, or disables or enables it. Disabling ends all sessions of the account at once. Accounts synchronized from LDAP are disabled and enabled by the directory. Administrators cannot disable their own account.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        userId   path      int                true  "User ID"
+// @Param        request  body      UpdateUserRequest  true  "Changes"
+// @Success      200      {object}  AdminUserResponse
+// @Failure      400      {string}  string "Bad Request - Negative quota, or disabling your own account"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "User not found"
+// @Failure      409      {string}  string "Conflict - The account is managed by the LDAP directory"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/users/{userId} [patch]
+func (s *Server) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUserID)
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.StorageQuotaBytes != nil && *req.StorageQuotaBytes < 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.NegativeStorageQuota)
+		return
+	}
+	if req.Disabled != nil && *req.Disabled && userID == claims.UserID {
+		writeError(w, r, http.StatusBadRequest, i18n.DisableOwnAccount)
+		return
+	}
+
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserDataLookupFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+	if req.Disabled != nil && user.LDAPDN != nil {
+		writeError(w, r, http.StatusConflict, i18n.DirectoryManagedAccount)
+		return
+	}
+
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		if req.StorageQuotaBytes != nil {
+			if _, err := q.UpdateUserQuota(r.Context(), userID, *req.StorageQuotaBytes); err != nil {
+				return err
+			}
+		}
+		if req.Disabled == nil {
+			return nil
+		}
+		if !*req.Disabled {
+			_, err := q.EnableUser(r.Context(), userID)
+			return err
+		}
+		if _, err := q.DisableUser(r.Context(), userID); err != nil {
+			return err
+		}
+		return q.DeleteAllSessionsForUser(r.Context(), userID)
+	})
+	if txErr != nil {
+		log.Printf("ERROR: Failed to update user %d: %v", userID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.UserUpdateFailed)
+		return
+	}
+	if req.StorageQuotaBytes != nil {
+		log.Printf("Storage quota of user %d set to %d bytes by user %d", userID, *req.StorageQuotaBytes, claims.UserID)
+	}
+	if req.Disabled != nil {
+		log.Printf("User %d disabled=%t by user %d", userID, *req.Disabled, claims.UserID)
+	}
+
+	user, err = s.store.GetUserByID(r.Context(), userID)
+	if err != nil || user == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserDataLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminUser(*user))
+}
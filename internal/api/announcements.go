@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxAnnouncementLength = 2000
+
+var announcementLevels = map[string]bool{"info": true, "warning": true, "critical": true}
+
+type CreateAnnouncementRequest struct {
+	Message string `json:"message" example:"Przerwa techniczna w sobotę 22:00-23:00"`
+	// Level is "info" (the default), "warning" or "critical".
+	Level string `json:"level,omitempty" example:"warning"`
+	// StartsAt schedules the announcement; it is shown immediately when omitted.
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	// EndsAt hides the announcement afterwards; it stays until removed when
+	// omitted.
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+// publishDueAnnouncements pushes announcements that have become active to
+// every connected client as "announcement_published" events. Each one is
+// pushed once; clients that connect later read GET /announcements.
+func (s *Server) publishDueAnnouncements(ctx context.Context) error {
+	announcements, err := s.store.ClaimDueAnnouncements(ctx)
+	if err != nil {
+		return err
+	}
+	for _, announcement := range announcements {
+		eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "announcement_published", "payload": dto.AnnouncementFrom(announcement)})
+		s.wsHub.PublishAll(eventBytes)
+	}
+	return nil
+}
+
+// @Summary      List active announcements
+// @Description  Returns the system announcements shown right now (maintenance windows, policy changes), the most severe first. It needs no authentication so clients can show it on the login screen. New announcements are also pushed over WebSocket as "announcement_published" events when they become active, and removals as "announcement_removed".
+// @Tags         announcements
+// @Produce      json
+// @Success      200  {array}   dto.Announcement
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /announcements [get]
+func (s *Server) ListActiveAnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	announcements, err := s.store.ListActiveAnnouncements(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list active announcements: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.AnnouncementsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.Announcements(announcements))
+}
+
+// @Summary      List all announcements
+// @Description  Lists all announcements, including scheduled and expired ones, newest first.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.Announcement
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/announcements [get]
+func (s *Server) ListAnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	announcements, err := s.store.ListAnnouncements(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list announcements: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.AnnouncementsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.Announcements(announcements))
+}
+
+// @Summary      Create an announcement
+// @Description  Creates a system announcement. One that starts right away is pushed to connected clients immediately, a scheduled one within a minute of its start.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        announcement  body      CreateAnnouncementRequest  true  "Announcement"
+// @Success      201           {object}  dto.Announcement
+// @Failure      400           {string}  string "Bad Request - Empty or too long message, unknown level or invalid time window"
+// @Failure      401           {string}  string "Unauthorized"
+// @Failure      403           {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500           {string}  string "Internal Server Error"
+// @Router       /admin/announcements [post]
+func (s *Server) CreateAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req CreateAnnouncementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" || len([]rune(req.Message)) > maxAnnouncementLength {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidAnnouncementLength, maxAnnouncementLength)
+		return
+	}
+	if req.Level == "" {
+		req.Level = "info"
+	}
+	if !announcementLevels[req.Level] {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidAnnouncementLevel)
+		return
+	}
+	if req.EndsAt != nil {
+		start := time.Now()
+		if req.StartsAt != nil {
+			start = *req.StartsAt
+		}
+		if !req.EndsAt.After(start) {
+			writeError(w, r, http.StatusBadRequest, i18n.AnnouncementEndsBeforeStart)
+			return
+		}
+	}
+
+	announcement, err := s.store.CreateAnnouncement(r.Context(), database.CreateAnnouncementParams{
+		Message:   req.Message,
+		Level:     req.Level,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		CreatedBy: claims.UserID,
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to create announcement: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.AnnouncementCreateFailed)
+		return
+	}
+	if err := s.publishDueAnnouncements(r.Context()); err != nil {
+		log.Printf("ERROR: Failed to publish announcement %d: %v", announcement.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.AnnouncementFrom(*announcement))
+}
+
+// @Summary      Remove an announcement
+// @Description  Removes an announcement and tells connected clients with an "announcement_removed" event.
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        announcementId  path      int     true  "Announcement ID"
+// @Success      204             {null}    nil     "No Content"
+// @Failure      400             {string}  string "Bad Request - Invalid announcement ID"
+// @Failure      401             {string}  string "Unauthorized"
+// @Failure      403             {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404             {string}  string "Announcement not found"
+// @Failure      500             {string}  string "Internal Server Error"
+// @Router       /admin/announcements/{announcementId} [delete]
+func (s *Server) DeleteAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "announcementId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidAnnouncementID)
+		return
+	}
+
+	deleted, err := s.store.DeleteAnnouncement(r.Context(), id)
+	if err != nil {
+		log.Printf("ERROR: Failed to remove announcement %d: %v", id, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.AnnouncementRemoveFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.AnnouncementNotFound)
+		return
+	}
+
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "announcement_removed", "payload": map[string]int64{"id": id}})
+	s.wsHub.PublishAll(eventBytes)
+	w.WriteHeader(http.StatusNoContent)
+}
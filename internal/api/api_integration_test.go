@@ -1,22 +1,54 @@
 package api
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/chaos"
+	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/contentpolicy"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/delta"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/email"
+	"serwer-plikow/internal/features"
+	"serwer-plikow/internal/federation"
+	"serwer-plikow/internal/geoip"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/ldap"
 	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
 
@@ -245,7 +277,7 @@ func TestUploadFileHandler(t *testing.T) {
 	require.Equal(t, "testfile.txt", uploadedNode.Name)
 	require.Equal(t, int64(len(fileContent)), *uploadedNode.SizeBytes)
 
-	_, err = testServer.storage.Get(uploadedNode.ID)
+	_, err = testServer.openNodeContent(context.Background(), uploadedNode.ID)
 	require.NoError(t, err, "File should exist in storage after upload")
 }
 
@@ -452,7 +484,7 @@ func TestShareAndFavorite_Integration(t *testing.T) {
 		router.ServeHTTP(rr, req)
 
 		require.Equal(t, http.StatusCreated, rr.Code)
-		var shareResp ShareResponse
+		var shareResp dto.Share
 		err := json.Unmarshal(rr.Body.Bytes(), &shareResp)
 		require.NoError(t, err)
 		require.Equal(t, nodeToShare.ID, shareResp.NodeID)
@@ -638,12 +670,14 @@ func TestGetEventsHandler_Integration(t *testing.T) {
 	router.ServeHTTP(rrAll, reqAll)
 
 	require.Equal(t, http.StatusOK, rrAll.Code)
-	var events []database.Event
-	err := json.Unmarshal(rrAll.Body.Bytes(), &events)
+	var page EventsPageResponse
+	err := json.Unmarshal(rrAll.Body.Bytes(), &page)
 	require.NoError(t, err)
-	require.GreaterOrEqual(t, len(events), 1, "At least one event should be returned")
+	require.GreaterOrEqual(t, len(page.Events), 1, "At least one event should be returned")
+	require.False(t, page.HasMore)
 
-	lastEventID := events[len(events)-1].ID
+	lastEventID := page.Events[len(page.Events)-1].ID
+	require.Equal(t, lastEventID, page.NextSince)
 
 	urlSince := fmt.Sprintf("/api/v1/events?since=%d", lastEventID)
 	reqSince := httptest.NewRequest("GET", urlSince, nil)
@@ -652,10 +686,42 @@ func TestGetEventsHandler_Integration(t *testing.T) {
 	router.ServeHTTP(rrSince, reqSince)
 
 	require.Equal(t, http.StatusOK, rrSince.Code)
-	var noEvents []database.Event
+	var noEvents EventsPageResponse
 	err = json.Unmarshal(rrSince.Body.Bytes(), &noEvents)
 	require.NoError(t, err)
-	require.Len(t, noEvents, 0, "There should be no new events since the last known ID")
+	require.Len(t, noEvents.Events, 0, "There should be no new events since the last known ID")
+	require.Equal(t, lastEventID, noEvents.NextSince, "The cursor should not move without new events")
+
+	for i := 0; i < 2; i++ {
+		body, _ := json.Marshal(CreateFolderRequest{Name: fmt.Sprintf("EventPage%d", i)})
+		req := httptest.NewRequest("POST", "/api/v1/nodes/folder", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+loginResp.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code)
+	}
+
+	since := lastEventID
+	var paged []EventResponse
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5, "Paging should terminate")
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/events?since=%d&limit=1", since), nil)
+		req.Header.Set("Authorization", "Bearer "+loginResp.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page EventsPageResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		require.Equal(t, 1, page.Limit)
+		require.LessOrEqual(t, len(page.Events), 1)
+		paged = append(paged, page.Events...)
+		since = page.NextSince
+		if !page.HasMore {
+			break
+		}
+	}
+	require.Len(t, paged, 2, "Both new events should be delivered one page at a time")
 }
 
 func TestHealthCheckHandler(t *testing.T) {
@@ -777,3 +843,5345 @@ func TestDownloadArchiveHandler(t *testing.T) {
 	require.True(t, foundFiles["plik2.txt"], "Expected to find root file plik2.txt")
 	require.Len(t, foundFiles, 3, "Archive should contain exactly 3 entries")
 }
+
+func TestAnonymizeIP(t *testing.T) {
+	require.Equal(t, "192.168.10.0", anonymizeIP("192.168.10.77"))
+	require.Equal(t, "2001:db8:abcd::", anonymizeIP("2001:db8:abcd:12:1:2:3:4"))
+	require.Equal(t, "", anonymizeIP("not-an-ip"))
+}
+
+func TestAccessLogRecordedOnDownload(t *testing.T) {
+	owner := createTestUserWithPassword(t, "access_log_api_owner", "password")
+	ownerLogin := loginUserForTest(t, "access_log_api_owner", "password")
+
+	fileNode := createTestNodeAPI(t, "logged_download.txt", "file", nil, owner.ID)
+	err := testServer.storage.Save(fileNode.ID, strings.NewReader("content"))
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	router.Get("/api/v1/nodes/{nodeId}/access-log", testServer.ListNodeAccessLogHandler)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/download", fileNode.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/access-log", fileNode.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var entries []dto.AccessLogEntry
+	err = json.Unmarshal(rr.Body.Bytes(), &entries)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "download", entries[0].Action)
+}
+
+func TestContentDeltaUpdate(t *testing.T) {
+	owner := createTestUserWithPassword(t, "delta_owner", "password")
+	ownerLogin := loginUserForTest(t, "delta_owner", "password")
+
+	base := bytes.Repeat([]byte("0123456789abcdef"), 2048)
+	fileNode := createTestNodeAPI(t, "delta.bin", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, bytes.NewReader(base)))
+	fileNode, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, owner.ID, int64(len(base)), nil, nil)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/signature", testServer.GetFileSignatureHandler)
+	router.Put("/api/v1/nodes/{nodeId}/content/delta", testServer.ApplyContentDeltaHandler)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/signature?block_size=%d", fileNode.ID, delta.MinBlockSize), nil)
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	var signature delta.Signature
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &signature))
+	require.Equal(t, int64(len(base)), signature.FileSize)
+
+	updated := append([]byte("header line\n"), base...)
+	updated = append(updated, []byte("trailer")...)
+	var patch bytes.Buffer
+	require.NoError(t, delta.WriteDelta(&signature, updated, &patch))
+	require.Less(t, patch.Len(), len(updated)/4)
+
+	t.Run("stale version is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/v1/nodes/%s/content/delta?block_size=%d", fileNode.ID, delta.MinBlockSize), bytes.NewReader(patch.Bytes()))
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		req.Header.Set("If-Match", `"stale"`)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	})
+
+	t.Run("malformed patch is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/v1/nodes/%s/content/delta?block_size=%d", fileNode.ID, delta.MinBlockSize), strings.NewReader("garbage"))
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	req = httptest.NewRequest("PUT", fmt.Sprintf("/api/v1/nodes/%s/content/delta?block_size=%d", fileNode.ID, delta.MinBlockSize), bytes.NewReader(patch.Bytes()))
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	req.Header.Set("If-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var updatedNode models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updatedNode))
+	require.Equal(t, int64(len(updated)), *updatedNode.SizeBytes)
+
+	stream, err := testServer.openNodeContent(context.Background(), fileNode.ID)
+	require.NoError(t, err)
+	defer stream.Close()
+	content, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, updated, content)
+}
+
+func TestAssembleUploadSession(t *testing.T) {
+	location, err := testServer.storage.CreateUploadArea(uuid.NewString())
+	require.NoError(t, err)
+	defer testServer.storage.DeleteUploadArea(location)
+
+	chunks := []string{"first chunk|", "second chunk|", "third"}
+	session := &models.UploadSession{TempLocation: location}
+	for _, data := range chunks {
+		_, err := testServer.storage.SaveChunk(location, session.TotalSize, strings.NewReader(data))
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte(data))
+		checksum := hex.EncodeToString(sum[:])
+		session.Chunks = append(session.Chunks, models.UploadChunk{Offset: session.TotalSize, SizeBytes: int64(len(data)), Checksum: &checksum})
+		session.TotalSize += int64(len(data))
+	}
+	fullSum := sha256.Sum256([]byte(strings.Join(chunks, "")))
+	expected := hex.EncodeToString(fullSum[:])
+	session.ExpectedSHA256 = &expected
+
+	stagedID, fileHash, err := testServer.assembleUploadSession(context.Background(), session)
+	require.NoError(t, err)
+	require.Equal(t, expected, fileHash)
+	testServer.storage.Delete(stagedID)
+
+	t.Run("corrupt chunks are reported by index", func(t *testing.T) {
+		_, err := testServer.storage.SaveChunk(location, session.Chunks[1].Offset, strings.NewReader("SECOND CHUNK|"))
+		require.NoError(t, err)
+
+		_, _, err = testServer.assembleUploadSession(context.Background(), session)
+		var verificationErr *ChunkVerificationError
+		require.ErrorAs(t, err, &verificationErr)
+		require.Equal(t, []int{1}, verificationErr.CorruptChunks)
+	})
+
+	t.Run("missing ranges are rejected", func(t *testing.T) {
+		incomplete := *session
+		incomplete.Chunks = []models.UploadChunk{session.Chunks[0], session.Chunks[2]}
+		_, _, err := testServer.assembleUploadSession(context.Background(), &incomplete)
+		require.ErrorIs(t, err, errUploadIncomplete)
+	})
+}
+
+func TestComputeFolderDiff(t *testing.T) {
+	folderID := "folder_diff_folder_id"
+	otherID := "folder_diff_other___id"
+	event := func(eventType string, payload interface{}) database.Event {
+		raw, _ := json.Marshal(payload)
+		return database.Event{EventType: eventType, Payload: raw}
+	}
+
+	events := []database.Event{
+		event("node_created", models.Node{ID: "added", ParentID: &folderID, Name: "new.txt"}),
+		event("node_renamed", map[string]interface{}{"id": "renamed", "old_name": "a.txt", "new_name": "b.txt"}),
+		event("node_moved", map[string]interface{}{"id": "moved_out", "old_parent_id": folderID, "new_parent_id": otherID}),
+		event("node_trashed", map[string]string{"id": "trashed", "parent_id": folderID}),
+		event("node_created", models.Node{ID: "transient", ParentID: &folderID, Name: "tmp"}),
+		event("node_trashed", map[string]string{"id": "transient", "parent_id": folderID}),
+		event("node_renamed", map[string]interface{}{"id": "elsewhere", "old_name": "x", "new_name": "y"}),
+		event("node_moved", map[string]interface{}{"id": "moved_in", "old_parent_id": otherID, "new_parent_id": folderID}),
+	}
+	current := []models.Node{
+		{ID: "added", ParentID: &folderID, Name: "new.txt"},
+		{ID: "renamed", ParentID: &folderID, Name: "b.txt"},
+		{ID: "moved_in", ParentID: &folderID, Name: "in.txt"},
+	}
+
+	diff := computeFolderDiff(folderID, events, current)
+
+	require.Len(t, diff.Added, 2)
+	require.Equal(t, "added", diff.Added[0].ID)
+	require.Equal(t, "moved_in", diff.Added[1].ID)
+	require.Equal(t, []string{"moved_out", "trashed"}, diff.Removed)
+	require.Equal(t, []RenamedNode{{ID: "renamed", OldName: "a.txt", NewName: "b.txt"}}, diff.Renamed)
+}
+
+func TestDeleteShareInvalidatesRecipientSubtreeState(t *testing.T) {
+	sharer := createTestUserWithPassword(t, "revoke_cascade_sharer", "password")
+	recipient := createTestUserWithPassword(t, "revoke_cascade_recipient", "password")
+	sharerLogin := loginUserForTest(t, "revoke_cascade_sharer", "password")
+
+	folder := createTestNodeAPI(t, "Revoked", "folder", nil, sharer.ID)
+	subfolder := createTestNodeAPI(t, "Nested", "folder", &folder.ID, sharer.ID)
+	share, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: folder.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "write",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, testServer.store.WatchNode(context.Background(), recipient.ID, subfolder.ID, "daily"))
+
+	favorite := createTestNodeAPI(t, "ulubiony.txt", "file", &subfolder.ID, sharer.ID)
+	stillShared := createTestNodeAPI(t, "nadal.txt", "file", &folder.ID, sharer.ID)
+	_, err = testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: stillShared.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "manage",
+	})
+	require.NoError(t, err)
+	require.NoError(t, testServer.store.AddFavorite(context.Background(), recipient.ID, favorite.ID))
+	require.NoError(t, testServer.store.AddFavorite(context.Background(), recipient.ID, stillShared.ID))
+
+	sessionID := uuid.New()
+	location, err := testServer.storage.CreateUploadArea(sessionID.String())
+	require.NoError(t, err)
+	_, err = testServer.storage.SaveChunk(location, 0, strings.NewReader("chunk"))
+	require.NoError(t, err)
+	_, err = testServer.store.CreateUploadSession(context.Background(), database.CreateUploadSessionParams{
+		ID: sessionID, UserID: recipient.ID, OwnerID: sharer.ID, ParentID: &subfolder.ID,
+		FileName: "pending.bin", TotalSize: 10, TempLocation: location, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Delete("/api/v1/shares/{shareId}", testServer.DeleteShareHandler)
+
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/v1/shares/%d", share.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+sharerLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	stillWatched, err := testServer.store.UnwatchNode(context.Background(), recipient.ID, subfolder.ID)
+	require.NoError(t, err)
+	require.False(t, stillWatched, "The recipient's watch inside the revoked subtree should be removed")
+
+	exists, err := testServer.store.UploadSessionExists(context.Background(), sessionID)
+	require.NoError(t, err)
+	require.False(t, exists, "The recipient's pending upload into the revoked subtree should be cancelled")
+
+	_, err = testServer.storage.OpenChunk(location, 0)
+	require.Error(t, err)
+
+	events, err := testServer.store.GetEventsSince(context.Background(), recipient.ID, 0, MaxLimit)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	require.Equal(t, "share_revoked_for_you", last.EventType)
+	require.Contains(t, string(last.Payload), subfolder.ID)
+	require.Contains(t, string(last.Payload), sessionID.String())
+	var payload struct {
+		Invalidated revokedSubtreeState `json:"invalidated"`
+	}
+	require.NoError(t, json.Unmarshal(last.Payload, &payload))
+	require.Equal(t, []string{favorite.ID}, payload.Invalidated.Favorites)
+
+	favorites, err := testServer.store.ListFavorites(context.Background(), recipient.ID, MaxLimit, 0)
+	require.NoError(t, err)
+	require.Len(t, favorites, 1, "A favorite still reachable through another share is kept")
+	require.Equal(t, stillShared.ID, favorites[0].ID)
+}
+
+func TestFolderPlacementLimits(t *testing.T) {
+	owner := createTestUserWithPassword(t, "limits_owner", "password")
+	ownerLogin := loginUserForTest(t, "limits_owner", "password")
+
+	testServer.config.Load().Limits = config.LimitsConfig{MaxFolderDepth: 2, MaxChildrenPerFolder: 1}
+	defer func() { testServer.config.Load().Limits = config.LimitsConfig{} }()
+
+	top := createTestNodeAPI(t, "Top", "folder", nil, owner.ID)
+	nested := createTestNodeAPI(t, "Nested", "folder", &top.ID, owner.ID)
+	sibling := createTestNodeAPI(t, "Sibling", "folder", nil, owner.ID)
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/capabilities", testServer.GetCapabilitiesHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Post("/api/v1/nodes/folder", testServer.CreateFolderHandler)
+		r.Patch("/api/v1/nodes/{nodeId}", testServer.UpdateNodeHandler)
+	})
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/api/v1/capabilities", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var caps CapabilitiesResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &caps))
+	require.Equal(t, 2, caps.MaxFolderDepth)
+	require.Equal(t, int64(1), caps.MaxChildrenPerFolder)
+
+	rr = do("POST", "/api/v1/nodes/folder", fmt.Sprintf(`{"name":"TooDeep","parent_id":"%s"}`, nested.ID))
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, "Creating a third level must be rejected")
+
+	rr = do("PATCH", "/api/v1/nodes/"+top.ID, fmt.Sprintf(`{"parent_id":"%s"}`, sibling.ID))
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, "Moving a two level folder below another folder must be rejected")
+
+	rr = do("POST", "/api/v1/nodes/folder", fmt.Sprintf(`{"name":"Second","parent_id":"%s"}`, top.ID))
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, "A folder already holding the maximum number of items must reject new ones")
+
+	rr = do("POST", "/api/v1/nodes/folder", fmt.Sprintf(`{"name":"First","parent_id":"%s"}`, sibling.ID))
+	require.Equal(t, http.StatusCreated, rr.Code)
+}
+
+func TestCORSOriginValidation(t *testing.T) {
+	handler := CORSMiddleware(config.CORSConfig{
+		Environment:    CORSEnvironmentProduction,
+		AllowedOrigins: []string{"https://files.example.com", "https://*.example.org", "https://*", "*"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/api/v1/me", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	allowed := []string{"https://files.example.com", "https://app.example.org", "https://a.b.example.org"}
+	for _, origin := range allowed {
+		rr := preflight(origin)
+		require.Equal(t, origin, rr.Header().Get("Access-Control-Allow-Origin"), "Origin %s should be allowed", origin)
+		require.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	}
+
+	rejected := []string{
+		"https://evil.com",
+		"http://files.example.com",
+		"https://files.example.com.evil.com",
+		"https://example.org",
+		"https://evilexample.org",
+		"http://localhost:3000",
+		"null",
+	}
+	for _, origin := range rejected {
+		rr := preflight(origin)
+		require.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), "Origin %s should be rejected", origin)
+	}
+
+	t.Run("development preset allows localhost", func(t *testing.T) {
+		validate := NewOriginValidator(config.CORSConfig{Environment: CORSEnvironmentDevelopment})
+		require.True(t, validate(nil, "http://localhost:3000"))
+		require.True(t, validate(nil, "http://127.0.0.1:5173"))
+		require.False(t, validate(nil, "http://localhost.evil.com"))
+		require.False(t, validate(nil, "https://evil.com"))
+	})
+}
+
+func TestRefreshTokenReuseRevokesSessionFamily(t *testing.T) {
+	user := createTestUserWithPassword(t, "user_for_reuse_test", "password")
+	stolen := loginUserForTest(t, "user_for_reuse_test", "password")
+	otherDevice := loginUserForTest(t, "user_for_reuse_test", "password")
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(RefreshTokenRequest{RefreshToken: token})
+		req := httptest.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(testServer.RefreshTokenHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := refresh(stolen.RefreshToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var rotated TokenResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rotated))
+
+	rr = refresh(stolen.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rr.Code, "Replaying a rotated token must fail")
+
+	rr = refresh(rotated.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rr.Code, "The whole session family must be revoked after a reuse")
+
+	rr = refresh(otherDevice.RefreshToken)
+	require.Equal(t, http.StatusOK, rr.Code, "Sessions from other logins must survive")
+
+	var reuseEvents int
+	err := testServer.store.GetPool().QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM event_journal WHERE user_id = $1 AND event_type = 'session_reuse_detected'", user.ID).Scan(&reuseEvents)
+	require.NoError(t, err)
+	require.Equal(t, 1, reuseEvents)
+}
+
+func TestLogoutInvalidatesTokenPair(t *testing.T) {
+	createTestUserWithPassword(t, "user_for_logout", "password")
+	login := loginUserForTest(t, "user_for_logout", "password")
+	otherDevice := loginUserForTest(t, "user_for_logout", "password")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/auth/logout", testServer.LogoutHandler)
+	router.Get("/api/v1/sessions", testServer.ListSessionsHandler)
+
+	call := func(method, url, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, call("GET", "/api/v1/sessions", login.AccessToken, "").Code)
+
+	rr := call("POST", "/api/v1/auth/logout", login.AccessToken, fmt.Sprintf(`{"refresh_token":"%s"}`, login.RefreshToken))
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	require.Equal(t, http.StatusUnauthorized, call("GET", "/api/v1/sessions", login.AccessToken, "").Code, "The access token must be rejected after logout")
+
+	body, _ := json.Marshal(RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	req := httptest.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewReader(body))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(testServer.RefreshTokenHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code, "The refresh token must be rejected after logout")
+
+	require.Equal(t, http.StatusOK, call("GET", "/api/v1/sessions", otherDevice.AccessToken, "").Code, "Other sessions must stay logged in")
+}
+
+// replaceTestContent stages content and commits it as the new version of a
+// file, the same way content updates do.
+func replaceTestContent(t *testing.T, node *models.Node, actorID int64, content string) *models.Node {
+	stagedID, err := testServer.generateUniqueID(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, testServer.storage.Save(stagedID, strings.NewReader(content)))
+	contentSHA256, err := hashContent(strings.NewReader(content))
+	require.NoError(t, err)
+	updated, err := testServer.commitReplacedContent(context.Background(), actorID, node, stagedID, int64(len(content)), nil, contentSHA256, nil)
+	require.NoError(t, err)
+	return updated
+}
+
+func TestVersionPinnedShare(t *testing.T) {
+	owner := createTestUserWithPassword(t, "pinned_share_owner", "password")
+	createTestUserWithPassword(t, "pinned_share_recipient", "password")
+	ownerLogin := loginUserForTest(t, "pinned_share_owner", "password")
+	recipientLogin := loginUserForTest(t, "pinned_share_recipient", "password")
+
+	fileNode := createTestNodeAPI(t, "raport.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("wersja 1")))
+	fileNode, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, owner.ID, int64(len("wersja 1")), nil, nil)
+	require.NoError(t, err)
+	fileNode = replaceTestContent(t, fileNode, owner.ID, "wersja druga")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/share", testServer.ShareNodeHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	router.Get("/api/v1/nodes/{nodeId}/versions", testServer.ListNodeVersionsHandler)
+	router.Get("/api/v1/shares/incoming/nodes", testServer.ListSharedNodesHandler)
+
+	share := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/nodes/%s/share", fileNode.ID), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(fmt.Sprintf("/api/v1/nodes/%s/versions", fileNode.ID), ownerLogin.AccessToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var versions []NodeVersionResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &versions))
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.True(t, versions[0].Current)
+	require.Equal(t, 1, versions[1].Version)
+	require.Equal(t, int64(len("wersja 1")), versions[1].SizeBytes)
+
+	require.Equal(t, http.StatusNotFound, share(`{"recipient_username":"pinned_share_recipient","permissions":"read","version":7}`).Code)
+	require.Equal(t, http.StatusBadRequest, share(`{"recipient_username":"pinned_share_recipient","permissions":"write","version":1}`).Code)
+
+	rr = share(`{"recipient_username":"pinned_share_recipient","permissions":"read","version":1}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created models.Share
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.NotNil(t, created.PinnedVersion)
+	require.Equal(t, 1, *created.PinnedVersion)
+
+	fileNode = replaceTestContent(t, fileNode, owner.ID, "wersja trzecia")
+
+	rr = get(fmt.Sprintf("/api/v1/nodes/%s/download", fileNode.ID), recipientLogin.AccessToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "wersja 1", rr.Body.String(), "Later edits must not change what the recipient sees")
+
+	rr = get(fmt.Sprintf("/api/v1/nodes/%s/download", fileNode.ID), ownerLogin.AccessToken)
+	require.Equal(t, "wersja trzecia", rr.Body.String())
+
+	rr = get("/api/v1/shares/incoming/nodes?sharer_username=pinned_share_owner", recipientLogin.AccessToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var shared []database.SharedNode
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shared))
+	require.Len(t, shared, 1)
+	require.NotNil(t, shared[0].PinnedVersion)
+	require.Equal(t, 1, *shared[0].PinnedVersion)
+	require.Equal(t, int64(len("wersja 1")), *shared[0].SizeBytes)
+
+	require.Equal(t, http.StatusForbidden, get(fmt.Sprintf("/api/v1/nodes/%s/versions", fileNode.ID), recipientLogin.AccessToken).Code)
+}
+
+func TestVersionDiff(t *testing.T) {
+	owner := createTestUserWithPassword(t, "version_diff_owner", "password")
+	ownerLogin := loginUserForTest(t, "version_diff_owner", "password")
+
+	fileNode := createTestNodeAPI(t, "notatki.md", "file", nil, owner.ID)
+	original := "tytuł\nstara linia\nkoniec\n"
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader(original)))
+	fileNode, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, owner.ID, int64(len(original)), nil, nil)
+	require.NoError(t, err)
+	fileNode = replaceTestContent(t, fileNode, owner.ID, "tytuł\nnowa linia\nkoniec\n")
+	replaceTestContent(t, fileNode, owner.ID, "binarny\x00plik")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/versions/diff", testServer.GetVersionDiffHandler)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/versions/diff?%s", fileNode.ID, query), nil)
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("from=1&to=2")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var res VersionDiffResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	require.False(t, res.Truncated)
+	require.Equal(t, "--- notatki.md (v1)\n+++ notatki.md (v2)\n@@ -1,3 +1,3 @@\n tytuł\n-stara linia\n+nowa linia\n koniec\n", res.Diff)
+
+	require.Equal(t, http.StatusUnprocessableEntity, get("from=2&to=3").Code)
+	require.Equal(t, http.StatusNotFound, get("from=1&to=9").Code)
+	require.Equal(t, http.StatusBadRequest, get("from=abc&to=2").Code)
+}
+
+func TestTruncateDiff(t *testing.T) {
+	require.Equal(t, "a\nb\n", truncateDiff("a\nb\nc\n", 5))
+	require.Equal(t, "short\n", truncateDiff("short\n", 10))
+	require.Equal(t, "abcd", truncateDiff("abcdefgh", 4), "A single long line is cut mid-line")
+	require.Equal(t, "ab", truncateDiff("abłęd", 3), "The cut backs off to a rune boundary")
+}
+
+func TestVersionRetentionPolicy(t *testing.T) {
+	owner := createTestUserWithPassword(t, "version_policy_owner", "password")
+	recipient := createTestUserWithPassword(t, "version_policy_recipient", "password")
+	ownerLogin := loginUserForTest(t, "version_policy_owner", "password")
+
+	fileNode := createTestNodeAPI(t, "budzet.csv", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("v1")))
+	fileNode, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, owner.ID, 2, nil, nil)
+	require.NoError(t, err)
+	for _, content := range []string{"v2", "v3", "v4"} {
+		fileNode = replaceTestContent(t, fileNode, owner.ID, content)
+	}
+
+	pinnedVersion := 1
+	_, err = testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: fileNode.ID, SharerID: owner.ID, RecipientID: recipient.ID, Permissions: "read", PinnedVersion: &pinnedVersion,
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Put("/api/v1/me/versions/policy", testServer.UpdateVersionPolicyHandler)
+	router.Get("/api/v1/me/storage", testServer.GetStorageUsageHandler)
+
+	req := httptest.NewRequest("PUT", "/api/v1/me/versions/policy", strings.NewReader(`{"max_versions_per_file":0}`))
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req = httptest.NewRequest("PUT", "/api/v1/me/versions/policy", strings.NewReader(`{"max_versions_per_file":1}`))
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var policy VersionPolicyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &policy))
+	require.Equal(t, 1, policy.Effective.MaxVersionsPerFile)
+
+	versions, err := testServer.store.ListNodeVersions(context.Background(), fileNode.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2, "Only the newest archived version and the pinned one should be kept")
+	require.Equal(t, 3, versions[0].Version)
+	require.Equal(t, 1, versions[1].Version)
+
+	req = httptest.NewRequest("GET", "/api/v1/me/storage", nil)
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var usage StorageUsageResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &usage))
+	require.Equal(t, int64(2), usage.Versions.Count)
+	require.Equal(t, int64(4), usage.Versions.UsedBytes)
+	require.Equal(t, 1, usage.Versions.Policy.MaxVersionsPerFile)
+}
+
+func TestEffectiveVersionPolicy(t *testing.T) {
+	global := config.VersionsConfig{MaxPerFile: 50, MaxAgeDays: 365}
+	require.Equal(t, EffectiveVersionPolicy{MaxVersionsPerFile: 50, MaxAgeDays: 365}, effectiveVersionPolicy(global, nil))
+
+	perFile, maxBytes, maxAge := 100, int64(1024), 30
+	user := &database.VersionPolicy{MaxVersionsPerFile: &perFile, MaxBytes: &maxBytes, MaxAgeDays: &maxAge}
+	require.Equal(t, EffectiveVersionPolicy{MaxVersionsPerFile: 50, MaxBytes: 1024, MaxAgeDays: 30}, effectiveVersionPolicy(global, user))
+}
+
+func TestUndoOperations(t *testing.T) {
+	user := createTestUserWithPassword(t, "undo_user", "password")
+	login := loginUserForTest(t, "undo_user", "password")
+
+	folder := createTestNodeAPI(t, "Projekty", "folder", nil, user.ID)
+	file := createTestNodeAPI(t, "plan.txt", "file", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Patch("/api/v1/nodes/{nodeId}", testServer.UpdateNodeHandler)
+	router.Delete("/api/v1/nodes/{nodeId}", testServer.DeleteNodeHandler)
+	router.Post("/api/v1/undo/{token}", testServer.UndoHandler)
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call("PATCH", "/api/v1/nodes/"+file.ID, fmt.Sprintf(`{"name":"plan-v2.txt","parent_id":"%s"}`, folder.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	token := rr.Header().Get("X-Undo-Token")
+	require.NotEmpty(t, token)
+	require.NotEmpty(t, rr.Header().Get("X-Undo-Expires-At"))
+
+	rr = call("POST", "/api/v1/undo/"+token, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var restored models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &restored))
+	require.Equal(t, "plan.txt", restored.Name)
+	require.Nil(t, restored.ParentID)
+
+	require.Equal(t, http.StatusNotFound, call("POST", "/api/v1/undo/"+token, "").Code, "Undo tokens are single-use")
+
+	rr = call("PATCH", "/api/v1/nodes/"+file.ID, `{"name":"plan-v3.txt"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	staleToken := rr.Header().Get("X-Undo-Token")
+	require.Equal(t, http.StatusOK, call("PATCH", "/api/v1/nodes/"+file.ID, `{"name":"plan-v4.txt"}`).Code)
+	require.Equal(t, http.StatusConflict, call("POST", "/api/v1/undo/"+staleToken, "").Code, "A node changed after the operation must not be reverted")
+
+	rr = call("DELETE", "/api/v1/nodes/"+folder.ID, "")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	token = rr.Header().Get("X-Undo-Token")
+	require.NotEmpty(t, token)
+
+	rr = call("POST", "/api/v1/undo/"+token, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &restored))
+	require.Equal(t, folder.ID, restored.ID)
+	require.Nil(t, restored.DeletedAt)
+}
+
+func TestClipboardPaste(t *testing.T) {
+	user := createTestUserWithPassword(t, "clipboard_user", "password")
+	login := loginUserForTest(t, "clipboard_user", "password")
+
+	source := createTestNodeAPI(t, "Zrodlo", "folder", nil, user.ID)
+	target := createTestNodeAPI(t, "Cel", "folder", nil, user.ID)
+	file := createTestNodeAPI(t, "notatka.txt", "file", &source.ID, user.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("tresc")))
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/clipboard", testServer.GetClipboardHandler)
+	router.Post("/api/v1/clipboard", testServer.SetClipboardHandler)
+	router.Post("/api/v1/clipboard/paste", testServer.PasteClipboardHandler)
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, call("POST", "/api/v1/clipboard", fmt.Sprintf(`{"operation":"move","node_ids":["%s"]}`, source.ID)).Code)
+
+	rr := call("POST", "/api/v1/clipboard", fmt.Sprintf(`{"operation":"copy","node_ids":["%s"]}`, source.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = call("POST", "/api/v1/clipboard/paste", fmt.Sprintf(`{"parent_id":"%s"}`, target.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var pasted PasteResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pasted))
+	require.Empty(t, pasted.Failed)
+	require.Len(t, pasted.Pasted, 1)
+	copiedFolder := pasted.Pasted[0]
+	require.NotEqual(t, source.ID, copiedFolder.ID)
+	require.Equal(t, target.ID, *copiedFolder.ParentID)
+
+	copiedChildren, err := testServer.store.GetNodesByParentID(context.Background(), user.ID, &copiedFolder.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, copiedChildren, 1)
+	require.NotEqual(t, file.ID, copiedChildren[0].ID)
+	blob, err := testServer.openNodeContent(context.Background(), copiedChildren[0].ID)
+	require.NoError(t, err)
+	content, _ := io.ReadAll(blob)
+	blob.Close()
+	require.Equal(t, "tresc", string(content))
+
+	require.Equal(t, http.StatusOK, call("GET", "/api/v1/clipboard", "").Code, "A copy keeps the clipboard")
+
+	rr = call("POST", "/api/v1/clipboard", fmt.Sprintf(`{"operation":"cut","node_ids":["%s"]}`, file.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = call("POST", "/api/v1/clipboard/paste", `{"parent_id":"root"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pasted))
+	require.Empty(t, pasted.Failed)
+	require.Len(t, pasted.Pasted, 1)
+	require.Nil(t, pasted.Pasted[0].ParentID)
+
+	require.Equal(t, http.StatusNotFound, call("GET", "/api/v1/clipboard", "").Code, "A completed cut empties the clipboard")
+}
+
+func TestOrganizationRules(t *testing.T) {
+	user := createTestUserWithPassword(t, "rules_user", "password")
+	login := loginUserForTest(t, "rules_user", "password")
+
+	inbox := createTestNodeAPI(t, "Inbox", "folder", nil, user.ID)
+	pdfs := createTestNodeAPI(t, "PDF", "folder", nil, user.ID)
+	existing := createTestNodeAPI(t, "stara-faktura.PDF", "file", &inbox.ID, user.ID)
+	createTestNodeAPI(t, "zdjecie.jpg", "file", &inbox.ID, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/rules", testServer.CreateOrganizationRuleHandler)
+	router.Post("/api/v1/rules/test", testServer.TestOrganizationRuleHandler)
+	router.Post("/api/v1/rules/{ruleId}/run", testServer.RunOrganizationRuleHandler)
+	router.Get("/api/v1/nodes/{nodeId}/tags", testServer.ListNodeTagsHandler)
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ruleBody := fmt.Sprintf(`{"name":"Faktury","name_pattern":"*.pdf","source_folder_id":"%s","target_folder_id":"%s","tags":["invoice"]}`, inbox.ID, pdfs.ID)
+	require.Equal(t, http.StatusBadRequest, call("POST", "/api/v1/rules", `{"name":"Pusta","name_pattern":"*.pdf"}`).Code, "A rule without actions is rejected")
+	require.Equal(t, http.StatusBadRequest, call("POST", "/api/v1/rules", fmt.Sprintf(`{"name":"Zla","name_pattern":"[","tags":["x"],"source_folder_id":"%s"}`, inbox.ID)).Code)
+
+	rr := call("POST", "/api/v1/rules/test", ruleBody)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var run RuleRunResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &run))
+	require.True(t, run.DryRun)
+	require.Len(t, run.Matches, 1)
+	require.Equal(t, existing.ID, run.Matches[0].Node.ID)
+	require.False(t, run.Matches[0].Applied)
+
+	rr = call("POST", "/api/v1/rules", ruleBody)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var rule dto.OrganizationRule
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rule))
+
+	uploaded := createTestNodeAPI(t, "nowa-faktura.pdf", "file", &inbox.ID, user.ID)
+	result := testServer.applyOrganizationRules(context.Background(), user.ID, []models.Node{*uploaded})
+	require.Len(t, result, 1)
+	require.Equal(t, pdfs.ID, *result[0].ParentID, "A matching upload is moved to the target folder")
+
+	rr = call("GET", fmt.Sprintf("/api/v1/nodes/%s/tags", uploaded.ID), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.JSONEq(t, `["invoice"]`, rr.Body.String())
+
+	rr = call("POST", fmt.Sprintf("/api/v1/rules/%d/run", rule.ID), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &run))
+	require.False(t, run.DryRun)
+	require.Len(t, run.Matches, 1)
+	require.True(t, run.Matches[0].Applied)
+
+	moved, err := testServer.store.GetNodeByID(context.Background(), existing.ID, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, pdfs.ID, *moved.ParentID)
+}
+
+func TestRunRuleCoversWholeFolder(t *testing.T) {
+	user := createTestUserWithPassword(t, "rule_pages_user", "password")
+	inbox := createTestNodeAPI(t, "Inbox", "folder", nil, user.ID)
+	archive := createTestNodeAPI(t, "Archiwum", "folder", nil, user.ID)
+
+	total := MaxLimit + 5
+	nodes := make([]database.CreateNodeParams, 0, total)
+	for i := 0; i < total; i++ {
+		nodes = append(nodes, database.CreateNodeParams{ID: fmt.Sprintf("rule_page_file_%05d", i), OwnerID: user.ID, ParentID: &inbox.ID, Name: fmt.Sprintf("notatka-%05d.txt", i), NodeType: "file"})
+	}
+	_, err := testServer.store.CopyNodes(context.Background(), nodes)
+	require.NoError(t, err)
+
+	rule := database.OrganizationRule{Name: "Notatki", NamePattern: "*.txt", SourceFolderID: &inbox.ID, TargetFolderID: &archive.ID, Enabled: true}
+	matches, err := testServer.runRule(context.Background(), user.ID, rule, true)
+	require.NoError(t, err)
+	require.Len(t, matches, total, "A dry run reaches files past the first page")
+
+	matches, err = testServer.runRule(context.Background(), user.ID, rule, false)
+	require.NoError(t, err)
+	require.Len(t, matches, total)
+	left, err := testServer.store.GetNodesByParentID(context.Background(), user.ID, &inbox.ID, MaxLimit, 0)
+	require.NoError(t, err)
+	require.Empty(t, left, "Moving matches does not skip files on later pages")
+}
+
+type stubTranscriber struct{}
+
+func (stubTranscriber) Transcribe(ctx context.Context, fileName string, audio io.Reader, format string) ([]byte, error) {
+	content, err := io.ReadAll(audio)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("WEBVTT\n\n00:00.000 --> 00:01.000\n" + string(content) + "\n"), nil
+}
+
+func TestTranscriptions(t *testing.T) {
+	user := createTestUserWithPassword(t, "transcription_user", "password")
+	login := loginUserForTest(t, "transcription_user", "password")
+
+	audioMime := "audio/mpeg"
+	audio := createTestNodeAPI(t, "wywiad.mp3", "file", nil, user.ID)
+	require.NoError(t, testServer.storage.Save(audio.ID, strings.NewReader("dzien dobry")))
+	audio, err := testServer.store.UpdateNodeContent(context.Background(), audio.ID, user.ID, int64(len("dzien dobry")), &audioMime, nil)
+	require.NoError(t, err)
+	document := createTestNodeAPI(t, "notatki.txt", "file", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/transcriptions", testServer.RequestTranscriptionHandler)
+	router.Get("/api/v1/transcriptions/{jobId}", testServer.GetTranscriptionHandler)
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	previous := testServer.transcriber
+	defer func() { testServer.transcriber = previous }()
+
+	testServer.transcriber = nil
+	require.Equal(t, http.StatusServiceUnavailable, call("POST", fmt.Sprintf("/api/v1/nodes/%s/transcriptions", audio.ID), "").Code)
+
+	testServer.transcriber = stubTranscriber{}
+	require.Equal(t, http.StatusBadRequest, call("POST", fmt.Sprintf("/api/v1/nodes/%s/transcriptions", document.ID), "").Code, "Only audio and video can be transcribed")
+	require.Equal(t, http.StatusBadRequest, call("POST", fmt.Sprintf("/api/v1/nodes/%s/transcriptions", audio.ID), `{"format":"srt"}`).Code)
+
+	rr := call("POST", fmt.Sprintf("/api/v1/nodes/%s/transcriptions", audio.ID), `{"format":"vtt"}`)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	var job dto.TranscriptionJob
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.Equal(t, database.TranscriptionQueued, job.Status)
+
+	require.NoError(t, testServer.processTranscriptions(context.Background()))
+
+	rr = call("GET", fmt.Sprintf("/api/v1/transcriptions/%s", job.ID), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.Equal(t, database.TranscriptionCompleted, job.Status)
+	require.Equal(t, 100, job.Progress)
+	require.NotNil(t, job.ResultNodeID)
+
+	sidecar, err := testServer.store.GetNodeByID(context.Background(), *job.ResultNodeID, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "wywiad.vtt", sidecar.Name)
+	require.Equal(t, "text/vtt", *sidecar.MimeType)
+
+	content, err := testServer.storage.Get(sidecar.ID)
+	require.NoError(t, err)
+	defer content.Close()
+	transcript, _ := io.ReadAll(content)
+	require.Contains(t, string(transcript), "dzien dobry")
+
+	require.Equal(t, http.StatusNotFound, call("GET", fmt.Sprintf("/api/v1/transcriptions/%s", uuid.New()), "").Code)
+}
+
+func TestSyncSnapshot(t *testing.T) {
+	user := createTestUserWithPassword(t, "snapshot_user", "password")
+	login := loginUserForTest(t, "snapshot_user", "password")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/folder", testServer.CreateFolderHandler)
+	router.Get("/api/v1/sync/snapshot", testServer.GetSyncSnapshotHandler)
+	router.Get("/api/v1/events", testServer.GetEventsHandler)
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusCreated, call("POST", "/api/v1/nodes/folder", `{"name":"Dokumenty"}`).Code)
+	folder := createTestNodeAPI(t, "Zdjecia", "folder", nil, user.ID)
+	createTestNodeAPI(t, "wakacje.jpg", "file", &folder.ID, user.ID)
+	trashed := createTestNodeAPI(t, "stary.txt", "file", nil, user.ID)
+	_, err := testServer.store.MoveNodeToTrash(context.Background(), trashed.ID, user.ID)
+	require.NoError(t, err)
+
+	rr := call("GET", "/api/v1/sync/snapshot", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var snapshot SyncSnapshotResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &snapshot))
+	require.Positive(t, snapshot.Cursor)
+
+	names := []string{}
+	for _, node := range snapshot.Nodes {
+		names = append(names, node.Name)
+	}
+	require.Equal(t, []string{"Dokumenty", "Zdjecia", "wakacje.jpg"}, names, "Parents come first and trashed nodes are left out")
+
+	rr = call("GET", fmt.Sprintf("/api/v1/events?since=%d", snapshot.Cursor), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var page EventsPageResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Empty(t, page.Events, "Nothing changed after the snapshot")
+
+	require.Equal(t, http.StatusCreated, call("POST", "/api/v1/nodes/folder", `{"name":"Nowy"}`).Code)
+	rr = call("GET", fmt.Sprintf("/api/v1/events?since=%d", snapshot.Cursor), "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Events, 1)
+}
+
+func TestListNodesChildCount(t *testing.T) {
+	user := createTestUserWithPassword(t, "child_count_user", "password")
+	login := loginUserForTest(t, "child_count_user", "password")
+
+	full := createTestNodeAPI(t, "Pelny", "folder", nil, user.ID)
+	createTestNodeAPI(t, "Pusty", "folder", nil, user.ID)
+	createTestNodeAPI(t, "a.txt", "file", &full.ID, user.ID)
+	createTestNodeAPI(t, "Podfolder", "folder", &full.ID, user.ID)
+	trashed := createTestNodeAPI(t, "b.txt", "file", &full.ID, user.ID)
+	_, err := testServer.store.MoveNodeToTrash(context.Background(), trashed.ID, user.ID)
+	require.NoError(t, err)
+	createTestNodeAPI(t, "luzny.txt", "file", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes", testServer.ListNodesHandler)
+
+	list := func(url string) map[string]dto.Node {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var nodes []dto.Node
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+		byName := map[string]dto.Node{}
+		for _, node := range nodes {
+			byName[node.Name] = node
+		}
+		return byName
+	}
+
+	plain := list("/api/v1/nodes")
+	require.Nil(t, plain["Pelny"].ChildCount, "Counts are only computed on request")
+
+	counted := list("/api/v1/nodes?include=child_count")
+	require.Equal(t, int64(2), *counted["Pelny"].ChildCount, "Trashed children are not counted")
+	require.Equal(t, int64(0), *counted["Pusty"].ChildCount)
+	require.Nil(t, counted["luzny.txt"].ChildCount, "Files have no child count")
+}
+
+func TestListNodesFieldProjection(t *testing.T) {
+	user := createTestUserWithPassword(t, "projection_user", "password")
+	login := loginUserForTest(t, "projection_user", "password")
+	createTestNodeAPI(t, "raport.pdf", "file", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes", testServer.ListNodesHandler)
+
+	req := httptest.NewRequest("GET", "/api/v1/nodes?fields=id,name,%20modified_at,unknown", nil)
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rows))
+	require.Len(t, rows, 1)
+	require.Len(t, rows[0], 3)
+	require.Equal(t, "raport.pdf", rows[0]["name"])
+	require.Contains(t, rows[0], "id")
+	require.Contains(t, rows[0], "modified_at")
+	require.NotContains(t, rows[0], "size_bytes")
+}
+
+func TestExplicitForbiddenErrors(t *testing.T) {
+	owner := createTestUserWithPassword(t, "forbidden_owner", "password")
+	createTestUserWithPassword(t, "forbidden_stranger", "password")
+	ownerLogin := loginUserForTest(t, "forbidden_owner", "password")
+	strangerLogin := loginUserForTest(t, "forbidden_stranger", "password")
+
+	private := createTestNodeAPI(t, "prywatny.txt", "file", nil, owner.ID)
+	trashed := createTestNodeAPI(t, "usuniety.txt", "file", nil, owner.ID)
+	_, err := testServer.store.MoveNodeToTrash(context.Background(), trashed.ID, owner.ID)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+
+	call := func(token, nodeID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/download", nodeID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusNotFound, call(strangerLogin.AccessToken, private.ID).Code, "By default existing nodes are indistinguishable from missing ones")
+
+	testServer.config.Load().Errors.ExplicitForbidden = true
+	defer func() { testServer.config.Load().Errors.ExplicitForbidden = false }()
+
+	reason := func(rr *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		var response AccessErrorResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response.Code
+	}
+	require.Equal(t, i18n.NotShared, reason(call(strangerLogin.AccessToken, private.ID)))
+	require.Equal(t, i18n.NodeTrashed, reason(call(ownerLogin.AccessToken, trashed.ID)))
+	require.Equal(t, http.StatusNotFound, call(strangerLogin.AccessToken, "nieistniejacy_wezel_1").Code)
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes", testServer.ListNodesHandler)
+
+	call := func(acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/nodes", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call("")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Equal(t, i18n.InvalidToken, rr.Header().Get("X-Error-Code"))
+	require.Equal(t, i18n.English, rr.Header().Get("Content-Language"))
+	require.Equal(t, i18n.Message(i18n.InvalidToken, i18n.English), strings.TrimSpace(rr.Body.String()))
+
+	rr = call("pl-PL,pl;q=0.9,en;q=0.5")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Equal(t, i18n.InvalidToken, rr.Header().Get("X-Error-Code"))
+	require.Equal(t, i18n.Polish, rr.Header().Get("Content-Language"))
+	require.Equal(t, i18n.Message(i18n.InvalidToken, i18n.Polish), strings.TrimSpace(rr.Body.String()))
+
+	createTestUserWithPassword(t, "localized_errors_user", "password")
+	loginResp := loginUserForTest(t, "localized_errors_user", "password")
+	router.Post("/api/v1/groups", testServer.CreateGroupHandler)
+	body := fmt.Sprintf(`{"name": %q}`, strings.Repeat("g", maxGroupNameLength+1))
+	req := httptest.NewRequest("POST", "/api/v1/groups", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+loginResp.AccessToken)
+	req.Header.Set("Accept-Language", "pl")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, i18n.GroupNameTooLong, rr.Header().Get("X-Error-Code"))
+	require.Equal(t, "Nazwa grupy nie może mieć więcej niż 100 znaków", strings.TrimSpace(rr.Body.String()), "Parameters are filled into the translated message")
+}
+
+func TestDownloadArchiveManifest(t *testing.T) {
+	user := createTestUserWithPassword(t, "archive_manifest_user", "password")
+	loginResp := loginUserForTest(t, "archive_manifest_user", "password")
+
+	folder := createTestNodeAPI(t, "Zdjęcia", "folder", nil, user.ID)
+	file := createTestNodeAPI(t, "łódź.txt", "file", &folder.ID, user.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("content")))
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/archive?ids=%s&manifest=true", folder.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+loginResp.AccessToken)
+	rr := httptest.NewRecorder()
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/archive", testServer.DownloadArchiveHandler)
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	zipBody := rr.Body.Bytes()
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBody), int64(len(zipBody)))
+	require.NoError(t, err)
+
+	entries := make(map[string]*zip.File)
+	for _, f := range zipReader.File {
+		entries[f.Name] = f
+	}
+	require.Len(t, entries, 3, "Folder, file and manifest are expected")
+
+	fileEntry := entries["Zdjęcia/łódź.txt"]
+	require.NotNil(t, fileEntry)
+	require.True(t, fileEntry.Modified.Equal(file.ModifiedAt.Truncate(time.Second)), "Entries should keep the node's modification time")
+	require.True(t, entries["Zdjęcia/"].Mode().IsDir())
+
+	manifestFile, err := entries["manifest.json"].Open()
+	require.NoError(t, err)
+	defer manifestFile.Close()
+	var manifest ArchiveManifest
+	require.NoError(t, json.NewDecoder(manifestFile).Decode(&manifest))
+	require.Len(t, manifest.Entries, 2)
+	require.Equal(t, folder.ID, manifest.Entries[0].ID)
+	require.Equal(t, file.ID, manifest.Entries[1].ID)
+	require.Equal(t, "Zdjęcia/łódź.txt", manifest.Entries[1].Path)
+	require.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", manifest.Entries[1].SHA256)
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	cleaned, err := cleanArchivePath("Faktury/./2024/styczen.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "Faktury/2024/styczen.pdf", cleaned)
+
+	cleaned, err = cleanArchivePath("./")
+	require.NoError(t, err)
+	require.Empty(t, cleaned)
+
+	for _, unsafe := range []string{"../evil.txt", "a/../../evil.txt", "/etc/passwd", "..\\evil.txt", "C:\\evil.txt"} {
+		_, err := cleanArchivePath(unsafe)
+		require.ErrorIs(t, err, errUnsafeArchivePath, unsafe)
+	}
+}
+
+func TestImportArchive(t *testing.T) {
+	user := createTestUserWithPassword(t, "archive_import_user", "password")
+	login := loginUserForTest(t, "archive_import_user", "password")
+
+	target := createTestNodeAPI(t, "Import", "folder", nil, user.ID)
+	existing := createTestNodeAPI(t, "Faktury", "folder", &target.ID, user.ID)
+	createTestNodeAPI(t, "styczen.txt", "file", &existing.ID, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/import-archive", testServer.ImportArchiveHandler)
+	router.Get("/api/v1/archive-imports/{importId}", testServer.GetArchiveImportHandler)
+
+	call := func(method, url, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	zipArchive := func(entries map[string]string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range entries {
+			f, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	importURL := fmt.Sprintf("/api/v1/nodes/import-archive?parent_id=%s", target.ID)
+
+	rr := call("POST", importURL, "application/zip", zipArchive(map[string]string{"ok.txt": "ok", "../evil.txt": "evil"}))
+	require.Equal(t, http.StatusBadRequest, rr.Code, "Zip-slip entries must reject the archive")
+	require.Equal(t, http.StatusBadRequest, call("POST", importURL, "text/plain", []byte("x")).Code)
+
+	rr = call("POST", importURL, "application/zip", zipArchive(map[string]string{
+		"Faktury/":              "",
+		"Faktury/styczen.txt":   "faktura 1",
+		"Faktury/2024/luty.txt": "faktura 2",
+		"notatki.md":            "# notatki",
+	}))
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	var job dto.ArchiveImport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.Equal(t, database.ArchiveImportQueued, job.Status)
+	require.Equal(t, 4, job.TotalEntries)
+
+	require.NoError(t, testServer.processArchiveImports(context.Background()))
+
+	rr = call("GET", fmt.Sprintf("/api/v1/archive-imports/%s", job.ID), "", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.Equal(t, database.ArchiveImportCompleted, job.Status, job.Error)
+	require.Equal(t, 4, job.CreatedNodes, "2024 folder, two files in Faktury and notatki.md")
+
+	faktury, err := testServer.store.GetNodesByParentID(context.Background(), user.ID, &existing.ID, MaxLimit, 0)
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, node := range faktury {
+		names[node.Name] = true
+	}
+	require.Equal(t, map[string]bool{"styczen.txt": true, "styczen (2).txt": true, "2024": true}, names, "Archive folders merge into existing ones")
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "raport.txt", Mode: 0o644, Size: 6, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("raport"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	rr = call("POST", importURL+"&format=tar", "", tarBuf.Bytes())
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	require.NoError(t, testServer.processArchiveImports(context.Background()))
+	taken, err := testServer.store.NodeNameTaken(context.Background(), user.ID, &target.ID, "raport.txt")
+	require.NoError(t, err)
+	require.True(t, taken)
+}
+
+func TestFolderHooks(t *testing.T) {
+	user := createTestUserWithPassword(t, "folder_hook_user", "password")
+	login := loginUserForTest(t, "folder_hook_user", "password")
+	folder := createTestNodeAPI(t, "Faktury", "folder", nil, user.ID)
+
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	fail := true
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- r
+		bodies <- body
+	}))
+	defer target.Close()
+
+	previous := testServer.config.Load().Hooks
+	defer func() { testServer.config.Load().Hooks = previous }()
+	testServer.config.Load().Hooks.AllowedHosts = []string{"127.0.0.1"}
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/hook", testServer.GetFolderHookHandler)
+	router.Put("/api/v1/nodes/{nodeId}/hook", testServer.SetFolderHookHandler)
+	router.Delete("/api/v1/nodes/{nodeId}/hook", testServer.DeleteFolderHookHandler)
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, fmt.Sprintf("/api/v1/nodes/%s/hook", folder.ID), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, call("PUT", `{"url":"http://example.com/hook"}`).Code, "Hosts outside hooks.allowed_hosts are rejected")
+	rr := call("PUT", fmt.Sprintf(`{"url":"%s/ingest","mime_prefixes":["application/pdf"]}`, target.URL))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var hook dto.FolderHookWithSecret
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &hook))
+	require.NotEmpty(t, hook.Secret)
+	rr = call("GET", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NotContains(t, rr.Body.String(), hook.Secret, "Reading the hook does not return its secret")
+
+	pdfMime, textMime := "application/pdf", "text/plain"
+	size := int64(3)
+	pdf, err := testServer.store.CreateNode(context.Background(), database.CreateNodeParams{ID: "hook_pdf_file_000001", OwnerID: user.ID, ParentID: &folder.ID, Name: "faktura.pdf", NodeType: "file", SizeBytes: &size, MimeType: &pdfMime})
+	require.NoError(t, err)
+	text, err := testServer.store.CreateNode(context.Background(), database.CreateNodeParams{ID: "hook_txt_file_000001", OwnerID: user.ID, ParentID: &folder.ID, Name: "notatka.txt", NodeType: "file", SizeBytes: &size, MimeType: &textMime})
+	require.NoError(t, err)
+	testServer.publishUploadedNodes(context.Background(), user.ID, nil, &folder.ID, []models.Node{*pdf, *text})
+
+	require.NoError(t, testServer.deliverFolderHooks(context.Background()))
+	require.Empty(t, received, "The first attempt fails and is retried later")
+	_, err = testServer.store.GetPool().Exec(context.Background(), `UPDATE folder_hook_deliveries SET next_attempt_at = NOW() WHERE folder_id = $1`, folder.ID)
+	require.NoError(t, err)
+	require.NoError(t, testServer.deliverFolderHooks(context.Background()))
+
+	require.Len(t, received, 1, "Only the PDF matches the hook's MIME prefixes")
+	req := <-received
+	body := <-bodies
+	require.Equal(t, signHookPayload(hook.Secret, body), req.Header.Get("X-Hook-Signature"))
+	var payload FolderHookPayload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	require.Equal(t, pdf.ID, payload.Node.ID)
+	require.Equal(t, 2, payload.Attempt)
+
+	require.Equal(t, http.StatusNoContent, call("DELETE", "").Code)
+	require.Equal(t, http.StatusNotFound, call("DELETE", "").Code)
+
+	// An allowed host cannot redirect deliveries to a host that is not.
+	reached := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+	defer internal.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(internal.URL+"/admin", http.StatusTemporaryRedirect))
+	defer redirector.Close()
+	testServer.config.Load().Hooks.AllowedHosts = []string{strings.TrimPrefix(redirector.URL, "http://")}
+	resp, err := testServer.hookClient.Post(redirector.URL, "application/json", strings.NewReader("{}"))
+	if err == nil {
+		resp.Body.Close()
+	}
+	require.Error(t, err)
+	require.False(t, reached, "The redirect target is not in hooks.allowed_hosts")
+}
+
+func TestShareActivity(t *testing.T) {
+	owner := createTestUserWithPassword(t, "activity_owner", "password")
+	recipient := createTestUserWithPassword(t, "activity_recipient", "password")
+	createTestUserWithPassword(t, "activity_stranger", "password")
+	ownerLogin := loginUserForTest(t, "activity_owner", "password")
+	recipientLogin := loginUserForTest(t, "activity_recipient", "password")
+	strangerLogin := loginUserForTest(t, "activity_stranger", "password")
+
+	shared := createTestNodeAPI(t, "Wspólny", "folder", nil, owner.ID)
+	sub := createTestNodeAPI(t, "Podfolder", "folder", &shared.ID, owner.ID)
+	inside := createTestNodeAPI(t, "raport.txt", "file", &sub.ID, owner.ID)
+	outside := createTestNodeAPI(t, "prywatny.txt", "file", nil, owner.ID)
+
+	share, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: shared.ID, SharerID: owner.ID, RecipientID: recipient.ID, Permissions: "write",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, testServer.store.LogEvents(context.Background(), nodeCreatedEvents(recipient.ID, owner.ID, inside)))
+	require.NoError(t, testServer.store.LogEvents(context.Background(), nodeCreatedEvents(owner.ID, owner.ID, outside)))
+	require.NoError(t, testServer.store.LogEvent(context.Background(), owner.ID, "node_renamed", map[string]interface{}{"id": sub.ID, "new_name": "Podfolder", "old_name": "Stary"}))
+	require.NoError(t, testServer.store.LogEvent(context.Background(), owner.ID, "favorite_added", map[string]interface{}{"node_id": inside.ID}))
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/shares/{shareId}/activity", testServer.GetShareActivityHandler)
+	call := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/shares/%d/activity%s", share.ID, query), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, token := range []string{ownerLogin.AccessToken, recipientLogin.AccessToken} {
+		rr := call(token, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var activity ShareActivityResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &activity))
+		require.Len(t, activity.Events, 2, "Only changes within the shared subtree are listed")
+		require.Equal(t, "node_renamed", activity.Events[0].EventType)
+		require.Equal(t, "node_created", activity.Events[1].EventType)
+		require.False(t, activity.HasMore)
+	}
+
+	rr := call(recipientLogin.AccessToken, "?limit=1")
+	var page ShareActivityResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Events, 1)
+	require.True(t, page.HasMore)
+	rr = call(recipientLogin.AccessToken, fmt.Sprintf("?limit=1&before=%d", page.NextBefore))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Equal(t, "node_created", page.Events[0].EventType)
+
+	require.Equal(t, http.StatusNotFound, call(strangerLogin.AccessToken, "").Code)
+}
+
+func TestAdminAccessCheck(t *testing.T) {
+	admin := createTestUserWithPassword(t, "access_check_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "access_check_admin", "password")
+
+	owner := createTestUserWithPassword(t, "access_check_owner", "password")
+	reader := createTestUserWithPassword(t, "access_check_reader", "password")
+	createTestUserWithPassword(t, "access_check_stranger", "password")
+
+	projects := createTestNodeAPI(t, "Projekty", "folder", nil, owner.ID)
+	sub := createTestNodeAPI(t, "Q3", "folder", &projects.ID, owner.ID)
+	file := createTestNodeAPI(t, "plan.txt", "file", &sub.ID, owner.ID)
+	share, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: projects.ID, SharerID: owner.ID, RecipientID: reader.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.With(testServer.AdminMiddleware).Get("/api/v1/admin/access-check", testServer.AdminAccessCheckHandler)
+	check := func(user, node string) AccessCheckResponse {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/admin/access-check?user=%s&node=%s", user, node), nil)
+		req.Header.Set("Authorization", "Bearer "+adminLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response AccessCheckResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	result := check("access_check_reader", file.ID)
+	require.Equal(t, accessDecisionShared, result.Decision)
+	require.Equal(t, "read", result.Permission)
+	require.True(t, result.CanRead)
+	require.False(t, result.CanWrite)
+	require.Len(t, result.Path, 3)
+	require.NotNil(t, result.MatchedShare)
+	require.Equal(t, share.ID, *result.MatchedShare.ShareID)
+	require.Equal(t, 2, result.MatchedShare.Depth)
+
+	result = check(fmt.Sprint(owner.ID), file.ID)
+	require.Equal(t, accessDecisionOwner, result.Decision)
+	require.True(t, result.CanWrite)
+
+	result = check("access_check_stranger", file.ID)
+	require.Equal(t, accessDecisionDenied, result.Decision)
+	require.False(t, result.CanRead)
+
+	require.Equal(t, accessDecisionNotFound, check("access_check_stranger", "nieistniejacy_wezel_1").Decision)
+}
+
+func TestWebSocketCatchUp(t *testing.T) {
+	user := createTestUserWithPassword(t, "ws_catch_up_user", "password")
+	login := loginUserForTest(t, "ws_catch_up_user", "password")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, testServer.store.LogEvent(context.Background(), user.ID, "node_renamed", map[string]interface{}{"id": fmt.Sprintf("node_%d", i)}))
+	}
+	events, err := testServer.store.GetEventsSince(context.Background(), user.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	server := httptest.NewServer(http.HandlerFunc(testServer.ServeWsHandler))
+	defer server.Close()
+
+	url := fmt.Sprintf("ws%s?token=%s&since=%d", strings.TrimPrefix(server.URL, "http"), login.AccessToken, events[0].ID)
+	conn, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var frame struct {
+		EventType string         `json:"event_type"`
+		Payload   CatchUpPayload `json:"payload"`
+	}
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "catch_up", frame.EventType)
+	require.Equal(t, events[2].ID, frame.Payload.LastEventID)
+	require.Len(t, frame.Payload.Events, 2, "Only events after the cursor are replayed")
+	require.Equal(t, events[1].ID, frame.Payload.Events[0].ID)
+	require.False(t, frame.Payload.HasMore)
+}
+
+func TestResumableUpload(t *testing.T) {
+	user := createTestUserWithPassword(t, "resumable_upload_user", "password")
+	login := loginUserForTest(t, "resumable_upload_user", "password")
+	folder := createTestNodeAPI(t, "Nagrania", "folder", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/file/sessions", testServer.CreateUploadSessionHandler)
+	router.Get("/api/v1/uploads/{uploadId}", testServer.GetUploadSessionHandler)
+	router.Patch("/api/v1/uploads/{uploadId}", testServer.UploadChunkHandler)
+	router.Delete("/api/v1/uploads/{uploadId}", testServer.CancelUploadSessionHandler)
+	router.Post("/api/v1/uploads/{uploadId}/complete", testServer.CompleteUploadSessionHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+
+	call := func(method, url string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	chunkSum := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+
+	content := "pierwsza czesc|druga czesc"
+	body, _ := json.Marshal(CreateUploadSessionRequest{FileName: "nagranie.txt", ParentID: &folder.ID, TotalSize: int64(len(content))})
+	rr := call("POST", "/api/v1/nodes/file/sessions", nil, body)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var session models.UploadSession
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &session))
+	uploadURL := fmt.Sprintf("/api/v1/uploads/%s", session.ID)
+
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "15"}, []byte(content[15:]))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "0", rr.Header().Get("Upload-Offset"), "Nothing is received from the start yet")
+
+	rr = call("POST", uploadURL+"/complete", nil, nil)
+	require.Equal(t, http.StatusConflict, rr.Code, "Missing bytes keep the upload open")
+
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "0"}, []byte(content))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "A chunk cannot extend past the end of the file")
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "0", "X-Chunk-SHA256": chunkSum("something else")}, []byte(content[:15]))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = call("POST", uploadURL+"/complete", nil, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	var verification ChunkVerificationError
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &verification))
+	require.Equal(t, []int{0}, verification.CorruptChunks)
+
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "0", "X-Chunk-SHA256": chunkSum(content[:15])}, []byte(content[:15]))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, strconv.Itoa(len(content)), rr.Header().Get("Upload-Offset"))
+
+	rr = call("POST", uploadURL+"/complete", nil, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var node models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &node))
+	require.Equal(t, "nagranie.txt", node.Name)
+	require.Equal(t, folder.ID, *node.ParentID)
+
+	rr = call("GET", fmt.Sprintf("/api/v1/nodes/%s/download", node.ID), nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, content, rr.Body.String())
+	require.Equal(t, http.StatusNotFound, call("GET", uploadURL, nil, nil).Code, "A finalized session is removed")
+
+	body, _ = json.Marshal(CreateUploadSessionRequest{FileName: "porzucony.bin", TotalSize: 10})
+	rr = call("POST", "/api/v1/nodes/file/sessions", nil, body)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &session))
+	uploadURL = fmt.Sprintf("/api/v1/uploads/%s", session.ID)
+	require.Equal(t, http.StatusNoContent, call("DELETE", uploadURL, nil, nil).Code)
+	require.Equal(t, http.StatusNotFound, call("PATCH", uploadURL, map[string]string{"Upload-Offset": "0"}, []byte("x")).Code)
+}
+
+func TestParseByteRanges(t *testing.T) {
+	ranges, err := parseByteRanges("bytes=0-4, 8-, -3", 10)
+	require.NoError(t, err)
+	require.Equal(t, []byteRange{{start: 0, length: 5}, {start: 8, length: 2}, {start: 7, length: 3}}, ranges)
+
+	ranges, err = parseByteRanges("bytes=5-100,20-30", 10)
+	require.NoError(t, err)
+	require.Equal(t, []byteRange{{start: 5, length: 5}}, ranges, "Ranges are clamped and those past the end dropped")
+
+	_, err = parseByteRanges("bytes=10-", 10)
+	require.ErrorIs(t, err, errRangeNotSatisfiable)
+	_, err = parseByteRanges("bytes=-5", 0)
+	require.ErrorIs(t, err, errRangeNotSatisfiable)
+
+	for _, header := range []string{"items=0-4", "bytes=4-2", "bytes=a-b", "bytes=5", "bytes=" + strings.Repeat("0-1,", maxByteRanges) + "0-1"} {
+		_, err = parseByteRanges(header, 10)
+		require.ErrorIs(t, err, errInvalidRange, header)
+	}
+}
+
+func TestDownloadFileRanges(t *testing.T) {
+	fileNode := createTestNodeAPI(t, "film.mp4", "file", nil, testUserClaims.UserID)
+	content := "0123456789abcdef"
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader(content)))
+	mimeType := "video/mp4"
+	fileNode, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, testUserClaims.UserID, int64(len(content)), &mimeType, nil)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	download := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/%s/download", fileNode.ID), nil)
+		req.Header.Set("Authorization", "Bearer "+testUserToken)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := download(nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+	etag := rr.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rr = download(map[string]string{"Range": "bytes=4-7"})
+	require.Equal(t, http.StatusPartialContent, rr.Code)
+	require.Equal(t, "4567", rr.Body.String())
+	require.Equal(t, "bytes 4-7/16", rr.Header().Get("Content-Range"))
+	require.Equal(t, "4", rr.Header().Get("Content-Length"))
+
+	rr = download(map[string]string{"Range": "bytes=-3", "If-Range": etag})
+	require.Equal(t, http.StatusPartialContent, rr.Code)
+	require.Equal(t, "def", rr.Body.String())
+
+	rr = download(map[string]string{"Range": "bytes=-3", "If-Range": "\"stale\""})
+	require.Equal(t, http.StatusOK, rr.Code, "A stale If-Range validator gets the whole file")
+	require.Equal(t, content, rr.Body.String())
+
+	rr = download(map[string]string{"Range": "bytes=16-"})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	require.Equal(t, "bytes */16", rr.Header().Get("Content-Range"))
+
+	rr = download(map[string]string{"Range": "bytes=0-1,10-11"})
+	require.Equal(t, http.StatusPartialContent, rr.Code)
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(rr.Body, params["boundary"])
+	for _, expected := range []struct{ contentRange, body string }{{"bytes 0-1/16", "01"}, {"bytes 10-11/16", "ab"}} {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		require.Equal(t, expected.contentRange, part.Header.Get("Content-Range"))
+		require.Equal(t, mimeType, part.Header.Get("Content-Type"))
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		require.Equal(t, expected.body, string(body))
+	}
+	_, err = reader.NextPart()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestSecureDeletePurge(t *testing.T) {
+	user := createTestUserWithPassword(t, "secure_delete_user", "password")
+	login := loginUserForTest(t, "secure_delete_user", "password")
+
+	testServer.config.Load().Storage.SecureDelete = true
+	testServer.storage.EnableShredding(1)
+	defer func() {
+		testServer.config.Load().Storage.SecureDelete = false
+		testServer.storage.EnableShredding(0)
+	}()
+
+	fileNode := createTestNodeAPI(t, "umowa.pdf", "file", nil, user.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("poufna umowa")))
+	trashed, err := testServer.store.MoveNodeToTrash(context.Background(), fileNode.ID, user.ID)
+	require.NoError(t, err)
+	require.True(t, trashed)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/trash/purge", nil)
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr := httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Delete("/api/v1/trash/purge", testServer.PurgeTrashHandler)
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	_, err = testServer.storage.Get(fileNode.ID)
+	require.Error(t, err, "The blob is removed")
+
+	var action string
+	err = testServer.store.GetPool().QueryRow(context.Background(),
+		"SELECT action FROM access_log WHERE node_id = $1 AND owner_id = $2", fileNode.ID, user.ID).Scan(&action)
+	require.NoError(t, err)
+	require.Equal(t, "shredded", action)
+}
+
+func TestDownloadArchiveSharedAndLimits(t *testing.T) {
+	owner := createTestUserWithPassword(t, "archive_share_owner", "password")
+	recipient := createTestUserWithPassword(t, "archive_share_recipient", "password")
+	recipientLogin := loginUserForTest(t, "archive_share_recipient", "password")
+
+	folder := createTestNodeAPI(t, "Wspólne", "folder", nil, owner.ID)
+	sub := createTestNodeAPI(t, "Podfolder", "folder", &folder.ID, owner.ID)
+	file := createTestNodeAPI(t, "notatka.txt", "file", &sub.ID, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("wspólna notatka")))
+	private := createTestNodeAPI(t, "prywatne.txt", "file", nil, owner.ID)
+
+	_, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: folder.ID, SharerID: owner.ID, RecipientID: recipient.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/archive", testServer.DownloadArchiveHandler)
+	download := func(ids ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/nodes/archive?ids="+strings.Join(ids, ","), nil)
+		req.Header.Set("Authorization", "Bearer "+recipientLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := download(folder.ID, file.ID)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	zipBody := rr.Body.Bytes()
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBody), int64(len(zipBody)))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range zipReader.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"Wspólne/", "Wspólne/Podfolder/", "Wspólne/Podfolder/notatka.txt"}, names, "A node selected with its ancestor is packed once")
+
+	require.Equal(t, http.StatusNotFound, download(folder.ID, private.ID).Code, "Nodes that are not shared cannot be archived")
+
+	testServer.config.Load().Limits = config.LimitsConfig{MaxArchiveEntries: 2}
+	defer func() { testServer.config.Load().Limits = config.LimitsConfig{} }()
+	require.Equal(t, http.StatusRequestEntityTooLarge, download(folder.ID).Code)
+	testServer.config.Load().Limits = config.LimitsConfig{MaxArchiveSizeMB: 1}
+	require.Equal(t, http.StatusOK, download(folder.ID).Code, "createTestNodeAPI files are 1234 bytes")
+}
+
+func TestCopyNode(t *testing.T) {
+	owner := createTestUserWithPassword(t, "copy_owner", "password")
+	recipient := createTestUserWithPassword(t, "copy_recipient", "password")
+	recipientLogin := loginUserForTest(t, "copy_recipient", "password")
+
+	shared := createTestNodeAPI(t, "Materiały", "folder", nil, owner.ID)
+	inner := createTestNodeAPI(t, "Wykłady", "folder", &shared.ID, owner.ID)
+	file := createTestNodeAPI(t, "wyklad1.txt", "file", &inner.ID, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("treść wykładu")))
+	pinnedFile := createTestNodeAPI(t, "zamrozony.txt", "file", nil, owner.ID)
+	target := createTestNodeAPI(t, "Moje", "folder", nil, recipient.ID)
+
+	pinnedVersion := 1
+	for _, params := range []database.ShareNodeParams{
+		{NodeID: shared.ID, SharerID: owner.ID, RecipientID: recipient.ID, Permissions: "read"},
+		{NodeID: pinnedFile.ID, SharerID: owner.ID, RecipientID: recipient.ID, Permissions: "read", PinnedVersion: &pinnedVersion},
+	} {
+		_, err := testServer.store.ShareNode(context.Background(), params)
+		require.NoError(t, err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/copy", testServer.CopyNodeHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	call := func(method, url string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+recipientLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	copyTo := func(nodeID string, parentID string) *httptest.ResponseRecorder {
+		return call("POST", fmt.Sprintf("/api/v1/nodes/%s/copy", nodeID), fmt.Sprintf(`{"parent_id":%q}`, parentID))
+	}
+
+	before, err := testServer.store.GetUserByID(context.Background(), recipient.ID)
+	require.NoError(t, err)
+
+	rr := copyTo(inner.ID, target.ID)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var copied models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &copied))
+	require.NotEqual(t, inner.ID, copied.ID)
+	require.Equal(t, recipient.ID, copied.OwnerID, "Copies belong to the owner of the target folder")
+	require.Equal(t, target.ID, *copied.ParentID)
+
+	children, err := testServer.store.GetNodesByParentID(context.Background(), recipient.ID, &copied.ID, MaxLimit, 0)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Equal(t, "wyklad1.txt", children[0].Name)
+	rr = call("GET", fmt.Sprintf("/api/v1/nodes/%s/download", children[0].ID), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "treść wykładu", rr.Body.String())
+
+	after, err := testServer.store.GetUserByID(context.Background(), recipient.ID)
+	require.NoError(t, err)
+	require.Equal(t, before.StorageUsedBytes+*file.SizeBytes, after.StorageUsedBytes, "The target owner's quota is charged")
+
+	require.Equal(t, http.StatusConflict, copyTo(inner.ID, target.ID).Code, "The name is already taken in the target folder")
+	require.Equal(t, http.StatusBadRequest, copyTo(target.ID, copied.ID).Code, "A folder cannot be copied into itself")
+	require.Equal(t, http.StatusForbidden, copyTo(file.ID, shared.ID).Code, "A read-only share cannot be copied into")
+	require.Equal(t, http.StatusForbidden, copyTo(pinnedFile.ID, "root").Code, "A file shared at a fixed version cannot be copied")
+}
+
+func TestLegalExport(t *testing.T) {
+	admin := createTestUserWithPassword(t, "legal_export_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "legal_export_admin", "password")
+	owner := createTestUserWithPassword(t, "legal_export_owner", "password")
+	ownerLogin := loginUserForTest(t, "legal_export_owner", "password")
+
+	ctx := context.Background()
+	folder := createTestNodeAPI(t, "Umowy", "folder", nil, owner.ID)
+	contract := createTestNodeAPI(t, "umowa.txt", "file", &folder.ID, owner.ID)
+	deleted := createTestNodeAPI(t, "aneks.txt", "file", &folder.ID, owner.ID)
+	for id, content := range map[string]string{contract.ID: "wersja 2", deleted.ID: "aneks"} {
+		require.NoError(t, testServer.storage.Save(id, strings.NewReader(content)))
+		_, err := testServer.store.GetPool().Exec(ctx, `UPDATE nodes SET size_bytes = $1 WHERE id = $2`, len(content), id)
+		require.NoError(t, err)
+	}
+	oldSize := int64(len("wersja 1"))
+	contract.SizeBytes = &oldSize
+	require.NoError(t, testServer.storage.Save("legal_export_v1", strings.NewReader("wersja 1")))
+	_, err = testServer.store.ArchiveNodeVersion(ctx, contract, "legal_export_v1")
+	require.NoError(t, err)
+	trashed, err := testServer.store.MoveNodeToTrash(ctx, deleted.ID, owner.ID)
+	require.NoError(t, err)
+	require.True(t, trashed)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Post("/api/v1/admin/legal-exports", testServer.CreateLegalExportHandler)
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Get("/api/v1/admin/legal-exports/{exportId}", testServer.GetLegalExportHandler)
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Get("/api/v1/admin/legal-exports/{exportId}/download", testServer.DownloadLegalExportHandler)
+	call := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	request := `{"node_id":"` + folder.ID + `","reason":"Sprawa 123/2025"}`
+	require.Equal(t, http.StatusForbidden, call(ownerLogin.AccessToken, "POST", "/api/v1/admin/legal-exports", request).Code)
+	require.Equal(t, http.StatusBadRequest, call(adminLogin.AccessToken, "POST", "/api/v1/admin/legal-exports", `{"node_id":"`+folder.ID+`"}`).Code)
+	require.Equal(t, http.StatusNotFound, call(adminLogin.AccessToken, "POST", "/api/v1/admin/legal-exports", `{"node_id":"missing","reason":"x"}`).Code)
+
+	rr := call(adminLogin.AccessToken, "POST", "/api/v1/admin/legal-exports", request)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	var export dto.LegalExport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	require.Equal(t, database.LegalExportQueued, export.Status)
+	require.Equal(t, http.StatusConflict, call(adminLogin.AccessToken, "GET", "/api/v1/admin/legal-exports/"+export.ID.String()+"/download", "").Code)
+
+	require.NoError(t, testServer.processLegalExports(ctx))
+
+	rr = call(adminLogin.AccessToken, "GET", "/api/v1/admin/legal-exports/"+export.ID.String(), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	require.Equal(t, database.LegalExportCompleted, export.Status, export.Error)
+	require.Equal(t, 6, export.Entries)
+
+	rr = call(adminLogin.AccessToken, "GET", "/api/v1/admin/legal-exports/"+export.ID.String()+"/download", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	bundleHash := sha256.Sum256(rr.Body.Bytes())
+	require.Equal(t, *export.SHA256, hex.EncodeToString(bundleHash[:]))
+	require.Equal(t, *export.SHA256, rr.Header().Get("X-Bundle-SHA256"))
+
+	contents := map[string][]byte{}
+	tarReader := tar.NewReader(rr.Body)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		contents[header.Name] = data
+	}
+	require.Equal(t, "wersja 2", string(contents["content/"+contract.ID+"/current"]))
+	require.Equal(t, "wersja 1", string(contents["content/"+contract.ID+"/v1"]))
+	require.Equal(t, "aneks", string(contents["content/"+deleted.ID+"/current"]), "Trashed files are exported")
+
+	var manifest LegalExportManifest
+	require.NoError(t, json.Unmarshal(contents["manifest.json"], &manifest))
+	require.Equal(t, "Sprawa 123/2025", manifest.Reason)
+	require.Len(t, manifest.Entries, 6)
+	for _, entry := range manifest.Entries {
+		entryHash := sha256.Sum256(contents[entry.Path])
+		require.Equal(t, hex.EncodeToString(entryHash[:]), entry.SHA256, entry.Path)
+	}
+
+	var nodes []LegalExportNode
+	require.NoError(t, json.Unmarshal(contents["nodes.json"], &nodes))
+	require.Len(t, nodes, 3)
+	require.Equal(t, "Umowy", nodes[0].Path)
+	require.Equal(t, "Umowy/aneks.txt", nodes[1].Path)
+	require.NotNil(t, nodes[1].DeletedAt)
+
+	var accessLog []dto.AccessLogEntry
+	require.NoError(t, json.Unmarshal(contents["audit/access_log.json"], &accessLog))
+	require.Len(t, accessLog, 1)
+	require.Equal(t, "legal_export", accessLog[0].Action)
+
+	completed, err := testServer.store.CompleteLegalExport(ctx, export.ID, "other", 1, "00", 1)
+	require.NoError(t, err)
+	require.Nil(t, completed, "A completed export cannot be replaced")
+}
+
+func TestAnnouncements(t *testing.T) {
+	admin := createTestUserWithPassword(t, "announcement_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "announcement_admin", "password")
+	createTestUserWithPassword(t, "announcement_user", "password")
+	userLogin := loginUserForTest(t, "announcement_user", "password")
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/announcements", testServer.ListActiveAnnouncementsHandler)
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Post("/api/v1/admin/announcements", testServer.CreateAnnouncementHandler)
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Delete("/api/v1/admin/announcements/{announcementId}", testServer.DeleteAnnouncementHandler)
+	call := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	create := func(body string) dto.Announcement {
+		rr := call(adminLogin.AccessToken, "POST", "/api/v1/admin/announcements", body)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var announcement dto.Announcement
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &announcement))
+		return announcement
+	}
+	activeIDs := func() []int64 {
+		rr := call("", "GET", "/api/v1/announcements", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var announcements []dto.Announcement
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &announcements))
+		ids := []int64{}
+		for _, announcement := range announcements {
+			ids = append(ids, announcement.ID)
+		}
+		return ids
+	}
+
+	require.Equal(t, http.StatusForbidden, call(userLogin.AccessToken, "POST", "/api/v1/admin/announcements", `{"message":"x"}`).Code)
+	require.Equal(t, http.StatusBadRequest, call(adminLogin.AccessToken, "POST", "/api/v1/admin/announcements", `{"message":"  "}`).Code)
+	require.Equal(t, http.StatusBadRequest, call(adminLogin.AccessToken, "POST", "/api/v1/admin/announcements", `{"message":"x","level":"urgent"}`).Code)
+	require.Equal(t, http.StatusBadRequest, call(adminLogin.AccessToken, "POST", "/api/v1/admin/announcements", `{"message":"x","ends_at":"2000-01-01T00:00:00Z"}`).Code)
+
+	info := create(`{"message":"Nowy regulamin"}`)
+	require.Equal(t, "info", info.Level)
+	maintenance := create(`{"message":"Przerwa techniczna","level":"critical"}`)
+	scheduled := create(`{"message":"Migracja","starts_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`)
+
+	ids := activeIDs()
+	require.Contains(t, ids, info.ID)
+	require.Contains(t, ids, maintenance.ID)
+	require.NotContains(t, ids, scheduled.ID, "Scheduled announcements are not shown before their start")
+	require.Less(t, slices.Index(ids, maintenance.ID), slices.Index(ids, info.ID), "Critical announcements come first")
+
+	require.Equal(t, http.StatusNoContent, call(adminLogin.AccessToken, "DELETE", fmt.Sprintf("/api/v1/admin/announcements/%d", info.ID), "").Code)
+	require.NotContains(t, activeIDs(), info.ID)
+	require.Equal(t, http.StatusNotFound, call(adminLogin.AccessToken, "DELETE", fmt.Sprintf("/api/v1/admin/announcements/%d", info.ID), "").Code)
+}
+
+func TestOnboardingTemplates(t *testing.T) {
+	ctx := context.Background()
+	admin := createTestUserWithPassword(t, "onboarding_admin", "password")
+	_, err := testServer.store.GetPool().Exec(ctx, `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "onboarding_admin", "password")
+	other := createTestUserWithPassword(t, "onboarding_other", "password")
+	_, err = testServer.store.GetPool().Exec(ctx, `UPDATE users SET onboarded_at = NOW()`)
+	require.NoError(t, err)
+
+	welcome := createTestNodeAPI(t, "Witaj", "folder", nil, admin.ID)
+	manual := createTestNodeAPI(t, "Instrukcja.pdf", "file", &welcome.ID, admin.ID)
+	require.NoError(t, testServer.storage.Save(manual.ID, strings.NewReader("instrukcja")))
+	foreign := createTestNodeAPI(t, "Cudze", "folder", nil, other.ID)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware, testServer.AdminMiddleware).Put("/api/v1/admin/onboarding/templates", testServer.SetOnboardingTemplatesHandler)
+	set := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/admin/onboarding/templates", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	defer set(`{"node_ids":[]}`)
+
+	require.Equal(t, http.StatusNotFound, set(`{"node_ids":["`+foreign.ID+`"]}`).Code, "Only the administrator's own nodes can be templates")
+	rr := set(`{"node_ids":["` + welcome.ID + `","` + welcome.ID + `"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var templates []models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &templates))
+	require.Len(t, templates, 1)
+
+	newcomer := createTestUserWithPassword(t, "onboarding_newcomer", "password")
+	require.NoError(t, testServer.onboardNewUsers(ctx))
+
+	root, err := testServer.store.GetNodesByParentID(ctx, newcomer.ID, nil, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, root, 1)
+	require.Equal(t, "Witaj", root[0].Name)
+	require.Equal(t, newcomer.ID, root[0].OwnerID)
+	children, err := testServer.store.GetNodesByParentID(ctx, newcomer.ID, &root[0].ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	content, err := testServer.openNodeContent(ctx, children[0].ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(content)
+	content.Close()
+	require.NoError(t, err)
+	require.Equal(t, "instrukcja", string(data))
+
+	otherRoot, err := testServer.store.GetNodesByParentID(ctx, other.ID, nil, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, otherRoot, 1, "Users onboarded before the templates were set get no copies")
+
+	require.NoError(t, testServer.onboardNewUsers(ctx))
+	root, err = testServer.store.GetNodesByParentID(ctx, newcomer.ID, nil, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, root, 1, "Users are onboarded once")
+}
+
+func TestReplaceContent(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "replace_owner", "password")
+	ownerLogin := loginUserForTest(t, "replace_owner", "password")
+	reader := createTestUserWithPassword(t, "replace_reader", "password")
+	readerLogin := loginUserForTest(t, "replace_reader", "password")
+
+	fileNode := createTestNodeAPI(t, "notatki.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("stara treść")))
+	fileNode, err := testServer.store.UpdateNodeContent(ctx, fileNode.ID, owner.ID, int64(len("stara treść")), nil, nil)
+	require.NoError(t, err)
+	_, err = testServer.store.ShareNode(ctx, database.ShareNodeParams{
+		NodeID: fileNode.ID, SharerID: owner.ID, RecipientID: reader.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+	before, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Put("/api/v1/nodes/{nodeId}/content", testServer.ReplaceContentHandler)
+	put := func(token, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/nodes/"+fileNode.ID+"/content", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusForbidden, put(readerLogin.AccessToken, "x", nil).Code)
+	require.Equal(t, http.StatusPreconditionFailed, put(ownerLogin.AccessToken, "x", map[string]string{"If-Match": `"stale"`}).Code)
+
+	newContent := "# Nowa, dłuższa treść notatek"
+	newHash := fmt.Sprintf("%x", sha256.Sum256([]byte(newContent)))
+	for hash, key := range map[string]string{"stary-hash": "replace_thumb_old", newHash: "replace_thumb_new"} {
+		require.NoError(t, testServer.storage.Save(key, strings.NewReader("miniatura")))
+		_, err = testServer.store.RegisterDerivedArtifact(ctx, database.RegisterDerivedArtifactParams{
+			NodeID: fileNode.ID, ContentHash: hash, Kind: "thumbnail-64", StorageKey: key, SizeBytes: 9,
+		})
+		require.NoError(t, err)
+	}
+	rr := put(ownerLogin.AccessToken, newContent, map[string]string{"If-Match": contentETag(fileNode), "Content-Type": "text/markdown"})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var updated models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	require.Equal(t, fileNode.ID, updated.ID)
+	require.Equal(t, int64(len(newContent)), *updated.SizeBytes)
+	require.Equal(t, "text/markdown", *updated.MimeType)
+	require.Equal(t, contentETag(&updated), rr.Header().Get("ETag"))
+
+	stream, err := testServer.openNodeContent(ctx, fileNode.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	require.Equal(t, newContent, string(data))
+
+	after, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, before.StorageUsedBytes+int64(len(newContent)-len("stara treść")), after.StorageUsedBytes)
+
+	versions, err := testServer.store.ListNodeVersions(ctx, fileNode.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1, "The previous content is kept as a version")
+
+	stale, err := testServer.store.GetDerivedArtifact(ctx, fileNode.ID, "stary-hash", "thumbnail-64")
+	require.NoError(t, err)
+	require.Nil(t, stale, "Artifacts of the previous content are dropped")
+	_, err = testServer.storage.Get("replace_thumb_old")
+	require.Error(t, err)
+	current, err := testServer.store.GetDerivedArtifact(ctx, fileNode.ID, newHash, "thumbnail-64")
+	require.NoError(t, err)
+	require.NotNil(t, current, "Artifacts of the new content are kept")
+
+	events, err := testServer.store.GetEventsSince(ctx, owner.ID, 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, "node_updated", events[len(events)-1].EventType)
+
+	_, err = testServer.store.GetPool().Exec(ctx, `UPDATE users SET storage_quota_bytes = storage_used_bytes WHERE id = $1`, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, put(ownerLogin.AccessToken, newContent+" i jeszcze więcej", nil).Code)
+}
+
+func TestReplaceContentFailedCommitKeepsContent(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "replace_commit_owner", "password")
+	ownerLogin := loginUserForTest(t, "replace_commit_owner", "password")
+
+	fileNode := createTestNodeAPI(t, "umowa.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("stara treść")))
+	fileNode, err := testServer.store.UpdateNodeContent(ctx, fileNode.ID, owner.ID, int64(len("stara treść")), nil, nil)
+	require.NoError(t, err)
+
+	commitFaults := chaos.New(3, 1, 0, chaos.DBCommit)
+	chaosServer := NewServer(testServer.config.Load(), testServer.store.WithChaos(commitFaults), testServer.storage,
+		testServer.blobs, testServer.wsHub)
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Put("/api/v1/nodes/{nodeId}/content", chaosServer.ReplaceContentHandler)
+	req := httptest.NewRequest("PUT", "/api/v1/nodes/"+fileNode.ID+"/content", strings.NewReader("nowa treść"))
+	req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	stream, err := testServer.openNodeContent(ctx, fileNode.ID)
+	require.NoError(t, err)
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	require.Equal(t, "stara treść", string(data), "A failed commit keeps the previous content in place")
+}
+
+func TestFederatedShares(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "fed_owner", "password")
+	ownerLogin := loginUserForTest(t, "fed_owner", "password")
+	recipient := createTestUserWithPassword(t, "fed_recipient", "password")
+	recipientLogin := loginUserForTest(t, "fed_recipient", "password")
+
+	folder := createTestNodeAPI(t, "Wspólny projekt", "folder", nil, owner.ID)
+	fileNode := createTestNodeAPI(t, "plan.txt", "file", &folder.ID, owner.ID)
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader("plan projektu")))
+	_, err := testServer.store.UpdateNodeContent(ctx, fileNode.ID, owner.ID, int64(len("plan projektu")), nil, nil)
+	require.NoError(t, err)
+	outside := createTestNodeAPI(t, "prywatne.txt", "file", nil, owner.ID)
+
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(r chi.Router) {
+		r.Post("/federation/shares", testServer.ReceiveFederatedShareHandler)
+		r.Delete("/federation/shares/{token}", testServer.RevokeFederatedShareHandler)
+		r.Get("/federation/shares/{token}/nodes", testServer.ListFederatedShareNodesHandler)
+		r.Get("/federation/shares/{token}/download", testServer.DownloadFederatedShareHandler)
+		r.Group(func(r chi.Router) {
+			r.Use(testServer.AuthMiddleware)
+			r.Post("/nodes/{nodeId}/federated-shares", testServer.CreateFederatedShareHandler)
+			r.Get("/federated-shares", testServer.ListFederatedSharesHandler)
+			r.Delete("/federated-shares/{shareId}", testServer.DeleteFederatedShareHandler)
+			r.Get("/remote-shares", testServer.ListRemoteSharesHandler)
+			r.Get("/remote-shares/{shareId}/nodes", testServer.ListRemoteShareNodesHandler)
+			r.Get("/remote-shares/{shareId}/download", testServer.DownloadRemoteShareHandler)
+		})
+	})
+	// The instance is its own peer, so both sides of the protocol run here.
+	instance := httptest.NewServer(router)
+	defer instance.Close()
+
+	previous := testServer.config.Load().Federation
+	testServer.config.Load().Federation = config.FederationConfig{
+		Enabled:      true,
+		InstanceName: "self",
+		Peers:        map[string]config.FederationPeerConfig{"self": {URL: instance.URL + "/api/v1", Secret: "federation_secret"}},
+	}
+	testServer.federation = federation.NewClient("self", time.Minute)
+	defer func() {
+		testServer.config.Load().Federation = previous
+		testServer.federation = nil
+	}()
+
+	do := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, instance.URL+"/api/v1"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do("POST", "/nodes/"+folder.ID+"/federated-shares", ownerLogin.AccessToken, `{"recipient":"nobody@self"}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do("POST", "/nodes/"+folder.ID+"/federated-shares", ownerLogin.AccessToken, `{"recipient":"fed_recipient@elsewhere"}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do("POST", "/nodes/"+folder.ID+"/federated-shares", ownerLogin.AccessToken, `{"recipient":"fed_recipient@self"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var share dto.FederatedShare
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&share))
+	require.Equal(t, "self", share.Peer)
+	require.Equal(t, "fed_recipient", share.Recipient)
+	resp = do("POST", "/nodes/"+folder.ID+"/federated-shares", ownerLogin.AccessToken, `{"recipient":"fed_recipient@self"}`)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = do("GET", "/remote-shares", recipientLogin.AccessToken, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var remoteShares []dto.RemoteShare
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&remoteShares))
+	require.Len(t, remoteShares, 1)
+	require.Equal(t, "fed_owner", remoteShares[0].Owner)
+	require.Equal(t, "Wspólny projekt", remoteShares[0].Name)
+	remoteShareID := strconv.FormatInt(remoteShares[0].ID, 10)
+
+	resp = do("GET", "/remote-shares/"+remoteShareID+"/nodes", recipientLogin.AccessToken, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var nodes []federation.RemoteNode
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&nodes))
+	require.Len(t, nodes, 1)
+	require.Equal(t, fileNode.ID, nodes[0].ID)
+
+	resp = do("GET", "/remote-shares/"+remoteShareID+"/download?node_id="+fileNode.ID, recipientLogin.AccessToken, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	content, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "plan projektu", string(content))
+	require.Contains(t, resp.Header.Get("Content-Disposition"), "plan.txt")
+
+	resp = do("GET", "/remote-shares/"+remoteShareID+"/download?node_id="+outside.ID, recipientLogin.AccessToken, "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, "Nodes outside the share stay private")
+	resp = do("GET", "/remote-shares/"+remoteShareID+"/nodes", ownerLogin.AccessToken, "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var token string
+	require.NoError(t, testServer.store.GetPool().QueryRow(ctx, "SELECT token FROM federated_shares WHERE id = $1", share.ID).Scan(&token))
+	resp = do("GET", "/federation/shares/"+token+"/nodes", "", "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Peer requests must be signed")
+
+	resp = do("DELETE", "/federated-shares/"+strconv.FormatInt(share.ID, 10), ownerLogin.AccessToken, "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	remaining, err := testServer.store.ListRemoteShares(ctx, recipient.ID, 100, 0)
+	require.NoError(t, err)
+	require.Empty(t, remaining)
+
+	events, err := testServer.store.GetEventsSince(ctx, recipient.ID, 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, "remote_share_revoked", events[len(events)-1].EventType)
+}
+
+func TestPreviewFile(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "preview_owner", "password")
+	login := loginUserForTest(t, "preview_owner", "password")
+
+	createFile := func(name, mimeType, content string) *models.Node {
+		node := createTestNodeAPI(t, name, "file", nil, owner.ID)
+		require.NoError(t, testServer.storage.Save(node.ID, strings.NewReader(content)))
+		node, err := testServer.store.UpdateNodeContent(ctx, node.ID, owner.ID, int64(len(content)), &mimeType, nil)
+		require.NoError(t, err)
+		return node
+	}
+	pdf := createFile("Umowa \"końcowa\".pdf", "application/pdf", "%PDF-1.7")
+	page := createFile("strona.html", "text/html", "<script>alert(1)</script>")
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/{nodeId}/preview", testServer.PreviewFileHandler)
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/v1/nodes/" + pdf.ID + "/preview")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "%PDF-1.7", rr.Body.String())
+	require.Equal(t, "application/pdf", rr.Header().Get("Content-Type"))
+	require.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, `inline; filename="Umowa _ko_cowa_.pdf"; filename*=UTF-8''Umowa%20_ko%C5%84cowa_.pdf`, rr.Header().Get("Content-Disposition"))
+
+	require.Equal(t, http.StatusUnsupportedMediaType, get("/api/v1/nodes/"+page.ID+"/preview").Code, "Active content is never rendered inline")
+	rr = get("/api/v1/nodes/" + page.ID + "/download")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, `attachment; filename="strona.html"`, rr.Header().Get("Content-Disposition"))
+	require.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+
+	var action string
+	require.NoError(t, testServer.store.GetPool().QueryRow(ctx,
+		"SELECT action FROM access_log WHERE node_id = $1 ORDER BY id DESC LIMIT 1", pdf.ID).Scan(&action))
+	require.Equal(t, "preview", action)
+}
+
+type fakeDirectory struct {
+	users     []ldap.User
+	groups    []ldap.Group
+	passwords map[string]string
+}
+
+func (d *fakeDirectory) Users(ctx context.Context) ([]ldap.User, error) {
+	return d.users, nil
+}
+
+func (d *fakeDirectory) Groups(ctx context.Context) ([]ldap.Group, error) {
+	return d.groups, nil
+}
+
+func (d *fakeDirectory) Authenticate(ctx context.Context, dn, password string) error {
+	if expected, ok := d.passwords[dn]; ok && password != "" && password == expected {
+		return nil
+	}
+	return ldap.ErrInvalidCredentials
+}
+
+func TestLDAPSync(t *testing.T) {
+	ctx := context.Background()
+	createTestUserWithPassword(t, "ldap_local", "localpassword")
+
+	directory := &fakeDirectory{
+		users: []ldap.User{
+			{DN: "uid=ldap_jan,ou=people,dc=example,dc=com", Username: "ldap_jan", DisplayName: "Jan Kowalski"},
+			{DN: "uid=ldap_anna,ou=people,dc=example,dc=com", Username: "ldap_anna"},
+			{DN: "uid=ldap_local,ou=people,dc=example,dc=com", Username: "ldap_local"},
+		},
+		passwords: map[string]string{"uid=ldap_jan,ou=people,dc=example,dc=com": "haslo-z-ad"},
+	}
+	testServer.directory = directory
+	defer func() { testServer.directory = nil }()
+
+	login := func(username, password string) int {
+		body, _ := json.Marshal(LoginRequest{Username: username, Password: password})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(testServer.LoginHandler).ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/auth/login", bytes.NewReader(body)))
+		return rr.Code
+	}
+
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	jan, err := testServer.store.GetUserByUsername(ctx, "ldap_jan")
+	require.NoError(t, err)
+	require.NotNil(t, jan)
+	require.Equal(t, "Jan Kowalski", *jan.DisplayName)
+	require.Equal(t, http.StatusOK, login("ldap_jan", "haslo-z-ad"))
+	require.Equal(t, http.StatusUnauthorized, login("ldap_jan", ""))
+
+	local, err := testServer.store.GetUserByUsername(ctx, "ldap_local")
+	require.NoError(t, err)
+	require.Nil(t, local.LDAPDN, "A local account is not taken over by a directory entry with the same name")
+	require.Equal(t, http.StatusOK, login("ldap_local", "localpassword"))
+
+	janLogin := loginUserForTest(t, "ldap_jan", "haslo-z-ad")
+	directory.users = []ldap.User{
+		{DN: "UID=ldap_anna,ou=people,dc=example,dc=com", Username: "ldap_anna", DisplayName: "Anna Nowak"},
+	}
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	jan, err = testServer.store.GetUserByID(ctx, jan.ID)
+	require.NoError(t, err)
+	require.NotNil(t, jan.DisabledAt)
+	require.Equal(t, http.StatusUnauthorized, login("ldap_jan", "haslo-z-ad"))
+	anna, err := testServer.store.GetUserByUsername(ctx, "ldap_anna")
+	require.NoError(t, err)
+	require.Equal(t, "Anna Nowak", *anna.DisplayName, "DNs are matched without regard to case")
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/me", testServer.GetCurrentUserHandler)
+	req := httptest.NewRequest("GET", "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+janLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code, "Sessions of disabled accounts end")
+
+	directory.users = nil
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	anna, err = testServer.store.GetUserByUsername(ctx, "ldap_anna")
+	require.NoError(t, err)
+	require.Nil(t, anna.DisabledAt, "An empty directory result disables nobody")
+
+	directory.users = []ldap.User{{DN: "uid=ldap_jan,ou=people,dc=example,dc=com", Username: "ldap_jan"}, {DN: "uid=ldap_anna,ou=people,dc=example,dc=com", Username: "ldap_anna"}}
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	require.Equal(t, http.StatusOK, login("ldap_jan", "haslo-z-ad"), "Accounts back in the directory are enabled again")
+
+	// Groups list members by DN or, like posixGroup's memberUid, by username.
+	directory.groups = []ldap.Group{
+		{DN: "cn=ldap_ksiegowosc,ou=groups,dc=example,dc=com", Name: "Księgowość", Members: []string{"UID=ldap_jan,ou=people,dc=example,dc=com", "uid=nieznany,ou=people,dc=example,dc=com"}},
+		{DN: "cn=ldap_zarzad,ou=groups,dc=example,dc=com", Name: "Zarząd", Members: []string{"ldap_anna", "ldap_jan"}},
+	}
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	groups, err := testServer.store.ListGroupsForUser(ctx, jan.ID)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	require.Equal(t, "Księgowość", groups[0].Name)
+	require.True(t, groups[0].Directory)
+	require.Nil(t, groups[0].OwnerID)
+	require.Equal(t, 1, groups[0].MemberCount, "Entries without a synchronized account are ignored")
+	require.Equal(t, 2, groups[1].MemberCount)
+	accounting := groups[0]
+
+	janLogin = loginUserForTest(t, "ldap_jan", "haslo-z-ad")
+	groupRouter := chi.NewRouter()
+	groupRouter.Use(testServer.AuthMiddleware)
+	groupRouter.Patch("/api/v1/groups/{groupId}", testServer.UpdateGroupHandler)
+	groupRouter.Delete("/api/v1/groups/{groupId}/members/{userId}", testServer.RemoveGroupMemberHandler)
+	for _, call := range []struct{ method, path, body string }{
+		{"PATCH", fmt.Sprintf("/api/v1/groups/%d", accounting.ID), `{"name":"Inna"}`},
+		{"DELETE", fmt.Sprintf("/api/v1/groups/%d/members/%d", accounting.ID, jan.ID), ""},
+	} {
+		req := httptest.NewRequest(call.method, call.path, strings.NewReader(call.body))
+		req.Header.Set("Authorization", "Bearer "+janLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		groupRouter.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusConflict, rr.Code, "Directory groups are managed by the directory")
+	}
+
+	directory.groups = []ldap.Group{
+		{DN: "cn=ldap_ksiegowosc,ou=groups,dc=example,dc=com", Name: "Finanse", Members: []string{"uid=ldap_anna,ou=people,dc=example,dc=com"}},
+	}
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	groups, err = testServer.store.ListGroupsForUser(ctx, jan.ID)
+	require.NoError(t, err)
+	require.Empty(t, groups, "Jan left the renamed group and the group removed from the directory is deleted")
+	groups, err = testServer.store.ListGroupsForUser(ctx, anna.ID)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	require.Equal(t, accounting.ID, groups[0].ID)
+	require.Equal(t, "Finanse", groups[0].Name)
+
+	directory.groups = nil
+	require.NoError(t, testServer.syncDirectoryAccounts(ctx))
+	groups, err = testServer.store.ListGroupsForUser(ctx, anna.ID)
+	require.NoError(t, err)
+	require.Len(t, groups, 1, "An empty directory result deletes no group")
+}
+
+func TestUploadContentTypeDetection(t *testing.T) {
+	user := createTestUserWithPassword(t, "sniff_user", "password")
+	login := loginUserForTest(t, "sniff_user", "password")
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+	upload := func(name, contentType string, content []byte) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, name))
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		part.Write(content)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	uploadedType := func(rr *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var nodes []models.Node
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+		require.Len(t, nodes, 1)
+		require.Equal(t, user.ID, nodes[0].OwnerID)
+		return *nodes[0].MimeType
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	require.Equal(t, "image/png", uploadedType(upload("zdjecie.txt", "text/plain", png)), "The content wins over a wrong declaration")
+	require.Equal(t, "text/csv", uploadedType(upload("dane.csv", "text/csv", []byte("a,b\n1,2\n"))), "A declared type refines plain text")
+	require.Equal(t, "text/plain; charset=utf-8", uploadedType(upload("obraz.png", "image/png", []byte("zwykły tekst"))), "Text cannot pass as an image")
+	docx := "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	require.Equal(t, docx, uploadedType(upload("raport.docx", docx, []byte("PK\x03\x04\x14\x00\x06\x00"))))
+
+	elf := []byte("\x7fELF\x02\x01\x01\x00")
+	require.Equal(t, "application/x-executable", uploadedType(upload("narzedzie", "application/octet-stream", elf)))
+
+	previous := testServer.config.Load().ContentTypes
+	testServer.config.Load().ContentTypes.Blocked = []string{"application/x-executable", "application/vnd.microsoft.portable-executable"}
+	defer func() { testServer.config.Load().ContentTypes = previous }()
+
+	rr := upload("notatki.txt", "text/plain", elf)
+	require.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	require.Contains(t, rr.Body.String(), "application/x-executable")
+
+	testServer.config.Load().ContentTypes.Allowed = []string{"image/*"}
+	require.Equal(t, http.StatusUnsupportedMediaType, upload("dane.csv", "text/csv", []byte("a,b\n")).Code)
+	require.Equal(t, "image/png", uploadedType(upload("zdjecie.png", "image/png", png)))
+}
+
+func TestContentChecksums(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "checksum_owner", "password")
+	login := loginUserForTest(t, "checksum_owner", "password")
+	sum := func(content string) string {
+		digest := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(digest[:])
+	}
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+	router.With(testServer.AuthMiddleware).Put("/api/v1/nodes/{nodeId}/content", testServer.ReplaceContentHandler)
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/{nodeId}/checksum", testServer.GetChecksumHandler)
+	upload := func(name, content, checksum string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, name))
+		if checksum != "" {
+			header.Set(contentSHA256Header, checksum)
+		}
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	call := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	checksum := func(nodeID string) ChecksumResponse {
+		rr := call("GET", "/api/v1/nodes/"+nodeID+"/checksum", "", nil)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response ChecksumResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response
+	}
+
+	rr := upload("umowa.txt", "treść umowy", "")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var nodes []models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+	require.Equal(t, sum("treść umowy"), *nodes[0].ContentSHA256, "The checksum is computed while the file is stored")
+	fileNode := nodes[0]
+
+	rr = upload("zweryfikowany.txt", "treść", strings.ToUpper(sum("treść")))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	before, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	rr = upload("uszkodzony.txt", "treść uszkodzona w drodze", sum("treść"))
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	require.Contains(t, rr.Body.String(), sum("treść"))
+	after, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, before.StorageUsedBytes, after.StorageUsedBytes, "A corrupted file is not stored")
+	require.Equal(t, http.StatusBadRequest, upload("zly.txt", "treść", "xyz").Code)
+
+	response := checksum(fileNode.ID)
+	require.Equal(t, ChecksumResponse{NodeID: fileNode.ID, Algorithm: "sha256", Checksum: sum("treść umowy"), SizeBytes: int64(len("treść umowy"))}, response)
+
+	target := "/api/v1/nodes/" + fileNode.ID + "/content"
+	rr = call("PUT", target, "nowa treść", map[string]string{contentSHA256Header: sum("inna treść")})
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	require.Equal(t, sum("treść umowy"), checksum(fileNode.ID).Checksum, "A rejected replacement leaves the file unchanged")
+
+	rr = call("PUT", target, "nowa treść", map[string]string{contentSHA256Header: sum("nowa treść")})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var updated models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	require.Equal(t, sum("nowa treść"), *updated.ContentSHA256)
+	require.Equal(t, sum("nowa treść"), checksum(fileNode.ID).Checksum)
+
+	legacy := createTestNodeAPI(t, "stary.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(legacy.ID, strings.NewReader("sprzed sum kontrolnych")))
+	require.Nil(t, legacy.ContentSHA256)
+	require.Equal(t, sum("sprzed sum kontrolnych"), checksum(legacy.ID).Checksum, "Missing checksums are computed on request")
+	stored, err := testServer.store.GetNodeByID(ctx, legacy.ID, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, sum("sprzed sum kontrolnych"), *stored.ContentSHA256, "and recorded")
+
+	folder := createTestNodeAPI(t, "katalog", "folder", nil, owner.ID)
+	require.Equal(t, http.StatusBadRequest, call("GET", "/api/v1/nodes/"+folder.ID+"/checksum", "", nil).Code)
+}
+
+func TestContentPolicy(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "policy_owner", "password")
+	createTestUserWithPassword(t, "policy_recipient", "password")
+	admin := createTestUserWithPassword(t, "policy_admin", "password")
+	_, err := testServer.store.GetPool().Exec(ctx, `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	login := loginUserForTest(t, "policy_owner", "password")
+	adminLogin := loginUserForTest(t, "policy_admin", "password")
+
+	policy, err := contentpolicy.NewRegexPolicy([]contentpolicy.Rule{
+		{Name: "wykonywalne", FileName: `\.exe$`, Verdict: contentpolicy.Deny, Reason: "Executables are not allowed"},
+		{Name: "karty", Detector: contentpolicy.DetectorCreditCard, Verdict: contentpolicy.Quarantine, Reason: "The file contains payment card numbers"},
+		{Name: "poufne", Actions: []string{contentpolicy.ActionShare}, Content: `(?i)poufne`, Verdict: contentpolicy.Deny},
+	}, 0)
+	require.NoError(t, err)
+	previous := testServer.contentPolicy
+	testServer.SetContentPolicy(policy)
+	defer func() { testServer.contentPolicy = previous }()
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	router.Post("/api/v1/nodes/{nodeId}/share", testServer.ShareNodeHandler)
+	router.With(testServer.AdminMiddleware).Get("/api/v1/admin/quarantine", testServer.ListQuarantinedNodesHandler)
+	router.With(testServer.AdminMiddleware).Post("/api/v1/admin/quarantine/{nodeId}/release", testServer.ReleaseQuarantinedNodeHandler)
+	upload := func(name, content string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	call := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	share := func(nodeID string) *httptest.ResponseRecorder {
+		return call("POST", "/api/v1/nodes/"+nodeID+"/share", `{"recipient_username":"policy_recipient","permissions":"read"}`, login.AccessToken)
+	}
+
+	before, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	rr := upload("instalator.exe", "zawartość")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Contains(t, rr.Body.String(), "Executables are not allowed")
+	after, err := testServer.store.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, before.StorageUsedBytes, after.StorageUsedBytes, "A denied file is not stored")
+
+	rr = upload("platnosci.txt", "Karta klienta: 4111 1111 1111 1111")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var nodes []models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+	quarantinedFile := nodes[0]
+
+	require.Equal(t, http.StatusForbidden, call("GET", "/api/v1/nodes/"+quarantinedFile.ID+"/download", "", login.AccessToken).Code)
+	require.Equal(t, http.StatusForbidden, share(quarantinedFile.ID).Code)
+	_, err = testServer.copyNodeTree(ctx, owner.ID, &quarantinedFile, nil)
+	var opErr *opError
+	require.ErrorAs(t, err, &opErr)
+	require.Equal(t, http.StatusForbidden, opErr.status)
+
+	rr = call("GET", "/api/v1/admin/quarantine", "", adminLogin.AccessToken)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var quarantined []dto.QuarantinedNode
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &quarantined))
+	require.Len(t, quarantined, 1)
+	require.Equal(t, quarantinedFile.ID, quarantined[0].NodeID)
+	require.Equal(t, "karty", quarantined[0].Rule)
+	require.Equal(t, contentpolicy.ActionUpload, quarantined[0].Action)
+	require.Equal(t, http.StatusForbidden, call("GET", "/api/v1/admin/quarantine", "", login.AccessToken).Code)
+
+	require.Equal(t, http.StatusNoContent, call("POST", "/api/v1/admin/quarantine/"+quarantinedFile.ID+"/release", "", adminLogin.AccessToken).Code)
+	require.Equal(t, http.StatusNotFound, call("POST", "/api/v1/admin/quarantine/"+quarantinedFile.ID+"/release", "", adminLogin.AccessToken).Code)
+	rr = call("GET", "/api/v1/nodes/"+quarantinedFile.ID+"/download", "", login.AccessToken)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "Karta klienta: 4111 1111 1111 1111", rr.Body.String())
+
+	rr = share(quarantinedFile.ID)
+	require.Equal(t, http.StatusForbidden, rr.Code, "Share rules see the content again and quarantine it anew")
+	isQuarantined, err := testServer.isQuarantined(ctx, quarantinedFile.ID)
+	require.NoError(t, err)
+	require.True(t, isQuarantined)
+
+	folder := createTestNodeAPI(t, "Dokumenty", "folder", nil, owner.ID)
+	secret := createTestNodeAPI(t, "raport.txt", "file", &folder.ID, owner.ID)
+	require.NoError(t, testServer.storage.Save(secret.ID, strings.NewReader("Raport POUFNE")))
+	rr = share(folder.ID)
+	require.Equal(t, http.StatusForbidden, rr.Code, "Files inside a shared folder are checked")
+	require.Contains(t, rr.Body.String(), "raport.txt")
+
+	rr = upload("raport2.txt", "Raport poufne")
+	require.Equal(t, http.StatusCreated, rr.Code, "Rules limited to shares do not apply to uploads")
+	plain := createTestNodeAPI(t, "notatki.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(plain.ID, strings.NewReader("lista zakupów")))
+	require.Equal(t, http.StatusCreated, share(plain.ID).Code)
+}
+
+func TestContentDeduplication(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "dedup_owner", "password")
+	createTestUserWithPassword(t, "dedup_other", "password")
+	login := loginUserForTest(t, "dedup_owner", "password")
+	otherLogin := loginUserForTest(t, "dedup_other", "password")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	router.Delete("/api/v1/trash/purge", testServer.PurgeTrashHandler)
+	upload := func(token, name, content string) models.Node {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var nodes []models.Node
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &nodes))
+		return nodes[0]
+	}
+	download := func(nodeID string) string {
+		req := httptest.NewRequest("GET", "/api/v1/nodes/"+nodeID+"/download", nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		return rr.Body.String()
+	}
+	storageKey := func(nodeID string) string {
+		_, key, err := testServer.store.GetNodeStorageBackend(ctx, nodeID)
+		require.NoError(t, err)
+		return key
+	}
+	blobExists := func(key string) bool {
+		blob, err := testServer.storage.Get(key)
+		if err != nil {
+			return false
+		}
+		blob.Close()
+		return true
+	}
+	purge := func() {
+		req := httptest.NewRequest("DELETE", "/api/v1/trash/purge", nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	content := "raport kwartalny, wersja ostateczna"
+	first := upload(login.AccessToken, "raport.txt", content)
+	second := upload(login.AccessToken, "raport-kopia.txt", content)
+	foreign := upload(otherLogin.AccessToken, "raport.txt", content)
+	key := storageKey(first.ID)
+	require.Equal(t, contentBlobKey(*first.ContentSHA256), key)
+	require.Equal(t, key, storageKey(second.ID), "Identical uploads share one blob")
+	require.Equal(t, key, storageKey(foreign.ID), "also across users")
+	require.True(t, blobExists(key))
+	require.False(t, blobExists(first.ID), "No copy is kept under the node ID")
+	require.False(t, blobExists(second.ID))
+
+	copies, err := testServer.copyNodeTree(ctx, owner.ID, &first, nil)
+	require.NoError(t, err)
+	require.Equal(t, key, storageKey(copies[0].ID), "Copies share the blob of their source")
+	require.Equal(t, content, download(copies[0].ID))
+
+	updated := replaceTestContent(t, &second, owner.ID, "zmieniona treść")
+	require.NotEqual(t, key, storageKey(updated.ID))
+	require.Equal(t, "zmieniona treść", download(second.ID))
+	require.Equal(t, content, download(first.ID), "Replacing content leaves files sharing the old blob intact")
+	version, err := testServer.store.GetNodeVersion(ctx, second.ID, 1)
+	require.NoError(t, err)
+	require.NotNil(t, version)
+	archived, err := testServer.storage.Get(version.StorageKey)
+	require.NoError(t, err)
+	archivedContent, _ := io.ReadAll(archived)
+	archived.Close()
+	require.Equal(t, content, string(archivedContent), "The previous content is archived as a version")
+
+	for _, id := range []string{first.ID, copies[0].ID} {
+		_, err := testServer.store.MoveNodeToTrash(ctx, id, owner.ID)
+		require.NoError(t, err)
+	}
+	purge()
+	require.True(t, blobExists(key), "The blob is kept while another user's file references it")
+
+	_, err = testServer.store.MoveNodeToTrash(ctx, foreign.ID, foreign.OwnerID)
+	require.NoError(t, err)
+	req := httptest.NewRequest("DELETE", "/api/v1/trash/purge", nil)
+	req.Header.Set("Authorization", "Bearer "+otherLogin.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.False(t, blobExists(key), "The blob is removed with the last file referencing it")
+}
+
+func TestOfflinePins(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "offline_owner", "password")
+	stranger := createTestUserWithPassword(t, "offline_stranger", "password")
+	login := loginUserForTest(t, "offline_owner", "password")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/offline", testServer.PinNodeOfflineHandler)
+	router.Delete("/api/v1/nodes/{nodeId}/offline", testServer.UnpinNodeOfflineHandler)
+	router.Get("/api/v1/offline-pins", testServer.ListOfflinePinsHandler)
+	do := func(method, url, deviceID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		if deviceID != "" {
+			req.Header.Set(deviceIDHeader, deviceID)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	list := func(url, deviceID string) []dto.OfflinePin {
+		rr := do("GET", url, deviceID)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var pins []dto.OfflinePin
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pins))
+		return pins
+	}
+
+	report := createTestNodeAPI(t, "raport_offline.txt", "file", nil, owner.ID)
+	photos := createTestNodeAPI(t, "Zdjecia", "folder", nil, owner.ID)
+	foreign := createTestNodeAPI(t, "cudzy.txt", "file", nil, stranger.ID)
+
+	rr := do("POST", "/api/v1/nodes/"+report.ID+"/offline", "laptop")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	rr = do("POST", "/api/v1/nodes/"+report.ID+"/offline", "laptop")
+	require.Equal(t, http.StatusNoContent, rr.Code, "Pinning again is a no-op")
+	rr = do("POST", "/api/v1/nodes/"+photos.ID+"/offline", "")
+	require.Equal(t, http.StatusNoContent, rr.Code, "Without the header the session is the device")
+	rr = do("POST", "/api/v1/nodes/"+foreign.ID+"/offline", "laptop")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr = do("POST", "/api/v1/nodes/"+report.ID+"/offline", strings.Repeat("x", maxDeviceIDLength+1))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	laptopPins := list("/api/v1/offline-pins", "laptop")
+	require.Len(t, laptopPins, 1)
+	require.Equal(t, report.ID, laptopPins[0].ID)
+	require.Equal(t, "laptop", laptopPins[0].DeviceID)
+	sessionPins := list("/api/v1/offline-pins", "")
+	require.Len(t, sessionPins, 1)
+	require.Equal(t, photos.ID, sessionPins[0].ID)
+	require.Len(t, list("/api/v1/offline-pins?device=all", "laptop"), 2)
+	require.Len(t, list("/api/v1/offline-pins?device=laptop", ""), 1)
+
+	events, err := testServer.store.GetEventsSince(ctx, owner.ID, 0, 100)
+	require.NoError(t, err)
+	added := 0
+	for _, event := range events {
+		if event.EventType == "offline_pin_added" {
+			added++
+		}
+	}
+	require.Equal(t, 2, added, "Each new pin is journaled once")
+
+	_, err = testServer.store.MoveNodeToTrash(ctx, photos.ID, owner.ID)
+	require.NoError(t, err)
+	require.Empty(t, list("/api/v1/offline-pins", ""), "Trashed nodes are not listed")
+
+	rr = do("DELETE", "/api/v1/nodes/"+report.ID+"/offline", "laptop")
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	rr = do("DELETE", "/api/v1/nodes/"+report.ID+"/offline", "laptop")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Empty(t, list("/api/v1/offline-pins", "laptop"))
+}
+
+func TestThumbnails(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "thumbnail_owner", "password")
+	login := loginUserForTest(t, "thumbnail_owner", "password")
+
+	createFile := func(name, mimeType string, content []byte) *models.Node {
+		node := createTestNodeAPI(t, name, "file", nil, owner.ID)
+		require.NoError(t, testServer.storage.Save(node.ID, bytes.NewReader(content)))
+		node, err := testServer.store.UpdateNodeContent(ctx, node.ID, owner.ID, int64(len(content)), &mimeType, nil)
+		require.NoError(t, err)
+		return node
+	}
+	picture := image.NewNRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			picture.SetNRGBA(x, y, color.NRGBA{R: 200, G: 30, B: 30, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, picture))
+	photo := createFile("zdjecie.png", "image/png", encoded.Bytes())
+	notes := createFile("notatki.txt", "text/plain", []byte("to nie jest obraz"))
+	foreign := createTestNodeAPI(t, "cudze.png", "file", nil, createTestUserWithPassword(t, "thumbnail_stranger", "password").ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/thumbnail", testServer.GetThumbnailHandler)
+	router.Post("/api/v1/thumbnails/batch", testServer.ThumbnailBatchHandler)
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/api/v1/nodes/"+photo.ID+"/thumbnail?size=128", nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+	thumbnail, err := jpeg.Decode(bytes.NewReader(rr.Body.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 128, 64), thumbnail.Bounds(), "The thumbnail keeps the proportions")
+	r, _, _, _ := thumbnail.At(64, 32).RGBA()
+	require.InDelta(t, 200, r>>8, 10)
+
+	artifact, err := testServer.store.GetDerivedArtifact(ctx, photo.ID, "", "thumbnail-128")
+	require.NoError(t, err)
+	require.NotNil(t, artifact, "The thumbnail is kept for later requests, under the content it was made of")
+	rr = do("GET", "/api/v1/nodes/"+photo.ID+"/thumbnail?size=128", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	require.Equal(t, http.StatusUnsupportedMediaType, do("GET", "/api/v1/nodes/"+notes.ID+"/thumbnail", nil).Code)
+	require.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/nodes/"+photo.ID+"/thumbnail?size=100", nil).Code)
+	require.Equal(t, http.StatusNotFound, do("GET", "/api/v1/nodes/"+foreign.ID+"/thumbnail", nil).Code)
+
+	body, _ := json.Marshal(ThumbnailBatchRequest{NodeIDs: []string{photo.ID, notes.ID, foreign.ID, photo.ID}, Size: 64})
+	rr = do("POST", "/api/v1/thumbnails/batch", body)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	require.Equal(t, notes.ID+","+foreign.ID, rr.Header().Get("X-Thumbnails-Missing"))
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, archive.File, 1)
+	require.Equal(t, photo.ID+".jpg", archive.File[0].Name)
+	entry, err := archive.File[0].Open()
+	require.NoError(t, err)
+	small, err := jpeg.Decode(entry)
+	entry.Close()
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 64, 32), small.Bounds())
+
+	tooMany := make([]string, maxThumbnailBatch+1)
+	for i := range tooMany {
+		tooMany[i] = photo.ID
+	}
+	body, _ = json.Marshal(ThumbnailBatchRequest{NodeIDs: tooMany})
+	require.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/thumbnails/batch", body).Code)
+}
+
+func TestExtractArchive(t *testing.T) {
+	ctx := context.Background()
+	user := createTestUserWithPassword(t, "extract_user", "password")
+	login := loginUserForTest(t, "extract_user", "password")
+	folder := createTestNodeAPI(t, "Pobrane", "folder", nil, user.ID)
+
+	createFile := func(name string, content []byte) *models.Node {
+		node := createTestNodeAPI(t, name, "file", &folder.ID, user.ID)
+		require.NoError(t, testServer.storage.Save(node.ID, bytes.NewReader(content)))
+		mimeType := "application/octet-stream"
+		node, err := testServer.store.UpdateNodeContent(ctx, node.ID, user.ID, int64(len(content)), &mimeType, nil)
+		require.NoError(t, err)
+		return node
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{"zdjecia/lato.txt": "lato", "zdjecia/zima.txt": "zima", "opis.txt": "opis"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	archive := createFile("wakacje.tar.gz", compressed.Bytes())
+	notes := createFile("notatki.txt", []byte("to nie jest archiwum"))
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/extract", testServer.ExtractArchiveHandler)
+	extract := func(nodeID string, req ExtractArchiveRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest("POST", "/api/v1/nodes/"+nodeID+"/extract", bytes.NewReader(body))
+		httpReq.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httpReq)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, extract(notes.ID, ExtractArchiveRequest{}).Code)
+	require.Equal(t, http.StatusBadRequest, extract(folder.ID, ExtractArchiveRequest{}).Code)
+
+	rr := extract(archive.ID, ExtractArchiveRequest{})
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	var job dto.ArchiveImport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+	require.Equal(t, archiveFormatTarGz, job.Format)
+	require.Equal(t, &archive.ID, job.SourceNodeID)
+	require.Equal(t, 3, job.TotalEntries)
+
+	require.NoError(t, testServer.processArchiveImports(ctx))
+	finished, err := testServer.store.GetArchiveImport(ctx, job.ID, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, database.ArchiveImportCompleted, finished.Status, finished.Error)
+	photos, err := testServer.store.GetChildFolderByName(ctx, user.ID, &folder.ID, "zdjecia")
+	require.NoError(t, err)
+	require.NotNil(t, photos, "The archive is extracted next to itself")
+	extracted, err := testServer.store.GetNodesByParentID(ctx, user.ID, &photos.ID, MaxLimit, 0)
+	require.NoError(t, err)
+	require.Len(t, extracted, 2)
+
+	testServer.config.Load().Limits = config.LimitsConfig{MaxExtractEntries: 2}
+	defer func() { testServer.config.Load().Limits = config.LimitsConfig{} }()
+	require.Equal(t, http.StatusUnprocessableEntity, extract(archive.ID, ExtractArchiveRequest{}).Code, "Too many entries")
+	testServer.config.Load().Limits = config.LimitsConfig{}
+
+	var bomb bytes.Buffer
+	zw := zip.NewWriter(&bomb)
+	entry, err := zw.Create("zera.bin")
+	require.NoError(t, err)
+	_, err = entry.Write(make([]byte, minExtractLimitBytes+1))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	bombNode := createFile("bomba.zip", bomb.Bytes())
+	rr = extract(bombNode.ID, ExtractArchiveRequest{})
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+}
+
+func TestPrepareUpload(t *testing.T) {
+	ctx := context.Background()
+	createTestUserWithPassword(t, "prepare_owner", "password")
+	createTestUserWithPassword(t, "prepare_other", "password")
+	login := loginUserForTest(t, "prepare_owner", "password")
+	otherLogin := loginUserForTest(t, "prepare_other", "password")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+	router.Post("/api/v1/nodes/file/prepare", testServer.PrepareUploadHandler)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	prepare := func(token string, req PrepareUploadRequest) (*httptest.ResponseRecorder, PrepareUploadResponse) {
+		body, _ := json.Marshal(req)
+		httpReq := httptest.NewRequest("POST", "/api/v1/nodes/file/prepare", bytes.NewReader(body))
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httpReq)
+		var resp PrepareUploadResponse
+		if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		}
+		return rr, resp
+	}
+
+	content := "nagranie z zebrania zarzadu"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	rr, resp := prepare(login.AccessToken, PrepareUploadRequest{FileName: "zebranie.txt", SizeBytes: int64(len(content)), SHA256: checksum})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.True(t, resp.UploadRequired, "Unknown content has to be uploaded")
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "zebranie.txt")
+	require.NoError(t, err)
+	part.Write([]byte(content))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	rr, resp = prepare(login.AccessToken, PrepareUploadRequest{FileName: "zebranie-kopia.txt", SizeBytes: int64(len(content)), SHA256: strings.ToUpper(checksum)})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	require.False(t, resp.UploadRequired)
+	require.NotNil(t, resp.Node)
+	require.Equal(t, "zebranie-kopia.txt", resp.Node.Name)
+	require.Equal(t, checksum, *resp.Node.SHA256)
+
+	req = httptest.NewRequest("GET", "/api/v1/nodes/"+resp.Node.ID+"/download", nil)
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, content, rr.Body.String())
+	_, key, err := testServer.store.GetNodeStorageBackend(ctx, resp.Node.ID)
+	require.NoError(t, err)
+	require.Equal(t, contentBlobKey(checksum), key, "The file shares the stored content")
+
+	rr, _ = prepare(login.AccessToken, PrepareUploadRequest{FileName: "zebranie-kopia.txt", SizeBytes: int64(len(content)), SHA256: checksum})
+	require.Equal(t, http.StatusConflict, rr.Code)
+	rr, resp = prepare(login.AccessToken, PrepareUploadRequest{FileName: "inny-rozmiar.txt", SizeBytes: int64(len(content)) + 1, SHA256: checksum})
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, resp.UploadRequired, "The size has to match as well")
+	rr, resp = prepare(otherLogin.AccessToken, PrepareUploadRequest{FileName: "zebranie.txt", SizeBytes: int64(len(content)), SHA256: checksum})
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, resp.UploadRequired, "Another user's content is never used")
+	rr, _ = prepare(login.AccessToken, PrepareUploadRequest{FileName: "zly.txt", SizeBytes: 1, SHA256: "abc"})
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDownloadArchiveTarFormats(t *testing.T) {
+	user := createTestUserWithPassword(t, "archive_tar_user", "password")
+	loginResp := loginUserForTest(t, "archive_tar_user", "password")
+
+	folder := createTestNodeAPI(t, "Projekt", "folder", nil, user.ID)
+	file := createTestNodeAPI(t, "notatki.txt", "file", &folder.ID, user.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("content")))
+	mimeType := "text/plain"
+	_, err := testServer.store.UpdateNodeContent(context.Background(), file.ID, user.ID, int64(len("content")), &mimeType, nil)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/archive", testServer.DownloadArchiveHandler)
+	download := func(format string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/nodes/archive?ids=%s&manifest=true&format=%s", folder.ID, format), nil)
+		req.Header.Set("Authorization", "Bearer "+loginResp.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	readTar := func(content io.Reader) map[string][]byte {
+		entries := make(map[string][]byte)
+		tarReader := tar.NewReader(content)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return entries
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(tarReader)
+			require.NoError(t, err)
+			entries[header.Name] = data
+		}
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		rr := download("tar")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "application/x-tar", rr.Header().Get("Content-Type"))
+		require.Contains(t, rr.Header().Get("Content-Disposition"), "archive.tar")
+
+		entries := readTar(rr.Body)
+		require.Len(t, entries, 3, "Folder, file and manifest are expected")
+		require.Contains(t, entries, "Projekt/")
+		require.Equal(t, "content", string(entries["Projekt/notatki.txt"]))
+		var manifest ArchiveManifest
+		require.NoError(t, json.Unmarshal(entries["manifest.json"], &manifest))
+		require.Len(t, manifest.Entries, 2)
+		require.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", manifest.Entries[1].SHA256)
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		rr := download("tar.gz")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "application/gzip", rr.Header().Get("Content-Type"))
+
+		gzipReader, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		entries := readTar(gzipReader)
+		require.Equal(t, "content", string(entries["Projekt/notatki.txt"]))
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, download("rar").Code)
+	})
+}
+
+func TestPreflight(t *testing.T) {
+	owner := createTestUserWithPassword(t, "preflight_owner", "password")
+	ownerToken := loginUserForTest(t, "preflight_owner", "password").AccessToken
+	createTestUserWithPassword(t, "preflight_other", "password")
+	otherToken := loginUserForTest(t, "preflight_other", "password").AccessToken
+
+	folder := createTestNodeAPI(t, "Cel", "folder", nil, owner.ID)
+	createTestNodeAPI(t, "zajety.txt", "file", &folder.ID, owner.ID)
+	parent := createTestNodeAPI(t, "Rodzic", "folder", nil, owner.ID)
+	child := createTestNodeAPI(t, "Dziecko", "folder", &parent.ID, owner.ID)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/preflight", testServer.PreflightHandler)
+	preflight := func(token string, body PreflightRequest) (int, PreflightResponse) {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/v1/nodes/preflight", bytes.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var resp PreflightResponse
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		}
+		return rr.Code, resp
+	}
+	codes := func(resp PreflightResponse) []string {
+		var result []string
+		for _, blocker := range resp.Blockers {
+			result = append(result, blocker.Code)
+		}
+		return result
+	}
+
+	t.Run("upload that fits", func(t *testing.T) {
+		status, resp := preflight(ownerToken, PreflightRequest{Operation: "upload", ParentID: &folder.ID, FileName: "nowy.txt", SizeBytes: 10})
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, resp.OK)
+		require.Empty(t, resp.Blockers)
+	})
+
+	t.Run("all blockers of an upload at once", func(t *testing.T) {
+		status, resp := preflight(ownerToken, PreflightRequest{Operation: "upload", ParentID: &folder.ID, FileName: "zajety.txt", SizeBytes: 1 << 50})
+		require.Equal(t, http.StatusOK, status)
+		require.False(t, resp.OK)
+		require.ElementsMatch(t, []string{blockerQuotaExceeded, blockerNameConflict}, codes(resp))
+	})
+
+	t.Run("upload into a folder of another user", func(t *testing.T) {
+		_, resp := preflight(otherToken, PreflightRequest{Operation: "upload", ParentID: &folder.ID, SizeBytes: 10})
+		require.Equal(t, []string{blockerNotFound}, codes(resp))
+	})
+
+	t.Run("circular move", func(t *testing.T) {
+		_, resp := preflight(ownerToken, PreflightRequest{Operation: "move", NodeID: parent.ID, ParentID: &child.ID})
+		require.Equal(t, []string{blockerCircularMove}, codes(resp))
+	})
+
+	t.Run("move to the root", func(t *testing.T) {
+		root := "root"
+		_, resp := preflight(ownerToken, PreflightRequest{Operation: "move", NodeID: child.ID, ParentID: &root})
+		require.True(t, resp.OK, "Blockers: %v", resp.Blockers)
+	})
+
+	t.Run("move onto a taken name", func(t *testing.T) {
+		createTestNodeAPI(t, "Dziecko", "folder", &folder.ID, owner.ID)
+		_, resp := preflight(ownerToken, PreflightRequest{Operation: "move", NodeID: child.ID, ParentID: &folder.ID})
+		require.Equal(t, []string{blockerNameConflict}, codes(resp))
+	})
+
+	t.Run("invalid operation", func(t *testing.T) {
+		status, _ := preflight(ownerToken, PreflightRequest{Operation: "delete"})
+		require.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+func TestImportURL(t *testing.T) {
+	user := createTestUserWithPassword(t, "url_import_user", "password")
+	login := loginUserForTest(t, "url_import_user", "password")
+	folder := createTestNodeAPI(t, "Pobrane", "folder", nil, user.ID)
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/raport":
+			w.Header().Set("Content-Disposition", `attachment; filename="raport.txt"`)
+			w.Write([]byte("zawartość raportu"))
+		case "/przekierowanie":
+			http.Redirect(w, r, "/raport", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/import", testServer.ImportURLHandler)
+	router.Get("/api/v1/url-imports/{importId}", testServer.GetURLImportHandler)
+	startImport := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/nodes/import", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	runImport := func(body string) dto.URLImport {
+		rr := startImport(body)
+		require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+		var job dto.URLImport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		require.Equal(t, database.URLImportQueued, job.Status)
+
+		require.NoError(t, testServer.processURLImports(context.Background()))
+
+		req := httptest.NewRequest("GET", "/api/v1/url-imports/"+job.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		return job
+	}
+
+	t.Run("private networks are refused by default", func(t *testing.T) {
+		job := runImport(fmt.Sprintf(`{"url":"%s/raport","parent_id":"%s"}`, remote.URL, folder.ID))
+		require.Equal(t, database.URLImportFailed, job.Status)
+		require.NotNil(t, job.Error)
+		require.Contains(t, *job.Error, "private network")
+		require.Nil(t, job.NodeID)
+	})
+
+	previous := testServer.config.Load().URLImport
+	defer func() { testServer.config.Load().URLImport = previous }()
+	testServer.config.Load().URLImport.AllowPrivateNetworks = true
+
+	t.Run("file is fetched into the folder", func(t *testing.T) {
+		job := runImport(fmt.Sprintf(`{"url":"%s/przekierowanie","parent_id":"%s"}`, remote.URL, folder.ID))
+		require.Equal(t, database.URLImportCompleted, job.Status, "Error: %v", job.Error)
+		require.NotNil(t, job.NodeID)
+		require.Equal(t, int64(len("zawartość raportu")), *job.SizeBytes)
+
+		node, err := testServer.store.GetNodeByID(context.Background(), *job.NodeID, user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "raport.txt", node.Name, "The name comes from Content-Disposition")
+		require.Equal(t, folder.ID, *node.ParentID)
+		content, err := testServer.openNodeContent(context.Background(), node.ID)
+		require.NoError(t, err)
+		defer content.Close()
+		data, _ := io.ReadAll(content)
+		require.Equal(t, "zawartość raportu", string(data))
+	})
+
+	t.Run("requested name and failed download", func(t *testing.T) {
+		job := runImport(fmt.Sprintf(`{"url":"%s/raport","parent_id":"%s","file_name":"kopia.txt"}`, remote.URL, folder.ID))
+		require.Equal(t, database.URLImportCompleted, job.Status)
+		node, err := testServer.store.GetNodeByID(context.Background(), *job.NodeID, user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "kopia.txt", node.Name)
+
+		job = runImport(fmt.Sprintf(`{"url":"%s/brak"}`, remote.URL))
+		require.Equal(t, database.URLImportFailed, job.Status)
+		require.Contains(t, *job.Error, "404")
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		testServer.config.Load().URLImport.MaxSizeMB = 1
+		defer func() { testServer.config.Load().URLImport.MaxSizeMB = previous.MaxSizeMB }()
+		big := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(bytes.Repeat([]byte("x"), 2<<20))
+		}))
+		defer big.Close()
+
+		job := runImport(fmt.Sprintf(`{"url":"%s/duzy.bin"}`, big.URL))
+		require.Equal(t, database.URLImportFailed, job.Status)
+		require.Contains(t, *job.Error, "larger than")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, startImport(`{"url":"ftp://example.com/plik"}`).Code)
+		require.Equal(t, http.StatusBadRequest, startImport(`{"url":"https://example.com/plik","file_name":"a/b"}`).Code)
+	})
+}
+
+func TestOutgoingShareStats(t *testing.T) {
+	sharer := createTestUserWithPassword(t, "share_stats_sharer", "password")
+	anna := createTestUserWithPassword(t, "share_stats_anna", "password")
+	piotr := createTestUserWithPassword(t, "share_stats_piotr", "password")
+	sharerLogin := loginUserForTest(t, "share_stats_sharer", "password")
+	ctx := context.Background()
+
+	folder := createTestNodeAPI(t, "Projekt", "folder", nil, sharer.ID)
+	nested := createTestNodeAPI(t, "plan.txt", "file", &folder.ID, sharer.ID)
+	loose := createTestNodeAPI(t, "umowa.pdf", "file", nil, sharer.ID)
+	createTestNodeAPI(t, "prywatny.txt", "file", nil, sharer.ID)
+	require.NoError(t, testServer.store.UpdateUserStorage(ctx, sharer.ID, 4*1234))
+
+	for _, share := range []database.ShareNodeParams{
+		{NodeID: folder.ID, SharerID: sharer.ID, RecipientID: anna.ID, Permissions: "write"},
+		{NodeID: nested.ID, SharerID: sharer.ID, RecipientID: piotr.ID, Permissions: "read"},
+		{NodeID: loose.ID, SharerID: sharer.ID, RecipientID: anna.ID, Permissions: "read"},
+	} {
+		_, err := testServer.store.ShareNode(ctx, share)
+		require.NoError(t, err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/shares/outgoing", testServer.ListOutgoingSharesHandler)
+	router.Get("/api/v1/shares/outgoing/stats", testServer.GetOutgoingShareStatsHandler)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+sharerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	stats := func(query string) OutgoingShareStatsResponse {
+		rr := get("/api/v1/shares/outgoing/stats" + query)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp OutgoingShareStatsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	all := stats("")
+	require.Equal(t, int64(3), all.Shares)
+	require.Equal(t, int64(3), all.SharedNodes)
+	require.Equal(t, int64(2), all.Recipients)
+	require.Equal(t, int64(2), all.SharedFiles, "A file reached by two shares is counted once")
+	require.Equal(t, int64(2*1234), all.SharedBytes)
+	require.Equal(t, int64(4*1234), all.StorageUsedBytes)
+	require.Equal(t, 50.0, all.SharedPercent)
+
+	annaOnly := stats("?recipient=share_stats_anna")
+	require.Equal(t, int64(2), annaOnly.Shares)
+	require.Equal(t, int64(1), annaOnly.Recipients)
+
+	readOnly := stats("?permission=read")
+	require.Equal(t, int64(2), readOnly.Shares)
+	require.Equal(t, int64(2*1234), readOnly.SharedBytes)
+
+	rr := get("/api/v1/shares/outgoing?permission=write")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var shares []database.OutgoingShare
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shares))
+	require.Len(t, shares, 1)
+	require.Equal(t, folder.ID, shares[0].NodeID)
+
+	require.Equal(t, http.StatusBadRequest, get("/api/v1/shares/outgoing/stats?permission=admin").Code)
+}
+
+func TestUploadProgressEvents(t *testing.T) {
+	createTestUserWithPassword(t, "upload_progress_user", "password")
+	login := loginUserForTest(t, "upload_progress_user", "password")
+
+	server := httptest.NewServer(http.HandlerFunc(testServer.ServeWsHandler))
+	defer server.Close()
+	conn, _, err := gorillaws.DefaultDialer.Dial(fmt.Sprintf("ws%s?token=%s&since=0", strings.TrimPrefix(server.URL, "http"), login.AccessToken), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var frame struct {
+		EventType string                 `json:"event_type"`
+		Payload   map[string]interface{} `json:"payload"`
+	}
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "catch_up", frame.EventType, "The client is registered once catch-up arrives")
+	nextUploadEvent := func() {
+		for {
+			require.NoError(t, conn.ReadJSON(&frame))
+			if strings.HasPrefix(frame.EventType, "upload_") {
+				return
+			}
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/file/sessions", testServer.CreateUploadSessionHandler)
+	router.Patch("/api/v1/uploads/{uploadId}", testServer.UploadChunkHandler)
+	router.Post("/api/v1/uploads/{uploadId}/complete", testServer.CompleteUploadSessionHandler)
+	call := func(method, url string, headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	content := "pierwsza czesc|druga czesc"
+	body, _ := json.Marshal(CreateUploadSessionRequest{FileName: "postep.txt", TotalSize: int64(len(content))})
+	rr := call("POST", "/api/v1/nodes/file/sessions", nil, body)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var session models.UploadSession
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &session))
+	uploadURL := fmt.Sprintf("/api/v1/uploads/%s", session.ID)
+
+	nextUploadEvent()
+	require.Equal(t, "upload_started", frame.EventType)
+	require.Equal(t, session.ID.String(), frame.Payload["upload_id"])
+	require.EqualValues(t, 0, frame.Payload["received_bytes"])
+
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "0"}, []byte(content[:15]))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = call("PATCH", uploadURL, map[string]string{"Upload-Offset": "15"}, []byte(content[15:]))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	nextUploadEvent()
+	require.Equal(t, "upload_progress", frame.EventType, "The first chunk is throttled, the last one is always reported")
+	require.EqualValues(t, len(content), frame.Payload["received_bytes"])
+
+	rr = call("POST", uploadURL+"/complete", nil, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var node models.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &node))
+
+	nextUploadEvent()
+	require.Equal(t, "upload_finished", frame.EventType)
+	require.Equal(t, uploadOutcomeCompleted, frame.Payload["outcome"])
+	require.Equal(t, node.ID, frame.Payload["node_id"])
+}
+
+func TestUploadLimits(t *testing.T) {
+	createTestUserWithPassword(t, "upload_limits_user", "password")
+	login := loginUserForTest(t, "upload_limits_user", "password")
+
+	storageConfig := testServer.config.Load().Storage
+	defer func() { testServer.config.Load().Storage = storageConfig }()
+	testServer.config.Load().Storage.MaxRequestSizeMB = 2
+	testServer.config.Load().Storage.MaxFileSizeMB = 1
+	testServer.config.Load().Storage.MaxFilesPerRequest = 2
+
+	upload := func(sizes ...int) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		for i, size := range sizes {
+			part, err := writer.CreateFormFile("file", fmt.Sprintf("limit_%d.bin", i))
+			require.NoError(t, err)
+			part.Write(bytes.Repeat([]byte("x"), size))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router := chi.NewRouter()
+		router.Use(testServer.AuthMiddleware)
+		router.Post("/api/v1/nodes/file", testServer.UploadFileHandler)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	violated := func(rr *httptest.ResponseRecorder) UploadLimitError {
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+		var limitErr UploadLimitError
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &limitErr))
+		return limitErr
+	}
+
+	limitErr := violated(upload(10, 10, 10))
+	require.Equal(t, uploadLimitFilesPerRequest, limitErr.Limit)
+	require.EqualValues(t, 2, limitErr.Max)
+	require.EqualValues(t, 3, limitErr.Actual)
+
+	limitErr = violated(upload(10, 1<<20+1))
+	require.Equal(t, uploadLimitFileSize, limitErr.Limit)
+	require.Equal(t, "limit_1.bin", limitErr.File)
+	require.EqualValues(t, 1<<20, limitErr.Max)
+
+	limitErr = violated(upload(1<<20, 1<<20, 1))
+	require.Equal(t, uploadLimitRequestSize, limitErr.Limit, "The request limit is checked before the files are counted")
+	require.Greater(t, limitErr.Actual, int64(2<<20), "The declared Content-Length is reported")
+
+	rr := upload(10, 1<<20)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+}
+
+func TestShareReviewReminders(t *testing.T) {
+	ctx := context.Background()
+	sharer := createTestUserWithPassword(t, "share_review_sharer", "password")
+	login := loginUserForTest(t, "share_review_sharer", "password")
+	recipient := createTestUserWithPassword(t, "share_review_recipient", "password")
+	usedFolder := createTestNodeAPI(t, "Uzywany", "folder", nil, sharer.ID)
+	usedFile := createTestNodeAPI(t, "uzywany.txt", "file", &usedFolder.ID, sharer.ID)
+	forgottenFile := createTestNodeAPI(t, "zapomniany.txt", "file", nil, sharer.ID)
+
+	shareIDs := map[string]int64{}
+	for _, node := range []*models.Node{usedFolder, forgottenFile} {
+		share, err := testServer.store.ShareNode(ctx, database.ShareNodeParams{
+			NodeID: node.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "read",
+		})
+		require.NoError(t, err)
+		shareIDs[node.ID] = share.ID
+	}
+	_, err := testServer.store.GetPool().Exec(ctx, `UPDATE shares SET shared_at = NOW() - INTERVAL '1 year' WHERE sharer_id = $1`, sharer.ID)
+	require.NoError(t, err)
+	require.NoError(t, testServer.store.LogAccess(ctx, database.LogAccessParams{
+		UserID: recipient.ID, NodeID: usedFile.ID, OwnerID: sharer.ID, Action: "download",
+	}))
+
+	shareReview := testServer.config.Load().ShareReview
+	defer func() { testServer.config.Load().ShareReview = shareReview }()
+	testServer.config.Load().ShareReview = config.ShareReviewConfig{Enabled: true, MaxAgeDays: 180, InactiveDays: 30, ReminderIntervalDays: 30}
+
+	reminders := func() []database.Event {
+		events, err := testServer.store.GetEventsSince(ctx, sharer.ID, 0, 100)
+		require.NoError(t, err)
+		var found []database.Event
+		for _, event := range events {
+			if event.EventType == "share_review_reminder" {
+				found = append(found, event)
+			}
+		}
+		return found
+	}
+
+	require.NoError(t, testServer.sendShareReviewReminders(ctx))
+	found := reminders()
+	require.Len(t, found, 1)
+	var payload struct {
+		ShareIDs []int64               `json:"share_ids"`
+		Shares   []database.StaleShare `json:"shares"`
+	}
+	require.NoError(t, json.Unmarshal(found[0].Payload, &payload))
+	require.Equal(t, []int64{shareIDs[forgottenFile.ID]}, payload.ShareIDs, "Activity below a shared folder keeps its share fresh")
+	require.Equal(t, "share_review_recipient", payload.Shares[0].RecipientUsername)
+	require.Nil(t, payload.Shares[0].LastActivityAt)
+
+	require.NoError(t, testServer.sendShareReviewReminders(ctx))
+	require.Len(t, reminders(), 1, "A share is not included again before the reminder interval passes")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/shares/outgoing/revoke", testServer.RevokeSharesHandler)
+	body, _ := json.Marshal(RevokeSharesRequest{ShareIDs: append(payload.ShareIDs, 999999999)})
+	req := httptest.NewRequest("POST", "/api/v1/shares/outgoing/revoke", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp RevokeSharesResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, payload.ShareIDs, resp.Revoked)
+	require.Equal(t, []int64{999999999}, resp.NotFound)
+
+	hasAccess, err := testServer.store.HasAccessToNode(ctx, forgottenFile.ID, recipient.ID)
+	require.NoError(t, err)
+	require.False(t, hasAccess)
+	hasAccess, err = testServer.store.HasAccessToNode(ctx, usedFile.ID, recipient.ID)
+	require.NoError(t, err)
+	require.True(t, hasAccess)
+}
+
+func TestReloadConfig(t *testing.T) {
+	admin := createTestUserWithPassword(t, "config_reload_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "config_reload_admin", "password")
+
+	settings := filepath.Join(t.TempDir(), "settings.yml")
+	viper.SetConfigFile(settings)
+	defer viper.Reset()
+	running := testServer.config.Load()
+	defer testServer.config.Store(running)
+	defer testServer.originValidator.Store(NewOriginValidator(running.CORS))
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.With(testServer.AdminMiddleware).Post("/api/v1/admin/config/reload", testServer.ReloadConfigHandler)
+	reload := func(content string) *httptest.ResponseRecorder {
+		require.NoError(t, os.WriteFile(settings, []byte(content), 0o644))
+		req := httptest.NewRequest("POST", "/api/v1/admin/config/reload", nil)
+		req.Header.Set("Authorization", "Bearer "+adminLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := reload("jwt:\n  secret: api_test_secret\ndb:\n  source: postgres://elsewhere\nlimits:\n  max_folder_depth: 5\ncors:\n  allowed_origins: [\"https://app.example.com\"]\n")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var resp ConfigReloadResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	keys := []string{}
+	for _, change := range resp.Applied {
+		keys = append(keys, change.Key)
+	}
+	require.Equal(t, []string{"limits.max_folder_depth", "cors.allowed_origins"}, keys)
+	require.Equal(t, []string{"db.source"}, resp.RestartRequired)
+	require.Equal(t, 5, testServer.maxFolderDepth())
+	require.Empty(t, testServer.config.Load().DB.Source, "Settings needing a restart are not applied")
+
+	preflight := httptest.NewRequest("OPTIONS", "/", nil)
+	preflight.Header.Set("Origin", "https://app.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "GET")
+	corsRR := httptest.NewRecorder()
+	testServer.CORS()(http.NotFoundHandler()).ServeHTTP(corsRR, preflight)
+	require.Equal(t, "https://app.example.com", corsRR.Header().Get("Access-Control-Allow-Origin"), "Reloaded origins are allowed at once")
+
+	events, err := testServer.store.GetEventsSince(context.Background(), admin.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "config_reloaded", events[0].EventType)
+
+	rr = reload("limits:\n  max_folder_depth: -1\ncors:\n  allowed_origins: [\"*\"]\n")
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	require.Contains(t, rr.Body.String(), "limits.max_folder_depth cannot be negative")
+	require.Contains(t, rr.Body.String(), `"*"`)
+	require.Equal(t, 5, testServer.maxFolderDepth(), "An invalid file is rejected as a whole")
+}
+
+func TestStorageBreakdownAndReconciliation(t *testing.T) {
+	user := createTestUserWithPassword(t, "storage_breakdown_user", "password")
+	login := loginUserForTest(t, "storage_breakdown_user", "password")
+	ctx := context.Background()
+
+	kept := createTestNodeAPI(t, "zostaje.txt", "file", nil, user.ID)
+	trashed := createTestNodeAPI(t, "do_kosza.txt", "file", nil, user.ID)
+	createTestNodeAPI(t, "pusty", "folder", nil, user.ID)
+	empty := createTestNodeAPI(t, "pusty.txt", "file", nil, user.ID)
+	_, err := testServer.store.GetPool().Exec(ctx, `UPDATE nodes SET size_bytes = 0 WHERE id = $1`, empty.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, testServer.reconcileStorageUsage(ctx))
+	stored, err := testServer.store.GetUserByUsername(ctx, user.Username)
+	require.NoError(t, err)
+	require.Equal(t, *kept.SizeBytes+*trashed.SizeBytes, stored.StorageUsedBytes, "Nodes created without counting their size are reconciled")
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Delete("/api/v1/nodes/{nodeId}", testServer.DeleteNodeHandler)
+	router.Post("/api/v1/nodes/{nodeId}/restore", testServer.RestoreNodeHandler)
+	router.Get("/api/v1/me/storage/breakdown", testServer.GetStorageBreakdownHandler)
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	breakdown := func() StorageBreakdownResponse {
+		rr := do("GET", "/api/v1/me/storage/breakdown")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp StorageBreakdownResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		return resp
+	}
+
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/nodes/"+trashed.ID).Code)
+	resp := breakdown()
+	require.True(t, resp.TrashCounted)
+	require.Equal(t, *kept.SizeBytes+*trashed.SizeBytes, resp.UsedBytes, "Trashed files count by default")
+	require.Equal(t, LiveStorageBreakdown{Files: 2, UsedBytes: *kept.SizeBytes, ZeroByteFiles: 1, Folders: 1, EmptyFolders: 1}, resp.Live)
+	require.Equal(t, TrashStorageUsage{Files: 1, UsedBytes: *trashed.SizeBytes}, resp.Trash)
+
+	quota := testServer.config.Load().Quota
+	testServer.config.Load().Quota.ExcludeTrash = true
+	defer func() { testServer.config.Load().Quota = quota }()
+
+	require.Equal(t, http.StatusOK, do("POST", "/api/v1/nodes/"+trashed.ID+"/restore").Code)
+	require.Equal(t, *kept.SizeBytes+*trashed.SizeBytes, breakdown().UsedBytes)
+	require.Equal(t, http.StatusNoContent, do("DELETE", "/api/v1/nodes/"+trashed.ID).Code)
+	resp = breakdown()
+	require.False(t, resp.TrashCounted)
+	require.Equal(t, *kept.SizeBytes, resp.UsedBytes, "Trashed files are released at once when the trash is excluded")
+	require.Equal(t, *trashed.SizeBytes, resp.Trash.UsedBytes)
+}
+
+func TestFeatureFlags(t *testing.T) {
+	admin := createTestUserWithPassword(t, "feature_flags_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "feature_flags_admin", "password")
+	user := createTestUserWithPassword(t, "feature_flags_user", "password")
+	userLogin := loginUserForTest(t, "feature_flags_user", "password")
+	defer testServer.store.DeleteFeatureFlagOverride(context.Background(), features.DeltaUploads)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/me/features", testServer.GetMyFeaturesHandler)
+	router.With(testServer.RequireFeature(features.DeltaUploads)).Put("/api/v1/delta", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Route("/api/v1/admin/features", func(r chi.Router) {
+		r.Use(testServer.AdminMiddleware)
+		r.Get("/", testServer.ListFeatureFlagsHandler)
+		r.Put("/{flag}", testServer.SetFeatureFlagHandler)
+		r.Delete("/{flag}", testServer.ResetFeatureFlagHandler)
+		r.Put("/{flag}/users/{userId}", testServer.SetFeatureFlagUserHandler)
+		r.Delete("/{flag}/users/{userId}", testServer.DeleteFeatureFlagUserHandler)
+	})
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusNoContent, do(userLogin.AccessToken, "PUT", "/api/v1/delta", "").Code, "Flags are on by default")
+	require.Equal(t, http.StatusForbidden, do(userLogin.AccessToken, "PUT", "/api/v1/admin/features/delta_uploads", `{"enabled":false}`).Code)
+	require.Equal(t, http.StatusNotFound, do(adminLogin.AccessToken, "PUT", "/api/v1/admin/features/no_such_flag", `{"enabled":false}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(adminLogin.AccessToken, "PUT", "/api/v1/admin/features/delta_uploads", `{"enabled":true,"rollout_percent":101}`).Code)
+
+	rr := do(adminLogin.AccessToken, "PUT", "/api/v1/admin/features/delta_uploads", `{"enabled":false}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var flag FeatureFlagResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &flag))
+	require.Equal(t, featureSourceOverride, flag.Source)
+	require.False(t, flag.Enabled)
+
+	rr = do(userLogin.AccessToken, "PUT", "/api/v1/delta", "")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Equal(t, i18n.FeatureDisabled, rr.Header().Get("X-Error-Code"))
+
+	userURL := fmt.Sprintf("/api/v1/admin/features/delta_uploads/users/%d", user.ID)
+	rr = do(adminLogin.AccessToken, "PUT", userURL, `{"enabled":true}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &flag))
+	require.Len(t, flag.Users, 1)
+	require.Equal(t, user.ID, flag.Users[0].UserID)
+	require.Equal(t, http.StatusNoContent, do(userLogin.AccessToken, "PUT", "/api/v1/delta", "").Code, "A forced flag wins over the override")
+
+	var mine map[string]bool
+	require.NoError(t, json.Unmarshal(do(userLogin.AccessToken, "GET", "/api/v1/me/features", "").Body.Bytes(), &mine))
+	require.True(t, mine[features.DeltaUploads])
+	require.True(t, mine[features.ResumableUploads])
+	require.NoError(t, json.Unmarshal(do(adminLogin.AccessToken, "GET", "/api/v1/me/features", "").Body.Bytes(), &mine))
+	require.False(t, mine[features.DeltaUploads])
+
+	require.Equal(t, http.StatusOK, do(adminLogin.AccessToken, "DELETE", userURL, "").Code)
+	require.Equal(t, http.StatusNotFound, do(adminLogin.AccessToken, "DELETE", userURL, "").Code)
+	require.Equal(t, http.StatusForbidden, do(userLogin.AccessToken, "PUT", "/api/v1/delta", "").Code)
+
+	rr = do(adminLogin.AccessToken, "DELETE", "/api/v1/admin/features/delta_uploads", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &flag))
+	require.Equal(t, featureSourceDefault, flag.Source)
+	require.Equal(t, http.StatusNoContent, do(userLogin.AccessToken, "PUT", "/api/v1/delta", "").Code)
+}
+
+func TestPublicLinks(t *testing.T) {
+	owner := createTestUserWithPassword(t, "public_link_owner", "password")
+	ownerLogin := loginUserForTest(t, "public_link_owner", "password")
+	createTestUserWithPassword(t, "public_link_other", "password")
+	otherLogin := loginUserForTest(t, "public_link_other", "password")
+
+	folder := createTestNodeAPI(t, "Publiczny", "folder", nil, owner.ID)
+	subfolder := createTestNodeAPI(t, "Zdjęcia", "folder", &folder.ID, owner.ID)
+	outside := createTestNodeAPI(t, "prywatny.txt", "file", nil, owner.ID)
+
+	file := createTestNodeAPI(t, "raport.txt", "file", &subfolder.ID, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("raport kwartalny")))
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Post("/api/v1/nodes/{nodeId}/links", testServer.CreatePublicLinkHandler)
+		r.Get("/api/v1/links", testServer.ListPublicLinksHandler)
+		r.Delete("/api/v1/links/{id}", testServer.DeletePublicLinkHandler)
+	})
+	do := func(token, method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusNotFound, do(otherLogin.AccessToken, "POST", "/api/v1/nodes/"+folder.ID+"/links").Code, "Only the owner can create links")
+	rr := do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+folder.ID+"/links")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var link dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.NotEmpty(t, link.Token)
+	require.Equal(t, "Publiczny", link.NodeName)
+
+	rr = do("", "GET", "/api/v1/public/"+link.Token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var listing PublicFolderResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+	require.Equal(t, folder.ID, listing.Folder.ID)
+	require.Len(t, listing.Items, 1)
+	require.Equal(t, subfolder.ID, listing.Items[0].ID)
+
+	rr = do("", "GET", "/api/v1/public/"+link.Token+"?node_id="+subfolder.ID)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+	require.Len(t, listing.Items, 1)
+	require.Equal(t, file.ID, listing.Items[0].ID)
+
+	rr = do("", "GET", "/api/v1/public/"+link.Token+"?node_id="+file.ID)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "raport kwartalny", rr.Body.String())
+	require.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+
+	require.Equal(t, http.StatusNotFound, do("", "GET", "/api/v1/public/"+link.Token+"?node_id="+outside.ID).Code, "Nodes outside the linked folder are not reachable")
+	require.Equal(t, http.StatusNotFound, do("", "GET", "/api/v1/public/no-such-token").Code)
+
+	rr = do(ownerLogin.AccessToken, "GET", "/api/v1/links")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var links []dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &links))
+	require.Len(t, links, 1)
+	require.EqualValues(t, 1, links[0].DownloadCount)
+	require.NotNil(t, links[0].LastAccessedAt)
+
+	linkURL := fmt.Sprintf("/api/v1/links/%d", link.ID)
+	require.Equal(t, http.StatusNotFound, do(otherLogin.AccessToken, "DELETE", linkURL).Code)
+	require.Equal(t, http.StatusNoContent, do(ownerLogin.AccessToken, "DELETE", linkURL).Code)
+	require.Equal(t, http.StatusNotFound, do("", "GET", "/api/v1/public/"+link.Token).Code, "A revoked link stops working")
+}
+
+func TestAdminUserManagement(t *testing.T) {
+	admin := createTestUserWithPassword(t, "admin_panel_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "admin_panel_admin", "password")
+	createTestUserWithPassword(t, "admin_panel_user", "password")
+	userLogin := loginUserForTest(t, "admin_panel_user", "password")
+	createTestNodeAPI(t, "dane.bin", "file", nil, admin.ID)
+	before, err := testServer.store.GetSystemStats(context.Background())
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware, testServer.AdminMiddleware)
+		r.Get("/api/v1/admin/users", testServer.ListUsersHandler)
+		r.Post("/api/v1/admin/users", testServer.CreateUserHandler)
+		r.Patch("/api/v1/admin/users/{userId}", testServer.UpdateUserHandler)
+		r.Get("/api/v1/admin/stats", testServer.GetSystemStatsHandler)
+		r.Get("/api/v1/admin/jobs", testServer.ListJobsHandler)
+	})
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusForbidden, do(userLogin.AccessToken, "GET", "/api/v1/admin/users", "").Code)
+
+	require.Equal(t, http.StatusBadRequest, do(adminLogin.AccessToken, "POST", "/api/v1/admin/users", `{"username":"nowy","password":"krotkie"}`).Code)
+	require.Equal(t, http.StatusConflict, do(adminLogin.AccessToken, "POST", "/api/v1/admin/users", `{"username":"admin_panel_user","password":"password123"}`).Code)
+	rr := do(adminLogin.AccessToken, "POST", "/api/v1/admin/users", `{"username":"nowy","password":"password123","display_name":"Nowy","storage_quota_bytes":1048576}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created AdminUserResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.EqualValues(t, 1048576, created.StorageQuotaBytes)
+	require.NotContains(t, rr.Body.String(), "password")
+	newLogin := loginUserForTest(t, "nowy", "password123")
+
+	rr = do(adminLogin.AccessToken, "GET", "/api/v1/admin/users?limit=1000", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var users []AdminUserResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &users))
+	require.True(t, slices.ContainsFunc(users, func(u AdminUserResponse) bool { return u.ID == created.ID }))
+
+	userURL := fmt.Sprintf("/api/v1/admin/users/%d", created.ID)
+	require.Equal(t, http.StatusBadRequest, do(adminLogin.AccessToken, "PATCH", userURL, `{"storage_quota_bytes":-1}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(adminLogin.AccessToken, "PATCH", fmt.Sprintf("/api/v1/admin/users/%d", admin.ID), `{"disabled":true}`).Code, "Administrators cannot disable themselves")
+	require.Equal(t, http.StatusNotFound, do(adminLogin.AccessToken, "PATCH", "/api/v1/admin/users/999999", `{"disabled":true}`).Code)
+
+	rr = do(adminLogin.AccessToken, "PATCH", userURL, `{"storage_quota_bytes":2097152,"disabled":true}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var updated AdminUserResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	require.EqualValues(t, 2097152, updated.StorageQuotaBytes)
+	require.NotNil(t, updated.DisabledAt)
+	require.Equal(t, http.StatusUnauthorized, do(newLogin.AccessToken, "GET", "/api/v1/admin/users", "").Code, "Disabling ends the user's sessions")
+
+	rr = do(adminLogin.AccessToken, "PATCH", userURL, `{"disabled":false}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	require.Nil(t, updated.DisabledAt)
+	loginUserForTest(t, "nowy", "password123")
+
+	rr = do(adminLogin.AccessToken, "GET", "/api/v1/admin/stats", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var stats dto.SystemStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	require.Equal(t, before.Users+1, stats.Users)
+	require.Equal(t, before.Files, stats.Files)
+	require.GreaterOrEqual(t, stats.AdminUsers, int64(1))
+	require.GreaterOrEqual(t, stats.UsedBytes, int64(1234))
+
+	testServer.jobs.started("test_job", time.Minute, time.Now())
+	testServer.jobs.finished("test_job", time.Now(), fmt.Errorf("boom"))
+	rr = do(adminLogin.AccessToken, "GET", "/api/v1/admin/jobs", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var jobs []JobStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &jobs))
+	require.NotEmpty(t, jobs)
+	for _, job := range jobs {
+		if job.Name == "test_job" {
+			require.EqualValues(t, 1, job.Failures)
+			require.Equal(t, "boom", job.LastError)
+		}
+	}
+}
+
+func TestPublicLinkRestrictions(t *testing.T) {
+	owner := createTestUserWithPassword(t, "link_limits_owner", "password")
+	ownerLogin := loginUserForTest(t, "link_limits_owner", "password")
+	file := createTestNodeAPI(t, "umowa.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("treść umowy")))
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Post("/api/v1/nodes/{nodeId}/links", testServer.CreatePublicLinkHandler)
+		r.Patch("/api/v1/links/{id}", testServer.UpdatePublicLinkHandler)
+	})
+	do := func(token, method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createURL := "/api/v1/nodes/" + file.ID + "/links"
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", createURL, `{"expires_at":"`+past+`"}`, nil).Code)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", createURL, `{"max_downloads":0}`, nil).Code)
+
+	rr := do(ownerLogin.AccessToken, "POST", createURL, `{"password":"tajne","max_downloads":2}`, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	require.NotContains(t, rr.Body.String(), "password_hash")
+	var link dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.True(t, link.HasPassword)
+	publicURL := "/api/v1/public/" + link.Token
+
+	require.Equal(t, http.StatusUnauthorized, do("", "GET", publicURL, "", nil).Code)
+	require.Equal(t, http.StatusUnauthorized, do("", "GET", publicURL, "", map[string]string{"X-Link-Password": "zle"}).Code)
+	withPassword := map[string]string{"X-Link-Password": "tajne"}
+	rr = do("", "GET", publicURL, "", withPassword)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "treść umowy", rr.Body.String())
+	require.Equal(t, http.StatusOK, do("", "GET", publicURL, "", withPassword).Code)
+	require.Equal(t, http.StatusGone, do("", "GET", publicURL, "", withPassword).Code, "The download limit is enforced")
+
+	linkURL := fmt.Sprintf("/api/v1/links/%d", link.ID)
+	rr = do(ownerLogin.AccessToken, "PATCH", linkURL, `{"password":null,"max_downloads":null}`, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.False(t, link.HasPassword)
+	require.Nil(t, link.MaxDownloads)
+	require.Equal(t, http.StatusOK, do("", "GET", publicURL, "", nil).Code)
+
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "PATCH", linkURL, `{"password":""}`, nil).Code)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "PATCH", linkURL, `{"expires_at":"`+past+`"}`, nil).Code)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rr = do(ownerLogin.AccessToken, "PATCH", linkURL, `{"expires_at":"`+future+`"}`, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.NotNil(t, link.ExpiresAt)
+	require.EqualValues(t, 3, link.DownloadCount)
+
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE public_links SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, link.ID)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusGone, do("", "GET", publicURL, "", nil).Code, "An expired link is gone")
+	require.Equal(t, http.StatusNotFound, do(ownerLogin.AccessToken, "PATCH", "/api/v1/links/999999", `{"max_downloads":1}`, nil).Code)
+}
+
+func TestPublicLinkPasswordAttempts(t *testing.T) {
+	owner := createTestUserWithPassword(t, "link_guess_owner", "password")
+	file := createTestNodeAPI(t, "sejf.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("sekret")))
+	passwordHash, err := auth.HashPassword("tajne")
+	require.NoError(t, err)
+	link, err := testServer.store.CreatePublicLink(context.Background(), "link_guess_token_0000000000000001", file.ID, owner.ID, database.PublicLinkDownload, database.PublicLinkSettings{PasswordHash: &passwordHash})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	open := func(clientIP, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/public/"+link.Token, nil)
+		req.RemoteAddr = clientIP + ":40000"
+		req.Header.Set("X-Link-Password", password)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < maxLinkPasswordFailuresPerLink; i++ {
+		require.Equal(t, http.StatusUnauthorized, open(fmt.Sprintf("198.51.100.%d", i), "zgadywane").Code)
+	}
+	rr := open("203.0.113.7", "tajne")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code, "The link is locked after too many wrong passwords from any address")
+	require.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	var downloads int64
+	require.NoError(t, testServer.store.GetPool().QueryRow(context.Background(), `SELECT download_count FROM public_links WHERE id = $1`, link.ID).Scan(&downloads))
+	require.Zero(t, downloads, "Failed attempts do not use up downloads")
+
+	now := time.Now()
+	require.Zero(t, testServer.linkPasswords.lockedFor(link.ID, "203.0.113.7", now.Add(linkPasswordWindow)), "The lock ends with the window")
+	var attempts linkPasswordAttempts
+	for i := 0; i < maxLinkPasswordFailuresPerIP; i++ {
+		attempts.fail(int64(i), "203.0.113.8", now)
+	}
+	require.Positive(t, attempts.lockedFor(999, "203.0.113.8", now), "An address is locked after too many wrong passwords for any links")
+	require.Zero(t, attempts.lockedFor(999, "203.0.113.9", now))
+	attempts.prune(now.Add(linkPasswordWindow))
+	require.Zero(t, attempts.lockedFor(999, "203.0.113.8", now))
+}
+
+func TestPublicLinkPreviews(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUserWithPassword(t, "link_preview_owner", "password")
+	createFile := func(name, mimeType string, content []byte) *models.Node {
+		node := createTestNodeAPI(t, name, "file", nil, owner.ID)
+		require.NoError(t, testServer.storage.Save(node.ID, bytes.NewReader(content)))
+		node, err := testServer.store.UpdateNodeContent(ctx, node.ID, owner.ID, int64(len(content)), &mimeType, nil)
+		require.NoError(t, err)
+		return node
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, image.NewNRGBA(image.Rect(0, 0, 40, 20))))
+	photo := createFile("wakacje <2024>.png", "image/png", encoded.Bytes())
+	secret := createFile("umowa.pdf", "application/pdf", []byte("poufne"))
+	passwordHash, err := auth.HashPassword("tajne")
+	require.NoError(t, err)
+	photoLink, err := testServer.store.CreatePublicLink(ctx, "link_preview_token_000000000001", photo.ID, owner.ID, database.PublicLinkDownload, database.PublicLinkSettings{})
+	require.NoError(t, err)
+	secretLink, err := testServer.store.CreatePublicLink(ctx, "link_preview_token_000000000002", secret.ID, owner.ID, database.PublicLinkDownload, database.PublicLinkSettings{PasswordHash: &passwordHash})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}/preview", testServer.PreviewPublicLinkHandler)
+	router.Get("/api/v1/public/{token}/oembed", testServer.PublicLinkOEmbedHandler)
+	router.Get("/api/v1/public/{token}/thumbnail", testServer.PublicLinkThumbnailHandler)
+	get := func(url, clientIP string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = clientIP + ":40000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/v1/public/"+photoLink.Token+"/preview", "198.51.100.20")
+	require.Equal(t, http.StatusOK, rr.Code)
+	page := rr.Body.String()
+	require.Contains(t, page, `<meta property="og:title" content="wakacje &lt;2024&gt;.png">`, "Names are escaped")
+	require.Contains(t, page, `og:image" content="http://example.com/api/v1/public/`+photoLink.Token+`/thumbnail"`)
+	require.Contains(t, page, `/api/v1/public/`+photoLink.Token+`/oembed`)
+
+	rr = get("/api/v1/public/"+photoLink.Token+"/oembed", "198.51.100.20")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var embed OEmbedResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &embed))
+	require.Equal(t, "link", embed.Type)
+	require.Equal(t, photo.Name, embed.Title)
+	require.NotEmpty(t, embed.ThumbnailURL)
+
+	rr = get("/api/v1/public/"+photoLink.Token+"/thumbnail", "198.51.100.20")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+
+	rr = get("/api/v1/public/"+secretLink.Token+"/preview", "198.51.100.20")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotContains(t, rr.Body.String(), "umowa", "A password-protected link shows nothing of its file")
+	require.NotContains(t, rr.Body.String(), "og:image")
+	require.Equal(t, http.StatusNotFound, get("/api/v1/public/"+secretLink.Token+"/thumbnail", "198.51.100.20").Code)
+	require.Equal(t, http.StatusNotFound, get("/api/v1/public/link_preview_token_missing/preview", "198.51.100.20").Code)
+
+	var downloads int64
+	require.NoError(t, testServer.store.GetPool().QueryRow(ctx, `SELECT download_count FROM public_links WHERE id = $1`, photoLink.ID).Scan(&downloads))
+	require.Zero(t, downloads, "Previews do not count as downloads")
+
+	for i := 0; i < defaultPreviewsPerMinute; i++ {
+		require.Equal(t, http.StatusOK, get("/api/v1/public/"+photoLink.Token+"/oembed", "198.51.100.21").Code)
+	}
+	rr = get("/api/v1/public/"+photoLink.Token+"/oembed", "198.51.100.21")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.NotEmpty(t, rr.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, get("/api/v1/public/"+photoLink.Token+"/oembed", "198.51.100.22").Code)
+
+	require.Equal(t, "512 B", formatByteSize(512))
+	require.Equal(t, "1.5 MB", formatByteSize(1536*1024))
+}
+
+func TestPublicLinkSlugsAndQR(t *testing.T) {
+	owner := createTestUserWithPassword(t, "link_slug_owner", "password")
+	ownerLogin := loginUserForTest(t, "link_slug_owner", "password")
+	file := createTestNodeAPI(t, "ulotka.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("ulotka")))
+	other := createTestNodeAPI(t, "plakat.txt", "file", nil, owner.ID)
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	router.Get("/api/v1/public/{token}/qr", testServer.PublicLinkQRHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Post("/api/v1/nodes/{nodeId}/links", testServer.CreatePublicLinkHandler)
+		r.Patch("/api/v1/links/{id}", testServer.UpdatePublicLinkHandler)
+	})
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+file.ID+"/links", `{"slug":"ab"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+file.ID+"/links", `{"slug":"ulotka/2024"}`).Code)
+	rr := do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+file.ID+"/links", `{"slug":"Ulotka-2024"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var link dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.Equal(t, "ulotka-2024", *link.Slug, "Slugs are stored in lower case")
+
+	rr = do("", "GET", "/api/v1/public/ULOTKA-2024", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "ulotka", rr.Body.String(), "The slug opens the link, ignoring case")
+	require.Equal(t, http.StatusOK, do("", "GET", "/api/v1/public/"+link.Token, "").Code, "The token keeps working")
+
+	rr = do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+other.ID+"/links", `{"slug":"ulotka-2024"}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+	rr = do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+other.ID+"/links", "")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var otherLink dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &otherLink))
+	require.Nil(t, otherLink.Slug)
+	require.Equal(t, http.StatusConflict, do(ownerLogin.AccessToken, "PATCH", fmt.Sprintf("/api/v1/links/%d", otherLink.ID), `{"slug":"ulotka-2024"}`).Code)
+
+	rr = do("", "GET", "/api/v1/public/ulotka-2024/qr", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	qrImage, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	require.NoError(t, err)
+	require.Positive(t, qrImage.Bounds().Dx())
+
+	rr = do(ownerLogin.AccessToken, "PATCH", fmt.Sprintf("/api/v1/links/%d", link.ID), `{"slug":null}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusNotFound, do("", "GET", "/api/v1/public/ulotka-2024", "").Code, "A removed slug stops working")
+	require.Equal(t, http.StatusOK, do(ownerLogin.AccessToken, "PATCH", fmt.Sprintf("/api/v1/links/%d", otherLink.ID), `{"slug":"ulotka-2024"}`).Code, "A freed slug can be reused")
+	require.Equal(t, http.StatusNotFound, do("", "GET", "/api/v1/public/nie-ma-takiego/qr", "").Code)
+}
+
+func TestPublicLinkTransferCap(t *testing.T) {
+	owner := createTestUserWithPassword(t, "link_cap_owner", "password")
+	ownerLogin := loginUserForTest(t, "link_cap_owner", "password")
+	file := createTestNodeAPI(t, "film.txt", "file", nil, owner.ID)
+	require.NoError(t, testServer.storage.Save(file.ID, strings.NewReader("0123456789")))
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Post("/api/v1/nodes/{nodeId}/links", testServer.CreatePublicLinkHandler)
+		r.Patch("/api/v1/links/{id}", testServer.UpdatePublicLinkHandler)
+	})
+	do := func(token, method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	createURL := "/api/v1/nodes/" + file.ID + "/links"
+	capEvents := func() int {
+		var count int
+		err := testServer.store.GetPool().QueryRow(context.Background(),
+			"SELECT COUNT(*) FROM event_journal WHERE user_id = $1 AND event_type = 'public_link_transfer_cap_reached'", owner.ID).Scan(&count)
+		require.NoError(t, err)
+		return count
+	}
+
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", createURL, `{"max_transfer_bytes":0}`, nil).Code)
+
+	rr := do(ownerLogin.AccessToken, "POST", createURL, `{"max_transfer_bytes":15}`, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var link dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.EqualValues(t, 15, *link.MaxTransferBytes)
+	publicURL := "/api/v1/public/" + link.Token
+
+	require.Equal(t, http.StatusOK, do("", "GET", publicURL, "", nil).Code)
+	require.Equal(t, 0, capEvents())
+	rr = do("", "GET", publicURL, "", nil)
+	require.Equal(t, http.StatusOK, rr.Code, "The download crossing the cap is completed")
+	require.Equal(t, "0123456789", rr.Body.String())
+	require.Equal(t, 1, capEvents(), "The owner is notified when the cap is reached")
+
+	rr = do("", "GET", publicURL, "", nil)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Contains(t, rr.Body.String(), "transfer limit")
+	rr = do("", "GET", publicURL, "", map[string]string{"Accept": "text/html,application/xhtml+xml"})
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+	require.Contains(t, rr.Body.String(), "<h1>")
+
+	linkURL := fmt.Sprintf("/api/v1/links/%d", link.ID)
+	rr = do(ownerLogin.AccessToken, "PATCH", linkURL, `{"max_transfer_bytes":25}`, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.EqualValues(t, 20, link.BytesServed)
+	require.Equal(t, http.StatusOK, do("", "GET", publicURL, "", nil).Code, "Raising the cap reopens the link")
+	require.Equal(t, 2, capEvents(), "A new cap is notified again")
+
+	rr = do(ownerLogin.AccessToken, "PATCH", linkURL, `{"max_transfer_bytes":null}`, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, http.StatusOK, do("", "GET", publicURL, "", nil).Code)
+	require.Equal(t, 2, capEvents())
+}
+
+func TestCompareFolders(t *testing.T) {
+	user := createTestUserWithPassword(t, "compare_user", "password")
+	login := loginUserForTest(t, "compare_user", "password")
+	other := createTestUserWithPassword(t, "compare_other", "password")
+	ctx := context.Background()
+
+	file := func(name string, parentID string, sha string, size int64) {
+		node := createTestNodeAPI(t, name, "file", &parentID, user.ID)
+		_, err := testServer.store.GetPool().Exec(ctx, `UPDATE nodes SET content_sha256 = NULLIF($2, ''), size_bytes = $3 WHERE id = $1`, node.ID, sha, size)
+		require.NoError(t, err)
+	}
+	sha := func(c string) string { return strings.Repeat(c, 64) }
+
+	left := createTestNodeAPI(t, "lewy", "folder", nil, user.ID)
+	right := createTestNodeAPI(t, "prawy", "folder", nil, user.ID)
+	leftDocs := createTestNodeAPI(t, "docs", "folder", &left.ID, user.ID)
+	rightDocs := createTestNodeAPI(t, "docs", "folder", &right.ID, user.ID)
+	file("same.txt", left.ID, sha("a"), 10)
+	file("same.txt", right.ID, sha("a"), 10)
+	file("old.txt", left.ID, sha("b"), 5)
+	file("plan.txt", leftDocs.ID, sha("c"), 7)
+	file("plan.txt", rightDocs.ID, sha("d"), 8)
+	file("legacy.bin", leftDocs.ID, "", 3)
+	file("legacy.bin", rightDocs.ID, sha("e"), 3)
+	file("new.txt", rightDocs.ID, sha("f"), 1)
+	foreign := createTestNodeAPI(t, "obcy", "folder", nil, other.ID)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/compare", testServer.CompareFoldersHandler)
+	compare := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/nodes/compare", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := compare(`{"left_id":"` + left.ID + `","right_id":"` + right.ID + `"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var result CompareFoldersResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Len(t, result.Added, 1)
+	require.Equal(t, "docs/new.txt", result.Added[0].Path)
+	require.Len(t, result.Removed, 1)
+	require.Equal(t, "old.txt", result.Removed[0].Path)
+	require.Len(t, result.Changed, 1)
+	require.Equal(t, "docs/plan.txt", result.Changed[0].Path)
+	require.Equal(t, 2, result.Unchanged, "A file without a checksum is compared by size")
+	require.False(t, result.Truncated)
+
+	rr = compare(`{"left_id":"` + left.ID + `","right_id":"` + right.ID + `","limit":2}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	require.Equal(t, 2, len(result.Added)+len(result.Removed)+len(result.Changed))
+	require.True(t, result.Truncated)
+
+	require.Equal(t, http.StatusBadRequest, compare(`{"left_id":"`+left.ID+`"}`).Code)
+	require.Equal(t, http.StatusBadRequest, compare(`{"left_id":"`+left.ID+`","right_id":"`+right.ID+`","limit":-1}`).Code)
+	require.Equal(t, http.StatusNotFound, compare(`{"left_id":"`+left.ID+`","right_id":"`+foreign.ID+`"}`).Code)
+}
+
+// fakeMailer hands the emails sent in the background to the test.
+type fakeMailer chan email.Message
+
+func (m fakeMailer) Send(ctx context.Context, msg email.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestEmailVerificationAndPasswordReset(t *testing.T) {
+	user := createTestUserWithPassword(t, "reset_user", "password")
+	login := loginUserForTest(t, "reset_user", "password")
+	otherLogin := loginUserForTest(t, "reset_user", "password")
+
+	mailer := make(fakeMailer, 10)
+	testServer.mailer = mailer
+	defer func() { testServer.mailer = nil }()
+	receive := func() email.Message {
+		select {
+		case msg := <-mailer:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("No email was sent")
+			return email.Message{}
+		}
+	}
+	tokenFrom := func(msg email.Message) string {
+		lines := strings.Split(msg.Body, "\n")
+		require.GreaterOrEqual(t, len(lines), 3)
+		return lines[2]
+	}
+
+	router := chi.NewRouter()
+	router.Post("/api/v1/auth/verify-email", testServer.VerifyEmailHandler)
+	router.Post("/api/v1/auth/password-reset/request", testServer.RequestPasswordResetHandler)
+	router.Post("/api/v1/auth/password-reset/confirm", testServer.ConfirmPasswordResetHandler)
+	router.Post("/api/v1/auth/login", testServer.LoginHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(testServer.AuthMiddleware)
+		r.Put("/api/v1/me/email", testServer.SetMyEmailHandler)
+		r.Get("/api/v1/me/email", testServer.GetMyEmailHandler)
+	})
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, do(login.AccessToken, "PUT", "/api/v1/me/email", `{"email":"nie-adres"}`).Code)
+	rr := do(login.AccessToken, "PUT", "/api/v1/me/email", `{"email":"Reset.User@Example.com"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var address dto.UserEmail
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &address))
+	require.Equal(t, "reset.user@example.com", *address.Email)
+	require.Nil(t, address.VerifiedAt)
+	verification := receive()
+	require.Equal(t, "reset.user@example.com", verification.To)
+
+	// An unverified address gets no reset emails.
+	require.Equal(t, http.StatusAccepted, do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"reset.user@example.com"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do("", "POST", "/api/v1/auth/verify-email", `{"token":"zly-token"}`).Code)
+	require.Equal(t, http.StatusNoContent, do("", "POST", "/api/v1/auth/verify-email", `{"token":"`+tokenFrom(verification)+`"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do("", "POST", "/api/v1/auth/verify-email", `{"token":"`+tokenFrom(verification)+`"}`).Code, "A token is single-use")
+	rr = do(login.AccessToken, "GET", "/api/v1/me/email", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &address))
+	require.NotNil(t, address.VerifiedAt)
+
+	require.Equal(t, http.StatusAccepted, do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"nikt@example.com"}`).Code)
+	require.Equal(t, http.StatusAccepted, do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"RESET.USER@example.com"}`).Code)
+	reset := receive()
+	require.Equal(t, "Reset your password", reset.Subject)
+	require.Empty(t, mailer, "Unknown and unverified addresses get no email")
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusAccepted, do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"reset.user@example.com"}`).Code)
+	}
+	for i := 0; i < 2; i++ {
+		receive()
+	}
+	require.Equal(t, http.StatusAccepted, do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"reset.user@example.com"}`).Code)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, mailer, "Reset emails are rate-limited per account")
+
+	require.Equal(t, http.StatusBadRequest, do("", "POST", "/api/v1/auth/password-reset/confirm", `{"token":"`+tokenFrom(reset)+`","new_password":"krotkie"}`).Code)
+	require.Equal(t, http.StatusNoContent, do("", "POST", "/api/v1/auth/password-reset/confirm", `{"token":"`+tokenFrom(reset)+`","new_password":"nowe-haslo-123"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do("", "POST", "/api/v1/auth/password-reset/confirm", `{"token":"`+tokenFrom(reset)+`","new_password":"inne-haslo-123"}`).Code)
+
+	var sessions int
+	err := testServer.store.GetPool().QueryRow(context.Background(), `SELECT COUNT(*) FROM sessions WHERE user_id = $1`, user.ID).Scan(&sessions)
+	require.NoError(t, err)
+	require.Zero(t, sessions, "A reset terminates all sessions")
+	require.Equal(t, http.StatusUnauthorized, do(otherLogin.AccessToken, "GET", "/api/v1/me/email", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("", "POST", "/api/v1/auth/login", `{"username":"reset_user","password":"password"}`).Code)
+	require.Equal(t, http.StatusOK, do("", "POST", "/api/v1/auth/login", `{"username":"reset_user","password":"nowe-haslo-123"}`).Code)
+
+	// Concurrent requests cannot slip under the hourly limit together.
+	_, err = testServer.store.GetPool().Exec(context.Background(), `DELETE FROM email_tokens WHERE user_id = $1`, user.ID)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do("", "POST", "/api/v1/auth/password-reset/request", `{"email":"reset.user@example.com"}`)
+		}()
+	}
+	wg.Wait()
+	var stored int
+	err = testServer.store.GetPool().QueryRow(context.Background(), `SELECT COUNT(*) FROM email_tokens WHERE user_id = $1`, user.ID).Scan(&stored)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxEmailsPerHour, stored)
+	var pending []email.Message
+	for i := 0; i < stored; i++ {
+		pending = append(pending, receive())
+	}
+
+	// A token sent to the previous address stops working once the address
+	// changes.
+	relogin := loginUserForTest(t, "reset_user", "nowe-haslo-123")
+	require.Equal(t, http.StatusOK, do(relogin.AccessToken, "PUT", "/api/v1/me/email", `{"email":"nowy.adres@example.com"}`).Code)
+	receive()
+	require.Equal(t, http.StatusBadRequest, do("", "POST", "/api/v1/auth/password-reset/confirm", `{"token":"`+tokenFrom(pending[0])+`","new_password":"przejete-haslo"}`).Code)
+	require.Equal(t, http.StatusOK, do("", "POST", "/api/v1/auth/login", `{"username":"reset_user","password":"nowe-haslo-123"}`).Code)
+}
+
+func TestChaosUploadsKeepInvariants(t *testing.T) {
+	user := createTestUserWithPassword(t, "chaos_upload_user", "password")
+	login := loginUserForTest(t, "chaos_upload_user", "password")
+	ctx := context.Background()
+
+	blobs, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	storageFaults := chaos.New(7, 0.25, time.Millisecond, chaos.StorageSave, chaos.StorageRename)
+	dbFaults := chaos.New(11, 0.1, time.Millisecond, chaos.DBExec, chaos.DBCommit)
+	chaosServer := NewServer(testServer.config.Load(), testServer.store.WithChaos(dbFaults), testServer.storage,
+		storage.NewRouter(storage.NewChaosBackend(blobs, storageFaults)), testServer.wsHub)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/file", chaosServer.UploadFileHandler)
+	upload := func(name, content string) int {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", name)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/nodes/file", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	uploaded := 0
+	for i := 0; i < 40; i++ {
+		// Every content is uploaded several times to exercise deduplication.
+		code := upload(fmt.Sprintf("plik_%02d.txt", i), fmt.Sprintf("treść numer %d", i%6))
+		require.Contains(t, []int{http.StatusCreated, http.StatusInternalServerError}, code)
+		if code == http.StatusCreated {
+			uploaded++
+		}
+	}
+	require.Positive(t, storageFaults.Injected()+dbFaults.Injected(), "The run should inject failures")
+	require.Positive(t, uploaded)
+	storageFaults.SetFailureRate(0)
+	dbFaults.SetFailureRate(0)
+
+	var files int
+	err = testServer.store.GetPool().QueryRow(ctx, `SELECT count(*) FROM nodes WHERE owner_id = $1`, user.ID).Scan(&files)
+	require.NoError(t, err)
+	require.Equal(t, uploaded, files)
+
+	violations, err := testServer.store.CheckInvariants(ctx, false, user.ID)
+	require.NoError(t, err)
+	require.Empty(t, violations, "Failed uploads must not leave quota or reference count drift")
+
+	keys, err := blobs.Keys()
+	require.NoError(t, err)
+	orphans, err := testServer.store.UnreferencedStorageKeys(ctx, storage.DefaultBackend, keys)
+	require.NoError(t, err)
+	require.Empty(t, orphans, "Failed uploads must not leave orphaned blobs")
+}
+
+func TestFileDropLinks(t *testing.T) {
+	owner := createTestUserWithPassword(t, "file_drop_owner", "password")
+	ownerLogin := loginUserForTest(t, "file_drop_owner", "password")
+	folder := createTestNodeAPI(t, "Prace domowe", "folder", nil, owner.ID)
+	existing := createTestNodeAPI(t, "praca.txt", "file", &folder.ID, owner.ID)
+	file := createTestNodeAPI(t, "plik.txt", "file", nil, owner.ID)
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/public/{token}", testServer.OpenPublicLinkHandler)
+	router.Post("/api/v1/public/{token}/files", testServer.UploadToPublicLinkHandler)
+	router.With(testServer.AuthMiddleware).Post("/api/v1/nodes/{nodeId}/links", testServer.CreatePublicLinkHandler)
+	createLink := func(nodeID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/nodes/"+nodeID+"/links", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ownerLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	upload := func(token string, names ...string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		for _, name := range names {
+			part, err := writer.CreateFormFile("file", name)
+			require.NoError(t, err)
+			part.Write([]byte("rozwiązanie zadania"))
+		}
+		writer.Close()
+		req := httptest.NewRequest("POST", "/api/v1/public/"+token+"/files", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, createLink(file.ID, `{"type":"upload"}`).Code, "Upload links point to folders")
+	require.Equal(t, http.StatusBadRequest, createLink(folder.ID, `{"type":"other"}`).Code)
+	rr := createLink(folder.ID, `{"type":"upload","max_downloads":2}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var link dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.Equal(t, database.PublicLinkUpload, link.LinkType)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/public/"+link.Token+"?node_id="+existing.ID, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var listing PublicFolderResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listing))
+	require.True(t, listing.UploadOnly)
+	require.Equal(t, folder.ID, listing.Folder.ID)
+	require.Empty(t, listing.Items, "Upload links never list the folder")
+
+	before, err := testServer.store.GetUserByID(context.Background(), owner.ID)
+	require.NoError(t, err)
+	rr = upload(link.Token, "praca.txt")
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var uploaded []PublicNode
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &uploaded))
+	require.Len(t, uploaded, 1)
+	require.Equal(t, "praca (2).txt", uploaded[0].Name, "A taken name gets a suffix")
+	node, err := testServer.store.GetNodeByID(context.Background(), uploaded[0].ID, owner.ID)
+	require.NoError(t, err)
+	require.NotNil(t, node, "The file belongs to the folder's owner")
+	require.Equal(t, folder.ID, *node.ParentID)
+	after, err := testServer.store.GetUserByID(context.Background(), owner.ID)
+	require.NoError(t, err)
+	require.Equal(t, before.StorageUsedBytes+int64(len("rozwiązanie zadania")), after.StorageUsedBytes)
+
+	events, err := testServer.store.GetEventsSince(context.Background(), owner.ID, 0, 1000)
+	require.NoError(t, err)
+	require.True(t, slices.ContainsFunc(events, func(e database.Event) bool {
+		return e.EventType == "node_created" && strings.Contains(string(e.Payload), uploaded[0].ID)
+	}))
+
+	rr = upload(link.Token, "druga.txt", "trzecia.txt")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &uploaded))
+	require.Len(t, uploaded, 1, "Files beyond the limit are not stored")
+	require.Equal(t, http.StatusGone, upload(link.Token, "czwarta.txt").Code)
+
+	rr = createLink(folder.ID, `{}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var downloadLink dto.PublicLink
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &downloadLink))
+	require.Equal(t, http.StatusForbidden, upload(downloadLink.Token, "x.txt").Code, "Download links do not accept uploads")
+
+	_, err = testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET storage_quota_bytes = storage_used_bytes WHERE id = $1`, owner.ID)
+	require.NoError(t, err)
+	rr = createLink(folder.ID, `{"type":"upload"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &link))
+	require.Equal(t, http.StatusRequestEntityTooLarge, upload(link.Token, "za_duzo.txt").Code, "Uploads count against the owner's quota")
+}
+
+func TestUpdateSharePermissions(t *testing.T) {
+	sharer := createTestUserWithPassword(t, "share_update_sharer", "password")
+	recipient := createTestUserWithPassword(t, "share_update_recipient", "password")
+	sharerLogin := loginUserForTest(t, "share_update_sharer", "password")
+	recipientLogin := loginUserForTest(t, "share_update_recipient", "password")
+
+	folder := createTestNodeAPI(t, "Editable", "folder", nil, sharer.ID)
+	share, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: folder.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Patch("/api/v1/shares/{shareId}", testServer.UpdateShareHandler)
+
+	patch := func(token string, shareID int64, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/v1/shares/%d", shareID), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusBadRequest, patch(sharerLogin.AccessToken, share.ID, `{"permissions":"admin"}`).Code)
+	require.Equal(t, http.StatusNotFound, patch(recipientLogin.AccessToken, share.ID, `{"permissions":"write"}`).Code,
+		"Only the sharer can change the permissions")
+
+	rr := patch(sharerLogin.AccessToken, share.ID, `{"permissions":"write"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var updated models.Share
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	require.Equal(t, share.ID, updated.ID)
+	require.Equal(t, "write", updated.Permissions)
+	require.True(t, share.SharedAt.Equal(updated.SharedAt), "The share keeps its shared_at")
+
+	events, err := testServer.store.GetEventsSince(context.Background(), recipient.ID, 0, MaxLimit)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	require.Equal(t, "share_updated", last.EventType)
+	require.Contains(t, string(last.Payload), `"previous_permissions":"read"`)
+
+	node, err := testServer.store.GetNodeIfAccessible(context.Background(), folder.ID, recipient.ID)
+	require.NoError(t, err)
+	require.NotNil(t, node)
+
+	file := createTestNodeAPI(t, "pinned.txt", "file", nil, sharer.ID)
+	pinnedVersion := 1
+	pinned, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: file.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "read", PinnedVersion: &pinnedVersion,
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, patch(sharerLogin.AccessToken, pinned.ID, `{"permissions":"write"}`).Code,
+		"Shares pinned to a version stay read-only")
+
+	// A recipient who shared the folder further loses control of that share
+	// together with their manage permission.
+	third := createTestUserWithPassword(t, "share_update_third", "password")
+	require.Equal(t, http.StatusOK, patch(sharerLogin.AccessToken, share.ID, `{"permissions":"manage"}`).Code)
+	reshare, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: folder.ID, SharerID: recipient.ID, RecipientID: third.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, patch(recipientLogin.AccessToken, reshare.ID, `{"permissions":"write"}`).Code)
+	require.Equal(t, http.StatusOK, patch(sharerLogin.AccessToken, share.ID, `{"permissions":"write"}`).Code)
+	require.Equal(t, http.StatusForbidden, patch(recipientLogin.AccessToken, reshare.ID, `{"permissions":"manage"}`).Code,
+		"A downgraded recipient cannot raise the shares they made")
+}
+
+func TestBulkCreateNodes(t *testing.T) {
+	admin := createTestUserWithPassword(t, "bulk_nodes_admin", "password")
+	_, err := testServer.store.GetPool().Exec(context.Background(), `UPDATE users SET is_admin = TRUE WHERE id = $1`, admin.ID)
+	require.NoError(t, err)
+	adminLogin := loginUserForTest(t, "bulk_nodes_admin", "password")
+	owner := createTestUserWithPassword(t, "bulk_nodes_owner", "password")
+	parent := createTestNodeAPI(t, "Benchmark", "folder", nil, owner.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Use(testServer.AdminMiddleware)
+	router.With(testServer.RequireFeature(features.BulkNodes)).Post("/api/v1/admin/nodes/bulk", testServer.BulkCreateNodesHandler)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/admin/nodes/bulk", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminLogin.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	body := fmt.Sprintf(`{"owner_id":%d,"parent_id":%q,"folders":3,"files_per_folder":20}`, owner.ID, parent.ID)
+
+	require.Equal(t, http.StatusForbidden, post(body).Code, "The flag is off by default")
+
+	_, err = testServer.store.SetFeatureFlagOverride(context.Background(), features.BulkNodes, true, 100, admin.ID)
+	require.NoError(t, err)
+	defer testServer.store.DeleteFeatureFlagOverride(context.Background(), features.BulkNodes)
+
+	require.Equal(t, http.StatusBadRequest, post(fmt.Sprintf(`{"owner_id":%d,"folders":0}`, owner.ID)).Code)
+	require.Equal(t, http.StatusNotFound, post(`{"owner_id":999999999,"folders":1}`).Code)
+
+	rr := post(body)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var resp BulkNodesResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, 3, resp.Folders)
+	require.Equal(t, 60, resp.Files)
+
+	var folders, files int
+	require.NoError(t, testServer.store.GetPool().QueryRow(context.Background(), `
+		SELECT COUNT(*) FILTER (WHERE node_type = 'folder' AND parent_id = $2),
+		       COUNT(*) FILTER (WHERE node_type = 'file')
+		FROM nodes WHERE owner_id = $1 AND deleted_at IS NULL
+	`, owner.ID, parent.ID).Scan(&folders, &files))
+	require.Equal(t, 3, folders)
+	require.Equal(t, 60, files)
+
+	require.Equal(t, http.StatusCreated, post(body).Code, "Each batch gets its own folder names")
+
+	shortIDs, err := ids.New("node", "", 6)
+	require.NoError(t, err)
+	previousIDs := testServer.nodeIDs
+	testServer.nodeIDs = shortIDs
+	defer func() { testServer.nodeIDs = previousIDs }()
+	require.Equal(t, http.StatusCreated, post(body).Code, "Node IDs shorter than the batch tag still work")
+}
+
+func TestGroupSharing(t *testing.T) {
+	owner := createTestUserWithPassword(t, "group_share_owner", "password")
+	member := createTestUserWithPassword(t, "group_share_member", "password")
+	outsider := createTestUserWithPassword(t, "group_share_outsider", "password")
+	ownerLogin := loginUserForTest(t, "group_share_owner", "password")
+	memberLogin := loginUserForTest(t, "group_share_member", "password")
+
+	folder := createTestNodeAPI(t, "Team", "folder", nil, owner.ID)
+	subfolder := createTestNodeAPI(t, "Nested", "folder", &folder.ID, owner.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/groups", testServer.CreateGroupHandler)
+	router.Get("/api/v1/groups", testServer.ListGroupsHandler)
+	router.Get("/api/v1/groups/{groupId}", testServer.GetGroupHandler)
+	router.Post("/api/v1/groups/{groupId}/members", testServer.AddGroupMemberHandler)
+	router.Delete("/api/v1/groups/{groupId}/members/{userId}", testServer.RemoveGroupMemberHandler)
+	router.Get("/api/v1/groups/{groupId}/shares", testServer.ListGroupSharesHandler)
+	router.Post("/api/v1/nodes/{nodeId}/share", testServer.ShareNodeHandler)
+	router.Get("/api/v1/shares/incoming/users", testServer.ListSharingUsersHandler)
+
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(ownerLogin.AccessToken, "POST", "/api/v1/groups", `{"name":"  Zespół  "}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var group models.Group
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &group))
+	require.Equal(t, "Zespół", group.Name)
+	require.Equal(t, 1, group.MemberCount)
+	require.Equal(t, http.StatusConflict, do(ownerLogin.AccessToken, "POST", "/api/v1/groups", `{"name":"Zespół"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", "/api/v1/groups", `{"name":" "}`).Code)
+
+	groupURL := fmt.Sprintf("/api/v1/groups/%d", group.ID)
+	require.Equal(t, http.StatusNotFound, do(memberLogin.AccessToken, "GET", groupURL, "").Code, "Non-members do not see the group")
+	require.Equal(t, http.StatusCreated, do(ownerLogin.AccessToken, "POST", groupURL+"/members", `{"username":"group_share_member"}`).Code)
+	require.Equal(t, http.StatusConflict, do(ownerLogin.AccessToken, "POST", groupURL+"/members", `{"username":"group_share_member"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(memberLogin.AccessToken, "POST", groupURL+"/members", `{"username":"group_share_outsider"}`).Code,
+		"Only the owner manages the members")
+
+	rr = do(memberLogin.AccessToken, "GET", groupURL, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var details dto.GroupDetails
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &details))
+	require.Len(t, details.Members, 2)
+
+	shareURL := fmt.Sprintf("/api/v1/nodes/%s/share", folder.ID)
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "POST", shareURL,
+		fmt.Sprintf(`{"group_id":%d,"recipient_username":"group_share_member","permissions":"read"}`, group.ID)).Code)
+	rr = do(ownerLogin.AccessToken, "POST", shareURL, fmt.Sprintf(`{"group_id":%d,"permissions":"write"}`, group.ID))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var share models.GroupShare
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &share))
+	require.Equal(t, group.ID, share.GroupID)
+	require.Equal(t, http.StatusConflict, do(ownerLogin.AccessToken, "POST", shareURL, fmt.Sprintf(`{"group_id":%d,"permissions":"read"}`, group.ID)).Code)
+
+	ctx := context.Background()
+	hasAccess, err := testServer.store.HasAccessToNode(ctx, subfolder.ID, member.ID)
+	require.NoError(t, err)
+	require.True(t, hasAccess, "Members reach the shared subtree")
+	canWrite, err := testServer.store.CheckWritePermission(ctx, member.ID, &subfolder.ID)
+	require.NoError(t, err)
+	require.True(t, canWrite)
+	hasAccess, err = testServer.store.HasAccessToNode(ctx, subfolder.ID, outsider.ID)
+	require.NoError(t, err)
+	require.False(t, hasAccess)
+
+	rr = do(memberLogin.AccessToken, "GET", groupURL+"/shares", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), folder.ID)
+	rr = do(memberLogin.AccessToken, "GET", "/api/v1/shares/incoming/users", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "group_share_owner")
+
+	events, err := testServer.store.GetEventsSince(ctx, member.ID, 0, MaxLimit)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	require.Equal(t, "node_shared_with_you", events[len(events)-1].EventType)
+
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, "DELETE", fmt.Sprintf("%s/members/%d", groupURL, owner.ID), "").Code,
+		"The owner cannot leave")
+	require.Equal(t, http.StatusNoContent, do(memberLogin.AccessToken, "DELETE", fmt.Sprintf("%s/members/%d", groupURL, member.ID), "").Code,
+		"Members can leave")
+	hasAccess, err = testServer.store.HasAccessToNode(ctx, subfolder.ID, member.ID)
+	require.NoError(t, err)
+	require.False(t, hasAccess, "Leaving the group takes the access away")
+}
+
+func TestManagePermission(t *testing.T) {
+	owner := createTestUserWithPassword(t, "manage_owner", "password")
+	editor := createTestUserWithPassword(t, "manage_editor", "password")
+	manager := createTestUserWithPassword(t, "manage_manager", "password")
+	createTestUserWithPassword(t, "manage_guest", "password")
+	editorLogin := loginUserForTest(t, "manage_editor", "password")
+	managerLogin := loginUserForTest(t, "manage_manager", "password")
+
+	folder := createTestNodeAPI(t, "Managed", "folder", nil, owner.ID)
+	first := createTestNodeAPI(t, "first.txt", "file", &folder.ID, owner.ID)
+	second := createTestNodeAPI(t, "second.txt", "file", &folder.ID, owner.ID)
+	for recipientID, permissions := range map[int64]string{editor.ID: "write", manager.ID: "manage"} {
+		_, err := testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+			NodeID: folder.ID, SharerID: owner.ID, RecipientID: recipientID, Permissions: permissions,
+		})
+		require.NoError(t, err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/share", testServer.ShareNodeHandler)
+	router.Delete("/api/v1/nodes/{nodeId}", testServer.DeleteNodeHandler)
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	canWrite, err := testServer.store.CheckWritePermission(context.Background(), manager.ID, &folder.ID)
+	require.NoError(t, err)
+	require.True(t, canWrite, "Manage includes write")
+	canManage, err := testServer.store.CheckManagePermission(context.Background(), editor.ID, first.ID)
+	require.NoError(t, err)
+	require.False(t, canManage)
+
+	require.Equal(t, http.StatusForbidden, do(editorLogin.AccessToken, "POST", "/api/v1/nodes/"+first.ID+"/share", `{"recipient_username":"manage_guest","permissions":"read"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(editorLogin.AccessToken, "DELETE", "/api/v1/nodes/"+first.ID, "").Code,
+		"Write permission no longer allows deleting")
+
+	require.Equal(t, http.StatusBadRequest, do(managerLogin.AccessToken, "POST", "/api/v1/nodes/"+first.ID+"/share", `{"recipient_username":"manage_owner","permissions":"read"}`).Code)
+	rr := do(managerLogin.AccessToken, "POST", "/api/v1/nodes/"+first.ID+"/share", `{"recipient_username":"manage_guest","permissions":"read"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var share dto.Share
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &share))
+	require.Equal(t, manager.ID, share.SharerID)
+
+	require.Equal(t, http.StatusNoContent, do(managerLogin.AccessToken, "DELETE", "/api/v1/nodes/"+second.ID, "").Code)
+	trashed, err := testServer.store.GetNodeIfAccessible(context.Background(), second.ID, owner.ID)
+	require.NoError(t, err)
+	require.Nil(t, trashed, "The node is moved to the owner's trash")
+	require.Equal(t, http.StatusForbidden, do(managerLogin.AccessToken, "DELETE", "/api/v1/nodes/"+folder.ID, "").Code,
+		"The shared folder itself stays with its owner")
+}
+
+func TestTransferKind(t *testing.T) {
+	require.Equal(t, database.TransferFull, transferKind(nil, 10))
+	require.Equal(t, database.TransferFull, transferKind([]byteRange{{start: 0, length: 10}}, 10))
+	require.Equal(t, database.TransferResumed, transferKind([]byteRange{{start: 4, length: 6}}, 10))
+	require.Equal(t, database.TransferPartial, transferKind([]byteRange{{start: 0, length: 4}}, 10))
+	require.Equal(t, database.TransferPartial, transferKind([]byteRange{{start: 0, length: 2}, {start: 8, length: 2}}, 10))
+}
+
+func TestNodeTransferStats(t *testing.T) {
+	owner := createTestUserWithPassword(t, "transfer_owner", "password")
+	reader := createTestUserWithPassword(t, "transfer_reader", "password")
+	ownerLogin := loginUserForTest(t, "transfer_owner", "password")
+	readerLogin := loginUserForTest(t, "transfer_reader", "password")
+
+	fileNode := createTestNodeAPI(t, "wyklad.mp4", "file", nil, owner.ID)
+	content := "0123456789abcdef"
+	require.NoError(t, testServer.storage.Save(fileNode.ID, strings.NewReader(content)))
+	mimeType := "video/mp4"
+	_, err := testServer.store.UpdateNodeContent(context.Background(), fileNode.ID, owner.ID, int64(len(content)), &mimeType, nil)
+	require.NoError(t, err)
+	_, err = testServer.store.ShareNode(context.Background(), database.ShareNodeParams{
+		NodeID: fileNode.ID, SharerID: owner.ID, RecipientID: reader.ID, Permissions: "read",
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Get("/api/v1/nodes/{nodeId}/download", testServer.DownloadFileHandler)
+	router.Get("/api/v1/nodes/{nodeId}/transfer-stats", testServer.GetNodeTransferStatsHandler)
+	do := func(token, url, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	downloadURL := "/api/v1/nodes/" + fileNode.ID + "/download"
+	require.Equal(t, http.StatusOK, do(readerLogin.AccessToken, downloadURL, "").Code)
+	require.Equal(t, http.StatusPartialContent, do(readerLogin.AccessToken, downloadURL, "bytes=10-").Code)
+	require.Equal(t, http.StatusPartialContent, do(ownerLogin.AccessToken, downloadURL, "bytes=2-5").Code)
+
+	statsURL := "/api/v1/nodes/" + fileNode.ID + "/transfer-stats"
+	require.Equal(t, http.StatusNotFound, do(readerLogin.AccessToken, statsURL, "").Code, "Only the owner sees the stats")
+	require.Equal(t, http.StatusBadRequest, do(ownerLogin.AccessToken, statsURL+"?days=0", "").Code)
+
+	rr := do(ownerLogin.AccessToken, statsURL, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var stats NodeTransferStatsResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	require.Equal(t, fileNode.ID, stats.NodeID)
+	require.Equal(t, int64(1), stats.FullDownloads)
+	require.Equal(t, int64(1), stats.ResumedDownloads)
+	require.Equal(t, int64(1), stats.PartialRequests)
+	require.Equal(t, int64(2), stats.UniqueUsers)
+	require.Equal(t, int64(len(content)+6+4), stats.BytesServed)
+	require.Zero(t, stats.Interrupted)
+	require.NotNil(t, stats.LastServedAt)
+}
+
+type fakeGeoLocator map[string]*geoip.Location
+
+func (l fakeGeoLocator) Locate(ip string) (*geoip.Location, error) {
+	return l[ip], nil
+}
+
+func TestListSessionsWithLocation(t *testing.T) {
+	user := createTestUserWithPassword(t, "geo_sessions_user", "password")
+	login := loginUserForTest(t, "geo_sessions_user", "password")
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/sessions", testServer.ListSessionsHandler)
+	list := func() []dto.Session {
+		req := httptest.NewRequest("GET", "/api/v1/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var sessions []dto.Session
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sessions))
+		require.Len(t, sessions, 1)
+		return sessions
+	}
+
+	require.Nil(t, list()[0].Location, "Without a GeoIP database sessions have no location")
+
+	sessions, err := testServer.store.ListSessionsForUser(context.Background(), user.ID)
+	require.NoError(t, err)
+	krakow := &geoip.Location{CountryCode: "PL", Country: "Poland", City: "Kraków"}
+	testServer.geoLocator = fakeGeoLocator{sessions[0].ClientIP: krakow}
+	defer func() { testServer.geoLocator = nil }()
+
+	require.Equal(t, krakow, list()[0].Location)
+}
+
+func TestSessionLastUsedAt(t *testing.T) {
+	user := createTestUserWithPassword(t, "last_used_user", "password")
+	login := loginUserForTest(t, "last_used_user", "password")
+	ctx := context.Background()
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/sessions", testServer.ListSessionsHandler)
+	list := func() dto.Session {
+		req := httptest.NewRequest("GET", "/api/v1/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var sessions []dto.Session
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sessions))
+		require.Len(t, sessions, 1)
+		return sessions[0]
+	}
+
+	session := list()
+	require.False(t, session.LastUsedAt.IsZero())
+	require.WithinDuration(t, session.CreatedAt, session.LastUsedAt, time.Second)
+
+	dormant := time.Now().Add(-2 * time.Hour)
+	_, err := testServer.store.GetPool().Exec(ctx, `UPDATE sessions SET last_used_at = $1 WHERE user_id = $2`, dormant, user.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), list().LastUsedAt, time.Minute, "A request on a stale session records activity")
+
+	recent := time.Now().Add(-time.Minute)
+	_, err = testServer.store.GetPool().Exec(ctx, `UPDATE sessions SET last_used_at = $1 WHERE user_id = $2`, recent, user.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, recent, list().LastUsedAt, time.Second, "Recent activity is not rewritten on every request")
+}
+
+func TestListIncomingShares(t *testing.T) {
+	alice := createTestUserWithPassword(t, "incoming_alice", "password")
+	bob := createTestUserWithPassword(t, "incoming_bob", "password")
+	recipient := createTestUserWithPassword(t, "incoming_recipient", "password")
+	login := loginUserForTest(t, "incoming_recipient", "password")
+
+	report := createTestNodeAPI(t, "Raport.pdf", "file", nil, alice.ID)
+	photos := createTestNodeAPI(t, "Zdjęcia", "folder", nil, bob.ID)
+	trashed := createTestNodeAPI(t, "Stare.txt", "file", nil, bob.ID)
+	message := "Do wglądu"
+	for _, params := range []database.ShareNodeParams{
+		{NodeID: report.ID, SharerID: alice.ID, RecipientID: recipient.ID, Permissions: "read", Message: &message},
+		{NodeID: photos.ID, SharerID: bob.ID, RecipientID: recipient.ID, Permissions: "write"},
+		{NodeID: trashed.ID, SharerID: bob.ID, RecipientID: recipient.ID, Permissions: "read"},
+	} {
+		_, err := testServer.store.ShareNode(context.Background(), params)
+		require.NoError(t, err)
+	}
+	_, err := testServer.store.MoveNodeToTrashInBatch(context.Background(), trashed.ID, bob.ID, uuid.New())
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/shares/incoming", testServer.ListIncomingSharesHandler)
+	list := func(query string) (*httptest.ResponseRecorder, []dto.IncomingShare) {
+		req := httptest.NewRequest("GET", "/api/v1/shares/incoming"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		var shares []dto.IncomingShare
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shares))
+		}
+		return rr, shares
+	}
+
+	rr, shares := list("")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Len(t, shares, 2, "Shares of trashed nodes are left out")
+	require.Equal(t, photos.ID, shares[0].Node.ID, "Newest shares come first")
+	require.Equal(t, "incoming_bob", shares[0].SharerUsername)
+	require.Equal(t, "write", shares[0].Permissions)
+	require.Equal(t, "folder", shares[0].Node.NodeType)
+	require.Equal(t, "Do wglądu", *shares[1].Message)
+
+	_, shares = list("?sort=sharer&order=asc")
+	require.Equal(t, []string{"incoming_alice", "incoming_bob"}, []string{shares[0].SharerUsername, shares[1].SharerUsername})
+
+	_, shares = list("?sort=name&limit=1&offset=1")
+	require.Len(t, shares, 1)
+	require.Equal(t, "Zdjęcia", shares[0].Node.Name)
+
+	rr, _ = list("?sort=owner")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	rr, _ = list("?order=up")
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestOverlappingSharesAndEffectiveAccess(t *testing.T) {
+	owner := createTestUserWithPassword(t, "overlap_owner", "password")
+	recipient := createTestUserWithPassword(t, "overlap_recipient", "password")
+	ownerLogin := loginUserForTest(t, "overlap_owner", "password")
+	recipientLogin := loginUserForTest(t, "overlap_recipient", "password")
+
+	folder := createTestNodeAPI(t, "Projekty", "folder", nil, owner.ID)
+	file := createTestNodeAPI(t, "plan.txt", "file", &folder.ID, owner.ID)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Post("/api/v1/nodes/{nodeId}/share", testServer.ShareNodeHandler)
+	router.Get("/api/v1/nodes/{nodeId}/effective-access", testServer.GetEffectiveAccessHandler)
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	share := func(nodeID, permissions string) *httptest.ResponseRecorder {
+		return do(ownerLogin.AccessToken, "POST", "/api/v1/nodes/"+nodeID+"/share", fmt.Sprintf(`{"recipient_username":"overlap_recipient","permissions":"%s"}`, permissions))
+	}
+
+	require.Equal(t, http.StatusCreated, share(folder.ID, "write").Code)
+	rr := share(file.ID, "read")
+	require.Equal(t, http.StatusConflict, rr.Code)
+	require.Contains(t, rr.Body.String(), "Projekty")
+	require.Equal(t, http.StatusConflict, share(file.ID, "write").Code)
+	require.Equal(t, http.StatusCreated, share(file.ID, "manage").Code, "A higher permission than the folder's can still be granted")
+
+	rr = do(ownerLogin.AccessToken, "GET", "/api/v1/nodes/"+file.ID+"/effective-access", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var access []dto.EffectiveAccess
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &access))
+	require.Len(t, access, 1)
+	require.Equal(t, recipient.ID, access[0].UserID)
+	require.Equal(t, "manage", access[0].Permissions)
+	require.Equal(t, file.ID, access[0].ViaNodeID)
+	require.Equal(t, 0, access[0].Depth)
+	require.Equal(t, 2, access[0].Grants)
+
+	rr = do(ownerLogin.AccessToken, "GET", "/api/v1/nodes/"+folder.ID+"/effective-access", "")
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &access))
+	require.Len(t, access, 1)
+	require.Equal(t, "write", access[0].Permissions)
+	require.Equal(t, 1, access[0].Grants)
+
+	require.Equal(t, http.StatusForbidden, do(recipientLogin.AccessToken, "GET", "/api/v1/nodes/"+folder.ID+"/effective-access", "").Code)
+	require.Equal(t, http.StatusOK, do(recipientLogin.AccessToken, "GET", "/api/v1/nodes/"+file.ID+"/effective-access", "").Code)
+}
+
+func TestListNodeShares(t *testing.T) {
+	owner := createTestUserWithPassword(t, "node_shares_owner", "password")
+	reader := createTestUserWithPassword(t, "node_shares_reader", "password")
+	editor := createTestUserWithPassword(t, "node_shares_editor", "password")
+	ownerLogin := loginUserForTest(t, "node_shares_owner", "password")
+	readerLogin := loginUserForTest(t, "node_shares_reader", "password")
+	ctx := context.Background()
+
+	folder := createTestNodeAPI(t, "Zespół", "folder", nil, owner.ID)
+	file := createTestNodeAPI(t, "budżet.xlsx", "file", &folder.ID, owner.ID)
+	_, err := testServer.store.ShareNode(ctx, database.ShareNodeParams{NodeID: folder.ID, SharerID: owner.ID, RecipientID: reader.ID, Permissions: "read"})
+	require.NoError(t, err)
+	_, err = testServer.store.ShareNode(ctx, database.ShareNodeParams{NodeID: file.ID, SharerID: owner.ID, RecipientID: editor.ID, Permissions: "write"})
+	require.NoError(t, err)
+	group, err := testServer.store.CreateGroup(ctx, owner.ID, "Księgowość")
+	require.NoError(t, err)
+	_, err = testServer.store.ShareNodeWithGroup(ctx, database.ShareNodeWithGroupParams{NodeID: folder.ID, SharerID: owner.ID, GroupID: group.ID, Permissions: "read"})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Get("/api/v1/nodes/{nodeId}/shares", testServer.ListNodeSharesHandler)
+	list := func(token, nodeID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/nodes/"+nodeID+"/shares", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := list(ownerLogin.AccessToken, file.ID)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var shares []dto.NodeShare
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shares))
+	require.Len(t, shares, 3)
+
+	require.Equal(t, "node_shares_editor", shares[0].RecipientName)
+	require.Equal(t, "write", shares[0].Permissions)
+	require.Equal(t, file.ID, shares[0].OriginNodeID)
+	require.False(t, shares[0].Inherited)
+
+	require.Equal(t, "group", shares[1].RecipientType)
+	require.Equal(t, "Księgowość", shares[1].RecipientName)
+	require.Equal(t, "user", shares[2].RecipientType)
+	require.Equal(t, reader.ID, shares[2].RecipientID)
+	for _, share := range shares[1:] {
+		require.True(t, share.Inherited)
+		require.Equal(t, folder.ID, share.OriginNodeID)
+		require.Equal(t, "Zespół", share.OriginNodeName)
+	}
+
+	rr = list(ownerLogin.AccessToken, folder.ID)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &shares))
+	require.Len(t, shares, 2, "Shares of descendants are not listed")
+
+	require.Equal(t, http.StatusForbidden, list(readerLogin.AccessToken, file.ID).Code)
+}
+
+func TestPutFileUpload(t *testing.T) {
+	user := createTestUserWithPassword(t, "raw_upload_user", "password")
+	login := loginUserForTest(t, "raw_upload_user", "password")
+	folder := createTestNodeAPI(t, "Z telefonu", "folder", nil, user.ID)
+
+	router := chi.NewRouter()
+	router.With(testServer.AuthMiddleware).Put("/api/v1/nodes/file", testServer.PutFileHandler)
+	put := func(query, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/nodes/file?"+query, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	content := "notatka z telefonu"
+	sum := sha256.Sum256([]byte(content))
+	rr := put("name=notatka.txt&parent_id="+folder.ID, content, map[string]string{"Content-Type": "text/plain", contentSHA256Header: hex.EncodeToString(sum[:])})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var node dto.Node
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &node))
+	require.Equal(t, "notatka.txt", node.Name)
+	require.Equal(t, folder.ID, *node.ParentID)
+	require.Equal(t, int64(len(content)), *node.SizeBytes)
+	require.Equal(t, hex.EncodeToString(sum[:]), *node.SHA256)
+	require.True(t, strings.HasPrefix(*node.MimeType, "text/plain"))
+
+	owner, err := testServer.store.GetUserByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), owner.StorageUsedBytes)
+
+	require.Equal(t, http.StatusBadRequest, put("parent_id="+folder.ID, content, nil).Code, "A name is required")
+	require.Equal(t, http.StatusUnprocessableEntity, put("name=zla.txt", content, map[string]string{contentSHA256Header: strings.Repeat("0", 64)}).Code)
+
+	req := httptest.NewRequest("PUT", "/api/v1/nodes/file?name=strumien.bin", strings.NewReader(content))
+	req.Header.Set("Authorization", "Bearer "+login.AccessToken)
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusLengthRequired, rr.Code)
+
+	children, err := testServer.store.GetNodesByParentID(context.Background(), user.ID, nil, 100, 0)
+	require.NoError(t, err)
+	for _, child := range children {
+		require.NotEqual(t, "zla.txt", child.Name, "A rejected upload leaves no node")
+	}
+}
+
+func TestBlobMigration(t *testing.T) {
+	alice := createTestUserWithPassword(t, "migration_alice", "password")
+	bob := createTestUserWithPassword(t, "migration_bob", "password")
+	aliceLogin := loginUserForTest(t, "migration_alice", "password")
+	bobLogin := loginUserForTest(t, "migration_bob", "password")
+	ctx := context.Background()
+
+	local, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	archive, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	blobs := storage.NewRouter(local)
+	require.NoError(t, blobs.Register("archive", archive))
+	migrationServer := NewServer(testServer.config.Load(), testServer.store, testServer.storage, blobs, testServer.wsHub)
+
+	router := chi.NewRouter()
+	router.Use(testServer.AuthMiddleware)
+	router.Put("/api/v1/nodes/file", migrationServer.PutFileHandler)
+	router.Post("/api/v1/admin/storage/migrations", migrationServer.CreateBlobMigrationHandler)
+	router.Get("/api/v1/admin/storage/migrations/{migrationId}", migrationServer.GetBlobMigrationHandler)
+	do := func(token, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	upload := func(token, name, content string) dto.Node {
+		rr := do(token, "PUT", "/api/v1/nodes/file?name="+name, content)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var node dto.Node
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &node))
+		return node
+	}
+
+	shared := "wspólna treść"
+	aliceFiles := []dto.Node{upload(aliceLogin.AccessToken, "a.txt", shared), upload(aliceLogin.AccessToken, "b.txt", shared), upload(aliceLogin.AccessToken, "c.txt", "tylko alicja")}
+	bobFile := upload(bobLogin.AccessToken, "d.txt", shared)
+
+	require.Equal(t, http.StatusBadRequest, do(aliceLogin.AccessToken, "POST", "/api/v1/admin/storage/migrations", `{"from_backend":"local","to_backend":"s3"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(aliceLogin.AccessToken, "POST", "/api/v1/admin/storage/migrations", `{"from_backend":"local","to_backend":"local"}`).Code)
+
+	rr := do(aliceLogin.AccessToken, "POST", "/api/v1/admin/storage/migrations", fmt.Sprintf(`{"from_backend":"local","to_backend":"archive","owner_id":%d}`, alice.ID))
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	var migration dto.BlobMigration
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &migration))
+	require.Equal(t, database.BlobMigrationQueued, migration.Status)
+	require.Equal(t, 2, migration.TotalBlobs, "Identical content is one blob")
+
+	require.NoError(t, migrationServer.processBlobMigrations(ctx))
+
+	rr = do(aliceLogin.AccessToken, "GET", "/api/v1/admin/storage/migrations/"+migration.ID.String(), "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &migration))
+	require.Equal(t, database.BlobMigrationCompleted, migration.Status)
+	require.Equal(t, 2, migration.MigratedBlobs)
+	require.Equal(t, 0, migration.FailedBlobs)
+	require.Equal(t, int64(len(shared)+len("tylko alicja")), migration.MigratedBytes)
+
+	for _, file := range aliceFiles {
+		backend, _, err := testServer.store.GetNodeStorageBackend(ctx, file.ID)
+		require.NoError(t, err)
+		require.Equal(t, "archive", backend)
+	}
+	backend, _, err := testServer.store.GetNodeStorageBackend(ctx, bobFile.ID)
+	require.NoError(t, err)
+	require.Equal(t, storage.DefaultBackend, backend, "Other users' files stay in place")
+
+	for file, content := range map[string]string{aliceFiles[0].ID: shared, aliceFiles[2].ID: "tylko alicja", bobFile.ID: shared} {
+		stream, err := migrationServer.openNodeContent(ctx, file)
+		require.NoError(t, err)
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	}
+
+	violations, err := testServer.store.CheckInvariants(ctx, false, alice.ID, bob.ID)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+	localKeys, err := local.Keys()
+	require.NoError(t, err)
+	require.Len(t, localKeys, 1, "Only the blob Bob still uses is left in the source")
+	archiveKeys, err := archive.Keys()
+	require.NoError(t, err)
+	require.Len(t, archiveKeys, 2)
+}
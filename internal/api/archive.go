@@ -0,0 +1,337 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"serwer-plikow/internal/models"
+	"time"
+)
+
+// archiveManifestName is the entry that describes the archive's nodes when a
+// manifest is requested. It sits at the archive root.
+const archiveManifestName = "manifest.json"
+
+const archiveManifestVersion = 1
+
+var errArchiveTooLarge = errors.New("archive exceeds the configured entry or size limit")
+
+type ArchiveManifest struct {
+	Version   int                    `json:"version" example:"1"`
+	CreatedAt time.Time              `json:"created_at"`
+	Entries   []ArchiveManifestEntry `json:"entries"`
+}
+
+type ArchiveManifestEntry struct {
+	// Path is the entry name inside the archive, without a trailing slash.
+	Path       string    `json:"path" example:"Dokumenty/raport.pdf"`
+	ID         string    `json:"id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	ParentID   *string   `json:"parent_id"`
+	NodeType   string    `json:"node_type" example:"file"`
+	SizeBytes  *int64    `json:"size_bytes,omitempty"`
+	MimeType   *string   `json:"mime_type,omitempty"`
+	ModifiedAt time.Time `json:"modified_at"`
+	// SHA256 is the hex digest of the content as written to the archive.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// archiveEntryHeader describes a node as a ZIP entry, keeping its modification
+// time and giving it conventional permissions. Go's zip writer sets the UTF-8
+// flag itself for names that need it.
+func archiveEntryHeader(node models.Node, entryPath string) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     entryPath,
+		Method:   zip.Deflate,
+		Modified: node.ModifiedAt,
+	}
+	if node.NodeType == "folder" {
+		header.Name += "/"
+		header.Method = zip.Store
+		header.SetMode(0o755 | fs.ModeDir)
+	} else {
+		header.SetMode(0o644)
+	}
+	return header
+}
+
+// tarEntryHeader is archiveEntryHeader for tar archives. The writer switches
+// to the PAX format for names that do not fit the classic header.
+func tarEntryHeader(node models.Node, entryPath string, sizeBytes int64) *tar.Header {
+	if node.NodeType == "folder" {
+		return &tar.Header{Typeflag: tar.TypeDir, Name: entryPath + "/", Mode: 0o755, ModTime: node.ModifiedAt}
+	}
+	return &tar.Header{Typeflag: tar.TypeReg, Name: entryPath, Mode: 0o644, Size: sizeBytes, ModTime: node.ModifiedAt}
+}
+
+// writeArchiveFile copies content into a new archive entry and returns the
+// SHA-256 of what was written.
+func writeArchiveFile(zipWriter *zip.Writer, header *zip.FileHeader, content io.Reader) (string, error) {
+	entry, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return "", err
+	}
+	return copyHashed(entry, content)
+}
+
+func copyHashed(dst io.Writer, content io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeArchiveManifest(zipWriter *zip.Writer, manifest ArchiveManifest) error {
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     archiveManifestName,
+		Method:   zip.Deflate,
+		Modified: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
+
+// archiveWriter writes the entries of a downloaded archive in one of the
+// download formats.
+type archiveWriter interface {
+	writeFolder(node models.Node, entryPath string) error
+	// writeFile copies content, sizeBytes long when known, into a new entry
+	// and returns the SHA-256 of what was written.
+	writeFile(node models.Node, entryPath string, sizeBytes *int64, content io.Reader) (string, error)
+	writeManifest(manifest ArchiveManifest) error
+	Close() error
+}
+
+// newArchiveWriter returns the writer of an archive download format along
+// with the response's content type and file name.
+func (s *Server) newArchiveWriter(format string, w io.Writer) (archiveWriter, string, string, error) {
+	switch format {
+	case archiveFormatZip:
+		return &zipArchiveWriter{zipWriter: zip.NewWriter(w)}, "application/zip", "archive.zip", nil
+	case archiveFormatTar:
+		return &tarArchiveWriter{tarWriter: tar.NewWriter(w)}, "application/x-tar", "archive.tar", nil
+	case archiveFormatTarGz:
+		gzipWriter, err := gzip.NewWriterLevel(w, s.archiveGzipLevel())
+		if err != nil {
+			return nil, "", "", err
+		}
+		return &tarArchiveWriter{tarWriter: tar.NewWriter(gzipWriter), gzipWriter: gzipWriter}, "application/gzip", "archive.tar.gz", nil
+	}
+	return nil, "", "", fmt.Errorf("unsupported archive format %q", format)
+}
+
+// archiveGzipLevel is the compression level of tar.gz downloads.
+func (s *Server) archiveGzipLevel() int {
+	if level := s.config.Load().Archive.GzipLevel; level >= gzip.BestSpeed && level <= gzip.BestCompression {
+		return level
+	}
+	return gzip.DefaultCompression
+}
+
+type zipArchiveWriter struct {
+	zipWriter *zip.Writer
+}
+
+func (zw *zipArchiveWriter) writeFolder(node models.Node, entryPath string) error {
+	_, err := zw.zipWriter.CreateHeader(archiveEntryHeader(node, entryPath))
+	return err
+}
+
+func (zw *zipArchiveWriter) writeFile(node models.Node, entryPath string, _ *int64, content io.Reader) (string, error) {
+	return writeArchiveFile(zw.zipWriter, archiveEntryHeader(node, entryPath), content)
+}
+
+func (zw *zipArchiveWriter) writeManifest(manifest ArchiveManifest) error {
+	return writeArchiveManifest(zw.zipWriter, manifest)
+}
+
+func (zw *zipArchiveWriter) Close() error {
+	return zw.zipWriter.Close()
+}
+
+// tarArchiveWriter streams a tar archive, gzip-compressed when gzipWriter is
+// set. Tar entries carry their size up front, so files of unknown size are
+// refused with errUnknownEntrySize.
+type tarArchiveWriter struct {
+	tarWriter  *tar.Writer
+	gzipWriter *gzip.Writer
+}
+
+var errUnknownEntrySize = errors.New("the size of the file is unknown")
+
+func (tw *tarArchiveWriter) writeFolder(node models.Node, entryPath string) error {
+	return tw.tarWriter.WriteHeader(tarEntryHeader(node, entryPath, 0))
+}
+
+func (tw *tarArchiveWriter) writeFile(node models.Node, entryPath string, sizeBytes *int64, content io.Reader) (string, error) {
+	if sizeBytes == nil {
+		return "", errUnknownEntrySize
+	}
+	if err := tw.tarWriter.WriteHeader(tarEntryHeader(node, entryPath, *sizeBytes)); err != nil {
+		return "", err
+	}
+	return copyHashed(tw.tarWriter, content)
+}
+
+func (tw *tarArchiveWriter) writeManifest(manifest ArchiveManifest) error {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	encoded = append(encoded, '\n')
+	if err := tw.tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveManifestName,
+		Mode:     0o644,
+		Size:     int64(len(encoded)),
+		ModTime:  manifest.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	_, err = tw.tarWriter.Write(encoded)
+	return err
+}
+
+func (tw *tarArchiveWriter) Close() error {
+	if err := tw.tarWriter.Close(); err != nil {
+		return err
+	}
+	if tw.gzipWriter != nil {
+		return tw.gzipWriter.Close()
+	}
+	return nil
+}
+
+// archiveWalker streams nodes into an archive while walking their subtrees,
+// one page of children at a time, so memory use does not grow with the size of
+// the tree. The limits are checked up front by the handler and enforced again
+// here in case the tree grew in the meantime.
+type archiveWalker struct {
+	s      *Server
+	userID int64
+	writer archiveWriter
+	// manifest collects the written entries; nil unless one was requested.
+	manifest   *ArchiveManifest
+	entries    int64
+	maxEntries int64
+	bytes      int64
+	maxBytes   int64
+}
+
+// add writes node and, for a folder, everything below it. A file whose content
+// cannot be read, that is quarantined or that the format cannot hold is
+// skipped; errArchiveTooLarge and
+// context errors abort the walk.
+func (aw *archiveWalker) add(ctx context.Context, node models.Node, entryPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	aw.entries++
+	if aw.entries > aw.maxEntries {
+		return errArchiveTooLarge
+	}
+
+	entry := ArchiveManifestEntry{
+		Path:       entryPath,
+		ID:         node.ID,
+		ParentID:   node.ParentID,
+		NodeType:   node.NodeType,
+		SizeBytes:  node.SizeBytes,
+		MimeType:   node.MimeType,
+		ModifiedAt: node.ModifiedAt,
+	}
+
+	if node.NodeType == "folder" {
+		if err := aw.writer.writeFolder(node, entryPath); err != nil {
+			return err
+		}
+		aw.record(entry)
+		return aw.addChildren(ctx, node, entryPath)
+	}
+
+	if quarantined, err := aw.s.isQuarantined(ctx, node.ID); err != nil || quarantined {
+		if err != nil {
+			log.Printf("ERROR checking quarantine of %s: %v", node.Name, err)
+		}
+		return nil
+	}
+	content, sizeBytes, err := aw.openFile(ctx, &node)
+	if err != nil {
+		log.Printf("ERROR getting file stream for %s: %v", node.Name, err)
+		return nil
+	}
+	defer content.Close()
+	if sizeBytes != nil {
+		aw.bytes += *sizeBytes
+		entry.SizeBytes = sizeBytes
+	}
+	if aw.bytes > aw.maxBytes {
+		return errArchiveTooLarge
+	}
+	entry.SHA256, err = aw.writer.writeFile(node, entryPath, sizeBytes, content)
+	if errors.Is(err, errUnknownEntrySize) {
+		log.Printf("ERROR: Skipping %s in a tar archive: %v", node.Name, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	aw.record(entry)
+	return nil
+}
+
+// addChildren walks a folder page by page. Children share the folder's owner,
+// so the folder being accessible makes all of them accessible.
+func (aw *archiveWalker) addChildren(ctx context.Context, folder models.Node, folderPath string) error {
+	for offset := 0; ; offset += MaxLimit {
+		children, err := aw.s.store.GetNodesByParentID(ctx, folder.OwnerID, &folder.ID, MaxLimit, offset)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			child.OwnerID = folder.OwnerID
+			child.ParentID = &folder.ID
+			if err := aw.add(ctx, child, path.Join(folderPath, child.Name)); err != nil {
+				return err
+			}
+		}
+		if len(children) < MaxLimit {
+			return nil
+		}
+	}
+}
+
+// openFile opens the content of a file, honouring a version pinned by a share
+// with the user.
+func (aw *archiveWalker) openFile(ctx context.Context, node *models.Node) (io.ReadCloser, *int64, error) {
+	pinned, err := aw.s.pinnedVersionFor(ctx, node, aw.userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pinned != nil {
+		content, sizeBytes, _, err := aw.s.openNodeVersion(ctx, node, *pinned)
+		return content, sizeBytes, err
+	}
+	content, err := aw.s.openNodeContent(ctx, node.ID)
+	return content, node.SizeBytes, err
+}
+
+func (aw *archiveWalker) record(entry ArchiveManifestEntry) {
+	if aw.manifest != nil {
+		aw.manifest.Entries = append(aw.manifest.Entries, entry)
+	}
+}
@@ -0,0 +1,908 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTar   = "tar"
+	archiveFormatTarGz = "tar.gz"
+
+	// archiveImportStaleAfter is how long a running import may go without a
+	// progress update before another worker claims it again.
+	archiveImportStaleAfter = time.Hour
+)
+
+var (
+	errUnsafeArchivePath     = errors.New("archive entry path escapes the target folder")
+	errArchiveTooManyEntries = errors.New("the archive has too many entries")
+	errArchiveExpandsTooMuch = errors.New("the archive expands to far more data than it holds and looks like a zip bomb")
+)
+
+// archiveEntry is a file or folder read from an uploaded archive. Path is
+// cleaned and relative to the import target.
+type archiveEntry struct {
+	Path  string
+	IsDir bool
+	Size  int64
+	open  func() (io.ReadCloser, error)
+}
+
+// cleanArchivePath turns an entry name into a relative slash-separated path.
+// Absolute names and names climbing out with ".." are rejected rather than
+// silently rewritten (zip-slip). An empty result means the entry is the
+// archive root and can be skipped.
+func cleanArchivePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("%w: %s", errUnsafeArchivePath, name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: %s", errUnsafeArchivePath, name)
+		}
+		if len(segment) > 255 {
+			return "", fmt.Errorf("archive entry name is too long: %s", segment)
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// archiveFormat picks the archive format from the format query parameter or,
+// failing that, the request's Content-Type.
+func archiveFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return archiveFormatForType(mediaType)
+}
+
+// archiveFormatOfFile recognizes a stored archive by its name or, failing
+// that, its MIME type.
+func archiveFormatOfFile(node *models.Node) string {
+	name := strings.ToLower(node.Name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveFormatZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveFormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveFormatTarGz
+	}
+	if node.MimeType == nil {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(*node.MimeType)
+	return archiveFormatForType(mediaType)
+}
+
+func archiveFormatForType(mediaType string) string {
+	switch mediaType {
+	case "application/zip", "application/x-zip-compressed":
+		return archiveFormatZip
+	case "application/x-tar":
+		return archiveFormatTar
+	case "application/gzip", "application/x-gzip", "application/x-compressed-tar":
+		return archiveFormatTarGz
+	}
+	return ""
+}
+
+func isArchiveFormat(format string) bool {
+	return format == archiveFormatZip || format == archiveFormatTar || format == archiveFormatTarGz
+}
+
+// walkArchive calls fn for every file and folder of an archive, in archive
+// order. Content is only read when fn opens an entry, so tar archives are
+// streamed and zip archives are read through their central directory. Links
+// and other special entries are skipped.
+func walkArchive(format string, file *os.File, fn func(entry archiveEntry) error) error {
+	var tarStream io.Reader = file
+	switch format {
+	case archiveFormatZip:
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		reader, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, f := range reader.File {
+			mode := f.Mode()
+			if !mode.IsDir() && !mode.IsRegular() {
+				continue
+			}
+			entryPath, err := cleanArchivePath(f.Name)
+			if err != nil {
+				return err
+			}
+			if entryPath == "" {
+				continue
+			}
+			if err := fn(archiveEntry{Path: entryPath, IsDir: mode.IsDir(), Size: int64(f.UncompressedSize64), open: f.Open}); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case archiveFormatTarGz:
+		decompressed, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		tarStream = decompressed
+		fallthrough
+
+	case archiveFormatTar:
+		reader := tar.NewReader(tarStream)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg {
+				continue
+			}
+			entryPath, err := cleanArchivePath(header.Name)
+			if err != nil {
+				return err
+			}
+			if entryPath == "" {
+				continue
+			}
+			entry := archiveEntry{
+				Path:  entryPath,
+				IsDir: header.Typeflag == tar.TypeDir,
+				Size:  header.Size,
+				open:  func() (io.ReadCloser, error) { return io.NopCloser(reader), nil },
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("unsupported archive format %q", format)
+}
+
+// archiveSummary is what an archive would create, gathered before anything is
+// imported.
+type archiveSummary struct {
+	Entries   int
+	Bytes     int64
+	TopLevel  int
+	MaxHeight int
+}
+
+// summarizeArchive walks an archive without extracting it. It stops as soon
+// as the archive turns out to have more than maxEntries entries or to expand
+// to more than maxBytes, so a compressed archive crafted to expand to
+// enormous sizes (a zip bomb) is not decompressed in full.
+func summarizeArchive(format string, file *os.File, maxEntries int, maxBytes int64) (archiveSummary, error) {
+	var summary archiveSummary
+	topLevel := make(map[string]bool)
+	err := walkArchive(format, file, func(entry archiveEntry) error {
+		summary.Entries++
+		if summary.Entries > maxEntries {
+			return fmt.Errorf("%w: at most %d entries can be extracted", errArchiveTooManyEntries, maxEntries)
+		}
+		if !entry.IsDir {
+			summary.Bytes += entry.Size
+			if summary.Bytes > maxBytes {
+				return errArchiveExpandsTooMuch
+			}
+		}
+		segments := strings.Split(entry.Path, "/")
+		topLevel[segments[0]] = true
+		if len(segments) > summary.MaxHeight {
+			summary.MaxHeight = len(segments)
+		}
+		return nil
+	})
+	summary.TopLevel = len(topLevel)
+	return summary, err
+}
+
+// publishArchiveImportEvent pushes an import update to the requester. Progress
+// updates are only sent over WebSocket; final states are journaled as well.
+func (s *Server) publishArchiveImportEvent(ctx context.Context, eventType string, job *database.ArchiveImport, journal bool) {
+	if journal {
+		if err := s.store.LogEvent(ctx, job.RequestedBy, eventType, job); err != nil {
+			log.Printf("ERROR: Failed to journal %s for archive import %s: %v", eventType, job.ID, err)
+		}
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": dto.ArchiveImportFrom(*job)})
+	s.wsHub.PublishEvent(job.RequestedBy, eventBytes)
+}
+
+func (s *Server) updateArchiveImport(ctx context.Context, job *database.ArchiveImport, status string, progress int, createdNodes int, errorMessage *string) *database.ArchiveImport {
+	updated, err := s.store.UpdateArchiveImport(ctx, job.ID, status, progress, createdNodes, errorMessage)
+	if err != nil || updated == nil {
+		log.Printf("ERROR: Failed to update archive import %s: %v", job.ID, err)
+		return job
+	}
+	return updated
+}
+
+// archiveImporter creates the nodes of one archive under a folder, merging
+// archive folders into existing folders of the same name.
+type archiveImporter struct {
+	s           *Server
+	requestedBy int64
+	ownerID     int64
+	remaining   int64
+	folders     map[string]*string
+	created     []models.Node
+	// quarantined holds the content policy decisions for created files put in
+	// quarantine.
+	quarantined map[string]*contentpolicy.Decision
+}
+
+func (imp *archiveImporter) createNode(ctx context.Context, parentID *string, name, nodeType string, size *int64, mimeType *string, contentSHA256 *string, backendName string, nodeID string, quarantine *contentpolicy.Decision) (*models.Node, error) {
+	var node *models.Node
+	var duplicate bool
+	placedKey := ""
+	err := imp.s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var storageKey *string
+		if nodeType == "file" {
+			backend, err := imp.s.blobs.Backend(backendName)
+			if err != nil {
+				return err
+			}
+			key, dup, err := imp.s.storeContentBlob(ctx, q, backend, nodeID, backendName, backend, *contentSHA256, *size)
+			if err != nil {
+				return err
+			}
+			storageKey, duplicate = &key, dup
+			if !dup {
+				placedKey = key
+			}
+		}
+		var err error
+		node, err = q.CreateNode(ctx, database.CreateNodeParams{
+			ID:             nodeID,
+			OwnerID:        imp.ownerID,
+			ParentID:       parentID,
+			Name:           name,
+			NodeType:       nodeType,
+			SizeBytes:      size,
+			MimeType:       mimeType,
+			StorageBackend: backendName,
+			ContentSHA256:  contentSHA256,
+			StorageKey:     storageKey,
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.LogEvents(ctx, nodeCreatedEvents(imp.requestedBy, imp.ownerID, node)); err != nil {
+			return err
+		}
+		if size == nil {
+			return nil
+		}
+		if quarantine != nil {
+			if err := q.QuarantineNode(ctx, nodeID, contentpolicy.ActionUpload, quarantine.Rule, quarantine.Reason); err != nil {
+				return err
+			}
+		}
+		return q.UpdateUserStorage(ctx, imp.ownerID, *size)
+	})
+	if err != nil {
+		if placedKey != "" {
+			if backend, backendErr := imp.s.blobs.Backend(backendName); backendErr == nil {
+				imp.s.discardPlacedBlob(ctx, backendName, backend, placedKey)
+			}
+		}
+		return nil, err
+	}
+	if duplicate {
+		backend, err := imp.s.blobs.Backend(backendName)
+		if err == nil {
+			err = backend.Delete(nodeID)
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to delete duplicate content %s: %v", nodeID, err)
+		}
+	}
+	imp.created = append(imp.created, *node)
+	if quarantine != nil {
+		if imp.quarantined == nil {
+			imp.quarantined = make(map[string]*contentpolicy.Decision)
+		}
+		imp.quarantined[nodeID] = quarantine
+	}
+	return node, nil
+}
+
+// folder returns the ID of the folder for a directory path of the archive,
+// creating missing folders along the way.
+func (imp *archiveImporter) folder(ctx context.Context, dir string) (*string, error) {
+	if id, ok := imp.folders[dir]; ok {
+		return id, nil
+	}
+	parentID, err := imp.folder(ctx, path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(dir)
+	existing, err := imp.s.store.GetChildFolderByName(ctx, imp.ownerID, parentID, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		imp.folders[dir] = &existing.ID
+		return &existing.ID, nil
+	}
+
+	name, err = imp.s.freeNodeName(ctx, imp.ownerID, parentID, name)
+	if err != nil {
+		return nil, err
+	}
+	nodeID, err := imp.s.generateUniqueID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := imp.createNode(ctx, parentID, name, "folder", nil, nil, nil, "", nodeID, nil)
+	if err != nil {
+		return nil, err
+	}
+	imp.folders[dir] = &folder.ID
+	return &folder.ID, nil
+}
+
+func (imp *archiveImporter) file(ctx context.Context, entry archiveEntry) error {
+	if entry.Size > imp.remaining {
+		return errQuotaExceeded
+	}
+	parentID, err := imp.folder(ctx, path.Dir(entry.Path))
+	if err != nil {
+		return err
+	}
+	name, err := imp.s.freeNodeName(ctx, imp.ownerID, parentID, path.Base(entry.Path))
+	if err != nil {
+		return err
+	}
+	nodeID, err := imp.s.generateUniqueID(ctx)
+	if err != nil {
+		return err
+	}
+
+	content, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	mimeType, sniffed, err := sniffReader(content, name, "")
+	if err != nil {
+		return err
+	}
+	if err := imp.s.checkContentType(entry.Path, mimeType); err != nil {
+		return err
+	}
+	size := entry.Size
+	backendName, backend, err := imp.s.routeContent(size, &mimeType)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	err = backend.Save(nodeID, io.TeeReader(sniffed, hasher))
+	if err != nil {
+		backend.Delete(nodeID)
+		return fmt.Errorf("failed to save %s: %w", entry.Path, err)
+	}
+	contentSHA256 := hexSum(hasher)
+
+	described := contentpolicy.File{Name: entry.Path, MimeType: mimeType, SizeBytes: size}
+	quarantine, err := imp.s.evaluateUpload(ctx, described, func() (io.ReadCloser, error) { return backend.Get(nodeID) })
+	if err != nil {
+		if cleanupErr := backend.Delete(nodeID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up orphaned file %s: %v", nodeID, cleanupErr)
+		}
+		return err
+	}
+
+	if _, err := imp.createNode(ctx, parentID, name, "file", &size, &mimeType, &contentSHA256, backendName, nodeID, quarantine); err != nil {
+		if cleanupErr := backend.Delete(nodeID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up orphaned file %s: %v", nodeID, cleanupErr)
+		}
+		return err
+	}
+	imp.remaining -= size
+	return nil
+}
+
+// publish announces the created nodes folder by folder.
+func (imp *archiveImporter) publish(ctx context.Context, requestedBy int64) {
+	var order []string
+	byParent := make(map[string][]models.Node)
+	for _, node := range imp.created {
+		key := ""
+		if node.ParentID != nil {
+			key = *node.ParentID
+		}
+		if _, ok := byParent[key]; !ok {
+			order = append(order, key)
+		}
+		byParent[key] = append(byParent[key], node)
+	}
+	for _, key := range order {
+		nodes := byParent[key]
+		imp.s.publishUploadedNodes(ctx, requestedBy, &imp.ownerID, nodes[0].ParentID, nodes)
+	}
+	for i := range imp.created {
+		if decision, ok := imp.quarantined[imp.created[i].ID]; ok {
+			imp.s.notifyQuarantine(ctx, &imp.created[i], contentpolicy.ActionUpload, decision)
+		}
+	}
+}
+
+// importDestination checks, when a background import starts, that the
+// requester can still write to the target folder, and returns the folder's
+// owner and the storage quota the owner has left. It returns a message for the
+// requester instead when the import cannot proceed.
+func (s *Server) importDestination(ctx context.Context, requestedBy int64, parentID *string) (int64, int64, string) {
+	ownerID := requestedBy
+	if parentID != nil {
+		parent, err := s.store.GetNodeIfAccessible(ctx, *parentID, requestedBy)
+		if err != nil {
+			log.Printf("ERROR: Failed to load target folder %s of an import: %v", *parentID, err)
+			return 0, 0, "Failed to load the target folder"
+		}
+		if parent == nil {
+			return 0, 0, "The target folder is no longer available"
+		}
+		ownerID = parent.OwnerID
+	}
+	hasPermission, err := s.store.CheckWritePermission(ctx, requestedBy, parentID)
+	if err != nil || !hasPermission {
+		return 0, 0, "Write permission to the target folder was lost"
+	}
+	owner, err := s.store.GetUserByID(ctx, ownerID)
+	if err != nil || owner == nil {
+		log.Printf("ERROR: Failed to load owner %d for an import: %v", ownerID, err)
+		return 0, 0, "Failed to check the storage quota"
+	}
+	return ownerID, owner.StorageQuotaBytes - owner.StorageUsedBytes, ""
+}
+
+func (s *Server) runArchiveImport(ctx context.Context, job *database.ArchiveImport) {
+	defer func() {
+		if err := s.storage.Delete(job.StagedID); err != nil {
+			log.Printf("ERROR: Failed to remove staged archive %s of import %s: %v", job.StagedID, job.ID, err)
+		}
+	}()
+
+	imp := &archiveImporter{s: s, requestedBy: job.RequestedBy, folders: map[string]*string{".": job.ParentID}}
+	fail := func(message string) {
+		imp.publish(ctx, job.RequestedBy)
+		job = s.updateArchiveImport(ctx, job, database.ArchiveImportFailed, job.Progress, len(imp.created), &message)
+		s.publishArchiveImportEvent(ctx, "archive_import_failed", job, true)
+	}
+
+	var failure string
+	imp.ownerID, imp.remaining, failure = s.importDestination(ctx, job.RequestedBy, job.ParentID)
+	if failure != "" {
+		fail(failure)
+		return
+	}
+
+	archive, err := s.storage.Open(job.StagedID)
+	if err != nil {
+		log.Printf("ERROR: Failed to open staged archive of import %s: %v", job.ID, err)
+		fail("The uploaded archive is no longer available")
+		return
+	}
+	defer archive.Close()
+
+	processed := 0
+	err = walkArchive(job.Format, archive, func(entry archiveEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir {
+			if _, err := imp.folder(ctx, entry.Path); err != nil {
+				return err
+			}
+		} else if err := imp.file(ctx, entry); err != nil {
+			return err
+		}
+
+		processed++
+		if progress := processed * 100 / max(job.TotalEntries, 1); progress >= job.Progress+10 && progress < 100 {
+			job = s.updateArchiveImport(ctx, job, database.ArchiveImportRunning, progress, len(imp.created), nil)
+			s.publishArchiveImportEvent(ctx, "archive_import_progress", job, false)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("WARN: Archive import %s failed: %v", job.ID, err)
+		var typeErr *contentTypeError
+		var policyErr *contentPolicyError
+		if errors.Is(err, errQuotaExceeded) {
+			fail("Storage quota for the owner of this folder is exceeded")
+		} else if errors.As(err, &typeErr) {
+			fail(typeErr.Error())
+		} else if errors.As(err, &policyErr) {
+			fail(policyErr.Error())
+		} else {
+			fail("Failed to import the archive")
+		}
+		return
+	}
+
+	imp.publish(ctx, job.RequestedBy)
+	job = s.updateArchiveImport(ctx, job, database.ArchiveImportCompleted, 100, len(imp.created), nil)
+	s.publishArchiveImportEvent(ctx, "archive_import_completed", job, true)
+}
+
+// processArchiveImports works through the archive import queue until it is
+// empty.
+func (s *Server) processArchiveImports(ctx context.Context) error {
+	for ctx.Err() == nil {
+		job, err := s.store.ClaimArchiveImport(ctx, archiveImportStaleAfter)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		s.runArchiveImport(ctx, job)
+	}
+	return nil
+}
+
+// @Summary      Import an archive
+// @Description  Uploads a ZIP, tar or tar.gz archive as the raw request body and expands it server-side into the target folder. Folders in the archive are merged into existing folders of the same name; files whose names are taken get a numbered name. Entries with absolute paths or ".." segments reject the whole archive. The archive is checked against the extraction limits, the owner's storage quota and the folder limits before the import is queued. Progress is reported over WebSocket as "archive_import_progress" events, followed by "archive_import_completed" or "archive_import_failed".
+// @Tags         nodes
+// @Accept       application/zip
+// @Accept       application/x-tar
+// @Accept       application/gzip
+// @Produce      json
+// @Security     BearerAuth
+// @Param        parent_id  query     string  false  "ID of the target folder, the root when omitted"
+// @Param        format     query     string  false  "Archive format, 'zip', 'tar' or 'tar.gz'; taken from Content-Type when omitted"
+// @Success      202        {object}  dto.ArchiveImport
+// @Failure      400        {string}  string "Bad Request - Unknown format, or an invalid, empty or unsafe archive"
+// @Failure      401        {string}  string "Unauthorized"
+// @Failure      403        {string}  string "Forbidden - Write permission denied"
+// @Failure      404        {string}  string "Not Found - Parent folder not found"
+// @Failure      413        {object}  UploadLimitError "Payload Too Large - The request size limit or the owner's storage quota is exceeded"
+// @Failure      422        {string}  string "Unprocessable Entity - Too many entries, a zip bomb or folder limits exceeded"
+// @Failure      500        {string}  string "Internal Server Error"
+// @Router       /nodes/import-archive [post]
+func (s *Server) ImportArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	format := archiveFormat(r)
+	if !isArchiveFormat(format) {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidArchiveFormat)
+		return
+	}
+
+	var parentID *string
+	if parentIDStr := r.URL.Query().Get("parent_id"); parentIDStr != "" {
+		if len(parentIDStr) != 21 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+			return
+		}
+		parentID = &parentIDStr
+	}
+
+	ownerID, ok := s.archiveImportOwner(w, r, claims.UserID, parentID)
+	if !ok {
+		return
+	}
+
+	stagedID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveStageFailed)
+		return
+	}
+	queued := false
+	defer func() {
+		if queued {
+			return
+		}
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged archive %s: %v", stagedID, cleanupErr)
+		}
+	}()
+
+	if !s.limitRequestBody(w, r) {
+		return
+	}
+	if err := s.storage.Save(stagedID, r.Body); err != nil {
+		if s.writeRequestTooLarge(w, err) {
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, i18n.ArchiveReceiveFailed)
+		return
+	}
+
+	queued = s.queueArchiveImport(w, r, database.CreateArchiveImportParams{
+		RequestedBy: claims.UserID,
+		ParentID:    parentID,
+		Format:      format,
+		StagedID:    stagedID,
+	}, ownerID)
+}
+
+type ExtractArchiveRequest struct {
+	// ParentID is the folder to extract into; the archive's own folder when
+	// omitted.
+	ParentID *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// Format overrides the format recognized from the file name and type.
+	Format string `json:"format,omitempty" example:"tar.gz" enums:"zip,tar,tar.gz"`
+}
+
+// @Summary      Extract an archive file
+// @Description  Unpacks a stored ZIP, tar or tar.gz file server-side into a folder, by default the folder the archive is in, creating folders and files recursively. The extraction runs as the same background job as POST /nodes/import-archive, with the same merging of folders, quota and folder limit checks and protection against entries escaping the target folder. Archives with more entries than limits.max_extract_entries, or expanding to more than limits.max_extract_ratio times their size (zip bombs), are refused. Progress is reported over WebSocket as "archive_import_progress" events, followed by "archive_import_completed" or "archive_import_failed".
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId   path      string                 true   "Node ID of the archive file"
+// @Param        request  body      ExtractArchiveRequest  false  "Target folder and format"
+// @Success      202      {object}  dto.ArchiveImport
+// @Failure      400      {string}  string "Bad Request - Not an archive, or an invalid, empty or unsafe archive"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Write permission denied or the archive is quarantined"
+// @Failure      404      {string}  string "Not Found - Archive or target folder not found"
+// @Failure      413      {string}  string "Payload Too Large - The owner's storage quota is exceeded"
+// @Failure      422      {string}  string "Unprocessable Entity - Too many entries, a zip bomb or folder limits exceeded"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/extract [post]
+func (s *Server) ExtractArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	var req ExtractArchiveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+			return
+		}
+	}
+	if req.ParentID != nil && len(*req.ParentID) != 21 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+		return
+	}
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.ArchiveNotAccessible)
+		return
+	}
+	format := req.Format
+	if format == "" && node.NodeType == "file" {
+		format = archiveFormatOfFile(node)
+	}
+	if node.NodeType != "file" || !isArchiveFormat(format) {
+		writeError(w, r, http.StatusBadRequest, i18n.NotAnArchive)
+		return
+	}
+	if quarantined, err := s.isQuarantined(r.Context(), node.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	} else if quarantined {
+		writeError(w, r, http.StatusForbidden, i18n.QuarantinedFile)
+		return
+	}
+
+	parentID := node.ParentID
+	if req.ParentID != nil {
+		parentID = req.ParentID
+	}
+	ownerID, ok := s.archiveImportOwner(w, r, claims.UserID, parentID)
+	if !ok {
+		return
+	}
+
+	pinned, err := s.pinnedVersionFor(r.Context(), node, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	var content io.ReadCloser
+	if pinned != nil {
+		content, _, _, err = s.openNodeVersion(r.Context(), node, *pinned)
+	} else {
+		content, err = s.openNodeContent(r.Context(), node.ID)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer content.Close()
+
+	// The archive is staged like an uploaded one, so the job does not depend
+	// on the file staying unchanged until it runs.
+	stagedID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveStageFailed)
+		return
+	}
+	queued := false
+	defer func() {
+		if queued {
+			return
+		}
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged archive %s: %v", stagedID, cleanupErr)
+		}
+	}()
+	if err := s.storage.Save(stagedID, content); err != nil {
+		log.Printf("ERROR: Failed to stage archive %s for extraction: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveStageFailed)
+		return
+	}
+
+	queued = s.queueArchiveImport(w, r, database.CreateArchiveImportParams{
+		RequestedBy:  claims.UserID,
+		ParentID:     parentID,
+		Format:       format,
+		StagedID:     stagedID,
+		SourceNodeID: &node.ID,
+	}, ownerID)
+}
+
+// archiveImportOwner checks that the user may create nodes in the target
+// folder of an import and returns the folder's owner, whose quota the
+// imported files count against. It writes the error response and returns
+// false otherwise.
+func (s *Server) archiveImportOwner(w http.ResponseWriter, r *http.Request, userID int64, parentID *string) (int64, bool) {
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), userID, parentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return 0, false
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return 0, false
+	}
+
+	if parentID == nil {
+		return userID, true
+	}
+	parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *parentID, userID)
+	if err != nil || parentFolder == nil {
+		s.writeNodeNotFound(w, r, *parentID, i18n.ParentFolderNotAccessible)
+		return 0, false
+	}
+	return parentFolder.OwnerID, true
+}
+
+// queueArchiveImport checks an archive staged in local storage against the
+// extraction limits, the owner's quota and the folder limits, and queues its
+// import, answering 202 with the job. It writes the error response and
+// returns false when the archive is refused; the caller then deletes the
+// staged copy.
+func (s *Server) queueArchiveImport(w http.ResponseWriter, r *http.Request, arg database.CreateArchiveImportParams, ownerID int64) bool {
+	archive, err := s.storage.Open(arg.StagedID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveStageFailed)
+		return false
+	}
+	var archiveSize int64
+	if info, err := archive.Stat(); err == nil {
+		archiveSize = info.Size()
+	}
+	summary, err := summarizeArchive(arg.Format, archive, s.maxExtractEntries(), s.maxExtractedBytes(archiveSize))
+	archive.Close()
+	if err != nil {
+		switch {
+		case errors.Is(err, errArchiveTooManyEntries):
+			writeErrorf(w, r, http.StatusUnprocessableEntity, i18n.ArchiveTooManyEntries, s.maxExtractEntries())
+		case errors.Is(err, errArchiveExpandsTooMuch):
+			writeError(w, r, http.StatusUnprocessableEntity, i18n.ArchiveExpandsTooMuch)
+		default:
+			writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidArchive, err)
+		}
+		return false
+	}
+	if summary.Entries == 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.EmptyArchive)
+		return false
+	}
+
+	ownerUser, err := s.store.GetUserByID(r.Context(), ownerID)
+	if err != nil || ownerUser == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
+		return false
+	}
+	if ownerUser.StorageUsedBytes+summary.Bytes > ownerUser.StorageQuotaBytes {
+		writeError(w, r, http.StatusRequestEntityTooLarge, i18n.StorageQuotaExceeded)
+		return false
+	}
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, arg.ParentID, summary.TopLevel, summary.MaxHeight)) {
+		return false
+	}
+
+	arg.TotalEntries, arg.TotalBytes = summary.Entries, summary.Bytes
+	job, err := s.store.CreateArchiveImport(r.Context(), arg)
+	if err != nil {
+		log.Printf("ERROR: Failed to queue archive import: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ImportQueueFailed)
+		return false
+	}
+	s.publishArchiveImportEvent(r.Context(), "archive_import_progress", job, false)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.ArchiveImportFrom(*job))
+	return true
+}
+
+// @Summary      Get an archive import
+// @Description  Returns the status and progress of an archive import started by the user.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        importId  path      string  true  "Archive import ID"
+// @Success      200       {object}  dto.ArchiveImport
+// @Failure      400       {string}  string "Invalid import ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      404       {string}  string "Not Found"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /archive-imports/{importId} [get]
+func (s *Server) GetArchiveImportHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	importID, err := uuid.Parse(chi.URLParam(r, "importId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidImportID)
+		return
+	}
+
+	job, err := s.store.GetArchiveImport(r.Context(), importID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveImportLookupFailed)
+		return
+	}
+	if job == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ArchiveImportNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.ArchiveImportFrom(*job))
+}
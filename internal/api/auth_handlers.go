@@ -1,16 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"serwer-plikow/internal/auth"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/ldap"
+	"serwer-plikow/internal/models"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jaevor/go-nanoid"
 )
 
 type LoginRequest struct {
@@ -23,8 +27,24 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token" example:"V1StGXR8_Z5jdHi6B-myT78q_Z5jdHi6B-myT78q"`
 }
 
+// checkPassword verifies a password against the directory for accounts
+// synchronized from LDAP and against the stored hash otherwise.
+func (s *Server) checkPassword(ctx context.Context, user *models.User, password string) bool {
+	if user.LDAPDN == nil {
+		return auth.CheckPasswordHash(password, user.PasswordHash)
+	}
+	if s.directory == nil {
+		return false
+	}
+	err := s.directory.Authenticate(ctx, *user.LDAPDN, password)
+	if err != nil && !errors.Is(err, ldap.ErrInvalidCredentials) {
+		log.Printf("ERROR: LDAP authentication of user %d failed: %v", user.ID, err)
+	}
+	return err == nil
+}
+
 // @Summary      Logs a user in
-// @Description  Authenticates a user and returns a short-lived access token and a long-lived refresh token.
+// @Description  Authenticates a user and returns a short-lived access token and a long-lived refresh token. Accounts synchronized from LDAP are checked with the directory; disabled accounts cannot sign in.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -35,45 +55,45 @@ type TokenResponse struct {
 // @Failure      500            {string}  string "Internal Server Error"
 // @Router       /auth/login [post]
 func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if s.config == nil {
+	if s.config.Load() == nil {
 		log.Println("CRITICAL PANIC: s.config is nil in LoginHandler!")
-		http.Error(w, "Server configuration error", 500)
+		writeError(w, r, http.StatusInternalServerError, i18n.ServerConfigError)
 		return
 	}
 
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 
 	user, err := s.store.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.InternalError)
 		return
 	}
-	if user == nil || !auth.CheckPasswordHash(req.Password, user.PasswordHash) {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	if user == nil || user.DisabledAt != nil || !s.checkPassword(r.Context(), user, req.Password) {
+		writeError(w, r, http.StatusUnauthorized, i18n.InvalidCredentials)
 		return
 	}
 
-	accessToken, err := auth.GenerateJWT(user, s.config.JWT.Secret)
+	sessionID := uuid.New()
+	accessToken, err := auth.GenerateSessionJWT(user, sessionID.String(), s.config.Load().JWT.Secret)
 	if err != nil {
-		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.AccessTokenFailed)
 		return
 	}
 
-	generateID, err := nanoid.Standard(40)
+	refreshToken, err := ids.Token()
 	if err != nil {
-		log.Printf("CRITICAL: Failed to initialize nanoid generator: %v", err)
-		http.Error(w, "Internal server error (token generation)", http.StatusInternalServerError)
+		log.Printf("CRITICAL: Failed to generate refresh token: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.TokenGenerationFailed)
 		return
 	}
-	refreshToken := generateID()
 	expiresAt := time.Now().Add(24 * time.Hour)
 
 	sessionParams := database.CreateSessionParams{
-		ID:           uuid.New(),
+		ID:           sessionID,
 		UserID:       user.ID,
 		RefreshToken: refreshToken,
 		UserAgent:    r.UserAgent(),
@@ -84,7 +104,7 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	err = s.store.CreateSession(r.Context(), sessionParams)
 	if err != nil {
 		log.Printf("ERROR: Failed to create session for user %d: %v", user.ID, err)
-		http.Error(w, "Failed to process login session", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.LoginSessionFailed)
 		return
 	}
 
@@ -95,12 +115,14 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" example:"V1StGXR8_Z5jdHi6B-myT78q_Z5jdHi6B-myT78q"`
 }
 
 // @Summary      Refresh access token
-// @Description  Provides a new short-lived access token and a new refresh token in exchange for a valid, non-expired refresh token. Implements refresh token rotation.
+// @Description  Provides a new short-lived access token and a new refresh token in exchange for a valid, non-expired refresh token. Implements refresh token rotation: presenting an already rotated refresh token is treated as token theft, revokes every session descended from the same login and notifies the user with a "session_reuse_detected" event.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -113,36 +135,42 @@ type RefreshTokenRequest struct {
 func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var req RefreshTokenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 	if req.RefreshToken == "" {
-		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.RefreshTokenRequired)
 		return
 	}
 
 	var newAccessToken, newRefreshToken string
 
 	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
-		user, err := q.GetUserByRefreshToken(r.Context(), req.RefreshToken)
+		family, err := q.ConsumeRefreshToken(r.Context(), req.RefreshToken, auth.HashRefreshToken(req.RefreshToken))
 		if err != nil {
 			return err
 		}
-		if user == nil {
-			return errors.New("invalid or expired refresh token")
+		if family == nil {
+			return errInvalidRefreshToken
 		}
 
-		if err := q.DeleteSessionByRefreshToken(r.Context(), req.RefreshToken); err != nil {
+		user, err := q.GetUserByID(r.Context(), family.UserID)
+		if err != nil {
 			return err
 		}
+		if user == nil {
+			return errInvalidRefreshToken
+		}
 
-		newAccessToken, err = auth.GenerateJWT(user, s.config.JWT.Secret)
+		newAccessToken, err = auth.GenerateSessionJWT(user, family.FamilyID.String(), s.config.Load().JWT.Secret)
 		if err != nil {
 			return err
 		}
 
-		generateID, _ := nanoid.Standard(40)
-		newRefreshToken = generateID()
+		newRefreshToken, err = ids.Token()
+		if err != nil {
+			return err
+		}
 		sessionParams := database.CreateSessionParams{
 			ID:           uuid.New(),
 			UserID:       user.ID,
@@ -150,16 +178,18 @@ func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 			UserAgent:    r.UserAgent(),
 			ClientIP:     r.RemoteAddr,
 			ExpiresAt:    time.Now().Add(24 * time.Hour),
+			FamilyID:     family.FamilyID,
 		}
 		return q.CreateSession(r.Context(), sessionParams)
 	})
 
 	if txErr != nil {
-		if txErr.Error() == "invalid or expired refresh token" {
-			http.Error(w, txErr.Error(), http.StatusUnauthorized)
+		if errors.Is(txErr, errInvalidRefreshToken) {
+			s.handleRefreshTokenReuse(r, req.RefreshToken)
+			writeError(w, r, http.StatusUnauthorized, i18n.InvalidRefreshToken)
 		} else {
 			log.Printf("ERROR: Refresh token transaction failed: %v", txErr)
-			http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.TokenRefreshFailed)
 		}
 		return
 	}
@@ -170,3 +200,105 @@ func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 		RefreshToken: newRefreshToken,
 	})
 }
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty" example:"V1StGXR8_Z5jdHi6B-myT78q_Z5jdHi6B-myT78q"`
+}
+
+// @Summary      Log out
+// @Description  Ends the session of the presented token pair: the session holding the refresh token from the body (if given) and the session the access token was issued for are deleted, so neither token can be used anymore.
+// @Tags         auth
+// @Accept       json
+// @Security     BearerAuth
+// @Param        logoutRequest  body      LogoutRequest  false  "Refresh token of the session to end"
+// @Success      204            {null}    nil     "No Content"
+// @Failure      400            {string}  string "Invalid request body"
+// @Failure      401            {string}  string "Unauthorized"
+// @Failure      500            {string}  string "Internal Server Error"
+// @Router       /auth/logout [post]
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+			return
+		}
+	}
+
+	families := []uuid.UUID{}
+	if claims.SessionID != "" {
+		if familyID, err := uuid.Parse(claims.SessionID); err == nil {
+			families = append(families, familyID)
+		}
+	}
+
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		if req.RefreshToken != "" {
+			familyID, err := q.DeleteSessionByRefreshTokenForUser(r.Context(), req.RefreshToken, claims.UserID)
+			if err != nil {
+				return err
+			}
+			if familyID != nil {
+				families = append(families, *familyID)
+			}
+		}
+		for _, familyID := range families {
+			if _, err := q.RevokeSessionFamily(r.Context(), familyID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		log.Printf("ERROR: Failed to log out user %d: %v", claims.UserID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.LogoutFailed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRefreshTokenReuse revokes the session family of a refresh token that
+// was already rotated. Such a token can only be replayed if it leaked, and it
+// is impossible to tell whether the attacker or the user holds the newest
+// token, so neither may keep the session.
+func (s *Server) handleRefreshTokenReuse(r *http.Request, refreshToken string) {
+	family, err := s.store.FindRotatedRefreshToken(r.Context(), auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		log.Printf("ERROR: Failed to check refresh token reuse: %v", err)
+		return
+	}
+	if family == nil {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"family_id":  family.FamilyID,
+		"client_ip":  r.RemoteAddr,
+		"user_agent": r.UserAgent(),
+	}
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		revoked, err := q.RevokeSessionFamily(r.Context(), family.FamilyID)
+		if err != nil {
+			return err
+		}
+		payload["revoked_sessions"] = revoked
+		return q.LogEvent(r.Context(), family.UserID, "session_reuse_detected", payload)
+	})
+	if txErr != nil {
+		log.Printf("CRITICAL: Failed to revoke session family %s after refresh token reuse: %v", family.FamilyID, txErr)
+		return
+	}
+
+	log.Printf("WARN: Refresh token reuse detected for user %d (session family %s) from %s, sessions revoked", family.UserID, family.FamilyID, r.RemoteAddr)
+	eventMsg := map[string]interface{}{"event_type": "session_reuse_detected", "payload": payload}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(family.UserID, eventBytes)
+}
+
+func (s *Server) pruneRotatedRefreshTokens(ctx context.Context) error {
+	_, err := s.store.DeleteExpiredRotatedRefreshTokens(ctx, time.Now())
+	return err
+}
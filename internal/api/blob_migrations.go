@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/storage"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// blobMigrationStaleAfter is how long a running migration may go without
+	// recording progress before another worker claims it again.
+	blobMigrationStaleAfter = time.Hour
+	blobMigrationBatch      = 100
+)
+
+type CreateBlobMigrationRequest struct {
+	FromBackend string `json:"from_backend" example:"local"`
+	ToBackend   string `json:"to_backend" example:"archive"`
+	// OwnerID limits the migration to one user's files; empty migrates the
+	// files of all users.
+	OwnerID *int64 `json:"owner_id,omitempty" example:"2"`
+}
+
+// copyBlobVerified copies a blob to another backend under the same key and
+// reads the copy back, making sure it has the checksum of the source and,
+// when known, the checksum recorded for the content.
+func copyBlobVerified(from storage.Backend, to storage.Backend, key string, expectedSHA256 *string) error {
+	source, err := from.Get(key)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	err = to.Save(key, io.TeeReader(source, hasher))
+	source.Close()
+	if err == nil && expectedSHA256 != nil {
+		err = verifyContentSHA256(key, *expectedSHA256, hexSum(hasher))
+	}
+	if err == nil {
+		var copied io.ReadCloser
+		if copied, err = to.Get(key); err == nil {
+			copyHasher := sha256.New()
+			_, err = io.Copy(copyHasher, copied)
+			copied.Close()
+			if err == nil {
+				err = verifyContentSHA256(key, hexSum(hasher), hexSum(copyHasher))
+			}
+		}
+	}
+	if err != nil {
+		to.Delete(key)
+	}
+	return err
+}
+
+// migrateBlob moves one blob of a migration. The blob is copied and
+// verified first, then the files referencing it are switched to the copy in
+// a transaction, so downloads keep working throughout; the source is removed
+// last, unless files outside the migration still reference it.
+func (s *Server) migrateBlob(ctx context.Context, m *database.BlobMigration, from, to storage.Backend, blob database.MigrationBlob) error {
+	present := false
+	if blob.Deduplicated {
+		var err error
+		if present, err = s.store.ContentBlobRegistered(ctx, m.ToBackend, blob.Key); err != nil {
+			return err
+		}
+	}
+	if !present {
+		if err := copyBlobVerified(from, to, blob.Key, blob.SHA256); err != nil {
+			return err
+		}
+	}
+
+	var moved, remaining int
+	err := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var err error
+		moved, remaining, err = q.MoveBlobReferences(ctx, blob, m.FromBackend, m.ToBackend, m.OwnerID)
+		return err
+	})
+	if err != nil || moved == 0 {
+		// The files were deleted or got new content in the meantime.
+		if !present {
+			s.discardPlacedBlob(ctx, m.ToBackend, to, blob.Key)
+		}
+		return err
+	}
+	if remaining == 0 {
+		// Kept if an upload of the same content registered it again.
+		s.discardPlacedBlob(ctx, m.FromBackend, from, blob.Key)
+	}
+	return nil
+}
+
+// runBlobMigration works through the blobs of a migration in key order,
+// recording progress after each one. Blobs that cannot be moved are counted
+// as failed and stay where they are.
+func (s *Server) runBlobMigration(ctx context.Context, m *database.BlobMigration) {
+	fail := func(err error) {
+		log.Printf("ERROR: Blob migration %s from %q to %q failed: %v", m.ID, m.FromBackend, m.ToBackend, err)
+		if err := s.store.FailBlobMigration(ctx, m.ID, err.Error()); err != nil {
+			log.Printf("ERROR: Failed to record failure of blob migration %s: %v", m.ID, err)
+		}
+	}
+
+	from, err := s.blobs.Backend(m.FromBackend)
+	if err != nil {
+		fail(err)
+		return
+	}
+	to, err := s.blobs.Backend(m.ToBackend)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for ctx.Err() == nil {
+		afterKey := ""
+		if m.LastKey != nil {
+			afterKey = *m.LastKey
+		}
+		blobs, err := s.store.ListMigrationBlobs(ctx, m.FromBackend, m.OwnerID, afterKey, blobMigrationBatch)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if len(blobs) == 0 {
+			if err := s.store.CompleteBlobMigration(ctx, m.ID); err != nil {
+				log.Printf("ERROR: Failed to complete blob migration %s: %v", m.ID, err)
+			}
+			return
+		}
+		for _, blob := range blobs {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.migrateBlob(ctx, m, from, to, blob); err != nil {
+				log.Printf("WARN: Blob migration %s could not move blob %s: %v", m.ID, blob.Key, err)
+				m.FailedBlobs++
+			} else {
+				m.MigratedBlobs++
+				m.MigratedBytes += blob.SizeBytes
+			}
+			m.LastKey = &blob.Key
+			if err := s.store.RecordBlobMigrationProgress(ctx, m); err != nil {
+				log.Printf("ERROR: Failed to record progress of blob migration %s: %v", m.ID, err)
+			}
+		}
+	}
+}
+
+// processBlobMigrations works through the blob migration queue until it is
+// empty.
+func (s *Server) processBlobMigrations(ctx context.Context) error {
+	for ctx.Err() == nil {
+		migration, err := s.store.ClaimBlobMigration(ctx, blobMigrationStaleAfter)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			return nil
+		}
+		s.runBlobMigration(ctx, migration)
+	}
+	return nil
+}
+
+// @Summary      Migrate blobs between storage backends
+// @Description  Queues a migration of file content from one storage backend (a profile named in storage.backends, or "local") to another, for all files or only those of one user. A background job copies each blob, verifies the copy by its SHA-256 checksum, switches the files to it in a transaction and only then removes the source, so files stay available during the migration. Content shared with files outside the migration is copied and kept in place for them. Progress is reported by GET /admin/storage/migrations/{migrationId}. Routing rules still send new content to the source backend unless they are changed first. Archived versions and derived artifacts always stay in the local storage.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      CreateBlobMigrationRequest  true  "Source and target backends, optionally a user"
+// @Success      202      {object}  dto.BlobMigration
+// @Failure      400      {string}  string "Bad Request - Unknown or identical backends"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "User not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/storage/migrations [post]
+func (s *Server) CreateBlobMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req CreateBlobMigrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.FromBackend == "" || req.ToBackend == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.BackendsRequired)
+		return
+	}
+	if req.FromBackend == req.ToBackend {
+		writeError(w, r, http.StatusBadRequest, i18n.SameBackends)
+		return
+	}
+	for _, name := range []string{req.FromBackend, req.ToBackend} {
+		if _, err := s.blobs.Backend(name); err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UnknownStorageBackend, name)
+			return
+		}
+	}
+	if req.OwnerID != nil {
+		owner, err := s.store.GetUserByID(r.Context(), *req.OwnerID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.UserLookupFailed)
+			return
+		}
+		if owner == nil {
+			writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+			return
+		}
+	}
+
+	migration, err := s.store.CreateBlobMigration(r.Context(), claims.UserID, req.FromBackend, req.ToBackend, req.OwnerID)
+	if err != nil {
+		log.Printf("ERROR: Failed to queue blob migration from %q to %q: %v", req.FromBackend, req.ToBackend, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.MigrationQueueFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.BlobMigrationFrom(*migration))
+}
+
+// @Summary      List blob migrations
+// @Description  Lists blob migrations between storage backends, newest first.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.BlobMigration
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/storage/migrations [get]
+func (s *Server) ListBlobMigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	migrations, err := s.store.ListBlobMigrations(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list blob migrations: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.MigrationsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.BlobMigrations(migrations))
+}
+
+// @Summary      Get a blob migration
+// @Description  Returns the status and progress of a blob migration: how many of the blobs counted when it was queued have been moved, their size, and how many could not be moved and were left in the source backend.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        migrationId  path      string  true  "Migration ID"
+// @Success      200          {object}  dto.BlobMigration
+// @Failure      400          {string}  string "Bad Request - Invalid migration ID"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      403          {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404          {string}  string "Blob migration not found"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /admin/storage/migrations/{migrationId} [get]
+func (s *Server) GetBlobMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	migrationID, err := uuid.Parse(chi.URLParam(r, "migrationId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidMigrationID)
+		return
+	}
+	migration, err := s.store.GetBlobMigration(r.Context(), migrationID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.MigrationLookupFailed)
+		return
+	}
+	if migration == nil {
+		writeError(w, r, http.StatusNotFound, i18n.MigrationNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.BlobMigrationFrom(*migration))
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// contentSHA256Header carries the hex SHA-256 checksum the client computed
+// for the content it uploads.
+const contentSHA256Header = "X-Content-SHA256"
+
+// checksumMismatchError reports content whose checksum differs from the one
+// the client sent, meaning it was corrupted in transit.
+type checksumMismatchError struct {
+	name     string
+	expected string
+	actual   string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return i18n.Messagef(i18n.ChecksumMismatch, i18n.English, e.name, e.expected, e.actual)
+}
+
+func (e *checksumMismatchError) errorCode() (string, []any) {
+	return i18n.ChecksumMismatch, []any{e.name, e.expected, e.actual}
+}
+
+// parseContentSHA256 validates a checksum sent by the client and returns it
+// lowercased. An empty value means no checksum was sent.
+func parseContentSHA256(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != sha256.Size {
+		return "", newOpError(http.StatusBadRequest, i18n.InvalidChecksumHeader, contentSHA256Header)
+	}
+	return strings.ToLower(value), nil
+}
+
+// verifyContentSHA256 compares the checksum of received content with the one
+// the client expected, if any.
+func verifyContentSHA256(name, expected, actual string) error {
+	if expected != "" && expected != actual {
+		return &checksumMismatchError{name: name, expected: expected, actual: actual}
+	}
+	return nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashContent returns the hex SHA-256 checksum of everything r yields.
+func hashContent(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hexSum(hasher), nil
+}
+
+type ChecksumResponse struct {
+	NodeID    string `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	Algorithm string `json:"algorithm" example:"sha256"`
+	Checksum  string `json:"checksum" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	SizeBytes int64  `json:"size_bytes" example:"123456"`
+}
+
+// @Summary      Get file checksum
+// @Description  Returns the SHA-256 checksum of a file's content, so sync clients can verify local copies without downloading them. The checksum is recorded when content is uploaded; for files stored before that it is computed on the first request. Recipients of a share pinned to a version get the checksum of that version. The ETag header identifies the version the checksum belongs to.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID of the file"
+// @Success      200     {object}  ChecksumResponse
+// @Failure      400     {string}  string "Bad Request - Node is a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/checksum [get]
+func (s *Server) GetChecksumHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
+		return
+	}
+	if node.NodeType != "file" {
+		writeError(w, r, http.StatusBadRequest, i18n.ChecksumOnFolder)
+		return
+	}
+
+	pinned, err := s.pinnedVersionFor(r.Context(), node, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+
+	response := ChecksumResponse{NodeID: node.ID, Algorithm: "sha256"}
+	etag := contentETag(node)
+	switch {
+	case pinned != nil:
+		backend, key, sizeBytes, _, err := s.nodeVersionBlob(r.Context(), node, *pinned)
+		if err != nil || sizeBytes == nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+			return
+		}
+		content, err := backend.Get(key)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+			return
+		}
+		response.Checksum, err = hashContent(content)
+		content.Close()
+		if err != nil {
+			log.Printf("ERROR: Failed to compute checksum of version %d of node %s: %v", *pinned, node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.ChecksumFailed)
+			return
+		}
+		response.SizeBytes = *sizeBytes
+		etag = fmt.Sprintf("\"%s-v%d\"", node.ID, *pinned)
+	case node.ContentSHA256 != nil:
+		response.Checksum = *node.ContentSHA256
+	default:
+		content, err := s.openNodeContent(r.Context(), node.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+			return
+		}
+		response.Checksum, err = hashContent(content)
+		content.Close()
+		if err != nil {
+			log.Printf("ERROR: Failed to compute checksum of node %s: %v", node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.ChecksumFailed)
+			return
+		}
+		if err := s.store.SetNodeContentSHA256(r.Context(), node.ID, node.ModifiedAt, response.Checksum); err != nil {
+			log.Printf("WARN: Failed to record checksum of node %s: %v", node.ID, err)
+		}
+	}
+	if pinned == nil && node.SizeBytes != nil {
+		response.SizeBytes = *node.SizeBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type CleanupPolicyRequest struct {
+	MaxAgeDays *int `json:"max_age_days,omitempty" example:"90"`
+	KeepNewest *int `json:"keep_newest,omitempty" example:"50"`
+}
+
+type CleanupPreviewResponse struct {
+	Policy     dto.FolderCleanupPolicy `json:"policy"`
+	Files      []dto.Node              `json:"files"`
+	TotalBytes int64                   `json:"total_bytes" example:"1048576"`
+}
+
+func validCleanupLimits(maxAgeDays, keepNewest *int) bool {
+	if maxAgeDays == nil && keepNewest == nil {
+		return false
+	}
+	return (maxAgeDays == nil || *maxAgeDays > 0) && (keepNewest == nil || *keepNewest > 0)
+}
+
+// loadOwnedFolder returns the caller's own folder named in the URL, or writes
+// the error response and returns nil. feature names what is being configured,
+// e.g. "Cleanup policies".
+func (s *Server) loadOwnedFolder(w http.ResponseWriter, r *http.Request, feature string) *models.Node {
+	claims := GetUserFromContext(r.Context())
+
+	folder, err := s.store.GetNodeByID(r.Context(), chi.URLParam(r, "nodeId"), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderLookupFailed)
+		return nil
+	}
+	if folder == nil {
+		writeError(w, r, http.StatusNotFound, i18n.FolderNotOwned)
+		return nil
+	}
+	if folder.NodeType != "folder" {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.FolderOnlySetting, feature)
+		return nil
+	}
+	return folder
+}
+
+// applyFolderCleanup moves the files selected by a cleanup policy to the
+// owner's trash as one deletion batch, so a run can be restored at once.
+func (s *Server) applyFolderCleanup(ctx context.Context, policy database.FolderCleanupPolicy) (int, error) {
+	candidates, err := s.store.ListCleanupCandidates(ctx, policy)
+	if err != nil {
+		return 0, err
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	batchID := uuid.New()
+	trashed := []map[string]string{}
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		for _, node := range candidates {
+			success, err := q.MoveNodeToTrashInBatch(ctx, node.ID, policy.OwnerID, batchID)
+			if err != nil {
+				return err
+			}
+			if !success {
+				continue
+			}
+			payload := map[string]string{"id": node.ID, "parent_id": policy.FolderID, "deletion_batch_id": batchID.String()}
+			if err := q.LogEvent(ctx, policy.OwnerID, "node_trashed", payload); err != nil {
+				return err
+			}
+			trashed = append(trashed, payload)
+		}
+		if len(trashed) == 0 {
+			return nil
+		}
+		if err := s.syncTrashedStorage(ctx, q, policy.OwnerID); err != nil {
+			return err
+		}
+		return q.LogEvent(ctx, policy.OwnerID, "folder_cleaned_up", map[string]interface{}{
+			"folder_id":         policy.FolderID,
+			"deletion_batch_id": batchID,
+			"trashed_count":     len(trashed),
+		})
+	})
+	if txErr != nil {
+		return 0, txErr
+	}
+
+	for _, payload := range trashed {
+		eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "node_trashed", "payload": payload})
+		s.wsHub.PublishEvent(policy.OwnerID, eventBytes)
+	}
+	if len(trashed) > 0 {
+		eventBytes, _ := json.Marshal(map[string]interface{}{
+			"event_type": "folder_cleaned_up",
+			"payload": map[string]interface{}{
+				"folder_id":         policy.FolderID,
+				"deletion_batch_id": batchID,
+				"trashed_count":     len(trashed),
+			},
+		})
+		s.wsHub.PublishEvent(policy.OwnerID, eventBytes)
+		s.notifyWatchers(ctx, []string{policy.FolderID}, eventBytes, policy.OwnerID)
+	}
+	return len(trashed), nil
+}
+
+func (s *Server) runFolderCleanup(ctx context.Context) error {
+	policies, err := s.store.ListFolderCleanupPolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, policy := range policies {
+		trashed, err := s.applyFolderCleanup(ctx, policy)
+		if err != nil {
+			log.Printf("ERROR: Failed to clean up folder %s: %v", policy.FolderID, err)
+			continue
+		}
+		total += trashed
+	}
+	if total > 0 {
+		log.Printf("Folder cleanup: moved %d files to trash", total)
+	}
+	return nil
+}
+
+// @Summary      Get folder cleanup policy
+// @Description  Returns the automatic cleanup policy of a folder owned by the user.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Folder ID"
+// @Success      200     {object}  dto.FolderCleanupPolicy
+// @Failure      400     {string}  string "Bad Request - Not a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder or policy not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/cleanup-policy [get]
+func (s *Server) GetCleanupPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	folder := s.loadOwnedFolder(w, r, "Cleanup policies")
+	if folder == nil {
+		return
+	}
+
+	policy, err := s.store.GetFolderCleanupPolicy(r.Context(), folder.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.CleanupPolicyLookupFailed)
+		return
+	}
+	if policy == nil {
+		writeError(w, r, http.StatusNotFound, i18n.CleanupPolicyNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.FolderCleanupPolicyFrom(*policy))
+}
+
+// @Summary      Set folder cleanup policy
+// @Description  Sets an automatic cleanup policy on a folder owned by the user: files directly in the folder last modified more than max_age_days ago, or beyond the keep_newest most recently modified ones, are moved to the trash by a background job. Each run is one deletion batch that can be restored from the trash at once.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string                true  "Folder ID"
+// @Param        policy  body      CleanupPolicyRequest  true  "Cleanup limits"
+// @Success      200     {object}  dto.FolderCleanupPolicy
+// @Failure      400     {string}  string "Bad Request - Limits must be positive and at least one is required"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/cleanup-policy [put]
+func (s *Server) SetCleanupPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	folder := s.loadOwnedFolder(w, r, "Cleanup policies")
+	if folder == nil {
+		return
+	}
+
+	var req CleanupPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if !validCleanupLimits(req.MaxAgeDays, req.KeepNewest) {
+		writeError(w, r, http.StatusBadRequest, i18n.CleanupPolicyEmpty)
+		return
+	}
+
+	policy, err := s.store.SetFolderCleanupPolicy(r.Context(), folder.ID, claims.UserID, req.MaxAgeDays, req.KeepNewest)
+	if err != nil {
+		log.Printf("ERROR: Failed to save cleanup policy of folder %s: %v", folder.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.CleanupPolicySaveFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.FolderCleanupPolicyFrom(*policy))
+}
+
+// @Summary      Remove folder cleanup policy
+// @Tags         nodes
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Folder ID"
+// @Success      204     {null}    nil     "No Content"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder or policy not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/cleanup-policy [delete]
+func (s *Server) DeleteCleanupPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	folder := s.loadOwnedFolder(w, r, "Cleanup policies")
+	if folder == nil {
+		return
+	}
+
+	deleted, err := s.store.DeleteFolderCleanupPolicy(r.Context(), folder.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.CleanupPolicyRemoveFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.CleanupPolicyNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Preview folder cleanup
+// @Description  Lists the files the folder's cleanup policy would move to the trash right now, without changing anything. The max_age_days and keep_newest parameters override the saved policy, so a policy can be previewed before it is set.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId        path      string  true   "Folder ID"
+// @Param        max_age_days  query     int     false  "Preview with this age limit"
+// @Param        keep_newest   query     int     false  "Preview with this count limit"
+// @Success      200           {object}  CleanupPreviewResponse
+// @Failure      400           {string}  string "Bad Request - Invalid limits"
+// @Failure      401           {string}  string "Unauthorized"
+// @Failure      404           {string}  string "Folder or policy not found"
+// @Failure      500           {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/cleanup-policy/preview [get]
+func (s *Server) PreviewCleanupPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	folder := s.loadOwnedFolder(w, r, "Cleanup policies")
+	if folder == nil {
+		return
+	}
+
+	policy := database.FolderCleanupPolicy{FolderID: folder.ID, OwnerID: claims.UserID}
+	query := r.URL.Query()
+	if query.Has("max_age_days") || query.Has("keep_newest") {
+		for name, target := range map[string]**int{"max_age_days": &policy.MaxAgeDays, "keep_newest": &policy.KeepNewest} {
+			if !query.Has(name) {
+				continue
+			}
+			value, err := strconv.Atoi(query.Get(name))
+			if err != nil {
+				writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidParameter, name)
+				return
+			}
+			*target = &value
+		}
+		if !validCleanupLimits(policy.MaxAgeDays, policy.KeepNewest) {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidCleanupLimits)
+			return
+		}
+	} else {
+		saved, err := s.store.GetFolderCleanupPolicy(r.Context(), folder.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.CleanupPolicyLookupFailed)
+			return
+		}
+		if saved == nil {
+			writeError(w, r, http.StatusNotFound, i18n.CleanupPolicyNotFound)
+			return
+		}
+		policy = *saved
+	}
+
+	files, err := s.store.ListCleanupCandidates(r.Context(), policy)
+	if err != nil {
+		log.Printf("ERROR: Failed to preview cleanup of folder %s: %v", folder.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.CleanupPreviewFailed)
+		return
+	}
+
+	response := CleanupPreviewResponse{Policy: dto.FolderCleanupPolicyFrom(policy), Files: dto.Nodes(files)}
+	for _, file := range files {
+		if file.SizeBytes != nil {
+			response.TotalBytes += *file.SizeBytes
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"time"
+)
+
+const maxClipboardItems = 1000
+
+type ClipboardRequest struct {
+	Operation string   `json:"operation" example:"cut"`
+	NodeIDs   []string `json:"node_ids" example:"V1StGXR8_Z5jdHi6B-myT"`
+}
+
+type ClipboardResponse struct {
+	Operation string     `json:"operation" example:"cut"`
+	Nodes     []dto.Node `json:"nodes"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type PasteRequest struct {
+	// ParentID is the target folder; "root" or null pastes into the user's root.
+	ParentID *string `json:"parent_id" example:"bNowyFolderRodzic123"`
+}
+
+type PasteFailure struct {
+	NodeID string `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Error  string `json:"error" example:"A node with the same name already exists in the target folder"`
+}
+
+type PasteResponse struct {
+	Operation string         `json:"operation" example:"copy"`
+	Pasted    []dto.Node     `json:"pasted"`
+	Failed    []PasteFailure `json:"failed"`
+}
+
+// clipboardNodes resolves the clipboard's node IDs, skipping nodes that were
+// deleted or are no longer accessible to the user.
+func (s *Server) clipboardNodes(r *http.Request, userID int64, clipboard *database.Clipboard) ([]models.Node, error) {
+	nodes := []models.Node{}
+	for _, id := range clipboard.NodeIDs {
+		node, err := s.store.GetNodeIfAccessible(r.Context(), id, userID)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			nodes = append(nodes, *node)
+		}
+	}
+	return nodes, nil
+}
+
+// @Summary      Put nodes on the clipboard
+// @Description  Replaces the user's server-side clipboard with a list of nodes and an operation ("cut" or "copy"). The clipboard is kept on the server, so a later paste needs only the target folder.
+// @Tags         clipboard
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        clipboard  body      ClipboardRequest  true  "Nodes and operation"
+// @Success      200        {object}  ClipboardResponse
+// @Failure      400        {string}  string "Bad Request - Invalid operation or node list"
+// @Failure      401        {string}  string "Unauthorized"
+// @Failure      404        {string}  string "Node not found or access denied"
+// @Failure      500        {string}  string "Internal Server Error"
+// @Router       /clipboard [post]
+func (s *Server) SetClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req ClipboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.Operation != database.ClipboardCut && req.Operation != database.ClipboardCopy {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidClipboardOperation)
+		return
+	}
+	if len(req.NodeIDs) == 0 || len(req.NodeIDs) > maxClipboardItems {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidClipboardSize, maxClipboardItems)
+		return
+	}
+
+	seen := make(map[string]bool, len(req.NodeIDs))
+	nodeIDs := []string{}
+	nodes := []models.Node{}
+	for _, id := range req.NodeIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		node, err := s.store.GetNodeIfAccessible(r.Context(), id, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+			return
+		}
+		if node == nil {
+			s.writeNodeNotFound(w, r, id, i18n.NodeIDNotAccessible, id)
+			return
+		}
+		nodeIDs = append(nodeIDs, id)
+		nodes = append(nodes, *node)
+	}
+
+	clipboard, err := s.store.SetClipboard(r.Context(), claims.UserID, req.Operation, nodeIDs)
+	if err != nil {
+		log.Printf("ERROR: Failed to save clipboard of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ClipboardSaveFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClipboardResponse{
+		Operation: clipboard.Operation,
+		Nodes:     dto.Nodes(nodes),
+		UpdatedAt: clipboard.UpdatedAt,
+	})
+}
+
+// @Summary      Get the clipboard
+// @Description  Returns the operation and the nodes currently on the user's clipboard. Nodes deleted or no longer accessible since they were added are left out.
+// @Tags         clipboard
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  ClipboardResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      404  {string}  string "Clipboard is empty"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /clipboard [get]
+func (s *Server) GetClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	clipboard, err := s.store.GetClipboard(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ClipboardLookupFailed)
+		return
+	}
+	if clipboard == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ClipboardEmpty)
+		return
+	}
+
+	nodes, err := s.clipboardNodes(r, claims.UserID, clipboard)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ClipboardLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClipboardResponse{
+		Operation: clipboard.Operation,
+		Nodes:     dto.Nodes(nodes),
+		UpdatedAt: clipboard.UpdatedAt,
+	})
+}
+
+// @Summary      Clear the clipboard
+// @Tags         clipboard
+// @Security     BearerAuth
+// @Success      204  {null}    nil     "No Content"
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /clipboard [delete]
+func (s *Server) ClearClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	if err := s.store.ClearClipboard(r.Context(), claims.UserID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ClipboardClearFailed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Paste the clipboard
+// @Description  Moves ("cut") or deep-copies ("copy") the nodes on the clipboard into the target folder on the server. Each node is processed separately and failures are reported per node. After a cut, the moved nodes are removed from the clipboard; a copy keeps the clipboard so it can be pasted again.
+// @Tags         clipboard
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        paste  body      PasteRequest   true  "Target folder"
+// @Success      200    {object}  PasteResponse
+// @Failure      400    {string}  string "Bad Request - Invalid ParentID format"
+// @Failure      401    {string}  string "Unauthorized"
+// @Failure      404    {string}  string "Clipboard is empty"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /clipboard/paste [post]
+func (s *Server) PasteClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req PasteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	var parentID *string
+	if req.ParentID != nil && *req.ParentID != "root" {
+		if len(*req.ParentID) != 21 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+			return
+		}
+		parentID = req.ParentID
+	}
+
+	clipboard, err := s.store.GetClipboard(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ClipboardLookupFailed)
+		return
+	}
+	if clipboard == nil || len(clipboard.NodeIDs) == 0 {
+		writeError(w, r, http.StatusNotFound, i18n.ClipboardEmpty)
+		return
+	}
+
+	response := PasteResponse{Operation: clipboard.Operation, Pasted: []dto.Node{}, Failed: []PasteFailure{}}
+	remaining := []string{}
+	for _, id := range clipboard.NodeIDs {
+		node, err := s.store.GetNodeIfAccessible(r.Context(), id, claims.UserID)
+		if err != nil || node == nil {
+			response.Failed = append(response.Failed, PasteFailure{NodeID: id, Error: "Node not found or access denied"})
+			continue
+		}
+
+		if clipboard.Operation == database.ClipboardCut {
+			err = s.moveNode(r.Context(), claims.UserID, node, parentID, true)
+			if err == nil {
+				var moved *models.Node
+				moved, err = s.store.GetNodeByID(r.Context(), node.ID, node.OwnerID)
+				if err == nil && moved != nil {
+					response.Pasted = append(response.Pasted, dto.NodeFrom(*moved))
+				}
+			}
+		} else {
+			var copied []models.Node
+			copied, err = s.copyNodeTree(r.Context(), claims.UserID, node, parentID)
+			if err == nil {
+				response.Pasted = append(response.Pasted, dto.NodeFrom(copied[0]))
+			}
+		}
+
+		if err != nil {
+			log.Printf("WARN: Failed to paste node %s for user %d: %v", id, claims.UserID, err)
+			response.Failed = append(response.Failed, PasteFailure{NodeID: id, Error: opErrorMessage(err)})
+			remaining = append(remaining, id)
+		}
+	}
+
+	if clipboard.Operation == database.ClipboardCut {
+		if len(remaining) == 0 {
+			err = s.store.ClearClipboard(r.Context(), claims.UserID)
+		} else {
+			_, err = s.store.SetClipboard(r.Context(), claims.UserID, clipboard.Operation, remaining)
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to update clipboard of user %d after paste: %v", claims.UserID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/features"
+	"serwer-plikow/internal/i18n"
+	"sort"
+	"strings"
+)
+
+// errInvalidConfig marks a reloaded configuration that was rejected.
+var errInvalidConfig = errors.New("invalid configuration")
+
+type ConfigReloadResponse struct {
+	// Applied lists the values that changed and are in effect.
+	Applied []config.Change `json:"applied"`
+	// RestartRequired lists the keys whose new values only take effect after
+	// a restart. Their values are not shown, as they may hold secrets.
+	RestartRequired []string `json:"restart_required" example:"db.source"`
+}
+
+// validateReloadedConfig checks the sections applied by a reload, so a typo in
+// the settings file cannot take down the running server.
+func validateReloadedConfig(cfg *config.Config) error {
+	var problems []string
+	nonNegative := map[string]int64{
+		"limits.max_folder_depth":             int64(cfg.Limits.MaxFolderDepth),
+		"limits.max_children_per_folder":      cfg.Limits.MaxChildrenPerFolder,
+		"limits.max_archive_entries":          cfg.Limits.MaxArchiveEntries,
+		"limits.max_archive_size_mb":          cfg.Limits.MaxArchiveSizeMB,
+		"limits.max_extract_entries":          int64(cfg.Limits.MaxExtractEntries),
+		"limits.max_extract_ratio":            int64(cfg.Limits.MaxExtractRatio),
+		"storage.upload_session_ttl_hours":    int64(cfg.Storage.UploadSessionTTLHours),
+		"storage.max_request_size_mb":         cfg.Storage.MaxRequestSizeMB,
+		"storage.max_file_size_mb":            cfg.Storage.MaxFileSizeMB,
+		"storage.max_files_per_request":       int64(cfg.Storage.MaxFilesPerRequest),
+		"access_log.retention_days":           int64(cfg.AccessLog.RetentionDays),
+		"share_review.max_age_days":           int64(cfg.ShareReview.MaxAgeDays),
+		"share_review.inactive_days":          int64(cfg.ShareReview.InactiveDays),
+		"share_review.reminder_interval_days": int64(cfg.ShareReview.ReminderIntervalDays),
+		"versions.max_per_file":               int64(cfg.Versions.MaxPerFile),
+		"versions.max_size_mb":                cfg.Versions.MaxSizeMB,
+		"versions.max_age_days":               int64(cfg.Versions.MaxAgeDays),
+		"undo.window_seconds":                 int64(cfg.Undo.WindowSeconds),
+	}
+	for key, value := range nonNegative {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("%s cannot be negative", key))
+		}
+	}
+	for name, flag := range cfg.Features {
+		if _, ok := features.Lookup(name); !ok {
+			problems = append(problems, fmt.Sprintf("features: %q is not a known feature flag", name))
+		}
+		if flag.RolloutPercent < 0 || flag.RolloutPercent > 100 {
+			problems = append(problems, fmt.Sprintf("features.%s.rollout_percent must be between 0 and 100", name))
+		}
+	}
+	if level := cfg.Archive.GzipLevel; level < 0 || level > 9 {
+		problems = append(problems, "archive.gzip_level must be between 0 and 9")
+	}
+	switch strings.ToLower(cfg.CORS.Environment) {
+	case "", CORSEnvironmentDevelopment, CORSEnvironmentProduction:
+	default:
+		problems = append(problems, fmt.Sprintf("cors.environment must be %s or %s", CORSEnvironmentDevelopment, CORSEnvironmentProduction))
+	}
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		if _, ok := parseOriginPattern(origin); !ok {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins: %q is not an explicit origin or subdomain wildcard", origin))
+		}
+	}
+	for _, pattern := range append(append([]string{}, cfg.ContentTypes.Allowed...), cfg.ContentTypes.Blocked...) {
+		if !strings.Contains(pattern, "/") {
+			problems = append(problems, fmt.Sprintf("content_types: %q is not a type such as image/png or image/*", pattern))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%w: %s", errInvalidConfig, strings.Join(problems, "; "))
+}
+
+// ReloadConfig reads the settings file again and applies the sections that
+// are safe to change at runtime: limits, CORS, archive, access log, share
+// review, versions, undo, quota, features, errors, content types and the
+// upload limits of the storage section. An invalid file is rejected as a
+// whole and the running configuration is kept. Administrators receive a
+// config_reloaded event describing what changed.
+func (s *Server) ReloadConfig(ctx context.Context) (*ConfigReloadResponse, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	loaded, err := config.Reload()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	if err := validateReloadedConfig(loaded); err != nil {
+		return nil, err
+	}
+
+	next, applied, restartRequired := config.ApplyReloadable(s.config.Load(), loaded)
+	resp := &ConfigReloadResponse{Applied: applied, RestartRequired: restartRequired}
+	if resp.Applied == nil {
+		resp.Applied = []config.Change{}
+	}
+	if resp.RestartRequired == nil {
+		resp.RestartRequired = []string{}
+	}
+	if len(applied) > 0 {
+		s.config.Store(next)
+		s.originValidator.Store(NewOriginValidator(next.CORS))
+	}
+
+	for _, change := range applied {
+		log.Printf("Config reload: %s changed from %v to %v", change.Key, change.Old, change.New)
+	}
+	for _, key := range restartRequired {
+		log.Printf("WARN: Config reload: %s changed but takes effect only after a restart", key)
+	}
+	if len(applied) == 0 && len(restartRequired) == 0 {
+		log.Println("Config reload: nothing changed")
+		return resp, nil
+	}
+
+	adminIDs, err := s.store.ListAdminIDs(ctx)
+	if err != nil {
+		log.Printf("ERROR: Failed to list administrators to notify of the config reload: %v", err)
+		return resp, nil
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "config_reloaded", "payload": resp})
+	for _, adminID := range adminIDs {
+		if err := s.store.LogEvent(ctx, adminID, "config_reloaded", resp); err != nil {
+			log.Printf("ERROR: Failed to log config reload for user %d: %v", adminID, err)
+		}
+		s.wsHub.PublishEvent(adminID, eventBytes)
+	}
+	return resp, nil
+}
+
+// WatchConfig reloads the configuration whenever the settings file changes,
+// until ctx is done.
+func (s *Server) WatchConfig(ctx context.Context) {
+	err := config.Watch(ctx, func() {
+		if _, err := s.ReloadConfig(ctx); err != nil {
+			log.Printf("ERROR: Config reload failed, keeping the running configuration: %v", err)
+		}
+	})
+	if err != nil {
+		log.Printf("WARN: Settings file is not watched, reload it with POST /admin/config/reload: %v", err)
+	}
+}
+
+// @Summary      Reload the configuration
+// @Description  Reads the settings file again and applies the sections that are safe to change without a restart: limits, cors, archive, access_log, share_review, versions, undo, quota, features, errors, content_types and the upload limits of storage. The file is also watched and reloaded automatically when it changes. An invalid file is rejected as a whole with 422 and the running configuration is kept. Changes of other settings are listed in restart_required. Administrators receive a config_reloaded event with the same content as the response.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  ConfigReloadResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden"
+// @Failure      422  {string}  string "Unprocessable Entity - The settings file is invalid"
+// @Router       /admin/config/reload [post]
+func (s *Server) ReloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	resp, err := s.ReloadConfig(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Config reload requested by user %d failed: %v", claims.UserID, err)
+		writeErrorf(w, r, http.StatusUnprocessableEntity, i18n.ConfigReloadRejected, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
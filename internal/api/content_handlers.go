@@ -0,0 +1,630 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/delta"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaWriter fails once more than remaining bytes have been written, so a
+// replacement cannot grow past the owner's quota while it is being streamed.
+type quotaWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > qw.remaining {
+		return 0, errQuotaExceeded
+	}
+	n, err := qw.w.Write(p)
+	qw.remaining -= int64(n)
+	return n, err
+}
+
+func contentETag(node *models.Node) string {
+	var size int64
+	if node.SizeBytes != nil {
+		size = *node.SizeBytes
+	}
+	return fmt.Sprintf("\"%x-%x\"", node.ModifiedAt.UnixNano(), size)
+}
+
+// loadReplaceableFile returns the file the user may replace the content of,
+// writing the appropriate error response when there is none.
+func (s *Server) loadReplaceableFile(w http.ResponseWriter, r *http.Request, userID int64, nodeID string) *models.Node {
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return nil
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
+		return nil
+	}
+	if node.NodeType != "file" {
+		writeError(w, r, http.StatusBadRequest, i18n.ReplaceFolderContent)
+		return nil
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), userID, &node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return nil
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.ModifyPermissionDenied)
+		return nil
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != contentETag(node) {
+		writeError(w, r, http.StatusPreconditionFailed, i18n.FileModifiedSinceVersion)
+		return nil
+	}
+
+	return node
+}
+
+// replaceableBytes is the largest size the file's new content may have
+// without pushing its owner over their storage quota.
+func (s *Server) replaceableBytes(ctx context.Context, node *models.Node) (int64, error) {
+	owner, err := s.store.GetUserByID(ctx, node.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+	if owner == nil {
+		return 0, fmt.Errorf("owner %d of node %s not found", node.OwnerID, node.ID)
+	}
+
+	var currentSize int64
+	if node.SizeBytes != nil {
+		currentSize = *node.SizeBytes
+	}
+	return owner.StorageQuotaBytes - owner.StorageUsedBytes + currentSize, nil
+}
+
+// commitReplacedContent swaps the blob staged under stagedID in place of the
+// node's current content and updates its metadata and the owner's storage
+// usage in a single transaction, dropping the derived artifacts, such as
+// thumbnails, made of other content than the new one. The previous content is
+// kept as an archived version of the file, copied when other files share it,
+// and the new content is deduplicated in the storage backend the routing rules
+// pick for it. When the transaction fails, even at commit, the previous
+// content is moved back, so the stored blob keeps matching the metadata.
+// contentSHA256 is the checksum of the new content; a non-nil quarantine puts
+// the file in quarantine.
+func (s *Server) commitReplacedContent(ctx context.Context, actorID int64, node *models.Node, stagedID string, newSize int64, mimeType *string, contentSHA256 string, quarantine *contentpolicy.Decision) (*models.Node, error) {
+	var oldSize int64
+	if node.SizeBytes != nil {
+		oldSize = *node.SizeBytes
+	}
+
+	versionKey, err := s.generateUniqueID(ctx)
+	if err != nil {
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		return nil, err
+	}
+
+	var updatedNode *models.Node
+	var staleArtifacts []string
+	var duplicate bool
+	var placedName, placedKey string
+	var placedBackend storage.Backend
+	// The previous content is moved under versionKey inside the transaction;
+	// archived records where it came from, so it can be moved back when the
+	// transaction fails, including at commit.
+	var archived, archivedShared bool
+	var archivedKey string
+	var archivedBackend storage.Backend
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var err error
+		updatedNode, err = q.UpdateNodeContent(ctx, node.ID, node.OwnerID, newSize, mimeType, &contentSHA256)
+		if err != nil {
+			return err
+		}
+		if updatedNode == nil {
+			return database.ErrNodeNotFound
+		}
+
+		if err := q.UpdateUserStorage(ctx, node.OwnerID, newSize-oldSize); err != nil {
+			return err
+		}
+		if quarantine != nil {
+			if err := q.QuarantineNode(ctx, node.ID, contentpolicy.ActionUpload, quarantine.Rule, quarantine.Reason); err != nil {
+				return err
+			}
+		}
+
+		staleArtifacts, err = q.InvalidateDerivedArtifacts(ctx, node.ID, contentSHA256)
+		if err != nil {
+			return err
+		}
+
+		if err := q.LogEvent(ctx, actorID, "node_updated", updatedNode); err != nil {
+			return err
+		}
+		if actorID != node.OwnerID {
+			if err := q.LogEvent(ctx, node.OwnerID, "node_updated", updatedNode); err != nil {
+				return err
+			}
+		}
+
+		if _, err := q.ArchiveNodeVersion(ctx, node, versionKey); err != nil {
+			return err
+		}
+
+		currentName, currentKey, currentBackend, err := s.nodeBackend(ctx, q, node.ID)
+		if err != nil {
+			return err
+		}
+		targetName, targetBackend, err := s.routeContent(newSize, updatedNode.MimeType)
+		if err != nil {
+			return err
+		}
+		if err := q.SetNodeStorageBackend(ctx, node.ID, targetName); err != nil {
+			return err
+		}
+
+		// Content shared with other files stays in place for them.
+		shared := false
+		if currentKey != node.ID {
+			refs, err := q.ReleaseContentBlob(ctx, currentName, currentKey)
+			if err != nil {
+				return err
+			}
+			shared = refs > 0
+		}
+		if shared {
+			err = storage.Copy(currentBackend, currentKey, s.storage, versionKey)
+		} else {
+			err = storage.Transfer(currentBackend, currentKey, s.storage, versionKey)
+		}
+		if err != nil {
+			return err
+		}
+		archived, archivedShared, archivedKey, archivedBackend = true, shared, currentKey, currentBackend
+
+		newKey, dup, err := s.storeContentBlob(ctx, q, s.storage, stagedID, targetName, targetBackend, contentSHA256, newSize)
+		if err == nil && !dup {
+			placedName, placedKey, placedBackend = targetName, newKey, targetBackend
+		}
+		if err == nil {
+			err = q.SetNodeStorageKey(ctx, node.ID, &newKey)
+		}
+		if err != nil {
+			return err
+		}
+		duplicate = dup
+		return nil
+	})
+	if txErr != nil {
+		if archived && archivedShared {
+			s.storage.Delete(versionKey)
+		} else if archived {
+			if restoreErr := storage.Transfer(s.storage, versionKey, archivedBackend, archivedKey); restoreErr != nil {
+				log.Printf("CRITICAL: Failed to restore content of node %s from %s: %v", node.ID, versionKey, restoreErr)
+			}
+		}
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		if placedKey != "" {
+			s.discardPlacedBlob(ctx, placedName, placedBackend, placedKey)
+		}
+		return nil, txErr
+	}
+
+	if duplicate {
+		if err := s.storage.Delete(stagedID); err != nil {
+			log.Printf("WARN: Failed to delete duplicate content %s: %v", stagedID, err)
+		}
+	}
+	s.deleteDerivedArtifactBlobs(staleArtifacts)
+
+	eventMsg := map[string]interface{}{"event_type": "node_updated", "payload": updatedNode}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(actorID, eventBytes)
+	if actorID != node.OwnerID {
+		s.wsHub.PublishEvent(node.OwnerID, eventBytes)
+	}
+	s.notifyWatchers(ctx, []string{node.ID}, eventBytes, actorID, node.OwnerID)
+	if quarantine != nil {
+		s.notifyQuarantine(ctx, updatedNode, contentpolicy.ActionUpload, quarantine)
+	}
+
+	return updatedNode, nil
+}
+
+// @Summary      Replace file content
+// @Description  Replaces the content of a file with the raw request body, keeping its ID, shares, favorites and tags. The previous content is kept as an archived version, the owner's storage usage is adjusted by the size difference and a "node_updated" event is sent. The MIME type is detected from the new content; the Content-Type header, or else the file's current type, only refines a generic result such as plain text. Types excluded by the content_types configuration are rejected with 415. Send the file's ETag in If-Match to make sure nobody changed it in the meantime. The SHA-256 checksum of the content is computed while it is received; when X-Content-SHA256 is sent and does not match, the content is discarded with 422. Requires write permission on the file.
+// @Tags         nodes
+// @Accept       application/octet-stream
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId            path      string  true   "Node ID of the file"
+// @Param        If-Match          header    string  false  "ETag of the version being replaced"
+// @Param        X-Content-SHA256  header    string  false  "Hex SHA-256 checksum of the content"
+// @Param        content           body      string  true   "New file content"
+// @Success      200               {object}  dto.Node
+// @Failure      400               {string}  string "Bad Request - Node is a folder or the checksum is malformed"
+// @Failure      401               {string}  string "Unauthorized"
+// @Failure      403               {string}  string "Forbidden - Write permission denied or the content is blocked by the content policy"
+// @Failure      404               {string}  string "Not Found"
+// @Failure      412               {string}  string "Precondition Failed - File changed since the given version"
+// @Failure      413               {object}  UploadLimitError "Payload Too Large - An upload limit or the owner's storage quota would be exceeded"
+// @Failure      415               {string}  string "Unsupported Media Type - The file type is not allowed"
+// @Failure      422               {string}  string "Unprocessable Entity - The content does not match X-Content-SHA256"
+// @Failure      500               {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/content [put]
+func (s *Server) ReplaceContentHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	expectedSHA256, err := parseContentSHA256(r.Header.Get(contentSHA256Header))
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	node := s.loadReplaceableFile(w, r, claims.UserID, nodeID)
+	if node == nil {
+		return
+	}
+
+	maxSize, err := s.replaceableBytes(r.Context(), node)
+	if err != nil {
+		log.Printf("ERROR: Failed to check quota for node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
+		return
+	}
+	if r.ContentLength > maxSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, i18n.FileOwnerQuotaExceeded)
+		return
+	}
+	// The body is the file, so it is bound by both the request and the file
+	// size limits; whichever is smaller is reported when it is exceeded.
+	fileLimited := s.maxFileBytes() > 0 && s.maxFileBytes() < s.maxRequestBytes()
+	if !s.checkFileSize(w, node.Name, r.ContentLength) || !s.limitRequestBody(w, r) {
+		return
+	}
+	if fileLimited {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxFileBytes())
+	}
+
+	stagedID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ContentStageFailed)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	copyDone := make(chan error, 1)
+	var newSize int64
+	hasher := sha256.New()
+	go func() {
+		n, err := io.Copy(&quotaWriter{w: io.MultiWriter(pw, hasher), remaining: maxSize}, r.Body)
+		newSize = n
+		pw.CloseWithError(err)
+		copyDone <- err
+	}()
+
+	saveErr := s.storage.Save(stagedID, pr)
+	pr.CloseWithError(saveErr)
+	copyErr := <-copyDone
+
+	if copyErr != nil || saveErr != nil {
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(copyErr, errQuotaExceeded):
+			writeError(w, r, http.StatusRequestEntityTooLarge, i18n.FileOwnerQuotaExceeded)
+		case errors.As(copyErr, &maxBytesErr) && fileLimited:
+			s.checkFileSize(w, node.Name, maxBytesErr.Limit+1)
+		case errors.As(copyErr, &maxBytesErr):
+			s.writeRequestTooLarge(w, copyErr)
+		default:
+			log.Printf("ERROR: Failed to store new content of node %s: copy=%v save=%v", node.ID, copyErr, saveErr)
+			writeError(w, r, http.StatusInternalServerError, i18n.ContentStoreFailed)
+		}
+		return
+	}
+
+	contentSHA256 := hexSum(hasher)
+	declared := r.Header.Get("Content-Type")
+	if declared == "" && node.MimeType != nil {
+		declared = *node.MimeType
+	}
+	mimeType, err := "", verifyContentSHA256(node.Name, expectedSHA256, contentSHA256)
+	if err == nil {
+		mimeType, err = s.sniffStaged(stagedID, node.Name, declared)
+	}
+	if err == nil {
+		err = s.checkContentType(node.Name, mimeType)
+	}
+	var quarantine *contentpolicy.Decision
+	if err == nil {
+		quarantine, err = s.evaluateStaged(r.Context(), stagedID, node.Name, mimeType, newSize)
+	}
+	if err != nil {
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		var typeErr *contentTypeError
+		var mismatchErr *checksumMismatchError
+		var policyErr *contentPolicyError
+		switch {
+		case errors.As(err, &typeErr):
+			writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+			return
+		case errors.As(err, &mismatchErr):
+			writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+			return
+		case errors.As(err, &policyErr):
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("ERROR: Failed to check new content of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ContentStoreFailed)
+		return
+	}
+	updatedNode, err := s.commitReplacedContent(r.Context(), claims.UserID, node, stagedID, newSize, &mimeType, contentSHA256, quarantine)
+	if err != nil {
+		if errors.Is(err, database.ErrNodeNotFound) {
+			s.writeNodeNotFound(w, r, node.ID, i18n.FileNotAccessible)
+			return
+		}
+		log.Printf("ERROR: Failed to replace content of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ContentUpdateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", contentETag(updatedNode))
+	json.NewEncoder(w).Encode(dto.NodePtr(updatedNode))
+}
+
+// @Summary      Get file block signature
+// @Description  Returns rsync-style block checksums (a weak rolling checksum and a truncated SHA-256 per block) of the current file content. Clients use it to compute a delta patch for PUT /nodes/{nodeId}/content/delta. The ETag header identifies the version the signature was computed for.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId      path      string  true   "Node ID of the file"
+// @Param        block_size  query     int     false  "Block size in bytes (2048 - 1048576). Defaults to roughly the square root of the file size."
+// @Success      200         {object}  delta.Signature
+// @Failure      400         {string}  string "Bad Request - Invalid block size or node is a folder"
+// @Failure      401         {string}  string "Unauthorized"
+// @Failure      403         {string}  string "Forbidden - The file is shared with you at a fixed version"
+// @Failure      404         {string}  string "Not Found"
+// @Failure      500         {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/signature [get]
+func (s *Server) GetFileSignatureHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
+		return
+	}
+	if node.NodeType != "file" {
+		writeError(w, r, http.StatusBadRequest, i18n.SignatureOnFolder)
+		return
+	}
+
+	pinned, err := s.pinnedVersionFor(r.Context(), node, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if pinned != nil {
+		writeError(w, r, http.StatusForbidden, i18n.FixedVersionShare)
+		return
+	}
+
+	var fileSize int64
+	if node.SizeBytes != nil {
+		fileSize = *node.SizeBytes
+	}
+	blockSize := delta.DefaultBlockSize(fileSize)
+	if raw := r.URL.Query().Get("block_size"); raw != "" {
+		blockSize, err = strconv.Atoi(raw)
+		if err != nil || blockSize < delta.MinBlockSize || blockSize > delta.MaxBlockSize {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidBlockSize, delta.MinBlockSize, delta.MaxBlockSize)
+			return
+		}
+	}
+
+	fileStream, err := s.openNodeContent(r.Context(), node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer fileStream.Close()
+
+	signature, err := delta.ComputeSignature(fileStream, blockSize)
+	if err != nil {
+		log.Printf("ERROR: Failed to compute signature for node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SignatureFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", contentETag(node))
+	json.NewEncoder(w).Encode(signature)
+}
+
+// @Summary      Update file content with a delta patch
+// @Description  Replaces the content of a file by applying a binary delta patch computed against a signature from GET /nodes/{nodeId}/signature. The block_size must match the signature. Send the signature's ETag in If-Match to make sure the patch is applied to the same version it was computed for. Send the checksum of the whole new file in X-Content-SHA256 to have the result verified; a mismatch discards it with 422. Requires write permission on the file.
+// @Tags         nodes
+// @Accept       application/octet-stream
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId            path      string  true   "Node ID of the file"
+// @Param        block_size        query     int     true   "Block size used to compute the signature"
+// @Param        If-Match          header    string  false  "ETag returned together with the signature"
+// @Param        X-Content-SHA256  header    string  false  "Hex SHA-256 checksum of the patched file"
+// @Param        patch             body      string  true   "Binary delta patch"
+// @Success      200               {object}  dto.Node
+// @Failure      400               {string}  string "Bad Request - Invalid block size, malformed patch or checksum"
+// @Failure      401               {string}  string "Unauthorized"
+// @Failure      403               {string}  string "Forbidden - Write permission denied or the content is blocked by the content policy"
+// @Failure      404               {string}  string "Not Found"
+// @Failure      412               {string}  string "Precondition Failed - File changed since the signature was computed"
+// @Failure      413               {object}  UploadLimitError "Payload Too Large - The request size limit or the owner's storage quota would be exceeded"
+// @Failure      422               {string}  string "Unprocessable Entity - The patched file does not match X-Content-SHA256"
+// @Failure      500               {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/content/delta [put]
+func (s *Server) ApplyContentDeltaHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	blockSize, err := strconv.Atoi(r.URL.Query().Get("block_size"))
+	if err != nil || blockSize < delta.MinBlockSize || blockSize > delta.MaxBlockSize {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidBlockSize, delta.MinBlockSize, delta.MaxBlockSize)
+		return
+	}
+	expectedSHA256, err := parseContentSHA256(r.Header.Get(contentSHA256Header))
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	node := s.loadReplaceableFile(w, r, claims.UserID, nodeID)
+	if node == nil {
+		return
+	}
+
+	maxSize, err := s.replaceableBytes(r.Context(), node)
+	if err != nil {
+		log.Printf("ERROR: Failed to check quota for node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
+		return
+	}
+
+	baseStream, err := s.openNodeContent(r.Context(), node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer baseStream.Close()
+
+	base, ok := baseStream.(io.ReaderAt)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, i18n.DeltaUnsupported)
+		return
+	}
+	var baseSize int64
+	if node.SizeBytes != nil {
+		baseSize = *node.SizeBytes
+	}
+
+	stagedID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ContentStageFailed)
+		return
+	}
+
+	if !s.limitRequestBody(w, r) {
+		return
+	}
+
+	pr, pw := io.Pipe()
+	applyDone := make(chan error, 1)
+	var newSize int64
+	hasher := sha256.New()
+	go func() {
+		n, err := delta.Apply(base, baseSize, blockSize, r.Body, &quotaWriter{w: io.MultiWriter(pw, hasher), remaining: maxSize})
+		newSize = n
+		pw.CloseWithError(err)
+		applyDone <- err
+	}()
+
+	saveErr := s.storage.Save(stagedID, pr)
+	pr.CloseWithError(saveErr)
+	applyErr := <-applyDone
+
+	if applyErr != nil || saveErr != nil {
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		switch {
+		case errors.Is(applyErr, delta.ErrInvalidPatch):
+			writeErrorf(w, r, http.StatusBadRequest, i18n.DeltaPatchRejected, applyErr)
+		case errors.Is(applyErr, errQuotaExceeded):
+			writeError(w, r, http.StatusRequestEntityTooLarge, i18n.FileOwnerQuotaExceeded)
+		case s.writeRequestTooLarge(w, applyErr):
+		default:
+			log.Printf("ERROR: Failed to apply delta to node %s: apply=%v save=%v", node.ID, applyErr, saveErr)
+			writeError(w, r, http.StatusInternalServerError, i18n.DeltaApplyFailed)
+		}
+		return
+	}
+
+	contentSHA256 := hexSum(hasher)
+	err = verifyContentSHA256(node.Name, expectedSHA256, contentSHA256)
+	var quarantine *contentpolicy.Decision
+	if err == nil {
+		mimeType := ""
+		if node.MimeType != nil {
+			mimeType = *node.MimeType
+		}
+		quarantine, err = s.evaluateStaged(r.Context(), stagedID, node.Name, mimeType, newSize)
+	}
+	if err != nil {
+		if cleanupErr := s.storage.Delete(stagedID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up staged content %s: %v", stagedID, cleanupErr)
+		}
+		var mismatchErr *checksumMismatchError
+		var policyErr *contentPolicyError
+		switch {
+		case errors.As(err, &mismatchErr):
+			writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+		case errors.As(err, &policyErr):
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+		default:
+			log.Printf("ERROR: Failed to check new content of node %s: %v", node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.DeltaApplyFailed)
+		}
+		return
+	}
+
+	updatedNode, err := s.commitReplacedContent(r.Context(), claims.UserID, node, stagedID, newSize, nil, contentSHA256, quarantine)
+	if err != nil {
+		if errors.Is(err, database.ErrNodeNotFound) {
+			s.writeNodeNotFound(w, r, node.ID, i18n.FileNotAccessible)
+			return
+		}
+		log.Printf("ERROR: Failed to replace content of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ContentUpdateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", contentETag(updatedNode))
+	json.NewEncoder(w).Encode(dto.NodePtr(updatedNode))
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// contentPolicyError reports a file the content policy does not let through.
+type contentPolicyError struct {
+	name     string
+	decision contentpolicy.Decision
+}
+
+func (e *contentPolicyError) Error() string {
+	code, args := e.errorCode()
+	return i18n.Messagef(code, i18n.English, args...)
+}
+
+func (e *contentPolicyError) errorCode() (string, []any) {
+	if e.decision.Verdict == contentpolicy.Quarantine {
+		return i18n.ContentQuarantined, []any{e.name, e.decision.Reason}
+	}
+	return i18n.ContentBlocked, []any{e.name, e.decision.Reason}
+}
+
+// SetContentPolicy installs the policy consulted when files are uploaded and
+// shared. Without one every file is allowed.
+func (s *Server) SetContentPolicy(policy contentpolicy.Policy) {
+	s.contentPolicy = policy
+}
+
+// evaluateContent consults the content policy about a file, opening its
+// content only when there is a policy.
+func (s *Server) evaluateContent(ctx context.Context, action string, file contentpolicy.File, open func() (io.ReadCloser, error)) (contentpolicy.Decision, error) {
+	if s.contentPolicy == nil {
+		return contentpolicy.Decision{Verdict: contentpolicy.Allow}, nil
+	}
+	content, err := open()
+	if err != nil {
+		return contentpolicy.Decision{}, err
+	}
+	defer content.Close()
+	return s.contentPolicy.Evaluate(ctx, action, file, content)
+}
+
+// evaluateUpload consults the content policy about uploaded content. It
+// returns a contentPolicyError for denied files and the decision for files to
+// be quarantined, which is nil for allowed files.
+func (s *Server) evaluateUpload(ctx context.Context, file contentpolicy.File, open func() (io.ReadCloser, error)) (*contentpolicy.Decision, error) {
+	decision, err := s.evaluateContent(ctx, contentpolicy.ActionUpload, file, open)
+	if err != nil {
+		return nil, err
+	}
+	switch decision.Verdict {
+	case contentpolicy.Deny:
+		return nil, &contentPolicyError{name: file.Name, decision: decision}
+	case contentpolicy.Quarantine:
+		return &decision, nil
+	}
+	return nil, nil
+}
+
+// evaluateStaged consults the content policy about content staged in local
+// storage, as evaluateUpload does.
+func (s *Server) evaluateStaged(ctx context.Context, stagedID, name, mimeType string, sizeBytes int64) (*contentpolicy.Decision, error) {
+	file := contentpolicy.File{Name: name, MimeType: mimeType, SizeBytes: sizeBytes}
+	return s.evaluateUpload(ctx, file, func() (io.ReadCloser, error) { return s.storage.Get(stagedID) })
+}
+
+// notifyQuarantine journals and pushes a node_quarantined event to the owner
+// of a file the content policy quarantined.
+func (s *Server) notifyQuarantine(ctx context.Context, node *models.Node, action string, decision *contentpolicy.Decision) {
+	payload := map[string]interface{}{
+		"node_id": node.ID,
+		"name":    node.Name,
+		"action":  action,
+		"rule":    decision.Rule,
+		"reason":  decision.Reason,
+	}
+	if err := s.store.LogEvent(ctx, node.OwnerID, "node_quarantined", payload); err != nil {
+		log.Printf("ERROR: Failed to journal quarantine of node %s: %v", node.ID, err)
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "node_quarantined", "payload": payload})
+	s.wsHub.PublishEvent(node.OwnerID, eventBytes)
+}
+
+func (s *Server) isQuarantined(ctx context.Context, nodeID string) (bool, error) {
+	quarantined, err := s.store.QuarantinedNodeIDs(ctx, []string{nodeID})
+	return len(quarantined) > 0, err
+}
+
+// checkShareContent applies the content policy to the files a share would
+// expose: the file itself or every file below a folder. Sharing is refused
+// when one of them is quarantined or the policy denies or quarantines it.
+func (s *Server) checkShareContent(ctx context.Context, node *models.Node) error {
+	files := []models.Node{*node}
+	if node.NodeType == "folder" {
+		subtree, err := s.store.ListSubtreeNodes(ctx, node.ID)
+		if err != nil {
+			return err
+		}
+		files = subtree
+	}
+	byID := make(map[string]*models.Node, len(files))
+	fileIDs := make([]string, 0, len(files))
+	for i := range files {
+		if files[i].NodeType == "file" {
+			byID[files[i].ID] = &files[i]
+			fileIDs = append(fileIDs, files[i].ID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	quarantined, err := s.store.QuarantinedNodeIDs(ctx, fileIDs)
+	if err != nil {
+		return err
+	}
+	if len(quarantined) > 0 {
+		return &contentPolicyError{name: byID[quarantined[0]].Name, decision: contentpolicy.Decision{
+			Verdict: contentpolicy.Quarantine,
+			Reason:  "the file must be released by an administrator before it can be shared",
+		}}
+	}
+	if s.contentPolicy == nil {
+		return nil
+	}
+
+	for _, fileID := range fileIDs {
+		file := byID[fileID]
+		described := contentpolicy.File{Name: file.Name}
+		if file.MimeType != nil {
+			described.MimeType = *file.MimeType
+		}
+		if file.SizeBytes != nil {
+			described.SizeBytes = *file.SizeBytes
+		}
+		decision, err := s.evaluateContent(ctx, contentpolicy.ActionShare, described, func() (io.ReadCloser, error) {
+			return s.openNodeContent(ctx, file.ID)
+		})
+		if err != nil {
+			return err
+		}
+		switch decision.Verdict {
+		case contentpolicy.Deny:
+			return &contentPolicyError{name: file.Name, decision: decision}
+		case contentpolicy.Quarantine:
+			if err := s.store.QuarantineNode(ctx, file.ID, contentpolicy.ActionShare, decision.Rule, decision.Reason); err != nil {
+				return err
+			}
+			s.notifyQuarantine(ctx, file, contentpolicy.ActionShare, &decision)
+			return &contentPolicyError{name: file.Name, decision: decision}
+		}
+	}
+	return nil
+}
+
+// @Summary      List quarantined files
+// @Description  Lists the files the content policy put in quarantine, most recent first. Quarantined files cannot be downloaded, previewed, copied or shared until they are released.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.QuarantinedNode
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/quarantine [get]
+func (s *Server) ListQuarantinedNodesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	nodes, err := s.store.ListQuarantinedNodes(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list quarantined nodes: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.QuarantineListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.QuarantinedNodes(nodes))
+}
+
+// @Summary      Release a quarantined file
+// @Description  Lifts the quarantine of a file after review, making it available for download and sharing again. The owner receives a "node_released" event.
+// @Tags         admin
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID of the quarantined file"
+// @Success      204     {null}    nil "No Content"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404     {string}  string "Not Found - The file is not quarantined"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/quarantine/{nodeId}/release [post]
+func (s *Server) ReleaseQuarantinedNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "nodeId")
+
+	var released *database.QuarantinedNode
+	var payload map[string]interface{}
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		released, err = q.ReleaseQuarantinedNode(r.Context(), nodeID)
+		if err != nil || released == nil {
+			return err
+		}
+		payload = map[string]interface{}{"node_id": released.NodeID, "name": released.Name}
+		return q.LogEvent(r.Context(), released.OwnerID, "node_released", payload)
+	})
+	if txErr != nil {
+		log.Printf("ERROR: Failed to release quarantined node %s: %v", nodeID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.QuarantineReleaseFailed)
+		return
+	}
+	if released == nil {
+		writeError(w, r, http.StatusNotFound, i18n.FileNotQuarantined)
+		return
+	}
+
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "node_released", "payload": payload})
+	s.wsHub.PublishEvent(released.OwnerID, eventBytes)
+	w.WriteHeader(http.StatusNoContent)
+}
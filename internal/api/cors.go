@@ -0,0 +1,111 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"serwer-plikow/internal/config"
+	"strings"
+
+	"github.com/go-chi/cors"
+)
+
+const (
+	CORSEnvironmentDevelopment = "development"
+	CORSEnvironmentProduction  = "production"
+)
+
+type originPattern struct {
+	scheme string
+	host   string
+	// wildcard marks patterns like https://*.example.com, which match any
+	// subdomain of host but not host itself.
+	wildcard bool
+}
+
+// NewOriginValidator builds the origin check used by CORSMiddleware. Only the
+// configured origins are allowed; in the development preset any localhost
+// origin is accepted as well. Catch-all entries ("*", "https://*") are
+// rejected because credentials are allowed for cross-origin requests.
+func NewOriginValidator(cfg config.CORSConfig) func(r *http.Request, origin string) bool {
+	patterns := []originPattern{}
+	for _, entry := range cfg.AllowedOrigins {
+		pattern, ok := parseOriginPattern(entry)
+		if !ok {
+			log.Printf("WARN: Ignoring CORS origin %q, only explicit origins or subdomain wildcards (https://*.example.com) are allowed", entry)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	allowLocalhost := strings.EqualFold(cfg.Environment, CORSEnvironmentDevelopment)
+
+	return func(r *http.Request, origin string) bool {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") {
+			return false
+		}
+		host := strings.ToLower(u.Host)
+
+		if allowLocalhost {
+			hostname := u.Hostname()
+			if hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1" {
+				return true
+			}
+		}
+
+		for _, pattern := range patterns {
+			if pattern.scheme != u.Scheme {
+				continue
+			}
+			if pattern.wildcard {
+				if strings.HasSuffix(host, "."+pattern.host) {
+					return true
+				}
+			} else if host == pattern.host {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func parseOriginPattern(entry string) (originPattern, bool) {
+	scheme, host, ok := strings.Cut(strings.ToLower(strings.TrimSpace(entry)), "://")
+	if !ok || (scheme != "http" && scheme != "https") {
+		return originPattern{}, false
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	wildcard := false
+	if rest, found := strings.CutPrefix(host, "*."); found {
+		wildcard = true
+		host = rest
+	}
+	if host == "" || strings.ContainsAny(host, "*/") || (wildcard && !strings.Contains(host, ".")) {
+		return originPattern{}, false
+	}
+	return originPattern{scheme: scheme, host: host, wildcard: wildcard}, true
+}
+
+func CORSMiddleware(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	return corsHandler(NewOriginValidator(cfg))
+}
+
+// CORS is CORSMiddleware for the server's configuration, following changes of
+// the allowed origins when the configuration is reloaded.
+func (s *Server) CORS() func(http.Handler) http.Handler {
+	return corsHandler(func(r *http.Request, origin string) bool {
+		return s.originValidator.Load().(func(*http.Request, string) bool)(r, origin)
+	})
+}
+
+func corsHandler(allowOrigin func(r *http.Request, origin string) bool) func(http.Handler) http.Handler {
+	return cors.Handler(cors.Options{
+		AllowOriginFunc:  allowOrigin,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Upload-Offset", "X-Chunk-SHA256", "X-Content-SHA256", "Range", "If-Range"},
+		ExposedHeaders:   []string{"Link", "X-Undo-Token", "X-Undo-Expires-At", "X-Error-Code", "Content-Language", "Upload-Offset", "Upload-Length", "Content-Range", "Accept-Ranges", "ETag", "X-Thumbnails-Missing"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	})
+}
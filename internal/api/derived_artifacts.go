@@ -0,0 +1,13 @@
+package api
+
+import "log"
+
+// deleteDerivedArtifactBlobs removes thumbnails, previews and other derived
+// blobs whose registry entries were already deleted in a committed transaction.
+func (s *Server) deleteDerivedArtifactBlobs(storageKeys []string) {
+	for _, key := range storageKeys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("WARN: Failed to delete derived artifact %s from storage: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxFolderDiffEvents = 5000
+
+type RenamedNode struct {
+	ID      string `json:"id"`
+	OldName string `json:"old_name"`
+	NewName string `json:"new_name"`
+}
+
+type FolderDiffResponse struct {
+	Cursor  int64         `json:"cursor" example:"1234"`
+	Added   []dto.Node    `json:"added"`
+	Removed []string      `json:"removed"`
+	Renamed []RenamedNode `json:"renamed"`
+}
+
+type nodeChangePayload struct {
+	ID          string  `json:"id"`
+	ParentID    *string `json:"parent_id"`
+	OldParentID *string `json:"old_parent_id"`
+	OldName     string  `json:"old_name"`
+}
+
+// nodeChange tracks what a folder's child looked like at the cursor: whether
+// it was in the folder (once an event reveals it) and its name back then.
+type nodeChange struct {
+	known   bool
+	wasIn   bool
+	oldName *string
+}
+
+// computeFolderDiff replays node events in order and, combined with the
+// current children among the touched nodes, derives what changed in the
+// folder since the first event.
+func computeFolderDiff(folderID string, events []database.Event, currentChildren []models.Node) FolderDiffResponse {
+	inFolder := func(parentID *string) bool {
+		return parentID != nil && *parentID == folderID
+	}
+
+	changes := make(map[string]*nodeChange)
+	order := []string{}
+	for _, event := range events {
+		var payload nodeChangePayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.ID == "" {
+			continue
+		}
+		change, ok := changes[payload.ID]
+		if !ok {
+			change = &nodeChange{}
+			changes[payload.ID] = change
+			order = append(order, payload.ID)
+		}
+
+		switch event.EventType {
+		case "node_renamed":
+			if change.oldName == nil {
+				oldName := payload.OldName
+				change.oldName = &oldName
+			}
+			continue
+		case "node_created", "node_restored":
+			if !change.known {
+				change.wasIn = false
+			}
+		case "node_moved":
+			if !change.known {
+				change.wasIn = inFolder(payload.OldParentID)
+			}
+		case "node_trashed":
+			if !change.known {
+				change.wasIn = inFolder(payload.ParentID)
+			}
+		}
+		change.known = true
+	}
+
+	current := make(map[string]models.Node, len(currentChildren))
+	for _, node := range currentChildren {
+		current[node.ID] = node
+	}
+
+	diff := FolderDiffResponse{Added: []dto.Node{}, Removed: []string{}, Renamed: []RenamedNode{}}
+	for _, nodeID := range order {
+		change := changes[nodeID]
+		node, isIn := current[nodeID]
+		wasIn := change.wasIn
+		if !change.known {
+			wasIn = isIn
+		}
+
+		switch {
+		case !wasIn && isIn:
+			diff.Added = append(diff.Added, dto.NodeFrom(node))
+		case wasIn && !isIn:
+			diff.Removed = append(diff.Removed, nodeID)
+		case wasIn && isIn && change.oldName != nil && *change.oldName != node.Name:
+			diff.Renamed = append(diff.Renamed, RenamedNode{ID: nodeID, OldName: *change.oldName, NewName: node.Name})
+		}
+	}
+	return diff
+}
+
+// @Summary      Get folder changes since an event
+// @Description  Returns only the children added to, removed from or renamed within a folder since the given event cursor, so folder views can be updated incrementally. Use the returned cursor for the next call. If too many changes happened since the cursor, 410 is returned and the folder should be listed again from scratch.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        folderId     path      string  true  "Folder ID"
+// @Param        since_event  query     int     true  "ID of the last event the client has seen"
+// @Success      200          {object}  FolderDiffResponse
+// @Failure      400          {string}  string "Bad Request"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      404          {string}  string "Not Found"
+// @Failure      410          {string}  string "Gone - Too many changes since the cursor, refetch the listing"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /nodes/{folderId}/diff [get]
+func (s *Server) GetFolderDiffHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	folderID := chi.URLParam(r, "nodeId")
+
+	sinceID, err := strconv.ParseInt(r.URL.Query().Get("since_event"), 10, 64)
+	if err != nil || sinceID < 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidSinceEvent)
+		return
+	}
+
+	folder, err := s.store.GetNodeIfAccessible(r.Context(), folderID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderLookupFailed)
+		return
+	}
+	if folder == nil || folder.NodeType != "folder" {
+		s.writeNodeNotFound(w, r, folderID, i18n.FolderNotAccessible)
+		return
+	}
+
+	// Every change inside a folder is journaled for its owner, whoever made it.
+	cursor, err := s.store.GetLatestEventID(r.Context(), folder.OwnerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderChangesFailed)
+		return
+	}
+	if cursor < sinceID {
+		cursor = sinceID
+	}
+
+	events, err := s.store.ListNodeChangeEventsSince(r.Context(), folder.OwnerID, sinceID, maxFolderDiffEvents+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list events for folder diff %s: %v", folderID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderChangesFailed)
+		return
+	}
+	if len(events) > maxFolderDiffEvents {
+		writeError(w, r, http.StatusGone, i18n.TooManyChanges)
+		return
+	}
+	for i, event := range events {
+		if event.ID > cursor {
+			events = events[:i]
+			break
+		}
+	}
+
+	touched := make([]string, 0, len(events))
+	for _, event := range events {
+		var payload nodeChangePayload
+		if json.Unmarshal(event.Payload, &payload) == nil && payload.ID != "" {
+			touched = append(touched, payload.ID)
+		}
+	}
+
+	children := []models.Node{}
+	if len(touched) > 0 {
+		children, err = s.store.ListChildrenByIDs(r.Context(), folder.ID, touched)
+		if err != nil {
+			log.Printf("ERROR: Failed to load children for folder diff %s: %v", folderID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.FolderChangesFailed)
+			return
+		}
+	}
+
+	diff := computeFolderDiff(folder.ID, events, children)
+	diff.Cursor = cursor
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// inlineMIMETypes are the types browsers display without running any of the
+// file's content: documents, images and plain text. HTML, SVG, XML and
+// JavaScript are left out on purpose since they could run scripts in the
+// API's origin.
+var inlineMIMETypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/avif":      true,
+	"image/bmp":       true,
+	"text/plain":      true,
+	"text/csv":        true,
+	"text/markdown":   true,
+}
+
+// inlineContentType reports whether content of the given type may be served
+// with an inline disposition.
+func inlineContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+	return inlineMIMETypes[mediaType]
+}
+
+// contentDisposition builds a Content-Disposition header for a file name.
+// Characters that could break out of the header are replaced; a name with
+// non-ASCII characters also gets an RFC 5987 filename* with the exact name
+// and a plain filename with those characters replaced for older clients.
+func contentDisposition(disposition, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '\\' || r == '/' {
+			return '_'
+		}
+		return r
+	}, name)
+	fallback := strings.Map(func(r rune) rune {
+		if r > 0x7e {
+			return '_'
+		}
+		return r
+	}, name)
+	header := disposition + "; filename=\"" + fallback + "\""
+	if fallback != name {
+		header += "; filename*=UTF-8''" + strings.ReplaceAll(url.QueryEscape(name), "+", "%20")
+	}
+	return header
+}
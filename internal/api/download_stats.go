@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultTransferStatsDays = 30
+	maxTransferStatsDays     = 365
+)
+
+// countingResponseWriter counts the body bytes written through it, so a
+// transfer cut short by the client can be told from a complete one.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// transferKind classifies a response by the ranges it serves: the whole file
+// (also as a single range) is a full download, a single range running to the
+// end of the file a resumed one, and anything else a partial read.
+func transferKind(ranges []byteRange, size int64) string {
+	if len(ranges) == 0 {
+		return database.TransferFull
+	}
+	if len(ranges) == 1 && ranges[0].start+ranges[0].length == size {
+		if ranges[0].start == 0 {
+			return database.TransferFull
+		}
+		return database.TransferResumed
+	}
+	return database.TransferPartial
+}
+
+// recordTransfer counts a served download in the metrics and the node's
+// transfer stats. It runs after the response, so it does not depend on the
+// request context, which is cancelled when the client disconnects.
+func (s *Server) recordTransfer(r *http.Request, params database.RecordDownloadTransferParams) {
+	downloadTransfersTotal.WithLabelValues(params.Kind).Inc()
+	downloadBytesTotal.WithLabelValues(params.Kind).Add(float64(params.BytesServed))
+
+	if err := s.store.RecordDownloadTransfer(context.WithoutCancel(r.Context()), params); err != nil {
+		log.Printf("ERROR: Failed to record %s transfer of node %s: %v", params.Kind, params.NodeID, err)
+	}
+}
+
+type NodeTransferStatsResponse struct {
+	NodeID string    `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	Since  time.Time `json:"since"`
+	database.NodeTransferStats
+}
+
+// @Summary      Get node transfer stats
+// @Description  Sums up how a file owned by the user was downloaded over the last days: complete downloads, downloads resumed from an offset, partial reads (e.g. seeking in a video), transfers interrupted by the client, downloads through public links, distinct users and bytes served. Stats are kept as long as the access log.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true   "Node ID"
+// @Param        days    query     int     false  "Number of past days to include (1-365)" default(30)
+// @Success      200     {object}  NodeTransferStatsResponse
+// @Failure      400     {string}  string "Bad Request"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Not Found - Node does not exist or user is not the owner"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/transfer-stats [get]
+func (s *Server) GetNodeTransferStatsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	days := defaultTransferStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTransferStatsDays {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidDays)
+			return
+		}
+		days = parsed
+	}
+
+	node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.NodeNotOwned)
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	stats, err := s.store.ReadReplica().GetNodeTransferStats(r.Context(), node.ID, since)
+	if err != nil {
+		log.Printf("ERROR: Failed to load transfer stats of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.TransferStatsLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NodeTransferStatsResponse{NodeID: node.ID, Since: since, NodeTransferStats: *stats})
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/email"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSMTPPort         = 587
+	defaultVerifyTokenTTL   = 24 * time.Hour
+	defaultResetTokenTTL    = 30 * time.Minute
+	defaultMaxEmailsPerHour = 3
+	emailSendTimeout        = time.Minute
+)
+
+var errEmailRateLimited = errors.New("too many emails sent to the account")
+
+type SetEmailRequest struct {
+	Email string `json:"email" example:"jan.kowalski@example.com"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" example:"q8ZJ3s0bVn2mP6xW1yT4cA9dF7gH5kLr"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" example:"jan.kowalski@example.com"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" example:"q8ZJ3s0bVn2mP6xW1yT4cA9dF7gH5kLr"`
+	NewPassword string `json:"new_password" example:"nowe-haslo-123"`
+}
+
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendEmailToken stores a new token for the account and emails it to the
+// address, with a link to the client's page when one is configured. It
+// returns errEmailRateLimited when the account was sent too many emails of
+// the kind. The email is sent in the background, so the response time does
+// not reveal whether an account exists.
+func (s *Server) sendEmailToken(ctx context.Context, userID int64, purpose, address string) error {
+	cfg := s.config.Load().Mail
+	ttl, linkTemplate := defaultVerifyTokenTTL, cfg.VerifyURL
+	if cfg.VerifyTokenTTLHours > 0 {
+		ttl = time.Duration(cfg.VerifyTokenTTLHours) * time.Hour
+	}
+	if purpose == database.EmailTokenPasswordReset {
+		ttl, linkTemplate = defaultResetTokenTTL, cfg.ResetURL
+		if cfg.ResetTokenTTLMinutes > 0 {
+			ttl = time.Duration(cfg.ResetTokenTTLMinutes) * time.Minute
+		}
+	}
+	maxPerHour := defaultMaxEmailsPerHour
+	if cfg.MaxPerHour > 0 {
+		maxPerHour = cfg.MaxPerHour
+	}
+
+	token, err := ids.Token()
+	if err != nil {
+		return err
+	}
+	var stored bool
+	err = s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var err error
+		stored, err = q.CreateEmailToken(ctx, database.CreateEmailTokenParams{
+			TokenHash: hashEmailToken(token),
+			UserID:    userID,
+			Purpose:   purpose,
+			Email:     address,
+			ExpiresAt: time.Now().Add(ttl),
+		}, maxPerHour)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return errEmailRateLimited
+	}
+
+	action := "verify your email address"
+	msg := email.Message{To: address, Subject: "Verify your email address"}
+	if purpose == database.EmailTokenPasswordReset {
+		action = "reset your password"
+		msg.Subject = "Reset your password"
+	}
+	var body strings.Builder
+	if linkTemplate != "" {
+		fmt.Fprintf(&body, "Open this link to %s:\n\n%s\n\n", action, strings.ReplaceAll(linkTemplate, "{token}", url.QueryEscape(token)))
+	} else {
+		fmt.Fprintf(&body, "Use this code to %s:\n\n%s\n\n", action, token)
+	}
+	validFor := fmt.Sprintf("%d minutes", int(ttl.Minutes()))
+	if ttl%time.Hour == 0 {
+		validFor = fmt.Sprintf("%d hours", int(ttl.Hours()))
+	}
+	fmt.Fprintf(&body, "It is valid for %s and can be used once. If you did not ask for it, ignore this email.\n", validFor)
+	msg.Body = body.String()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("ERROR: Failed to send %s email to user %d: %v", purpose, userID, err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) pruneEmailTokens(ctx context.Context) error {
+	_, err := s.store.DeleteStaleEmailTokens(ctx)
+	return err
+}
+
+// @Summary      Get my email address
+// @Description  Returns the email address of the current user and when it was verified. Password resets are only sent to a verified address.
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  dto.UserEmail
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /me/email [get]
+func (s *Server) GetMyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	address, err := s.store.GetUserEmail(r.Context(), claims.UserID)
+	if err != nil || address == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.EmailLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.UserEmailFrom(*address))
+}
+
+// @Summary      Set my email address
+// @Description  Sets the email address of the current user and emails a verification token to it, valid for 24 hours by default. The token is confirmed through POST /auth/verify-email. A new address stays unverified until then; setting the current address again only resends the token if it is not verified yet. Only a few emails are sent to an account per hour.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      SetEmailRequest  true  "Email address"
+// @Success      200      {object}  dto.UserEmail
+// @Failure      400      {string}  string "Bad Request - Invalid email address"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      429      {string}  string "Too Many Requests - Too many verification emails were sent"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Failure      503      {string}  string "Service Unavailable - Email is not configured"
+// @Router       /me/email [put]
+func (s *Server) SetMyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	if s.mailer == nil {
+		writeError(w, r, http.StatusServiceUnavailable, i18n.EmailNotConfigured)
+		return
+	}
+
+	var req SetEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	normalized, err := email.NormalizeAddress(req.Email)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidEmail)
+		return
+	}
+
+	address, err := s.store.SetUserEmail(r.Context(), claims.UserID, normalized)
+	if err != nil || address == nil {
+		log.Printf("ERROR: Failed to set email address of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.EmailUpdateFailed)
+		return
+	}
+	if address.VerifiedAt == nil {
+		if err := s.sendEmailToken(r.Context(), claims.UserID, database.EmailTokenVerify, normalized); err != nil {
+			if errors.Is(err, errEmailRateLimited) {
+				writeError(w, r, http.StatusTooManyRequests, i18n.TooManyEmails)
+				return
+			}
+			log.Printf("ERROR: Failed to send verification email to user %d: %v", claims.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.VerificationEmailFailed)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.UserEmailFrom(*address))
+}
+
+// @Summary      Verify an email address
+// @Description  Needs no account. Confirms the email address of an account with the token emailed to it. A token can be used once and stops working when the address was changed in the meantime.
+// @Tags         auth
+// @Accept       json
+// @Param        request  body      VerifyEmailRequest  true  "Verification token"
+// @Success      204      {null}    nil     "No Content"
+// @Failure      400      {string}  string "Bad Request - Invalid or expired token"
+// @Failure      409      {string}  string "Conflict - The address was verified for another account"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /auth/verify-email [post]
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+
+	token, err := s.store.ConsumeEmailToken(r.Context(), hashEmailToken(req.Token), database.EmailTokenVerify)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.EmailVerificationFailed)
+		return
+	}
+	if token == nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidToken)
+		return
+	}
+	verified, err := s.store.VerifyUserEmail(r.Context(), token.UserID, token.Email)
+	if errors.Is(err, database.ErrEmailTaken) {
+		writeError(w, r, http.StatusConflict, i18n.EmailTaken)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to verify email address of user %d: %v", token.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.EmailVerificationFailed)
+		return
+	}
+	if !verified {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidToken)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Request a password reset
+// @Description  Needs no account. Emails a password reset token, valid for 30 minutes by default, to the account with this verified address. The response is the same whether or not such an account exists, so it cannot be used to discover addresses. Accounts synchronized from LDAP and disabled accounts get no email, nor does an account already sent a few reset emails within the last hour.
+// @Tags         auth
+// @Accept       json
+// @Param        request  body      PasswordResetRequest  true  "Email address of the account"
+// @Success      202      {null}    nil     "Accepted"
+// @Failure      400      {string}  string "Bad Request - Invalid email address"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Failure      503      {string}  string "Service Unavailable - Email is not configured"
+// @Router       /auth/password-reset/request [post]
+func (s *Server) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.mailer == nil {
+		writeError(w, r, http.StatusServiceUnavailable, i18n.EmailNotConfigured)
+		return
+	}
+
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	address, err := email.NormalizeAddress(req.Email)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidEmail)
+		return
+	}
+
+	user, err := s.store.GetUserByVerifiedEmail(r.Context(), address)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.InternalError)
+		return
+	}
+	if user != nil && user.DisabledAt == nil && user.LDAPDN == nil {
+		err := s.sendEmailToken(r.Context(), user.ID, database.EmailTokenPasswordReset, address)
+		if errors.Is(err, errEmailRateLimited) {
+			log.Printf("WARN: Password reset for user %d rate-limited", user.ID)
+		} else if err != nil {
+			log.Printf("ERROR: Failed to send password reset email to user %d: %v", user.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.PasswordResetRequestFailed)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// @Summary      Reset a password
+// @Description  Needs no account. Sets a new password, at least 8 characters long, with a token from a password reset email. The token can be used once and only while the address it was sent to is still the verified address of the account; the account's other reset tokens stop working, and all of its sessions are terminated.
+// @Tags         auth
+// @Accept       json
+// @Param        request  body      PasswordResetConfirmRequest  true  "Reset token and new password"
+// @Success      204      {null}    nil     "No Content"
+// @Failure      400      {string}  string "Bad Request - Invalid or expired token, or the password is too short"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /auth/password-reset/confirm [post]
+func (s *Server) ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if len(req.NewPassword) < 8 {
+		writeError(w, r, http.StatusBadRequest, i18n.NewPasswordTooShort)
+		return
+	}
+
+	// The token is checked before the password is hashed, so requests with
+	// invalid tokens cost no bcrypt work. A token only resets the password
+	// while the address it was sent to is still the verified address of the
+	// account.
+	errInvalidToken := errors.New("invalid email token")
+	var userID int64
+	err := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		token, err := q.ConsumeEmailToken(r.Context(), hashEmailToken(req.Token), database.EmailTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			return errInvalidToken
+		}
+		user, err := q.GetUserByID(r.Context(), token.UserID)
+		if err != nil {
+			return err
+		}
+		if user == nil || user.DisabledAt != nil || user.LDAPDN != nil {
+			return errInvalidToken
+		}
+		current, err := q.GetUserEmail(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.Email == nil || *current.Email != token.Email || current.VerifiedAt == nil {
+			return errInvalidToken
+		}
+		passwordHash, err := auth.HashPassword(req.NewPassword)
+		if err != nil {
+			return err
+		}
+		userID = user.ID
+		if err := q.UpdateUserPassword(r.Context(), user.ID, passwordHash); err != nil {
+			return err
+		}
+		if err := q.InvalidateEmailTokens(r.Context(), user.ID, database.EmailTokenPasswordReset); err != nil {
+			return err
+		}
+		return q.DeleteAllSessionsForUser(r.Context(), user.ID)
+	})
+	if errors.Is(err, errInvalidToken) {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidToken)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to reset password: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.PasswordResetFailed)
+		return
+	}
+	log.Printf("Password of user %d was reset by email", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
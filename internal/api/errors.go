@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+)
+
+// localizedError is an error reported to clients by an i18n code. Its Error
+// method gives the English message, for logs.
+type localizedError interface {
+	error
+	errorCode() (string, []any)
+}
+
+// requestLanguage is the language negotiated for responses to r.
+func requestLanguage(r *http.Request) string {
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// writeError answers with the message for an i18n error code in the client's
+// language. The code itself is sent in the X-Error-Code header.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	lang := requestLanguage(r)
+	w.Header().Set("X-Error-Code", code)
+	w.Header().Set("Content-Language", lang)
+	http.Error(w, i18n.Message(code, lang), status)
+}
+
+// writeErrorf is writeError for messages with parameters, such as a limit or
+// the name of a file.
+func writeErrorf(w http.ResponseWriter, r *http.Request, status int, code string, args ...any) {
+	lang := requestLanguage(r)
+	w.Header().Set("X-Error-Code", code)
+	w.Header().Set("Content-Language", lang)
+	http.Error(w, i18n.Messagef(code, lang, args...), status)
+}
+
+// writeLocalizedError answers with the code carried by err. An error without
+// one is a bug and is answered as an internal error.
+func writeLocalizedError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var localized localizedError
+	if !errors.As(err, &localized) {
+		log.Printf("ERROR: Error without an i18n code reported to a client: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.InternalError)
+		return
+	}
+	code, args := localized.errorCode()
+	writeErrorf(w, r, status, code, args...)
+}
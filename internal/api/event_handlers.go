@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"serwer-plikow/internal/i18n"
 	"strconv"
 	"time"
 )
@@ -14,19 +15,31 @@ type EventResponse struct {
 	Payload   json.RawMessage `json:"payload" swaggertype:"object"`
 }
 
+type EventsPageResponse struct {
+	Events []EventResponse `json:"events"`
+	// NextSince is the cursor for the next request: the ID of the last
+	// returned event, or the requested one when nothing was returned.
+	NextSince int64 `json:"next_since" example:"223"`
+	// HasMore is true when further events are waiting after NextSince.
+	HasMore bool `json:"has_more" example:"false"`
+	Limit   int  `json:"limit" example:"100"`
+}
+
 // @Summary      Get new events
-// @Description  Retrieves a list of events that have occurred since a given event ID. Used for client-side cache synchronization.
+// @Description  Retrieves a page of events that have occurred since a given event ID, oldest first. Used for client-side cache synchronization, e.g. after a WebSocket reconnect: while has_more is true, request the next page with since=next_since.
 // @Tags         events
 // @Produce      json
 // @Security     BearerAuth
 // @Param        since  query     int  false  "The ID of the last event received. Omit or use 0 to get all events."
-// @Success      200    {array}   EventResponse
+// @Param        limit  query     int  false  "Maximum number of events to return (capped at 1000)" default(100)
+// @Success      200    {object}  EventsPageResponse
 // @Failure      400    {string}  string "Bad Request"
 // @Failure      401    {string}  string "Unauthorized"
 // @Failure      500    {string}  string "Internal Server Error"
 // @Router       /events [get]
 func (s *Server) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
+	limit, _ := parsePagination(r)
 
 	sinceStr := r.URL.Query().Get("since")
 	if sinceStr == "" {
@@ -35,16 +48,26 @@ func (s *Server) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
 
 	sinceID, err := strconv.ParseInt(sinceStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid 'since' parameter, must be a number", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidSince)
 		return
 	}
 
-	events, err := s.store.GetEventsSince(r.Context(), claims.UserID, sinceID)
+	events, err := s.store.ReadReplica().GetEventsSince(r.Context(), claims.UserID, sinceID, limit+1)
 	if err != nil {
-		http.Error(w, "Failed to retrieve events", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.EventsLookupFailed)
 		return
 	}
 
+	page := EventsPageResponse{Events: []EventResponse{}, NextSince: sinceID, Limit: limit}
+	if len(events) > limit {
+		events = events[:limit]
+		page.HasMore = true
+	}
+	for _, event := range events {
+		page.Events = append(page.Events, EventResponse(event))
+		page.NextSince = event.ID
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(events)
+	json.NewEncoder(w).Encode(page)
 }
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -36,11 +40,11 @@ func (s *Server) AddFavoriteHandler(w http.ResponseWriter, r *http.Request) {
 	if txErr != nil {
 		switch {
 		case errors.Is(txErr, database.ErrNodeNotFound):
-			http.Error(w, "Node not found or you do not have permission to access it", http.StatusNotFound)
+			s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotAccessible)
 		case errors.Is(txErr, database.ErrFavoriteAlreadyExists):
-			http.Error(w, txErr.Error(), http.StatusConflict)
+			writeError(w, r, http.StatusConflict, i18n.AlreadyFavorite)
 		default:
-			http.Error(w, "Failed to add to favorites", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.FavoriteAddFailed)
 		}
 		return
 	}
@@ -77,7 +81,7 @@ func (s *Server) RemoveFavoriteHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if txErr != nil {
-		http.Error(w, "Failed to remove from favorites", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.FavoriteRemoveFailed)
 		return
 	}
 
@@ -90,24 +94,58 @@ func (s *Server) RemoveFavoriteHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      List favorite nodes
-// @Description  Retrieves a list of all files and folders marked as favorite by the current user.
+// @Description  Retrieves a list of all files and folders marked as favorite by the current user. Favorites of items the user can no longer access (e.g. after a share was revoked) are omitted.
 // @Tags         favorites
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   NodeResponse
-// @Failure      401  {string}  string "Unauthorized"
-// @Failure      500  {string}  string "Internal Server Error"
+// @Param        fields  query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,name,node_type,modified_at"
+// @Success      200     {array}   dto.Node
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
 // @Router       /favorites [get]
 func (s *Server) ListFavoritesHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	limit, offset := parsePagination(r)
 
-	nodes, err := s.store.ListFavorites(r.Context(), claims.UserID, limit, offset)
+	nodes, err := s.store.ReadReplica().ListFavorites(r.Context(), claims.UserID, limit, offset)
 	if err != nil {
-		http.Error(w, "Failed to list favorites", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.FavoritesListFailed)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	writeListing(w, r, dto.Nodes(nodes))
+}
+
+// pruneInaccessibleFavorites drops favorites that outlived the share granting
+// access to them and tells the affected users about it.
+func (s *Server) pruneInaccessibleFavorites(ctx context.Context) error {
+	var removed []database.RemovedFavorite
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var err error
+		removed, err = q.DeleteInaccessibleFavorites(ctx)
+		if err != nil {
+			return err
+		}
+		for _, favorite := range removed {
+			payload := map[string]string{"node_id": favorite.NodeID, "reason": "access_lost"}
+			if err := q.LogEvent(ctx, favorite.UserID, "favorite_removed", payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		return txErr
+	}
+
+	for _, favorite := range removed {
+		payload := map[string]string{"node_id": favorite.NodeID, "reason": "access_lost"}
+		eventMsg := map[string]interface{}{"event_type": "favorite_removed", "payload": payload}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(favorite.UserID, eventBytes)
+	}
+	if len(removed) > 0 {
+		log.Printf("Favorites cleanup: removed %d favorites of no longer accessible nodes", len(removed))
+	}
+	return nil
 }
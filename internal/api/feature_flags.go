@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/features"
+	"serwer-plikow/internal/i18n"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Where the state of a feature flag comes from.
+const (
+	featureSourceDefault  = "default"
+	featureSourceConfig   = "config"
+	featureSourceOverride = "override"
+)
+
+type FeatureFlagResponse struct {
+	Name        string `json:"name" example:"resumable_uploads"`
+	Description string `json:"description" example:"Resumable uploads of large files in chunks (POST /nodes/file/sessions)"`
+	features.Flag
+	// Source is default, config or override.
+	Source   string                   `json:"source" example:"override"`
+	Override *dto.FeatureFlagOverride `json:"override,omitempty"`
+	Users    []dto.FeatureFlagUser    `json:"users"`
+}
+
+type SetFeatureFlagRequest struct {
+	Enabled        bool `json:"enabled" example:"true"`
+	RolloutPercent int  `json:"rollout_percent" example:"25"`
+}
+
+type SetFeatureFlagUserRequest struct {
+	Enabled bool `json:"enabled" example:"true"`
+}
+
+// resolveFeatureFlag returns the state of a known flag and where it comes
+// from: the administrator's override if there is one, else the configured
+// state, else the flag's default.
+func (s *Server) resolveFeatureFlag(def features.Definition, override *database.FeatureFlagOverride) (features.Flag, string) {
+	if override != nil {
+		return features.Flag{Enabled: override.Enabled, RolloutPercent: override.RolloutPercent}, featureSourceOverride
+	}
+	if configured, ok := s.config.Load().Features[def.Name]; ok {
+		return features.Flag{Enabled: configured.Enabled, RolloutPercent: configured.RolloutPercent}, featureSourceConfig
+	}
+	return def.Default, featureSourceDefault
+}
+
+func (s *Server) featureFlag(ctx context.Context, def features.Definition) (features.Flag, string, *database.FeatureFlagOverride, error) {
+	override, err := s.store.GetFeatureFlagOverride(ctx, def.Name)
+	if err != nil {
+		return features.Flag{}, "", nil, err
+	}
+	flag, source := s.resolveFeatureFlag(def, override)
+	return flag, source, override, nil
+}
+
+// featureEnabled reports whether a known flag is on for the user.
+func (s *Server) featureEnabled(ctx context.Context, name string, userID int64) (bool, error) {
+	def, ok := features.Lookup(name)
+	if !ok {
+		return false, nil
+	}
+	flag, _, _, err := s.featureFlag(ctx, def)
+	if err != nil {
+		return false, err
+	}
+	userOverride, err := s.store.GetFeatureFlagUserOverride(ctx, name, userID)
+	if err != nil {
+		return false, err
+	}
+	return features.Evaluate(name, flag, userOverride, userID), nil
+}
+
+// RequireFeature rejects requests of users for whom the flag is off.
+func (s *Server) RequireFeature(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetUserFromContext(r.Context())
+			enabled, err := s.featureEnabled(r.Context(), name, claims.UserID)
+			if err != nil {
+				log.Printf("ERROR: Failed to evaluate feature flag %s for user %d: %v", name, claims.UserID, err)
+				writeError(w, r, http.StatusInternalServerError, i18n.InternalError)
+				return
+			}
+			if !enabled {
+				writeError(w, r, http.StatusForbidden, i18n.FeatureDisabled)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) featureFlagResponse(ctx context.Context, def features.Definition) (*FeatureFlagResponse, error) {
+	flag, source, override, err := s.featureFlag(ctx, def)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.store.ListFeatureFlagUsers(ctx, def.Name)
+	if err != nil {
+		return nil, err
+	}
+	response := &FeatureFlagResponse{
+		Name:        def.Name,
+		Description: def.Description,
+		Flag:        flag,
+		Source:      source,
+		Users:       dto.FeatureFlagUsers(users),
+	}
+	if override != nil {
+		mapped := dto.FeatureFlagOverrideFrom(*override)
+		response.Override = &mapped
+	}
+	return response, nil
+}
+
+// lookupFeatureFlag resolves the flag named in the URL, answering 404 for an
+// unknown one.
+func lookupFeatureFlag(w http.ResponseWriter, r *http.Request) (features.Definition, bool) {
+	def, ok := features.Lookup(chi.URLParam(r, "flag"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, i18n.UnknownFeatureFlag)
+	}
+	return def, ok
+}
+
+// @Summary      List my features
+// @Description  Tells which optional features are enabled for the authenticated user, so clients can hide what the server would reject.
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]bool
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /me/features [get]
+func (s *Server) GetMyFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	overrides, err := s.store.ListFeatureFlagOverrides(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list feature flag overrides: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeaturesLookupFailed)
+		return
+	}
+	userOverrides, err := s.store.GetFeatureFlagUserOverrides(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to get feature flags of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeaturesLookupFailed)
+		return
+	}
+	overridden := make(map[string]*database.FeatureFlagOverride, len(overrides))
+	for i := range overrides {
+		overridden[overrides[i].Name] = &overrides[i]
+	}
+
+	response := make(map[string]bool, len(features.Known))
+	for _, def := range features.Known {
+		flag, _ := s.resolveFeatureFlag(def, overridden[def.Name])
+		var userOverride *bool
+		if enabled, ok := userOverrides[def.Name]; ok {
+			userOverride = &enabled
+		}
+		response[def.Name] = features.Evaluate(def.Name, flag, userOverride, claims.UserID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      List feature flags
+// @Description  Lists the known feature flags with their state, where it comes from (default, config or an administrator's override) and the users for whom the flag is forced on or off.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   FeatureFlagResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /admin/features [get]
+func (s *Server) ListFeatureFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	response := []FeatureFlagResponse{}
+	for _, def := range features.Known {
+		flag, err := s.featureFlagResponse(r.Context(), def)
+		if err != nil {
+			log.Printf("ERROR: Failed to get feature flag %s: %v", def.Name, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagsListFailed)
+			return
+		}
+		response = append(response, *flag)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      Override a feature flag
+// @Description  Turns a feature flag on or off, or rolls it out to a percentage of users (0 and 100 both mean everyone), replacing its configured state until the override is removed. Users are assigned to the rollout by a stable hash, so raising the percentage keeps the users already included.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        flag     path      string                 true  "Flag name"
+// @Param        request  body      SetFeatureFlagRequest  true  "New state"
+// @Success      200      {object}  FeatureFlagResponse
+// @Failure      400      {string}  string "Bad Request - rollout_percent is not between 0 and 100"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "Unknown feature flag"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/features/{flag} [put]
+func (s *Server) SetFeatureFlagHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	def, ok := lookupFeatureFlag(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetFeatureFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.RolloutPercent < 0 || req.RolloutPercent > 100 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRolloutPercent)
+		return
+	}
+
+	if _, err := s.store.SetFeatureFlagOverride(r.Context(), def.Name, req.Enabled, req.RolloutPercent, claims.UserID); err != nil {
+		log.Printf("ERROR: Failed to override feature flag %s: %v", def.Name, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagOverrideFailed)
+		return
+	}
+	log.Printf("Feature flag %s set to enabled=%t rollout_percent=%d by user %d", def.Name, req.Enabled, req.RolloutPercent, claims.UserID)
+	s.writeFeatureFlag(w, r, def)
+}
+
+// @Summary      Remove a feature flag override
+// @Description  Removes an administrator's override, so the flag follows the configuration again. Users for whom the flag is forced on or off are kept.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        flag  path      string  true  "Flag name"
+// @Success      200   {object}  FeatureFlagResponse
+// @Failure      401   {string}  string "Unauthorized"
+// @Failure      403   {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404   {string}  string "Unknown feature flag"
+// @Failure      500   {string}  string "Internal Server Error"
+// @Router       /admin/features/{flag} [delete]
+func (s *Server) ResetFeatureFlagHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	def, ok := lookupFeatureFlag(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := s.store.DeleteFeatureFlagOverride(r.Context(), def.Name)
+	if err != nil {
+		log.Printf("ERROR: Failed to remove override of feature flag %s: %v", def.Name, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagOverrideRemoveFailed)
+		return
+	}
+	if removed {
+		log.Printf("Feature flag %s override removed by user %d", def.Name, claims.UserID)
+	}
+	s.writeFeatureFlag(w, r, def)
+}
+
+// @Summary      Force a feature flag for a user
+// @Description  Turns a feature flag on or off for one user, whatever its rollout, e.g. to let testers try a feature before it is rolled out.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        flag     path      string                     true  "Flag name"
+// @Param        userId   path      int                        true  "User ID"
+// @Param        request  body      SetFeatureFlagUserRequest  true  "Whether the flag is on for the user"
+// @Success      200      {object}  FeatureFlagResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "Unknown feature flag or user"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/features/{flag}/users/{userId} [put]
+func (s *Server) SetFeatureFlagUserHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	def, ok := lookupFeatureFlag(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUserID)
+		return
+	}
+
+	var req SetFeatureFlagUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserDataLookupFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+
+	if err := s.store.SetFeatureFlagUser(r.Context(), def.Name, userID, req.Enabled); err != nil {
+		log.Printf("ERROR: Failed to force feature flag %s for user %d: %v", def.Name, userID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagForceFailed)
+		return
+	}
+	log.Printf("Feature flag %s forced to enabled=%t for user %d by user %d", def.Name, req.Enabled, userID, claims.UserID)
+	s.writeFeatureFlag(w, r, def)
+}
+
+// @Summary      Stop forcing a feature flag for a user
+// @Description  Lets the flag's rollout decide for the user again.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        flag    path      string  true  "Flag name"
+// @Param        userId  path      int     true  "User ID"
+// @Success      200     {object}  FeatureFlagResponse
+// @Failure      400     {string}  string "Bad Request"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404     {string}  string "Unknown feature flag, or the flag is not forced for the user"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/features/{flag}/users/{userId} [delete]
+func (s *Server) DeleteFeatureFlagUserHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	def, ok := lookupFeatureFlag(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUserID)
+		return
+	}
+
+	removed, err := s.store.DeleteFeatureFlagUser(r.Context(), def.Name, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to stop forcing feature flag %s for user %d: %v", def.Name, userID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagUpdateFailed)
+		return
+	}
+	if !removed {
+		writeError(w, r, http.StatusNotFound, i18n.FeatureFlagNotForced)
+		return
+	}
+	log.Printf("Feature flag %s no longer forced for user %d, changed by user %d", def.Name, userID, claims.UserID)
+	s.writeFeatureFlag(w, r, def)
+}
+
+func (s *Server) writeFeatureFlag(w http.ResponseWriter, r *http.Request, def features.Definition) {
+	response, err := s.featureFlagResponse(r.Context(), def)
+	if err != nil {
+		log.Printf("ERROR: Failed to get feature flag %s: %v", def.Name, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FeatureFlagLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,652 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/federation"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultFederationTimeout = 30 * time.Second
+	// maxFederationBody bounds the JSON bodies peers send.
+	maxFederationBody   = 64 << 10
+	maxRemoteNameLength = 255
+)
+
+type CreateFederatedShareRequest struct {
+	// Recipient is a user of a peer instance, as "user@instance".
+	Recipient string `json:"recipient" example:"anna@partner"`
+}
+
+// federationPeer returns the trusted peer configured under name.
+func (s *Server) federationPeer(name string) (federation.Peer, bool) {
+	name = strings.ToLower(name)
+	peer, ok := s.config.Load().Federation.Peers[name]
+	if !ok || peer.URL == "" || peer.Secret == "" {
+		return federation.Peer{}, false
+	}
+	return federation.Peer{Name: name, URL: peer.URL, Secret: peer.Secret}, true
+}
+
+// verifyFederationRequest authenticates a request of a peer and returns the
+// peer and the request body, writing the error response and returning false
+// when the request is not from a trusted peer.
+func (s *Server) verifyFederationRequest(w http.ResponseWriter, r *http.Request) (federation.Peer, []byte, bool) {
+	if s.federation == nil {
+		writeError(w, r, http.StatusNotFound, i18n.FederationDisabled)
+		return federation.Peer{}, nil, false
+	}
+	peer, ok := s.federationPeer(r.Header.Get(federation.HeaderInstance))
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, i18n.UnknownInstance)
+		return federation.Peer{}, nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxFederationBody))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return federation.Peer{}, nil, false
+	}
+	if err := federation.Verify(peer.Secret, r, body, time.Now()); err != nil {
+		log.Printf("WARN: Rejected federation request from %s: %v", peer.Name, err)
+		writeErrorf(w, r, http.StatusUnauthorized, i18n.FederationRequestRejected, err)
+		return federation.Peer{}, nil, false
+	}
+	return peer, body, true
+}
+
+func (s *Server) publishRemoteShareEvent(ctx context.Context, recipientID int64, eventType string, payload interface{}) {
+	if err := s.store.LogEvent(ctx, recipientID, eventType, payload); err != nil {
+		log.Printf("ERROR: Failed to journal %s for user %d: %v", eventType, recipientID, err)
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": payload})
+	s.wsHub.PublishEvent(recipientID, eventBytes)
+}
+
+// loadFederatedShare returns the share a peer's request refers to with its
+// shared node, writing the error response when there is none.
+func (s *Server) loadFederatedShare(w http.ResponseWriter, r *http.Request, peer federation.Peer) (*database.FederatedShare, *models.Node) {
+	share, err := s.store.GetFederatedShareByToken(r.Context(), peer.Name, chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FederatedShareLookupFailed)
+		return nil, nil
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return nil, nil
+	}
+	root, err := s.store.GetNodeByID(r.Context(), share.NodeID, share.SharerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return nil, nil
+	}
+	if root == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return nil, nil
+	}
+	return share, root
+}
+
+// resolveSharedNode returns the node nodeID inside a shared subtree, the
+// shared node itself when nodeID is empty, or nil when it is outside the
+// share. It serves federated shares and public links alike.
+func (s *Server) resolveSharedNode(ctx context.Context, root *models.Node, nodeID string) (*models.Node, error) {
+	if nodeID == "" || nodeID == root.ID {
+		return root, nil
+	}
+	if root.NodeType != "folder" {
+		return nil, nil
+	}
+	inside, err := s.store.IsDescendantOf(ctx, root.ID, nodeID)
+	if err != nil || !inside {
+		return nil, err
+	}
+	return s.store.GetNodeByID(ctx, nodeID, root.OwnerID)
+}
+
+func remoteNode(node models.Node) federation.RemoteNode {
+	return federation.RemoteNode{
+		ID:         node.ID,
+		ParentID:   node.ParentID,
+		Name:       node.Name,
+		NodeType:   node.NodeType,
+		SizeBytes:  node.SizeBytes,
+		MimeType:   node.MimeType,
+		ModifiedAt: node.ModifiedAt,
+	}
+}
+
+// writePeerError answers a failed request to a peer: a share or node the
+// peer does not know is a 404, anything else a 502.
+func writePeerError(w http.ResponseWriter, r *http.Request, err error, peerName string) {
+	var peerErr *federation.PeerError
+	if errors.As(err, &peerErr) && peerErr.StatusCode == http.StatusNotFound {
+		writeErrorf(w, r, http.StatusNotFound, i18n.PeerNotFound, peerName)
+		return
+	}
+	log.Printf("ERROR: Federation request to %s failed: %v", peerName, err)
+	writeErrorf(w, r, http.StatusBadGateway, i18n.PeerUnreachable, peerName)
+}
+
+// @Summary      Receive a federated share (server-to-server)
+// @Description  Called by a trusted peer instance to offer a share to a local user. The request must be signed with the peer's secret (X-Federation-Instance, X-Federation-Timestamp and X-Federation-Signature headers). The recipient is told with a "remote_share_received" event.
+// @Tags         federation
+// @Accept       json
+// @Produce      json
+// @Param        offer  body      federation.ShareOffer  true  "Share offer"
+// @Success      201    {object}  dto.RemoteShare
+// @Failure      400    {string}  string "Bad Request"
+// @Failure      401    {string}  string "Unauthorized - Unknown instance or invalid signature"
+// @Failure      404    {string}  string "Federation is disabled or the recipient does not exist"
+// @Failure      409    {string}  string "Conflict - The token is used by another share"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /federation/shares [post]
+func (s *Server) ReceiveFederatedShareHandler(w http.ResponseWriter, r *http.Request) {
+	peer, body, ok := s.verifyFederationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var offer federation.ShareOffer
+	if err := json.Unmarshal(body, &offer); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if offer.Permissions == "" {
+		offer.Permissions = "read"
+	}
+	if offer.Token == "" || len(offer.Token) > 64 || offer.Owner == "" || len(offer.Owner) > maxRemoteNameLength ||
+		offer.Name == "" || len(offer.Name) > maxRemoteNameLength || (offer.NodeType != "file" && offer.NodeType != "folder") ||
+		offer.Permissions != "read" {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareOffer)
+		return
+	}
+
+	recipient, err := s.store.GetUserByUsername(r.Context(), offer.Recipient)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserLookupFailed)
+		return
+	}
+	if recipient == nil {
+		writeError(w, r, http.StatusNotFound, i18n.RecipientNotFound)
+		return
+	}
+
+	share, err := s.store.CreateRemoteShare(r.Context(), database.CreateRemoteShareParams{
+		Peer:             peer.Name,
+		Token:            offer.Token,
+		RecipientID:      recipient.ID,
+		Owner:            offer.Owner,
+		OwnerDisplayName: offer.OwnerDisplayName,
+		Name:             offer.Name,
+		NodeType:         offer.NodeType,
+		SizeBytes:        offer.SizeBytes,
+		MimeType:         offer.MimeType,
+		Permissions:      offer.Permissions,
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to record share offered by %s: %v", peer.Name, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareRecordFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusConflict, i18n.ShareTokenTaken)
+		return
+	}
+	s.publishRemoteShareEvent(r.Context(), recipient.ID, "remote_share_received", dto.RemoteShareFrom(*share))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.RemoteShareFrom(*share))
+}
+
+// @Summary      Revoke a federated share (server-to-server)
+// @Description  Called by a trusted peer instance when the owner removed a share. The recipient is told with a "remote_share_revoked" event.
+// @Tags         federation
+// @Param        token  path      string  true  "Share token"
+// @Success      204    {null}    nil     "No Content"
+// @Failure      401    {string}  string "Unauthorized - Unknown instance or invalid signature"
+// @Failure      404    {string}  string "Federation is disabled or the share does not exist"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /federation/shares/{token} [delete]
+func (s *Server) RevokeFederatedShareHandler(w http.ResponseWriter, r *http.Request) {
+	peer, _, ok := s.verifyFederationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	share, err := s.store.DeleteRemoteShareByToken(r.Context(), peer.Name, chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareRevokeFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return
+	}
+	s.publishRemoteShareEvent(r.Context(), share.RecipientID, "remote_share_revoked", map[string]int64{"id": share.ID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      List a federated folder (server-to-server)
+// @Description  Called by a trusted peer instance to list a folder inside a share: the shared folder itself without parent_id.
+// @Tags         federation
+// @Produce      json
+// @Param        token      path      string  true   "Share token"
+// @Param        parent_id  query     string  false  "Folder inside the share"
+// @Param        limit      query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset     query     int     false  "Number of items to skip" default(0)
+// @Success      200        {array}   federation.RemoteNode
+// @Failure      400        {string}  string "Bad Request - Not a folder"
+// @Failure      401        {string}  string "Unauthorized - Unknown instance or invalid signature"
+// @Failure      404        {string}  string "Not Found"
+// @Failure      500        {string}  string "Internal Server Error"
+// @Router       /federation/shares/{token}/nodes [get]
+func (s *Server) ListFederatedShareNodesHandler(w http.ResponseWriter, r *http.Request) {
+	peer, _, ok := s.verifyFederationRequest(w, r)
+	if !ok {
+		return
+	}
+	_, root := s.loadFederatedShare(w, r, peer)
+	if root == nil {
+		return
+	}
+
+	folder, err := s.resolveSharedNode(r.Context(), root, r.URL.Query().Get("parent_id"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if folder == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNodeNotFound)
+		return
+	}
+	if folder.NodeType != "folder" {
+		writeError(w, r, http.StatusBadRequest, i18n.NotAFolder)
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	children, err := s.store.GetNodesByParentID(r.Context(), folder.OwnerID, &folder.ID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderListFailed)
+		return
+	}
+	nodes := make([]federation.RemoteNode, 0, len(children))
+	for _, child := range children {
+		nodes = append(nodes, remoteNode(child))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// @Summary      Download from a federated share (server-to-server)
+// @Description  Called by a trusted peer instance to download a file inside a share: the shared file itself without node_id.
+// @Tags         federation
+// @Produce      octet-stream
+// @Param        token    path      string  true   "Share token"
+// @Param        node_id  query     string  false  "File inside the share"
+// @Success      200      {file}    binary  "File content"
+// @Failure      400      {string}  string "Bad Request - Not a file"
+// @Failure      401      {string}  string "Unauthorized - Unknown instance or invalid signature"
+// @Failure      403      {string}  string "Forbidden - The file is quarantined"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /federation/shares/{token}/download [get]
+func (s *Server) DownloadFederatedShareHandler(w http.ResponseWriter, r *http.Request) {
+	peer, _, ok := s.verifyFederationRequest(w, r)
+	if !ok {
+		return
+	}
+	_, root := s.loadFederatedShare(w, r, peer)
+	if root == nil {
+		return
+	}
+
+	node, err := s.resolveSharedNode(r.Context(), root, r.URL.Query().Get("node_id"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNodeNotFound)
+		return
+	}
+	if node.NodeType != "file" {
+		writeError(w, r, http.StatusBadRequest, i18n.NotAFile)
+		return
+	}
+	if quarantined, err := s.isQuarantined(r.Context(), node.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	} else if quarantined {
+		writeError(w, r, http.StatusForbidden, i18n.QuarantinedFile)
+		return
+	}
+
+	content, err := s.openNodeContent(r.Context(), node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", node.Name))
+	if node.MimeType != nil && *node.MimeType != "" {
+		w.Header().Set("Content-Type", *node.MimeType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if node.SizeBytes != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*node.SizeBytes, 10))
+	}
+	io.Copy(w, content)
+}
+
+// @Summary      Share with a user of another instance
+// @Description  Experimental. Shares an owned file or folder, read-only, with a user of a trusted peer instance (federation.peers), addressed as "user@instance". The peer is told about the share right away; its user browses and downloads it through their own instance.
+// @Tags         federation
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId   path      string                       true  "Node ID"
+// @Param        request  body      CreateFederatedShareRequest  true  "Recipient"
+// @Success      201      {object}  dto.FederatedShare
+// @Failure      400      {string}  string "Bad Request - Invalid address or unknown instance"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - The content policy does not allow sharing the content"
+// @Failure      404      {string}  string "Federation is disabled, or the node or recipient was not found"
+// @Failure      409      {string}  string "Conflict - Already shared with this recipient"
+// @Failure      502      {string}  string "Bad Gateway - The instance could not be reached"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/federated-shares [post]
+func (s *Server) CreateFederatedShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+	if s.federation == nil {
+		writeError(w, r, http.StatusNotFound, i18n.FederationDisabled)
+		return
+	}
+
+	var req CreateFederatedShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	recipient, instance, err := federation.ParseAddress(req.Recipient)
+	if err != nil {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidFederatedAddress, err)
+		return
+	}
+	peer, ok := s.federationPeer(instance)
+	if !ok {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.UnknownInstanceName, instance)
+		return
+	}
+
+	node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotOwnedByYou)
+		return
+	}
+	if err := s.checkShareContent(r.Context(), node); err != nil {
+		var policyErr *contentPolicyError
+		if errors.As(err, &policyErr) {
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("ERROR: Content policy failed for shared node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharedContentCheckFailed)
+		return
+	}
+	owner, err := s.store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil || owner == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserLookupFailed)
+		return
+	}
+
+	token, err := ids.Token()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareTokenFailed)
+		return
+	}
+	share, err := s.store.CreateFederatedShare(r.Context(), token, node.ID, claims.UserID, peer.Name, recipient)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			writeErrorf(w, r, http.StatusConflict, i18n.AlreadySharedWith, req.Recipient)
+			return
+		}
+		log.Printf("ERROR: Failed to create federated share of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareCreateFailed)
+		return
+	}
+
+	offer := federation.ShareOffer{
+		Token:            token,
+		Owner:            owner.Username,
+		OwnerDisplayName: owner.DisplayName,
+		Recipient:        recipient,
+		Name:             node.Name,
+		NodeType:         node.NodeType,
+		SizeBytes:        node.SizeBytes,
+		MimeType:         node.MimeType,
+		Permissions:      "read",
+	}
+	if err := s.federation.OfferShare(r.Context(), peer, offer); err != nil {
+		if _, deleteErr := s.store.DeleteFederatedShare(r.Context(), share.ID, claims.UserID); deleteErr != nil {
+			log.Printf("ERROR: Failed to remove undelivered federated share %d: %v", share.ID, deleteErr)
+		}
+		writePeerError(w, r, err, peer.Name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.FederatedShareFrom(*share))
+}
+
+// @Summary      List shares with other instances
+// @Description  Lists the user's shares with users of peer instances, newest first.
+// @Tags         federation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.FederatedShare
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /federated-shares [get]
+func (s *Server) ListFederatedSharesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	shares, err := s.store.ListFederatedShares(r.Context(), claims.UserID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.SharesListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.FederatedShares(shares))
+}
+
+// @Summary      Remove a share with another instance
+// @Description  Removes a share with a user of a peer instance. The share stops working at once; the peer is told on a best-effort basis.
+// @Tags         federation
+// @Security     BearerAuth
+// @Param        shareId  path      int     true  "Federated share ID"
+// @Success      204      {null}    nil     "No Content"
+// @Failure      400      {string}  string "Bad Request - Invalid share ID"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Share not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /federated-shares/{shareId} [delete]
+func (s *Server) DeleteFederatedShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareID)
+		return
+	}
+
+	share, err := s.store.DeleteFederatedShare(r.Context(), shareID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareRevokeFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return
+	}
+
+	if peer, ok := s.federationPeer(share.Peer); ok && s.federation != nil {
+		if err := s.federation.RevokeShare(r.Context(), peer, share.Token); err != nil {
+			log.Printf("WARN: Failed to tell %s about revoked share %d: %v", share.Peer, share.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadRemoteShare returns a share offered to the user by a peer that is still
+// trusted, writing the error response when there is none.
+func (s *Server) loadRemoteShare(w http.ResponseWriter, r *http.Request) (*database.RemoteShare, federation.Peer) {
+	claims := GetUserFromContext(r.Context())
+	if s.federation == nil {
+		writeError(w, r, http.StatusNotFound, i18n.FederationDisabled)
+		return nil, federation.Peer{}
+	}
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareID)
+		return nil, federation.Peer{}
+	}
+	share, err := s.store.GetRemoteShare(r.Context(), shareID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FederatedShareLookupFailed)
+		return nil, federation.Peer{}
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return nil, federation.Peer{}
+	}
+	peer, ok := s.federationPeer(share.Peer)
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, i18n.PeerNoLongerTrusted, share.Peer)
+		return nil, federation.Peer{}
+	}
+	return share, peer
+}
+
+// @Summary      List shares from other instances
+// @Description  Lists the shares users of peer instances offered to the user, newest first.
+// @Tags         federation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.RemoteShare
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /remote-shares [get]
+func (s *Server) ListRemoteSharesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	shares, err := s.store.ListRemoteShares(r.Context(), claims.UserID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.SharesListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.RemoteShares(shares))
+}
+
+// @Summary      Browse a share from another instance
+// @Description  Lists a folder inside a share from a peer instance, fetched from the owner's instance: the shared folder itself without parent_id.
+// @Tags         federation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        shareId    path      int     true   "Remote share ID"
+// @Param        parent_id  query     string  false  "Folder inside the share"
+// @Success      200        {array}   federation.RemoteNode
+// @Failure      400        {string}  string "Bad Request"
+// @Failure      401        {string}  string "Unauthorized"
+// @Failure      404        {string}  string "Not Found"
+// @Failure      502        {string}  string "Bad Gateway - The instance could not be reached"
+// @Router       /remote-shares/{shareId}/nodes [get]
+func (s *Server) ListRemoteShareNodesHandler(w http.ResponseWriter, r *http.Request) {
+	share, peer := s.loadRemoteShare(w, r)
+	if share == nil {
+		return
+	}
+	if share.NodeType != "folder" && r.URL.Query().Get("parent_id") == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.NotAFolder)
+		return
+	}
+
+	nodes, err := s.federation.ListNodes(r.Context(), peer, share.Token, r.URL.Query().Get("parent_id"))
+	if err != nil {
+		writePeerError(w, r, err, peer.Name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// @Summary      Download from a share from another instance
+// @Description  Downloads a file inside a share from a peer instance, proxied from the owner's instance: the shared file itself without node_id.
+// @Tags         federation
+// @Produce      octet-stream
+// @Security     BearerAuth
+// @Param        shareId  path      int     true   "Remote share ID"
+// @Param        node_id  query     string  false  "File inside the share"
+// @Success      200      {file}    binary  "File content"
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      502      {string}  string "Bad Gateway - The instance could not be reached"
+// @Router       /remote-shares/{shareId}/download [get]
+func (s *Server) DownloadRemoteShareHandler(w http.ResponseWriter, r *http.Request) {
+	share, peer := s.loadRemoteShare(w, r)
+	if share == nil {
+		return
+	}
+
+	resp, err := s.federation.Download(r.Context(), peer, share.Token, r.URL.Query().Get("node_id"))
+	if err != nil {
+		writePeerError(w, r, err, peer.Name)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("ERROR: Failed to proxy download of remote share %d from %s: %v", share.ID, peer.Name, err)
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"sort"
+)
+
+const (
+	// maxFolderCompareNodes bounds the size of each compared folder tree.
+	maxFolderCompareNodes = 50000
+	// defaultFolderCompareLimit and maxFolderCompareLimit bound the number of
+	// differences returned.
+	defaultFolderCompareLimit = 1000
+	maxFolderCompareLimit     = 10000
+)
+
+type CompareFoldersRequest struct {
+	LeftID  string `json:"left_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	RightID string `json:"right_id" example:"bNowyFolderRodzic123"`
+	// Limit caps the number of differences returned (default 1000, at most
+	// 10000).
+	Limit int `json:"limit,omitempty" example:"1000"`
+}
+
+// ComparedFile is a file found in only one of the compared folders.
+type ComparedFile struct {
+	// Path is relative to the compared folder, e.g. "umowy/2024/umowa.pdf".
+	Path      string  `json:"path" example:"umowy/umowa.pdf"`
+	ID        string  `json:"id" example:"_vx2a-43VqRT5wz_s9u4"`
+	SizeBytes *int64  `json:"size_bytes,omitempty" example:"1048576"`
+	SHA256    *string `json:"sha256,omitempty"`
+}
+
+// ChangedFile is a file present under the same path in both folders with
+// different content.
+type ChangedFile struct {
+	Path           string  `json:"path" example:"umowy/umowa.pdf"`
+	LeftID         string  `json:"left_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	RightID        string  `json:"right_id" example:"Uakgb_J5m9g-0JDMbcJqL"`
+	LeftSizeBytes  *int64  `json:"left_size_bytes,omitempty" example:"1048576"`
+	RightSizeBytes *int64  `json:"right_size_bytes,omitempty" example:"1050624"`
+	LeftSHA256     *string `json:"left_sha256,omitempty"`
+	RightSHA256    *string `json:"right_sha256,omitempty"`
+}
+
+type CompareFoldersResponse struct {
+	// Added are the files only in the right folder, Removed those only in
+	// the left one.
+	Added     []ComparedFile `json:"added"`
+	Removed   []ComparedFile `json:"removed"`
+	Changed   []ChangedFile  `json:"changed"`
+	Unchanged int            `json:"unchanged" example:"120"`
+	// Truncated is set when there were more differences than the limit.
+	Truncated bool `json:"truncated" example:"false"`
+}
+
+// subtreeFiles maps the files of a subtree, as returned by ListSubtreeNodes,
+// by their path relative to the root.
+func subtreeFiles(rootID string, nodes []models.Node) map[string]models.Node {
+	paths := map[string]string{rootID: ""}
+	files := make(map[string]models.Node)
+	for _, node := range nodes {
+		if node.ID == rootID || node.ParentID == nil {
+			continue
+		}
+		parentPath, ok := paths[*node.ParentID]
+		if !ok {
+			continue
+		}
+		path := node.Name
+		if parentPath != "" {
+			path = parentPath + "/" + node.Name
+		}
+		if node.NodeType == "folder" {
+			paths[node.ID] = path
+		} else {
+			files[path] = node
+		}
+	}
+	return files
+}
+
+// sameContent compares files by checksum, or by size when either checksum
+// is unknown.
+func sameContent(left, right models.Node) bool {
+	if left.ContentSHA256 != nil && right.ContentSHA256 != nil {
+		return *left.ContentSHA256 == *right.ContentSHA256
+	}
+	return left.SizeBytes != nil && right.SizeBytes != nil && *left.SizeBytes == *right.SizeBytes
+}
+
+func comparedFile(path string, node models.Node) ComparedFile {
+	return ComparedFile{Path: path, ID: node.ID, SizeBytes: node.SizeBytes, SHA256: node.ContentSHA256}
+}
+
+// compareFolderTrees lists the differences between the files of two
+// subtrees in path order, stopping after limit differences.
+func compareFolderTrees(leftID string, left []models.Node, rightID string, right []models.Node, limit int) CompareFoldersResponse {
+	leftFiles := subtreeFiles(leftID, left)
+	rightFiles := subtreeFiles(rightID, right)
+
+	paths := make([]string, 0, len(leftFiles)+len(rightFiles))
+	for path := range leftFiles {
+		paths = append(paths, path)
+	}
+	for path := range rightFiles {
+		if _, ok := leftFiles[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	response := CompareFoldersResponse{Added: []ComparedFile{}, Removed: []ComparedFile{}, Changed: []ChangedFile{}}
+	differences := 0
+	for _, path := range paths {
+		leftFile, inLeft := leftFiles[path]
+		rightFile, inRight := rightFiles[path]
+		if inLeft && inRight && sameContent(leftFile, rightFile) {
+			response.Unchanged++
+			continue
+		}
+		if differences == limit {
+			response.Truncated = true
+			continue
+		}
+		differences++
+		switch {
+		case !inLeft:
+			response.Added = append(response.Added, comparedFile(path, rightFile))
+		case !inRight:
+			response.Removed = append(response.Removed, comparedFile(path, leftFile))
+		default:
+			response.Changed = append(response.Changed, ChangedFile{
+				Path:           path,
+				LeftID:         leftFile.ID,
+				RightID:        rightFile.ID,
+				LeftSizeBytes:  leftFile.SizeBytes,
+				RightSizeBytes: rightFile.SizeBytes,
+				LeftSHA256:     leftFile.ContentSHA256,
+				RightSHA256:    rightFile.ContentSHA256,
+			})
+		}
+	}
+	return response
+}
+
+// @Summary      Compare two folders
+// @Description  Compares the files of two folders the user can access, including their subfolders, by path relative to each folder: files only in the right folder are added, files only in the left one removed, and files under the same path with a different SHA-256 checksum changed (files without a known checksum are compared by size). Useful before merging shared folders or to validate a sync. At most limit differences are returned, in path order, with truncated set when there were more; unchanged files are only counted. Folders with more than 50000 items cannot be compared.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      CompareFoldersRequest  true  "Folders to compare"
+// @Success      200      {object}  CompareFoldersResponse
+// @Failure      400      {string}  string "Bad Request - Missing folder ID or invalid limit"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Folder not found"
+// @Failure      422      {string}  string "Unprocessable Entity - A folder is too large to compare"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/compare [post]
+func (s *Server) CompareFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req CompareFoldersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.LeftID == "" || req.RightID == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.CompareIDsRequired)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultFolderCompareLimit
+	}
+	if req.Limit < 0 || req.Limit > maxFolderCompareLimit {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidCompareLimit, maxFolderCompareLimit)
+		return
+	}
+
+	trees := make([][]models.Node, 2)
+	for i, folderID := range []string{req.LeftID, req.RightID} {
+		folder, err := s.store.GetNodeIfAccessible(r.Context(), folderID, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+			return
+		}
+		if folder == nil || folder.NodeType != "folder" {
+			s.writeNodeNotFound(w, r, folderID, i18n.FolderNotAccessible)
+			return
+		}
+		entries, _, err := s.store.GetSubtreeTotals(r.Context(), []string{folder.ID})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.CompareFailed)
+			return
+		}
+		if entries > maxFolderCompareNodes {
+			writeErrorf(w, r, http.StatusUnprocessableEntity, i18n.FolderTooLargeToCompare, folder.ID, maxFolderCompareNodes)
+			return
+		}
+		if trees[i], err = s.store.ListSubtreeNodes(r.Context(), folder.ID); err != nil {
+			log.Printf("ERROR: Failed to list folder %s for comparison: %v", folder.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.CompareFailed)
+			return
+		}
+	}
+
+	response := compareFolderTrees(req.LeftID, trees[0], req.RightID, trees[1], req.Limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
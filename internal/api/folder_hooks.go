@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHookTimeout     = 10 * time.Second
+	defaultHookMaxAttempts = 5
+	// hookRetryBase is the delay before the first retry; it doubles after
+	// every failed attempt.
+	hookRetryBase = 30 * time.Second
+	// hookDeliveryBatch is how many deliveries a worker claims at once.
+	hookDeliveryBatch   = 50
+	maxHookMimePrefixes = 20
+	maxHookRedirects    = 5
+)
+
+type FolderHookRequest struct {
+	// URL is called with a POST for every file that arrives in the folder. Its
+	// host must be listed in hooks.allowed_hosts.
+	URL string `json:"url" example:"http://invoices.internal/ingest"`
+	// MimePrefixes limit the hook to files whose type starts with one of them,
+	// e.g. "application/pdf". Empty matches every file.
+	MimePrefixes []string `json:"mime_prefixes,omitempty" example:"application/pdf"`
+}
+
+// FolderHookPayload is the JSON body sent to a hook. It is signed with the
+// hook's secret in the X-Hook-Signature header as "sha256=" followed by the
+// hex HMAC-SHA256 of the body.
+type FolderHookPayload struct {
+	Event    string   `json:"event" example:"file_arrived"`
+	FolderID string   `json:"folder_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Node     dto.Node `json:"node"`
+	Attempt  int      `json:"attempt" example:"1"`
+}
+
+func (s *Server) hookMaxAttempts() int {
+	if s.config.Load().Hooks.MaxAttempts > 0 {
+		return s.config.Load().Hooks.MaxAttempts
+	}
+	return defaultHookMaxAttempts
+}
+
+// hookURLAllowed reports whether a hook may call rawURL: it must be an
+// absolute http(s) URL whose host, with or without its port, is listed in
+// hooks.allowed_hosts. The list keeps hooks from probing arbitrary hosts from
+// inside the server's network.
+func (s *Server) hookURLAllowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	for _, allowed := range s.config.Load().Hooks.AllowedHosts {
+		if strings.EqualFold(allowed, u.Host) || strings.EqualFold(allowed, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHookClient returns the client hooks are delivered with. Redirects are
+// only followed to hosts hooks.allowed_hosts lists as well, so an allowed host
+// cannot forward the signed payloads elsewhere.
+func (s *Server) newHookClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxHookRedirects {
+				return errors.New("too many redirects")
+			}
+			if !s.hookURLAllowed(req.URL.String()) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+}
+
+// enqueueFolderHooks queues hook deliveries for files that arrived in a
+// folder. Failures are logged, as the files themselves are already stored.
+func (s *Server) enqueueFolderHooks(ctx context.Context, parentID *string, nodes []models.Node) {
+	if parentID == nil || len(s.config.Load().Hooks.AllowedHosts) == 0 {
+		return
+	}
+	nodeIDs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.NodeType == "file" {
+			nodeIDs = append(nodeIDs, node.ID)
+		}
+	}
+	if len(nodeIDs) == 0 {
+		return
+	}
+	if _, err := s.store.EnqueueFolderHookDeliveries(ctx, *parentID, nodeIDs); err != nil {
+		log.Printf("ERROR: Failed to queue hook deliveries for folder %s: %v", *parentID, err)
+	}
+}
+
+func signHookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendFolderHook makes one delivery attempt for a file that still exists.
+func (s *Server) sendFolderHook(ctx context.Context, delivery database.FolderHookDelivery, node *models.Node) error {
+	if !s.hookURLAllowed(delivery.URL) {
+		return fmt.Errorf("host of %s is no longer allowed", delivery.URL)
+	}
+	body, err := json.Marshal(FolderHookPayload{Event: "file_arrived", FolderID: delivery.FolderID, Node: dto.NodeFrom(*node), Attempt: delivery.Attempts})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Hook-Signature", signHookPayload(delivery.Secret, body))
+
+	resp, err := s.hookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// deliverFolderHooks sends due hook deliveries until none are left. Failed
+// deliveries are retried with exponential backoff and dropped after
+// hooks.max_attempts attempts.
+func (s *Server) deliverFolderHooks(ctx context.Context) error {
+	for ctx.Err() == nil {
+		deliveries, err := s.store.ClaimFolderHookDeliveries(ctx, hookDeliveryBatch, time.Now().Add(s.hookClient.Timeout+time.Minute))
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, delivery := range deliveries {
+			node, err := s.store.GetNodeByID(ctx, delivery.NodeID, delivery.OwnerID)
+			if err == nil && node != nil {
+				err = s.sendFolderHook(ctx, delivery, node)
+			}
+			if err == nil {
+				if err := s.store.DeleteFolderHookDelivery(ctx, delivery.ID); err != nil {
+					log.Printf("ERROR: Failed to remove hook delivery %d: %v", delivery.ID, err)
+				}
+				continue
+			}
+
+			if delivery.Attempts >= s.hookMaxAttempts() {
+				log.Printf("WARN: Dropping hook delivery %d of node %s to %s after %d attempts: %v", delivery.ID, delivery.NodeID, delivery.URL, delivery.Attempts, err)
+				if err := s.store.DeleteFolderHookDelivery(ctx, delivery.ID); err != nil {
+					log.Printf("ERROR: Failed to remove hook delivery %d: %v", delivery.ID, err)
+				}
+				continue
+			}
+			retryAt := time.Now().Add(hookRetryBase << (delivery.Attempts - 1))
+			if err := s.store.RetryFolderHookDelivery(ctx, delivery.ID, retryAt, err.Error()); err != nil {
+				log.Printf("ERROR: Failed to reschedule hook delivery %d: %v", delivery.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// @Summary      Get folder hook
+// @Description  Returns the upload hook of a folder owned by the user. The secret used to sign deliveries is only returned when the hook is set.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Folder ID"
+// @Success      200     {object}  dto.FolderHook
+// @Failure      400     {string}  string "Bad Request - Not a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder or hook not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/hook [get]
+func (s *Server) GetFolderHookHandler(w http.ResponseWriter, r *http.Request) {
+	folder := s.loadOwnedFolder(w, r, "Hooks")
+	if folder == nil {
+		return
+	}
+
+	hook, err := s.store.GetFolderHook(r.Context(), folder.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.HookLookupFailed)
+		return
+	}
+	if hook == nil {
+		writeError(w, r, http.StatusNotFound, i18n.HookNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.FolderHookFrom(*hook))
+}
+
+// @Summary      Set folder hook
+// @Description  Attaches a processing hook to a folder owned by the user: every file that arrives directly in the folder (uploads, archive imports, transcripts) is announced with a signed POST of FolderHookPayload to the URL, from a background queue with retries. The URL's host must be listed in hooks.allowed_hosts. A new hook gets a random secret; replacing a hook keeps it. The response includes the secret.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string             true  "Folder ID"
+// @Param        hook    body      FolderHookRequest  true  "Hook target"
+// @Success      200     {object}  dto.FolderHookWithSecret
+// @Failure      400     {string}  string "Bad Request - Invalid or disallowed URL"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/hook [put]
+func (s *Server) SetFolderHookHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	folder := s.loadOwnedFolder(w, r, "Hooks")
+	if folder == nil {
+		return
+	}
+
+	var req FolderHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if !s.hookURLAllowed(req.URL) {
+		writeError(w, r, http.StatusBadRequest, i18n.HookURLNotAllowed)
+		return
+	}
+	if len(req.MimePrefixes) > maxHookMimePrefixes {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.TooManyHookMimePrefixes, maxHookMimePrefixes)
+		return
+	}
+	if req.MimePrefixes == nil {
+		req.MimePrefixes = []string{}
+	}
+
+	secret, err := ids.Token()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.HookSecretFailed)
+		return
+	}
+	hook, err := s.store.SetFolderHook(r.Context(), folder.ID, claims.UserID, req.URL, secret, req.MimePrefixes)
+	if err != nil {
+		log.Printf("ERROR: Failed to set hook of folder %s: %v", folder.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.HookSaveFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.FolderHookWithSecretFrom(*hook))
+}
+
+// @Summary      Remove folder hook
+// @Description  Removes the upload hook of a folder owned by the user. Queued deliveries are dropped.
+// @Tags         nodes
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Folder ID"
+// @Success      204     {null}    nil     "No Content"
+// @Failure      400     {string}  string "Bad Request - Not a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Folder or hook not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/hook [delete]
+func (s *Server) DeleteFolderHookHandler(w http.ResponseWriter, r *http.Request) {
+	folder := s.loadOwnedFolder(w, r, "Hooks")
+	if folder == nil {
+		return
+	}
+
+	deleted, err := s.store.DeleteFolderHook(r.Context(), folder.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.HookRemoveFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.HookNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,552 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxGroupNameLength = 100
+
+type GroupRequest struct {
+	Name string `json:"name" example:"Dział marketingu"`
+}
+
+type AddGroupMemberRequest struct {
+	Username string `json:"username" example:"user2"`
+}
+
+// validateGroupName returns why a trimmed group name is not allowed, or nil.
+func validateGroupName(name string) *opError {
+	if name == "" {
+		return newOpError(http.StatusBadRequest, i18n.GroupNameRequired)
+	}
+	if utf8.RuneCountInString(name) > maxGroupNameLength {
+		return newOpError(http.StatusBadRequest, i18n.GroupNameTooLong, maxGroupNameLength)
+	}
+	return nil
+}
+
+// loadGroup returns the group from the URL if the user is a member of it,
+// writing the error response otherwise. With ownerOnly, members who do not
+// own the group are refused, as are changes to groups synchronized from the
+// directory.
+func (s *Server) loadGroup(w http.ResponseWriter, r *http.Request, ownerOnly bool) *models.Group {
+	claims := GetUserFromContext(r.Context())
+	groupID, err := strconv.ParseInt(chi.URLParam(r, "groupId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidGroupIDFormat)
+		return nil
+	}
+	group, err := s.store.GetGroupForMember(r.Context(), groupID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupLookupFailed)
+		return nil
+	}
+	if group == nil {
+		writeError(w, r, http.StatusNotFound, i18n.GroupNotFound)
+		return nil
+	}
+	if ownerOnly && group.Directory {
+		writeError(w, r, http.StatusConflict, i18n.DirectoryManagedGroup)
+		return nil
+	}
+	if ownerOnly && *group.OwnerID != claims.UserID {
+		writeError(w, r, http.StatusForbidden, i18n.GroupOwnerRequired)
+		return nil
+	}
+	return group
+}
+
+// publishGroupEvent journals an event for each of the users and notifies them
+// over WebSocket.
+func (s *Server) publishGroupEvent(ctx context.Context, userIDs []int64, eventType string, payload interface{}) {
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": payload})
+	for _, userID := range userIDs {
+		if err := s.store.LogEvent(ctx, userID, eventType, payload); err != nil {
+			log.Printf("ERROR: Failed to journal %s for user %d: %v", eventType, userID, err)
+		}
+		s.wsHub.PublishEvent(userID, eventBytes)
+	}
+}
+
+// @Summary      Create a group
+// @Description  Creates a group of users that nodes can be shared with at once. The creator owns the group, manages its members and is its first member.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        groupRequest  body      GroupRequest  true  "Group name"
+// @Success      201           {object}  dto.Group
+// @Failure      400           {string}  string "Bad Request"
+// @Failure      401           {string}  string "Unauthorized"
+// @Failure      409           {string}  string "Conflict - You already have a group with this name"
+// @Failure      500           {string}  string "Internal Server Error"
+// @Router       /groups [post]
+func (s *Server) CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if problem := validateGroupName(name); problem != nil {
+		writeLocalizedError(w, r, problem.status, problem)
+		return
+	}
+
+	group, err := s.store.CreateGroup(r.Context(), claims.UserID, name)
+	if err != nil {
+		if errors.Is(err, database.ErrGroupExists) {
+			writeError(w, r, http.StatusConflict, i18n.GroupExists)
+			return
+		}
+		log.Printf("ERROR: Failed to create group for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupCreateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.GroupFrom(*group))
+}
+
+// @Summary      List my groups
+// @Description  Lists the groups the user is a member of, including the ones they own and the ones synchronized from the LDAP directory (directory: true), which have no owner.
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   dto.Group
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /groups [get]
+func (s *Server) ListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	groups, err := s.store.ListGroupsForUser(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.Groups(groups))
+}
+
+// @Summary      Get a group
+// @Description  Returns a group the user is a member of, with its members.
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        groupId  path      int  true  "Group ID"
+// @Success      200      {object}  dto.GroupDetails
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /groups/{groupId} [get]
+func (s *Server) GetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group := s.loadGroup(w, r, false)
+	if group == nil {
+		return
+	}
+
+	members, err := s.store.ListGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMembersListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.GroupDetailsFrom(*group, members))
+}
+
+// @Summary      Rename a group
+// @Description  Renames a group. Only the group owner can do this; groups synchronized from the directory cannot be renamed.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        groupId       path      int           true  "Group ID"
+// @Param        groupRequest  body      GroupRequest  true  "New group name"
+// @Success      200           {object}  dto.Group
+// @Failure      400           {string}  string "Bad Request"
+// @Failure      401           {string}  string "Unauthorized"
+// @Failure      403           {string}  string "Forbidden - Not the group owner"
+// @Failure      404           {string}  string "Not Found"
+// @Failure      409           {string}  string "Conflict - You already have a group with this name, or the group is managed by the directory"
+// @Failure      500           {string}  string "Internal Server Error"
+// @Router       /groups/{groupId} [patch]
+func (s *Server) UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if problem := validateGroupName(name); problem != nil {
+		writeLocalizedError(w, r, problem.status, problem)
+		return
+	}
+
+	group := s.loadGroup(w, r, true)
+	if group == nil {
+		return
+	}
+
+	group, err := s.store.RenameGroup(r.Context(), group.ID, claims.UserID, name)
+	if err != nil {
+		if errors.Is(err, database.ErrGroupExists) {
+			writeError(w, r, http.StatusConflict, i18n.GroupExists)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupRenameFailed)
+		return
+	}
+	if group == nil {
+		writeError(w, r, http.StatusNotFound, i18n.GroupNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.GroupFrom(*group))
+}
+
+// @Summary      Delete a group
+// @Description  Deletes a group and its shares, so its members lose the access they had only through the group. Only the group owner can do this; groups synchronized from the directory are deleted with their directory entry.
+// @Tags         groups
+// @Security     BearerAuth
+// @Param        groupId  path      int  true  "Group ID"
+// @Success      204      {null}    nil "No Content"
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Not the group owner"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      409      {string}  string "Conflict - The group is managed by the directory"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /groups/{groupId} [delete]
+func (s *Server) DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	group := s.loadGroup(w, r, true)
+	if group == nil {
+		return
+	}
+	members, err := s.store.ListGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMembersListFailed)
+		return
+	}
+
+	deleted, err := s.store.DeleteGroup(r.Context(), group.ID, claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to delete group %d: %v", group.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupDeleteFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.GroupNotFound)
+		return
+	}
+
+	var removed []int64
+	for _, member := range members {
+		if member.UserID != claims.UserID {
+			removed = append(removed, member.UserID)
+		}
+	}
+	s.publishGroupEvent(r.Context(), removed, "group_member_removed", map[string]interface{}{"group_id": group.ID, "group_name": group.Name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Add a group member
+// @Description  Adds a user to a group, giving them access to everything shared with the group. Only the group owner can do this; members of groups synchronized from the directory follow the directory. The new member is notified with a group_member_added event.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        groupId        path      int                    true  "Group ID"
+// @Param        memberRequest  body      AddGroupMemberRequest  true  "User to add"
+// @Success      201            {object}  dto.GroupDetails
+// @Failure      400            {string}  string "Bad Request"
+// @Failure      401            {string}  string "Unauthorized"
+// @Failure      403            {string}  string "Forbidden - Not the group owner"
+// @Failure      404            {string}  string "Not Found - Group or user not found"
+// @Failure      409            {string}  string "Conflict - The user already is a member, or the group is managed by the directory"
+// @Failure      500            {string}  string "Internal Server Error"
+// @Router       /groups/{groupId}/members [post]
+func (s *Server) AddGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var req AddGroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+
+	group := s.loadGroup(w, r, true)
+	if group == nil {
+		return
+	}
+
+	user, err := s.store.GetUserByUsername(r.Context(), req.Username)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserFindFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+
+	added, err := s.store.AddGroupMember(r.Context(), group.ID, user.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to add user %d to group %d: %v", user.ID, group.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMemberAddFailed)
+		return
+	}
+	if !added {
+		writeError(w, r, http.StatusConflict, i18n.AlreadyGroupMember)
+		return
+	}
+	group.MemberCount++
+
+	s.publishGroupEvent(r.Context(), []int64{user.ID}, "group_member_added", map[string]interface{}{"group_id": group.ID, "group_name": group.Name})
+
+	members, err := s.store.ListGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMembersListFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.GroupDetailsFrom(*group, members))
+}
+
+// @Summary      Remove a group member
+// @Description  Removes a member from a group, taking away the access they had only through the group. The group owner can remove anyone else; any member can remove themselves to leave the group. The owner cannot leave their own group, and nobody can leave or be removed from a group synchronized from the directory.
+// @Tags         groups
+// @Security     BearerAuth
+// @Param        groupId  path      int  true  "Group ID"
+// @Param        userId   path      int  true  "ID of the member to remove"
+// @Success      204      {null}    nil "No Content"
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Not the group owner"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      409      {string}  string "Conflict - The group is managed by the directory"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /groups/{groupId}/members/{userId} [delete]
+func (s *Server) RemoveGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUserIDFormat)
+		return
+	}
+
+	group := s.loadGroup(w, r, userID != claims.UserID)
+	if group == nil {
+		return
+	}
+	if group.Directory {
+		writeError(w, r, http.StatusConflict, i18n.DirectoryManagedGroup)
+		return
+	}
+	if userID == *group.OwnerID {
+		writeError(w, r, http.StatusBadRequest, i18n.GroupOwnerCannotLeave)
+		return
+	}
+
+	removed, err := s.store.RemoveGroupMember(r.Context(), group.ID, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to remove user %d from group %d: %v", userID, group.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMemberRemoveFailed)
+		return
+	}
+	if !removed {
+		writeError(w, r, http.StatusNotFound, i18n.NotGroupMember)
+		return
+	}
+
+	s.publishGroupEvent(r.Context(), []int64{userID}, "group_member_removed", map[string]interface{}{"group_id": group.ID, "group_name": group.Name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      List group shares
+// @Description  Lists the nodes shared with a group the user is a member of.
+// @Tags         groups
+// @Produce      json
+// @Security     BearerAuth
+// @Param        groupId  path      int  true  "Group ID"
+// @Success      200      {array}   dto.GroupSharedNode
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /groups/{groupId}/shares [get]
+func (s *Server) ListGroupSharesHandler(w http.ResponseWriter, r *http.Request) {
+	group := s.loadGroup(w, r, false)
+	if group == nil {
+		return
+	}
+
+	shares, err := s.store.ListGroupShares(r.Context(), group.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupSharesListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.GroupSharedNodes(shares))
+}
+
+// @Summary      Revoke a group share
+// @Description  Revokes a share made to a group. The sharer and the group owner can do this. The other members are notified with a share_revoked_for_you event.
+// @Tags         groups
+// @Security     BearerAuth
+// @Param        groupId  path      int  true  "Group ID"
+// @Param        shareId  path      int  true  "Group share ID"
+// @Success      204      {null}    nil "No Content"
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /groups/{groupId}/shares/{shareId} [delete]
+func (s *Server) DeleteGroupShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareIDFormat)
+		return
+	}
+	group := s.loadGroup(w, r, false)
+	if group == nil {
+		return
+	}
+
+	share, err := s.store.DeleteGroupShare(r.Context(), shareID, group.ID, claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to delete group share %d: %v", shareID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareDeleteFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotDeletable)
+		return
+	}
+
+	members, err := s.store.ListGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		log.Printf("WARN: Failed to list members of group %d to notify: %v", group.ID, err)
+	}
+	var notified []int64
+	for _, member := range members {
+		if member.UserID != share.SharerID {
+			notified = append(notified, member.UserID)
+		}
+	}
+	s.publishGroupEvent(r.Context(), notified, "share_revoked_for_you", map[string]interface{}{"node_id": share.NodeID, "group_id": group.ID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// shareNodeWithGroup shares the sharer's node with a group they are a member
+// of, for ShareNodeHandler. Every other member is notified.
+func (s *Server) shareNodeWithGroup(w http.ResponseWriter, r *http.Request, node *models.Node, req ShareRequest) {
+	claims := GetUserFromContext(r.Context())
+
+	group, err := s.store.GetGroupForMember(r.Context(), *req.GroupID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupLookupFailed)
+		return
+	}
+	if group == nil {
+		writeError(w, r, http.StatusNotFound, i18n.GroupNotFound)
+		return
+	}
+
+	if err := s.checkShareContent(r.Context(), node); err != nil {
+		var policyErr *contentPolicyError
+		if errors.As(err, &policyErr) {
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("ERROR: Content policy failed for shared node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharedContentCheckFailed)
+		return
+	}
+
+	members, err := s.store.ListGroupMembers(r.Context(), group.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.GroupMembersListFailed)
+		return
+	}
+	var recipients []int64
+	for _, member := range members {
+		if member.UserID != claims.UserID {
+			recipients = append(recipients, member.UserID)
+		}
+	}
+
+	var payload map[string]interface{}
+	var share *models.GroupShare
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		share, err = q.ShareNodeWithGroup(r.Context(), database.ShareNodeWithGroupParams{
+			NodeID:      node.ID,
+			SharerID:    claims.UserID,
+			GroupID:     group.ID,
+			Permissions: req.Permissions,
+			Message:     req.Message,
+		})
+		if err != nil {
+			return err
+		}
+
+		payload = map[string]interface{}{"share_info": share, "node_info": node, "group_name": group.Name}
+		for _, recipientID := range recipients {
+			if err := q.LogEvent(r.Context(), recipientID, "node_shared_with_you", payload); err != nil {
+				return err
+			}
+		}
+		return q.LogEvent(r.Context(), claims.UserID, "node_share_created", payload)
+	})
+	if txErr != nil {
+		if errors.Is(txErr, database.ErrShareAlreadyExists) {
+			writeError(w, r, http.StatusConflict, i18n.AlreadySharedWithGroup)
+			return
+		}
+		log.Printf("ERROR: Failed to share node %s with group %d: %v", node.ID, group.ID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareNodeFailed)
+		return
+	}
+
+	recipientEvent, _ := json.Marshal(map[string]interface{}{"event_type": "node_shared_with_you", "payload": payload})
+	for _, recipientID := range recipients {
+		s.wsHub.PublishEvent(recipientID, recipientEvent)
+	}
+	sharerEvent, _ := json.Marshal(map[string]interface{}{"event_type": "node_share_created", "payload": payload})
+	s.wsHub.PublishEvent(claims.UserID, sharerEvent)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.GroupShareFrom(*share))
+}
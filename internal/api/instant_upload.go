@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var errContentGone = errors.New("the stored content was removed")
+
+type PrepareUploadRequest struct {
+	FileName string  `json:"file_name" example:"nagranie.mp4"`
+	ParentID *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// SizeBytes and SHA256 identify the content the client is about to upload.
+	SizeBytes int64  `json:"size_bytes" example:"5368709120"`
+	SHA256    string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+type PrepareUploadResponse struct {
+	// UploadRequired is true when the server does not have the content and
+	// the file has to be uploaded as usual.
+	UploadRequired bool `json:"upload_required" example:"false"`
+	// Node is the file created from the content already stored.
+	Node *dto.Node `json:"node,omitempty"`
+}
+
+// @Summary      Upload by checksum
+// @Description  Lets a client ask, before uploading, whether the server already has the content of a file. When the user already stores content with the given size and SHA-256 checksum (in any of their files, including the trash), the file is created at once from it, without transferring any bytes, and 201 is returned with the node. Otherwise the answer is 200 with upload_required and the client uploads the file as usual. Only the user's own content is considered, so a checksum can neither reveal nor obtain another user's files. The file's type is the one detected for the stored content, and the content type restrictions and content policy apply as to an upload.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      PrepareUploadRequest   true  "File to create"
+// @Success      200      {object}  PrepareUploadResponse  "The content has to be uploaded"
+// @Success      201      {object}  PrepareUploadResponse  "The file was created from stored content"
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Write permission denied or blocked by the content policy"
+// @Failure      404      {string}  string "Parent folder not found"
+// @Failure      409      {string}  string "Conflict - A node with the same name already exists"
+// @Failure      413      {object}  UploadLimitError "The file size limit or the storage quota is exceeded"
+// @Failure      415      {string}  string "Unsupported Media Type - The file type is not allowed"
+// @Failure      422      {string}  string "Unprocessable Entity - Folder limits exceeded"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/file/prepare [post]
+func (s *Server) PrepareUploadHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req PrepareUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.FileName = strings.TrimSpace(req.FileName)
+	if req.FileName == "" || len(req.FileName) > 255 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidFileNameLength)
+		return
+	}
+	if req.SizeBytes <= 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.NonPositiveSize)
+		return
+	}
+	if !s.checkFileSize(w, req.FileName, req.SizeBytes) {
+		return
+	}
+	contentSHA256, err := parseContentSHA256(req.SHA256)
+	if err != nil || contentSHA256 == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidSHA256)
+		return
+	}
+	if req.ParentID != nil && len(*req.ParentID) != 21 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+		return
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, req.ParentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return
+	}
+
+	ownerID := claims.UserID
+	if req.ParentID != nil {
+		parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *req.ParentID, claims.UserID)
+		if err != nil || parentFolder == nil {
+			s.writeNodeNotFound(w, r, *req.ParentID, i18n.ParentFolderNotAccessible)
+			return
+		}
+		ownerID = parentFolder.OwnerID
+	}
+
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, req.ParentID, 1, 1)) {
+		return
+	}
+	if !s.checkUploadQuota(w, r, ownerID, req.SizeBytes) {
+		return
+	}
+
+	uploadRequired := func() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PrepareUploadResponse{UploadRequired: true})
+	}
+	blob, storedType, err := s.store.FindOwnedContent(r.Context(), claims.UserID, contentSHA256, req.SizeBytes)
+	if err != nil {
+		log.Printf("ERROR: Failed to look up content %s for user %d: %v", contentSHA256, claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.StoredContentCheckFailed)
+		return
+	}
+	if blob == nil {
+		uploadRequired()
+		return
+	}
+	backend, err := s.blobs.Backend(blob.StorageBackend)
+	if err != nil {
+		log.Printf("ERROR: Content %s is in an unknown backend: %v", blob.StorageKey, err)
+		uploadRequired()
+		return
+	}
+
+	mimeType := "application/octet-stream"
+	if storedType != nil {
+		mimeType = *storedType
+	}
+	if err := s.checkContentType(req.FileName, mimeType); err != nil {
+		writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	described := contentpolicy.File{Name: req.FileName, MimeType: mimeType, SizeBytes: req.SizeBytes}
+	quarantine, err := s.evaluateUpload(r.Context(), described, func() (io.ReadCloser, error) { return backend.Get(blob.StorageKey) })
+	if err != nil {
+		var policyErr *contentPolicyError
+		if errors.As(err, &policyErr) {
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("ERROR: Content policy failed for content %s: %v", blob.StorageKey, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileCreateFailed)
+		return
+	}
+
+	nodeID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileCreateFailed)
+		return
+	}
+	sizeBytes := req.SizeBytes
+	var createdNode *models.Node
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		refs, err := q.AcquireContentBlob(r.Context(), blob.StorageBackend, blob.StorageKey, sizeBytes)
+		if err != nil {
+			return err
+		}
+		if refs == 1 {
+			// The last file referencing the content was purged meanwhile.
+			return errContentGone
+		}
+		createdNode, err = q.CreateNode(r.Context(), database.CreateNodeParams{
+			ID:             nodeID,
+			OwnerID:        ownerID,
+			ParentID:       req.ParentID,
+			Name:           req.FileName,
+			NodeType:       "file",
+			SizeBytes:      &sizeBytes,
+			MimeType:       &mimeType,
+			StorageBackend: blob.StorageBackend,
+			ContentSHA256:  &contentSHA256,
+			StorageKey:     &blob.StorageKey,
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.LogEvents(r.Context(), nodeCreatedEvents(claims.UserID, ownerID, createdNode)); err != nil {
+			return err
+		}
+		if err := q.UpdateUserStorage(r.Context(), ownerID, sizeBytes); err != nil {
+			return err
+		}
+		if quarantine != nil {
+			return q.QuarantineNode(r.Context(), nodeID, contentpolicy.ActionUpload, quarantine.Rule, quarantine.Reason)
+		}
+		return nil
+	})
+	if txErr != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(txErr, errContentGone):
+			uploadRequired()
+		case errors.As(txErr, &pgErr) && pgErr.Code == "23505":
+			writeError(w, r, http.StatusConflict, i18n.DuplicateNodeName)
+		default:
+			log.Printf("ERROR: Failed to create file from stored content %s: %v", blob.StorageKey, txErr)
+			writeError(w, r, http.StatusInternalServerError, i18n.FileCreateFailed)
+		}
+		return
+	}
+
+	var parentFolderOwnerID *int64
+	if req.ParentID != nil {
+		parentFolderOwnerID = &ownerID
+	}
+	createdNodes := []models.Node{*createdNode}
+	s.publishUploadedNodes(r.Context(), claims.UserID, parentFolderOwnerID, req.ParentID, createdNodes)
+	if quarantine != nil {
+		s.notifyQuarantine(r.Context(), createdNode, contentpolicy.ActionUpload, quarantine)
+	}
+	createdNodes = s.applyOrganizationRules(r.Context(), ownerID, createdNodes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PrepareUploadResponse{Node: dto.NodePtr(&createdNodes[0])})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatus reports the runs of a background job since the server started.
+type JobStatus struct {
+	Name            string     `json:"name" example:"version_retention"`
+	IntervalSeconds int64      `json:"interval_seconds" example:"3600"`
+	Running         bool       `json:"running" example:"false"`
+	Runs            int64      `json:"runs" example:"12"`
+	Failures        int64      `json:"failures" example:"1"`
+	LastStartedAt   *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt  *time.Time `json:"last_finished_at,omitempty"`
+	LastDurationMs  int64      `json:"last_duration_ms" example:"120"`
+	LastError       string     `json:"last_error,omitempty" example:"context deadline exceeded"`
+}
+
+// jobMonitor keeps the status of every background job. The zero value is
+// ready to use.
+type jobMonitor struct {
+	mu   sync.Mutex
+	jobs map[string]*JobStatus
+}
+
+func (m *jobMonitor) started(name string, interval time.Duration, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs == nil {
+		m.jobs = make(map[string]*JobStatus)
+	}
+	status, ok := m.jobs[name]
+	if !ok {
+		status = &JobStatus{Name: name}
+		m.jobs[name] = status
+	}
+	status.IntervalSeconds = int64(interval / time.Second)
+	status.Running = true
+	status.LastStartedAt = &now
+}
+
+func (m *jobMonitor) finished(name string, now time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.jobs[name]
+	if !ok {
+		return
+	}
+	status.Running = false
+	status.Runs++
+	status.LastFinishedAt = &now
+	status.LastDurationMs = now.Sub(*status.LastStartedAt).Milliseconds()
+	status.LastError = ""
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+	}
+}
+
+// snapshot returns a copy of the job statuses ordered by name.
+func (m *jobMonitor) snapshot() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(m.jobs))
+	for _, status := range m.jobs {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (s *Server) StartBackgroundJobs(ctx context.Context) {
+	go s.runPeriodically(ctx, "access_log_retention", time.Hour, s.pruneAccessLogs)
+	go s.runPeriodically(ctx, "upload_session_janitor", 15*time.Minute, s.cleanupUploadSessions)
+	go s.runPeriodically(ctx, "watch_digests", time.Hour, s.sendWatchDigests)
+	go s.runPeriodically(ctx, "favorites_cleanup", time.Hour, s.pruneInaccessibleFavorites)
+	go s.runPeriodically(ctx, "share_review", time.Hour, s.sendShareReviewReminders)
+	go s.runPeriodically(ctx, "rotated_token_cleanup", time.Hour, s.pruneRotatedRefreshTokens)
+	go s.runPeriodically(ctx, "email_token_cleanup", time.Hour, s.pruneEmailTokens)
+	go s.runPeriodically(ctx, "public_link_limits", linkPasswordWindow, s.prunePublicLinkLimits)
+	go s.runPeriodically(ctx, "version_retention", time.Hour, s.pruneNodeVersions)
+	go s.runPeriodically(ctx, "storage_reconciliation", time.Hour, s.reconcileStorageUsage)
+	go s.runPeriodically(ctx, "undo_token_cleanup", 15*time.Minute, s.pruneExpiredUndoTokens)
+	go s.runPeriodically(ctx, "folder_cleanup", time.Hour, s.runFolderCleanup)
+	go s.runPeriodically(ctx, "transcriptions", 15*time.Second, s.processTranscriptions)
+	go s.runPeriodically(ctx, "archive_imports", 5*time.Second, s.processArchiveImports)
+	go s.runPeriodically(ctx, "url_imports", 5*time.Second, s.processURLImports)
+	go s.runPeriodically(ctx, "folder_hooks", 10*time.Second, s.deliverFolderHooks)
+	go s.runPeriodically(ctx, "legal_exports", 10*time.Second, s.processLegalExports)
+	go s.runPeriodically(ctx, "blob_migrations", 10*time.Second, s.processBlobMigrations)
+	go s.runPeriodically(ctx, "announcements", time.Minute, s.publishDueAnnouncements)
+	go s.runPeriodically(ctx, "onboarding", 30*time.Second, s.onboardNewUsers)
+	if s.directory != nil {
+		go s.runPeriodically(ctx, "ldap_sync", s.ldapSyncInterval(), s.syncDirectoryAccounts)
+	}
+}
+
+func (s *Server) runPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.jobs.started(name, interval, time.Now())
+		err := job(ctx)
+		s.jobs.finished(name, time.Now(), err)
+		if err != nil {
+			log.Printf("ERROR: Background job %s failed: %v", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
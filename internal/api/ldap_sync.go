@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/ldap"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultLDAPSyncInterval = time.Hour
+	defaultLDAPTimeout      = 30 * time.Second
+)
+
+func ldapDirectoryConfig(cfg config.LDAPConfig) ldap.Config {
+	directory := ldap.Config{
+		URL:                  cfg.URL,
+		BindDN:               cfg.BindDN,
+		BindPassword:         cfg.BindPassword,
+		BaseDN:               cfg.BaseDN,
+		UserFilter:           cfg.UserFilter,
+		UsernameAttribute:    cfg.UsernameAttribute,
+		DisplayNameAttribute: cfg.DisplayNameAttribute,
+		GroupFilter:          cfg.GroupFilter,
+		GroupNameAttribute:   cfg.GroupNameAttribute,
+		GroupMemberAttribute: cfg.GroupMemberAttribute,
+		Timeout:              defaultLDAPTimeout,
+	}
+	if directory.UserFilter == "" {
+		directory.UserFilter = "(objectClass=inetOrgPerson)"
+	}
+	if directory.UsernameAttribute == "" {
+		directory.UsernameAttribute = "uid"
+	}
+	if directory.DisplayNameAttribute == "" {
+		directory.DisplayNameAttribute = "displayName"
+	}
+	if directory.GroupNameAttribute == "" {
+		directory.GroupNameAttribute = "cn"
+	}
+	if directory.GroupMemberAttribute == "" {
+		directory.GroupMemberAttribute = "member"
+	}
+	if cfg.TimeoutSeconds > 0 {
+		directory.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	return directory
+}
+
+func (s *Server) ldapSyncInterval() time.Duration {
+	if s.config.Load().LDAP.SyncIntervalMinutes > 0 {
+		return time.Duration(s.config.Load().LDAP.SyncIntervalMinutes) * time.Minute
+	}
+	return defaultLDAPSyncInterval
+}
+
+// syncDirectoryAccounts creates an account for every directory entry matching
+// the user filter, applies renamed usernames and display names, and disables
+// synchronized accounts whose entries are gone, ending their sessions.
+// Accounts are matched by DN. An entry whose username is taken by a local
+// account is skipped rather than taking that account over. Groups are
+// synchronized afterwards with syncDirectoryGroups.
+func (s *Server) syncDirectoryAccounts(ctx context.Context) error {
+	users, err := s.directory.Users(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		// An empty result is far more likely a wrong base DN or filter than
+		// an empty directory; disabling every account would lock everyone out.
+		log.Printf("WARN: LDAP search returned no accounts, skipping synchronization")
+		return nil
+	}
+
+	accounts, err := s.store.ListDirectoryAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	byDN := make(map[string]database.DirectoryAccount, len(accounts))
+	for _, account := range accounts {
+		byDN[strings.ToLower(account.LDAPDN)] = account
+	}
+
+	present := make(map[int64]bool, len(users))
+	for _, user := range users {
+		var displayName *string
+		if user.DisplayName != "" {
+			displayName = &user.DisplayName
+		}
+
+		account, ok := byDN[strings.ToLower(user.DN)]
+		if !ok {
+			created, err := s.store.CreateDirectoryAccount(ctx, user.Username, displayName, user.DN)
+			if err != nil {
+				return err
+			}
+			if !created {
+				log.Printf("WARN: Skipping LDAP entry %s: username %s is already taken", user.DN, user.Username)
+			}
+			continue
+		}
+
+		present[account.ID] = true
+		if account.Username == user.Username && equalStringPtr(account.DisplayName, displayName) && account.DisabledAt == nil {
+			continue
+		}
+		if err := s.store.UpdateDirectoryAccount(ctx, account.ID, user.Username, displayName); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				log.Printf("WARN: Cannot rename user %d to %s from LDAP: the username is already taken", account.ID, user.Username)
+				continue
+			}
+			return err
+		}
+	}
+
+	for _, account := range accounts {
+		if present[account.ID] || account.DisabledAt != nil {
+			continue
+		}
+		err := s.store.ExecTx(ctx, func(q *database.Queries) error {
+			if _, err := q.DisableUser(ctx, account.ID); err != nil {
+				return err
+			}
+			return q.DeleteAllSessionsForUser(ctx, account.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.syncDirectoryGroups(ctx)
+}
+
+// syncDirectoryGroups creates a group for every directory entry matching the
+// group filter, applies renames, makes the synchronized accounts it lists its
+// only members, and deletes the groups whose entries are gone. Groups are
+// matched by DN, members by DN or, for attributes such as memberUid, by
+// username. Added and removed members are notified like in other groups.
+func (s *Server) syncDirectoryGroups(ctx context.Context) error {
+	entries, err := s.directory.Groups(ctx)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		// Either groups are not synchronized or, as with accounts, the base
+		// DN or filter is wrong; deleting every group would revoke its shares.
+		return nil
+	}
+
+	accounts, err := s.store.ListDirectoryAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	accountIDs := make(map[string]int64, 2*len(accounts))
+	for _, account := range accounts {
+		accountIDs[strings.ToLower(account.LDAPDN)] = account.ID
+		accountIDs[account.Username] = account.ID
+	}
+
+	groups, err := s.store.ListDirectoryGroups(ctx)
+	if err != nil {
+		return err
+	}
+	byDN := make(map[string]database.DirectoryGroup, len(groups))
+	for _, group := range groups {
+		byDN[strings.ToLower(group.LDAPDN)] = group
+	}
+
+	present := make(map[int64]bool, len(entries))
+	for _, entry := range entries {
+		name := entry.Name
+		if utf8.RuneCountInString(name) > maxGroupNameLength {
+			name = string([]rune(name)[:maxGroupNameLength])
+		}
+		group, ok := byDN[strings.ToLower(entry.DN)]
+		if !ok {
+			if group.ID, err = s.store.CreateDirectoryGroup(ctx, name, entry.DN); err != nil {
+				return err
+			}
+		} else if group.Name != name {
+			if err := s.store.RenameDirectoryGroup(ctx, group.ID, name); err != nil {
+				return err
+			}
+		}
+		present[group.ID] = true
+
+		memberIDs := []int64{}
+		for _, member := range entry.Members {
+			if id, ok := accountIDs[strings.ToLower(strings.TrimSpace(member))]; ok {
+				memberIDs = append(memberIDs, id)
+			}
+		}
+		added, removed, err := s.store.SetGroupMembers(ctx, group.ID, memberIDs)
+		if err != nil {
+			return err
+		}
+		payload := map[string]interface{}{"group_id": group.ID, "group_name": name}
+		s.publishGroupEvent(ctx, added, "group_member_added", payload)
+		s.publishGroupEvent(ctx, removed, "group_member_removed", payload)
+	}
+
+	for _, group := range groups {
+		if present[group.ID] {
+			continue
+		}
+		members, err := s.store.DeleteDirectoryGroup(ctx, group.ID)
+		if err != nil {
+			return err
+		}
+		s.publishGroupEvent(ctx, members, "group_member_removed", map[string]interface{}{"group_id": group.ID, "group_name": group.Name})
+	}
+	return nil
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,496 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// legalExportStaleAfter is how long an export may stay running before
+	// another worker claims it again.
+	legalExportStaleAfter = time.Hour
+	legalExportEventPage  = 1000
+	// legalExportManifestName is the last entry of every bundle.
+	legalExportManifestName    = "manifest.json"
+	legalExportManifestVersion = 1
+)
+
+var errLegalExportRootGone = errors.New("the exported node no longer exists")
+
+type LegalExportRequest struct {
+	NodeID string `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// Reason is kept with the export and written into its manifest.
+	Reason string `json:"reason" example:"Sprawa sądowa 123/2025"`
+}
+
+// LegalExportManifest is the last entry of a bundle. It lists every other
+// entry with its SHA-256, so the bundle can be checked entry by entry after
+// the bundle's own digest has been verified.
+type LegalExportManifest struct {
+	Version     int                        `json:"version" example:"1"`
+	ExportID    uuid.UUID                  `json:"export_id"`
+	NodeID      string                     `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID     *int64                     `json:"owner_id" example:"2"`
+	RequestedBy *int64                     `json:"requested_by" example:"1"`
+	Reason      string                     `json:"reason" example:"Sprawa sądowa 123/2025"`
+	CreatedAt   time.Time                  `json:"created_at"`
+	Entries     []LegalExportManifestEntry `json:"entries"`
+}
+
+type LegalExportManifestEntry struct {
+	Path   string  `json:"path" example:"content/V1StGXR8_Z5jdHi6B-myT/v2"`
+	NodeID *string `json:"node_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// Version is set for archived versions; the current content has none.
+	Version   *int   `json:"version,omitempty" example:"2"`
+	SizeBytes int64  `json:"size_bytes" example:"1024"`
+	SHA256    string `json:"sha256"`
+}
+
+// LegalExportNode is a node as listed in a bundle's nodes.json, with its path
+// from the exported node.
+type LegalExportNode struct {
+	models.Node
+	Path string `json:"path" example:"Projekty/Umowy/umowa.pdf"`
+}
+
+// legalExportWriter writes the entries of a bundle, recording each of them in
+// the manifest.
+type legalExportWriter struct {
+	tw       *tar.Writer
+	modTime  time.Time
+	manifest LegalExportManifest
+}
+
+func (lw *legalExportWriter) writeEntry(name string, size int64, content io.Reader, digest hash.Hash) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o444,
+		ModTime:  lw.modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := lw.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	// A blob shorter than its recorded size is caught by the next WriteHeader
+	// or Close; a longer one fails the copy.
+	_, err := io.Copy(io.MultiWriter(lw.tw, digest), content)
+	return err
+}
+
+func (lw *legalExportWriter) add(name string, size int64, content io.Reader, nodeID *string, version *int) error {
+	digest := sha256.New()
+	if err := lw.writeEntry(name, size, content, digest); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	lw.manifest.Entries = append(lw.manifest.Entries, LegalExportManifestEntry{
+		Path:      name,
+		NodeID:    nodeID,
+		Version:   version,
+		SizeBytes: size,
+		SHA256:    hex.EncodeToString(digest.Sum(nil)),
+	})
+	return nil
+}
+
+func (lw *legalExportWriter) addJSON(name string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return lw.add(name, int64(len(data)), bytes.NewReader(data), nil, nil)
+}
+
+// finish writes the manifest, which does not list itself, and closes the tar
+// stream.
+func (lw *legalExportWriter) finish() error {
+	data, err := json.MarshalIndent(lw.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := lw.writeEntry(legalExportManifestName, int64(len(data)), bytes.NewReader(data), sha256.New()); err != nil {
+		return err
+	}
+	return lw.tw.Close()
+}
+
+// addNodeContent writes the current content of a file and all its archived
+// versions.
+func (s *Server) addNodeContent(ctx context.Context, lw *legalExportWriter, node models.Node) error {
+	var size int64
+	if node.SizeBytes != nil {
+		size = *node.SizeBytes
+	}
+	content, err := s.openNodeContent(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	err = lw.add("content/"+node.ID+"/current", size, content, &node.ID, nil)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	versions, err := s.store.ListNodeVersions(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		content, err := s.storage.Get(version.StorageKey)
+		if err != nil {
+			return fmt.Errorf("version %d: %w", version.Version, err)
+		}
+		err = lw.add("content/"+node.ID+"/v"+strconv.Itoa(version.Version), version.SizeBytes, content, &node.ID, &version.Version)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legalExportEvents returns all of the owner's events that concern the
+// exported subtree, newest first.
+func (s *Server) legalExportEvents(ctx context.Context, export *database.LegalExport) ([]database.Event, error) {
+	events := []database.Event{}
+	if export.OwnerID == nil {
+		return events, nil
+	}
+	var beforeID int64
+	for {
+		page, err := s.store.ListSubtreeEvents(ctx, *export.OwnerID, export.NodeID, nil, beforeID, legalExportEventPage)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if len(page) < legalExportEventPage {
+			return events, nil
+		}
+		beforeID = page[len(page)-1].ID
+	}
+}
+
+// writeLegalExportBundle writes the tar bundle of an export to out and
+// returns the number of entries listed in its manifest. Trashed nodes are
+// included, as a hold must cover what the owner has already deleted.
+func (s *Server) writeLegalExportBundle(ctx context.Context, export *database.LegalExport, out io.Writer) (int, error) {
+	nodes, err := s.store.ListSubtreeNodesForExport(ctx, export.NodeID)
+	if err != nil {
+		return 0, err
+	}
+	if len(nodes) == 0 {
+		return 0, errLegalExportRootGone
+	}
+
+	lw := &legalExportWriter{
+		tw:      tar.NewWriter(out),
+		modTime: time.Now().UTC(),
+		manifest: LegalExportManifest{
+			Version:     legalExportManifestVersion,
+			ExportID:    export.ID,
+			NodeID:      export.NodeID,
+			OwnerID:     export.OwnerID,
+			RequestedBy: export.RequestedBy,
+			Reason:      export.Reason,
+			CreatedAt:   time.Now().UTC(),
+			Entries:     []LegalExportManifestEntry{},
+		},
+	}
+
+	paths := make(map[string]string, len(nodes))
+	listed := make([]LegalExportNode, 0, len(nodes))
+	nodeIDs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodePath := node.Name
+		if node.ParentID != nil && node.ID != export.NodeID {
+			nodePath = paths[*node.ParentID] + "/" + node.Name
+		}
+		paths[node.ID] = nodePath
+		listed = append(listed, LegalExportNode{Node: node, Path: nodePath})
+		nodeIDs = append(nodeIDs, node.ID)
+
+		if node.NodeType != "file" {
+			continue
+		}
+		if err := s.addNodeContent(ctx, lw, node); err != nil {
+			return 0, fmt.Errorf("content of node %s: %w", node.ID, err)
+		}
+	}
+
+	accessLog, err := s.store.ListAccessLogForNodes(ctx, nodeIDs)
+	if err != nil {
+		return 0, err
+	}
+	events, err := s.legalExportEvents(ctx, export)
+	if err != nil {
+		return 0, err
+	}
+	if err := lw.addJSON("nodes.json", listed); err != nil {
+		return 0, err
+	}
+	if err := lw.addJSON("audit/access_log.json", accessLog); err != nil {
+		return 0, err
+	}
+	if err := lw.addJSON("audit/events.json", events); err != nil {
+		return 0, err
+	}
+	if err := lw.finish(); err != nil {
+		return 0, err
+	}
+	return len(lw.manifest.Entries), nil
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// runLegalExport streams the bundle of an export into storage, hashing it on
+// the way, and records the result.
+func (s *Server) runLegalExport(ctx context.Context, export *database.LegalExport) {
+	fail := func(err error) {
+		log.Printf("ERROR: Legal export %s of node %s failed: %v", export.ID, export.NodeID, err)
+		if err := s.store.FailLegalExport(ctx, export.ID, err.Error()); err != nil {
+			log.Printf("ERROR: Failed to record failure of legal export %s: %v", export.ID, err)
+		}
+	}
+
+	key, err := s.generateUniqueID(ctx)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	reader, writer := io.Pipe()
+	entries := make(chan int, 1)
+	go func() {
+		n, err := s.writeLegalExportBundle(ctx, export, writer)
+		entries <- n
+		writer.CloseWithError(err)
+	}()
+
+	digest := sha256.New()
+	var size byteCounter
+	err = s.storage.Save(key, io.TeeReader(reader, io.MultiWriter(digest, &size)))
+	// Unblocks the writer when saving stopped early.
+	reader.CloseWithError(err)
+	count := <-entries
+	if err != nil {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("WARN: Failed to remove partial legal export bundle %s: %v", key, err)
+		}
+		fail(err)
+		return
+	}
+
+	completed, err := s.store.CompleteLegalExport(ctx, export.ID, key, int64(size), hex.EncodeToString(digest.Sum(nil)), count)
+	if err != nil || completed == nil {
+		// Another worker finished it first, or the result could not be
+		// recorded; either way this bundle is not referenced.
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("WARN: Failed to remove unused legal export bundle %s: %v", key, err)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+}
+
+// processLegalExports works through the legal export queue until it is empty.
+func (s *Server) processLegalExports(ctx context.Context) error {
+	for ctx.Err() == nil {
+		export, err := s.store.ClaimLegalExport(ctx, legalExportStaleAfter)
+		if err != nil {
+			return err
+		}
+		if export == nil {
+			return nil
+		}
+		s.runLegalExport(ctx, export)
+	}
+	return nil
+}
+
+// loadLegalExport fetches the export named in the URL, writing the error
+// response and returning nil when there is none.
+func (s *Server) loadLegalExport(w http.ResponseWriter, r *http.Request) *database.LegalExport {
+	exportID, err := uuid.Parse(chi.URLParam(r, "exportId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidExportID)
+		return nil
+	}
+	export, err := s.store.GetLegalExport(r.Context(), exportID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LegalExportLookupFailed)
+		return nil
+	}
+	if export == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LegalExportNotFound)
+		return nil
+	}
+	return export
+}
+
+// @Summary      Request a legal hold export
+// @Description  Queues an export of a node and its whole subtree, trashed nodes included, for a litigation hold. A background job writes a tar bundle with the current content and every archived version of each file ("content/<id>/current", "content/<id>/v<N>"), the node metadata with paths ("nodes.json"), the access log and the owner's events for the subtree ("audit/access_log.json", "audit/events.json") and finally "manifest.json" with the SHA-256 of every other entry. The digest of the whole bundle is recorded with the export. Completed exports cannot be changed or removed through the API. The request is recorded in the node's access log.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      LegalExportRequest  true  "Node to export and the reason"
+// @Success      202      {object}  dto.LegalExport
+// @Failure      400      {string}  string "Bad Request - Missing node ID or reason"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "Node not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/legal-exports [post]
+func (s *Server) CreateLegalExportHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req LegalExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.NodeID == "" || req.Reason == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.NodeAndReasonRequired)
+		return
+	}
+
+	export, err := s.store.CreateLegalExport(r.Context(), claims.UserID, req.NodeID, req.Reason)
+	if err != nil {
+		log.Printf("ERROR: Failed to queue legal export of node %s: %v", req.NodeID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.LegalExportQueueFailed)
+		return
+	}
+	if export == nil {
+		writeError(w, r, http.StatusNotFound, i18n.NodeNotFound)
+		return
+	}
+	if export.OwnerID != nil {
+		s.recordAccess(r, claims.UserID, export.NodeID, *export.OwnerID, "legal_export")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.LegalExportFrom(*export))
+}
+
+// @Summary      List legal hold exports
+// @Description  Lists legal hold exports, newest first.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.LegalExport
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /admin/legal-exports [get]
+func (s *Server) ListLegalExportsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parsePagination(r)
+
+	exports, err := s.store.ListLegalExports(r.Context(), limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list legal exports: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.LegalExportsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.LegalExports(exports))
+}
+
+// @Summary      Get a legal hold export
+// @Description  Returns the status of a legal hold export and, once completed, the size, entry count and SHA-256 of its bundle.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        exportId  path      string  true  "Export ID"
+// @Success      200       {object}  dto.LegalExport
+// @Failure      400       {string}  string "Bad Request - Invalid export ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      403       {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404       {string}  string "Legal export not found"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /admin/legal-exports/{exportId} [get]
+func (s *Server) GetLegalExportHandler(w http.ResponseWriter, r *http.Request) {
+	export := s.loadLegalExport(w, r)
+	if export == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.LegalExportFrom(*export))
+}
+
+// @Summary      Download a legal hold export
+// @Description  Downloads the tar bundle of a completed legal hold export. Its recorded SHA-256 is sent in the X-Bundle-SHA256 header.
+// @Tags         admin
+// @Produce      application/x-tar
+// @Security     BearerAuth
+// @Param        exportId  path      string  true  "Export ID"
+// @Success      200       {file}    file
+// @Failure      400       {string}  string "Bad Request - Invalid export ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      403       {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404       {string}  string "Legal export not found"
+// @Failure      409       {string}  string "Conflict - The export is not completed"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /admin/legal-exports/{exportId}/download [get]
+func (s *Server) DownloadLegalExportHandler(w http.ResponseWriter, r *http.Request) {
+	export := s.loadLegalExport(w, r)
+	if export == nil {
+		return
+	}
+	if export.Status != database.LegalExportCompleted || export.StorageKey == nil {
+		writeError(w, r, http.StatusConflict, i18n.ExportNotCompleted)
+		return
+	}
+
+	bundle, err := s.storage.Get(*export.StorageKey)
+	if err != nil {
+		log.Printf("CRITICAL: Bundle of legal export %s is missing from storage: %v", export.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ExportBundleOpenFailed)
+		return
+	}
+	defer bundle.Close()
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="legal-export-`+export.ID.String()+`.tar"`)
+	if export.SizeBytes != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*export.SizeBytes, 10))
+	}
+	if export.SHA256 != nil {
+		w.Header().Set("X-Bundle-SHA256", *export.SHA256)
+	}
+	if _, err := io.Copy(w, bundle); err != nil {
+		log.Printf("ERROR: Failed to stream legal export %s: %v", export.ID, err)
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+)
+
+const (
+	defaultMaxFolderDepth       = 64
+	defaultMaxChildrenPerFolder = 100_000
+	defaultMaxArchiveEntries    = 100_000
+	defaultMaxArchiveSizeMB     = 10 << 10
+	defaultMaxExtractEntries    = 50_000
+	defaultMaxExtractRatio      = 100
+	// minExtractLimitBytes is how much any archive may expand to regardless
+	// of the ratio, so small archives of well compressible text are not
+	// mistaken for zip bombs.
+	minExtractLimitBytes = 64 << 20
+)
+
+var (
+	errFolderDepthExceeded    = errors.New("folder depth limit exceeded")
+	errFolderChildrenExceeded = errors.New("folder children limit exceeded")
+)
+
+// placementError is a folder limit a placement would exceed. It matches
+// errFolderDepthExceeded or errFolderChildrenExceeded with errors.Is.
+type placementError struct {
+	limit error
+	code  string
+	max   int64
+}
+
+func (e *placementError) Error() string {
+	return i18n.Messagef(e.code, i18n.English, e.max)
+}
+
+func (e *placementError) errorCode() (string, []any) {
+	return e.code, []any{e.max}
+}
+
+func (e *placementError) Unwrap() error {
+	return e.limit
+}
+
+func (s *Server) maxFolderDepth() int {
+	if s.config.Load().Limits.MaxFolderDepth > 0 {
+		return s.config.Load().Limits.MaxFolderDepth
+	}
+	return defaultMaxFolderDepth
+}
+
+func (s *Server) maxChildrenPerFolder() int64 {
+	if s.config.Load().Limits.MaxChildrenPerFolder > 0 {
+		return s.config.Load().Limits.MaxChildrenPerFolder
+	}
+	return defaultMaxChildrenPerFolder
+}
+
+func (s *Server) maxArchiveEntries() int64 {
+	if s.config.Load().Limits.MaxArchiveEntries > 0 {
+		return s.config.Load().Limits.MaxArchiveEntries
+	}
+	return defaultMaxArchiveEntries
+}
+
+func (s *Server) maxArchiveBytes() int64 {
+	if s.config.Load().Limits.MaxArchiveSizeMB > 0 {
+		return s.config.Load().Limits.MaxArchiveSizeMB << 20
+	}
+	return defaultMaxArchiveSizeMB << 20
+}
+
+func (s *Server) maxExtractEntries() int {
+	if s.config.Load().Limits.MaxExtractEntries > 0 {
+		return s.config.Load().Limits.MaxExtractEntries
+	}
+	return defaultMaxExtractEntries
+}
+
+// maxExtractedBytes is how much an archive of the given size may expand to
+// when unpacked on the server.
+func (s *Server) maxExtractedBytes(archiveSize int64) int64 {
+	ratio := int64(defaultMaxExtractRatio)
+	if s.config.Load().Limits.MaxExtractRatio > 0 {
+		ratio = int64(s.config.Load().Limits.MaxExtractRatio)
+	}
+	return max(archiveSize*ratio, minExtractLimitBytes)
+}
+
+// checkPlacementLimits verifies that adding newItems nodes to parentID (the
+// owner's root when nil), the tallest of them height levels deep, keeps the
+// tree within the configured depth and children limits.
+func (s *Server) checkPlacementLimits(ctx context.Context, ownerID int64, parentID *string, newItems int, height int) error {
+	parentDepth := 0
+	if parentID != nil {
+		var err error
+		parentDepth, err = s.store.GetNodeDepth(ctx, *parentID)
+		if err != nil {
+			return err
+		}
+	}
+	if maxDepth := s.maxFolderDepth(); parentDepth+height > maxDepth {
+		return &placementError{errFolderDepthExceeded, i18n.FolderDepthExceeded, int64(maxDepth)}
+	}
+
+	children, err := s.store.CountChildren(ctx, ownerID, parentID)
+	if err != nil {
+		return err
+	}
+	if maxChildren := s.maxChildrenPerFolder(); children+int64(newItems) > maxChildren {
+		return &placementError{errFolderChildrenExceeded, i18n.FolderChildrenExceeded, maxChildren}
+	}
+	return nil
+}
+
+// writePlacementLimitError reports a limit violation as 422 and anything else
+// as an internal error. It returns false when err is nil.
+func writePlacementLimitError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errFolderDepthExceeded), errors.Is(err, errFolderChildrenExceeded):
+		writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+	default:
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderLimitsCheckFailed)
+	}
+	return true
+}
+
+type CapabilitiesResponse struct {
+	MaxFolderDepth        int   `json:"max_folder_depth" example:"64"`
+	MaxChildrenPerFolder  int64 `json:"max_children_per_folder" example:"100000"`
+	MaxUploadRequestBytes int64 `json:"max_upload_request_bytes" example:"1073741824"`
+	// MaxUploadFileBytes and MaxFilesPerRequest are 0 when not limited.
+	MaxUploadFileBytes    int64 `json:"max_upload_file_bytes" example:"0"`
+	MaxFilesPerRequest    int   `json:"max_files_per_request" example:"0"`
+	MaxShareMessageLength int   `json:"max_share_message_length" example:"1000"`
+}
+
+// @Summary      Get server capabilities
+// @Description  Returns the limits enforced by this server so clients can validate operations up front.
+// @Tags         capabilities
+// @Produce      json
+// @Success      200  {object}  CapabilitiesResponse
+// @Router       /capabilities [get]
+func (s *Server) GetCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	resp := CapabilitiesResponse{
+		MaxFolderDepth:        s.maxFolderDepth(),
+		MaxChildrenPerFolder:  s.maxChildrenPerFolder(),
+		MaxUploadRequestBytes: s.maxRequestBytes(),
+		MaxUploadFileBytes:    s.maxFileBytes(),
+		MaxFilesPerRequest:    s.maxFilesPerRequest(),
+		MaxShareMessageLength: maxShareMessageLength,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// linkPasswordWindow is how long failed link password attempts are
+	// counted, and how long a link or a client stays locked out once they
+	// reach the limit.
+	linkPasswordWindow = 15 * time.Minute
+	// maxLinkPasswordFailuresPerLink and maxLinkPasswordFailuresPerIP bound
+	// the wrong passwords accepted for one link, from any client, and from
+	// one client, for any link, within the window.
+	maxLinkPasswordFailuresPerLink = 10
+	maxLinkPasswordFailuresPerIP   = 20
+)
+
+// windowCount counts events from the first one in a fixed window.
+type windowCount struct {
+	count int
+	since time.Time
+}
+
+// countInWindow counts an event for key and returns the events counted in its
+// current window, starting a new window when the previous one is over.
+func countInWindow[K comparable](counts map[K]*windowCount, key K, now time.Time, window time.Duration) int {
+	c := counts[key]
+	if c == nil || now.Sub(c.since) >= window {
+		counts[key] = &windowCount{count: 1, since: now}
+		return 1
+	}
+	c.count++
+	return c.count
+}
+
+// pruneWindows forgets the keys whose window is over.
+func pruneWindows[K comparable](counts map[K]*windowCount, now time.Time, window time.Duration) {
+	for key, c := range counts {
+		if now.Sub(c.since) >= window {
+			delete(counts, key)
+		}
+	}
+}
+
+// linkPasswordAttempts counts wrong public link passwords per link and per
+// client address, so link passwords cannot be guessed online. They are kept
+// apart from the downloads of the link. The zero value is ready to use.
+type linkPasswordAttempts struct {
+	mu     sync.Mutex
+	byLink map[int64]*windowCount
+	byIP   map[string]*windowCount
+}
+
+// lockedFor returns how long password attempts on the link from the client
+// are refused, or 0 when they are allowed.
+func (a *linkPasswordAttempts) lockedFor(linkID int64, clientIP string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var wait time.Duration
+	if c := a.byLink[linkID]; c != nil && c.count >= maxLinkPasswordFailuresPerLink {
+		wait = c.since.Add(linkPasswordWindow).Sub(now)
+	}
+	if c := a.byIP[clientIP]; c != nil && c.count >= maxLinkPasswordFailuresPerIP {
+		wait = max(wait, c.since.Add(linkPasswordWindow).Sub(now))
+	}
+	return max(wait, 0)
+}
+
+// fail records a wrong password for the link from the client.
+func (a *linkPasswordAttempts) fail(linkID int64, clientIP string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.byLink == nil {
+		a.byLink = make(map[int64]*windowCount)
+		a.byIP = make(map[string]*windowCount)
+	}
+	countInWindow(a.byLink, linkID, now, linkPasswordWindow)
+	countInWindow(a.byIP, clientIP, now, linkPasswordWindow)
+}
+
+// prune forgets failures whose window is over.
+func (a *linkPasswordAttempts) prune(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pruneWindows(a.byLink, now, linkPasswordWindow)
+	pruneWindows(a.byIP, now, linkPasswordWindow)
+}
+
+// prunePublicLinkLimits forgets the expired counters of the limits on public
+// link passwords and previews.
+func (s *Server) prunePublicLinkLimits(ctx context.Context) error {
+	now := time.Now()
+	s.linkPasswords.prune(now)
+	s.linkPreviews.prune(now)
+	return nil
+}
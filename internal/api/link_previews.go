@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// linkPreviewWindow is the period over which previews are counted per
+	// client address.
+	linkPreviewWindow        = time.Minute
+	defaultPreviewsPerMinute = 60
+	// linkPreviewThumbnailSize is the size of the image shown in previews.
+	linkPreviewThumbnailSize = 256
+	// linkPreviewCacheAge is how long, in seconds, unfurlers may keep a preview.
+	linkPreviewCacheAge = 3600
+	linkPreviewProvider = "File Server"
+)
+
+// linkPreviewLimiter counts the link previews served to each client address,
+// so unfurling cannot be used to probe link tokens or load the server with
+// thumbnails. The zero value is ready to use.
+type linkPreviewLimiter struct {
+	mu   sync.Mutex
+	byIP map[string]*windowCount
+}
+
+// allow counts a preview for the client and returns how long it has to wait
+// when it already got limit previews in the current window, or 0.
+func (l *linkPreviewLimiter) allow(clientIP string, limit int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byIP == nil {
+		l.byIP = make(map[string]*windowCount)
+	}
+	if countInWindow(l.byIP, clientIP, now, linkPreviewWindow) <= limit {
+		return 0
+	}
+	return max(l.byIP[clientIP].since.Add(linkPreviewWindow).Sub(now), 0)
+}
+
+// prune forgets addresses whose window is over.
+func (l *linkPreviewLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pruneWindows(l.byIP, now, linkPreviewWindow)
+}
+
+// linkPreview is what an unfurled public link shows. A password-protected
+// link shows nothing of the node behind it, and only images that are not
+// quarantined get a thumbnail.
+type linkPreview struct {
+	Title       string
+	Description string
+	URL         string
+	OEmbedURL   string
+	ImageURL    string
+}
+
+// OEmbedResponse describes a public link for oEmbed consumers.
+type OEmbedResponse struct {
+	Version         string `json:"version" example:"1.0"`
+	Type            string `json:"type" example:"link"`
+	Title           string `json:"title" example:"Raport_Q3.pdf"`
+	ProviderName    string `json:"provider_name" example:"File Server"`
+	CacheAge        int    `json:"cache_age" example:"3600"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty" example:"https://pliki.example.com/api/v1/public/abc/thumbnail"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty" example:"256"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty" example:"256"`
+}
+
+var linkPreviewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="` + linkPreviewProvider + `">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{- if .ImageURL}}
+<meta property="og:image" content="{{.ImageURL}}">
+<meta name="twitter:card" content="summary">
+{{- end}}
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+</head>
+<body>
+<p><a href="{{.URL}}">{{.Title}}</a></p>
+<p>{{.Description}}</p>
+</body>
+</html>
+`))
+
+// publicLinkBaseURL returns the address public links are served under,
+// without a trailing slash.
+func (s *Server) publicLinkBaseURL(r *http.Request) string {
+	if base := s.config.Load().PublicLinks.BaseURL; base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/api/v1/public"
+}
+
+// publicLinkURL returns the address of the link, by its slug when it has one.
+func (s *Server) publicLinkURL(r *http.Request, link *database.PublicLink) string {
+	name := link.Token
+	if link.Slug != nil {
+		name = *link.Slug
+	}
+	return s.publicLinkBaseURL(r) + "/" + name
+}
+
+// loadPreviewedLink returns the link being previewed and the node behind it,
+// or writes the error response and returns nil. Previews are rate-limited
+// per client address and never count as downloads.
+func (s *Server) loadPreviewedLink(w http.ResponseWriter, r *http.Request) (*database.PublicLink, *models.Node) {
+	limit := s.config.Load().PublicLinks.PreviewsPerMinute
+	if limit <= 0 {
+		limit = defaultPreviewsPerMinute
+	}
+	if wait := s.linkPreviews.allow(clientIPFromRequest(r), limit, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeError(w, r, http.StatusTooManyRequests, i18n.TooManyLinkPreviews)
+		return nil, nil
+	}
+
+	link, err := s.store.GetPublicLinkByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkLookupFailed)
+		return nil, nil
+	}
+	if link == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return nil, nil
+	}
+	if link.Expired(time.Now()) {
+		writeError(w, r, http.StatusGone, i18n.LinkExpired)
+		return nil, nil
+	}
+	if link.Exhausted() {
+		writeError(w, r, http.StatusGone, i18n.LinkDownloadLimitReached)
+		return nil, nil
+	}
+	node, err := s.store.GetNodeByID(r.Context(), link.NodeID, link.OwnerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return nil, nil
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return nil, nil
+	}
+	return link, node
+}
+
+// previewThumbnailAllowed reports whether the preview of the link may show a
+// thumbnail of the node.
+func (s *Server) previewThumbnailAllowed(r *http.Request, link *database.PublicLink, node *models.Node) (bool, error) {
+	if link.PasswordHash != nil || link.LinkType != database.PublicLinkDownload || node.NodeType != "file" {
+		return false, nil
+	}
+	if node.MimeType == nil || !thumbnailTypes[mediaTypeOf(*node.MimeType)] {
+		return false, nil
+	}
+	quarantined, err := s.isQuarantined(r.Context(), node.ID)
+	return !quarantined, err
+}
+
+// describeLink builds the preview of the link.
+func (s *Server) describeLink(r *http.Request, link *database.PublicLink, node *models.Node) (linkPreview, error) {
+	linkURL := s.publicLinkURL(r, link)
+	preview := linkPreview{URL: linkURL, OEmbedURL: linkURL + "/oembed"}
+	switch {
+	case link.PasswordHash != nil:
+		preview.Title = "Password-protected link"
+		preview.Description = "Open the link and enter its password to see what is shared."
+		return preview, nil
+	case link.LinkType == database.PublicLinkUpload:
+		preview.Title = node.Name
+		preview.Description = "Upload files to this folder"
+		return preview, nil
+	case node.NodeType == "folder":
+		preview.Title = node.Name
+		preview.Description = "Shared folder"
+		return preview, nil
+	}
+
+	preview.Title = node.Name
+	details := []string{}
+	if node.SizeBytes != nil {
+		details = append(details, formatByteSize(*node.SizeBytes))
+	}
+	if node.MimeType != nil {
+		details = append(details, mediaTypeOf(*node.MimeType))
+	}
+	preview.Description = "Shared file"
+	if len(details) > 0 {
+		preview.Description += ", " + strings.Join(details, ", ")
+	}
+	thumbnail, err := s.previewThumbnailAllowed(r, link, node)
+	if err != nil {
+		return linkPreview{}, err
+	}
+	if thumbnail {
+		preview.ImageURL = linkURL + "/thumbnail"
+	}
+	return preview, nil
+}
+
+// formatByteSize writes a size in bytes the way people read it, e.g. "1.5 MB".
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGTP"[exp])
+}
+
+// @Summary      Preview a public link
+// @Description  Needs no account. Returns an HTML page with OpenGraph tags and an oEmbed link, so chat apps can unfurl the link. It shows the name, size and type of a linked file, a thumbnail of a linked image, and the name of a linked folder; a password-protected link shows none of them. Previews never count as downloads, and are limited per address (60 a minute by default).
+// @Tags         links
+// @Produce      html
+// @Param        token  path      string  true  "Link token"
+// @Success      200    {string}  string "HTML page with the preview tags"
+// @Failure      404    {string}  string "Not Found"
+// @Failure      410    {string}  string "Gone - The link expired or reached its download limit"
+// @Failure      429    {string}  string "Too Many Requests - Too many previews from this address"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /public/{token}/preview [get]
+func (s *Server) PreviewPublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, node := s.loadPreviewedLink(w, r)
+	if link == nil {
+		return
+	}
+	preview, err := s.describeLink(r, link, node)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", linkPreviewCacheAge))
+	if err := linkPreviewPage.Execute(w, preview); err != nil {
+		log.Printf("ERROR: Failed to write preview of public link %d: %v", link.ID, err)
+	}
+}
+
+// @Summary      Describe a public link for oEmbed
+// @Description  Needs no account. Returns the oEmbed description of a public link, with the same details as its preview.
+// @Tags         links
+// @Produce      json
+// @Param        token  path      string  true  "Link token"
+// @Success      200    {object}  OEmbedResponse
+// @Failure      404    {string}  string "Not Found"
+// @Failure      410    {string}  string "Gone - The link expired or reached its download limit"
+// @Failure      429    {string}  string "Too Many Requests - Too many previews from this address"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /public/{token}/oembed [get]
+func (s *Server) PublicLinkOEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	link, node := s.loadPreviewedLink(w, r)
+	if link == nil {
+		return
+	}
+	preview, err := s.describeLink(r, link, node)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	response := OEmbedResponse{
+		Version:      "1.0",
+		Type:         "link",
+		Title:        preview.Title,
+		ProviderName: linkPreviewProvider,
+		CacheAge:     linkPreviewCacheAge,
+	}
+	if preview.ImageURL != "" {
+		response.ThumbnailURL = preview.ImageURL
+		response.ThumbnailWidth = linkPreviewThumbnailSize
+		response.ThumbnailHeight = linkPreviewThumbnailSize
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      Get the preview image of a public link
+// @Description  Needs no account. Returns a 256 pixel JPEG thumbnail of an image shared by a download link without a password. It never counts as a download.
+// @Tags         links
+// @Produce      jpeg
+// @Param        token  path      string  true  "Link token"
+// @Success      200    {file}    binary  "JPEG thumbnail"
+// @Failure      404    {string}  string "Not Found - No such link, or it has no preview image"
+// @Failure      410    {string}  string "Gone - The link expired or reached its download limit"
+// @Failure      429    {string}  string "Too Many Requests - Too many previews from this address"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /public/{token}/thumbnail [get]
+func (s *Server) PublicLinkThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	link, node := s.loadPreviewedLink(w, r)
+	if link == nil {
+		return
+	}
+	allowed, err := s.previewThumbnailAllowed(r, link, node)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if !allowed {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNoPreviewImage)
+		return
+	}
+	thumbnail, err := s.thumbnail(r.Context(), node, link.OwnerID, linkPreviewThumbnailSize)
+	if err != nil {
+		if errors.Is(err, errNoThumbnail) {
+			writeError(w, r, http.StatusNotFound, i18n.LinkNoPreviewImage)
+			return
+		}
+		log.Printf("ERROR: Failed to make preview image of public link %d: %v", link.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ThumbnailFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", linkPreviewCacheAge))
+	w.Write(thumbnail)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/qr"
+	"strings"
+)
+
+const (
+	// qrModuleSize is the width in pixels of one module of link QR codes.
+	qrModuleSize = 8
+	// minSlugLength and maxSlugLength bound link slugs; tokens are longer,
+	// so a slug never matches another link's token.
+	minSlugLength = 3
+	maxSlugLength = 30
+)
+
+// normalizeLinkSlug returns the slug in lower case, or an error when it is
+// not 3 to 30 letters, digits and dashes.
+func normalizeLinkSlug(slug string) (string, error) {
+	slug = strings.ToLower(slug)
+	if len(slug) < minSlugLength || len(slug) > maxSlugLength {
+		return "", newOpError(http.StatusBadRequest, i18n.InvalidSlugLength, minSlugLength, maxSlugLength)
+	}
+	for _, c := range slug {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", newOpError(http.StatusBadRequest, i18n.InvalidSlugCharacters)
+		}
+	}
+	return slug, nil
+}
+
+// @Summary      Get the QR code of a public link
+// @Description  Needs no account. Returns a PNG QR code of the link's address, by its slug when it has one, for opening the link on a phone or in print. It counts toward the per-address limit of link previews and never counts as a download.
+// @Tags         links
+// @Produce      png
+// @Param        token  path      string  true  "Link token or slug"
+// @Success      200    {file}    binary  "PNG QR code"
+// @Failure      404    {string}  string "Not Found"
+// @Failure      410    {string}  string "Gone - The link expired or reached its download limit"
+// @Failure      429    {string}  string "Too Many Requests - Too many previews from this address"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /public/{token}/qr [get]
+func (s *Server) PublicLinkQRHandler(w http.ResponseWriter, r *http.Request) {
+	link, _ := s.loadPreviewedLink(w, r)
+	if link == nil {
+		return
+	}
+	code, err := qr.Encode(s.publicLinkURL(r, link))
+	if err != nil {
+		log.Printf("ERROR: Failed to encode QR code of public link %d: %v", link.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.QRCodeFailed)
+		return
+	}
+	image, err := code.PNG(qrModuleSize)
+	if err != nil {
+		log.Printf("ERROR: Failed to draw QR code of public link %d: %v", link.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.QRCodeFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(image)
+}
@@ -68,10 +68,10 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not create local storage: %s", err)
 	}
 
-	wsHub := websocket.NewHub()
+	wsHub := websocket.NewHub(websocket.HubOptions{})
 	store := database.NewStore(pool)
 	cfg := &config.Config{JWT: config.JWTConfig{Secret: "api_test_secret"}}
-	testServer = NewServer(cfg, store, localStorage, wsHub)
+	testServer = NewServer(cfg, store, localStorage, storage.NewRouter(localStorage), wsHub)
 
 	hashedPassword, _ := auth.HashPassword("password")
 	var userID int64
@@ -28,6 +28,22 @@ var (
 		},
 		[]string{"path", "method"},
 	)
+
+	downloadTransfersTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "download_transfers_total",
+			Help: "Responses serving file content, by kind: full, resumed or partial.",
+		},
+		[]string{"kind"},
+	)
+
+	downloadBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "download_bytes_total",
+			Help: "File content bytes sent to clients, by kind of transfer.",
+		},
+		[]string{"kind"},
+	)
 )
 
 func MetricsMiddleware(next http.Handler) http.Handler {
@@ -2,44 +2,94 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/i18n"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type contextKey string
 
 const userContextKey = contextKey("user")
 
+// sessionActivityInterval is how stale a session's last_used_at may get
+// before an authenticated request updates it.
+const sessionActivityInterval = 5 * time.Minute
+
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, i18n.AuthorizationRequired)
 			return
 		}
 
 		headerParts := strings.Split(authHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, i18n.InvalidAuthorization)
 			return
 		}
 
 		tokenString := headerParts[1]
 
-		claims, err := auth.VerifyJWT(tokenString, s.config.JWT.Secret)
+		claims, err := auth.VerifyJWT(tokenString, s.config.Load().JWT.Secret)
 		if err != nil {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, i18n.InvalidToken)
 			return
 		}
 
+		if claims.SessionID != "" {
+			sessionID, err := uuid.Parse(claims.SessionID)
+			if err != nil {
+				writeError(w, r, http.StatusUnauthorized, i18n.InvalidToken)
+				return
+			}
+			active, err := s.store.TouchSessionFamily(r.Context(), sessionID, time.Now().Add(-sessionActivityInterval))
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, i18n.SessionCheckFailed)
+				return
+			}
+			if !active {
+				writeError(w, r, http.StatusUnauthorized, i18n.SessionTerminated)
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), userContextKey, claims)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// AdminMiddleware must run after AuthMiddleware. The admin flag is read from
+// the database on every request so revoking it takes effect immediately.
+func (s *Server) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetUserFromContext(r.Context())
+		if claims == nil {
+			writeError(w, r, http.StatusUnauthorized, i18n.Unauthorized)
+			return
+		}
+
+		user, err := s.store.GetUserByID(r.Context(), claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			writeError(w, r, http.StatusForbidden, i18n.AdminRequired)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func GetUserFromContext(ctx context.Context) *auth.AppClaims {
 	if claims, ok := ctx.Value(userContextKey).(*auth.AppClaims); ok {
 		return claims
@@ -71,3 +121,43 @@ func parsePagination(r *http.Request) (limit, offset int) {
 
 	return limit, offset
 }
+
+// writeListing encodes a listing as JSON. When the request names fields
+// (?fields=id,name,modified_at), each item is reduced to those JSON fields;
+// unknown names are ignored.
+func writeListing(w http.ResponseWriter, r *http.Request, items interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fields := map[string]bool{}
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = true
+		}
+	}
+	if len(fields) == 0 {
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ResponseEncodeFailed)
+		return
+	}
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &rows); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ResponseEncodeFailed)
+		return
+	}
+	for _, row := range rows {
+		for key := range row {
+			if !fields[key] {
+				delete(row, key)
+			}
+		}
+	}
+	if rows == nil {
+		rows = []map[string]json.RawMessage{}
+	}
+	json.NewEncoder(w).Encode(rows)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"serwer-plikow/internal/i18n"
+	"strings"
+)
+
+// sniffLen is how much of a file is inspected to detect its type.
+const sniffLen = 512
+
+// executableSignatures recognize native executables, which
+// http.DetectContentType reports as application/octet-stream.
+var executableSignatures = []struct {
+	prefix   []byte
+	mimeType string
+}{
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, "application/x-mach-binary"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, "application/x-mach-binary"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+}
+
+// refinements lists, for types the content sniffer reports for whole
+// families of formats, which more specific declared types are believed. A
+// .docx sniffs as application/zip and an SVG as text/xml, for example, while
+// a client claiming image/png for plain text is not believed.
+var refinements = map[string]func(declared string) bool{
+	"application/octet-stream": func(string) bool { return true },
+	"text/plain": func(declared string) bool {
+		return strings.HasPrefix(declared, "text/") || strings.HasSuffix(declared, "+json") || strings.HasSuffix(declared, "+xml") ||
+			declared == "application/json" || declared == "application/xml" || declared == "application/javascript" ||
+			declared == "application/x-yaml" || declared == "application/yaml" || declared == "application/sql"
+	},
+	"text/xml": func(declared string) bool {
+		return strings.HasSuffix(declared, "+xml") || declared == "application/xml"
+	},
+	"application/zip": func(declared string) bool {
+		return strings.HasPrefix(declared, "application/vnd.openxmlformats-officedocument.") ||
+			strings.HasPrefix(declared, "application/vnd.oasis.opendocument.") ||
+			declared == "application/epub+zip" || declared == "application/java-archive" ||
+			declared == "application/vnd.android.package-archive"
+	},
+	"video/mp4":       func(declared string) bool { return declared == "audio/mp4" || declared == "audio/x-m4a" },
+	"video/webm":      func(declared string) bool { return declared == "audio/webm" },
+	"application/ogg": func(declared string) bool { return declared == "audio/ogg" || declared == "video/ogg" },
+}
+
+// detectMimeType determines the type of a file from the first bytes of its
+// content. The type declared by the client, or else the one implied by the
+// file extension, is used only where it refines what the content shows.
+func detectMimeType(head []byte, fileName, declared string) string {
+	sniffed := http.DetectContentType(head)
+	if len(head) == 0 {
+		// An empty file has no content to go by.
+		sniffed = "application/octet-stream"
+	}
+	if bytes.HasPrefix(head, []byte("MZ")) && isPortableExecutable(head) {
+		return "application/vnd.microsoft.portable-executable"
+	}
+	for _, signature := range executableSignatures {
+		if bytes.HasPrefix(head, signature.prefix) {
+			return signature.mimeType
+		}
+	}
+
+	sniffedType, _, _ := mime.ParseMediaType(sniffed)
+	refines, ok := refinements[sniffedType]
+	if !ok {
+		return sniffed
+	}
+	for _, candidate := range []string{declared, mime.TypeByExtension(path.Ext(fileName))} {
+		candidateType, _, err := mime.ParseMediaType(candidate)
+		if err != nil || candidateType == "application/octet-stream" {
+			continue
+		}
+		if refines(candidateType) {
+			return candidate
+		}
+	}
+	return sniffed
+}
+
+// isPortableExecutable checks the PE header a DOS "MZ" stub points to, so
+// text that merely starts with "MZ" is not taken for a Windows executable.
+func isPortableExecutable(head []byte) bool {
+	if len(head) < 0x40 {
+		return false
+	}
+	offset := int(binary.LittleEndian.Uint32(head[0x3c:0x40]))
+	return offset+4 <= len(head) && bytes.Equal(head[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// sniffReader returns the type of the content r yields and a reader that
+// still yields all of it.
+func sniffReader(r io.Reader, fileName, declared string) (string, io.Reader, error) {
+	buffered := bufio.NewReaderSize(r, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", nil, err
+	}
+	return detectMimeType(head, fileName, declared), buffered, nil
+}
+
+// sniffFile detects the type of a stored or uploaded file from its first
+// bytes.
+func sniffFile(r io.ReaderAt, fileName, declared string) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return detectMimeType(head[:n], fileName, declared), nil
+}
+
+// contentTypeError reports a file whose type the content_types policy does
+// not allow.
+type contentTypeError struct {
+	name     string
+	mimeType string
+}
+
+func (e *contentTypeError) Error() string {
+	return i18n.Messagef(i18n.ContentTypeNotAllowed, i18n.English, e.name, e.mimeType)
+}
+
+func (e *contentTypeError) errorCode() (string, []any) {
+	return i18n.ContentTypeNotAllowed, []any{e.name, e.mimeType}
+}
+
+// checkContentType applies the content_types allowlist and blocklist to a
+// file's detected type.
+func (s *Server) checkContentType(name, mimeType string) error {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = strings.ToLower(mimeType)
+	}
+	policy := s.config.Load().ContentTypes
+	if matchesContentType(policy.Blocked, mediaType) || (len(policy.Allowed) > 0 && !matchesContentType(policy.Allowed, mediaType)) {
+		return &contentTypeError{name: name, mimeType: mediaType}
+	}
+	return nil
+}
+
+// matchesContentType matches a media type against exact types and "type/*"
+// patterns.
+func matchesContentType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffStaged detects the type of content staged in local storage.
+func (s *Server) sniffStaged(stagedID, fileName, declared string) (string, error) {
+	staged, err := s.storage.Open(stagedID)
+	if err != nil {
+		return "", err
+	}
+	defer staged.Close()
+	return sniffFile(staged, fileName, declared)
+}
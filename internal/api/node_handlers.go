@@ -1,23 +1,27 @@
 package api
 
 import (
-	"archive/zip"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"path"
+	"serwer-plikow/internal/contentpolicy"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
 	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jaevor/go-nanoid"
 )
 
 type CreateFolderRequest struct {
@@ -25,38 +29,44 @@ type CreateFolderRequest struct {
 	ParentID *string `json:"parent_id,omitempty" example:"_vx2a-43VqRT5wz_s9u4"`
 }
 
-type NodeResponse struct {
-	ID         string    `json:"id" example:"_vx2a-43VqRT5wz_s9u4"`
-	OwnerID    int64     `json:"owner_id" example:"1"`
-	ParentID   *string   `json:"parent_id,omitempty" example:"fLW5kAh2ia9vYmjMnU4nZ"`
-	Name       string    `json:"name" example:"Raport_Q3.docx"`
-	NodeType   string    `json:"node_type" example:"file"`
-	SizeBytes  *int64    `json:"size_bytes,omitempty" example:"123456"`
-	MimeType   *string   `json:"mime_type,omitempty" example:"application/vnd.openxmlformats-officedocument.wordprocessingml.document"`
-	CreatedAt  time.Time `json:"created_at"`
-	ModifiedAt time.Time `json:"modified_at"`
+// wantsChildCounts reports whether a listing was asked to include the number
+// of direct children of each folder (?include=child_count).
+func wantsChildCounts(r *http.Request) bool {
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(include) == "child_count" {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Server) generateUniqueID(ctx context.Context) (string, error) {
-	maxRetries := 10
+// attachChildCounts fills in ChildCount for the folders in nodes.
+func (s *Server) attachChildCounts(ctx context.Context, q *database.Queries, nodes []models.Node) error {
+	folderIDs := []string{}
+	for _, node := range nodes {
+		if node.NodeType == "folder" {
+			folderIDs = append(folderIDs, node.ID)
+		}
+	}
+	if len(folderIDs) == 0 {
+		return nil
+	}
 
-	generateID, err := nanoid.Standard(21)
+	counts, err := q.CountChildrenOf(ctx, folderIDs)
 	if err != nil {
-		return "", fmt.Errorf("failed to initialize nanoid generator: %w", err)
+		return err
 	}
-
-	for i := 0; i < maxRetries; i++ {
-		id := generateID()
-		exists, err := s.store.NodeExists(ctx, id)
-		if err != nil {
-			return "", fmt.Errorf("failed to check for node existence: %w", err)
-		}
-		if !exists {
-			return id, nil
+	for i := range nodes {
+		if nodes[i].NodeType == "folder" {
+			count := counts[nodes[i].ID]
+			nodes[i].ChildCount = &count
 		}
 	}
+	return nil
+}
 
-	return "", fmt.Errorf("failed to generate a unique ID after %d attempts", maxRetries)
+func (s *Server) generateUniqueID(ctx context.Context) (string, error) {
+	return s.nodeIDs.Unique(ctx, s.store.NodeExists)
 }
 
 // @Summary      Create a new folder
@@ -66,12 +76,13 @@ func (s *Server) generateUniqueID(ctx context.Context) (string, error) {
 // @Produce      json
 // @Security     BearerAuth
 // @Param        folderRequest  body      CreateFolderRequest  true  "Folder details"
-// @Success      201            {object}  NodeResponse
+// @Success      201            {object}  dto.Node
 // @Failure      400            {string}  string "Bad Request"
 // @Failure      401            {string}  string "Unauthorized"
 // @Failure      403            {string}  string "Forbidden - Write permission denied"
 // @Failure      404            {string}  string "Not Found - Parent folder not found"
 // @Failure      409            {string}  string "Conflict - a folder with the same name already exists"
+// @Failure      422            {string}  string "Unprocessable Entity - Folder depth or children limit exceeded"
 // @Failure      500            {string}  string "Internal Server Error"
 // @Router       /nodes/folder [post]
 func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
@@ -79,27 +90,27 @@ func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req CreateFolderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 
 	if strings.TrimSpace(req.Name) == "" {
-		http.Error(w, "Folder name cannot be empty", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.EmptyFolderName)
 		return
 	}
 
 	if req.ParentID != nil && len(*req.ParentID) != 21 {
-		http.Error(w, "Invalid ParentID format", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
 		return
 	}
 
 	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, req.ParentID)
 	if err != nil {
-		http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
 		return
 	}
 	if !hasPermission {
-		http.Error(w, "You do not have permission to create items in this folder", http.StatusForbidden)
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
 		return
 	}
 
@@ -108,13 +119,17 @@ func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
 	if req.ParentID != nil {
 		parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *req.ParentID, claims.UserID)
 		if err != nil || parentFolder == nil {
-			http.Error(w, "Parent folder not found or access denied", http.StatusNotFound)
+			s.writeNodeNotFound(w, r, *req.ParentID, i18n.ParentFolderNotAccessible)
 			return
 		}
 		ownerID = parentFolder.OwnerID
 		parentFolderOwnerID = &parentFolder.OwnerID
 	}
 
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, req.ParentID, 1, 1)) {
+		return
+	}
+
 	var createdNode *models.Node
 
 	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
@@ -152,15 +167,15 @@ func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
 		if errors.As(txErr, &pgErr) {
 			switch pgErr.Code {
 			case "23503":
-				http.Error(w, "Parent folder does not exist", http.StatusBadRequest)
+				writeError(w, r, http.StatusBadRequest, i18n.ParentFolderMissing)
 				return
 			case "23505":
-				http.Error(w, "A folder with the same name already exists in this location", http.StatusConflict)
+				writeError(w, r, http.StatusConflict, i18n.DuplicateFolderName)
 				return
 			}
 		}
 		log.Printf("ERROR: Transaction failed in CreateFolderHandler: %v", txErr)
-		http.Error(w, "Failed to create folder", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderCreateFailed)
 		return
 	}
 
@@ -176,11 +191,12 @@ func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
 		if parentFolderOwnerID != nil && claims.UserID != *parentFolderOwnerID {
 			s.wsHub.PublishEvent(*parentFolderOwnerID, eventBytes)
 		}
+		s.notifyWatchers(r.Context(), []string{createdNode.ID}, eventBytes, claims.UserID, createdNode.OwnerID)
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(createdNode)
+	json.NewEncoder(w).Encode(dto.NodePtr(createdNode))
 }
 
 // @Summary      List user's own nodes
@@ -191,7 +207,9 @@ func (s *Server) CreateFolderHandler(w http.ResponseWriter, r *http.Request) {
 // @Param        parent_id  query     string  false  "ID of the parent folder to list. Omit for root."
 // @Param        limit      query     int     false  "Number of items to return" default(100)
 // @Param        offset     query     int     false  "Offset for pagination" default(0)
-// @Success      200        {array}   NodeResponse
+// @Param        include    query     string  false  "Set to child_count to include the number of direct children of each folder"
+// @Param        fields     query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,name,node_type,modified_at"
+// @Success      200        {array}   dto.Node
 // @Failure      401        {string}  string "Unauthorized"
 // @Failure      500        {string}  string "Internal Server Error"
 // @Router       /nodes [get]
@@ -205,40 +223,54 @@ func (s *Server) ListNodesHandler(w http.ResponseWriter, r *http.Request) {
 		parentID = &parentIDStr
 	}
 
-	nodes, err := s.store.GetNodesByParentID(r.Context(), claims.UserID, parentID, limit, offset)
+	nodes, err := s.store.ReadReplica().GetNodesByParentID(r.Context(), claims.UserID, parentID, limit, offset)
 	if err != nil {
 		log.Printf("ERROR: Failed to list own nodes for user %d: %v", claims.UserID, err)
-		http.Error(w, "Failed to list nodes", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodesListFailed)
 		return
 	}
+	if wantsChildCounts(r) {
+		if err := s.attachChildCounts(r.Context(), s.store.ReadReplica(), nodes); err != nil {
+			log.Printf("ERROR: Failed to count children for user %d: %v", claims.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.NodesListFailed)
+			return
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	writeListing(w, r, dto.Nodes(nodes))
 }
 
 // @Summary      Upload file(s)
-// @Description  Uploads one or more files. If uploaded inside a shared folder with write permissions, the folder's owner becomes the owner of the new file(s). The total size of the request payload cannot exceed 1GB. Exceeding the owner's storage quota will result in an error.
+// @Description  Uploads one or more files. If uploaded inside a shared folder with write permissions, the folder's owner becomes the owner of the new file(s). The request cannot exceed storage.max_request_size_mb (1GB by default), each file storage.max_file_size_mb and the number of files storage.max_files_per_request; these limits are rejected with 413 and an UploadLimitError naming the limit, the request size limit from Content-Length before the body is read. Larger files are uploaded in chunks through POST /nodes/file/sessions. Exceeding the owner's storage quota will result in an error. When more than one file is uploaded, WebSocket clients receive a single "folder_changed" event instead of one "node_created" per file. The owner's organization rules are applied to the new files before the response is sent. The type of each file is detected from its first 512 bytes; the part's Content-Type or the file extension is only used to refine a generic result (e.g. a .docx that sniffs as a ZIP archive). Types excluded by the content_types configuration are rejected with 415 before anything is stored. The SHA-256 checksum of each file is recorded on its node. To have files verified, send their hex checksum in an X-Content-SHA256 header of each part, or of the request when it carries a single file; if any file does not match, nothing is stored and 422 is returned.
 // @Tags         nodes
 // @Accept       multipart/form-data
 // @Produce      json
 // @Security     BearerAuth
-// @Param        file       formData  file    true   "The file(s) to upload. Can be provided multiple times."
-// @Param        parent_id  formData  string  false  "ID of the parent folder."
-// @Success      201        {array}   NodeResponse
-// @Failure      400        {string}  string "Bad Request"
-// @Failure      401        {string}  string "Unauthorized"
-// @Failure      403        {string}  string "Forbidden - Write permission denied"
-// @Failure      404        {string}  string "Not Found - Parent folder not found"
-// @Failure      413        {string}  string "Payload Too Large - either the request exceeds 1GB or the owner's storage quota is exceeded."
-// @Failure      500        {string}  string "Internal Server Error"
+// @Param        file              formData  file    true   "The file(s) to upload. Can be provided multiple times."
+// @Param        parent_id         formData  string  false  "ID of the parent folder."
+// @Param        X-Content-SHA256  header    string  false  "Hex SHA-256 checksum of the file when a single file is uploaded"
+// @Success      201               {array}   dto.Node
+// @Failure      400               {string}  string "Bad Request"
+// @Failure      401               {string}  string "Unauthorized"
+// @Failure      403               {string}  string "Forbidden - Write permission denied or a file is blocked by the content policy"
+// @Failure      404               {string}  string "Not Found - Parent folder not found"
+// @Failure      413               {object}  UploadLimitError "Payload Too Large - An upload limit or the owner's storage quota is exceeded"
+// @Failure      415               {string}  string "Unsupported Media Type - A file type is not allowed on this server"
+// @Failure      422               {string}  string "Unprocessable Entity - Folder children limit exceeded or a file does not match its checksum"
+// @Failure      500               {string}  string "Internal Server Error"
 // @Router       /nodes/file [post]
 func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30) // TODO: zaimplementować chunked upload!!!
+	if !s.limitRequestBody(w, r) {
+		return
+	}
 
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Error parsing multipart form: "+err.Error(), http.StatusBadRequest)
+		if s.writeRequestTooLarge(w, err) {
+			return
+		}
+		writeErrorf(w, r, http.StatusBadRequest, i18n.MultipartParseFailed, err)
 		return
 	}
 
@@ -246,7 +278,7 @@ func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	var parentID *string
 	if parentIDStr != "" {
 		if len(parentIDStr) != 21 {
-			http.Error(w, "Invalid ParentID format", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
 			return
 		}
 		parentID = &parentIDStr
@@ -254,11 +286,11 @@ func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, parentID)
 	if err != nil {
-		http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
 		return
 	}
 	if !hasPermission {
-		http.Error(w, "You do not have permission to create items in this folder", http.StatusForbidden)
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
 		return
 	}
 
@@ -267,7 +299,7 @@ func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	if parentID != nil {
 		parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *parentID, claims.UserID)
 		if err != nil || parentFolder == nil {
-			http.Error(w, "Parent folder not found or access denied", http.StatusNotFound)
+			s.writeNodeNotFound(w, r, *parentID, i18n.ParentFolderNotAccessible)
 			return
 		}
 		ownerID = parentFolder.OwnerID
@@ -276,13 +308,84 @@ func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	files := r.MultipartForm.File["file"]
 	if len(files) == 0 {
-		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.NoFilesUploaded)
+		return
+	}
+	if !s.checkFileCount(w, len(files)) {
+		return
+	}
+	for _, handler := range files {
+		if !s.checkFileSize(w, handler.Filename, handler.Size) {
+			return
+		}
+	}
+
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, parentID, len(files), 1)) {
+		return
+	}
+
+	requestSHA256 := r.Header.Get(contentSHA256Header)
+	if requestSHA256 != "" && len(files) > 1 {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.SingleFileChecksumHeader, contentSHA256Header)
 		return
 	}
 
+	mimeTypes := make([]string, len(files))
+	quarantines := make([]*contentpolicy.Decision, len(files))
+	for i, handler := range files {
+		expectedSHA256 := handler.Header.Get(contentSHA256Header)
+		if expectedSHA256 == "" {
+			expectedSHA256 = requestSHA256
+		}
+		expectedSHA256, err := parseContentSHA256(expectedSHA256)
+		if err != nil {
+			writeLocalizedError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		file, err := handler.Open()
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UploadReadFailed, handler.Filename)
+			return
+		}
+		mimeTypes[i], err = sniffFile(file, handler.Filename, handler.Header.Get("Content-Type"))
+		if err == nil && expectedSHA256 != "" {
+			var actualSHA256 string
+			if actualSHA256, err = hashContent(io.NewSectionReader(file, 0, handler.Size)); err == nil {
+				err = verifyContentSHA256(handler.Filename, expectedSHA256, actualSHA256)
+			}
+		}
+		file.Close()
+		var mismatchErr *checksumMismatchError
+		if errors.As(err, &mismatchErr) {
+			writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UploadReadFailed, handler.Filename)
+			return
+		}
+		if err := s.checkContentType(handler.Filename, mimeTypes[i]); err != nil {
+			writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+			return
+		}
+		described := contentpolicy.File{Name: handler.Filename, MimeType: mimeTypes[i], SizeBytes: handler.Size}
+		quarantines[i], err = s.evaluateUpload(r.Context(), described, func() (io.ReadCloser, error) { return handler.Open() })
+		if err != nil {
+			var policyErr *contentPolicyError
+			if errors.As(err, &policyErr) {
+				writeLocalizedError(w, r, http.StatusForbidden, err)
+				return
+			}
+			log.Printf("ERROR: Content policy failed for uploaded file %s: %v", handler.Filename, err)
+			writeErrorf(w, r, http.StatusInternalServerError, i18n.UploadCheckFailed, handler.Filename)
+			return
+		}
+	}
+
 	ownerUser, err := s.store.GetUserByID(r.Context(), ownerID)
 	if err != nil || ownerUser == nil {
-		http.Error(w, "Could not verify owner for quota check", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
 		return
 	}
 
@@ -292,162 +395,364 @@ func (s *Server) UploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if ownerUser.StorageUsedBytes+totalUploadSize > ownerUser.StorageQuotaBytes {
-		http.Error(w, "Storage quota for the owner of this folder is exceeded", http.StatusRequestEntityTooLarge)
+		writeError(w, r, http.StatusRequestEntityTooLarge, i18n.StorageQuotaExceeded)
 		return
 	}
 
 	var createdNodes []models.Node
 
-	for _, handler := range files {
-		file, err := handler.Open()
+	for i, handler := range files {
+		createdNode, err := s.storeUploadedFile(r.Context(), claims.UserID, ownerID, parentID, handler, handler.Filename, mimeTypes[i], quarantines[i])
 		if err != nil {
-			log.Printf("ERROR opening multipart file %s: %v", handler.Filename, err)
 			continue
 		}
-		defer file.Close()
+		createdNodes = append(createdNodes, *createdNode)
+		if quarantines[i] != nil {
+			s.notifyQuarantine(r.Context(), createdNode, contentpolicy.ActionUpload, quarantines[i])
+		}
+	}
 
-		var createdNode *models.Node
-		nodeID := ""
+	if len(createdNodes) == 0 {
+		writeError(w, r, http.StatusInternalServerError, i18n.NoFilesProcessed)
+		return
+	}
 
-		txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
-			var txErr error
-			nodeID, txErr = s.generateUniqueID(r.Context())
-			if txErr != nil {
-				return txErr
-			}
+	s.publishUploadedNodes(r.Context(), claims.UserID, parentFolderOwnerID, parentID, createdNodes)
+	createdNodes = s.applyOrganizationRules(r.Context(), ownerID, createdNodes)
 
-			file.Seek(0, io.SeekStart)
-			if err := s.storage.Save(nodeID, file); err != nil {
-				return fmt.Errorf("failed to save file to storage: %w", err)
-			}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.Nodes(createdNodes))
+}
 
-			sizeBytes := handler.Size
-			mimeType := handler.Header.Get("Content-Type")
-			params := database.CreateNodeParams{
-				ID:        nodeID,
-				OwnerID:   ownerID,
-				ParentID:  parentID,
-				Name:      handler.Filename,
-				NodeType:  "file",
-				SizeBytes: &sizeBytes,
-				MimeType:  &mimeType,
-			}
+// storeUploadedFile stores one file of a multipart upload under the given
+// name and creates its node, charging the owner's storage. Failures are
+// logged; the content is cleaned up when the node cannot be created.
+func (s *Server) storeUploadedFile(ctx context.Context, uploaderID, ownerID int64, parentID *string, handler *multipart.FileHeader, name, mimeType string, quarantine *contentpolicy.Decision) (*models.Node, error) {
+	file, err := handler.Open()
+	if err != nil {
+		log.Printf("ERROR opening multipart file %s: %v", handler.Filename, err)
+		return nil, err
+	}
+	defer file.Close()
+	return s.storeFileContent(ctx, uploaderID, ownerID, parentID, file, handler.Size, name, mimeType, quarantine)
+}
 
-			createdNode, txErr = q.CreateNode(r.Context(), params)
-			if txErr != nil {
-				return txErr
-			}
+// storeFileContent stores sizeBytes of file as a new file node, the way
+// storeUploadedFile does for a multipart upload.
+func (s *Server) storeFileContent(ctx context.Context, uploaderID, ownerID int64, parentID *string, file io.ReadSeeker, sizeBytes int64, name, mimeType string, quarantine *contentpolicy.Decision) (*models.Node, error) {
+	var createdNode *models.Node
+	var duplicate bool
+	nodeID, placedKey := "", ""
+	backendName, backend, err := s.routeContent(sizeBytes, &mimeType)
+	if err != nil {
+		log.Printf("ERROR: No storage backend for file %s: %v", name, err)
+		return nil, err
+	}
 
-			txErr = q.UpdateUserStorage(r.Context(), ownerID, sizeBytes)
-			if txErr != nil {
-				return txErr
-			}
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var txErr error
+		nodeID, txErr = s.generateUniqueID(ctx)
+		if txErr != nil {
+			return txErr
+		}
 
-			err = q.LogEvent(r.Context(), claims.UserID, "node_created", createdNode)
-			if err != nil {
+		file.Seek(0, io.SeekStart)
+		hasher := sha256.New()
+		if err := backend.Save(nodeID, io.TeeReader(file, hasher)); err != nil {
+			return fmt.Errorf("failed to save file to storage: %w", err)
+		}
+		contentSHA256 := hexSum(hasher)
+		storageKey, dup, err := s.storeContentBlob(ctx, q, backend, nodeID, backendName, backend, contentSHA256, sizeBytes)
+		if err != nil {
+			return fmt.Errorf("failed to store file content: %w", err)
+		}
+		duplicate = dup
+		if !dup {
+			placedKey = storageKey
+		}
+
+		params := database.CreateNodeParams{
+			ID:             nodeID,
+			OwnerID:        ownerID,
+			ParentID:       parentID,
+			Name:           name,
+			NodeType:       "file",
+			SizeBytes:      &sizeBytes,
+			MimeType:       &mimeType,
+			StorageBackend: backendName,
+			ContentSHA256:  &contentSHA256,
+			StorageKey:     &storageKey,
+		}
+
+		createdNode, txErr = q.CreateNode(ctx, params)
+		if txErr != nil {
+			return txErr
+		}
+		if quarantine != nil {
+			if err := q.QuarantineNode(ctx, nodeID, contentpolicy.ActionUpload, quarantine.Rule, quarantine.Reason); err != nil {
 				return err
 			}
+		}
 
-			if parentFolderOwnerID != nil && claims.UserID != *parentFolderOwnerID {
-				err = q.LogEvent(r.Context(), *parentFolderOwnerID, "node_created", createdNode)
-			}
+		if err := q.LogEvents(ctx, nodeCreatedEvents(uploaderID, ownerID, createdNode)); err != nil {
 			return err
-		})
+		}
+		return q.UpdateUserStorage(ctx, ownerID, sizeBytes)
+	})
 
-		if txErr != nil {
-			log.Printf("ERROR creating db record for file %s: %v", handler.Filename, txErr)
-			if nodeID != "" {
-				if cleanupErr := s.storage.Delete(nodeID); cleanupErr != nil {
-					log.Printf("CRITICAL: Failed to clean up orphaned file %s: %v", nodeID, cleanupErr)
-				}
+	if txErr != nil {
+		log.Printf("ERROR creating db record for file %s: %v", name, txErr)
+		if nodeID != "" {
+			if cleanupErr := backend.Delete(nodeID); cleanupErr != nil {
+				log.Printf("CRITICAL: Failed to clean up orphaned file %s: %v", nodeID, cleanupErr)
 			}
-			continue
 		}
+		if placedKey != "" {
+			s.discardPlacedBlob(ctx, backendName, backend, placedKey)
+		}
+		return nil, txErr
+	}
+	if duplicate {
+		if err := backend.Delete(nodeID); err != nil {
+			log.Printf("WARN: Failed to delete duplicate content %s: %v", nodeID, err)
+		}
+	}
+	return createdNode, nil
+}
 
-		eventMsg := map[string]interface{}{"event_type": "node_created", "payload": createdNode}
-		eventBytes, _ := json.Marshal(eventMsg)
-
-		s.wsHub.PublishEvent(claims.UserID, eventBytes)
-		if parentFolderOwnerID != nil && claims.UserID != *parentFolderOwnerID {
-			s.wsHub.PublishEvent(*parentFolderOwnerID, eventBytes)
+// nodeCreatedEvents returns the node_created journal entries of new nodes for
+// the uploader and, when it is someone else, the owner of the nodes. They are
+// logged in the transaction creating the nodes, so a committed node is never
+// missing from the journal.
+func nodeCreatedEvents(uploaderID, ownerID int64, nodes ...*models.Node) []database.EventEntry {
+	recipients := []int64{uploaderID}
+	if ownerID != uploaderID {
+		recipients = append(recipients, ownerID)
+	}
+	entries := make([]database.EventEntry, 0, len(nodes)*len(recipients))
+	for _, node := range nodes {
+		for _, userID := range recipients {
+			entries = append(entries, database.EventEntry{UserID: userID, EventType: "node_created", Payload: node})
 		}
+	}
+	return entries
+}
 
-		createdNodes = append(createdNodes, *createdNode)
+// publishUploadedNodes announces uploaded files already journaled with
+// nodeCreatedEvents. A single file is pushed over WebSocket as node_created,
+// while larger uploads are coalesced into one folder_changed event. Hooks of
+// the parent folder are queued for the new files.
+func (s *Server) publishUploadedNodes(ctx context.Context, uploaderID int64, parentFolderOwnerID *int64, parentID *string, nodes []models.Node) {
+	recipients := []int64{uploaderID}
+	if parentFolderOwnerID != nil && uploaderID != *parentFolderOwnerID {
+		recipients = append(recipients, *parentFolderOwnerID)
 	}
 
-	if len(createdNodes) == 0 {
-		http.Error(w, "None of the files could be processed", http.StatusInternalServerError)
-		return
+	var eventMsg map[string]interface{}
+	if len(nodes) == 1 {
+		eventMsg = map[string]interface{}{"event_type": "node_created", "payload": nodes[0]}
+	} else {
+		eventMsg = map[string]interface{}{
+			"event_type": "folder_changed",
+			"payload": map[string]interface{}{
+				"folder_id": parentID,
+				"created":   nodes,
+			},
+		}
 	}
+	eventBytes, _ := json.Marshal(eventMsg)
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdNodes)
+	for _, userID := range recipients {
+		s.wsHub.PublishEvent(userID, eventBytes)
+	}
+	if parentID != nil {
+		s.notifyWatchers(ctx, []string{*parentID}, eventBytes, append(recipients, nodes[0].OwnerID)...)
+	}
+	s.enqueueFolderHooks(ctx, parentID, nodes)
 }
 
 // @Summary      Download a file
-// @Description  Downloads a single file by its ID.
+// @Description  Downloads a single file by its ID. Recipients of a share pinned to a version receive that version of the file. Supports byte ranges for seeking and resuming: a Range header with one range is answered with that part of the file, several ranges (up to 16) with multipart/byteranges. With If-Range, the ranges are served only if the ETag or Last-Modified date still matches, and the whole file otherwise.
 // @Tags         nodes
 // @Produce      application/octet-stream
 // @Security     BearerAuth
-// @Param        nodeId   path      string  true  "Node ID of the file to download"
+// @Param        nodeId   path      string  true   "Node ID of the file to download"
+// @Param        Range    header    string  false  "Byte ranges, e.g. bytes=0-1023"
+// @Param        If-Range header    string  false  "ETag or Last-Modified date the ranges are valid for"
 // @Success      200      {file}    binary  "The file content"
+// @Success      206      {file}    binary  "The requested ranges"
 // @Failure      400      {string}  string "Bad Request - Cannot download a folder"
 // @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - The file is quarantined"
 // @Failure      404      {string}  string "Not Found"
+// @Failure      416      {string}  string "Requested range not satisfiable"
 // @Failure      500      {string}  string "Internal Server Error"
 // @Router       /nodes/{nodeId}/download [get]
 func (s *Server) DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveFileContent(w, r, false)
+}
+
+// @Summary      Preview a file inline
+// @Description  Serves a file like /download, including byte ranges, but for display in the browser (Content-Disposition: inline), so PDFs, images, audio, video and plain text open in a tab or an <img>/<video> element. Only types browsers render safely can be previewed; HTML, SVG and other active content is refused and must be downloaded. Responses carry X-Content-Type-Options: nosniff so browsers never guess a different type.
+// @Tags         nodes
+// @Produce      application/octet-stream
+// @Security     BearerAuth
+// @Param        nodeId   path      string  true   "Node ID of the file to preview"
+// @Param        Range    header    string  false  "Byte ranges, e.g. bytes=0-1023"
+// @Param        If-Range header    string  false  "ETag or Last-Modified date the ranges are valid for"
+// @Success      200      {file}    binary  "The file content"
+// @Success      206      {file}    binary  "The requested ranges"
+// @Failure      400      {string}  string "Bad Request - Cannot download a folder"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - The file is quarantined"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      415      {string}  string "Unsupported Media Type - The file type cannot be previewed"
+// @Failure      416      {string}  string "Requested range not satisfiable"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/preview [get]
+func (s *Server) PreviewFileHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveFileContent(w, r, true)
+}
+
+// serveFileContent serves a file for download or, with inline, for display in
+// the browser.
+func (s *Server) serveFileContent(w http.ResponseWriter, r *http.Request, inline bool) {
 	claims := GetUserFromContext(r.Context())
 
 	nodeID := chi.URLParam(r, "nodeId")
 	if nodeID == "" {
-		http.Error(w, "Node ID is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.NodeIDRequired)
 		return
 	}
 
 	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve file metadata", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
 		return
 	}
 	if node == nil {
-		http.Error(w, "File not found or you do not have permission to access it", http.StatusNotFound)
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
 		return
 	}
 	if node.NodeType != "file" {
-		http.Error(w, "Cannot download a folder", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.DownloadFolder)
+		return
+	}
+	if quarantined, err := s.isQuarantined(r.Context(), node.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	} else if quarantined {
+		writeError(w, r, http.StatusForbidden, i18n.QuarantinedFile)
 		return
 	}
 
-	fileStream, err := s.storage.Get(node.ID)
+	pinned, err := s.pinnedVersionFor(r.Context(), node, claims.UserID)
 	if err != nil {
-		http.Error(w, "File not found on storage", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
 		return
 	}
-	defer fileStream.Close()
 
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+node.Name+"\"")
-	if node.MimeType != nil && *node.MimeType != "" {
-		w.Header().Set("Content-Type", *node.MimeType)
+	var backend storage.Backend
+	var key string
+	sizeBytes, mimeType := node.SizeBytes, node.MimeType
+	etag, lastModified := contentETag(node), &node.ModifiedAt
+	if pinned != nil {
+		backend, key, sizeBytes, mimeType, err = s.nodeVersionBlob(r.Context(), node, *pinned)
+		if errors.Is(err, errVersionNotFound) {
+			writeError(w, r, http.StatusNotFound, i18n.SharedVersionGone)
+			return
+		}
+		// A pinned version never changes, so it is identified by its number.
+		etag, lastModified = fmt.Sprintf("\"%s-v%d\"", node.ID, *pinned), nil
 	} else {
-		w.Header().Set("Content-Type", "application/octet-stream")
+		_, key, backend, err = s.nodeBackend(r.Context(), s.store.Queries, node.ID)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+
+	contentType := "application/octet-stream"
+	if mimeType != nil && *mimeType != "" {
+		contentType = *mimeType
+	}
+	disposition := "attachment"
+	if inline {
+		if !inlineContentType(contentType) {
+			writeError(w, r, http.StatusUnsupportedMediaType, i18n.PreviewUnsupported)
+			return
+		}
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, node.Name))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", etag)
+	if lastModified != nil {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	var ranges []byteRange
+	if sizeBytes != nil {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if header := r.Header.Get("Range"); header != "" && ifRangeMatches(r, etag, lastModified) {
+			ranges, err = parseByteRanges(header, *sizeBytes)
+			if errors.Is(err, errRangeNotSatisfiable) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", *sizeBytes))
+				writeError(w, r, http.StatusRequestedRangeNotSatisfiable, i18n.RangeNotSatisfiable)
+				return
+			}
+		}
 	}
 
-	if node.SizeBytes != nil {
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", *node.SizeBytes))
+	// Players seek with many ranged requests; only the one starting at the
+	// beginning of the file is logged as a download.
+	if len(ranges) == 0 || ranges[0].start == 0 {
+		s.recordAccess(r, claims.UserID, node.ID, node.OwnerID, "download")
 	}
 
-	io.Copy(w, fileStream)
+	transfer := database.RecordDownloadTransferParams{NodeID: node.ID, OwnerID: node.OwnerID, UserID: &claims.UserID, Kind: database.TransferFull}
+	cw := &countingResponseWriter{ResponseWriter: w}
+	if len(ranges) > 0 {
+		transfer.Kind = transferKind(ranges, *sizeBytes)
+		transfer.RangeStart = ranges[0].start
+		for _, br := range ranges {
+			transfer.BytesRequested += br.length
+		}
+		serveByteRanges(cw, r, backend, key, ranges, *sizeBytes)
+		// Multipart responses also carry part headers, which are not content.
+		transfer.BytesServed = min(cw.written, transfer.BytesRequested)
+		s.recordTransfer(r, transfer)
+		return
+	}
+
+	fileStream, err := backend.Get(key)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer fileStream.Close()
+
+	if sizeBytes != nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", *sizeBytes))
+	}
+	io.Copy(cw, fileStream)
+	transfer.BytesServed = cw.written
+	transfer.BytesRequested = cw.written
+	if sizeBytes != nil {
+		transfer.BytesRequested = *sizeBytes
+	}
+	s.recordTransfer(r, transfer)
 }
 
 // @Summary      Move node to trash
-// @Description  Moves a file or a folder (and its contents) to the trash (soft delete). Requires write permission in the folder containing the node. The node is moved to its owner's trash.
+// @Description  Moves a file or a folder (and its contents) to the trash (soft delete). Users other than the owner need manage permission in the folder containing the node; write permission is not enough. The node is moved to its owner's trash. The response carries an X-Undo-Token header that can be passed to POST /undo/{token} until X-Undo-Expires-At.
 // @Tags         nodes
 // @Security     BearerAuth
 // @Param        nodeId   path      string  true  "Node ID to move to trash"
 // @Success      204      {null}    nil     "No Content"
 // @Failure      401      {string}  string "Unauthorized"
-// @Failure      403      {string}  string "Forbidden - Write permission denied"
+// @Failure      403      {string}  string "Forbidden - Manage permission denied"
 // @Failure      404      {string}  string "Not Found"
 // @Failure      500      {string}  string "Internal Server Error"
 // @Router       /nodes/{nodeId} [delete]
@@ -456,45 +761,52 @@ func (s *Server) DeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
 	nodeID := chi.URLParam(r, "nodeId")
 
 	if nodeID == "" {
-		http.Error(w, "Node ID is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.NodeIDRequired)
 		return
 	}
 
 	nodeToDelete, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve node to delete", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.DeleteLookupFailed)
 		return
 	}
 	if nodeToDelete == nil {
-		http.Error(w, "Node not found or access denied", http.StatusNotFound)
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
 		return
 	}
 
-	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, nodeToDelete.ParentID)
-	if err != nil {
-		http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
-		return
+	hasPermission := nodeToDelete.OwnerID == claims.UserID
+	if !hasPermission && nodeToDelete.ParentID != nil {
+		hasPermission, err = s.store.CheckManagePermission(r.Context(), claims.UserID, *nodeToDelete.ParentID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
 	}
 	if !hasPermission {
-		http.Error(w, "You do not have permission to delete items in this folder", http.StatusForbidden)
+		writeError(w, r, http.StatusForbidden, i18n.DeletePermissionDenied)
 		return
 	}
 
+	batchID := uuid.New()
 	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
-		success, err := q.MoveNodeToTrash(r.Context(), nodeID, nodeToDelete.OwnerID)
+		success, err := q.MoveNodeToTrashInBatch(r.Context(), nodeID, nodeToDelete.OwnerID, batchID)
 		if err != nil {
 			return err
 		}
 		if !success {
 			return database.ErrNodeNotFound
 		}
+		if err := s.syncTrashedStorage(r.Context(), q, nodeToDelete.OwnerID); err != nil {
+			return err
+		}
 
 		var parentID string
 		if nodeToDelete.ParentID != nil {
 			parentID = *nodeToDelete.ParentID
 		}
 
-		payload := map[string]string{"id": nodeID, "parent_id": parentID}
+		payload := map[string]string{"id": nodeID, "parent_id": parentID, "deletion_batch_id": batchID.String()}
 		err = q.LogEvent(r.Context(), claims.UserID, "node_trashed", payload)
 		if err != nil {
 			return err
@@ -508,10 +820,10 @@ func (s *Server) DeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	if txErr != nil {
 		if errors.Is(txErr, database.ErrNodeNotFound) {
-			http.Error(w, "Node not found or you do not have permission to delete it", http.StatusNotFound)
+			s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotDeletable)
 			return
 		}
-		http.Error(w, "Failed to delete node", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeDeleteFailed)
 		return
 	}
 
@@ -519,7 +831,7 @@ func (s *Server) DeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
 	if nodeToDelete.ParentID != nil {
 		parentID = *nodeToDelete.ParentID
 	}
-	payload := map[string]string{"id": nodeID, "parent_id": parentID}
+	payload := map[string]string{"id": nodeID, "parent_id": parentID, "deletion_batch_id": batchID.String()}
 	eventMsg := map[string]interface{}{"event_type": "node_trashed", "payload": payload}
 	eventBytes, _ := json.Marshal(eventMsg)
 
@@ -527,6 +839,13 @@ func (s *Server) DeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
 	if claims.UserID != nodeToDelete.OwnerID {
 		s.wsHub.PublishEvent(nodeToDelete.OwnerID, eventBytes)
 	}
+	if nodeToDelete.ParentID != nil {
+		s.notifyWatchers(r.Context(), []string{*nodeToDelete.ParentID}, eventBytes, claims.UserID, nodeToDelete.OwnerID)
+	}
+
+	s.issueUndoToken(w, r, nodeToDelete, database.UndoOperationTrash,
+		database.NodePlacement{Name: nodeToDelete.Name, ParentID: nodeToDelete.ParentID},
+		database.NodePlacement{Name: nodeToDelete.Name, DeletionBatchID: &batchID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -537,19 +856,20 @@ type UpdateNodeRequest struct {
 }
 
 // @Summary      Update a node
-// @Description  Updates a node's properties, such as its name or parent folder. To move a node to the root directory, provide "root" as the parent_id. Moving nodes between different owners is not allowed. Requires write permission in the source and target folders.
+// @Description  Updates a node's properties, such as its name or parent folder. To move a node to the root directory, provide "root" as the parent_id. Moving nodes between different owners is not allowed. Requires write permission in the source and target folders. The response carries an X-Undo-Token header that can be passed to POST /undo/{token} until X-Undo-Expires-At.
 // @Tags         nodes
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
 // @Param        nodeId         path      string             true  "Node ID to update"
 // @Param        updateRequest  body      UpdateNodeRequest  true  "Properties to update"
-// @Success      200            {object}  NodeResponse
+// @Success      200            {object}  dto.Node
 // @Failure      400            {string}  string "Bad Request - Invalid operation (e.g., moving between owners, circular move)"
 // @Failure      401            {string}  string "Unauthorized"
 // @Failure      403            {string}  string "Forbidden - Write permission denied"
 // @Failure      404            {string}  string "Not Found"
 // @Failure      409            {string}  string "Conflict"
+// @Failure      422            {string}  string "Unprocessable Entity - Folder depth or children limit exceeded"
 // @Failure      500            {string}  string "Internal Server Error"
 // @Router       /nodes/{nodeId} [patch]
 func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
@@ -558,17 +878,17 @@ func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	originalNode, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve node", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
 		return
 	}
 	if originalNode == nil {
-		http.Error(w, "Node not found or you do not have permission to modify it", http.StatusNotFound)
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotModifiable)
 		return
 	}
 
 	var req UpdateNodeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 
@@ -578,17 +898,17 @@ func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
 	if req.Name != nil {
 		hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, originalNode.ParentID)
 		if err != nil {
-			http.Error(w, "Failed to verify permissions for renaming", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.RenamePermissionCheckFailed)
 			return
 		}
 		if !hasPermission {
-			http.Error(w, "You do not have permission to rename items in this folder", http.StatusForbidden)
+			writeError(w, r, http.StatusForbidden, i18n.RenamePermissionDenied)
 			return
 		}
 
 		newName := strings.TrimSpace(*req.Name)
 		if newName == "" {
-			http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, i18n.EmptyName)
 			return
 		}
 
@@ -613,14 +933,14 @@ func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 		if txErr != nil {
 			if errors.Is(txErr, database.ErrDuplicateNodeName) {
-				http.Error(w, txErr.Error(), http.StatusConflict)
+				writeError(w, r, http.StatusConflict, i18n.DuplicateNameInFolder)
 				return
 			}
 			if errors.Is(txErr, database.ErrNodeNotFound) {
-				http.Error(w, "Node not found or you do not have permission to modify it", http.StatusNotFound)
+				s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotModifiable)
 				return
 			}
-			http.Error(w, "Failed to rename node", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.NodeRenameFailed)
 			return
 		}
 
@@ -632,6 +952,7 @@ func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
 			s.wsHub.PublishEvent(originalNode.OwnerID, eventBytes)
 			ownerNotified = true
 		}
+		s.notifyWatchers(r.Context(), []string{nodeID}, eventBytes, claims.UserID, originalNode.OwnerID)
 		updated = true
 	}
 
@@ -641,131 +962,110 @@ func (s *Server) UpdateNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 		if newParentIDStr != "root" {
 			if len(newParentIDStr) != 21 {
-				http.Error(w, "Invalid ParentID format", http.StatusBadRequest)
+				writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
 				return
 			}
 			newParentID = &newParentIDStr
 		}
 
-		var destParentNode *models.Node
-		var destOwnerID int64 = claims.UserID
-
-		if newParentID != nil {
-			var err error
-			destParentNode, err = s.store.GetNodeIfAccessible(r.Context(), *newParentID, claims.UserID)
-			if err != nil || destParentNode == nil {
-				http.Error(w, "Target folder not found or access denied", http.StatusNotFound)
-				return
-			}
-			destOwnerID = destParentNode.OwnerID
-		}
-
-		if originalNode.OwnerID != destOwnerID {
-			http.Error(w, "Moving files between different owners is not allowed. Please copy the file instead.", http.StatusBadRequest)
-			return
-		}
-
-		hasPermissionSource, err := s.store.CheckWritePermission(r.Context(), claims.UserID, originalNode.ParentID)
-		if err != nil {
-			http.Error(w, "Failed to verify source permissions", http.StatusInternalServerError)
-			return
-		}
-		if !hasPermissionSource {
-			http.Error(w, "You do not have permission to move this item", http.StatusForbidden)
-			return
-		}
-
-		hasPermissionDest, err := s.store.CheckWritePermission(r.Context(), claims.UserID, newParentID)
-		if err != nil {
-			http.Error(w, "Failed to verify target permissions", http.StatusInternalServerError)
-			return
-		}
-		if !hasPermissionDest {
-			http.Error(w, "You do not have permission to move items into the target folder", http.StatusForbidden)
+		if err := s.moveNode(r.Context(), claims.UserID, originalNode, newParentID, !ownerNotified); err != nil {
+			writeOpError(w, r, err, i18n.NodeMoveFailed)
 			return
 		}
+		updated = true
+	}
 
-		if originalNode.NodeType == "folder" {
-			var potentialParentID string
-			if newParentID != nil {
-				potentialParentID = *newParentID
-			}
-			isCircular, err := s.store.IsDescendantOf(r.Context(), nodeID, potentialParentID)
-			if err != nil {
-				http.Error(w, "Failed to validate move operation", http.StatusInternalServerError)
-				return
-			}
-			if isCircular {
-				http.Error(w, "Cannot move a folder into itself or one of its subfolders", http.StatusBadRequest)
-				return
-			}
-		}
+	if !updated {
+		writeError(w, r, http.StatusBadRequest, i18n.NoUpdateSpecified)
+		return
+	}
 
-		txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
-			success, err := q.MoveNode(r.Context(), nodeID, originalNode.OwnerID, newParentID)
-			if err != nil {
-				return err
-			}
-			if !success {
-				return database.ErrNodeNotFound
-			}
+	updatedNode, _ := s.store.GetNodeByID(r.Context(), nodeID, originalNode.OwnerID)
+	if updatedNode != nil {
+		s.issueUndoToken(w, r, updatedNode, database.UndoOperationUpdate,
+			database.NodePlacement{Name: originalNode.Name, ParentID: originalNode.ParentID},
+			database.NodePlacement{Name: updatedNode.Name, ParentID: updatedNode.ParentID})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dto.NodePtr(updatedNode))
+}
 
-			payload := map[string]interface{}{"id": nodeID, "new_parent_id": req.ParentID, "old_parent_id": originalNode.ParentID}
-			err = q.LogEvent(r.Context(), claims.UserID, "node_moved", payload)
-			if err != nil {
-				return err
-			}
+type CopyNodeRequest struct {
+	// ParentID is the target folder; "root" or null copies into the user's root.
+	ParentID *string `json:"parent_id" example:"bNowyFolderRodzic123"`
+}
 
-			if claims.UserID != originalNode.OwnerID {
-				err = q.LogEvent(r.Context(), originalNode.OwnerID, "node_moved", payload)
-			}
-			return err
-		})
+// @Summary      Copy a node
+// @Description  Deep-copies a file or a folder with everything below it into a target folder, which may belong to another user (e.g. copying a file out of a share). The copies get new IDs and their own content, belong to the owner of the target folder and count against that owner's quota. Versions, shares and favorites are not copied. Every copied node is announced with a node_created event. Not available for files shared at a fixed version.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId       path      string           true  "Node ID to copy"
+// @Param        copyRequest  body      CopyNodeRequest  true  "Target folder"
+// @Success      201          {object}  dto.Node "The copy of the node"
+// @Failure      400          {string}  string "Bad Request - Invalid target or circular copy"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      403          {string}  string "Forbidden - Write permission denied"
+// @Failure      404          {string}  string "Not Found"
+// @Failure      409          {string}  string "Conflict - A node with the same name already exists in the target folder"
+// @Failure      413          {string}  string "Storage quota of the target owner exceeded"
+// @Failure      422          {string}  string "Unprocessable Entity - Folder depth or children limit exceeded"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/copy [post]
+func (s *Server) CopyNodeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
 
-		if txErr != nil {
-			if errors.Is(txErr, database.ErrDuplicateNodeName) {
-				http.Error(w, "A node with the same name already exists in the target folder", http.StatusConflict)
-				return
-			}
-			if strings.Contains(txErr.Error(), "target folder does not exist") {
-				http.Error(w, txErr.Error(), http.StatusBadRequest)
-				return
-			}
-			http.Error(w, "Failed to move node", http.StatusInternalServerError)
+	var req CopyNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	var parentID *string
+	if req.ParentID != nil && *req.ParentID != "root" {
+		if len(*req.ParentID) != 21 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
 			return
 		}
+		parentID = req.ParentID
+	}
 
-		payload := map[string]interface{}{"id": nodeID, "new_parent_id": req.ParentID, "old_parent_id": originalNode.ParentID}
-		eventMsg := map[string]interface{}{"event_type": "node_moved", "payload": payload}
-		eventBytes, _ := json.Marshal(eventMsg)
-		s.wsHub.PublishEvent(claims.UserID, eventBytes)
-		if !ownerNotified && claims.UserID != originalNode.OwnerID {
-			s.wsHub.PublishEvent(originalNode.OwnerID, eventBytes)
-		}
-		updated = true
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
+		return
 	}
 
-	if !updated {
-		http.Error(w, "No update operation specified (provide 'name' or 'parent_id')", http.StatusBadRequest)
+	copied, err := s.copyNodeTree(r.Context(), claims.UserID, node, parentID)
+	if err != nil {
+		writeOpError(w, r, err, i18n.NodeCopyFailed)
 		return
 	}
 
-	updatedNode, _ := s.store.GetNodeByID(r.Context(), nodeID, originalNode.OwnerID)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(updatedNode)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.NodeFrom(copied[0]))
 }
 
 // @Summary      Download an archive
-// @Description  Downloads multiple files and/or folders as a single ZIP archive.
+// @Description  Downloads multiple files and/or folders, owned by the user or shared with them, as a single ZIP, tar or tar.gz archive. Tar archives cost less to produce for huge folders; tar.gz ones are compressed at archive.gzip_level. The archive is streamed while the folders are walked. Archives over limits.max_archive_entries entries or limits.max_archive_size_mb of file content are refused with 413. Entries keep the nodes' modification times. With manifest=true the archive also contains a root manifest.json listing node IDs, paths and SHA-256 hashes, for re-import.
 // @Tags         nodes
-// @Produce      application/zip
+// @Produce      application/zip,application/x-tar,application/gzip
 // @Security     BearerAuth
-// @Param        ids    query     string  true  "Comma-separated list of Node IDs to include in the archive"
-// @Success      200    {file}    binary  "The ZIP archive content"
+// @Param        ids       query     string  true   "Comma-separated list of Node IDs to include in the archive"
+// @Param        manifest  query     bool    false  "Include manifest.json describing the archived nodes"
+// @Param        format    query     string  false  "Archive format: zip, tar or tar.gz" default(zip)
+// @Success      200    {file}    binary  "The archive content"
 // @Failure      400    {string}  string "Bad Request"
 // @Failure      401    {string}  string "Unauthorized"
 // @Failure      404    {string}  string "Not Found - one of the nodes does not exist"
+// @Failure      413    {string}  string "The archive would exceed the entry or size limit"
 // @Failure      500    {string}  string "Internal Server Error"
 // @Router       /nodes/archive [get]
 func (s *Server) DownloadArchiveHandler(w http.ResponseWriter, r *http.Request) {
@@ -773,77 +1073,107 @@ func (s *Server) DownloadArchiveHandler(w http.ResponseWriter, r *http.Request)
 
 	idsQuery := r.URL.Query().Get("ids")
 	if idsQuery == "" {
-		http.Error(w, "Node IDs are required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.NodeIDsRequired)
+		return
+	}
+	includeManifest := r.URL.Query().Get("manifest") == "true"
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = archiveFormatZip
+	}
+	if !isArchiveFormat(format) {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidDownloadFormat)
 		return
 	}
-	nodeIDs := strings.Split(idsQuery, ",")
-
-	nodesToPack := make(map[string]models.Node)
-	nodePaths := make(map[string]string)
 
-	var collectNodes func(nodeID, currentPath string) error
-	collectNodes = func(nodeID, currentPath string) error {
-		if _, exists := nodesToPack[nodeID]; exists {
-			return nil
+	var roots []models.Node
+	selected := make(map[string]bool)
+	for _, id := range strings.Split(idsQuery, ",") {
+		if selected[id] {
+			continue
 		}
-
-		node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+		node, err := s.store.GetNodeIfAccessible(r.Context(), id, claims.UserID)
 		if err != nil {
-			return fmt.Errorf("database error for node %s: %w", nodeID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+			return
 		}
 		if node == nil {
-			return fmt.Errorf("node with ID %s not found or you do not have permission to access it", nodeID)
-		}
-
-		fullPath := path.Join(currentPath, node.Name)
-		nodesToPack[node.ID] = *node
-		nodePaths[node.ID] = fullPath
-
-		if node.NodeType == "folder" {
-			children, err := s.store.GetNodesByParentID(r.Context(), claims.UserID, &node.ID, MaxLimit, 0) // TODO: unlimited limit for zipping
-			if err != nil {
-				return fmt.Errorf("could not list children of folder %s: %w", node.Name, err)
-			}
-			for _, child := range children {
-				if err := collectNodes(child.ID, fullPath); err != nil {
-					return err
-				}
-			}
+			s.writeNodeNotFound(w, r, id, i18n.NodeWithIDNotAccessible, id)
+			return
 		}
-		return nil
+		selected[id] = true
+		roots = append(roots, *node)
 	}
 
-	for _, id := range nodeIDs {
-		if err := collectNodes(id, ""); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+	// A node selected together with one of its ancestors is already packed
+	// below it.
+	rootIDs := make([]string, 0, len(roots))
+	topLevel := roots[:0]
+	for _, node := range roots {
+		ancestry, err := s.store.ListNodeAncestry(r.Context(), node.ID, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
 			return
 		}
+		nested := false
+		for _, ancestor := range ancestry {
+			nested = nested || (ancestor.Depth > 0 && selected[ancestor.NodeID])
+		}
+		if !nested {
+			topLevel = append(topLevel, node)
+			rootIDs = append(rootIDs, node.ID)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", `attachment; filename="archive.zip"`)
+	entries, totalBytes, err := s.store.GetSubtreeTotals(r.Context(), rootIDs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveMeasureFailed)
+		return
+	}
+	if entries > s.maxArchiveEntries() || totalBytes > s.maxArchiveBytes() {
+		writeErrorf(w, r, http.StatusRequestEntityTooLarge, i18n.ArchiveTooLarge, entries, totalBytes, s.maxArchiveEntries(), s.maxArchiveBytes())
+		return
+	}
 
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+	for _, node := range topLevel {
+		s.recordAccess(r, claims.UserID, node.ID, node.OwnerID, "archive_download")
+	}
 
-	for id, node := range nodesToPack {
-		fullPath := nodePaths[id]
+	writer, contentType, fileName, err := s.newArchiveWriter(format, w)
+	if err != nil {
+		log.Printf("ERROR: Failed to start %s archive: %v", format, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ArchiveCreateFailed)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+
+	walker := &archiveWalker{
+		s:          s,
+		userID:     claims.UserID,
+		writer:     writer,
+		maxEntries: s.maxArchiveEntries(),
+		maxBytes:   s.maxArchiveBytes(),
+	}
+	if includeManifest {
+		walker.manifest = &ArchiveManifest{Version: archiveManifestVersion, CreatedAt: time.Now().UTC(), Entries: []ArchiveManifestEntry{}}
+	}
+	for _, node := range topLevel {
+		if err := walker.add(r.Context(), node, node.Name); err != nil {
+			// The response has started; leaving the archive unfinished
+			// (without the ZIP central directory or the tar end marker)
+			// makes the failure visible to the client.
+			log.Printf("ERROR: Archive download of user %d aborted: %v", claims.UserID, err)
+			return
+		}
+	}
 
-		if node.NodeType == "folder" {
-			zipWriter.Create(fullPath + "/")
-		} else {
-			fileWriter, err := zipWriter.Create(fullPath)
-			if err != nil {
-				log.Printf("ERROR creating entry in zip for %s: %v", node.Name, err)
-				continue
-			}
-			fileStream, err := s.storage.Get(node.ID)
-			if err != nil {
-				log.Printf("ERROR getting file stream for %s: %v", node.Name, err)
-				continue
-			}
-			io.Copy(fileWriter, fileStream)
-			fileStream.Close()
+	if includeManifest {
+		if err := walker.writer.writeManifest(*walker.manifest); err != nil {
+			log.Printf("ERROR writing archive manifest: %v", err)
 		}
 	}
+	if err := walker.writer.Close(); err != nil {
+		log.Printf("ERROR: Failed to finish archive of user %d: %v", claims.UserID, err)
+	}
 }
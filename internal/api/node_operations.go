@@ -0,0 +1,435 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// opError is a node operation refused for a reason the client can act on,
+// reported with its own status code and i18n message.
+type opError struct {
+	status int
+	code   string
+	args   []any
+}
+
+func newOpError(status int, code string, args ...any) *opError {
+	return &opError{status: status, code: code, args: args}
+}
+
+func (e *opError) Error() string {
+	return i18n.Messagef(e.code, i18n.English, e.args...)
+}
+
+func (e *opError) errorCode() (string, []any) {
+	return e.code, e.args
+}
+
+// writeOpError reports err from moveNode or copyNodeTree. Unexpected errors
+// are logged and answered with the fallback code as an internal error.
+func writeOpError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	var refused *opError
+	switch {
+	case errors.As(err, &refused):
+		writeLocalizedError(w, r, refused.status, refused)
+	case errors.Is(err, errFolderDepthExceeded), errors.Is(err, errFolderChildrenExceeded):
+		writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+	default:
+		log.Printf("ERROR: %s: %v", i18n.Message(fallback, i18n.English), err)
+		writeError(w, r, http.StatusInternalServerError, fallback)
+	}
+}
+
+// opErrorMessage is the message reported for err in per-item results of
+// batch operations.
+func opErrorMessage(err error) string {
+	var refused *opError
+	if errors.As(err, &refused) || errors.Is(err, errFolderDepthExceeded) || errors.Is(err, errFolderChildrenExceeded) {
+		return err.Error()
+	}
+	return "Internal server error"
+}
+
+// moveNode moves node into newParentID (the owner's root when nil) on behalf
+// of userID after checking permissions, ownership and tree limits, and
+// publishes the node_moved event. notifyOwner is false when the owner has
+// already been sent a WebSocket event for the same request.
+func (s *Server) moveNode(ctx context.Context, userID int64, node *models.Node, newParentID *string, notifyOwner bool) error {
+	destOwnerID := userID
+	if newParentID != nil {
+		destParentNode, err := s.store.GetNodeIfAccessible(ctx, *newParentID, userID)
+		if err != nil || destParentNode == nil {
+			return newOpError(http.StatusNotFound, i18n.TargetFolderNotAccessible)
+		}
+		destOwnerID = destParentNode.OwnerID
+	}
+
+	if node.OwnerID != destOwnerID {
+		return newOpError(http.StatusBadRequest, i18n.CrossOwnerMove)
+	}
+
+	hasPermissionSource, err := s.store.CheckWritePermission(ctx, userID, node.ParentID)
+	if err != nil {
+		return fmt.Errorf("failed to verify source permissions: %w", err)
+	}
+	if !hasPermissionSource {
+		return newOpError(http.StatusForbidden, i18n.MovePermissionDenied)
+	}
+
+	hasPermissionDest, err := s.store.CheckWritePermission(ctx, userID, newParentID)
+	if err != nil {
+		return fmt.Errorf("failed to verify target permissions: %w", err)
+	}
+	if !hasPermissionDest {
+		return newOpError(http.StatusForbidden, i18n.MoveIntoPermissionDenied)
+	}
+
+	if node.NodeType == "folder" {
+		var potentialParentID string
+		if newParentID != nil {
+			potentialParentID = *newParentID
+		}
+		isCircular, err := s.store.IsDescendantOf(ctx, node.ID, potentialParentID)
+		if err != nil {
+			return fmt.Errorf("failed to validate move operation: %w", err)
+		}
+		if isCircular {
+			return newOpError(http.StatusBadRequest, i18n.MoveIntoItself)
+		}
+	}
+
+	height, err := s.store.GetSubtreeHeight(ctx, node.ID)
+	if err != nil {
+		return fmt.Errorf("failed to verify folder limits: %w", err)
+	}
+	if err := s.checkPlacementLimits(ctx, destOwnerID, newParentID, 1, height); err != nil {
+		return err
+	}
+
+	newParentValue := "root"
+	if newParentID != nil {
+		newParentValue = *newParentID
+	}
+	payload := map[string]interface{}{"id": node.ID, "new_parent_id": newParentValue, "old_parent_id": node.ParentID}
+
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		success, err := q.MoveNode(ctx, node.ID, node.OwnerID, newParentID)
+		if err != nil {
+			return err
+		}
+		if !success {
+			return database.ErrNodeNotFound
+		}
+
+		err = q.LogEvent(ctx, userID, "node_moved", payload)
+		if err != nil {
+			return err
+		}
+
+		if userID != node.OwnerID {
+			err = q.LogEvent(ctx, node.OwnerID, "node_moved", payload)
+		}
+		return err
+	})
+
+	if txErr != nil {
+		if errors.Is(txErr, database.ErrDuplicateNodeName) {
+			return newOpError(http.StatusConflict, i18n.DuplicateNameInTarget)
+		}
+		if errors.Is(txErr, database.ErrNodeNotFound) {
+			return newOpError(http.StatusNotFound, i18n.NodeNotModifiable)
+		}
+		if strings.Contains(txErr.Error(), "target folder does not exist") {
+			return newOpError(http.StatusBadRequest, i18n.TargetFolderMissing)
+		}
+		return txErr
+	}
+
+	eventMsg := map[string]interface{}{"event_type": "node_moved", "payload": payload}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(userID, eventBytes)
+	if notifyOwner && userID != node.OwnerID {
+		s.wsHub.PublishEvent(node.OwnerID, eventBytes)
+	}
+	watchedNodes := []string{node.ID}
+	if node.ParentID != nil {
+		watchedNodes = append(watchedNodes, *node.ParentID)
+	}
+	s.notifyWatchers(ctx, watchedNodes, eventBytes, userID, node.OwnerID)
+	return nil
+}
+
+// copyNodeTree deep-copies source with everything below it into destParentID
+// (userID's root when nil). The copies get new IDs, share the deduplicated
+// content of their sources and belong to the owner of the target folder, whose
+// quota is charged. Versions, shares and favorites are not copied. It returns
+// the copied nodes, the copy of source first.
+func (s *Server) copyNodeTree(ctx context.Context, userID int64, source *models.Node, destParentID *string) ([]models.Node, error) {
+	destOwnerID := userID
+	if destParentID != nil {
+		destParentNode, err := s.store.GetNodeIfAccessible(ctx, *destParentID, userID)
+		if err != nil || destParentNode == nil || destParentNode.NodeType != "folder" {
+			return nil, newOpError(http.StatusNotFound, i18n.TargetFolderNotAccessible)
+		}
+		destOwnerID = destParentNode.OwnerID
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(ctx, userID, destParentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify target permissions: %w", err)
+	}
+	if !hasPermission {
+		return nil, newOpError(http.StatusForbidden, i18n.CopyIntoPermissionDenied)
+	}
+
+	pinned, err := s.pinnedVersionFor(ctx, source, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify share version: %w", err)
+	}
+	if pinned != nil {
+		return nil, newOpError(http.StatusForbidden, i18n.FixedVersionShare)
+	}
+
+	if source.NodeType == "folder" && destParentID != nil {
+		isCircular, err := s.store.IsDescendantOf(ctx, source.ID, *destParentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate copy operation: %w", err)
+		}
+		if isCircular {
+			return nil, newOpError(http.StatusBadRequest, i18n.CopyIntoItself)
+		}
+	}
+
+	height, err := s.store.GetSubtreeHeight(ctx, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify folder limits: %w", err)
+	}
+	if err := s.checkPlacementLimits(ctx, destOwnerID, destParentID, 1, height); err != nil {
+		return nil, err
+	}
+
+	subtree, err := s.store.ListSubtreeNodes(ctx, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes to copy: %w", err)
+	}
+	if len(subtree) == 0 {
+		return nil, newOpError(http.StatusNotFound, i18n.NodeNotFoundOrDenied)
+	}
+
+	var totalBytes int64
+	fileIDs := make([]string, 0, len(subtree))
+	for _, node := range subtree {
+		if node.NodeType == "file" {
+			fileIDs = append(fileIDs, node.ID)
+			if node.SizeBytes != nil {
+				totalBytes += *node.SizeBytes
+			}
+		}
+	}
+	quarantined, err := s.store.QuarantinedNodeIDs(ctx, fileIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check quarantined files: %w", err)
+	}
+	if len(quarantined) > 0 {
+		return nil, newOpError(http.StatusForbidden, i18n.QuarantinedFile)
+	}
+	owner, err := s.store.GetUserByID(ctx, destOwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load owner of the target folder: %w", err)
+	}
+	if owner == nil {
+		return nil, fmt.Errorf("owner %d of the target folder not found", destOwnerID)
+	}
+	if owner.StorageUsedBytes+totalBytes > owner.StorageQuotaBytes {
+		return nil, newOpError(http.StatusRequestEntityTooLarge, i18n.TargetQuotaExceeded)
+	}
+
+	// copiedFile is where the content of a copied file ends up. A copy in the
+	// backend already holding the source's deduplicated blob shares it;
+	// otherwise the content is copied under the new ID and deduplicated in the
+	// transaction.
+	type copiedFile struct {
+		backendName   string
+		backend       storage.Backend
+		contentSHA256 string
+		shared        bool
+		duplicate     bool
+		// placedKey is the blob moved into place for content new to the
+		// backend, removed again if the transaction fails.
+		placedKey string
+	}
+	newIDs := make(map[string]string, len(subtree))
+	copiedFiles := make(map[string]*copiedFile)
+	cleanup := func() {
+		for id, file := range copiedFiles {
+			if file.shared {
+				continue
+			}
+			if err := file.backend.Delete(id); err != nil {
+				log.Printf("CRITICAL: Failed to clean up copied file %s: %v", id, err)
+			}
+		}
+	}
+
+	for _, node := range subtree {
+		newID, err := s.generateUniqueID(ctx)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		newIDs[node.ID] = newID
+		if node.NodeType != "file" {
+			continue
+		}
+
+		var size int64
+		if node.SizeBytes != nil {
+			size = *node.SizeBytes
+		}
+		backendName, backend, err := s.routeContent(size, node.MimeType)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		sourceName, sourceKey, sourceBackend, err := s.nodeBackend(ctx, s.store.Queries, node.ID)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to open file %s: %w", node.ID, err)
+		}
+		if sourceName == backendName && sourceKey != node.ID && node.ContentSHA256 != nil {
+			copiedFiles[newID] = &copiedFile{backendName: backendName, backend: backend, contentSHA256: *node.ContentSHA256, shared: true}
+			continue
+		}
+
+		blob, err := sourceBackend.Get(sourceKey)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to open file %s: %w", node.ID, err)
+		}
+		hasher := sha256.New()
+		err = backend.Save(newID, io.TeeReader(blob, hasher))
+		blob.Close()
+		if err != nil {
+			backend.Delete(newID)
+			cleanup()
+			return nil, fmt.Errorf("failed to copy file %s: %w", node.ID, err)
+		}
+		copiedFiles[newID] = &copiedFile{backendName: backendName, backend: backend, contentSHA256: hexSum(hasher)}
+	}
+
+	copied := make([]models.Node, 0, len(subtree))
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		for i, node := range subtree {
+			parentID := destParentID
+			if i > 0 {
+				newParentID := newIDs[*node.ParentID]
+				parentID = &newParentID
+			}
+			params := database.CreateNodeParams{
+				ID:        newIDs[node.ID],
+				OwnerID:   destOwnerID,
+				ParentID:  parentID,
+				Name:      node.Name,
+				NodeType:  node.NodeType,
+				SizeBytes: node.SizeBytes,
+				MimeType:  node.MimeType,
+			}
+			if file, ok := copiedFiles[params.ID]; ok {
+				var size int64
+				if node.SizeBytes != nil {
+					size = *node.SizeBytes
+				}
+				key := contentBlobKey(file.contentSHA256)
+				if file.shared {
+					refs, err := q.AcquireContentBlob(ctx, file.backendName, key, size)
+					if err != nil {
+						return err
+					}
+					if refs == 1 {
+						return fmt.Errorf("content of file %s was removed during the copy", node.ID)
+					}
+				} else {
+					storedKey, duplicate, err := s.storeContentBlob(ctx, q, file.backend, params.ID, file.backendName, file.backend, file.contentSHA256, size)
+					if err != nil {
+						return err
+					}
+					key, file.duplicate = storedKey, duplicate
+					if !duplicate {
+						file.placedKey = storedKey
+					}
+				}
+				params.StorageBackend = file.backendName
+				params.ContentSHA256 = &file.contentSHA256
+				params.StorageKey = &key
+			}
+			created, err := q.CreateNode(ctx, params)
+			if err != nil {
+				return err
+			}
+			copied = append(copied, *created)
+		}
+
+		if err := q.UpdateUserStorage(ctx, destOwnerID, totalBytes); err != nil {
+			return err
+		}
+
+		entries := make([]database.EventEntry, 0, len(copied)*2)
+		for i := range copied {
+			entries = append(entries, database.EventEntry{UserID: userID, EventType: "node_created", Payload: &copied[i]})
+			if userID != destOwnerID {
+				entries = append(entries, database.EventEntry{UserID: destOwnerID, EventType: "node_created", Payload: &copied[i]})
+			}
+		}
+		return q.LogEvents(ctx, entries)
+	})
+
+	if txErr != nil {
+		cleanup()
+		for _, file := range copiedFiles {
+			if file.placedKey != "" {
+				s.discardPlacedBlob(ctx, file.backendName, file.backend, file.placedKey)
+			}
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(txErr, &pgErr) && pgErr.Code == "23505" {
+			return nil, newOpError(http.StatusConflict, i18n.DuplicateNameInTarget)
+		}
+		return nil, txErr
+	}
+	for id, file := range copiedFiles {
+		if file.duplicate {
+			if err := file.backend.Delete(id); err != nil {
+				log.Printf("WARN: Failed to delete duplicate content %s: %v", id, err)
+			}
+		}
+	}
+
+	for _, node := range copied {
+		eventMsg := map[string]interface{}{"event_type": "node_created", "payload": node}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(userID, eventBytes)
+		if userID != destOwnerID {
+			s.wsHub.PublishEvent(destOwnerID, eventBytes)
+		}
+	}
+	if destParentID != nil {
+		eventMsg := map[string]interface{}{"event_type": "node_created", "payload": copied[0]}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.notifyWatchers(ctx, []string{*destParentID}, eventBytes, userID, destOwnerID)
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// deviceIDHeader names the device a sync client runs on. Without it the
+// session the request was made in stands for the device.
+const deviceIDHeader = "X-Device-ID"
+
+const maxDeviceIDLength = 64
+
+// requestDeviceID returns the device a request comes from, or an empty string
+// when neither the header nor the token identifies one.
+func requestDeviceID(r *http.Request, claims *auth.AppClaims) string {
+	if deviceID := strings.TrimSpace(r.Header.Get(deviceIDHeader)); deviceID != "" {
+		return deviceID
+	}
+	return claims.SessionID
+}
+
+// offlinePinDevice resolves the device of a pin request, writing an error
+// response and returning false when it cannot be determined.
+func offlinePinDevice(w http.ResponseWriter, r *http.Request, claims *auth.AppClaims) (string, bool) {
+	deviceID := requestDeviceID(r, claims)
+	if deviceID == "" {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.DeviceUnknown, deviceIDHeader)
+		return "", false
+	}
+	if len(deviceID) > maxDeviceIDLength {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.DeviceIDTooLong, deviceIDHeader)
+		return "", false
+	}
+	return deviceID, true
+}
+
+func (s *Server) publishOfflinePinEvent(userID int64, eventType string, payload map[string]string) {
+	eventMsg := map[string]interface{}{"event_type": eventType, "payload": payload}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(userID, eventBytes)
+}
+
+// @Summary      Keep a node offline
+// @Description  Marks a file or folder as kept offline on the current device, a hint for sync clients about what to cache. The device is named by the X-Device-ID header or, without it, is the current session. Every session of the user receives an "offline_pin_added" event naming the node and the device, so clients can coordinate. Pinning an already pinned node does nothing.
+// @Tags         offline
+// @Security     BearerAuth
+// @Param        nodeId       path      string  true   "Node ID to keep offline"
+// @Param        X-Device-ID  header    string  false  "Identifier of the device, at most 64 characters"
+// @Success      204          {null}    nil "No Content"
+// @Failure      400          {string}  string "Bad Request - The device could not be determined"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      404          {string}  string "Not Found - Node does not exist or user lacks access"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/offline [post]
+func (s *Server) PinNodeOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+	deviceID, ok := offlinePinDevice(w, r, claims)
+	if !ok {
+		return
+	}
+
+	payload := map[string]string{"node_id": nodeID, "device_id": deviceID}
+	var pinned bool
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		pinned, err = q.PinNodeOffline(r.Context(), claims.UserID, deviceID, nodeID)
+		if err != nil || !pinned {
+			return err
+		}
+		return q.LogEvent(r.Context(), claims.UserID, "offline_pin_added", payload)
+	})
+	if txErr != nil {
+		if errors.Is(txErr, database.ErrNodeNotFound) {
+			s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotAccessible)
+			return
+		}
+		log.Printf("ERROR: Failed to pin node %s offline for user %d: %v", nodeID, claims.UserID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.OfflinePinFailed)
+		return
+	}
+
+	if pinned {
+		s.publishOfflinePinEvent(claims.UserID, "offline_pin_added", payload)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Stop keeping a node offline
+// @Description  Removes the offline pin of a file or folder on the current device, identified as when pinning. Every session of the user receives an "offline_pin_removed" event.
+// @Tags         offline
+// @Security     BearerAuth
+// @Param        nodeId       path      string  true   "Node ID"
+// @Param        X-Device-ID  header    string  false  "Identifier of the device, at most 64 characters"
+// @Success      204          {null}    nil "No Content"
+// @Failure      400          {string}  string "Bad Request - The device could not be determined"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      404          {string}  string "Not Found - Node is not pinned on this device"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/offline [delete]
+func (s *Server) UnpinNodeOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+	deviceID, ok := offlinePinDevice(w, r, claims)
+	if !ok {
+		return
+	}
+
+	payload := map[string]string{"node_id": nodeID, "device_id": deviceID}
+	var removed bool
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		removed, err = q.UnpinNodeOffline(r.Context(), claims.UserID, deviceID, nodeID)
+		if err != nil || !removed {
+			return err
+		}
+		return q.LogEvent(r.Context(), claims.UserID, "offline_pin_removed", payload)
+	})
+	if txErr != nil {
+		log.Printf("ERROR: Failed to unpin node %s for user %d: %v", nodeID, claims.UserID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.OfflineUnpinFailed)
+		return
+	}
+	if !removed {
+		writeError(w, r, http.StatusNotFound, i18n.NotPinnedOffline)
+		return
+	}
+
+	s.publishOfflinePinEvent(claims.UserID, "offline_pin_removed", payload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      List nodes kept offline
+// @Description  Lists the files and folders the current user keeps offline, with the device each is pinned on. By default only the pins of the current device (see X-Device-ID) are listed; ?device=all lists the pins of every device and ?device=<id> those of another one. Pins of nodes in the trash or no longer accessible are omitted.
+// @Tags         offline
+// @Produce      json
+// @Security     BearerAuth
+// @Param        device       query     string  false  "Device whose pins to list, or \"all\""
+// @Param        X-Device-ID  header    string  false  "Identifier of the current device"
+// @Param        limit        query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset       query     int     false  "Number of items to skip" default(0)
+// @Param        fields       query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,name,device_id"
+// @Success      200          {array}   dto.OfflinePin
+// @Failure      400          {string}  string "Bad Request - The device could not be determined"
+// @Failure      401          {string}  string "Unauthorized"
+// @Failure      500          {string}  string "Internal Server Error"
+// @Router       /offline-pins [get]
+func (s *Server) ListOfflinePinsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	deviceID := r.URL.Query().Get("device")
+	switch deviceID {
+	case "all":
+		deviceID = ""
+	case "":
+		var ok bool
+		if deviceID, ok = offlinePinDevice(w, r, claims); !ok {
+			return
+		}
+	}
+
+	pins, err := s.store.ReadReplica().ListOfflinePins(r.Context(), claims.UserID, deviceID, limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list offline pins of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.OfflinePinsListFailed)
+		return
+	}
+
+	writeListing(w, r, dto.OfflinePins(pins))
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+)
+
+const (
+	// onboardingBatch is how many new users a worker onboards at once.
+	onboardingBatch        = 50
+	maxOnboardingTemplates = 20
+)
+
+type OnboardingTemplatesRequest struct {
+	// NodeIDs are files or folders of the administrator copied, with their
+	// contents, into the root of every new user. An empty list stops
+	// onboarding content.
+	NodeIDs []string `json:"node_ids" example:"V1StGXR8_Z5jdHi6B-myT"`
+}
+
+// onboardNewUsers copies the onboarding templates into the root of users
+// created since the last run, however they were created. Users are marked as
+// onboarded before the copy, so a failed copy is logged and not repeated
+// rather than risking a second set of copies.
+func (s *Server) onboardNewUsers(ctx context.Context) error {
+	for ctx.Err() == nil {
+		userIDs, err := s.store.ClaimUsersToOnboard(ctx, onboardingBatch)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+
+		templates, err := s.store.ListOnboardingTemplates(ctx)
+		if err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			for i := range templates {
+				if templates[i].OwnerID == userID {
+					continue
+				}
+				if _, err := s.copyNodeTree(ctx, userID, &templates[i], nil); err != nil {
+					log.Printf("ERROR: Failed to copy onboarding template %s for user %d: %v", templates[i].ID, userID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// @Summary      List onboarding templates
+// @Description  Returns the files and folders copied into the root of every new user.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   dto.Node
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden - Administrator privileges required"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /admin/onboarding/templates [get]
+func (s *Server) ListOnboardingTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.store.ListOnboardingTemplates(r.Context())
+	if err != nil {
+		log.Printf("ERROR: Failed to list onboarding templates: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.OnboardingListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.Nodes(templates))
+}
+
+// @Summary      Set onboarding templates
+// @Description  Replaces the onboarding templates: files and folders of the administrator (e.g. a welcome folder or a manual) that are deep-copied into the root of every user created afterwards, however the account was created (e.g. with scripts/add-user.ps1). A background job picks up new users within 30 seconds; the copies belong to and count against the quota of the new user. Existing users are not affected.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      OnboardingTemplatesRequest  true  "Template nodes"
+// @Success      200      {array}   dto.Node
+// @Failure      400      {string}  string "Bad Request - Too many templates"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Administrator privileges required"
+// @Failure      404      {string}  string "Node not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /admin/onboarding/templates [put]
+func (s *Server) SetOnboardingTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req OnboardingTemplatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if len(req.NodeIDs) > maxOnboardingTemplates {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.TooManyOnboardingTemplates, maxOnboardingTemplates)
+		return
+	}
+
+	seen := make(map[string]bool, len(req.NodeIDs))
+	nodeIDs := make([]string, 0, len(req.NodeIDs))
+	for _, nodeID := range req.NodeIDs {
+		if seen[nodeID] {
+			continue
+		}
+		seen[nodeID] = true
+		node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+			return
+		}
+		if node == nil {
+			writeErrorf(w, r, http.StatusNotFound, i18n.NodeNotAmongYourFiles, nodeID)
+			return
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+
+	err := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		return q.SetOnboardingTemplates(r.Context(), claims.UserID, nodeIDs)
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to set onboarding templates: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.OnboardingSaveFailed)
+		return
+	}
+	s.ListOnboardingTemplatesHandler(w, r)
+}
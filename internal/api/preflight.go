@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strings"
+)
+
+const (
+	preflightUpload = "upload"
+	preflightMove   = "move"
+)
+
+// Codes of the blockers a preflight check reports.
+const (
+	blockerNotFound         = "not_found"
+	blockerPermissionDenied = "permission_denied"
+	blockerQuotaExceeded    = "quota_exceeded"
+	blockerDepthExceeded    = "depth_exceeded"
+	blockerChildrenExceeded = "children_exceeded"
+	blockerNameConflict     = "name_conflict"
+	blockerCrossOwner       = "cross_owner"
+	blockerCircularMove     = "circular_move"
+)
+
+type PreflightRequest struct {
+	// Operation is "upload" or "move".
+	Operation string `json:"operation" example:"upload"`
+	// ParentID is the target folder; "root" or null is the user's root.
+	ParentID *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// FileName and SizeBytes describe the file of an upload. Without a name
+	// name conflicts are not checked.
+	FileName  string `json:"file_name,omitempty" example:"nagranie.mp4"`
+	SizeBytes int64  `json:"size_bytes,omitempty" example:"5368709120"`
+	// NodeID is the file or folder to move.
+	NodeID string `json:"node_id,omitempty" example:"bNowyFolderRodzic123"`
+}
+
+type PreflightBlocker struct {
+	Code    string `json:"code" example:"quota_exceeded"`
+	Message string `json:"message" example:"The upload would exceed the storage quota by 1048576 bytes"`
+}
+
+type PreflightResponse struct {
+	// OK is true when nothing is known to stand in the way of the operation.
+	OK       bool               `json:"ok" example:"false"`
+	Blockers []PreflightBlocker `json:"blockers"`
+}
+
+// preflight collects the blockers found for a planned operation.
+type preflight struct {
+	s        *Server
+	userID   int64
+	blockers []PreflightBlocker
+}
+
+func (p *preflight) block(code, message string) {
+	p.blockers = append(p.blockers, PreflightBlocker{Code: code, Message: message})
+}
+
+// checkWrite reports a blocker when the user cannot write in a folder.
+func (p *preflight) checkWrite(ctx context.Context, parentID *string, message string) error {
+	allowed, err := p.s.store.CheckWritePermission(ctx, p.userID, parentID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		p.block(blockerPermissionDenied, message)
+	}
+	return nil
+}
+
+// checkPlacement reports the folder limits that placing a subtree height
+// levels deep into parentID would exceed. Both limits are checked, so both
+// can be reported.
+func (p *preflight) checkPlacement(ctx context.Context, ownerID int64, parentID *string, height int) error {
+	err := p.s.checkPlacementLimits(ctx, ownerID, parentID, 0, height)
+	switch {
+	case errors.Is(err, errFolderDepthExceeded):
+		p.block(blockerDepthExceeded, err.Error())
+	case err != nil && !errors.Is(err, errFolderChildrenExceeded):
+		return err
+	}
+	err = p.s.checkPlacementLimits(ctx, ownerID, parentID, 1, 0)
+	switch {
+	case errors.Is(err, errFolderChildrenExceeded):
+		p.block(blockerChildrenExceeded, err.Error())
+	case err != nil && !errors.Is(err, errFolderDepthExceeded):
+		return err
+	}
+	return nil
+}
+
+func (p *preflight) checkName(ctx context.Context, ownerID int64, parentID *string, name string) error {
+	taken, err := p.s.store.NodeNameTaken(ctx, ownerID, parentID, name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		p.block(blockerNameConflict, fmt.Sprintf("A node named %q already exists in the target folder", name))
+	}
+	return nil
+}
+
+func (p *preflight) checkUpload(ctx context.Context, target *models.Node, parentID *string, req PreflightRequest) error {
+	ownerID := p.userID
+	if target != nil {
+		ownerID = target.OwnerID
+	}
+	if err := p.checkWrite(ctx, parentID, "You do not have permission to create items in the target folder"); err != nil {
+		return err
+	}
+
+	owner, err := p.s.store.GetUserByID(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	if owner == nil {
+		return fmt.Errorf("owner %d not found", ownerID)
+	}
+	if over := owner.StorageUsedBytes + req.SizeBytes - owner.StorageQuotaBytes; over > 0 {
+		p.block(blockerQuotaExceeded, fmt.Sprintf("The upload would exceed the storage quota by %d bytes", over))
+	}
+
+	if err := p.checkPlacement(ctx, ownerID, parentID, 1); err != nil {
+		return err
+	}
+	if req.FileName != "" {
+		return p.checkName(ctx, ownerID, parentID, req.FileName)
+	}
+	return nil
+}
+
+// checkMove mirrors the checks of moveNode. A move keeps the node with its
+// owner, so it never affects the quota.
+func (p *preflight) checkMove(ctx context.Context, target *models.Node, parentID *string, nodeID string) error {
+	node, err := p.s.store.GetNodeIfAccessible(ctx, nodeID, p.userID)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		p.block(blockerNotFound, "Node not found or access denied")
+		return nil
+	}
+
+	ownerID := p.userID
+	if target != nil {
+		ownerID = target.OwnerID
+	}
+	if node.OwnerID != ownerID {
+		p.block(blockerCrossOwner, "Moving files between different owners is not allowed. Please copy the file instead.")
+	}
+	if err := p.checkWrite(ctx, node.ParentID, "You do not have permission to move this item"); err != nil {
+		return err
+	}
+	if err := p.checkWrite(ctx, parentID, "You do not have permission to move items into the target folder"); err != nil {
+		return err
+	}
+
+	if node.NodeType == "folder" && parentID != nil {
+		circular, err := p.s.store.IsDescendantOf(ctx, node.ID, *parentID)
+		if err != nil {
+			return err
+		}
+		if circular {
+			p.block(blockerCircularMove, "Cannot move a folder into itself or one of its subfolders")
+			return nil
+		}
+	}
+
+	height, err := p.s.store.GetSubtreeHeight(ctx, node.ID)
+	if err != nil {
+		return err
+	}
+	if err := p.checkPlacement(ctx, node.OwnerID, parentID, height); err != nil {
+		return err
+	}
+	if sameParent := (node.ParentID == nil && parentID == nil) || (node.ParentID != nil && parentID != nil && *node.ParentID == *parentID); sameParent {
+		return nil
+	}
+	return p.checkName(ctx, node.OwnerID, parentID, node.Name)
+}
+
+// @Summary      Check a planned operation
+// @Description  Validates an upload of size_bytes (optionally named file_name) into a folder, or a move of a file or folder with everything below it, without performing it. All blockers found are returned at once, each with a code: not_found, permission_denied, quota_exceeded, depth_exceeded, children_exceeded, name_conflict, cross_owner or circular_move. Clients can thus fail fast before streaming gigabytes. The answer reflects the moment of the check; the operation itself checks again.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      PreflightRequest   true  "Planned operation"
+// @Success      200      {object}  PreflightResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/preflight [post]
+func (s *Server) PreflightHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req PreflightRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.FileName = strings.TrimSpace(req.FileName)
+	switch req.Operation {
+	case preflightUpload:
+		if req.SizeBytes < 0 {
+			writeError(w, r, http.StatusBadRequest, i18n.NegativeSize)
+			return
+		}
+		if len(req.FileName) > 255 {
+			writeError(w, r, http.StatusBadRequest, i18n.FileNameTooLong)
+			return
+		}
+	case preflightMove:
+		if len(req.NodeID) != 21 {
+			writeError(w, r, http.StatusBadRequest, i18n.MoveNodeRequired)
+			return
+		}
+	default:
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidPreflightOperation)
+		return
+	}
+	parentID := req.ParentID
+	if parentID != nil && *parentID == "root" {
+		parentID = nil
+	}
+	if parentID != nil && len(*parentID) != 21 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+		return
+	}
+
+	check := &preflight{s: s, userID: claims.UserID}
+	var target *models.Node
+	if parentID != nil {
+		var err error
+		target, err = s.store.GetNodeIfAccessible(r.Context(), *parentID, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+			return
+		}
+		if target == nil || target.NodeType != "folder" {
+			check.block(blockerNotFound, "Target folder not found or access denied")
+		}
+	}
+
+	if len(check.blockers) == 0 {
+		var err error
+		if req.Operation == preflightUpload {
+			err = check.checkUpload(r.Context(), target, parentID, req)
+		} else {
+			err = check.checkMove(r.Context(), target, parentID, req.NodeID)
+		}
+		if err != nil {
+			log.Printf("ERROR: Preflight check of a %s for user %d failed: %v", req.Operation, claims.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.PreflightFailed)
+			return
+		}
+	}
+
+	if check.blockers == nil {
+		check.blockers = []PreflightBlocker{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PreflightResponse{OK: len(check.blockers) == 0, Blockers: check.blockers})
+}
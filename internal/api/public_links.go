@@ -0,0 +1,757 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PublicNode is a file or folder as shown to visitors of a public link.
+type PublicNode struct {
+	ID         string    `json:"id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Name       string    `json:"name" example:"Raport_Q3.pdf"`
+	NodeType   string    `json:"node_type" example:"file"`
+	SizeBytes  *int64    `json:"size_bytes,omitempty" example:"1048576"`
+	MimeType   *string   `json:"mime_type,omitempty" example:"application/pdf"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type PublicFolderResponse struct {
+	Folder PublicNode   `json:"folder"`
+	Items  []PublicNode `json:"items"`
+	// UploadOnly marks upload links, whose folder contents are never listed.
+	UploadOnly bool `json:"upload_only,omitempty" example:"false"`
+}
+
+// linkPasswordHeader carries the password of a protected public link.
+const linkPasswordHeader = "X-Link-Password"
+
+type CreatePublicLinkRequest struct {
+	// Type is "download" (default) or "upload"; upload links accept files
+	// into a folder without showing its contents.
+	Type string `json:"type,omitempty" example:"upload"`
+	// Password protects the link; visitors send it in the X-Link-Password header.
+	Password string `json:"password,omitempty" example:"tajne-haslo"`
+	// ExpiresAt ends the link; it must be in the future.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxDownloads limits how many files can be downloaded through the link.
+	MaxDownloads *int64 `json:"max_downloads,omitempty" example:"10"`
+	// MaxTransferBytes caps the bytes downloaded through the link, e.g. to
+	// keep a hot-linked file from draining the server's bandwidth.
+	MaxTransferBytes *int64 `json:"max_transfer_bytes,omitempty" example:"10737418240"`
+	// Slug is a short name opening the link as /public/{slug}: 3 to 30
+	// letters, digits and dashes, unique across links and ignoring case.
+	Slug string `json:"slug,omitempty" example:"raport-q3"`
+}
+
+// UpdatePublicLinkRequest changes only the settings present in the body; a
+// setting sent as null is removed.
+type UpdatePublicLinkRequest struct {
+	Password     json.RawMessage `json:"password,omitempty" swaggertype:"string" example:"nowe-haslo"`
+	ExpiresAt    json.RawMessage `json:"expires_at,omitempty" swaggertype:"string" format:"date-time"`
+	MaxDownloads json.RawMessage `json:"max_downloads,omitempty" swaggertype:"integer" example:"20"`
+	// MaxTransferBytes changes the transfer cap; the bytes already served
+	// keep counting.
+	MaxTransferBytes json.RawMessage `json:"max_transfer_bytes,omitempty" swaggertype:"integer" example:"21474836480"`
+	Slug             json.RawMessage `json:"slug,omitempty" swaggertype:"string" example:"raport-q3"`
+}
+
+func publicNode(node models.Node) PublicNode {
+	return PublicNode{
+		ID:         node.ID,
+		Name:       node.Name,
+		NodeType:   node.NodeType,
+		SizeBytes:  node.SizeBytes,
+		MimeType:   node.MimeType,
+		ModifiedAt: node.ModifiedAt,
+	}
+}
+
+// @Summary      Create a public link
+// @Description  Creates a link giving anyone who knows its token read access to an owned file or folder, without an account: GET /public/{token} downloads the file or lists the folder. A node can have several links, e.g. one per recipient, each revoked separately. With type "upload" the link points to a folder and lets visitors add files to it through POST /public/{token}/files without seeing its contents, e.g. to collect documents. The body is optional and can protect the link with a password, make it expire, limit the number of downloads, or cap the bytes downloaded through it (max_transfer_bytes, only for download links). A slug gives the link a short name that opens it in place of the token, e.g. /public/raport-q3; GET /public/{token}/qr returns a QR code of the link.
+// @Tags         links
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId   path      string                   true   "Node ID"
+// @Param        request  body      CreatePublicLinkRequest  false  "Link restrictions"
+// @Success      201      {object}  dto.PublicLink
+// @Failure      400      {string}  string "Bad Request - Unknown type, upload link to a file, expiry in the past, non-positive limit, transfer cap on an upload link or invalid slug"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - The content policy does not allow sharing the content"
+// @Failure      404      {string}  string "Node not found or you are not its owner"
+// @Failure      409      {string}  string "Conflict - Another link already has the slug"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/links [post]
+func (s *Server) CreatePublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	var req CreatePublicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if req.Type == "" {
+		req.Type = database.PublicLinkDownload
+	}
+	if req.Type != database.PublicLinkDownload && req.Type != database.PublicLinkUpload {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidLinkType)
+		return
+	}
+	if code := validatePublicLinkLimits(req.ExpiresAt, req.MaxDownloads, req.MaxTransferBytes); code != "" {
+		writeError(w, r, http.StatusBadRequest, code)
+		return
+	}
+	if req.Type == database.PublicLinkUpload && req.MaxTransferBytes != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.TransferCapOnUploadLink)
+		return
+	}
+	settings := database.PublicLinkSettings{ExpiresAt: req.ExpiresAt, MaxDownloads: req.MaxDownloads, MaxTransferBytes: req.MaxTransferBytes}
+	if req.Slug != "" {
+		slug, err := normalizeLinkSlug(req.Slug)
+		if err != nil {
+			writeLocalizedError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		settings.Slug = &slug
+	}
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PasswordHashFailed)
+			return
+		}
+		settings.PasswordHash = &hash
+	}
+
+	node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotOwnedByYou)
+		return
+	}
+	if req.Type == database.PublicLinkUpload && node.NodeType != "folder" {
+		writeError(w, r, http.StatusBadRequest, i18n.UploadLinkToFile)
+		return
+	}
+	// Upload links reveal nothing, so only download links are checked.
+	if req.Type == database.PublicLinkDownload {
+		if err := s.checkShareContent(r.Context(), node); err != nil {
+			var policyErr *contentPolicyError
+			if errors.As(err, &policyErr) {
+				writeLocalizedError(w, r, http.StatusForbidden, err)
+				return
+			}
+			log.Printf("ERROR: Content policy failed for linked node %s: %v", node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.SharedContentCheckFailed)
+			return
+		}
+	}
+
+	token, err := ids.Token()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkTokenFailed)
+		return
+	}
+	link, err := s.store.CreatePublicLink(r.Context(), token, node.ID, claims.UserID, req.Type, settings)
+	if errors.Is(err, database.ErrPublicLinkSlugTaken) {
+		writeError(w, r, http.StatusConflict, i18n.LinkSlugTaken)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to create public link to node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkCreateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.PublicLinkFrom(*link))
+}
+
+// @Summary      List my public links
+// @Description  Lists the user's public links, newest first, with how often they were used to download files and how many bytes they served.
+// @Tags         links
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset  query     int     false  "Number of items to skip" default(0)
+// @Success      200     {array}   dto.PublicLink
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /links [get]
+func (s *Server) ListPublicLinksHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	links, err := s.store.ListPublicLinks(r.Context(), claims.UserID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.PublicLinks(links))
+}
+
+func validatePublicLinkLimits(expiresAt *time.Time, maxDownloads, maxTransferBytes *int64) string {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return i18n.LinkExpiryInPast
+	}
+	if maxDownloads != nil && *maxDownloads <= 0 {
+		return i18n.InvalidLinkMaxDownloads
+	}
+	if maxTransferBytes != nil && *maxTransferBytes <= 0 {
+		return i18n.InvalidLinkTransferCap
+	}
+	return ""
+}
+
+// decodeLinkSetting decodes a setting of UpdatePublicLinkRequest. It reports
+// whether the setting was present; a null leaves dst nil.
+func decodeLinkSetting[T any](raw json.RawMessage, dst **T) (bool, error) {
+	if len(raw) == 0 {
+		return false, nil
+	}
+	if string(raw) == "null" {
+		*dst = nil
+		return true, nil
+	}
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false, err
+	}
+	*dst = &value
+	return true, nil
+}
+
+// @Summary      Update a public link
+// @Description  Changes the password, expiry, download limit, transfer cap or slug of a link. Only the settings present in the body change; a setting sent as null is removed. Lowering the download limit to the number of downloads so far ends the link; raising the transfer cap above the bytes served so far reopens it.
+// @Tags         links
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                      true  "Link ID"
+// @Param        request  body      UpdatePublicLinkRequest  true  "Changed settings"
+// @Success      200      {object}  dto.PublicLink
+// @Failure      400      {string}  string "Bad Request - Invalid link ID, empty password, expiry in the past, non-positive limit or invalid slug"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Link not found"
+// @Failure      409      {string}  string "Conflict - Another link already has the slug"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /links/{id} [patch]
+func (s *Server) UpdatePublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	linkID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidLinkID)
+		return
+	}
+
+	var req UpdatePublicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	var update database.PublicLinkUpdate
+	var password *string
+	if update.SetPassword, err = decodeLinkSetting(req.Password, &password); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if update.SetExpiresAt, err = decodeLinkSetting(req.ExpiresAt, &update.ExpiresAt); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if update.SetMaxDownloads, err = decodeLinkSetting(req.MaxDownloads, &update.MaxDownloads); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if update.SetMaxTransferBytes, err = decodeLinkSetting(req.MaxTransferBytes, &update.MaxTransferBytes); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if update.SetSlug, err = decodeLinkSetting(req.Slug, &update.Slug); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if update.Slug != nil {
+		slug, err := normalizeLinkSlug(*update.Slug)
+		if err != nil {
+			writeLocalizedError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		update.Slug = &slug
+	}
+	if password != nil && *password == "" {
+		writeError(w, r, http.StatusBadRequest, i18n.EmptyLinkPassword)
+		return
+	}
+	if code := validatePublicLinkLimits(update.ExpiresAt, update.MaxDownloads, update.MaxTransferBytes); code != "" {
+		writeError(w, r, http.StatusBadRequest, code)
+		return
+	}
+	if password != nil {
+		hash, err := auth.HashPassword(*password)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PasswordHashFailed)
+			return
+		}
+		update.PasswordHash = &hash
+	}
+
+	link, err := s.store.UpdatePublicLink(r.Context(), linkID, claims.UserID, update)
+	if errors.Is(err, database.ErrPublicLinkSlugTaken) {
+		writeError(w, r, http.StatusConflict, i18n.LinkSlugTaken)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to update public link %d: %v", linkID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkUpdateFailed)
+		return
+	}
+	if link == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.PublicLinkFrom(*link))
+}
+
+// @Summary      Revoke a public link
+// @Description  Removes a public link. It stops working at once; other links to the same node keep working.
+// @Tags         links
+// @Security     BearerAuth
+// @Param        id   path      int     true  "Link ID"
+// @Success      204  {null}    nil     "No Content"
+// @Failure      400  {string}  string "Bad Request - Invalid link ID"
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      404  {string}  string "Link not found"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /links/{id} [delete]
+func (s *Server) DeletePublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	linkID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidLinkID)
+		return
+	}
+
+	deleted, err := s.store.DeletePublicLink(r.Context(), linkID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkRevokeFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Open a public link
+// @Description  Needs no account. Downloads the linked file, or lists the linked folder. Inside a folder, node_id selects a subfolder to list or a file to download. A link to a trashed node, or one that was revoked, is not found. A password-protected link needs the password in the X-Link-Password header; after 10 wrong passwords for the link, or 20 from one address, within 15 minutes, further attempts are refused with 429 until the period ends. An expired link, or one that used up its downloads, is gone. A link that served as many bytes as its transfer cap allows answers 429, with an HTML page for browsers, until the owner raises or removes the cap; the download crossing the cap is still completed. An upload link only describes its folder, with upload_only set and no items.
+// @Tags         links
+// @Produce      json
+// @Produce      octet-stream
+// @Param        token            path      string  true   "Link token or slug"
+// @Param        node_id          query     string  false  "File or folder inside the linked folder"
+// @Param        limit            query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset           query     int     false  "Number of items to skip" default(0)
+// @Param        X-Link-Password  header    string  false  "Password of a protected link"
+// @Success      200              {object}  PublicFolderResponse
+// @Failure      401              {string}  string "Unauthorized - Missing or wrong link password"
+// @Failure      403              {string}  string "Forbidden - The file is quarantined"
+// @Failure      404              {string}  string "Not Found"
+// @Failure      410              {string}  string "Gone - The link expired or reached its download limit"
+// @Failure      429              {string}  string "Too Many Requests - The link reached its transfer cap, or too many wrong passwords were sent"
+// @Failure      500              {string}  string "Internal Server Error"
+// @Router       /public/{token} [get]
+func (s *Server) OpenPublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, err := s.store.GetPublicLinkByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkLookupFailed)
+		return
+	}
+	if link == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+	if !s.checkPublicLinkAccess(w, r, link) {
+		return
+	}
+	root, err := s.store.GetNodeByID(r.Context(), link.NodeID, link.OwnerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if root == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+	if link.LinkType == database.PublicLinkUpload {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PublicFolderResponse{Folder: publicNode(*root), Items: []PublicNode{}, UploadOnly: true})
+		return
+	}
+
+	node, err := s.resolveSharedNode(r.Context(), root, r.URL.Query().Get("node_id"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNodeNotFound)
+		return
+	}
+
+	if node.NodeType == "folder" {
+		s.writePublicFolder(w, r, node)
+		return
+	}
+	s.servePublicFile(w, r, link, node)
+}
+
+// checkPublicLinkAccess writes the error response and returns false when the
+// link expired, used up its downloads or transfer cap, or needs a password
+// the visitor did not give. After too many wrong passwords for the link, or
+// from the visitor's address, further attempts are refused for a while.
+func (s *Server) checkPublicLinkAccess(w http.ResponseWriter, r *http.Request, link *database.PublicLink) bool {
+	if link.Expired(time.Now()) {
+		writeError(w, r, http.StatusGone, i18n.LinkExpired)
+		return false
+	}
+	if link.Exhausted() {
+		writeError(w, r, http.StatusGone, i18n.LinkDownloadLimitReached)
+		return false
+	}
+	if link.TransferCapReached() {
+		writeTransferCapReached(w, r)
+		return false
+	}
+	if link.PasswordHash == nil {
+		return true
+	}
+	password := r.Header.Get(linkPasswordHeader)
+	if password == "" {
+		writeError(w, r, http.StatusUnauthorized, i18n.LinkPasswordRequired)
+		return false
+	}
+	clientIP := clientIPFromRequest(r)
+	if wait := s.linkPasswords.lockedFor(link.ID, clientIP, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeError(w, r, http.StatusTooManyRequests, i18n.TooManyLinkPasswords)
+		return false
+	}
+	if !auth.CheckPasswordHash(password, *link.PasswordHash) {
+		s.linkPasswords.fail(link.ID, clientIP, time.Now())
+		writeError(w, r, http.StatusUnauthorized, i18n.WrongLinkPassword)
+		return false
+	}
+	return true
+}
+
+func (s *Server) writePublicFolder(w http.ResponseWriter, r *http.Request, folder *models.Node) {
+	limit, offset := parsePagination(r)
+	children, err := s.store.GetNodesByParentID(r.Context(), folder.OwnerID, &folder.ID, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FolderListFailed)
+		return
+	}
+	response := PublicFolderResponse{Folder: publicNode(*folder), Items: make([]PublicNode, 0, len(children))}
+	for _, child := range children {
+		response.Items = append(response.Items, publicNode(child))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) servePublicFile(w http.ResponseWriter, r *http.Request, link *database.PublicLink, node *models.Node) {
+	if quarantined, err := s.isQuarantined(r.Context(), node.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	} else if quarantined {
+		writeError(w, r, http.StatusForbidden, i18n.QuarantinedFile)
+		return
+	}
+
+	content, err := s.openNodeContent(r.Context(), node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMissingFromStorage)
+		return
+	}
+	defer content.Close()
+
+	counted, err := s.store.RecordPublicLinkDownload(r.Context(), link.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to count download through public link %d: %v", link.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkLookupFailed)
+		return
+	}
+	if !counted {
+		writeError(w, r, http.StatusGone, i18n.LinkDownloadLimitReached)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", node.Name))
+	if node.MimeType != nil && *node.MimeType != "" {
+		w.Header().Set("Content-Type", *node.MimeType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if node.SizeBytes != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*node.SizeBytes, 10))
+	}
+	cw := &countingResponseWriter{ResponseWriter: w}
+	io.Copy(cw, content)
+
+	transfer := database.RecordDownloadTransferParams{
+		NodeID:         node.ID,
+		OwnerID:        node.OwnerID,
+		PublicLink:     true,
+		Kind:           database.TransferFull,
+		BytesRequested: cw.written,
+		BytesServed:    cw.written,
+	}
+	if node.SizeBytes != nil {
+		transfer.BytesRequested = *node.SizeBytes
+	}
+	s.recordTransfer(r, transfer)
+	s.recordPublicLinkTransfer(context.WithoutCancel(r.Context()), link, node, cw.written)
+}
+
+// transferCapPage is shown to browsers opening a link that reached its
+// transfer cap, instead of a bare error.
+const transferCapPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Link unavailable</title></head>
+<body>
+<h1>This link is temporarily unavailable</h1>
+<p>The files behind it were downloaded so often that the link reached its transfer limit.</p>
+<p>Ask the person who shared it for a new link, or try again after they raise the limit.</p>
+</body>
+</html>
+`
+
+func writeTransferCapReached(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeError(w, r, http.StatusTooManyRequests, i18n.LinkTransferCapReached)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	io.WriteString(w, transferCapPage)
+}
+
+// recordPublicLinkTransfer adds a download to the bytes served by the link
+// and, when it made the link reach its transfer cap, journals and pushes a
+// public_link_transfer_cap_reached event to the owner.
+func (s *Server) recordPublicLinkTransfer(ctx context.Context, link *database.PublicLink, node *models.Node, bytes int64) {
+	capReached, err := s.store.RecordPublicLinkTransfer(ctx, link.ID, bytes)
+	if err != nil {
+		log.Printf("ERROR: Failed to count bytes served through public link %d: %v", link.ID, err)
+		return
+	}
+	if !capReached {
+		return
+	}
+	payload := map[string]interface{}{
+		"link_id":            link.ID,
+		"node_id":            link.NodeID,
+		"node_name":          link.NodeName,
+		"file_id":            node.ID,
+		"max_transfer_bytes": link.MaxTransferBytes,
+	}
+	if err := s.store.LogEvent(ctx, link.OwnerID, "public_link_transfer_cap_reached", payload); err != nil {
+		log.Printf("ERROR: Failed to journal transfer cap of public link %d: %v", link.ID, err)
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "public_link_transfer_cap_reached", "payload": payload})
+	s.wsHub.PublishEvent(link.OwnerID, eventBytes)
+}
+
+// @Summary      Upload through a public link
+// @Description  Needs no account. Adds files, sent as multipart "file" fields, to the folder of an upload link. The visitor never sees the folder's contents: a name already taken gets a numbered suffix, e.g. "praca (2).pdf". The files count against the storage quota of the folder's owner, who receives node_created events. A password-protected link needs the password in the X-Link-Password header, with the same limit on wrong passwords as when opening the link; on a link with a limit, each file uses one of its downloads.
+// @Tags         links
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        token            path      string  true   "Link token or slug"
+// @Param        file             formData  file    true   "Files to upload (the field can be repeated)"
+// @Param        X-Link-Password  header    string  false  "Password of a protected link"
+// @Success      201              {array}   PublicNode
+// @Failure      400              {string}  string "Bad Request - No files"
+// @Failure      401              {string}  string "Unauthorized - Missing or wrong link password"
+// @Failure      403              {string}  string "Forbidden - Not an upload link, or the content policy rejected a file"
+// @Failure      404              {string}  string "Link not found"
+// @Failure      410              {string}  string "Gone - The link expired or reached its limit"
+// @Failure      413              {string}  string "Request Entity Too Large - The owner's storage quota would be exceeded"
+// @Failure      415              {string}  string "Unsupported Media Type"
+// @Failure      429              {string}  string "Too Many Requests - Too many wrong passwords were sent"
+// @Failure      500              {string}  string "Internal Server Error"
+// @Router       /public/{token}/files [post]
+func (s *Server) UploadToPublicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, err := s.store.GetPublicLinkByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.LinkLookupFailed)
+		return
+	}
+	if link == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+	if link.LinkType != database.PublicLinkUpload {
+		writeError(w, r, http.StatusForbidden, i18n.LinkUploadNotAllowed)
+		return
+	}
+	if !s.checkPublicLinkAccess(w, r, link) {
+		return
+	}
+	folder, err := s.store.GetNodeByID(r.Context(), link.NodeID, link.OwnerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if folder == nil {
+		writeError(w, r, http.StatusNotFound, i18n.LinkNotFound)
+		return
+	}
+
+	if !s.limitRequestBody(w, r) {
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		if s.writeRequestTooLarge(w, err) {
+			return
+		}
+		writeErrorf(w, r, http.StatusBadRequest, i18n.MultipartParseFailed, err)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.NoFilesUploaded)
+		return
+	}
+	if !s.checkFileCount(w, len(files)) {
+		return
+	}
+	var totalUploadSize int64
+	for _, handler := range files {
+		if !s.checkFileSize(w, handler.Filename, handler.Size) {
+			return
+		}
+		totalUploadSize += handler.Size
+	}
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), link.OwnerID, &folder.ID, len(files), 1)) {
+		return
+	}
+
+	mimeTypes := make([]string, len(files))
+	quarantines := make([]*contentpolicy.Decision, len(files))
+	for i, handler := range files {
+		file, err := handler.Open()
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UploadReadFailed, handler.Filename)
+			return
+		}
+		mimeTypes[i], err = sniffFile(file, handler.Filename, handler.Header.Get("Content-Type"))
+		file.Close()
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UploadReadFailed, handler.Filename)
+			return
+		}
+		if err := s.checkContentType(handler.Filename, mimeTypes[i]); err != nil {
+			writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+			return
+		}
+		described := contentpolicy.File{Name: handler.Filename, MimeType: mimeTypes[i], SizeBytes: handler.Size}
+		quarantines[i], err = s.evaluateUpload(r.Context(), described, func() (io.ReadCloser, error) { return handler.Open() })
+		if err != nil {
+			var policyErr *contentPolicyError
+			if errors.As(err, &policyErr) {
+				writeLocalizedError(w, r, http.StatusForbidden, err)
+				return
+			}
+			log.Printf("ERROR: Content policy failed for file %s uploaded through public link %d: %v", handler.Filename, link.ID, err)
+			writeErrorf(w, r, http.StatusInternalServerError, i18n.UploadCheckFailed, handler.Filename)
+			return
+		}
+	}
+
+	owner, err := s.store.GetUserByID(r.Context(), link.OwnerID)
+	if err != nil || owner == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
+		return
+	}
+	if owner.StorageUsedBytes+totalUploadSize > owner.StorageQuotaBytes {
+		writeError(w, r, http.StatusRequestEntityTooLarge, i18n.StorageQuotaExceeded)
+		return
+	}
+
+	var created []models.Node
+	limitReached := false
+	for i, handler := range files {
+		counted, err := s.store.RecordPublicLinkDownload(r.Context(), link.ID)
+		if err != nil {
+			log.Printf("ERROR: Failed to count upload through public link %d: %v", link.ID, err)
+			break
+		}
+		if !counted {
+			limitReached = true
+			break
+		}
+		name, err := s.freeNodeName(r.Context(), link.OwnerID, &folder.ID, handler.Filename)
+		if err != nil {
+			log.Printf("ERROR: No free name for file %s uploaded through public link %d: %v", handler.Filename, link.ID, err)
+			continue
+		}
+		node, err := s.storeUploadedFile(r.Context(), link.OwnerID, link.OwnerID, &folder.ID, handler, name, mimeTypes[i], quarantines[i])
+		if err != nil {
+			continue
+		}
+		created = append(created, *node)
+		if quarantines[i] != nil {
+			s.notifyQuarantine(r.Context(), node, contentpolicy.ActionUpload, quarantines[i])
+		}
+	}
+	if len(created) == 0 {
+		if limitReached {
+			writeError(w, r, http.StatusGone, i18n.LinkUploadLimitReached)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, i18n.NoFilesProcessed)
+		return
+	}
+
+	s.publishUploadedNodes(r.Context(), link.OwnerID, &link.OwnerID, &folder.ID, created)
+	created = s.applyOrganizationRules(r.Context(), link.OwnerID, created)
+
+	response := make([]PublicNode, 0, len(created))
+	for _, node := range created {
+		response = append(response, publicNode(node))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
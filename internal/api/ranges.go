@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxByteRanges caps the ranges served in one multipart response; requests
+// asking for more get the whole file instead.
+const maxByteRanges = 16
+
+var (
+	errInvalidRange        = errors.New("invalid Range header")
+	errRangeNotSatisfiable = errors.New("no requested range overlaps the file")
+)
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseByteRanges parses a Range header against a file of the given size.
+// Ranges that start past the end of the file are dropped and the rest are
+// clamped to it; errRangeNotSatisfiable is returned when nothing is left.
+// Malformed headers, units other than bytes and too many ranges give
+// errInvalidRange, upon which the header is to be ignored.
+func parseByteRanges(header string, size int64) ([]byteRange, error) {
+	specs, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, errInvalidRange
+	}
+
+	parts := strings.Split(specs, ",")
+	if len(parts) > maxByteRanges {
+		return nil, errInvalidRange
+	}
+	ranges := make([]byteRange, 0, len(parts))
+	for _, part := range parts {
+		first, last, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found {
+			return nil, errInvalidRange
+		}
+
+		if first == "" {
+			// A suffix range: the last N bytes.
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, errInvalidRange
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			suffix = min(suffix, size)
+			ranges = append(ranges, byteRange{start: size - suffix, length: suffix})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, errInvalidRange
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether a Range header may be honoured: without
+// If-Range it always may, otherwise only if the validator names the current
+// content, either by its strong ETag or by its exact Last-Modified date.
+func ifRangeMatches(r *http.Request, etag string, lastModified *time.Time) bool {
+	value := strings.TrimSpace(r.Header.Get("If-Range"))
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, "\"") {
+		return value == etag
+	}
+	if lastModified == nil {
+		return false
+	}
+	date, err := http.ParseTime(value)
+	return err == nil && lastModified.Truncate(time.Second).Equal(date)
+}
+
+// serveByteRanges answers with a 206 holding the requested ranges of a blob: a
+// single range as the body, several as multipart/byteranges. Content-Type and
+// the other entity headers must already be set.
+func serveByteRanges(w http.ResponseWriter, r *http.Request, backend storage.Backend, key string, ranges []byteRange, size int64) {
+	if len(ranges) == 1 {
+		stream, err := backend.GetRange(key, ranges[0].start, ranges[0].length)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.ContentMissing)
+			return
+		}
+		defer stream.Close()
+
+		w.Header().Set("Content-Range", ranges[0].contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.Copy(w, stream)
+		return
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, br := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {br.contentRange(size)},
+		})
+		if err != nil {
+			return
+		}
+		stream, err := backend.GetRange(key, br.start, br.length)
+		if err != nil {
+			log.Printf("ERROR: Failed to read range %s of %s: %v", br.contentRange(size), key, err)
+			return
+		}
+		_, err = io.Copy(part, stream)
+		stream.Close()
+		if err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
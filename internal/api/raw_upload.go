@@ -0,0 +1,166 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strings"
+)
+
+// @Summary      Upload a file as the request body
+// @Description  Uploads a single file sent as the raw request body, for clients such as mobile share sheets where building a multipart form is awkward. The file name and parent folder are given in the query and the length in Content-Length, which is required. Otherwise it works like POST /nodes/file: the same write permission, size limits, storage quota, folder limits, type detection (the Content-Type header refines a generic result), content policy and organization rules apply, and an X-Content-SHA256 header has the file verified.
+// @Tags         nodes
+// @Accept       application/octet-stream
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name              query     string  true   "File name"
+// @Param        parent_id         query     string  false  "ID of the parent folder"
+// @Param        Content-Length    header    int     true   "Size of the file in bytes"
+// @Param        X-Content-SHA256  header    string  false  "Hex SHA-256 checksum of the file"
+// @Param        file              body      string  true   "The file content"
+// @Success      201               {object}  dto.Node
+// @Failure      400               {string}  string "Bad Request"
+// @Failure      401               {string}  string "Unauthorized"
+// @Failure      403               {string}  string "Forbidden - Write permission denied or the file is blocked by the content policy"
+// @Failure      404               {string}  string "Not Found - Parent folder not found"
+// @Failure      411               {string}  string "Length Required"
+// @Failure      413               {object}  UploadLimitError "Payload Too Large - An upload limit or the owner's storage quota is exceeded"
+// @Failure      415               {string}  string "Unsupported Media Type - The file type is not allowed on this server"
+// @Failure      422               {string}  string "Unprocessable Entity - Folder children limit exceeded or the file does not match its checksum"
+// @Failure      500               {string}  string "Internal Server Error"
+// @Router       /nodes/file [put]
+func (s *Server) PutFileHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" || len(name) > 255 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidFileNameLength)
+		return
+	}
+	var parentID *string
+	if raw := r.URL.Query().Get("parent_id"); raw != "" {
+		if len(raw) != 21 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+			return
+		}
+		parentID = &raw
+	}
+	if r.ContentLength < 0 {
+		writeError(w, r, http.StatusLengthRequired, i18n.ContentLengthRequired)
+		return
+	}
+	expectedSHA256, err := parseContentSHA256(r.Header.Get(contentSHA256Header))
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !s.checkFileSize(w, name, r.ContentLength) || !s.limitRequestBody(w, r) {
+		return
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, parentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return
+	}
+
+	ownerID := claims.UserID
+	var parentFolderOwnerID *int64
+	if parentID != nil {
+		parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *parentID, claims.UserID)
+		if err != nil || parentFolder == nil {
+			s.writeNodeNotFound(w, r, *parentID, i18n.ParentFolderNotAccessible)
+			return
+		}
+		ownerID = parentFolder.OwnerID
+		parentFolderOwnerID = &parentFolder.OwnerID
+	}
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, parentID, 1, 1)) {
+		return
+	}
+	if !s.checkUploadQuota(w, r, ownerID, r.ContentLength) {
+		return
+	}
+
+	stagedID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadStageFailed)
+		return
+	}
+	defer func() {
+		if err := s.storage.Delete(stagedID); err != nil {
+			log.Printf("WARN: Failed to delete staged upload %s: %v", stagedID, err)
+		}
+	}()
+
+	hasher := sha256.New()
+	if err := s.storage.Save(stagedID, io.TeeReader(r.Body, hasher)); err != nil {
+		if !s.writeRequestTooLarge(w, err) {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.UploadReadFailed, name)
+		}
+		return
+	}
+
+	mimeType, err := "", verifyContentSHA256(name, expectedSHA256, hexSum(hasher))
+	if err == nil {
+		mimeType, err = s.sniffStaged(stagedID, name, r.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = s.checkContentType(name, mimeType)
+	}
+	var quarantine *contentpolicy.Decision
+	if err == nil {
+		quarantine, err = s.evaluateStaged(r.Context(), stagedID, name, mimeType, r.ContentLength)
+	}
+	if err != nil {
+		var typeErr *contentTypeError
+		var mismatchErr *checksumMismatchError
+		var policyErr *contentPolicyError
+		switch {
+		case errors.As(err, &typeErr):
+			writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+		case errors.As(err, &mismatchErr):
+			writeLocalizedError(w, r, http.StatusUnprocessableEntity, err)
+		case errors.As(err, &policyErr):
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+		default:
+			log.Printf("ERROR: Failed to check uploaded file %s: %v", name, err)
+			writeErrorf(w, r, http.StatusInternalServerError, i18n.UploadCheckFailed, name)
+		}
+		return
+	}
+
+	staged, err := s.storage.Open(stagedID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+	createdNode, err := s.storeFileContent(r.Context(), claims.UserID, ownerID, parentID, staged, r.ContentLength, name, mimeType, quarantine)
+	staged.Close()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+
+	createdNodes := []models.Node{*createdNode}
+	s.publishUploadedNodes(r.Context(), claims.UserID, parentFolderOwnerID, parentID, createdNodes)
+	if quarantine != nil {
+		s.notifyQuarantine(r.Context(), createdNode, contentpolicy.ActionUpload, quarantine)
+	}
+	createdNodes = s.applyOrganizationRules(r.Context(), ownerID, createdNodes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.NodeFrom(createdNodes[0]))
+}
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxRuleTags  = 10
+	maxTagLength = 64
+)
+
+type OrganizationRuleRequest struct {
+	Name        string `json:"name" example:"Faktury PDF"`
+	NamePattern string `json:"name_pattern" example:"*.pdf"`
+	// SourceFolderID is the folder whose uploads the rule watches; omit for the root.
+	SourceFolderID *string  `json:"source_folder_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	TargetFolderID *string  `json:"target_folder_id,omitempty" example:"bNowyFolderRodzic123"`
+	Tags           []string `json:"tags,omitempty" example:"invoice"`
+	Enabled        *bool    `json:"enabled,omitempty" example:"true"`
+}
+
+// RuleMatch is a file a rule applies to, with the actions taken or, in a dry
+// run, the actions that would be taken.
+type RuleMatch struct {
+	Node           dto.Node `json:"node"`
+	TargetFolderID *string  `json:"target_folder_id,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	Applied        bool     `json:"applied"`
+	Error          string   `json:"error,omitempty"`
+}
+
+type RuleRunResponse struct {
+	DryRun  bool        `json:"dry_run"`
+	Matches []RuleMatch `json:"matches"`
+}
+
+// ruleMatches reports whether a rule applies to a file. Patterns use shell
+// glob syntax and are matched case-insensitively against the file name.
+func ruleMatches(rule database.OrganizationRule, node models.Node) bool {
+	if node.NodeType != "file" || !sameParent(rule.SourceFolderID, node.ParentID) {
+		return false
+	}
+	matched, err := path.Match(strings.ToLower(rule.NamePattern), strings.ToLower(node.Name))
+	return err == nil && matched
+}
+
+// validateRuleRequest checks a rule definition and turns it into creation
+// parameters. Folders must be the owner's own folders.
+func (s *Server) validateRuleRequest(ctx context.Context, ownerID int64, req OrganizationRuleRequest) (*database.CreateOrganizationRuleParams, error) {
+	params := &database.CreateOrganizationRuleParams{
+		OwnerID:        ownerID,
+		Name:           strings.TrimSpace(req.Name),
+		NamePattern:    strings.TrimSpace(req.NamePattern),
+		SourceFolderID: req.SourceFolderID,
+		TargetFolderID: req.TargetFolderID,
+		Tags:           []string{},
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if params.Name == "" {
+		return nil, newOpError(http.StatusBadRequest, i18n.EmptyRuleName)
+	}
+	if params.NamePattern == "" {
+		return nil, newOpError(http.StatusBadRequest, i18n.EmptyNamePattern)
+	}
+	if _, err := path.Match(params.NamePattern, ""); err != nil {
+		return nil, newOpError(http.StatusBadRequest, i18n.InvalidNamePattern)
+	}
+
+	for _, folderID := range []*string{params.SourceFolderID, params.TargetFolderID} {
+		if folderID == nil {
+			continue
+		}
+		folder, err := s.store.GetNodeByID(ctx, *folderID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if folder == nil || folder.NodeType != "folder" {
+			return nil, newOpError(http.StatusNotFound, i18n.RuleFolderNotFound, *folderID)
+		}
+	}
+	if params.TargetFolderID != nil && sameParent(params.SourceFolderID, params.TargetFolderID) {
+		return nil, newOpError(http.StatusBadRequest, i18n.RuleSameFolders)
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, newOpError(http.StatusBadRequest, i18n.InvalidTagLength, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			params.Tags = append(params.Tags, tag)
+		}
+	}
+	if len(params.Tags) > maxRuleTags {
+		return nil, newOpError(http.StatusBadRequest, i18n.TooManyRuleTags, maxRuleTags)
+	}
+	if params.TargetFolderID == nil && len(params.Tags) == 0 {
+		return nil, newOpError(http.StatusBadRequest, i18n.RuleWithoutAction)
+	}
+	return params, nil
+}
+
+// applyRule tags and moves a file as the rule says, acting as the owner.
+func (s *Server) applyRule(ctx context.Context, rule database.OrganizationRule, node *models.Node) error {
+	if len(rule.Tags) > 0 {
+		payload := map[string]interface{}{"id": node.ID, "tags": rule.Tags, "rule_id": rule.ID}
+		txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+			if err := q.AddNodeTags(ctx, node.ID, rule.Tags); err != nil {
+				return err
+			}
+			return q.LogEvent(ctx, node.OwnerID, "node_tagged", payload)
+		})
+		if txErr != nil {
+			return txErr
+		}
+		eventMsg := map[string]interface{}{"event_type": "node_tagged", "payload": payload}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(node.OwnerID, eventBytes)
+	}
+
+	if rule.TargetFolderID != nil {
+		return s.moveNode(ctx, node.OwnerID, node, rule.TargetFolderID, true)
+	}
+	return nil
+}
+
+// applyOrganizationRules runs the owner's enabled rules over freshly uploaded
+// files. Rules are evaluated in creation order and only the first matching
+// rule is applied to a file. It returns the files as they are afterwards;
+// failures are logged and leave the file where it was uploaded.
+func (s *Server) applyOrganizationRules(ctx context.Context, ownerID int64, nodes []models.Node) []models.Node {
+	rules, err := s.store.ListOrganizationRules(ctx, ownerID, true)
+	if err != nil {
+		log.Printf("ERROR: Failed to load organization rules of user %d: %v", ownerID, err)
+		return nodes
+	}
+	if len(rules) == 0 {
+		return nodes
+	}
+
+	result := make([]models.Node, 0, len(nodes))
+	for _, node := range nodes {
+		for _, rule := range rules {
+			if !ruleMatches(rule, node) {
+				continue
+			}
+			if err := s.applyRule(ctx, rule, &node); err != nil {
+				log.Printf("WARN: Organization rule %d failed for node %s: %v", rule.ID, node.ID, err)
+			} else if updated, err := s.store.GetNodeByID(ctx, node.ID, ownerID); err == nil && updated != nil {
+				node = *updated
+			}
+			break
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// runRule evaluates a rule against the files already in its source folder and
+// applies it unless dryRun is set. The whole folder is listed before anything
+// is applied, since moving matches out of it would shift the later pages.
+func (s *Server) runRule(ctx context.Context, ownerID int64, rule database.OrganizationRule, dryRun bool) ([]RuleMatch, error) {
+	var children []models.Node
+	for offset := 0; ; offset += MaxLimit {
+		page, err := s.store.GetNodesByParentID(ctx, ownerID, rule.SourceFolderID, MaxLimit, offset)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, page...)
+		if len(page) < MaxLimit {
+			break
+		}
+	}
+
+	matches := []RuleMatch{}
+	for _, node := range children {
+		node.OwnerID = ownerID
+		node.ParentID = rule.SourceFolderID
+		if !ruleMatches(rule, node) {
+			continue
+		}
+
+		match := RuleMatch{Node: dto.NodeFrom(node), TargetFolderID: rule.TargetFolderID, Tags: rule.Tags}
+		if !dryRun {
+			if err := s.applyRule(ctx, rule, &node); err != nil {
+				match.Error = opErrorMessage(err)
+			} else {
+				match.Applied = true
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+func parseRuleID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	ruleID, err := strconv.ParseInt(chi.URLParam(r, "ruleId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRuleID)
+		return 0, false
+	}
+	return ruleID, true
+}
+
+// @Summary      List organization rules
+// @Description  Returns the user's automatic organization rules in the order they are evaluated after uploads.
+// @Tags         rules
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   dto.OrganizationRule
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /rules [get]
+func (s *Server) ListOrganizationRulesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	rules, err := s.store.ListOrganizationRules(r.Context(), claims.UserID, false)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.RulesListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.OrganizationRules(rules))
+}
+
+// @Summary      Create an organization rule
+// @Description  Creates a rule applied to files uploaded to the source folder (the root when omitted) whose name matches a glob pattern such as "*.pdf". A matching file gets the rule's tags and is moved to the target folder. Rules are evaluated in creation order and the first match wins.
+// @Tags         rules
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        rule  body      OrganizationRuleRequest  true  "Rule definition"
+// @Success      201   {object}  dto.OrganizationRule
+// @Failure      400   {string}  string "Bad Request - Invalid rule"
+// @Failure      401   {string}  string "Unauthorized"
+// @Failure      404   {string}  string "Folder not found"
+// @Failure      500   {string}  string "Internal Server Error"
+// @Router       /rules [post]
+func (s *Server) CreateOrganizationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req OrganizationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+
+	params, err := s.validateRuleRequest(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeOpError(w, r, err, i18n.RuleValidateFailed)
+		return
+	}
+
+	rule, err := s.store.CreateOrganizationRule(r.Context(), *params)
+	if err != nil {
+		log.Printf("ERROR: Failed to create organization rule for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.RuleCreateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.OrganizationRuleFrom(*rule))
+}
+
+// @Summary      Delete an organization rule
+// @Tags         rules
+// @Security     BearerAuth
+// @Param        ruleId  path      int     true  "Rule ID"
+// @Success      204     {null}    nil     "No Content"
+// @Failure      400     {string}  string "Invalid rule ID"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Rule not found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /rules/{ruleId} [delete]
+func (s *Server) DeleteOrganizationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	ruleID, ok := parseRuleID(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.store.DeleteOrganizationRule(r.Context(), ruleID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.RuleDeleteFailed)
+		return
+	}
+	if !deleted {
+		writeError(w, r, http.StatusNotFound, i18n.RuleNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Test an organization rule
+// @Description  Evaluates an unsaved rule definition against the files already in its source folder and returns the files it would match with the planned actions. Nothing is changed.
+// @Tags         rules
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        rule  body      OrganizationRuleRequest  true  "Rule definition"
+// @Success      200   {object}  RuleRunResponse
+// @Failure      400   {string}  string "Bad Request - Invalid rule"
+// @Failure      401   {string}  string "Unauthorized"
+// @Failure      404   {string}  string "Folder not found"
+// @Failure      500   {string}  string "Internal Server Error"
+// @Router       /rules/test [post]
+func (s *Server) TestOrganizationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req OrganizationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+
+	params, err := s.validateRuleRequest(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeOpError(w, r, err, i18n.RuleValidateFailed)
+		return
+	}
+
+	rule := database.OrganizationRule{
+		Name:           params.Name,
+		NamePattern:    params.NamePattern,
+		SourceFolderID: params.SourceFolderID,
+		TargetFolderID: params.TargetFolderID,
+		Tags:           params.Tags,
+		Enabled:        params.Enabled,
+	}
+	matches, err := s.runRule(r.Context(), claims.UserID, rule, true)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.RuleEvaluateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RuleRunResponse{DryRun: true, Matches: matches})
+}
+
+// @Summary      Run an organization rule
+// @Description  Applies a saved rule to the files already in its source folder. With dry_run=true only the matching files and planned actions are returned. Disabled rules can be run as well.
+// @Tags         rules
+// @Produce      json
+// @Security     BearerAuth
+// @Param        ruleId   path      int     true   "Rule ID"
+// @Param        dry_run  query     bool    false  "Only report what would be done"
+// @Success      200      {object}  RuleRunResponse
+// @Failure      400      {string}  string "Invalid rule ID"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Rule not found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /rules/{ruleId}/run [post]
+func (s *Server) RunOrganizationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	ruleID, ok := parseRuleID(w, r)
+	if !ok {
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	rule, err := s.store.GetOrganizationRule(r.Context(), ruleID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.RuleLookupFailed)
+		return
+	}
+	if rule == nil {
+		writeError(w, r, http.StatusNotFound, i18n.RuleNotFound)
+		return
+	}
+
+	matches, err := s.runRule(r.Context(), claims.UserID, *rule, dryRun)
+	if err != nil {
+		log.Printf("ERROR: Failed to run organization rule %d: %v", ruleID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.RuleRunFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RuleRunResponse{DryRun: dryRun, Matches: matches})
+}
+
+// @Summary      List node tags
+// @Description  Returns the tags attached to a node, e.g. by organization rules.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID"
+// @Success      200     {array}   string
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/tags [get]
+func (s *Server) ListNodeTagsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
+		return
+	}
+
+	tags, err := s.store.ListNodeTags(r.Context(), node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.TagsListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
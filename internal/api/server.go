@@ -5,25 +5,118 @@ import (
 	"log"
 	"net/http"
 	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/contentpolicy"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/email"
+	"serwer-plikow/internal/federation"
+	"serwer-plikow/internal/geoip"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/ldap"
 	"serwer-plikow/internal/storage"
+	"serwer-plikow/internal/transcription"
 	"serwer-plikow/internal/websocket"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server struct {
-	config  *config.Config
+	// config is replaced as a whole when the settings file is reloaded.
+	config  atomic.Pointer[config.Config]
 	store   *database.Store
 	storage *storage.LocalStorage
+	blobs   *storage.Router
 	wsHub   *websocket.Hub
+	nodeIDs *ids.Generator
+	// hookClient sends folder hook deliveries.
+	hookClient *http.Client
+	// urlImportClient fetches files imported from URLs.
+	urlImportClient *http.Client
+	// uploadProgress rate-limits the progress events of resumable uploads.
+	uploadProgress uploadProgressThrottle
+	// linkPasswords counts wrong public link passwords.
+	linkPasswords linkPasswordAttempts
+	// linkPreviews rate-limits the previews of public links.
+	linkPreviews linkPreviewLimiter
+	// jobs tracks the runs of the background jobs.
+	jobs jobMonitor
+	// transcriber is nil when no transcription service is configured.
+	transcriber transcription.Transcriber
+	// federation is nil unless federation is enabled.
+	federation *federation.Client
+	// directory is nil when no LDAP directory is configured.
+	directory ldap.UserDirectory
+	// contentPolicy is nil unless a content policy is set.
+	contentPolicy contentpolicy.Policy
+	// geoLocator is nil when no GeoIP database is configured.
+	geoLocator geoip.Locator
+	// mailer is nil when no SMTP server is configured.
+	mailer email.Mailer
+	// originValidator holds the CORS origin check of the current config.
+	originValidator atomic.Value
+	// reloadMu serializes configuration reloads.
+	reloadMu sync.Mutex
 }
 
-func NewServer(cfg *config.Config, store *database.Store, storage *storage.LocalStorage, wsHub *websocket.Hub) *Server {
-	return &Server{
-		config:  cfg,
+func NewServer(cfg *config.Config, store *database.Store, storage *storage.LocalStorage, blobs *storage.Router, wsHub *websocket.Hub) *Server {
+	server := &Server{
 		store:   store,
 		storage: storage,
+		blobs:   blobs,
 		wsHub:   wsHub,
 	}
+	server.config.Store(cfg)
+	server.originValidator.Store(NewOriginValidator(cfg.CORS))
+	nodeIDs, err := ids.New("node", cfg.IDs.Alphabet, cfg.IDs.Length)
+	if err != nil {
+		log.Printf("WARN: Invalid ids configuration, using default node IDs: %v", err)
+		nodeIDs, _ = ids.New("node", "", 0)
+	}
+	server.nodeIDs = nodeIDs
+
+	hookTimeout := defaultHookTimeout
+	if cfg.Hooks.TimeoutSeconds > 0 {
+		hookTimeout = time.Duration(cfg.Hooks.TimeoutSeconds) * time.Second
+	}
+	server.hookClient = server.newHookClient(hookTimeout)
+	server.urlImportClient = server.newURLImportClient()
+	if cfg.Transcription.Endpoint != "" {
+		timeout := defaultTranscriptionTimeout
+		if cfg.Transcription.TimeoutSeconds > 0 {
+			timeout = time.Duration(cfg.Transcription.TimeoutSeconds) * time.Second
+		}
+		server.transcriber = transcription.NewWhisperClient(cfg.Transcription.Endpoint, cfg.Transcription.APIKey, cfg.Transcription.Model, timeout)
+	}
+	if cfg.Federation.Enabled {
+		timeout := defaultFederationTimeout
+		if cfg.Federation.TimeoutSeconds > 0 {
+			timeout = time.Duration(cfg.Federation.TimeoutSeconds) * time.Second
+		}
+		server.federation = federation.NewClient(cfg.Federation.InstanceName, timeout)
+	}
+	if cfg.LDAP.URL != "" {
+		server.directory = ldap.NewDirectory(ldapDirectoryConfig(cfg.LDAP))
+	}
+	if cfg.Mail.Host != "" {
+		port := defaultSMTPPort
+		if cfg.Mail.Port > 0 {
+			port = cfg.Mail.Port
+		}
+		server.mailer = email.NewSMTPMailer(cfg.Mail.Host, port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	}
+	if cfg.GeoIP.DatabasePath != "" {
+		geoDB, err := geoip.Open(cfg.GeoIP.DatabasePath, cfg.GeoIP.Language)
+		if err != nil {
+			log.Printf("WARN: Failed to open the GeoIP database, sessions are listed without locations: %v", err)
+		} else {
+			cacheSize := defaultGeoIPCacheSize
+			if cfg.GeoIP.CacheSize > 0 {
+				cacheSize = cfg.GeoIP.CacheSize
+			}
+			server.geoLocator = geoip.NewCache(geoDB, cacheSize)
+		}
+	}
+	return server
 }
 
 func (s *Server) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -33,14 +126,27 @@ func (s *Server) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		status["status"] = "ok"
 		status["database"] = "connected"
-		w.WriteHeader(http.StatusOK)
 	} else {
 		status["status"] = "error"
 		status["database"] = "disconnected"
 		log.Printf("Health check failed: database ping error: %v", err)
-		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if replica := s.store.GetReplicaPool(); replica != nil {
+		if err := replica.Ping(r.Context()); err == nil {
+			status["replica"] = "connected"
+		} else {
+			status["status"] = "error"
+			status["replica"] = "disconnected"
+			log.Printf("Health check failed: read replica ping error: %v", err)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if status["status"] == "ok" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	json.NewEncoder(w).Encode(status)
 }
@@ -2,20 +2,27 @@ package api
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"serwer-plikow/internal/i18n"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"serwer-plikow/internal/dto"
 	_ "serwer-plikow/internal/models"
 )
 
+// defaultGeoIPCacheSize is the number of client addresses whose locations
+// are remembered when geoip.cache_size is not set.
+const defaultGeoIPCacheSize = 10000
+
 // @Summary      List active sessions
-// @Description  Gets a list of all active sessions for the currently authenticated user, which can be displayed to allow them to manage devices.
+// @Description  Gets a list of all active sessions for the currently authenticated user, which can be displayed to allow them to manage devices. When the server has a GeoIP database, each session carries the country and city of its client IP, to help spot logins from unexpected places.
 // @Tags         sessions
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   models.Session
+// @Success      200  {array}   dto.Session
 // @Failure      401  {string}  string "Unauthorized"
 // @Failure      500  {string}  string "Internal Server Error"
 // @Router       /sessions [get]
@@ -24,12 +31,24 @@ func (s *Server) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	sessions, err := s.store.ListSessionsForUser(r.Context(), claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.SessionsLookupFailed)
 		return
 	}
 
+	mapped := dto.Sessions(sessions)
+	if s.geoLocator != nil {
+		for i := range mapped {
+			location, err := s.geoLocator.Locate(mapped[i].ClientIP)
+			if err != nil {
+				log.Printf("WARN: GeoIP lookup of %s failed: %v", mapped[i].ClientIP, err)
+				continue
+			}
+			mapped[i].Location = location
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(sessions)
+	json.NewEncoder(w).Encode(mapped)
 }
 
 // @Summary      Terminate a specific session
@@ -48,13 +67,13 @@ func (s *Server) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
 
 	sessionID, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		http.Error(w, "Invalid session ID format", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidSessionIDFormat)
 		return
 	}
 
 	err = s.store.DeleteSessionByID(r.Context(), sessionID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to delete session", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.SessionDeleteFailed)
 		return
 	}
 
@@ -62,19 +81,20 @@ func (s *Server) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary      Terminate all sessions (Log out everywhere)
-// @Description  Terminates all active sessions for the currently authenticated user, effectively logging them out from all other devices.
+// @Description  Terminates all active sessions for the currently authenticated user, effectively logging them out from all devices. Access tokens issued for those sessions stop being accepted as well.
 // @Tags         sessions
 // @Security     BearerAuth
 // @Success      204  {null}    nil "No Content"
 // @Failure      401  {string}  string "Unauthorized"
 // @Failure      500  {string}  string "Internal Server Error"
 // @Router       /sessions/terminate_all [post]
+// @Router       /auth/logout-all [post]
 func (s *Server) TerminateAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 
 	err := s.store.DeleteAllSessionsForUser(r.Context(), claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to terminate all sessions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.SessionsTerminateFailed)
 		return
 	}
 
@@ -1,21 +1,39 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
 	"serwer-plikow/internal/models"
 	"strconv"
-	"time"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
+const maxShareMessageLength = 1000
+
+// sharePermissionRanks orders the share permission levels; each level
+// includes the rights of the ones below it. Write adds changing the shared
+// content, manage adds sharing it further and deleting inside it.
+var sharePermissionRanks = map[string]int{"read": 1, "write": 2, "manage": 3}
+
 type ShareRequest struct {
-	RecipientUsername string `json:"recipient_username" example:"user2"`
-	Permissions       string `json:"permissions" example:"read" enums:"read,write"`
+	// RecipientUsername or GroupID names who the node is shared with.
+	RecipientUsername string  `json:"recipient_username,omitempty" example:"user2"`
+	GroupID           *int64  `json:"group_id,omitempty" example:"7"`
+	Permissions       string  `json:"permissions" example:"read" enums:"read,write,manage"`
+	Message           *string `json:"message,omitempty" example:"Do audytu, tylko do odczytu do piątku"`
+	// Version pins a file share to that version of the file. Pinned shares are read-only.
+	Version *int `json:"version,omitempty" example:"3"`
 }
 
 type SharingUserResponse struct {
@@ -24,38 +42,20 @@ type SharingUserResponse struct {
 	DisplayName string `json:"display_name" example:"Jan Kowalski"`
 }
 
-type OutgoingShareResponse struct {
-	ID                int64     `json:"id" example:"42"`
-	NodeID            string    `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
-	NodeName          string    `json:"node_name" example:"Wspólny Projekt"`
-	NodeType          string    `json:"node_type" example:"folder"`
-	RecipientUsername string    `json:"recipient_username" example:"user2"`
-	Permissions       string    `json:"permissions" example:"write"`
-	SharedAt          time.Time `json:"shared_at"`
-}
-
-type ShareResponse struct {
-	ID          int64     `json:"id" example:"42"`
-	NodeID      string    `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
-	SharerID    int64     `json:"sharer_id" example:"1"`
-	RecipientID int64     `json:"recipient_id" example:"2"`
-	Permissions string    `json:"permissions" example:"read"`
-	SharedAt    time.Time `json:"shared_at"`
-}
-
 // @Summary      Share a node
-// @Description  Shares a file or folder with another user, granting them read or write permissions.
+// @Description  Shares a file or folder with another user, or with a group the sharer is a member of, granting read, write or manage permissions. Manage permission additionally lets the recipient share the node further and delete nodes inside it; recipients holding it can call this endpoint for the shared tree themselves. A group share reaches every current member of the group and returns the group share. An optional message explaining why access was granted is shown to the recipient. A file can be shared read-only at a specific version, so later edits do not change what the recipient sees. Sharing a node with a user who already has the same or a higher permission through a shared ancestor folder is rejected with 409, since revoking either share would not behave as expected; GET /nodes/{nodeId}/effective-access shows such overlaps.
 // @Tags         shares
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
 // @Param        nodeId       path      string        true  "Node ID to share"
 // @Param        shareRequest body      ShareRequest  true  "Share details"
-// @Success      201          {object}  ShareResponse
+// @Success      201          {object}  dto.Share
 // @Failure      400          {string}  string "Bad Request"
 // @Failure      401          {string}  string "Unauthorized"
-// @Failure      404          {string}  string "Not Found - Node or recipient not found"
-// @Failure      409          {string}  string "Conflict - Node is already shared with this user"
+// @Failure      403          {string}  string "Forbidden - Manage permission required or the content policy does not allow sharing the content"
+// @Failure      404          {string}  string "Not Found - Node, version, recipient or group not found"
+// @Failure      409          {string}  string "Conflict - Node is already shared with this user, or a shared ancestor already grants the permission"
 // @Failure      500          {string}  string "Internal Server Error"
 // @Router       /nodes/{nodeId}/share [post]
 func (s *Server) ShareNodeHandler(w http.ResponseWriter, r *http.Request) {
@@ -64,45 +64,145 @@ func (s *Server) ShareNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req ShareRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 
-	if req.Permissions != "read" && req.Permissions != "write" {
-		http.Error(w, "Invalid permissions value. Must be 'read' or 'write'", http.StatusBadRequest)
+	if sharePermissionRanks[req.Permissions] == 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidPermissionsValue)
 		return
 	}
 
-	node, err := s.store.GetNodeByID(r.Context(), nodeID, claims.UserID)
+	if req.GroupID != nil && req.RecipientUsername != "" {
+		writeError(w, r, http.StatusBadRequest, i18n.RecipientAndGroup)
+		return
+	}
+
+	if req.Message != nil {
+		message := strings.TrimSpace(*req.Message)
+		if utf8.RuneCountInString(message) > maxShareMessageLength {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.ShareMessageTooLong, maxShareMessageLength)
+			return
+		}
+		if message == "" {
+			req.Message = nil
+		} else {
+			req.Message = &message
+		}
+	}
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Internal server error while checking node ownership", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeAccessCheckFailed)
 		return
 	}
 	if node == nil {
-		http.Error(w, "Node not found or you are not the owner", http.StatusNotFound)
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
+		return
+	}
+	if node.OwnerID != claims.UserID {
+		canManage, err := s.store.CheckManagePermission(r.Context(), claims.UserID, node.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+		if !canManage {
+			writeError(w, r, http.StatusForbidden, i18n.ShareRequiresManage)
+			return
+		}
+	}
+
+	if req.GroupID != nil {
+		if req.Version != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.GroupShareVersionPinned)
+			return
+		}
+		s.shareNodeWithGroup(w, r, node, req)
 		return
 	}
 
+	if req.Version != nil {
+		if node.NodeType != "file" {
+			writeError(w, r, http.StatusBadRequest, i18n.VersionPinOnFolder)
+			return
+		}
+		if req.Permissions != "read" {
+			writeError(w, r, http.StatusBadRequest, i18n.PinnedShareReadOnly)
+			return
+		}
+		current, err := s.store.CurrentNodeVersion(r.Context(), node.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileVersionsLookupFailed)
+			return
+		}
+		if *req.Version < 1 || *req.Version > current {
+			writeErrorf(w, r, http.StatusNotFound, i18n.VersionNotFound, *req.Version)
+			return
+		}
+		if *req.Version < current {
+			version, err := s.store.GetNodeVersion(r.Context(), node.ID, *req.Version)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, i18n.FileVersionsLookupFailed)
+				return
+			}
+			if version == nil {
+				writeErrorf(w, r, http.StatusNotFound, i18n.VersionGone, *req.Version)
+				return
+			}
+		}
+	}
+
 	recipient, err := s.store.GetUserByUsername(r.Context(), req.RecipientUsername)
 	if err != nil {
-		http.Error(w, "Internal server error while finding recipient", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.RecipientLookupFailed)
 		return
 	}
 	if recipient == nil {
-		http.Error(w, "Recipient user not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, i18n.RecipientUserNotFound)
 		return
 	}
 
 	if recipient.ID == claims.UserID {
-		http.Error(w, "Cannot share a node with yourself", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.ShareWithSelf)
+		return
+	}
+	if recipient.ID == node.OwnerID {
+		writeError(w, r, http.StatusBadRequest, i18n.ShareWithOwner)
+		return
+	}
+
+	if req.Version == nil {
+		covering, err := s.coveringShare(r.Context(), node.ID, recipient.ID, req.Permissions)
+		if err != nil {
+			log.Printf("ERROR: Failed to check shares covering node %s for user %d: %v", node.ID, recipient.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+		if covering != nil {
+			writeErrorf(w, r, http.StatusConflict, i18n.AlreadySharedThroughFolder,
+				recipient.Username, *covering.Permissions, covering.Name, *covering.ShareID)
+			return
+		}
+	}
+
+	if err := s.checkShareContent(r.Context(), node); err != nil {
+		var policyErr *contentPolicyError
+		if errors.As(err, &policyErr) {
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+			return
+		}
+		log.Printf("ERROR: Content policy failed for shared node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharedContentCheckFailed)
 		return
 	}
 
 	params := database.ShareNodeParams{
-		NodeID:      nodeID,
-		SharerID:    claims.UserID,
-		RecipientID: recipient.ID,
-		Permissions: req.Permissions,
+		NodeID:        nodeID,
+		SharerID:      claims.UserID,
+		RecipientID:   recipient.ID,
+		Permissions:   req.Permissions,
+		Message:       req.Message,
+		PinnedVersion: req.Version,
 	}
 
 	var createdShare *models.Share
@@ -129,12 +229,12 @@ func (s *Server) ShareNodeHandler(w http.ResponseWriter, r *http.Request) {
 	if txErr != nil {
 		switch {
 		case errors.Is(txErr, database.ErrShareAlreadyExists):
-			http.Error(w, txErr.Error(), http.StatusConflict)
+			writeError(w, r, http.StatusConflict, i18n.AlreadyShared)
 		case errors.Is(txErr, database.ErrRecipientNotFound):
-			http.Error(w, "Recipient user not found", http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, i18n.RecipientUserNotFound)
 		default:
 			log.Printf("ERROR: Failed to create share record: %v", txErr)
-			http.Error(w, "Failed to share node", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.ShareNodeFailed)
 		}
 		return
 	}
@@ -150,7 +250,88 @@ func (s *Server) ShareNodeHandler(w http.ResponseWriter, r *http.Request) {
 	s.wsHub.PublishEvent(claims.UserID, eventBytesSharer)
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdShare)
+	json.NewEncoder(w).Encode(dto.ShareFrom(*createdShare))
+}
+
+// coveringShare returns the nearest share of an ancestor of the node to the
+// user that already grants at least the given permission, or nil. Shares
+// pinned to a version do not cover anything beyond their file.
+func (s *Server) coveringShare(ctx context.Context, nodeID string, userID int64, permissions string) (*database.NodeAncestor, error) {
+	ancestry, err := s.store.ListNodeAncestry(ctx, nodeID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ancestry {
+		a := &ancestry[i]
+		if a.Depth == 0 || a.ShareID == nil || a.PinnedVersion != nil {
+			continue
+		}
+		if sharePermissionRanks[*a.Permissions] >= sharePermissionRanks[permissions] {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// effectivePermissionRank returns the rank of the highest permission the user
+// was given on the node through shares, or 0 when it is not shared with them.
+func (s *Server) effectivePermissionRank(ctx context.Context, nodeID string, userID int64) (int, error) {
+	access, err := s.store.ListEffectiveAccess(ctx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range access {
+		if a.UserID == userID {
+			return sharePermissionRanks[a.Permissions], nil
+		}
+	}
+	return 0, nil
+}
+
+// @Summary      Get effective access to a node
+// @Description  Lists everyone the node is shared with, through a share of the node itself, of an ancestor folder or of a group, with the effective permission each user has, the share it comes from and how many shares grant access. More than one grant means overlapping shares: revoking one of them may leave the user with access. Available to the owner and to users with manage permission.
+// @Tags         shares
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID"
+// @Success      200     {array}   dto.EffectiveAccess
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Manage permission required"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/effective-access [get]
+func (s *Server) GetEffectiveAccessHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
+		return
+	}
+	canManage, err := s.store.CheckManagePermission(r.Context(), claims.UserID, node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !canManage {
+		writeError(w, r, http.StatusForbidden, i18n.AccessViewRequiresManage)
+		return
+	}
+
+	access, err := s.store.ListEffectiveAccess(r.Context(), node.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list effective access to node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.EffectiveAccessLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.EffectiveAccesses(access))
 }
 
 // @Summary      List users who shared with me
@@ -166,9 +347,9 @@ func (s *Server) ListSharingUsersHandler(w http.ResponseWriter, r *http.Request)
 	claims := GetUserFromContext(r.Context())
 	limit, offset := parsePagination(r)
 
-	users, err := s.store.GetSharingUsers(r.Context(), claims.UserID, limit, offset)
+	users, err := s.store.ReadReplica().GetSharingUsers(r.Context(), claims.UserID, limit, offset)
 	if err != nil {
-		http.Error(w, "Failed to retrieve list of sharing users", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharersLookupFailed)
 		return
 	}
 
@@ -176,16 +357,66 @@ func (s *Server) ListSharingUsersHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(users)
 }
 
+// @Summary      List incoming shares
+// @Description  Lists every share made to the current user, directly or through a group, in one call: the sharer, the permissions, message and date of the share and the shared node. Suited to a single "Shared with me" list; GET /shares/incoming/users and /shares/incoming/nodes browse the same shares by sharer. Shares of nodes in the trash are left out.
+// @Tags         shares
+// @Produce      json
+// @Security     BearerAuth
+// @Param        sort    query     string  false  "Sort key" Enums(shared_at, name, sharer, size) default(shared_at)
+// @Param        order   query     string  false  "Sort direction; newest and largest first by default, names A to Z" Enums(asc, desc)
+// @Param        limit   query     int     false  "Number of items to return" default(100)
+// @Param        offset  query     int     false  "Offset for pagination" default(0)
+// @Param        fields  query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,sharer_username,node"
+// @Success      200     {array}   dto.IncomingShare
+// @Failure      400     {string}  string "Bad Request"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /shares/incoming [get]
+func (s *Server) ListIncomingSharesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	sort := database.IncomingShareSortSharedAt
+	if value := r.URL.Query().Get("sort"); value != "" {
+		sort = database.IncomingShareSort(value)
+		if !database.ValidIncomingShareSort(sort) {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidSharedSort)
+			return
+		}
+	}
+	desc := sort == database.IncomingShareSortSharedAt || sort == database.IncomingShareSortSize
+	switch r.URL.Query().Get("order") {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidOrder)
+		return
+	}
+
+	shares, err := s.store.ReadReplica().ListIncomingShares(r.Context(), claims.UserID, sort, desc, limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list incoming shares for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.IncomingSharesListFailed)
+		return
+	}
+	writeListing(w, r, dto.IncomingShares(shares))
+}
+
 // @Summary      List items shared by a user
-// @Description  Lists files and folders shared with the current user by a specific sharer. Can list the root of shared items or the content of a subfolder.
+// @Description  Lists files and folders shared with the current user by a specific sharer. Can list the root of shared items (including the permissions and message of each share) or the content of a subfolder.
 // @Tags         shares
 // @Produce      json
 // @Security     BearerAuth
 // @Param        sharer_username  query     string  true   "Username of the person who shared the content"
 // @Param        parent_id        query     string  false  "ID of the shared parent folder to list. Omit for the root of shared items."
+// @Param        include          query     string  false  "Set to child_count to include the number of direct children of folders inside a shared folder"
 // @Param        limit            query     int     false  "Number of items to return" default(100)
 // @Param        offset           query     int     false  "Offset for pagination" default(0)
-// @Success      200              {array}   NodeResponse
+// @Param        fields           query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,name,node_type,modified_at"
+// @Success      200              {array}   dto.SharedNode
 // @Failure      400              {string}  string "Bad Request"
 // @Failure      401              {string}  string "Unauthorized"
 // @Failure      404              {string}  string "Not Found or access denied"
@@ -197,83 +428,162 @@ func (s *Server) ListSharedNodesHandler(w http.ResponseWriter, r *http.Request)
 
 	sharerUsername := r.URL.Query().Get("sharer_username")
 	if sharerUsername == "" {
-		http.Error(w, "sharer_username is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.SharerUsernameRequired)
 		return
 	}
 
 	sharer, err := s.store.GetUserByUsername(r.Context(), sharerUsername)
 	if err != nil {
 		log.Printf("ERROR: Failed to find sharer '%s': %v", sharerUsername, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.InternalError)
 		return
 	}
 	if sharer == nil {
-		http.Error(w, "Sharer not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, i18n.SharerNotFound)
 		return
 	}
 
 	parentIDStr := r.URL.Query().Get("parent_id")
 
 	if parentIDStr == "" {
-		nodes, err := s.store.ListDirectlySharedNodes(r.Context(), claims.UserID, sharer.ID, limit, offset)
+		nodes, err := s.store.ReadReplica().ListDirectlySharedNodes(r.Context(), claims.UserID, sharer.ID, limit, offset)
 		if err != nil {
 			log.Printf("ERROR: Failed to list directly shared nodes for user %d from sharer %d: %v", claims.UserID, sharer.ID, err)
-			http.Error(w, "Failed to list shared nodes", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, i18n.SharedNodesListFailed)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(nodes)
+		writeListing(w, r, dto.SharedNodes(nodes))
 		return
 	}
 
 	hasAccess, err := s.store.HasAccessToNode(r.Context(), parentIDStr, claims.UserID)
 	if err != nil {
 		log.Printf("ERROR: Failed to check access for user %d to node %s: %v", claims.UserID, parentIDStr, err)
-		http.Error(w, "Failed to check access permissions", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.AccessCheckFailed)
 		return
 	}
 
 	if !hasAccess {
-		http.Error(w, "Shared folder not found or access denied", http.StatusNotFound)
+		s.writeNodeNotFound(w, r, parentIDStr, i18n.SharedFolderNotAccessible)
 		return
 	}
 
-	nodes, err := s.store.GetNodesByParentID(r.Context(), sharer.ID, &parentIDStr, limit, offset)
+	nodes, err := s.store.ReadReplica().GetNodesByParentID(r.Context(), sharer.ID, &parentIDStr, limit, offset)
 	if err != nil {
 		log.Printf("ERROR: Failed to list children for shared node %s: %v", parentIDStr, err)
-		http.Error(w, "Failed to list shared nodes content", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharedContentListFailed)
 		return
 	}
+	if wantsChildCounts(r) {
+		if err := s.attachChildCounts(r.Context(), s.store.ReadReplica(), nodes); err != nil {
+			log.Printf("ERROR: Failed to count children in shared node %s: %v", parentIDStr, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.SharedContentListFailed)
+			return
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	writeListing(w, r, dto.Nodes(nodes))
+}
+
+// outgoingShareFilter reads the recipient and permission filters of the
+// outgoing share endpoints, writing a 400 and returning false when they are
+// invalid.
+func outgoingShareFilter(w http.ResponseWriter, r *http.Request) (database.OutgoingShareFilter, bool) {
+	var filter database.OutgoingShareFilter
+	if recipient := strings.TrimSpace(r.URL.Query().Get("recipient")); recipient != "" {
+		filter.RecipientUsername = &recipient
+	}
+	if permission := r.URL.Query().Get("permission"); permission != "" {
+		if sharePermissionRanks[permission] == 0 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidPermission)
+			return filter, false
+		}
+		filter.Permissions = &permission
+	}
+	return filter, true
 }
 
 // @Summary      List items I have shared
-// @Description  Gets a list of all items the currently authenticated user has shared with others.
+// @Description  Gets a list of all items the currently authenticated user has shared with others, optionally only those shared with one recipient or with one permission level. GET /shares/outgoing/stats sums up what the shares expose.
 // @Tags         shares
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   OutgoingShareResponse
-// @Failure      401  {string}  string "Unauthorized"
-// @Failure      500  {string}  string "Internal Server Error"
+// @Param        recipient   query     string  false  "Only shares with the user of this username"
+// @Param        permission  query     string  false  "Only shares with this permission level" Enums(read, write, manage)
+// @Param        limit       query     int     false  "Maximum number of items to return" default(100)
+// @Param        offset      query     int     false  "Number of items to skip" default(0)
+// @Success      200         {array}   dto.OutgoingShare
+// @Failure      400         {string}  string "Bad Request - Invalid permission filter"
+// @Failure      401         {string}  string "Unauthorized"
+// @Failure      500         {string}  string "Internal Server Error"
 // @Router       /shares/outgoing [get]
 func (s *Server) ListOutgoingSharesHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	limit, offset := parsePagination(r)
+	filter, ok := outgoingShareFilter(w, r)
+	if !ok {
+		return
+	}
+
+	shares, err := s.store.ReadReplica().GetOutgoingShares(r.Context(), claims.UserID, filter, limit, offset)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.OutgoingSharesLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.OutgoingShares(shares))
+}
+
+type OutgoingShareStatsResponse struct {
+	database.OutgoingShareStats
+	StorageUsedBytes int64 `json:"storage_used_bytes" example:"2147483648"`
+	// SharedPercent is SharedBytes as a percentage of StorageUsedBytes.
+	SharedPercent float64 `json:"shared_percent" example:"24.41"`
+}
+
+// @Summary      Get statistics of my shares
+// @Description  Sums up the sharing exposure of the current user: the number of shares, of distinct nodes shared and of recipients, and the number and total size of the user's files reachable through the shares (below shared folders included, each file counted once), also as a percentage of the storage the user uses. Accepts the same filters as GET /shares/outgoing.
+// @Tags         shares
+// @Produce      json
+// @Security     BearerAuth
+// @Param        recipient   query     string  false  "Only shares with the user of this username"
+// @Param        permission  query     string  false  "Only shares with this permission level" Enums(read, write, manage)
+// @Success      200         {object}  OutgoingShareStatsResponse
+// @Failure      400         {string}  string "Bad Request - Invalid permission filter"
+// @Failure      401         {string}  string "Unauthorized"
+// @Failure      500         {string}  string "Internal Server Error"
+// @Router       /shares/outgoing/stats [get]
+func (s *Server) GetOutgoingShareStatsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	filter, ok := outgoingShareFilter(w, r)
+	if !ok {
+		return
+	}
 
-	shares, err := s.store.GetOutgoingShares(r.Context(), claims.UserID, limit, offset)
+	stats, err := s.store.ReadReplica().GetOutgoingShareStats(r.Context(), claims.UserID, filter)
 	if err != nil {
-		http.Error(w, "Failed to retrieve outgoing shares", http.StatusInternalServerError)
+		log.Printf("ERROR: Failed to compute share statistics of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareStatsFailed)
+		return
+	}
+	user, err := s.store.GetUserByID(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareStatsFailed)
 		return
 	}
 
+	resp := OutgoingShareStatsResponse{OutgoingShareStats: *stats, StorageUsedBytes: user.StorageUsedBytes}
+	if user.StorageUsedBytes > 0 {
+		resp.SharedPercent = math.Round(float64(stats.SharedBytes)*10000/float64(user.StorageUsedBytes)) / 100
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(shares)
+	json.NewEncoder(w).Encode(resp)
 }
 
 // @Summary      Revoke a share
-// @Description  Revokes a share entry. Only the original sharer can do this.
+// @Description  Revokes a share entry. Only the original sharer can do this. The recipient's watches, favorites and pending uploads inside the shared subtree that are not covered by another share are removed in the same transaction, and their IDs are listed under "invalidated" in the recipient's share_revoked_for_you event.
 // @Tags         shares
 // @Security     BearerAuth
 // @Param        shareId  path      int  true  "ID of the share to delete"
@@ -289,53 +599,415 @@ func (s *Server) DeleteShareHandler(w http.ResponseWriter, r *http.Request) {
 	shareIDStr := chi.URLParam(r, "shareId")
 	shareID, err := strconv.ParseInt(shareIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid share ID format", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareIDFormat)
 		return
 	}
 
 	shareInfo, err := s.store.GetShareByID(r.Context(), shareID, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve share information", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareLookupFailed)
 		return
 	}
 	if shareInfo == nil {
-		http.Error(w, "Share not found or you do not have permission to delete it", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotDeletable)
 		return
 	}
 
-	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
-		err := q.DeleteShare(r.Context(), shareID, claims.UserID)
+	if err := s.revokeShare(r.Context(), shareInfo); err != nil {
+		log.Printf("ERROR: Failed to delete share in transaction: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareDeleteFailed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeShare deletes a share, invalidates what its recipient could reach
+// through it and notifies both sides.
+func (s *Server) revokeShare(ctx context.Context, share *models.Share) error {
+	var revoked *revokedSubtreeState
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		err := q.DeleteShare(ctx, share.ID, share.SharerID)
+		if err != nil {
+			return err
+		}
+
+		revoked, err = invalidateRevokedSubtree(ctx, q, share.RecipientID, share.NodeID)
 		if err != nil {
 			return err
 		}
 
-		payloadForRecipient := map[string]string{"node_id": shareInfo.NodeID}
-		err = q.LogEvent(r.Context(), shareInfo.RecipientID, "share_revoked_for_you", payloadForRecipient)
+		payloadForRecipient := map[string]interface{}{"node_id": share.NodeID, "invalidated": revoked}
+		err = q.LogEvent(ctx, share.RecipientID, "share_revoked_for_you", payloadForRecipient)
 		if err != nil {
 			return err
 		}
 
-		payloadForSharer := map[string]interface{}{"share_id": shareInfo.ID, "node_id": shareInfo.NodeID}
-		err = q.LogEvent(r.Context(), claims.UserID, "node_share_revoked", payloadForSharer)
+		payloadForSharer := map[string]interface{}{"share_id": share.ID, "node_id": share.NodeID}
+		err = q.LogEvent(ctx, share.SharerID, "node_share_revoked", payloadForSharer)
 
 		return err
 	})
 
 	if txErr != nil {
-		log.Printf("ERROR: Failed to delete share in transaction: %v", txErr)
-		http.Error(w, "Failed to delete share", http.StatusInternalServerError)
-		return
+		return txErr
+	}
+
+	for _, location := range revoked.uploadLocations {
+		if err := s.storage.DeleteUploadArea(location); err != nil {
+			log.Printf("WARN: Failed to delete upload area %s of revoked share: %v", location, err)
+		}
 	}
 
-	payloadForRecipient := map[string]string{"node_id": shareInfo.NodeID}
+	payloadForRecipient := map[string]interface{}{"node_id": share.NodeID, "invalidated": revoked}
 	eventMsgRecipient := map[string]interface{}{"event_type": "share_revoked_for_you", "payload": payloadForRecipient}
 	eventBytesRecipient, _ := json.Marshal(eventMsgRecipient)
-	s.wsHub.PublishEvent(shareInfo.RecipientID, eventBytesRecipient)
+	s.wsHub.PublishEvent(share.RecipientID, eventBytesRecipient)
 
-	payloadForSharer := map[string]interface{}{"share_id": shareInfo.ID, "node_id": shareInfo.NodeID}
+	payloadForSharer := map[string]interface{}{"share_id": share.ID, "node_id": share.NodeID}
 	eventMsgSharer := map[string]interface{}{"event_type": "node_share_revoked", "payload": payloadForSharer}
 	eventBytesSharer, _ := json.Marshal(eventMsgSharer)
-	s.wsHub.PublishEvent(claims.UserID, eventBytesSharer)
+	s.wsHub.PublishEvent(share.SharerID, eventBytesSharer)
+	return nil
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// errShareNotFound aborts a share update when the share was deleted
+// concurrently.
+var errShareNotFound = errors.New("share not found")
+
+type UpdateShareRequest struct {
+	Permissions string `json:"permissions" example:"write" enums:"read,write,manage"`
+}
+
+// @Summary      Change share permissions
+// @Description  Changes a share between read, write and manage access without recreating it, so its shared_at is kept. Only the original sharer can do this; a sharer who is not the owner must still have manage permission on the node and cannot grant a higher permission than their own. Shares pinned to a file version stay read-only. The recipient is notified with a share_updated event.
+// @Tags         shares
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        shareId        path      int                 true  "ID of the share to update"
+// @Param        updateRequest  body      UpdateShareRequest  true  "New permissions"
+// @Success      200            {object}  dto.Share
+// @Failure      400            {string}  string "Bad Request"
+// @Failure      401            {string}  string "Unauthorized"
+// @Failure      403            {string}  string "Forbidden - Manage permission required or the permission is higher than the sharer's own"
+// @Failure      404            {string}  string "Not Found"
+// @Failure      500            {string}  string "Internal Server Error"
+// @Router       /shares/{shareId} [patch]
+func (s *Server) UpdateShareHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareIDFormat)
+		return
+	}
+
+	var req UpdateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if sharePermissionRanks[req.Permissions] == 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidPermissionsValue)
+		return
+	}
+
+	share, err := s.store.GetShareByID(r.Context(), shareID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareLookupFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotUpdatable)
+		return
+	}
+	if share.PinnedVersion != nil && req.Permissions != "read" {
+		writeError(w, r, http.StatusBadRequest, i18n.PinnedShareReadOnly)
+		return
+	}
+
+	// A recipient who shared the node further keeps control of the share only
+	// while they can still manage the node, and cannot grant more than they
+	// hold themselves.
+	node, err := s.store.GetNodeIfAccessible(r.Context(), share.NodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeAccessCheckFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusForbidden, i18n.ShareChangeRequiresManage)
+		return
+	}
+	if node.OwnerID != claims.UserID {
+		canManage, err := s.store.CheckManagePermission(r.Context(), claims.UserID, node.ID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+		if !canManage {
+			writeError(w, r, http.StatusForbidden, i18n.ShareChangeRequiresManage)
+			return
+		}
+		rank, err := s.effectivePermissionRank(r.Context(), node.ID, claims.UserID)
+		if err != nil {
+			log.Printf("ERROR: Failed to check the permission of user %d on node %s: %v", claims.UserID, node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+			return
+		}
+		if sharePermissionRanks[req.Permissions] > rank {
+			writeError(w, r, http.StatusForbidden, i18n.PermissionAboveOwn)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if share.Permissions == req.Permissions {
+		json.NewEncoder(w).Encode(dto.ShareFrom(*share))
+		return
+	}
+
+	previous := share.Permissions
+	var updated *models.Share
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		updated, err = q.UpdateSharePermissions(r.Context(), share.ID, claims.UserID, req.Permissions)
+		if err != nil {
+			return err
+		}
+		if updated == nil {
+			return errShareNotFound
+		}
+
+		payload := map[string]interface{}{"share_info": updated, "previous_permissions": previous}
+		if err := q.LogEvent(r.Context(), updated.RecipientID, "share_updated", payload); err != nil {
+			return err
+		}
+		return q.LogEvent(r.Context(), claims.UserID, "share_updated", payload)
+	})
+	if txErr != nil {
+		if errors.Is(txErr, errShareNotFound) {
+			writeError(w, r, http.StatusNotFound, i18n.ShareNotUpdatable)
+			return
+		}
+		log.Printf("ERROR: Failed to update share %d: %v", share.ID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareUpdateFailed)
+		return
+	}
+
+	payload := map[string]interface{}{"share_info": updated, "previous_permissions": previous}
+	eventMsg := map[string]interface{}{"event_type": "share_updated", "payload": payload}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(updated.RecipientID, eventBytes)
+	s.wsHub.PublishEvent(claims.UserID, eventBytes)
+
+	json.NewEncoder(w).Encode(dto.ShareFrom(*updated))
+}
+
+// shareActivityEventTypes are the events shown in a shared folder's activity
+// feed. Personal events such as favorites stay out of it.
+var shareActivityEventTypes = []string{
+	"node_created", "node_updated", "node_renamed", "node_moved", "node_trashed",
+	"node_restored", "node_tagged", "folder_cleaned_up",
+}
+
+type ShareActivityResponse struct {
+	ShareID int64           `json:"share_id" example:"42"`
+	NodeID  string          `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Events  []EventResponse `json:"events"`
+	// NextBefore is the cursor for older events: pass it as before to get the
+	// next page.
+	NextBefore int64 `json:"next_before,omitempty" example:"1180"`
+	HasMore    bool  `json:"has_more" example:"false"`
+}
+
+// @Summary      Get shared folder activity
+// @Description  Returns recent changes within a shared node's subtree (uploads, edits, renames, moves, trashing, restores and tags), newest first. Available to both the sharer and the recipient; the feed is read from the owner's event journal, so it includes changes made by either side.
+// @Tags         shares
+// @Produce      json
+// @Security     BearerAuth
+// @Param        shareId  path      int  true   "Share ID"
+// @Param        before   query     int  false  "Return events older than this event ID"
+// @Param        limit    query     int  false  "Maximum number of events to return (capped at 1000)" default(100)
+// @Success      200      {object}  ShareActivityResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /shares/{shareId}/activity [get]
+func (s *Server) GetShareActivityHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, _ := parsePagination(r)
+
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareId"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareIDFormat)
+		return
+	}
+	var before int64
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		before, err = strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidBefore)
+			return
+		}
+	}
+
+	share, err := s.store.GetShareForParticipant(r.Context(), shareID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.ShareLookupFailed)
+		return
+	}
+	if share == nil {
+		writeError(w, r, http.StatusNotFound, i18n.ShareNotFound)
+		return
+	}
+	node, err := s.store.GetNodeByID(r.Context(), share.NodeID, share.SharerID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		writeError(w, r, http.StatusNotFound, i18n.SharedNodeGone)
+		return
+	}
+
+	events, err := s.store.ReadReplica().ListSubtreeEvents(r.Context(), node.OwnerID, node.ID, shareActivityEventTypes, before, limit+1)
+	if err != nil {
+		log.Printf("ERROR: Failed to list activity of share %d: %v", share.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ActivityLookupFailed)
+		return
+	}
+
+	response := ShareActivityResponse{ShareID: share.ID, NodeID: node.ID, Events: []EventResponse{}}
+	if len(events) > limit {
+		events = events[:limit]
+		response.HasMore = true
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, EventResponse(event))
+		response.NextBefore = event.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// revokedSubtreeState lists what a recipient lost together with a share, so
+// their clients can drop the corresponding cached state.
+type revokedSubtreeState struct {
+	Watches         []string    `json:"watches"`
+	Favorites       []string    `json:"favorites"`
+	UploadSessions  []uuid.UUID `json:"upload_sessions"`
+	uploadLocations []string
+}
+
+// invalidateRevokedSubtree removes the recipient's watches, favorites and
+// pending upload sessions inside a no longer shared subtree, keeping those
+// still reachable through another share.
+func invalidateRevokedSubtree(ctx context.Context, q *database.Queries, recipientID int64, rootID string) (*revokedSubtreeState, error) {
+	revoked := &revokedSubtreeState{Watches: []string{}, Favorites: []string{}, UploadSessions: []uuid.UUID{}}
+
+	watchedNodes, err := q.ListWatchesInSubtree(ctx, recipientID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	for _, nodeID := range watchedNodes {
+		hasAccess, err := q.HasAccessToNode(ctx, nodeID, recipientID)
+		if err != nil {
+			return nil, err
+		}
+		if hasAccess {
+			continue
+		}
+		if _, err := q.UnwatchNode(ctx, recipientID, nodeID); err != nil {
+			return nil, err
+		}
+		revoked.Watches = append(revoked.Watches, nodeID)
+	}
+
+	favoriteNodes, err := q.ListFavoritesInSubtree(ctx, recipientID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	for _, nodeID := range favoriteNodes {
+		hasAccess, err := q.HasAccessToNode(ctx, nodeID, recipientID)
+		if err != nil {
+			return nil, err
+		}
+		if hasAccess {
+			continue
+		}
+		if _, err := q.RemoveFavorite(ctx, recipientID, nodeID); err != nil {
+			return nil, err
+		}
+		revoked.Favorites = append(revoked.Favorites, nodeID)
+	}
+
+	sessions, err := q.ListUploadSessionsInSubtree(ctx, recipientID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		canWrite, err := q.CheckWritePermission(ctx, recipientID, session.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if canWrite {
+			continue
+		}
+		if err := q.DeleteUploadSession(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		revoked.UploadSessions = append(revoked.UploadSessions, session.ID)
+		revoked.uploadLocations = append(revoked.uploadLocations, session.TempLocation)
+	}
+
+	return revoked, nil
+}
+
+// @Summary      List shares of a node
+// @Description  Lists the shares giving access to the node: shares of the node itself and shares inherited from its ancestor folders, each with the recipient user or group, the permission and the origin node. Group shares are listed once, not per member; see effective-access for the resulting access per user. Available to the owner and to users with manage permission.
+// @Tags         shares
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID"
+// @Success      200     {array}   dto.NodeShare
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - Manage permission required"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/shares [get]
+func (s *Server) ListNodeSharesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.NodeNotFoundOrDenied)
+		return
+	}
+	canManage, err := s.store.CheckManagePermission(r.Context(), claims.UserID, node.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !canManage {
+		writeError(w, r, http.StatusForbidden, i18n.ShareListRequiresManage)
+		return
+	}
+
+	shares, err := s.store.ListNodeShares(r.Context(), node.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list shares of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SharesLookupFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.NodeShares(shares))
 }
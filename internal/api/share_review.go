@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"time"
+)
+
+const (
+	defaultShareReviewMaxAgeDays           = 180
+	defaultShareReviewInactiveDays         = 90
+	defaultShareReviewReminderIntervalDays = 30
+	// maxShareReviewBatch bounds the stale shares handled in one run; the
+	// rest are picked up by the next one.
+	maxShareReviewBatch = 1000
+	maxRevokeShareIDs   = 1000
+)
+
+func configuredDays(days, fallback int) time.Duration {
+	if days <= 0 {
+		days = fallback
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// sendShareReviewReminders reminds sharers of their old shares that the
+// recipients no longer use. Each sharer gets one share_review_reminder event
+// listing the shares, whose IDs can be passed to POST /shares/outgoing/revoke.
+func (s *Server) sendShareReviewReminders(ctx context.Context) error {
+	cfg := s.config.Load().ShareReview
+	if !cfg.Enabled {
+		return nil
+	}
+	now := time.Now()
+	shares, err := s.store.ListStaleShares(ctx,
+		now.Add(-configuredDays(cfg.MaxAgeDays, defaultShareReviewMaxAgeDays)),
+		now.Add(-configuredDays(cfg.InactiveDays, defaultShareReviewInactiveDays)),
+		now.Add(-configuredDays(cfg.ReminderIntervalDays, defaultShareReviewReminderIntervalDays)),
+		maxShareReviewBatch)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(shares); {
+		end := start + 1
+		for end < len(shares) && shares[end].SharerID == shares[start].SharerID {
+			end++
+		}
+		if err := s.sendShareReviewReminder(ctx, shares[start:end], now); err != nil {
+			return err
+		}
+		start = end
+	}
+	if len(shares) > 0 {
+		log.Printf("Share review: reminded sharers of %d stale shares", len(shares))
+	}
+	return nil
+}
+
+func (s *Server) sendShareReviewReminder(ctx context.Context, shares []database.StaleShare, now time.Time) error {
+	sharerID := shares[0].SharerID
+	shareIDs := make([]int64, len(shares))
+	for i, share := range shares {
+		shareIDs[i] = share.ID
+	}
+	payload := map[string]interface{}{
+		"shares":    shares,
+		"share_ids": shareIDs,
+	}
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		if err := q.LogEvent(ctx, sharerID, "share_review_reminder", payload); err != nil {
+			return err
+		}
+		return q.MarkSharesReviewReminded(ctx, shareIDs, now)
+	})
+	if txErr != nil {
+		return txErr
+	}
+	eventMsg := map[string]interface{}{"event_type": "share_review_reminder", "payload": payload}
+	eventBytes, _ := json.Marshal(eventMsg)
+	s.wsHub.PublishEvent(sharerID, eventBytes)
+	return nil
+}
+
+type RevokeSharesRequest struct {
+	ShareIDs []int64 `json:"share_ids" example:"12,15,31"`
+}
+
+type RevokeSharesResponse struct {
+	Revoked []int64 `json:"revoked" example:"12,15"`
+	// NotFound lists shares that do not exist or were made by another user,
+	// including ones already revoked.
+	NotFound []int64 `json:"not_found" example:"31"`
+}
+
+// @Summary      Revoke several shares
+// @Description  Revokes up to 1000 of the user's own shares at once, e.g. all shares listed in a share_review_reminder event. Each share is revoked as by DELETE /shares/{shareId}. Shares that do not exist or belong to another sharer are reported in not_found instead of failing the request.
+// @Tags         shares
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      RevokeSharesRequest   true  "Shares to revoke"
+// @Success      200      {object}  RevokeSharesResponse
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /shares/outgoing/revoke [post]
+func (s *Server) RevokeSharesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req RevokeSharesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if len(req.ShareIDs) == 0 || len(req.ShareIDs) > maxRevokeShareIDs {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidShareIDsCount)
+		return
+	}
+
+	resp := RevokeSharesResponse{Revoked: []int64{}, NotFound: []int64{}}
+	seen := make(map[int64]bool, len(req.ShareIDs))
+	for _, shareID := range req.ShareIDs {
+		if seen[shareID] {
+			continue
+		}
+		seen[shareID] = true
+
+		share, err := s.store.GetShareByID(r.Context(), shareID, claims.UserID)
+		if err != nil {
+			log.Printf("ERROR: Failed to retrieve share %d for bulk revocation: %v", shareID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.SharesRevokeFailed)
+			return
+		}
+		if share == nil {
+			resp.NotFound = append(resp.NotFound, shareID)
+			continue
+		}
+		if err := s.revokeShare(r.Context(), share); err != nil {
+			log.Printf("ERROR: Failed to revoke share %d: %v", shareID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.SharesRevokeFailed)
+			return
+		}
+		resp.Revoked = append(resp.Revoked, shareID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
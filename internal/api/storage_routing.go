@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/storage"
+)
+
+// The current content of a file is stored in the backend recorded on the
+// node, chosen by the storage routing rules when the content was written.
+// Content is deduplicated per backend: files with identical content share one
+// blob, stored under a key derived from its SHA-256 checksum and reference
+// counted in content_blobs, and the blob is removed with its last file. Files
+// stored before deduplication keep their content under the node's ID. Staged
+// uploads, archived versions and derived artifacts always live in the local
+// storage.
+
+// routeContent picks the backend for new file content.
+func (s *Server) routeContent(sizeBytes int64, mimeType *string) (string, storage.Backend, error) {
+	var mime string
+	if mimeType != nil {
+		mime = *mimeType
+	}
+	name := s.blobs.Select(sizeBytes, mime)
+	backend, err := s.blobs.Backend(name)
+	return name, backend, err
+}
+
+// nodeBackend resolves the backend holding the content of a file and the key
+// the content is stored under.
+func (s *Server) nodeBackend(ctx context.Context, q *database.Queries, nodeID string) (string, string, storage.Backend, error) {
+	name, key, err := q.GetNodeStorageBackend(ctx, nodeID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if name == "" {
+		return "", "", nil, database.ErrNodeNotFound
+	}
+	backend, err := s.blobs.Backend(name)
+	return name, key, backend, err
+}
+
+// openNodeContent opens the current content of a file.
+func (s *Server) openNodeContent(ctx context.Context, nodeID string) (io.ReadCloser, error) {
+	_, key, backend, err := s.nodeBackend(ctx, s.store.Queries, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Get(key)
+}
+
+// contentBlobKey is the key of the deduplicated blob for content with the
+// given checksum.
+func contentBlobKey(contentSHA256 string) string {
+	return "sha256-" + contentSHA256
+}
+
+// storeContentBlob references the deduplicated blob for content staged in
+// from under stagedKey, within the transaction creating or updating the file.
+// Content new to the backend is moved into place; otherwise the staged copy is
+// a duplicate the caller removes once the transaction commits. It returns the
+// blob's key.
+func (s *Server) storeContentBlob(ctx context.Context, q *database.Queries, from storage.Backend, stagedKey, backendName string, backend storage.Backend, contentSHA256 string, sizeBytes int64) (string, bool, error) {
+	key := contentBlobKey(contentSHA256)
+	refs, err := q.AcquireContentBlob(ctx, backendName, key, sizeBytes)
+	if err != nil {
+		return "", false, err
+	}
+	if refs > 1 {
+		return key, true, nil
+	}
+	return key, false, storage.Transfer(from, stagedKey, backend, key)
+}
+
+// discardPlacedBlob removes a blob that storeContentBlob moved into place in
+// a transaction that was then rolled back, leaving it unregistered. A blob
+// registered again in the meantime, by another upload of the same content,
+// is kept.
+func (s *Server) discardPlacedBlob(ctx context.Context, backendName string, backend storage.Backend, key string) {
+	registered, err := s.store.ContentBlobRegistered(ctx, backendName, key)
+	if err == nil && !registered {
+		err = backend.Delete(key)
+	}
+	if err != nil {
+		log.Printf("CRITICAL: Failed to clean up orphaned content blob %s in storage backend %q: %v", key, backendName, err)
+	}
+}
+
+// deleteFileBlobs removes the content of deleted files, given as a map of node
+// IDs to their backends, and returns the IDs whose content was removed.
+func (s *Server) deleteFileBlobs(backends map[string]string) []string {
+	removed := make([]string, 0, len(backends))
+	for id, name := range backends {
+		backend, err := s.blobs.Backend(name)
+		if err == nil {
+			err = backend.Delete(id)
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to delete file %s from storage backend %q: %v", id, name, err)
+			continue
+		}
+		removed = append(removed, id)
+	}
+	return removed
+}
+
+// deleteContentBlobs removes deduplicated blobs no file references anymore.
+// Each blob is unregistered and deleted in one transaction, skipping those the
+// same content was uploaded to again after they were released.
+func (s *Server) deleteContentBlobs(ctx context.Context, blobs []database.ContentBlob) {
+	for _, blob := range blobs {
+		backend, err := s.blobs.Backend(blob.StorageBackend)
+		if err == nil {
+			err = s.store.ExecTx(ctx, func(q *database.Queries) error {
+				deleted, err := q.DeleteUnreferencedContentBlob(ctx, blob.StorageBackend, blob.StorageKey)
+				if err != nil || !deleted {
+					return err
+				}
+				return backend.Delete(blob.StorageKey)
+			})
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to delete content blob %s from storage backend %q: %v", blob.StorageKey, blob.StorageBackend, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+)
+
+type StorageBreakdownResponse struct {
+	UsedBytes  int64 `json:"used_bytes" example:"5242880"`
+	QuotaBytes int64 `json:"quota_bytes" example:"1073741824"`
+	// TrashCounted reports whether trashed files count against the quota.
+	TrashCounted bool                    `json:"trash_counted" example:"true"`
+	Live         LiveStorageBreakdown    `json:"live"`
+	Trash        TrashStorageUsage       `json:"trash"`
+	Versions     VersionStorageBreakdown `json:"versions"`
+}
+
+type LiveStorageBreakdown struct {
+	Files         int64 `json:"files" example:"42"`
+	UsedBytes     int64 `json:"used_bytes" example:"4194304"`
+	ZeroByteFiles int64 `json:"zero_byte_files" example:"3"`
+	Folders       int64 `json:"folders" example:"7"`
+	EmptyFolders  int64 `json:"empty_folders" example:"2"`
+}
+
+type TrashStorageUsage struct {
+	Files     int64 `json:"files" example:"5"`
+	Folders   int64 `json:"folders" example:"1"`
+	UsedBytes int64 `json:"used_bytes" example:"1048576"`
+}
+
+// VersionStorageBreakdown reports archived file versions, which never count
+// against the quota.
+type VersionStorageBreakdown struct {
+	Count     int64 `json:"count" example:"12"`
+	UsedBytes int64 `json:"used_bytes" example:"2097152"`
+}
+
+func (s *Server) trashCountsAgainstQuota() bool {
+	return !s.config.Load().Quota.ExcludeTrash
+}
+
+// syncTrashedStorage recomputes the storage used by an owner after files moved
+// in or out of the trash. It does nothing while trashed files count against
+// the quota, as moving them then does not change the usage.
+func (s *Server) syncTrashedStorage(ctx context.Context, q *database.Queries, ownerID int64) error {
+	if s.trashCountsAgainstQuota() {
+		return nil
+	}
+	_, _, err := q.ReconcileStorageUsage(ctx, ownerID, true)
+	return err
+}
+
+// reconcileStorageUsage recomputes the storage used by every user from their
+// files, correcting counters that drifted, e.g. after a crash between writing
+// a file and recording its size, or after quota.exclude_trash was changed.
+func (s *Server) reconcileStorageUsage(ctx context.Context) error {
+	userIDs, err := s.store.ListUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	excludeTrash := !s.trashCountsAgainstQuota()
+	corrected := 0
+	for _, userID := range userIDs {
+		var previous, current int64
+		err := s.store.ExecTx(ctx, func(q *database.Queries) error {
+			var err error
+			previous, current, err = q.ReconcileStorageUsage(ctx, userID, excludeTrash)
+			return err
+		})
+		if err != nil {
+			log.Printf("ERROR: Failed to reconcile storage usage of user %d: %v", userID, err)
+			continue
+		}
+		if previous != current {
+			log.Printf("WARN: Storage usage of user %d corrected from %d to %d bytes", userID, previous, current)
+			corrected++
+		}
+	}
+	if corrected > 0 {
+		log.Printf("Storage reconciliation: corrected the usage of %d users", corrected)
+	}
+	return nil
+}
+
+// @Summary      Get storage usage breakdown
+// @Description  Splits the storage of the authenticated user between live files, the trash and archived file versions. Zero-byte files and empty folders are counted separately, as they take no space. Trashed files count against the quota until the trash is purged, unless quota.exclude_trash is set; trash_counted tells which applies. Archived versions never count against the quota.
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  StorageBreakdownResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      404  {string}  string "User not found"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /me/storage/breakdown [get]
+func (s *Server) GetStorageBreakdownHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	user, err := s.store.GetUserByUsername(r.Context(), claims.Username)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UserDataLookupFailed)
+		return
+	}
+	if user == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+
+	breakdown, err := s.store.GetStorageBreakdown(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to get storage breakdown for user %d: %v", user.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.StorageBreakdownFailed)
+		return
+	}
+
+	response := StorageBreakdownResponse{
+		UsedBytes:    user.StorageUsedBytes,
+		QuotaBytes:   user.StorageQuotaBytes,
+		TrashCounted: s.trashCountsAgainstQuota(),
+		Live: LiveStorageBreakdown{
+			Files:         breakdown.LiveFiles,
+			UsedBytes:     breakdown.LiveBytes,
+			ZeroByteFiles: breakdown.ZeroByteFiles,
+			Folders:       breakdown.Folders,
+			EmptyFolders:  breakdown.EmptyFolders,
+		},
+		Trash: TrashStorageUsage{
+			Files:     breakdown.TrashedFiles,
+			Folders:   breakdown.TrashedFolders,
+			UsedBytes: breakdown.TrashedBytes,
+		},
+		Versions: VersionStorageBreakdown{
+			Count:     breakdown.Versions,
+			UsedBytes: breakdown.VersionBytes,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"time"
+)
+
+type SyncSnapshotResponse struct {
+	// Cursor is the event ID to pass as since to /events to receive the
+	// changes made after the snapshot.
+	Cursor      int64      `json:"cursor" example:"4821"`
+	Nodes       []dto.Node `json:"nodes"`
+	GeneratedAt time.Time  `json:"generated_at"`
+}
+
+// @Summary      Get sync snapshot
+// @Description  Returns the current state of the user's file tree (all active files and folders they own, parents before children) together with the current event cursor. New devices should bootstrap from the snapshot and then call /events with since=cursor for later changes instead of replaying the whole event history. Events recorded while the snapshot was taken may repeat changes already contained in it, so they must be applied idempotently.
+// @Tags         events
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  SyncSnapshotResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /sync/snapshot [get]
+func (s *Server) GetSyncSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	// The cursor is read before the tree, so no change can fall between the
+	// two; at worst a change is both in the snapshot and replayed as an event.
+	cursor, err := s.store.GetLatestEventID(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to read event cursor for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SnapshotCreateFailed)
+		return
+	}
+	generatedAt := time.Now()
+
+	nodes, err := s.store.ListOwnedTree(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list file tree for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.SnapshotCreateFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SyncSnapshotResponse{Cursor: cursor, Nodes: dto.Nodes(nodes), GeneratedAt: generatedAt})
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultThumbnailSize = 256
+	maxThumbnailBatch    = 100
+	thumbnailQuality     = 80
+	// maxThumbnailPixels bounds the images thumbnails are made of, so a small
+	// file declaring huge dimensions cannot exhaust memory when decoded.
+	maxThumbnailPixels = 40_000_000
+)
+
+// thumbnailSizes are the edge lengths thumbnails are made in. A thumbnail
+// fits in a square of that size, keeping the image's proportions.
+var thumbnailSizes = []int{64, 128, 256, 512}
+
+// thumbnailTypes are the image types thumbnails can be made of.
+var thumbnailTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+var errNoThumbnail = errors.New("no thumbnail can be made of this file")
+
+func parseThumbnailSize(value int) (int, error) {
+	if value == 0 {
+		return defaultThumbnailSize, nil
+	}
+	for _, size := range thumbnailSizes {
+		if value == size {
+			return size, nil
+		}
+	}
+	return 0, newOpError(http.StatusBadRequest, i18n.InvalidThumbnailSize)
+}
+
+// thumbnail returns a JPEG thumbnail of a file the user can access, making
+// it on first use and keeping it as a derived artifact of the content it was
+// made of. Recipients of a share pinned to a version get a thumbnail of that
+// version. errNoThumbnail is returned for files that are not images.
+func (s *Server) thumbnail(ctx context.Context, node *models.Node, userID int64, size int) ([]byte, error) {
+	if node.NodeType != "file" {
+		return nil, errNoThumbnail
+	}
+	pinned, err := s.pinnedVersionFor(ctx, node, userID)
+	if err != nil {
+		return nil, err
+	}
+	contentHash, mimeType := "", node.MimeType
+	if node.ContentSHA256 != nil {
+		contentHash = *node.ContentSHA256
+	}
+	open := func() (io.ReadCloser, error) { return s.openNodeContent(ctx, node.ID) }
+	if pinned != nil {
+		version := *pinned
+		backend, key, _, versionMimeType, err := s.nodeVersionBlob(ctx, node, version)
+		if err != nil {
+			return nil, err
+		}
+		contentHash, mimeType = fmt.Sprintf("version-%d", version), versionMimeType
+		open = func() (io.ReadCloser, error) { return backend.Get(key) }
+	}
+	if mimeType == nil || !thumbnailTypes[mediaTypeOf(*mimeType)] {
+		return nil, errNoThumbnail
+	}
+
+	kind := fmt.Sprintf("thumbnail-%d", size)
+	artifact, err := s.store.GetDerivedArtifact(ctx, node.ID, contentHash, kind)
+	if err != nil {
+		return nil, err
+	}
+	if artifact != nil {
+		if stored, err := s.storage.Get(artifact.StorageKey); err == nil {
+			defer stored.Close()
+			return io.ReadAll(stored)
+		}
+		log.Printf("WARN: Thumbnail %s of node %s is missing from storage, making it again", artifact.StorageKey, node.ID)
+	}
+
+	thumbnail, err := makeThumbnail(open, size)
+	if err != nil {
+		return nil, err
+	}
+	storageKey := "thumb-" + uuid.NewString()
+	if err := s.storage.Save(storageKey, bytes.NewReader(thumbnail)); err != nil {
+		return nil, err
+	}
+	previousKey, err := s.store.RegisterDerivedArtifact(ctx, database.RegisterDerivedArtifactParams{
+		NodeID:      node.ID,
+		ContentHash: contentHash,
+		Kind:        kind,
+		StorageKey:  storageKey,
+		SizeBytes:   int64(len(thumbnail)),
+	})
+	if err != nil {
+		s.storage.Delete(storageKey)
+		return nil, err
+	}
+	if previousKey != nil {
+		s.deleteDerivedArtifactBlobs([]string{*previousKey})
+	}
+	return thumbnail, nil
+}
+
+func mediaTypeOf(mimeType string) string {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// makeThumbnail decodes the image open yields and encodes it scaled down to
+// fit in a square of the given size. Transparent areas become white.
+func makeThumbnail(open func() (io.ReadCloser, error), size int) ([]byte, error) {
+	content, err := open()
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(content)
+	content.Close()
+	if err != nil || config.Width*config.Height > maxThumbnailPixels {
+		return nil, errNoThumbnail
+	}
+
+	content, err = open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	src, _, err := image.Decode(content)
+	if err != nil {
+		return nil, errNoThumbnail
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, scaleToFit(src, size), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
+
+// scaleToFit shrinks an image to fit in a square of the given size by
+// averaging the source pixels each target pixel covers. Smaller images keep
+// their size.
+func scaleToFit(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	targetWidth, targetHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			targetWidth, targetHeight = size, max(1, height*size/width)
+		} else {
+			targetWidth, targetHeight = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := bounds.Min.Y + y*height/targetHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			x0 := bounds.Min.X + x*width/targetWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/targetWidth)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			// The colors are premultiplied, so adding the missing opacity
+			// puts the pixel on a white background.
+			white := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// @Summary      Get a file thumbnail
+// @Description  Returns a JPEG thumbnail of an image (JPEG, PNG or GIF) that fits in a square of the given size. Thumbnails are made on first request and kept until the file's content changes. For many files at once use POST /thumbnails/batch.
+// @Tags         nodes
+// @Produce      image/jpeg
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true   "Node ID of the image"
+// @Param        size    query     int     false  "Edge length in pixels: 64, 128, 256 or 512" default(256)
+// @Success      200     {file}    binary  "The thumbnail"
+// @Failure      400     {string}  string "Bad Request - Invalid size"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - The file is quarantined"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      415     {string}  string "Unsupported Media Type - No thumbnail can be made of this file"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/thumbnail [get]
+func (s *Server) GetThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	requested := 0
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		if requested, err = strconv.Atoi(value); err != nil {
+			requested = -1
+		}
+	}
+	size, err := parseThumbnailSize(requested)
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
+		return
+	}
+	if quarantined, err := s.isQuarantined(r.Context(), node.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	} else if quarantined {
+		writeError(w, r, http.StatusForbidden, i18n.QuarantinedFile)
+		return
+	}
+
+	thumbnail, err := s.thumbnail(r.Context(), node, claims.UserID, size)
+	if err != nil {
+		if errors.Is(err, errNoThumbnail) {
+			writeError(w, r, http.StatusUnsupportedMediaType, i18n.NoThumbnail)
+			return
+		}
+		log.Printf("ERROR: Failed to make thumbnail of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ThumbnailFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(thumbnail)
+}
+
+type ThumbnailBatchRequest struct {
+	NodeIDs []string `json:"node_ids" example:"_vx2a-43VqRT5wz_s9u4,fLW5kAh2ia9vYmjMnU4nZ"`
+	Size    int      `json:"size,omitempty" example:"128"`
+}
+
+// @Summary      Get thumbnails of many files
+// @Description  Returns thumbnails of up to 100 images in one ZIP archive, so gallery views need a single request instead of one per file. Each thumbnail is an uncompressed "<node id>.jpg" entry, made as by GET /nodes/{nodeId}/thumbnail. Files that are not accessible, quarantined or not images are left out and listed in the X-Thumbnails-Missing header.
+// @Tags         nodes
+// @Accept       json
+// @Produce      application/zip
+// @Security     BearerAuth
+// @Param        request  body      ThumbnailBatchRequest  true  "Files and thumbnail size"
+// @Success      200      {file}    binary  "ZIP archive of the thumbnails"
+// @Header       200      {string}  X-Thumbnails-Missing  "Comma-separated IDs of the requested files without a thumbnail"
+// @Failure      400      {string}  string "Bad Request - No node IDs, too many node IDs or invalid size"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /thumbnails/batch [post]
+func (s *Server) ThumbnailBatchHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req ThumbnailBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if len(req.NodeIDs) == 0 || len(req.NodeIDs) > maxThumbnailBatch {
+		writeErrorf(w, r, http.StatusBadRequest, i18n.InvalidThumbnailBatch, maxThumbnailBatch)
+		return
+	}
+	size, err := parseThumbnailSize(req.Size)
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	quarantined, err := s.store.QuarantinedNodeIDs(r.Context(), req.NodeIDs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return
+	}
+	isQuarantined := make(map[string]bool, len(quarantined))
+	for _, nodeID := range quarantined {
+		isQuarantined[nodeID] = true
+	}
+
+	// Thumbnails are small, so they are all made before the response starts
+	// and the missing ones can be listed in a header.
+	type entry struct {
+		node      *models.Node
+		thumbnail []byte
+	}
+	var entries []entry
+	missing := []string{}
+	seen := make(map[string]bool, len(req.NodeIDs))
+	for _, nodeID := range req.NodeIDs {
+		if seen[nodeID] {
+			continue
+		}
+		seen[nodeID] = true
+		if isQuarantined[nodeID] {
+			missing = append(missing, nodeID)
+			continue
+		}
+		node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, claims.UserID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+			return
+		}
+		if node == nil {
+			missing = append(missing, nodeID)
+			continue
+		}
+		thumbnail, err := s.thumbnail(r.Context(), node, claims.UserID, size)
+		if err != nil {
+			if !errors.Is(err, errNoThumbnail) {
+				log.Printf("ERROR: Failed to make thumbnail of node %s: %v", node.ID, err)
+			}
+			missing = append(missing, nodeID)
+			continue
+		}
+		entries = append(entries, entry{node: node, thumbnail: thumbnail})
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("X-Thumbnails-Missing", strings.Join(missing, ","))
+	zipWriter := zip.NewWriter(w)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.node.ID + ".jpg", Method: zip.Store, Modified: e.node.ModifiedAt}
+		header.SetMode(0o644)
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err == nil {
+			_, err = entryWriter.Write(e.thumbnail)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to write thumbnail archive: %v", err)
+			return
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		log.Printf("ERROR: Failed to write thumbnail archive: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/transcription"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTranscriptionTimeout   = 10 * time.Minute
+	defaultTranscriptionMaxSizeMB = 25
+
+	// maxFreeNameAttempts bounds the search for a free node name.
+	maxFreeNameAttempts = 20
+)
+
+type TranscriptionRequest struct {
+	// Format of the transcript: "vtt" (subtitles, default) or "txt".
+	Format string `json:"format,omitempty" example:"vtt"`
+}
+
+func (s *Server) transcriptionMaxBytes() int64 {
+	if s.config.Load().Transcription.MaxSizeMB > 0 {
+		return s.config.Load().Transcription.MaxSizeMB << 20
+	}
+	return defaultTranscriptionMaxSizeMB << 20
+}
+
+func isTranscribable(node *models.Node) bool {
+	if node.NodeType != "file" || node.MimeType == nil {
+		return false
+	}
+	return strings.HasPrefix(*node.MimeType, "audio/") || strings.HasPrefix(*node.MimeType, "video/")
+}
+
+// publishTranscriptionEvent pushes a job update to the requester. Progress
+// updates are only sent over WebSocket; final states are journaled as well.
+func (s *Server) publishTranscriptionEvent(ctx context.Context, eventType string, job *database.TranscriptionJob, journal bool) {
+	if journal {
+		if err := s.store.LogEvent(ctx, job.RequestedBy, eventType, job); err != nil {
+			log.Printf("ERROR: Failed to journal %s for transcription %s: %v", eventType, job.ID, err)
+		}
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": dto.TranscriptionJobFrom(*job)})
+	s.wsHub.PublishEvent(job.RequestedBy, eventBytes)
+}
+
+func (s *Server) updateTranscriptionJob(ctx context.Context, job *database.TranscriptionJob, status string, progress int, resultNodeID *string, errorMessage *string) *database.TranscriptionJob {
+	updated, err := s.store.UpdateTranscriptionJob(ctx, job.ID, status, progress, resultNodeID, errorMessage)
+	if err != nil || updated == nil {
+		log.Printf("ERROR: Failed to update transcription %s: %v", job.ID, err)
+		return job
+	}
+	return updated
+}
+
+// freeNodeName picks a free name for a new node in a folder, e.g.
+// "nagranie.vtt", then "nagranie (2).vtt".
+func (s *Server) freeNodeName(ctx context.Context, ownerID int64, parentID *string, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for attempt := 1; attempt <= maxFreeNameAttempts; attempt++ {
+		candidate := name
+		if attempt > 1 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, attempt, ext)
+		}
+		taken, err := s.store.NodeNameTaken(ctx, ownerID, parentID, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", name)
+}
+
+// createSidecar stores a transcript as a new file next to its source, owned by
+// the source's owner.
+func (s *Server) createSidecar(ctx context.Context, requestedBy int64, source *models.Node, format string, transcript []byte) (*models.Node, error) {
+	owner, err := s.store.GetUserByID(ctx, source.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil {
+		return nil, fmt.Errorf("owner %d not found", source.OwnerID)
+	}
+	size := int64(len(transcript))
+	if owner.StorageUsedBytes+size > owner.StorageQuotaBytes {
+		return nil, errQuotaExceeded
+	}
+
+	base := strings.TrimSuffix(source.Name, path.Ext(source.Name))
+	name, err := s.freeNodeName(ctx, source.OwnerID, source.ParentID, base+"."+format)
+	if err != nil {
+		return nil, err
+	}
+	nodeID, err := s.generateUniqueID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := "text/plain; charset=utf-8"
+	if format == transcription.FormatVTT {
+		mimeType = "text/vtt"
+	}
+	backendName, backend, err := s.routeContent(size, &mimeType)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Save(nodeID, bytes.NewReader(transcript)); err != nil {
+		return nil, fmt.Errorf("failed to save transcript: %w", err)
+	}
+	sum := sha256.Sum256(transcript)
+	contentSHA256 := hex.EncodeToString(sum[:])
+
+	var sidecar *models.Node
+	txErr := s.store.ExecTx(ctx, func(q *database.Queries) error {
+		var err error
+		sidecar, err = q.CreateNode(ctx, database.CreateNodeParams{
+			ID:             nodeID,
+			OwnerID:        source.OwnerID,
+			ParentID:       source.ParentID,
+			Name:           name,
+			NodeType:       "file",
+			SizeBytes:      &size,
+			MimeType:       &mimeType,
+			StorageBackend: backendName,
+			ContentSHA256:  &contentSHA256,
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.LogEvents(ctx, nodeCreatedEvents(requestedBy, source.OwnerID, sidecar)); err != nil {
+			return err
+		}
+		return q.UpdateUserStorage(ctx, source.OwnerID, size)
+	})
+	if txErr != nil {
+		if cleanupErr := backend.Delete(nodeID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up orphaned transcript %s: %v", nodeID, cleanupErr)
+		}
+		return nil, txErr
+	}
+
+	s.publishUploadedNodes(ctx, requestedBy, &source.OwnerID, source.ParentID, []models.Node{*sidecar})
+	return sidecar, nil
+}
+
+func (s *Server) runTranscription(ctx context.Context, job *database.TranscriptionJob) {
+	fail := func(message string) {
+		job = s.updateTranscriptionJob(ctx, job, database.TranscriptionFailed, job.Progress, nil, &message)
+		s.publishTranscriptionEvent(ctx, "transcription_failed", job, true)
+	}
+
+	source, err := s.store.GetNodeIfAccessible(ctx, job.NodeID, job.RequestedBy)
+	if err != nil {
+		log.Printf("ERROR: Failed to load node %s for transcription %s: %v", job.NodeID, job.ID, err)
+		fail("Failed to load the file")
+		return
+	}
+	if source == nil {
+		fail("The file is no longer available")
+		return
+	}
+
+	job = s.updateTranscriptionJob(ctx, job, database.TranscriptionRunning, 10, nil, nil)
+	s.publishTranscriptionEvent(ctx, "transcription_progress", job, false)
+
+	audio, err := s.openNodeContent(ctx, source.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to open %s for transcription %s: %v", source.ID, job.ID, err)
+		fail("Failed to read the file")
+		return
+	}
+	transcript, err := s.transcriber.Transcribe(ctx, source.Name, audio, job.Format)
+	audio.Close()
+	if err != nil {
+		log.Printf("WARN: Transcription %s of node %s failed: %v", job.ID, source.ID, err)
+		fail("The transcription service could not process the file")
+		return
+	}
+
+	job = s.updateTranscriptionJob(ctx, job, database.TranscriptionRunning, 90, nil, nil)
+	s.publishTranscriptionEvent(ctx, "transcription_progress", job, false)
+
+	sidecar, err := s.createSidecar(ctx, job.RequestedBy, source, job.Format, transcript)
+	if err != nil {
+		log.Printf("ERROR: Failed to store transcript of transcription %s: %v", job.ID, err)
+		if errors.Is(err, errQuotaExceeded) {
+			fail("Storage quota for the owner of this file is exceeded")
+		} else {
+			fail("Failed to store the transcript")
+		}
+		return
+	}
+
+	job = s.updateTranscriptionJob(ctx, job, database.TranscriptionCompleted, 100, &sidecar.ID, nil)
+	s.publishTranscriptionEvent(ctx, "transcription_completed", job, true)
+}
+
+// processTranscriptions works through the transcription queue until it is
+// empty.
+func (s *Server) processTranscriptions(ctx context.Context) error {
+	if s.transcriber == nil {
+		return nil
+	}
+	staleAfter := 2 * defaultTranscriptionTimeout
+	if s.config.Load().Transcription.TimeoutSeconds > 0 {
+		staleAfter = 2 * time.Duration(s.config.Load().Transcription.TimeoutSeconds) * time.Second
+	}
+
+	for ctx.Err() == nil {
+		job, err := s.store.ClaimTranscriptionJob(ctx, staleAfter)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		s.runTranscription(ctx, job)
+	}
+	return nil
+}
+
+// @Summary      Request a transcription
+// @Description  Queues a transcription of an audio or video file by the configured Whisper-compatible service. The transcript is saved next to the file as a .vtt (subtitles) or .txt file owned by the file's owner. Progress is reported over WebSocket as "transcription_progress" events, followed by "transcription_completed" or "transcription_failed". Requires write permission in the folder containing the file.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId   path      string                true  "Audio or video file ID"
+// @Param        request  body      TranscriptionRequest  false "Transcript format"
+// @Success      202      {object}  dto.TranscriptionJob
+// @Failure      400      {string}  string "Bad Request - Not an audio or video file, or invalid format"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Write permission denied"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      413      {string}  string "Payload Too Large - File exceeds the transcription size limit"
+// @Failure      503      {string}  string "Service Unavailable - Transcription is not configured"
+// @Router       /nodes/{nodeId}/transcriptions [post]
+func (s *Server) RequestTranscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	if s.transcriber == nil {
+		writeError(w, r, http.StatusServiceUnavailable, i18n.TranscriptionNotConfigured)
+		return
+	}
+
+	req := TranscriptionRequest{Format: transcription.FormatVTT}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+			return
+		}
+		if req.Format == "" {
+			req.Format = transcription.FormatVTT
+		}
+	}
+	if req.Format != transcription.FormatVTT && req.Format != transcription.FormatText {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidTranscriptFormat)
+		return
+	}
+
+	node, err := s.store.GetNodeIfAccessible(r.Context(), chi.URLParam(r, "nodeId"), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeLookupFailed)
+		return
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, chi.URLParam(r, "nodeId"), i18n.NodeNotFoundOrDenied)
+		return
+	}
+	if !isTranscribable(node) {
+		writeError(w, r, http.StatusBadRequest, i18n.NotTranscribable)
+		return
+	}
+	if node.SizeBytes != nil && *node.SizeBytes > s.transcriptionMaxBytes() {
+		writeErrorf(w, r, http.StatusRequestEntityTooLarge, i18n.TranscriptionTooLarge, s.transcriptionMaxBytes()>>20)
+		return
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, node.ParentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return
+	}
+
+	job, err := s.store.CreateTranscriptionJob(r.Context(), node.ID, claims.UserID, req.Format)
+	if err != nil {
+		log.Printf("ERROR: Failed to queue transcription of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.TranscriptionQueueFailed)
+		return
+	}
+	s.publishTranscriptionEvent(r.Context(), "transcription_progress", job, false)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.TranscriptionJobFrom(*job))
+}
+
+// @Summary      Get a transcription job
+// @Description  Returns the status and progress of a transcription requested by the user. Completed jobs carry the ID of the transcript file.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        jobId  path      string  true  "Transcription job ID"
+// @Success      200    {object}  dto.TranscriptionJob
+// @Failure      400    {string}  string "Invalid job ID"
+// @Failure      401    {string}  string "Unauthorized"
+// @Failure      404    {string}  string "Not Found"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /transcriptions/{jobId} [get]
+func (s *Server) GetTranscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	jobID, err := uuid.Parse(chi.URLParam(r, "jobId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidJobID)
+		return
+	}
+
+	job, err := s.store.GetTranscriptionJob(r.Context(), jobID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.TranscriptionLookupFailed)
+		return
+	}
+	if job == nil {
+		writeError(w, r, http.StatusNotFound, i18n.TranscriptionNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.TranscriptionJobFrom(*job))
+}
@@ -6,13 +6,17 @@ import (
 	"log"
 	"net/http"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
 	"serwer-plikow/internal/models"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 // @Summary      Purge trash
-// @Description  Permanently deletes all files and folders from the user's trash. This action cannot be undone.
+// @Description  Permanently deletes all files and folders from the user's trash. This action cannot be undone. Content shared with other files through deduplication is kept until the last of them is purged. With storage.secure_delete enabled, file content is overwritten before removal and every purged file stored on its own is recorded in the access log as "shredded".
 // @Tags         trash
 // @Security     BearerAuth
 // @Success      204  {null}    nil "No Content"
@@ -23,16 +27,39 @@ func (s *Server) PurgeTrashHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 
 	var deletedFileIDs []string
+	var fileBackends map[string]string
+	var releasedBlobs []database.ContentBlob
+	var artifactKeys []string
+	var versionKeys []string
 	var totalSizeFreed int64
 
 	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
 		var err error
+		fileBackends, err = q.ListTrashedFileBackends(r.Context(), claims.UserID)
+		if err != nil {
+			return err
+		}
+		releasedBlobs, err = q.ReleaseTrashedContentBlobs(r.Context(), claims.UserID)
+		if err != nil {
+			return err
+		}
+
 		deletedFileIDs, totalSizeFreed, err = q.PurgeTrash(r.Context(), claims.UserID)
 		if err != nil {
 			return err
 		}
 
-		if totalSizeFreed > 0 {
+		artifactKeys, err = q.DeleteDerivedArtifactsForNodes(r.Context(), deletedFileIDs)
+		if err != nil {
+			return err
+		}
+
+		versionKeys, err = q.DeleteNodeVersionsForNodes(r.Context(), deletedFileIDs)
+		if err != nil {
+			return err
+		}
+
+		if totalSizeFreed > 0 && s.trashCountsAgainstQuota() {
 			return q.UpdateUserStorage(r.Context(), claims.UserID, -totalSizeFreed)
 		}
 
@@ -40,40 +67,91 @@ func (s *Server) PurgeTrashHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if txErr != nil {
-		http.Error(w, "Failed to purge trash", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.TrashPurgeFailed)
 		return
 	}
 
-	for _, fileID := range deletedFileIDs {
-		if err := s.storage.Delete(fileID); err != nil {
-			log.Printf("WARN: Failed to delete file %s from storage during purge: %v", fileID, err)
+	shredded := s.deleteFileBlobs(fileBackends)
+	s.recordShredded(r, claims.UserID, claims.UserID, shredded)
+	s.deleteContentBlobs(r.Context(), releasedBlobs)
+	for _, key := range versionKeys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("WARN: Failed to delete file version %s from storage during purge: %v", key, err)
 		}
 	}
+	s.deleteDerivedArtifactBlobs(artifactKeys)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // @Summary      List trash contents
-// @Description  Retrieves a list of all files and folders currently in the user's trash.
+// @Description  Retrieves a list of all files and folders currently in the user's trash. Each entry carries the deletion_batch_id of the delete operation that trashed it; pass batch_id to list a single batch.
 // @Tags         trash
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {array}   NodeResponse
-// @Failure      401  {string}  string "Unauthorized"
-// @Failure      500  {string}  string "Internal Server Error"
+// @Param        batch_id  query     string  false  "Only list nodes trashed in this deletion batch"
+// @Param        limit     query     int     false  "Number of items to return" default(100)
+// @Param        offset    query     int     false  "Offset for pagination" default(0)
+// @Param        fields    query     string  false  "Comma-separated JSON fields to return for each item, e.g. id,name,node_type,modified_at"
+// @Success      200       {array}   dto.Node
+// @Failure      400       {string}  string "Bad Request - Invalid batch ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      500       {string}  string "Internal Server Error"
 // @Router       /trash [get]
 func (s *Server) ListTrashHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	limit, offset := parsePagination(r)
 
-	nodes, err := s.store.ListTrash(r.Context(), claims.UserID, limit, offset)
+	var batchID *uuid.UUID
+	if raw := r.URL.Query().Get("batch_id"); raw != "" {
+		parsed, err := uuid.Parse(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidBatchIDFormat)
+			return
+		}
+		batchID = &parsed
+	}
+
+	nodes, err := s.store.ReadReplica().ListTrash(r.Context(), claims.UserID, batchID, limit, offset)
 	if err != nil {
-		http.Error(w, "Failed to list trash contents", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.TrashListFailed)
+		return
+	}
+
+	writeListing(w, r, dto.Nodes(nodes))
+}
+
+type TrashSummaryResponse struct {
+	TotalItems     int64      `json:"total_items" example:"12"`
+	TotalBytes     int64      `json:"total_bytes" example:"1048576"`
+	OldestDeletion *time.Time `json:"oldest_deletion,omitempty"`
+}
+
+// @Summary      Get trash summary
+// @Description  Returns aggregate information about the user's trash: the number of items, the total size of trashed files and the date of the oldest deletion.
+// @Tags         trash
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  TrashSummaryResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /trash/summary [get]
+func (s *Server) GetTrashSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	summary, err := s.store.ReadReplica().GetTrashSummary(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("ERROR: Failed to compute trash summary for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.TrashSummaryFailed)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	json.NewEncoder(w).Encode(TrashSummaryResponse{
+		TotalItems:     summary.TotalItems,
+		TotalBytes:     summary.TotalBytes,
+		OldestDeletion: summary.OldestDeletion,
+	})
 }
 
 // @Summary      Restore a node from trash
@@ -101,6 +179,9 @@ func (s *Server) RestoreNodeHandler(w http.ResponseWriter, r *http.Request) {
 		if !success {
 			return database.ErrNodeNotFound
 		}
+		if err := s.syncTrashedStorage(r.Context(), q, claims.UserID); err != nil {
+			return err
+		}
 
 		restoredNode, err = q.GetNodeByID(r.Context(), nodeID, claims.UserID)
 		if err != nil {
@@ -115,20 +196,123 @@ func (s *Server) RestoreNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	if txErr != nil {
 		if errors.Is(txErr, database.ErrNodeNotFound) {
-			http.Error(w, "Node not found in trash...", http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, i18n.NodeNotInTrash)
 			return
 		}
 		if errors.Is(txErr, database.ErrDuplicateNodeName) {
-			http.Error(w, "Cannot restore: a node with the same name already exists...", http.StatusConflict)
+			writeError(w, r, http.StatusConflict, i18n.RestoreNameTaken)
 			return
 		}
-		http.Error(w, "Failed to restore node", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NodeRestoreFailed)
 		return
 	}
 
 	eventMsg := map[string]interface{}{"event_type": "node_restored", "payload": restoredNode}
 	eventBytes, _ := json.Marshal(eventMsg)
 	s.wsHub.PublishEvent(claims.UserID, eventBytes)
+	s.notifyWatchers(r.Context(), []string{restoredNode.ID}, eventBytes, claims.UserID)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// @Summary      List trash grouped by deletion
+// @Description  Lists the user's trash grouped by the delete operation that trashed it, newest first. Each batch names the node the user deleted (the root) and counts it together with its trashed subtree.
+// @Tags         trash
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query     int  false  "Number of batches to return" default(100)
+// @Param        offset  query     int  false  "Offset for pagination" default(0)
+// @Success      200     {array}   dto.TrashBatch
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /trash/batches [get]
+func (s *Server) ListTrashBatchesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	limit, offset := parsePagination(r)
+
+	batches, err := s.store.ReadReplica().ListTrashBatches(r.Context(), claims.UserID, limit, offset)
+	if err != nil {
+		log.Printf("ERROR: Failed to list trash batches for user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.TrashListFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.TrashBatches(batches))
+}
+
+// @Summary      Restore a deletion batch
+// @Description  Restores everything trashed by a single delete operation: the deleted node and its whole subtree return to their original locations. Fails without restoring anything if a node with the same name already exists in the original location.
+// @Tags         trash
+// @Produce      json
+// @Security     BearerAuth
+// @Param        batchId  path      string  true  "Deletion batch ID"
+// @Success      200      {array}   dto.Node "Restored root nodes"
+// @Failure      400      {string}  string "Bad Request - Invalid batch ID"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found"
+// @Failure      409      {string}  string "Conflict - a node with the same name already exists in the original location"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /trash/batches/{batchId}/restore [post]
+func (s *Server) RestoreTrashBatchHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	batchID, err := uuid.Parse(chi.URLParam(r, "batchId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidBatchIDFormat)
+		return
+	}
+
+	restoredNodes := []models.Node{}
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		rootIDs, err := q.RestoreTrashBatch(r.Context(), batchID, claims.UserID)
+		if err != nil {
+			return err
+		}
+		if len(rootIDs) == 0 {
+			return database.ErrNodeNotFound
+		}
+		if err := s.syncTrashedStorage(r.Context(), q, claims.UserID); err != nil {
+			return err
+		}
+
+		for _, id := range rootIDs {
+			node, err := q.GetNodeByID(r.Context(), id, claims.UserID)
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return errors.New("failed to retrieve restored node")
+			}
+			if err := q.LogEvent(r.Context(), claims.UserID, "node_restored", node); err != nil {
+				return err
+			}
+			restoredNodes = append(restoredNodes, *node)
+		}
+		return nil
+	})
+
+	if txErr != nil {
+		if errors.Is(txErr, database.ErrNodeNotFound) {
+			writeError(w, r, http.StatusNotFound, i18n.BatchNotInTrash)
+			return
+		}
+		if errors.Is(txErr, database.ErrDuplicateNodeName) {
+			writeError(w, r, http.StatusConflict, i18n.RestoreNameTakenInOrigin)
+			return
+		}
+		log.Printf("ERROR: Failed to restore trash batch %s: %v", batchID, txErr)
+		writeError(w, r, http.StatusInternalServerError, i18n.BatchRestoreFailed)
+		return
+	}
+
+	for _, node := range restoredNodes {
+		eventMsg := map[string]interface{}{"event_type": "node_restored", "payload": node}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(claims.UserID, eventBytes)
+		s.notifyWatchers(r.Context(), []string{node.ID}, eventBytes, claims.UserID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.Nodes(restoredNodes))
+}
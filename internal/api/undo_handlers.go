@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/ids"
+	"serwer-plikow/internal/models"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const defaultUndoWindow = 30 * time.Second
+
+var (
+	errUndoConflict  = errors.New("the node has changed since the operation and it can no longer be undone")
+	errUndoForbidden = errors.New("you no longer have permission to restore this item")
+)
+
+type undoEvent struct {
+	eventType string
+	payload   interface{}
+}
+
+func (s *Server) undoWindow() time.Duration {
+	if s.config.Load().Undo.WindowSeconds > 0 {
+		return time.Duration(s.config.Load().Undo.WindowSeconds) * time.Second
+	}
+	return defaultUndoWindow
+}
+
+// issueUndoToken records the state needed to reverse an operation and returns
+// the token in the X-Undo-Token header. It must be called before the response
+// status is written. A failure only costs the user the undo option, so it is
+// logged instead of failing the request.
+func (s *Server) issueUndoToken(w http.ResponseWriter, r *http.Request, node *models.Node, operation string, before, after database.NodePlacement) {
+	claims := GetUserFromContext(r.Context())
+
+	token, err := ids.Token()
+	if err != nil {
+		log.Printf("WARN: Failed to generate undo token for node %s: %v", node.ID, err)
+		return
+	}
+	expiresAt := time.Now().Add(s.undoWindow())
+
+	err = s.store.CreateUndoToken(r.Context(), database.UndoToken{
+		Token:     token,
+		UserID:    claims.UserID,
+		NodeID:    node.ID,
+		OwnerID:   node.OwnerID,
+		Operation: operation,
+		Before:    before,
+		After:     after,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("WARN: Failed to store undo token for node %s: %v", node.ID, err)
+		return
+	}
+
+	w.Header().Set("X-Undo-Token", token)
+	w.Header().Set("X-Undo-Expires-At", expiresAt.UTC().Format(time.RFC3339))
+}
+
+func (s *Server) pruneExpiredUndoTokens(ctx context.Context) error {
+	_, err := s.store.DeleteExpiredUndoTokens(ctx)
+	return err
+}
+
+func sameParent(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// @Summary      Undo an operation
+// @Description  Reverses a delete, rename or move using the token returned in the X-Undo-Token header of that response. Tokens are single-use and expire after a short window (X-Undo-Expires-At). A deleted node is restored together with everything trashed by the same operation; a renamed or moved node gets its previous name and location back, provided it has not been changed again in the meantime.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        token  path      string  true  "Undo token"
+// @Success      200    {object}  dto.Node
+// @Failure      401    {string}  string "Unauthorized"
+// @Failure      403    {string}  string "Forbidden - No longer allowed to modify the original location"
+// @Failure      404    {string}  string "Undo token not found or expired"
+// @Failure      409    {string}  string "Conflict - The node has changed or its name is taken"
+// @Failure      500    {string}  string "Internal Server Error"
+// @Router       /undo/{token} [post]
+func (s *Server) UndoHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	token := chi.URLParam(r, "token")
+
+	var undone *models.Node
+	var undo *database.UndoToken
+	events := []undoEvent{}
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		undo, err = q.ConsumeUndoToken(r.Context(), token, claims.UserID)
+		if err != nil {
+			return err
+		}
+		if undo == nil {
+			return database.ErrNodeNotFound
+		}
+
+		hasPermission, err := q.CheckWritePermission(r.Context(), claims.UserID, undo.Before.ParentID)
+		if err != nil {
+			return err
+		}
+		if !hasPermission {
+			return errUndoForbidden
+		}
+
+		switch undo.Operation {
+		case database.UndoOperationTrash:
+			if undo.After.DeletionBatchID == nil {
+				return errUndoConflict
+			}
+			rootIDs, err := q.RestoreTrashBatch(r.Context(), *undo.After.DeletionBatchID, undo.OwnerID)
+			if err != nil {
+				return err
+			}
+			if len(rootIDs) == 0 {
+				return errUndoConflict
+			}
+			if err := s.syncTrashedStorage(r.Context(), q, undo.OwnerID); err != nil {
+				return err
+			}
+			for _, id := range rootIDs {
+				node, err := q.GetNodeByID(r.Context(), id, undo.OwnerID)
+				if err != nil {
+					return err
+				}
+				if node == nil {
+					return errors.New("failed to retrieve restored node")
+				}
+				if node.ID == undo.NodeID {
+					undone = node
+				}
+				events = append(events, undoEvent{"node_restored", node})
+			}
+
+		case database.UndoOperationUpdate:
+			node, err := q.GetNodeByID(r.Context(), undo.NodeID, undo.OwnerID)
+			if err != nil {
+				return err
+			}
+			if node == nil || node.Name != undo.After.Name || !sameParent(node.ParentID, undo.After.ParentID) {
+				return errUndoConflict
+			}
+
+			moved := !sameParent(undo.Before.ParentID, undo.After.ParentID)
+			if moved {
+				hasPermission, err := q.CheckWritePermission(r.Context(), claims.UserID, node.ParentID)
+				if err != nil {
+					return err
+				}
+				if !hasPermission {
+					return errUndoForbidden
+				}
+				if node.NodeType == "folder" && undo.Before.ParentID != nil {
+					isCircular, err := q.IsDescendantOf(r.Context(), node.ID, *undo.Before.ParentID)
+					if err != nil {
+						return err
+					}
+					if isCircular {
+						return errUndoConflict
+					}
+				}
+			}
+
+			success, err := q.RestoreNodePlacement(r.Context(), node.ID, undo.OwnerID, undo.Before.Name, undo.Before.ParentID)
+			if err != nil {
+				return err
+			}
+			if !success {
+				return errUndoConflict
+			}
+			if node.Name != undo.Before.Name {
+				events = append(events, undoEvent{"node_renamed", map[string]interface{}{"id": node.ID, "new_name": undo.Before.Name, "old_name": node.Name}})
+			}
+			if moved {
+				events = append(events, undoEvent{"node_moved", map[string]interface{}{"id": node.ID, "new_parent_id": undo.Before.ParentID, "old_parent_id": node.ParentID}})
+			}
+
+			undone, err = q.GetNodeByID(r.Context(), node.ID, undo.OwnerID)
+			if err != nil {
+				return err
+			}
+
+		default:
+			return errUndoConflict
+		}
+
+		for _, event := range events {
+			if err := q.LogEvent(r.Context(), claims.UserID, event.eventType, event.payload); err != nil {
+				return err
+			}
+			if claims.UserID != undo.OwnerID {
+				if err := q.LogEvent(r.Context(), undo.OwnerID, event.eventType, event.payload); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	if txErr != nil {
+		switch {
+		case errors.Is(txErr, database.ErrNodeNotFound):
+			writeError(w, r, http.StatusNotFound, i18n.UndoTokenNotFound)
+		case errors.Is(txErr, errUndoForbidden):
+			writeError(w, r, http.StatusForbidden, i18n.UndoForbidden)
+		case errors.Is(txErr, errUndoConflict):
+			writeError(w, r, http.StatusConflict, i18n.UndoConflict)
+		case errors.Is(txErr, database.ErrDuplicateNodeName):
+			writeError(w, r, http.StatusConflict, i18n.UndoNameTaken)
+		default:
+			log.Printf("ERROR: Failed to undo operation for user %d: %v", claims.UserID, txErr)
+			writeError(w, r, http.StatusInternalServerError, i18n.UndoFailed)
+		}
+		return
+	}
+
+	watchedNodes := []string{undo.NodeID}
+	if undo.Before.ParentID != nil {
+		watchedNodes = append(watchedNodes, *undo.Before.ParentID)
+	}
+	if undo.After.ParentID != nil && !sameParent(undo.Before.ParentID, undo.After.ParentID) {
+		watchedNodes = append(watchedNodes, *undo.After.ParentID)
+	}
+	for _, event := range events {
+		eventMsg := map[string]interface{}{"event_type": event.eventType, "payload": event.payload}
+		eventBytes, _ := json.Marshal(eventMsg)
+		s.wsHub.PublishEvent(claims.UserID, eventBytes)
+		if claims.UserID != undo.OwnerID {
+			s.wsHub.PublishEvent(undo.OwnerID, eventBytes)
+		}
+		s.notifyWatchers(r.Context(), watchedNodes, eventBytes, claims.UserID, undo.OwnerID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.NodePtr(undone))
+}
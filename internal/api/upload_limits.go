@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const defaultMaxRequestSizeMB = 1 << 10
+
+// Names of the upload limits reported by UploadLimitError.
+const (
+	uploadLimitRequestSize     = "max_request_size"
+	uploadLimitFileSize        = "max_file_size"
+	uploadLimitFilesPerRequest = "max_files_per_request"
+)
+
+// UploadLimitError is the body of a 413 response caused by an upload limit.
+type UploadLimitError struct {
+	// Limit is max_request_size, max_file_size or max_files_per_request.
+	Limit string `json:"limit" example:"max_file_size"`
+	// Max is the configured limit, in bytes or files.
+	Max int64 `json:"max" example:"1073741824"`
+	// Actual is the size or count of the request, when known before reading it.
+	Actual  int64  `json:"actual,omitempty" example:"2147483648"`
+	File    string `json:"file,omitempty" example:"nagranie.mp4"`
+	Message string `json:"message" example:"The file nagranie.mp4 exceeds the limit of 1073741824 bytes per file"`
+}
+
+func writeUploadLimitError(w http.ResponseWriter, limitErr UploadLimitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(limitErr)
+}
+
+func (s *Server) maxRequestBytes() int64 {
+	if s.config.Load().Storage.MaxRequestSizeMB > 0 {
+		return s.config.Load().Storage.MaxRequestSizeMB << 20
+	}
+	return defaultMaxRequestSizeMB << 20
+}
+
+// maxFileBytes is the largest file that can be uploaded, or 0 when only the
+// storage quota limits the size of a file.
+func (s *Server) maxFileBytes() int64 {
+	return max(s.config.Load().Storage.MaxFileSizeMB, 0) << 20
+}
+
+// maxFilesPerRequest is how many files one upload request may carry, or 0
+// for no limit.
+func (s *Server) maxFilesPerRequest() int {
+	return max(s.config.Load().Storage.MaxFilesPerRequest, 0)
+}
+
+func (s *Server) requestSizeLimitError(actual int64) UploadLimitError {
+	maxBytes := s.maxRequestBytes()
+	return UploadLimitError{
+		Limit:   uploadLimitRequestSize,
+		Max:     maxBytes,
+		Actual:  actual,
+		Message: fmt.Sprintf("The request exceeds the limit of %d bytes per request", maxBytes),
+	}
+}
+
+// limitRequestBody rejects a request whose declared Content-Length exceeds
+// the request size limit before any of its body is read, and caps the body
+// of requests that do not declare their length. It returns false when the
+// request was rejected.
+func (s *Server) limitRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > s.maxRequestBytes() {
+		writeUploadLimitError(w, s.requestSizeLimitError(r.ContentLength))
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxRequestBytes())
+	return true
+}
+
+// writeRequestTooLarge reports err as a violation of the request size limit
+// when it was caused by the body capped by limitRequestBody.
+func (s *Server) writeRequestTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeUploadLimitError(w, s.requestSizeLimitError(0))
+	return true
+}
+
+// checkFileSize rejects a file larger than the per-file limit. It returns
+// false when the file was rejected.
+func (s *Server) checkFileSize(w http.ResponseWriter, fileName string, size int64) bool {
+	maxBytes := s.maxFileBytes()
+	if maxBytes == 0 || size <= maxBytes {
+		return true
+	}
+	writeUploadLimitError(w, UploadLimitError{
+		Limit:   uploadLimitFileSize,
+		Max:     maxBytes,
+		Actual:  size,
+		File:    fileName,
+		Message: fmt.Sprintf("The file %s exceeds the limit of %d bytes per file", fileName, maxBytes),
+	})
+	return false
+}
+
+// checkFileCount rejects a request carrying more files than allowed. It
+// returns false when the request was rejected.
+func (s *Server) checkFileCount(w http.ResponseWriter, count int) bool {
+	maxFiles := s.maxFilesPerRequest()
+	if maxFiles == 0 || count <= maxFiles {
+		return true
+	}
+	writeUploadLimitError(w, UploadLimitError{
+		Limit:   uploadLimitFilesPerRequest,
+		Max:     int64(maxFiles),
+		Actual:  int64(count),
+		Message: fmt.Sprintf("A request can upload at most %d files", maxFiles),
+	})
+	return false
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"serwer-plikow/internal/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// uploadProgressInterval is the shortest time between two upload_progress
+// events of one upload session.
+const uploadProgressInterval = time.Second
+
+// Outcomes reported by upload_finished events.
+const (
+	uploadOutcomeCompleted = "completed"
+	uploadOutcomeCancelled = "cancelled"
+	uploadOutcomeRejected  = "rejected"
+)
+
+// uploadProgressThrottle remembers when the progress of each upload session
+// was last published. The zero value is ready to use.
+type uploadProgressThrottle struct {
+	mu   sync.Mutex
+	last map[uuid.UUID]time.Time
+}
+
+// allow reports whether a progress event of the session may be published now,
+// and if so records it.
+func (t *uploadProgressThrottle) allow(sessionID uuid.UUID, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.last[sessionID]) < uploadProgressInterval {
+		return false
+	}
+	if t.last == nil {
+		t.last = make(map[uuid.UUID]time.Time)
+	}
+	t.last[sessionID] = now
+	return true
+}
+
+func (t *uploadProgressThrottle) forget(sessionID uuid.UUID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, sessionID)
+}
+
+// prune forgets sessions whose progress was last published before cutoff,
+// such as sessions that expired without being finished.
+func (t *uploadProgressThrottle) prune(cutoff time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sessionID, last := range t.last {
+		if last.Before(cutoff) {
+			delete(t.last, sessionID)
+		}
+	}
+}
+
+func uploadEventPayload(session *models.UploadSession) map[string]interface{} {
+	return map[string]interface{}{
+		"upload_id":      session.ID,
+		"file_name":      session.FileName,
+		"parent_id":      session.ParentID,
+		"total_size":     session.TotalSize,
+		"received_bytes": session.ReceivedBytes,
+	}
+}
+
+// publishUploadEvent pushes an upload event to every client of the uploading
+// user. Upload events are transient and are not journaled.
+func (s *Server) publishUploadEvent(eventType string, session *models.UploadSession, payload map[string]interface{}) {
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": payload})
+	s.wsHub.PublishEvent(session.UserID, eventBytes)
+}
+
+func (s *Server) publishUploadStarted(session *models.UploadSession) {
+	s.uploadProgress.allow(session.ID, time.Now())
+	s.publishUploadEvent("upload_started", session, uploadEventPayload(session))
+}
+
+// publishUploadProgress publishes the progress of a session at most once per
+// uploadProgressInterval, and always once all bytes have arrived.
+func (s *Server) publishUploadProgress(session *models.UploadSession) {
+	if !s.uploadProgress.allow(session.ID, time.Now()) && session.ReceivedBytes < session.TotalSize {
+		return
+	}
+	s.publishUploadEvent("upload_progress", session, uploadEventPayload(session))
+}
+
+// publishUploadFinished announces the end of an upload session with its
+// outcome and, for a completed upload, the created node.
+func (s *Server) publishUploadFinished(session *models.UploadSession, outcome string, node *models.Node) {
+	s.uploadProgress.forget(session.ID)
+	payload := uploadEventPayload(session)
+	payload["outcome"] = outcome
+	if node != nil {
+		payload["node_id"] = node.ID
+	}
+	s.publishUploadEvent("upload_finished", session, payload)
+}
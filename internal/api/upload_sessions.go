@@ -0,0 +1,696 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"serwer-plikow/internal/contentpolicy"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultUploadSessionTTL = 24 * time.Hour
+	uploadAssemblyWorkers   = 4
+)
+
+var errUploadIncomplete = errors.New("upload is incomplete: received chunks do not cover the whole file")
+
+// ChunkVerificationError reports which chunks of an upload failed verification,
+// by their index in the session's offset-ordered chunk list.
+type ChunkVerificationError struct {
+	CorruptChunks    []int `json:"corrupt_chunks"`
+	FileHashMismatch bool  `json:"file_hash_mismatch"`
+}
+
+func (e *ChunkVerificationError) Error() string {
+	if len(e.CorruptChunks) > 0 {
+		return fmt.Sprintf("upload verification failed: corrupt chunks %v", e.CorruptChunks)
+	}
+	return "upload verification failed: file checksum does not match"
+}
+
+func (s *Server) uploadSessionTTL() time.Duration {
+	if s.config.Load().Storage.UploadSessionTTLHours > 0 {
+		return time.Duration(s.config.Load().Storage.UploadSessionTTLHours) * time.Hour
+	}
+	return defaultUploadSessionTTL
+}
+
+// cleanupUploadSessions removes sessions that received no data within the TTL
+// and chunk directories left on disk without a matching session record.
+func (s *Server) cleanupUploadSessions(ctx context.Context) error {
+	locations, err := s.store.DeleteExpiredUploadSessions(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, location := range locations {
+		if err := s.storage.DeleteUploadArea(location); err != nil {
+			log.Printf("WARN: Failed to delete expired upload area %s: %v", location, err)
+		}
+	}
+
+	staleAreas, err := s.storage.ListUploadAreasOlderThan(time.Now().Add(-s.uploadSessionTTL()))
+	if err != nil {
+		return err
+	}
+	for _, sessionIDStr := range staleAreas {
+		sessionID, err := uuid.Parse(sessionIDStr)
+		if err == nil {
+			exists, err := s.store.UploadSessionExists(ctx, sessionID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if err := s.storage.DeleteUploadArea(storage.UploadAreaLocation(sessionIDStr)); err != nil {
+			log.Printf("WARN: Failed to delete orphaned upload area %s: %v", sessionIDStr, err)
+		}
+	}
+
+	s.uploadProgress.prune(time.Now().Add(-s.uploadSessionTTL()))
+
+	if len(locations) > 0 {
+		log.Printf("Upload session janitor: removed %d abandoned sessions", len(locations))
+	}
+	return nil
+}
+
+// assembleUploadSession joins the chunks of a complete upload into a staged
+// blob, verifying every chunk against its declared checksum and the whole
+// file against the session's expected SHA-256. It returns the staged blob ID
+// and the SHA-256 of the assembled file.
+func (s *Server) assembleUploadSession(ctx context.Context, session *models.UploadSession) (string, string, error) {
+	var expectedOffset int64
+	parts := make([]storage.ChunkPart, 0, len(session.Chunks))
+	for _, chunk := range session.Chunks {
+		if chunk.Offset != expectedOffset {
+			return "", "", errUploadIncomplete
+		}
+		parts = append(parts, storage.ChunkPart{Offset: chunk.Offset, Size: chunk.SizeBytes})
+		expectedOffset += chunk.SizeBytes
+	}
+	if expectedOffset != session.TotalSize {
+		return "", "", errUploadIncomplete
+	}
+
+	stagedID, err := s.generateUniqueID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	hashes, err := s.storage.AssembleUpload(session.TempLocation, stagedID, parts, uploadAssemblyWorkers)
+	if err != nil {
+		return "", "", err
+	}
+
+	discard := func() {
+		if err := s.storage.Delete(stagedID); err != nil {
+			log.Printf("WARN: Failed to delete rejected upload %s: %v", stagedID, err)
+		}
+	}
+
+	verificationErr := &ChunkVerificationError{CorruptChunks: []int{}}
+	for i, chunk := range session.Chunks {
+		if hashes[i] == "" || (chunk.Checksum != nil && !strings.EqualFold(*chunk.Checksum, hashes[i])) {
+			verificationErr.CorruptChunks = append(verificationErr.CorruptChunks, i)
+		}
+	}
+	if len(verificationErr.CorruptChunks) > 0 {
+		discard()
+		return "", "", verificationErr
+	}
+
+	assembled, err := s.storage.Get(stagedID)
+	if err != nil {
+		discard()
+		return "", "", err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, assembled)
+	assembled.Close()
+	if err != nil {
+		discard()
+		return "", "", err
+	}
+	fileHash := hex.EncodeToString(hasher.Sum(nil))
+
+	if session.ExpectedSHA256 != nil && !strings.EqualFold(*session.ExpectedSHA256, fileHash) {
+		discard()
+		verificationErr.FileHashMismatch = true
+		return "", "", verificationErr
+	}
+
+	return stagedID, fileHash, nil
+}
+
+type CreateUploadSessionRequest struct {
+	FileName string  `json:"file_name" example:"nagranie.mp4"`
+	ParentID *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	MimeType *string `json:"mime_type,omitempty" example:"video/mp4"`
+	// TotalSize is the size of the whole file in bytes.
+	TotalSize int64 `json:"total_size" example:"5368709120"`
+	// SHA256 is the optional hex checksum of the whole file, verified when the
+	// upload is finalized.
+	SHA256 *string `json:"sha256,omitempty"`
+}
+
+// uploadedPrefix returns the number of bytes received contiguously from the
+// start of the file, which is where a client resuming a sequential upload
+// should continue.
+func uploadedPrefix(chunks []models.UploadChunk) int64 {
+	var offset int64
+	for _, chunk := range chunks {
+		if chunk.Offset != offset {
+			break
+		}
+		offset += chunk.SizeBytes
+	}
+	return offset
+}
+
+// loadUploadSession resolves the {uploadId} of a session started by the user,
+// writing the error response when it cannot be used.
+func (s *Server) loadUploadSession(w http.ResponseWriter, r *http.Request) *models.UploadSession {
+	claims := GetUserFromContext(r.Context())
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "uploadId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUploadID)
+		return nil
+	}
+	session, err := s.store.GetUploadSession(r.Context(), sessionID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadSessionLookupFailed)
+		return nil
+	}
+	if session == nil {
+		writeError(w, r, http.StatusNotFound, i18n.UploadSessionNotFound)
+		return nil
+	}
+	return session
+}
+
+func writeUploadSession(w http.ResponseWriter, status int, session *models.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(uploadedPrefix(session.Chunks), 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(dto.UploadSessionFrom(*session))
+}
+
+// checkUploadQuota reports whether size more bytes fit in the owner's quota,
+// writing the error response when they do not.
+func (s *Server) checkUploadQuota(w http.ResponseWriter, r *http.Request, ownerID int64, size int64) bool {
+	ownerUser, err := s.store.GetUserByID(r.Context(), ownerID)
+	if err != nil || ownerUser == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.QuotaOwnerCheckFailed)
+		return false
+	}
+	if ownerUser.StorageUsedBytes+size > ownerUser.StorageQuotaBytes {
+		writeError(w, r, http.StatusRequestEntityTooLarge, i18n.StorageQuotaExceeded)
+		return false
+	}
+	return true
+}
+
+// @Summary      Start a resumable upload
+// @Description  Creates an upload session for a file too large or too unreliable to send in one request. Chunks are then sent with PATCH /uploads/{uploadId}, in any order, and the file is created by POST /uploads/{uploadId}/complete. Sessions that receive no data for storage.upload_session_ttl_hours are removed together with their chunks. All of the user's WebSocket clients receive upload_started here, upload_progress as chunks arrive (at most once per second per session, and always when the last byte arrives) and upload_finished with the outcome completed, cancelled or rejected, so other devices can show the upload in progress.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        session  body      CreateUploadSessionRequest  true  "File to upload"
+// @Success      201      {object}  dto.UploadSession
+// @Failure      400      {string}  string "Bad Request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden"
+// @Failure      404      {string}  string "Parent folder not found"
+// @Failure      413      {object}  UploadLimitError "The file size limit or the storage quota is exceeded"
+// @Failure      415      {string}  string "Unsupported Media Type - The declared file type is not allowed"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/file/sessions [post]
+func (s *Server) CreateUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	req.FileName = strings.TrimSpace(req.FileName)
+	if req.FileName == "" || len(req.FileName) > 255 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidFileNameLength)
+		return
+	}
+	if req.TotalSize <= 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.NonPositiveTotalSize)
+		return
+	}
+	if !s.checkFileSize(w, req.FileName, req.TotalSize) {
+		return
+	}
+	if req.SHA256 != nil {
+		if decoded, err := hex.DecodeString(*req.SHA256); err != nil || len(decoded) != sha256.Size {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidSHA256)
+			return
+		}
+	}
+	if req.ParentID != nil && len(*req.ParentID) != 21 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+		return
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, req.ParentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return
+	}
+
+	ownerID := claims.UserID
+	if req.ParentID != nil {
+		parentFolder, err := s.store.GetNodeIfAccessible(r.Context(), *req.ParentID, claims.UserID)
+		if err != nil || parentFolder == nil {
+			s.writeNodeNotFound(w, r, *req.ParentID, i18n.ParentFolderNotAccessible)
+			return
+		}
+		ownerID = parentFolder.OwnerID
+	}
+
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, req.ParentID, 1, 1)) {
+		return
+	}
+	if !s.checkUploadQuota(w, r, ownerID, req.TotalSize) {
+		return
+	}
+
+	if req.MimeType == nil || *req.MimeType == "" {
+		mimeType := mime.TypeByExtension(path.Ext(req.FileName))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		req.MimeType = &mimeType
+	}
+	if err := s.checkContentType(req.FileName, *req.MimeType); err != nil {
+		writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+		return
+	}
+
+	sessionID := uuid.New()
+	location, err := s.storage.CreateUploadArea(sessionID.String())
+	if err != nil {
+		log.Printf("ERROR: Failed to create upload area for session %s: %v", sessionID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadStartFailed)
+		return
+	}
+
+	session, err := s.store.CreateUploadSession(r.Context(), database.CreateUploadSessionParams{
+		ID:             sessionID,
+		UserID:         claims.UserID,
+		OwnerID:        ownerID,
+		ParentID:       req.ParentID,
+		FileName:       req.FileName,
+		MimeType:       req.MimeType,
+		TotalSize:      req.TotalSize,
+		ExpectedSHA256: req.SHA256,
+		TempLocation:   location,
+		ExpiresAt:      time.Now().Add(s.uploadSessionTTL()),
+	})
+	if err != nil {
+		log.Printf("ERROR: Failed to create upload session: %v", err)
+		if cleanupErr := s.storage.DeleteUploadArea(location); cleanupErr != nil {
+			log.Printf("WARN: Failed to delete upload area %s: %v", location, cleanupErr)
+		}
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadStartFailed)
+		return
+	}
+
+	s.publishUploadStarted(session)
+	writeUploadSession(w, http.StatusCreated, session)
+}
+
+// @Summary      Get a resumable upload
+// @Description  Returns an upload session with the chunks received so far. The Upload-Offset header holds the number of bytes received contiguously from the start of the file, where a sequential upload should resume.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        uploadId  path      string  true  "Upload session ID"
+// @Success      200       {object}  dto.UploadSession
+// @Failure      400       {string}  string "Invalid upload ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      404       {string}  string "Upload session not found or expired"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /uploads/{uploadId} [get]
+func (s *Server) GetUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session := s.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	writeUploadSession(w, http.StatusOK, session)
+}
+
+// @Summary      Upload a chunk
+// @Description  Stores the raw request body as the bytes of the file starting at the Upload-Offset header. Chunks may arrive in any order and a chunk sent again to the same offset replaces the previous one, so a failed request can simply be retried. An optional X-Chunk-SHA256 header declares the chunk's checksum, verified when the upload is finalized.
+// @Tags         nodes
+// @Accept       application/octet-stream
+// @Produce      json
+// @Security     BearerAuth
+// @Param        uploadId       path      string  true   "Upload session ID"
+// @Param        Upload-Offset  header    int     true   "Offset of the chunk in the file"
+// @Param        X-Chunk-SHA256 header    string  false  "Hex SHA-256 of the chunk"
+// @Success      200            {object}  dto.UploadSession
+// @Failure      400            {string}  string "Bad Request - Invalid offset or empty chunk"
+// @Failure      401            {string}  string "Unauthorized"
+// @Failure      404            {string}  string "Upload session not found or expired"
+// @Failure      409            {string}  string "Conflict - Chunk overlaps another chunk"
+// @Failure      413            {string}  string "Chunk extends past the end of the file"
+// @Failure      500            {string}  string "Internal Server Error"
+// @Router       /uploads/{uploadId} [patch]
+func (s *Server) UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	session := s.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || offset >= session.TotalSize {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidUploadOffset)
+		return
+	}
+	var checksum *string
+	if value := r.Header.Get("X-Chunk-SHA256"); value != "" {
+		if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != sha256.Size {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidChunkSHA256)
+			return
+		}
+		checksum = &value
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, session.TotalSize-offset)
+	size, err := s.storage.SaveChunk(session.TempLocation, offset, r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, i18n.ChunkPastEnd)
+			return
+		}
+		log.Printf("ERROR: Failed to store chunk at %d of upload %s: %v", offset, session.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ChunkStoreFailed)
+		return
+	}
+	if size == 0 {
+		writeError(w, r, http.StatusBadRequest, i18n.EmptyChunk)
+		return
+	}
+	for _, chunk := range session.Chunks {
+		if chunk.Offset != offset && chunk.Offset < offset+size && offset < chunk.Offset+chunk.SizeBytes {
+			writeErrorf(w, r, http.StatusConflict, i18n.ChunkOverlap, chunk.Offset)
+			return
+		}
+	}
+
+	err = s.store.RecordUploadChunk(r.Context(), session.ID, offset, size, checksum, time.Now().Add(s.uploadSessionTTL()))
+	if errors.Is(err, database.ErrUploadSessionNotFound) {
+		writeError(w, r, http.StatusNotFound, i18n.UploadSessionNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to record chunk at %d of upload %s: %v", offset, session.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ChunkStoreFailed)
+		return
+	}
+
+	claims := GetUserFromContext(r.Context())
+	session, err = s.store.GetUploadSession(r.Context(), session.ID, claims.UserID)
+	if err != nil || session == nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadSessionLookupFailed)
+		return
+	}
+	s.publishUploadProgress(session)
+	writeUploadSession(w, http.StatusOK, session)
+}
+
+// @Summary      Finalize a resumable upload
+// @Description  Verifies that the received chunks cover the whole file, checks every declared checksum, and creates the file node in a single transaction. The stored type is detected from the assembled content, refined by the declared mime_type where the content alone is ambiguous. The SHA-256 checksum of the file is recorded on the node; it can also be given at this point in X-Content-SHA256 instead of when creating the session. The session and its chunks are removed afterwards. If verification fails, the response lists the corrupt chunks by their index in the session's chunk list so they can be sent again.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        uploadId          path      string  true   "Upload session ID"
+// @Param        X-Content-SHA256  header    string  false  "Hex SHA-256 checksum of the whole file"
+// @Success      201               {object}  dto.Node
+// @Failure      400               {string}  string "Invalid upload ID or checksum"
+// @Failure      401               {string}  string "Unauthorized"
+// @Failure      403               {string}  string "Forbidden - The file is blocked by the content policy"
+// @Failure      404               {string}  string "Upload session not found or expired"
+// @Failure      409               {string}  string "Conflict - Upload is incomplete"
+// @Failure      413               {string}  string "Storage quota exceeded"
+// @Failure      415               {string}  string "Unsupported Media Type - The detected file type is not allowed; the session is removed"
+// @Failure      422               {object}  ChunkVerificationError
+// @Failure      500               {string}  string "Internal Server Error"
+// @Router       /uploads/{uploadId}/complete [post]
+func (s *Server) CompleteUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	session := s.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	expectedSHA256, err := parseContentSHA256(r.Header.Get(contentSHA256Header))
+	if err != nil {
+		writeLocalizedError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if expectedSHA256 != "" {
+		if session.ExpectedSHA256 != nil && !strings.EqualFold(*session.ExpectedSHA256, expectedSHA256) {
+			writeErrorf(w, r, http.StatusBadRequest, i18n.ChecksumMismatchHeader, contentSHA256Header)
+			return
+		}
+		session.ExpectedSHA256 = &expectedSHA256
+	}
+
+	hasPermission, err := s.store.CheckWritePermission(r.Context(), claims.UserID, session.ParentID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return
+	}
+	if !hasPermission {
+		writeError(w, r, http.StatusForbidden, i18n.CreatePermissionDenied)
+		return
+	}
+	if !s.checkUploadQuota(w, r, session.OwnerID, session.TotalSize) {
+		return
+	}
+
+	stagedID, contentSHA256, err := s.assembleUploadSession(r.Context(), session)
+	if err != nil {
+		var verificationErr *ChunkVerificationError
+		switch {
+		case errors.Is(err, errUploadIncomplete):
+			writeErrorf(w, r, http.StatusConflict, i18n.UploadIncomplete, session.ReceivedBytes, session.TotalSize)
+		case errors.As(err, &verificationErr):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(verificationErr)
+		default:
+			log.Printf("ERROR: Failed to assemble upload %s: %v", session.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.UploadAssembleFailed)
+		}
+		return
+	}
+	defer func() {
+		if err := s.storage.Delete(stagedID); err != nil {
+			log.Printf("WARN: Failed to delete staged upload %s: %v", stagedID, err)
+		}
+	}()
+
+	declared := ""
+	if session.MimeType != nil {
+		declared = *session.MimeType
+	}
+	mimeType, err := s.sniffStaged(stagedID, session.FileName, declared)
+	if err != nil {
+		log.Printf("ERROR: Failed to detect the type of upload %s: %v", session.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+	err = s.checkContentType(session.FileName, mimeType)
+	var quarantine *contentpolicy.Decision
+	if err == nil {
+		quarantine, err = s.evaluateStaged(r.Context(), stagedID, session.FileName, mimeType, session.TotalSize)
+	}
+	var typeErr *contentTypeError
+	var policyErr *contentPolicyError
+	if errors.As(err, &typeErr) || errors.As(err, &policyErr) {
+		if deleteErr := s.store.DeleteUploadSession(r.Context(), session.ID); deleteErr != nil {
+			log.Printf("ERROR: Failed to remove rejected upload session %s: %v", session.ID, deleteErr)
+		}
+		if deleteErr := s.storage.DeleteUploadArea(session.TempLocation); deleteErr != nil {
+			log.Printf("WARN: Failed to delete upload area %s: %v", session.TempLocation, deleteErr)
+		}
+		s.publishUploadFinished(session, uploadOutcomeRejected, nil)
+		if policyErr != nil {
+			writeLocalizedError(w, r, http.StatusForbidden, err)
+		} else {
+			writeLocalizedError(w, r, http.StatusUnsupportedMediaType, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: Content policy failed for upload %s: %v", session.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+
+	sizeBytes := session.TotalSize
+	backendName, backend, err := s.routeContent(sizeBytes, &mimeType)
+	if err != nil {
+		log.Printf("ERROR: No storage backend for upload %s: %v", session.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+	nodeID, err := s.generateUniqueID(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+	assembled, err := s.storage.Open(stagedID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+	err = backend.Save(nodeID, assembled)
+	assembled.Close()
+	if err != nil {
+		log.Printf("ERROR: Failed to save upload %s as %s: %v", session.ID, nodeID, err)
+		backend.Delete(nodeID)
+		writeError(w, r, http.StatusInternalServerError, i18n.FileStoreFailed)
+		return
+	}
+
+	var createdNode *models.Node
+	var duplicate bool
+	placedKey := ""
+	txErr := s.store.ExecTx(r.Context(), func(q *database.Queries) error {
+		storageKey, dup, err := s.storeContentBlob(r.Context(), q, backend, nodeID, backendName, backend, contentSHA256, sizeBytes)
+		if err != nil {
+			return err
+		}
+		duplicate = dup
+		if !dup {
+			placedKey = storageKey
+		}
+		createdNode, err = q.CreateNode(r.Context(), database.CreateNodeParams{
+			ID:             nodeID,
+			OwnerID:        session.OwnerID,
+			ParentID:       session.ParentID,
+			Name:           session.FileName,
+			NodeType:       "file",
+			SizeBytes:      &sizeBytes,
+			MimeType:       &mimeType,
+			StorageBackend: backendName,
+			ContentSHA256:  &contentSHA256,
+			StorageKey:     &storageKey,
+		})
+		if err != nil {
+			return err
+		}
+		if err := q.LogEvents(r.Context(), nodeCreatedEvents(claims.UserID, session.OwnerID, createdNode)); err != nil {
+			return err
+		}
+		if err := q.UpdateUserStorage(r.Context(), session.OwnerID, sizeBytes); err != nil {
+			return err
+		}
+		if quarantine != nil {
+			if err := q.QuarantineNode(r.Context(), nodeID, contentpolicy.ActionUpload, quarantine.Rule, quarantine.Reason); err != nil {
+				return err
+			}
+		}
+		return q.DeleteUploadSession(r.Context(), session.ID)
+	})
+	if txErr != nil {
+		log.Printf("ERROR: Failed to create node for upload %s: %v", session.ID, txErr)
+		if cleanupErr := backend.Delete(nodeID); cleanupErr != nil {
+			log.Printf("CRITICAL: Failed to clean up orphaned file %s: %v", nodeID, cleanupErr)
+		}
+		if placedKey != "" {
+			s.discardPlacedBlob(r.Context(), backendName, backend, placedKey)
+		}
+		writeError(w, r, http.StatusInternalServerError, i18n.FileCreateFailed)
+		return
+	}
+	if duplicate {
+		if err := backend.Delete(nodeID); err != nil {
+			log.Printf("WARN: Failed to delete duplicate content %s: %v", nodeID, err)
+		}
+	}
+
+	if err := s.storage.DeleteUploadArea(session.TempLocation); err != nil {
+		log.Printf("WARN: Failed to delete upload area %s: %v", session.TempLocation, err)
+	}
+
+	var parentFolderOwnerID *int64
+	if session.ParentID != nil {
+		parentFolderOwnerID = &session.OwnerID
+	}
+	createdNodes := []models.Node{*createdNode}
+	s.publishUploadedNodes(r.Context(), claims.UserID, parentFolderOwnerID, session.ParentID, createdNodes)
+	s.publishUploadFinished(session, uploadOutcomeCompleted, createdNode)
+	if quarantine != nil {
+		s.notifyQuarantine(r.Context(), createdNode, contentpolicy.ActionUpload, quarantine)
+	}
+	createdNodes = s.applyOrganizationRules(r.Context(), session.OwnerID, createdNodes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.NodeFrom(createdNodes[0]))
+}
+
+// @Summary      Cancel a resumable upload
+// @Description  Removes an upload session and the chunks received so far.
+// @Tags         nodes
+// @Security     BearerAuth
+// @Param        uploadId  path      string  true  "Upload session ID"
+// @Success      204       {null}    nil     "No Content"
+// @Failure      400       {string}  string "Invalid upload ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      404       {string}  string "Upload session not found or expired"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /uploads/{uploadId} [delete]
+func (s *Server) CancelUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session := s.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+
+	if err := s.store.DeleteUploadSession(r.Context(), session.ID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UploadCancelFailed)
+		return
+	}
+	if err := s.storage.DeleteUploadArea(session.TempLocation); err != nil {
+		log.Printf("WARN: Failed to delete upload area %s: %v", session.TempLocation, err)
+	}
+	s.publishUploadFinished(session, uploadOutcomeCancelled, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,369 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultURLImportTimeout   = time.Hour
+	defaultURLImportMaxSizeMB = 10 << 10
+	maxURLImportRedirects     = 5
+	maxURLLength              = 2048
+	// urlImportFallbackName names files when neither the request, the
+	// response nor the URL suggests a name.
+	urlImportFallbackName = "download"
+)
+
+var errPrivateAddress = errors.New("the address is in a private network")
+
+// cgnatNetwork is the shared address space of carrier-grade NAT, which
+// net.IP.IsPrivate does not cover.
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatNetwork.Contains(ip))
+}
+
+// newURLImportClient returns the client fetching URL imports. Addresses are
+// checked when connecting, after name resolution, so neither redirects nor
+// DNS records pointing into the server's network get through.
+func (s *Server) newURLImportClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if s.config.Load().URLImport.AllowPrivateNetworks {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	timeout := defaultURLImportTimeout
+	if s.config.Load().URLImport.TimeoutSeconds > 0 {
+		timeout = time.Duration(s.config.Load().URLImport.TimeoutSeconds) * time.Second
+	}
+	// The transport uses no proxy, which would connect on the client's
+	// behalf past the address check.
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 30 * time.Second},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxURLImportRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+func (s *Server) maxURLImportBytes() int64 {
+	if s.config.Load().URLImport.MaxSizeMB > 0 {
+		return s.config.Load().URLImport.MaxSizeMB << 20
+	}
+	return defaultURLImportMaxSizeMB << 20
+}
+
+// cleanImportName makes a file name suggested by a remote server or URL safe
+// to use, returning "" when nothing usable is left.
+func cleanImportName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || len(name) > 255 {
+		return ""
+	}
+	return name
+}
+
+// remoteFileName picks the name of an imported file: the requested one, then
+// the filename of the response's Content-Disposition, then the last segment
+// of the final URL.
+func remoteFileName(job *database.URLImport, resp *http.Response) string {
+	if job.FileName != nil {
+		return *job.FileName
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := cleanImportName(params["filename"]); name != "" {
+			return name
+		}
+	}
+	if name := cleanImportName(path.Base(resp.Request.URL.Path)); name != "" {
+		return name
+	}
+	return urlImportFallbackName
+}
+
+func (s *Server) publishURLImportEvent(ctx context.Context, eventType string, job *database.URLImport) {
+	if err := s.store.LogEvent(ctx, job.RequestedBy, eventType, job); err != nil {
+		log.Printf("ERROR: Failed to journal %s for URL import %s: %v", eventType, job.ID, err)
+	}
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "payload": dto.URLImportFrom(*job)})
+	s.wsHub.PublishEvent(job.RequestedBy, eventBytes)
+}
+
+func (s *Server) runURLImport(ctx context.Context, job *database.URLImport) {
+	imp := &archiveImporter{s: s, folders: map[string]*string{".": job.ParentID}}
+	finish := func(status string, sizeBytes *int64, errorMessage *string) {
+		var nodeID *string
+		if len(imp.created) > 0 {
+			nodeID = &imp.created[0].ID
+		}
+		updated, err := s.store.UpdateURLImport(ctx, job.ID, status, nodeID, sizeBytes, errorMessage)
+		if err != nil || updated == nil {
+			log.Printf("ERROR: Failed to update URL import %s: %v", job.ID, err)
+		} else {
+			job = updated
+		}
+		eventType := "url_import_completed"
+		if status == database.URLImportFailed {
+			eventType = "url_import_failed"
+		}
+		s.publishURLImportEvent(ctx, eventType, job)
+	}
+	fail := func(message string) {
+		finish(database.URLImportFailed, nil, &message)
+	}
+
+	var failure string
+	imp.ownerID, imp.remaining, failure = s.importDestination(ctx, job.RequestedBy, job.ParentID)
+	if failure != "" {
+		fail(failure)
+		return
+	}
+	if imp.remaining <= 0 {
+		fail("Storage quota for the owner of this folder is exceeded")
+		return
+	}
+	limit := min(imp.remaining, s.maxURLImportBytes())
+	tooLarge := func() {
+		if limit < s.maxURLImportBytes() {
+			fail("Storage quota for the owner of this folder is exceeded")
+		} else {
+			fail(fmt.Sprintf("The file is larger than the %d bytes allowed for imports", limit))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		fail("The URL is invalid")
+		return
+	}
+	resp, err := s.urlImportClient.Do(req)
+	if err != nil {
+		log.Printf("WARN: URL import %s failed to fetch %s: %v", job.ID, job.URL, err)
+		if errors.Is(err, errPrivateAddress) {
+			fail("The URL points to a private network address")
+		} else {
+			fail("Failed to download the file")
+		}
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fail("The remote server answered " + resp.Status)
+		return
+	}
+	if resp.ContentLength > limit {
+		tooLarge()
+		return
+	}
+
+	stagedID, err := s.generateUniqueID(ctx)
+	if err != nil {
+		fail("Failed to stage the file")
+		return
+	}
+	defer func() {
+		if err := s.storage.Delete(stagedID); err != nil {
+			log.Printf("ERROR: Failed to remove staged file %s of URL import %s: %v", stagedID, job.ID, err)
+		}
+	}()
+	body := &io.LimitedReader{R: resp.Body, N: limit + 1}
+	if err := s.storage.Save(stagedID, body); err != nil {
+		log.Printf("WARN: URL import %s failed to download %s: %v", job.ID, job.URL, err)
+		fail("Failed to download the file")
+		return
+	}
+	size := limit + 1 - body.N
+	if size > limit {
+		tooLarge()
+		return
+	}
+
+	err = imp.file(ctx, archiveEntry{
+		Path: remoteFileName(job, resp),
+		Size: size,
+		open: func() (io.ReadCloser, error) { return s.storage.Get(stagedID) },
+	})
+	if err != nil {
+		log.Printf("WARN: URL import %s failed: %v", job.ID, err)
+		var typeErr *contentTypeError
+		var policyErr *contentPolicyError
+		switch {
+		case errors.Is(err, errQuotaExceeded):
+			fail("Storage quota for the owner of this folder is exceeded")
+		case errors.As(err, &typeErr):
+			fail(typeErr.Error())
+		case errors.As(err, &policyErr):
+			fail(policyErr.Error())
+		default:
+			fail("Failed to store the file")
+		}
+		return
+	}
+
+	imp.publish(ctx, job.RequestedBy)
+	finish(database.URLImportCompleted, &size, nil)
+}
+
+// processURLImports works through the URL import queue until it is empty.
+func (s *Server) processURLImports(ctx context.Context) error {
+	for ctx.Err() == nil {
+		job, err := s.store.ClaimURLImport(ctx, s.urlImportClient.Timeout+time.Minute)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		s.runURLImport(ctx, job)
+	}
+	return nil
+}
+
+type ImportURLRequest struct {
+	URL string `json:"url" example:"https://example.com/raport.pdf"`
+	// ParentID is the target folder; the root when omitted.
+	ParentID *string `json:"parent_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	// FileName names the file; by default the name comes from the response or
+	// the URL. A taken name gets a number.
+	FileName *string `json:"file_name,omitempty" example:"raport.pdf"`
+}
+
+// @Summary      Import a file from a URL
+// @Description  Queues a download of a file from an http(s) URL straight into a folder, without routing it through the client. The server fetches the file in the background, following up to 5 redirects, and stores it like an upload: the content type restrictions and the content policy apply and a taken name gets a number. The file may not exceed url_import.max_size_mb nor the storage quota of the folder's owner. Addresses in private networks are refused unless url_import.allow_private_networks is set. The outcome is announced with a "url_import_completed" or "url_import_failed" event and can be polled with GET /url-imports/{importId}.
+// @Tags         nodes
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      ImportURLRequest  true  "URL and target folder"
+// @Success      202      {object}  dto.URLImport
+// @Failure      400      {string}  string "Bad Request - Invalid URL or file name"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden - Write permission denied"
+// @Failure      404      {string}  string "Not Found - Parent folder not found"
+// @Failure      422      {string}  string "Unprocessable Entity - Folder limits exceeded"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/import [post]
+func (s *Server) ImportURLHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var req ImportURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	remote, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (remote.Scheme != "http" && remote.Scheme != "https") || remote.Host == "" || len(req.URL) > maxURLLength {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidImportURL)
+		return
+	}
+	if req.FileName != nil {
+		name := cleanImportName(*req.FileName)
+		if name == "" || name != *req.FileName {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidImportFileName)
+			return
+		}
+	}
+	if req.ParentID != nil && len(*req.ParentID) != 21 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidParentID)
+		return
+	}
+
+	ownerID, ok := s.archiveImportOwner(w, r, claims.UserID, req.ParentID)
+	if !ok {
+		return
+	}
+	if writePlacementLimitError(w, r, s.checkPlacementLimits(r.Context(), ownerID, req.ParentID, 1, 1)) {
+		return
+	}
+
+	job, err := s.store.CreateURLImport(r.Context(), claims.UserID, req.ParentID, remote.String(), req.FileName)
+	if err != nil {
+		log.Printf("ERROR: Failed to queue URL import: %v", err)
+		writeError(w, r, http.StatusInternalServerError, i18n.ImportQueueFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dto.URLImportFrom(*job))
+}
+
+// @Summary      Get a URL import
+// @Description  Returns the status of a URL import started by the user and, once completed, the ID of the created file.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        importId  path      string  true  "URL import ID"
+// @Success      200       {object}  dto.URLImport
+// @Failure      400       {string}  string "Invalid import ID"
+// @Failure      401       {string}  string "Unauthorized"
+// @Failure      404       {string}  string "Not Found"
+// @Failure      500       {string}  string "Internal Server Error"
+// @Router       /url-imports/{importId} [get]
+func (s *Server) GetURLImportHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	importID, err := uuid.Parse(chi.URLParam(r, "importId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidImportID)
+		return
+	}
+
+	job, err := s.store.GetURLImport(r.Context(), importID, claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.URLImportLookupFailed)
+		return
+	}
+	if job == nil {
+		writeError(w, r, http.StatusNotFound, i18n.URLImportNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dto.URLImportFrom(*job))
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"serwer-plikow/internal/i18n"
 
 	"serwer-plikow/internal/auth"
 	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
 )
 
 // @Summary      Get current user info
@@ -14,28 +16,36 @@ import (
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
-// @Success      200  {object}  auth.AppClaims
+// @Success      200  {object}  dto.CurrentUser
 // @Failure      401  {string}  string "Unauthorized"
 // @Failure      500  {string}  string "Internal Server Error"
 // @Router       /me [get]
 func (s *Server) GetCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetUserFromContext(r.Context())
 	if claims == nil {
-		http.Error(w, "Could not retrieve user from token", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.TokenUserLookupFailed)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(claims)
+	json.NewEncoder(w).Encode(dto.CurrentUserFrom(claims))
 }
 
 type StorageUsageResponse struct {
 	UsedBytes  int64 `json:"used_bytes"`
 	QuotaBytes int64 `json:"quota_bytes"`
+	// Versions reports archived file versions, which are kept outside the quota.
+	Versions VersionStorageResponse `json:"versions"`
+}
+
+type VersionStorageResponse struct {
+	Count     int64                  `json:"count" example:"12"`
+	UsedBytes int64                  `json:"used_bytes" example:"1048576"`
+	Policy    EffectiveVersionPolicy `json:"policy"`
 }
 
 // @Summary      Get storage usage
-// @Description  Retrieves the current storage usage and quota for the authenticated user.
+// @Description  Retrieves the current storage usage and quota for the authenticated user, along with the space taken by archived file versions and the retention policy applied to them.
 // @Tags         users
 // @Produce      json
 // @Security     BearerAuth
@@ -49,17 +59,33 @@ func (s *Server) GetStorageUsageHandler(w http.ResponseWriter, r *http.Request)
 
 	user, err := s.store.GetUserByUsername(r.Context(), claims.Username)
 	if err != nil {
-		http.Error(w, "Failed to retrieve user data", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.UserDataLookupFailed)
 		return
 	}
 	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, i18n.UserNotFound)
+		return
+	}
+
+	versionUsage, err := s.store.GetVersionStorageUsage(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.VersionUsageLookupFailed)
+		return
+	}
+	versionPolicy, err := s.store.GetVersionPolicy(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.VersionPolicyLookupFailed)
 		return
 	}
 
 	response := StorageUsageResponse{
 		UsedBytes:  user.StorageUsedBytes,
 		QuotaBytes: user.StorageQuotaBytes,
+		Versions: VersionStorageResponse{
+			Count:     versionUsage.Count,
+			UsedBytes: versionUsage.Bytes,
+			Policy:    effectiveVersionPolicy(s.config.Load().Versions, versionPolicy),
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -80,6 +106,7 @@ type ChangePasswordRequest struct {
 // @Success      204                    {null}    nil                    "No Content - Password changed successfully"
 // @Failure      400                    {string}  string "Bad Request - New password is weak (less than 8 characters) or empty"
 // @Failure      401                    {string}  string "Unauthorized - Old password does not match"
+// @Failure      409                    {string}  string "Conflict - The password is managed by the LDAP directory"
 // @Failure      500                    {string}  string "Internal Server Error"
 // @Router       /me/password [patch]
 func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
@@ -87,29 +114,34 @@ func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req ChangePasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
 		return
 	}
 
 	if len(req.NewPassword) < 8 {
-		http.Error(w, "New password must be at least 8 characters long", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, i18n.NewPasswordTooShort)
 		return
 	}
 
 	user, err := s.store.GetUserByUsername(r.Context(), claims.Username)
 	if err != nil || user == nil {
-		http.Error(w, "Could not find user", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.UserFindError)
+		return
+	}
+
+	if user.LDAPDN != nil {
+		writeError(w, r, http.StatusConflict, i18n.DirectoryManagedPassword)
 		return
 	}
 
 	if !auth.CheckPasswordHash(req.OldPassword, user.PasswordHash) {
-		http.Error(w, "Old password does not match", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, i18n.OldPasswordMismatch)
 		return
 	}
 
 	newPasswordHash, err := auth.HashPassword(req.NewPassword)
 	if err != nil {
-		http.Error(w, "Failed to hash new password", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.NewPasswordHashFailed)
 		return
 	}
 
@@ -122,7 +154,7 @@ func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	if txErr != nil {
 		log.Printf("ERROR: Failed to update password and terminate sessions in transaction: %v", txErr)
-		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, i18n.PasswordUpdateFailed)
 		return
 	}
 
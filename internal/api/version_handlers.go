@@ -0,0 +1,292 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/i18n"
+	"serwer-plikow/internal/models"
+	"serwer-plikow/internal/storage"
+	"serwer-plikow/internal/textdiff"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errVersionNotFound = errors.New("file version not found")
+
+const (
+	// maxDiffInputBytes is the largest version content a diff is computed for.
+	maxDiffInputBytes = 2 << 20
+	// maxDiffOutputBytes caps the returned diff; longer diffs are cut at a
+	// line boundary, or mid-line when the first line alone is too long, and
+	// marked as truncated.
+	maxDiffOutputBytes = 256 << 10
+)
+
+var (
+	errNotTextContent  = errors.New("content is not text")
+	errContentTooLarge = fmt.Errorf("content is larger than %d bytes", maxDiffInputBytes)
+)
+
+type VersionDiffResponse struct {
+	From      int    `json:"from" example:"3"`
+	To        int    `json:"to" example:"5"`
+	Diff      string `json:"diff" example:"--- raport.txt (v3)\n+++ raport.txt (v5)\n@@ -1 +1 @@\n-stara linia\n+nowa linia\n"`
+	Truncated bool   `json:"truncated" example:"false"`
+}
+
+type NodeVersionResponse struct {
+	Version   int       `json:"version" example:"3"`
+	SizeBytes int64     `json:"size_bytes" example:"1024"`
+	MimeType  *string   `json:"mime_type" example:"text/plain"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current" example:"false"`
+}
+
+// pinnedVersionFor returns the version a user is limited to when the node is
+// only shared with them pinned to a version, or nil when they see its current
+// content.
+func (s *Server) pinnedVersionFor(ctx context.Context, node *models.Node, userID int64) (*int, error) {
+	if node.OwnerID == userID {
+		return nil, nil
+	}
+	return s.store.GetPinnedShareVersion(ctx, node.ID, userID)
+}
+
+// nodeVersionBlob locates the content of a specific version of a file,
+// returning the backend and key it is stored under together with the size and
+// MIME type of that version.
+func (s *Server) nodeVersionBlob(ctx context.Context, node *models.Node, version int) (storage.Backend, string, *int64, *string, error) {
+	archived, err := s.store.GetNodeVersion(ctx, node.ID, version)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if archived != nil {
+		return s.storage, archived.StorageKey, &archived.SizeBytes, archived.MimeType, nil
+	}
+
+	current, err := s.store.CurrentNodeVersion(ctx, node.ID)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if version != current {
+		return nil, "", nil, nil, errVersionNotFound
+	}
+	_, key, backend, err := s.nodeBackend(ctx, s.store.Queries, node.ID)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	return backend, key, node.SizeBytes, node.MimeType, nil
+}
+
+// openNodeVersion opens the content of a specific version of a file, returning
+// it together with the size and MIME type of that version.
+func (s *Server) openNodeVersion(ctx context.Context, node *models.Node, version int) (io.ReadCloser, *int64, *string, error) {
+	backend, key, sizeBytes, mimeType, err := s.nodeVersionBlob(ctx, node, version)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stream, err := backend.Get(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return stream, sizeBytes, mimeType, nil
+}
+
+// loadVersionedFile fetches a file whose version history the user may see,
+// writing the error response and returning nil otherwise.
+func (s *Server) loadVersionedFile(w http.ResponseWriter, r *http.Request, userID int64, nodeID string) *models.Node {
+	node, err := s.store.GetNodeIfAccessible(r.Context(), nodeID, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.FileMetadataLookupFailed)
+		return nil
+	}
+	if node == nil {
+		s.writeNodeNotFound(w, r, nodeID, i18n.FileNotAccessible)
+		return nil
+	}
+	if node.NodeType != "file" {
+		writeError(w, r, http.StatusBadRequest, i18n.VersionsOnFolder)
+		return nil
+	}
+
+	pinned, err := s.pinnedVersionFor(r.Context(), node, userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.PermissionCheckFailed)
+		return nil
+	}
+	if pinned != nil {
+		writeError(w, r, http.StatusForbidden, i18n.FixedVersionShare)
+		return nil
+	}
+
+	return node
+}
+
+// @Summary      List file versions
+// @Description  Lists the versions of a file, newest first. The first entry is the current content; older versions are kept every time the content is replaced. Not available to recipients of a share pinned to a version.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID of the file"
+// @Success      200     {array}   NodeVersionResponse
+// @Failure      400     {string}  string "Bad Request - Node is a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - The file is shared with you at a fixed version"
+// @Failure      404     {string}  string "Not Found"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/versions [get]
+func (s *Server) ListNodeVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	node := s.loadVersionedFile(w, r, claims.UserID, nodeID)
+	if node == nil {
+		return
+	}
+
+	archived, err := s.store.ListNodeVersions(r.Context(), node.ID)
+	if err != nil {
+		log.Printf("ERROR: Failed to list versions of node %s: %v", node.ID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.VersionsListFailed)
+		return
+	}
+
+	current := NodeVersionResponse{Version: 1, MimeType: node.MimeType, CreatedAt: node.ModifiedAt, Current: true}
+	if len(archived) > 0 {
+		current.Version = archived[0].Version + 1
+	}
+	if node.SizeBytes != nil {
+		current.SizeBytes = *node.SizeBytes
+	}
+
+	versions := []NodeVersionResponse{current}
+	for _, v := range archived {
+		versions = append(versions, NodeVersionResponse{
+			Version:   v.Version,
+			SizeBytes: v.SizeBytes,
+			MimeType:  v.MimeType,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// readTextVersion loads a version of a file for diffing. Content that is not
+// valid UTF-8 or contains NUL bytes is treated as binary.
+func (s *Server) readTextVersion(ctx context.Context, node *models.Node, version int) (string, error) {
+	stream, _, _, err := s.openNodeVersion(ctx, node, version)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	content, err := io.ReadAll(io.LimitReader(stream, maxDiffInputBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(content) > maxDiffInputBytes {
+		return "", errContentTooLarge
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0 {
+		return "", errNotTextContent
+	}
+	return string(content), nil
+}
+
+// @Summary      Diff two file versions
+// @Description  Returns a unified diff between two versions of a text file, so clients can show what changed without downloading both. Versions larger than 2 MiB are not diffed and diffs longer than 256 KiB are truncated. Not available to recipients of a share pinned to a version.
+// @Tags         nodes
+// @Produce      json
+// @Security     BearerAuth
+// @Param        nodeId  path      string  true  "Node ID of the file"
+// @Param        from    query     int     true  "Version to compare from"
+// @Param        to      query     int     true  "Version to compare to"
+// @Success      200     {object}  VersionDiffResponse
+// @Failure      400     {string}  string "Bad Request - Invalid version numbers or node is a folder"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      403     {string}  string "Forbidden - The file is shared with you at a fixed version"
+// @Failure      404     {string}  string "Not Found - File or version not found"
+// @Failure      422     {string}  string "Unprocessable Entity - A version is binary or too large to diff"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/versions/diff [get]
+func (s *Server) GetVersionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	from, fromErr := strconv.Atoi(r.URL.Query().Get("from"))
+	to, toErr := strconv.Atoi(r.URL.Query().Get("to"))
+	if fromErr != nil || toErr != nil || from < 1 || to < 1 {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidVersionRange)
+		return
+	}
+
+	node := s.loadVersionedFile(w, r, claims.UserID, nodeID)
+	if node == nil {
+		return
+	}
+
+	texts := make(map[int]string, 2)
+	for _, version := range []int{from, to} {
+		text, err := s.readTextVersion(r.Context(), node, version)
+		switch {
+		case errors.Is(err, errVersionNotFound):
+			writeErrorf(w, r, http.StatusNotFound, i18n.VersionNotFound, version)
+			return
+		case errors.Is(err, errNotTextContent):
+			writeErrorf(w, r, http.StatusUnprocessableEntity, i18n.VersionNotText, version)
+			return
+		case errors.Is(err, errContentTooLarge):
+			writeErrorf(w, r, http.StatusUnprocessableEntity, i18n.VersionTooLargeToDiff, version)
+			return
+		case err != nil:
+			log.Printf("ERROR: Failed to read version %d of node %s: %v", version, node.ID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.VersionReadFailed)
+			return
+		}
+		texts[version] = text
+	}
+
+	diff := textdiff.Unified(
+		fmt.Sprintf("%s (v%d)", node.Name, from),
+		fmt.Sprintf("%s (v%d)", node.Name, to),
+		texts[from], texts[to], textdiff.DefaultContext,
+	)
+
+	response := VersionDiffResponse{From: from, To: to, Diff: diff}
+	if len(diff) > maxDiffOutputBytes {
+		response.Diff = truncateDiff(diff, maxDiffOutputBytes)
+		response.Truncated = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// truncateDiff cuts diff to at most limit bytes, after the last complete
+// line. A diff whose first line is longer than limit is cut inside that
+// line, on a rune boundary so the result stays valid UTF-8.
+func truncateDiff(diff string, limit int) string {
+	if len(diff) <= limit {
+		return diff
+	}
+	if cut := strings.LastIndexByte(diff[:limit], '\n'); cut >= 0 {
+		return diff[:cut+1]
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(diff[cut]) {
+		cut--
+	}
+	return diff[:cut]
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/config"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/dto"
+	"serwer-plikow/internal/i18n"
+)
+
+// EffectiveVersionPolicy is the retention policy applied to a user's archived
+// versions. Zero means no limit.
+type EffectiveVersionPolicy struct {
+	MaxVersionsPerFile int   `json:"max_versions_per_file" example:"50"`
+	MaxBytes           int64 `json:"max_bytes" example:"5368709120"`
+	MaxAgeDays         int   `json:"max_age_days" example:"365"`
+}
+
+type VersionPolicyResponse struct {
+	// User holds the user's own limits; unset ones fall back to the global policy.
+	User      dto.VersionPolicy      `json:"user"`
+	Global    EffectiveVersionPolicy `json:"global"`
+	Effective EffectiveVersionPolicy `json:"effective"`
+}
+
+func globalVersionPolicy(cfg config.VersionsConfig) EffectiveVersionPolicy {
+	policy := EffectiveVersionPolicy{}
+	if cfg.MaxPerFile > 0 {
+		policy.MaxVersionsPerFile = cfg.MaxPerFile
+	}
+	if cfg.MaxSizeMB > 0 {
+		policy.MaxBytes = cfg.MaxSizeMB * 1024 * 1024
+	}
+	if cfg.MaxAgeDays > 0 {
+		policy.MaxAgeDays = cfg.MaxAgeDays
+	}
+	return policy
+}
+
+// effectiveVersionPolicy combines the global and user policies. A user can
+// only tighten the global limits, so the stricter of each pair wins.
+func effectiveVersionPolicy(cfg config.VersionsConfig, user *database.VersionPolicy) EffectiveVersionPolicy {
+	policy := globalVersionPolicy(cfg)
+	if user == nil {
+		return policy
+	}
+	if user.MaxVersionsPerFile != nil {
+		policy.MaxVersionsPerFile = stricterLimit(policy.MaxVersionsPerFile, *user.MaxVersionsPerFile)
+	}
+	if user.MaxBytes != nil {
+		policy.MaxBytes = stricterLimit(policy.MaxBytes, *user.MaxBytes)
+	}
+	if user.MaxAgeDays != nil {
+		policy.MaxAgeDays = stricterLimit(policy.MaxAgeDays, *user.MaxAgeDays)
+	}
+	return policy
+}
+
+func stricterLimit[T int | int64](global, user T) T {
+	if global == 0 || (user > 0 && user < global) {
+		return user
+	}
+	return global
+}
+
+// pruneUserVersions applies a user's effective retention policy and deletes the
+// blobs of the pruned versions.
+func (s *Server) pruneUserVersions(ctx context.Context, userID int64) (int, error) {
+	userPolicy, err := s.store.GetVersionPolicy(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	policy := effectiveVersionPolicy(s.config.Load().Versions, userPolicy)
+	if policy == (EffectiveVersionPolicy{}) {
+		return 0, nil
+	}
+
+	keys, err := s.store.PruneNodeVersions(ctx, userID, policy.MaxVersionsPerFile, policy.MaxBytes, policy.MaxAgeDays)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("WARN: Failed to delete pruned file version %s from storage: %v", key, err)
+		}
+	}
+	return len(keys), nil
+}
+
+func (s *Server) pruneNodeVersions(ctx context.Context) error {
+	userIDs, err := s.store.ListUsersWithNodeVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, userID := range userIDs {
+		pruned, err := s.pruneUserVersions(ctx, userID)
+		if err != nil {
+			log.Printf("ERROR: Failed to prune file versions of user %d: %v", userID, err)
+			continue
+		}
+		total += pruned
+	}
+	if total > 0 {
+		log.Printf("Version retention: removed %d archived file versions", total)
+	}
+	return nil
+}
+
+// @Summary      Get version retention policy
+// @Description  Returns the user's own limits for archived file versions, the global server policy and the effective policy (the stricter of both) applied by the pruning job. Zero means no limit.
+// @Tags         users
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  VersionPolicyResponse
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      500  {string}  string "Internal Server Error"
+// @Router       /me/versions/policy [get]
+func (s *Server) GetVersionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	userPolicy, err := s.store.GetVersionPolicy(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.VersionPolicyLookupFailed)
+		return
+	}
+
+	response := VersionPolicyResponse{
+		Global:    globalVersionPolicy(s.config.Load().Versions),
+		Effective: effectiveVersionPolicy(s.config.Load().Versions, userPolicy),
+	}
+	if userPolicy != nil {
+		response.User = dto.VersionPolicyFrom(*userPolicy)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// @Summary      Set version retention policy
+// @Description  Sets the user's own limits for archived file versions: maximum versions per file, maximum total bytes and maximum age in days. Omitted or null fields fall back to the global policy, which the user's limits can only tighten. Versions beyond the new limits are pruned immediately, except those pinned by a share.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        policy  body      database.VersionPolicy  true  "Retention limits"
+// @Success      200     {object}  VersionPolicyResponse
+// @Failure      400     {string}  string "Bad Request - Limits must be positive"
+// @Failure      401     {string}  string "Unauthorized"
+// @Failure      500     {string}  string "Internal Server Error"
+// @Router       /me/versions/policy [put]
+func (s *Server) UpdateVersionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+
+	var policy database.VersionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+		return
+	}
+	if (policy.MaxVersionsPerFile != nil && *policy.MaxVersionsPerFile <= 0) ||
+		(policy.MaxBytes != nil && *policy.MaxBytes <= 0) ||
+		(policy.MaxAgeDays != nil && *policy.MaxAgeDays <= 0) {
+		writeError(w, r, http.StatusBadRequest, i18n.InvalidVersionPolicy)
+		return
+	}
+
+	if err := s.store.SetVersionPolicy(r.Context(), claims.UserID, policy); err != nil {
+		log.Printf("ERROR: Failed to save version policy of user %d: %v", claims.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, i18n.VersionPolicySaveFailed)
+		return
+	}
+
+	if _, err := s.pruneUserVersions(r.Context(), claims.UserID); err != nil {
+		log.Printf("ERROR: Failed to prune file versions of user %d: %v", claims.UserID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionPolicyResponse{
+		User:      dto.VersionPolicyFrom(policy),
+		Global:    globalVersionPolicy(s.config.Load().Versions),
+		Effective: effectiveVersionPolicy(s.config.Load().Versions, &policy),
+	})
+}
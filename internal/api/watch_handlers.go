@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/i18n"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxDigestEvents = 100
+
+type WatchNodeRequest struct {
+	Frequency string `json:"frequency" example:"daily" enums:"daily,weekly"`
+}
+
+// @Summary      Watch a folder
+// @Description  Subscribes the current user to changes in a folder and everything below it. Changes are pushed immediately over WebSocket and summarized in a periodic "watch_digest" event (daily by default, or weekly). Watching an already watched folder updates the digest frequency.
+// @Tags         nodes
+// @Accept       json
+// @Security     BearerAuth
+// @Param        nodeId   path      string            true   "Folder ID to watch"
+// @Param        request  body      WatchNodeRequest  false  "Digest frequency"
+// @Success      204      {null}    nil     "No Content"
+// @Failure      400      {string}  string "Bad Request - Invalid frequency"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found - Folder does not exist or user lacks access"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/watch [post]
+func (s *Server) WatchNodeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	req := WatchNodeRequest{Frequency: "daily"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidRequestBody)
+			return
+		}
+	}
+
+	err := s.store.WatchNode(r.Context(), claims.UserID, nodeID, req.Frequency)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidDigestFrequency):
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidDigestFrequency)
+		case errors.Is(err, database.ErrNodeNotFound):
+			s.writeNodeNotFound(w, r, nodeID, i18n.FolderNotAccessible)
+		default:
+			log.Printf("ERROR: Failed to watch node %s for user %d: %v", nodeID, claims.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, i18n.WatchFailed)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary      Stop watching a folder
+// @Description  Removes the current user's subscription to a folder.
+// @Tags         nodes
+// @Security     BearerAuth
+// @Param        nodeId   path      string  true  "Folder ID"
+// @Success      204      {null}    nil     "No Content"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      404      {string}  string "Not Found - Folder is not watched"
+// @Failure      500      {string}  string "Internal Server Error"
+// @Router       /nodes/{nodeId}/watch [delete]
+func (s *Server) UnwatchNodeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetUserFromContext(r.Context())
+	nodeID := chi.URLParam(r, "nodeId")
+
+	removed, err := s.store.UnwatchNode(r.Context(), claims.UserID, nodeID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, i18n.UnwatchFailed)
+		return
+	}
+	if !removed {
+		writeError(w, r, http.StatusNotFound, i18n.FolderNotWatched)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyWatchers forwards an already published event to users watching any
+// of the given nodes or their ancestors, skipping users notified directly.
+func (s *Server) notifyWatchers(ctx context.Context, nodeIDs []string, eventBytes []byte, alreadyNotified ...int64) {
+	watchers, err := s.store.ListWatchersForNodes(ctx, nodeIDs)
+	if err != nil {
+		log.Printf("ERROR: Failed to list watchers for nodes %v: %v", nodeIDs, err)
+		return
+	}
+
+	skip := make(map[int64]bool, len(alreadyNotified))
+	for _, userID := range alreadyNotified {
+		skip[userID] = true
+	}
+	for _, userID := range watchers {
+		if !skip[userID] {
+			s.wsHub.PublishEvent(userID, eventBytes)
+		}
+	}
+}
+
+func (s *Server) sendWatchDigests(ctx context.Context) error {
+	now := time.Now()
+	watches, err := s.store.ListDueWatches(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, watch := range watches {
+		if watch.UserID != watch.OwnerID {
+			hasAccess, err := s.store.HasAccessToNode(ctx, watch.NodeID, watch.UserID)
+			if err != nil {
+				return err
+			}
+			if !hasAccess {
+				if err := s.store.MarkWatchDigested(ctx, watch.UserID, watch.NodeID, now); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		events, err := s.store.ListSubtreeEventsSince(ctx, watch.OwnerID, watch.NodeID, watch.LastDigestAt, now, maxDigestEvents+1)
+		if err != nil {
+			return err
+		}
+
+		if len(events) > 0 {
+			truncated := len(events) > maxDigestEvents
+			if truncated {
+				events = events[:maxDigestEvents]
+			}
+			payload := map[string]interface{}{
+				"node_id":      watch.NodeID,
+				"node_name":    watch.NodeName,
+				"frequency":    watch.DigestFrequency,
+				"period_start": watch.LastDigestAt,
+				"period_end":   now,
+				"events":       events,
+				"truncated":    truncated,
+			}
+			if err := s.store.LogEvent(ctx, watch.UserID, "watch_digest", payload); err != nil {
+				return err
+			}
+			eventMsg := map[string]interface{}{"event_type": "watch_digest", "payload": payload}
+			eventBytes, _ := json.Marshal(eventMsg)
+			s.wsHub.PublishEvent(watch.UserID, eventBytes)
+		}
+
+		if err := s.store.MarkWatchDigested(ctx, watch.UserID, watch.NodeID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,16 +1,68 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/i18n"
 	"serwer-plikow/internal/websocket"
+	"strconv"
 )
 
+// wsCatchUpLimit bounds the missed events sent in the catch-up frame. The
+// frame takes a single slot of the client's send buffer; clients with more
+// missed events page through /events.
+const wsCatchUpLimit = 100
+
+type CatchUpPayload struct {
+	Since int64 `json:"since" example:"120"`
+	// LastEventID is the newest event of the user when the connection was
+	// established.
+	LastEventID int64           `json:"last_event_id" example:"180"`
+	Events      []EventResponse `json:"events"`
+	// HasMore is true when not all missed events fit in the frame; continue
+	// with GET /events?since= the ID of the last event in Events.
+	HasMore bool `json:"has_more" example:"false"`
+}
+
+// sendCatchUp queues a "catch_up" frame with the events the client missed
+// since its cursor. The client is registered before the events are read, so
+// live events may overlap with the frame; clients skip IDs they have seen.
+func (s *Server) sendCatchUp(ctx context.Context, client *websocket.Client, userID int64, since int64) {
+	latest, err := s.store.GetLatestEventID(ctx, userID)
+	if err != nil {
+		log.Printf("ERROR: Failed to read latest event of user %d for WS catch-up: %v", userID, err)
+		return
+	}
+	payload := CatchUpPayload{Since: since, LastEventID: latest, Events: []EventResponse{}}
+	if latest > since {
+		events, err := s.store.ReadReplica().GetEventsSince(ctx, userID, since, wsCatchUpLimit+1)
+		if err != nil {
+			log.Printf("ERROR: Failed to read missed events of user %d for WS catch-up: %v", userID, err)
+			return
+		}
+		if len(events) > wsCatchUpLimit {
+			events = events[:wsCatchUpLimit]
+			payload.HasMore = true
+		}
+		for _, event := range events {
+			payload.Events = append(payload.Events, EventResponse(event))
+		}
+	}
+
+	eventBytes, _ := json.Marshal(map[string]interface{}{"event_type": "catch_up", "payload": payload})
+	if !s.wsHub.SendTo(client, eventBytes) {
+		log.Printf("WARN: Could not queue WS catch-up for user %d", userID)
+	}
+}
+
 // @Summary      Establish WebSocket connection
-// @Description  Upgrades the HTTP connection to a WebSocket connection for real-time event notifications. The authentication token must be provided as a query parameter.
+// @Description  Upgrades the HTTP connection to a WebSocket connection for real-time event notifications. The authentication token must be provided as a query parameter. With since set to the ID of the last event the client has seen, the first message is a "catch_up" frame (CatchUpPayload) with the newest event ID and up to 100 missed events.
 // @Tags         websockets
-// @Param        token  query     string  true  "JWT authentication token"
+// @Param        token  query     string  true   "JWT authentication token"
+// @Param        since  query     int     false  "ID of the last event received, to catch up on missed events"
 // @Success      101    {string}  string  "Switching Protocols"
 // @Failure      401    {string}  string  "Unauthorized - Invalid or missing token"
 // @Router       /ws [get]
@@ -21,12 +73,21 @@ func (s *Server) ServeWsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, err := auth.VerifyJWT(tokenString, s.config.JWT.Secret)
+	claims, err := auth.VerifyJWT(tokenString, s.config.Load().JWT.Secret)
 	if err != nil {
 		log.Printf("WS connection attempt with invalid token: %v", err)
 		return
 	}
 
+	since := int64(-1)
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err = strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			writeError(w, r, http.StatusBadRequest, i18n.InvalidSinceNonNegative)
+			return
+		}
+	}
+
 	conn, err := websocket.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
@@ -38,4 +99,8 @@ func (s *Server) ServeWsHandler(w http.ResponseWriter, r *http.Request) {
 
 	go client.ReadPump()
 	go client.WritePump()
+
+	if since >= 0 {
+		s.sendCatchUp(r.Context(), client, claims.UserID, since)
+	}
 }
@@ -69,3 +69,11 @@ func TestGenerateAndVerifyJWT(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorIs(t, err, jwt.ErrTokenExpired)
 }
+
+func TestHashRefreshToken(t *testing.T) {
+	hash := HashRefreshToken("refresh-token")
+	require.Len(t, hash, 64)
+	require.Equal(t, hash, HashRefreshToken("refresh-token"))
+	require.NotEqual(t, hash, HashRefreshToken("other-token"))
+	require.NotContains(t, hash, "refresh-token")
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"serwer-plikow/internal/models"
 	"time"
 
@@ -10,15 +12,25 @@ import (
 type AppClaims struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
+	// SessionID is the session family the token was issued for. It is empty
+	// for tokens not bound to a session.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateJWT(user *models.User, secret string) (string, error) {
+	return GenerateSessionJWT(user, "", secret)
+}
+
+// GenerateSessionJWT issues an access token bound to a session family, so it
+// stops being accepted once that session is terminated.
+func GenerateSessionJWT(user *models.User, sessionID string, secret string) (string, error) {
 	expirationTime := time.Now().Add(1 * time.Hour)
 
 	claims := &AppClaims{
-		UserID:   user.ID,
-		Username: user.Username,
+		UserID:    user.ID,
+		Username:  user.Username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -54,3 +66,10 @@ func VerifyJWT(tokenString, secret string) (*AppClaims, error) {
 
 	return nil, jwt.ErrInvalidKey
 }
+
+// HashRefreshToken returns the SHA-256 of a refresh token, used to remember
+// rotated tokens without storing them.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
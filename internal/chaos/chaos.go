@@ -0,0 +1,96 @@
+// Package chaos injects latency and failures into the storage and database
+// layers for tests. An Injector is wrapped around a storage backend with
+// storage.NewChaosBackend or around a database store with Store.WithChaos;
+// the server is then built from the wrapped dependencies, so handlers run
+// unchanged while their dependencies misbehave. It is meant for tests only
+// and is not reachable from the configuration.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjected is wrapped by every failure the injector causes.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Operation names passed to Injector.Fault by the wrappers.
+const (
+	StorageSave   = "storage.save"
+	StorageGet    = "storage.get"
+	StorageDelete = "storage.delete"
+	StorageRename = "storage.rename"
+	DBExec        = "db.exec"
+	DBQuery       = "db.query"
+	DBBegin       = "db.begin"
+	DBCommit      = "db.commit"
+)
+
+// Injector decides which operations fail and how long they are delayed. It
+// is safe for concurrent use.
+type Injector struct {
+	mu          sync.Mutex
+	rng         *rand.Rand
+	failureRate float64
+	maxLatency  time.Duration
+	// operations limits failures to the listed operations; nil means all.
+	// Latency applies to all operations.
+	operations map[string]bool
+	injected   atomic.Int64
+}
+
+// New creates an injector failing the given share of operations, between 0
+// and 1, and delaying each by a random duration up to maxLatency. The seed
+// makes a run reproducible. Listing operations restricts failures to them.
+func New(seed int64, failureRate float64, maxLatency time.Duration, operations ...string) *Injector {
+	inj := &Injector{
+		rng:         rand.New(rand.NewSource(seed)),
+		failureRate: failureRate,
+		maxLatency:  maxLatency,
+	}
+	if len(operations) > 0 {
+		inj.operations = make(map[string]bool, len(operations))
+		for _, op := range operations {
+			inj.operations[op] = true
+		}
+	}
+	return inj
+}
+
+// SetFailureRate changes the share of failing operations, e.g. to 0 to let
+// a test check the state left behind.
+func (inj *Injector) SetFailureRate(rate float64) {
+	inj.mu.Lock()
+	inj.failureRate = rate
+	inj.mu.Unlock()
+}
+
+// Injected returns the number of failures caused so far.
+func (inj *Injector) Injected() int64 {
+	return inj.injected.Load()
+}
+
+// Fault delays the operation and returns an error wrapping ErrInjected when
+// it should fail.
+func (inj *Injector) Fault(op string) error {
+	inj.mu.Lock()
+	var delay time.Duration
+	if inj.maxLatency > 0 {
+		delay = time.Duration(inj.rng.Int63n(int64(inj.maxLatency) + 1))
+	}
+	fail := (inj.operations == nil || inj.operations[op]) && inj.rng.Float64() < inj.failureRate
+	inj.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if !fail {
+		return nil
+	}
+	inj.injected.Add(1)
+	return fmt.Errorf("%s: %w", op, ErrInjected)
+}
@@ -0,0 +1,47 @@
+package chaos
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFault(t *testing.T) {
+	never := New(1, 0, 0)
+	always := New(1, 1, 0)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, never.Fault(DBExec))
+		require.ErrorIs(t, always.Fault(DBExec), ErrInjected)
+	}
+	require.EqualValues(t, 0, never.Injected())
+	require.EqualValues(t, 100, always.Injected())
+
+	always.SetFailureRate(0)
+	require.NoError(t, always.Fault(DBExec))
+}
+
+func TestFaultOperations(t *testing.T) {
+	inj := New(1, 1, 0, StorageSave)
+	require.NoError(t, inj.Fault(StorageDelete), "Unlisted operations do not fail")
+	err := inj.Fault(StorageSave)
+	require.True(t, errors.Is(err, ErrInjected))
+	require.Contains(t, err.Error(), StorageSave)
+}
+
+func TestFaultReproducible(t *testing.T) {
+	a, b := New(42, 0.5, 0), New(42, 0.5, 0)
+	for i := 0; i < 50; i++ {
+		require.Equal(t, a.Fault(DBQuery) == nil, b.Fault(DBQuery) == nil)
+	}
+}
+
+func TestFaultLatency(t *testing.T) {
+	inj := New(1, 0, time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, inj.Fault(StorageGet))
+	}
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -7,14 +7,40 @@ import (
 )
 
 type Config struct {
-	DB      DBConfig      `mapstructure:"db"`
-	JWT     JWTConfig     `mapstructure:"jwt"`
-	Storage StorageConfig `mapstructure:"storage"`
-	AppHost string        `mapstructure:"host"`
+	DB            DBConfig                     `mapstructure:"db"`
+	JWT           JWTConfig                    `mapstructure:"jwt"`
+	Storage       StorageConfig                `mapstructure:"storage"`
+	AccessLog     AccessLogConfig              `mapstructure:"access_log"`
+	Limits        LimitsConfig                 `mapstructure:"limits"`
+	Archive       ArchiveConfig                `mapstructure:"archive"`
+	URLImport     URLImportConfig              `mapstructure:"url_import"`
+	ShareReview   ShareReviewConfig            `mapstructure:"share_review"`
+	CORS          CORSConfig                   `mapstructure:"cors"`
+	Versions      VersionsConfig               `mapstructure:"versions"`
+	Undo          UndoConfig                   `mapstructure:"undo"`
+	Quota         QuotaConfig                  `mapstructure:"quota"`
+	Features      map[string]FeatureFlagConfig `mapstructure:"features"`
+	Transcription TranscriptionConfig          `mapstructure:"transcription"`
+	Errors        ErrorsConfig                 `mapstructure:"errors"`
+	IDs           IDsConfig                    `mapstructure:"ids"`
+	Hooks         HooksConfig                  `mapstructure:"hooks"`
+	WebSocket     WebSocketConfig              `mapstructure:"websocket"`
+	Federation    FederationConfig             `mapstructure:"federation"`
+	LDAP          LDAPConfig                   `mapstructure:"ldap"`
+	ContentTypes  ContentTypesConfig           `mapstructure:"content_types"`
+	ContentPolicy ContentPolicyConfig          `mapstructure:"content_policy"`
+	Docs          DocsConfig                   `mapstructure:"docs"`
+	GeoIP         GeoIPConfig                  `mapstructure:"geoip"`
+	Mail          MailConfig                   `mapstructure:"mail"`
+	PublicLinks   PublicLinksConfig            `mapstructure:"public_links"`
+	AppHost       string                       `mapstructure:"host"`
 }
 
 type DBConfig struct {
-	Source string `mapstructure:"source"`
+	Source               string `mapstructure:"source"`
+	SlowQueryThresholdMs int    `mapstructure:"slow_query_threshold_ms"`
+	// ReplicaSource is an optional read replica for listings and event reads.
+	ReplicaSource string `mapstructure:"replica_source"`
 }
 
 type JWTConfig struct {
@@ -23,6 +49,292 @@ type JWTConfig struct {
 
 type StorageConfig struct {
 	Path string `mapstructure:"path"`
+	// UploadsPath holds the chunks of resumable uploads, outside Path;
+	// "<path>-uploads" by default.
+	UploadsPath           string `mapstructure:"uploads_path"`
+	UploadSessionTTLHours int    `mapstructure:"upload_session_ttl_hours"`
+	// MaxRequestSizeMB bounds the body of a single upload request (1024 by
+	// default). MaxFileSizeMB bounds every uploaded file, including resumable
+	// uploads, and MaxFilesPerRequest the files of a multipart upload; 0
+	// means no limit.
+	MaxRequestSizeMB   int64 `mapstructure:"max_request_size_mb"`
+	MaxFileSizeMB      int64 `mapstructure:"max_file_size_mb"`
+	MaxFilesPerRequest int   `mapstructure:"max_files_per_request"`
+	// SecureDelete overwrites file content with random data ShredPasses times
+	// (1 by default) before it is unlinked, in every backend, and records each
+	// purged file in the access log as "shredded".
+	SecureDelete bool `mapstructure:"secure_delete"`
+	ShredPasses  int  `mapstructure:"shred_passes"`
+	// Backends are additional places file content can be routed to, keyed by
+	// name. The backend at Path is always available as "local".
+	Backends map[string]StorageBackendConfig `mapstructure:"backends"`
+	Routing  []StorageRoutingRule            `mapstructure:"routing"`
+}
+
+type StorageBackendConfig struct {
+	// Type is the kind of backend; only "local" (a directory, possibly a
+	// mounted network or object store) is supported.
+	Type string `mapstructure:"type"`
+	Path string `mapstructure:"path"`
+}
+
+type StorageRoutingRule struct {
+	Backend      string   `mapstructure:"backend"`
+	MimePrefixes []string `mapstructure:"mime_prefixes"`
+	MinSizeMB    int64    `mapstructure:"min_size_mb"`
+	MaxSizeMB    int64    `mapstructure:"max_size_mb"`
+}
+
+type AccessLogConfig struct {
+	RetentionDays int  `mapstructure:"retention_days"`
+	AnonymizeIP   bool `mapstructure:"anonymize_ip"`
+}
+
+type LimitsConfig struct {
+	MaxFolderDepth       int   `mapstructure:"max_folder_depth"`
+	MaxChildrenPerFolder int64 `mapstructure:"max_children_per_folder"`
+	// MaxArchiveEntries and MaxArchiveSizeMB bound what a single ZIP download
+	// may contain.
+	MaxArchiveEntries int64 `mapstructure:"max_archive_entries"`
+	MaxArchiveSizeMB  int64 `mapstructure:"max_archive_size_mb"`
+	// MaxExtractEntries and MaxExtractRatio bound archives unpacked on the
+	// server: the number of entries, and how many times its own size an
+	// archive may expand to, which stops zip bombs.
+	MaxExtractEntries int `mapstructure:"max_extract_entries"`
+	MaxExtractRatio   int `mapstructure:"max_extract_ratio"`
+}
+
+// ArchiveConfig tunes archive downloads. GzipLevel is the compression level
+// of tar.gz archives, from 1 (fastest) to 9 (smallest); zero means gzip's
+// default level.
+type ArchiveConfig struct {
+	GzipLevel int `mapstructure:"gzip_level"`
+}
+
+// URLImportConfig limits files the server fetches from remote URLs on behalf
+// of users. Addresses in private, loopback and link-local networks are refused
+// unless AllowPrivateNetworks is set, so imports cannot reach services inside
+// the server's network.
+type URLImportConfig struct {
+	MaxSizeMB            int64 `mapstructure:"max_size_mb"`
+	TimeoutSeconds       int   `mapstructure:"timeout_seconds"`
+	AllowPrivateNetworks bool  `mapstructure:"allow_private_networks"`
+}
+
+// ShareReviewConfig controls reminders to review old shares: a share older
+// than MaxAgeDays whose recipient has not opened anything in it for
+// InactiveDays is included in a reminder to its sharer, at most once every
+// ReminderIntervalDays.
+type ShareReviewConfig struct {
+	Enabled              bool `mapstructure:"enabled"`
+	MaxAgeDays           int  `mapstructure:"max_age_days"`
+	InactiveDays         int  `mapstructure:"inactive_days"`
+	ReminderIntervalDays int  `mapstructure:"reminder_interval_days"`
+}
+
+// CORSConfig lists the browser origins allowed to call the API with
+// credentials. Environment "development" additionally allows any localhost
+// origin.
+type CORSConfig struct {
+	Environment    string   `mapstructure:"environment"`
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+}
+
+// VersionsConfig is the global retention policy for archived file versions.
+// A zero value disables the corresponding limit.
+type VersionsConfig struct {
+	MaxPerFile int   `mapstructure:"max_per_file"`
+	MaxSizeMB  int64 `mapstructure:"max_size_mb"`
+	MaxAgeDays int   `mapstructure:"max_age_days"`
+}
+
+// UndoConfig sets how long delete, rename and move operations can be undone.
+// Zero falls back to the default window.
+type UndoConfig struct {
+	WindowSeconds int `mapstructure:"window_seconds"`
+}
+
+// QuotaConfig decides what counts against storage quotas. Trashed files count
+// by default, until the trash is purged; archived versions never count.
+type QuotaConfig struct {
+	ExcludeTrash bool `mapstructure:"exclude_trash"`
+}
+
+// FeatureFlagConfig sets a feature flag, keyed by its name. RolloutPercent
+// limits an enabled flag to a share of users; 0 and 100 both mean everyone.
+// Administrators can override it at runtime.
+type FeatureFlagConfig struct {
+	Enabled        bool `mapstructure:"enabled"`
+	RolloutPercent int  `mapstructure:"rollout_percent"`
+}
+
+// TranscriptionConfig points at a Whisper-compatible speech-to-text API. An
+// empty endpoint disables transcriptions.
+type TranscriptionConfig struct {
+	Endpoint       string `mapstructure:"endpoint"`
+	APIKey         string `mapstructure:"api_key"`
+	Model          string `mapstructure:"model"`
+	TimeoutSeconds int    `mapstructure:"timeout_seconds"`
+	MaxSizeMB      int64  `mapstructure:"max_size_mb"`
+}
+
+// ErrorsConfig controls how errors are reported to clients. By default a node
+// the user cannot access is reported as not found, so node IDs cannot be
+// probed; ExplicitForbidden answers 403 with a reason code instead when the
+// node exists.
+type ErrorsConfig struct {
+	ExplicitForbidden bool `mapstructure:"explicit_forbidden"`
+}
+
+// IDsConfig shapes generated node IDs. An empty Alphabet means the standard
+// URL-safe nanoid alphabet; Length is at most 21, zero meaning 21.
+type IDsConfig struct {
+	Alphabet string `mapstructure:"alphabet"`
+	Length   int    `mapstructure:"length"`
+}
+
+// HooksConfig limits folder upload hooks. Hooks can only call hosts listed
+// in AllowedHosts, so an empty list disables them. Failed deliveries are
+// retried with backoff up to MaxAttempts times.
+type HooksConfig struct {
+	AllowedHosts   []string `mapstructure:"allowed_hosts"`
+	TimeoutSeconds int      `mapstructure:"timeout_seconds"`
+	MaxAttempts    int      `mapstructure:"max_attempts"`
+}
+
+// FederationConfig enables the experimental sharing with users of trusted
+// peer instances. InstanceName is the name this instance is listed under in
+// the peers' configuration.
+type FederationConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	InstanceName   string `mapstructure:"instance_name"`
+	TimeoutSeconds int    `mapstructure:"timeout_seconds"`
+	// Peers are keyed by the name users address them with, as in
+	// "anna@partner". Names are lowercase.
+	Peers map[string]FederationPeerConfig `mapstructure:"peers"`
+}
+
+type FederationPeerConfig struct {
+	// URL is the base of the peer's API, e.g. "https://files.example.com/api/v1".
+	URL string `mapstructure:"url"`
+	// Secret is shared with the peer and signs requests in both directions.
+	Secret string `mapstructure:"secret"`
+}
+
+// LDAPConfig synchronizes accounts from an LDAP directory such as Active
+// Directory. An empty URL disables the synchronization. Accounts matching
+// UserFilter are created or updated, and synchronized accounts that no
+// longer match are disabled.
+type LDAPConfig struct {
+	// URL is "ldap://host[:port]" or "ldaps://host[:port]".
+	URL          string `mapstructure:"url"`
+	BindDN       string `mapstructure:"bind_dn"`
+	BindPassword string `mapstructure:"bind_password"`
+	BaseDN       string `mapstructure:"base_dn"`
+	UserFilter   string `mapstructure:"user_filter"`
+	// UsernameAttribute and DisplayNameAttribute map entries to accounts,
+	// e.g. "sAMAccountName" and "displayName" for Active Directory.
+	UsernameAttribute    string `mapstructure:"username_attribute"`
+	DisplayNameAttribute string `mapstructure:"display_name_attribute"`
+	// GroupFilter selects the groups synchronized along with the accounts;
+	// groups are not synchronized when it is empty. GroupMemberAttribute
+	// lists members by DN ("member") or by username ("memberUid").
+	GroupFilter          string `mapstructure:"group_filter"`
+	GroupNameAttribute   string `mapstructure:"group_name_attribute"`
+	GroupMemberAttribute string `mapstructure:"group_member_attribute"`
+	SyncIntervalMinutes  int    `mapstructure:"sync_interval_minutes"`
+	TimeoutSeconds       int    `mapstructure:"timeout_seconds"`
+}
+
+// ContentTypesConfig restricts uploads by the file type detected from their
+// content. Entries are exact types such as "application/x-executable" or
+// patterns such as "video/*". An empty Allowed list allows every type that is
+// not Blocked.
+type ContentTypesConfig struct {
+	Allowed []string `mapstructure:"allowed"`
+	Blocked []string `mapstructure:"blocked"`
+}
+
+// ContentPolicyConfig lists the rules of the built-in content policy, checked
+// in order on uploads and shares; the first matching rule decides. Without
+// rules every file is allowed. Content is searched up to MaxScanMB.
+type ContentPolicyConfig struct {
+	MaxScanMB int64                     `mapstructure:"max_scan_mb"`
+	Rules     []ContentPolicyRuleConfig `mapstructure:"rules"`
+}
+
+// ContentPolicyRuleConfig matches files by name, type and content. Every
+// condition that is set must match.
+type ContentPolicyRuleConfig struct {
+	Name string `mapstructure:"name"`
+	// Actions is "upload", "share" or both; empty means both.
+	Actions []string `mapstructure:"actions"`
+	// FileName and Content are regular expressions.
+	FileName  string   `mapstructure:"file_name"`
+	MimeTypes []string `mapstructure:"mime_types"`
+	Content   string   `mapstructure:"content"`
+	// Detector is a built-in check; "credit_card" finds payment card numbers.
+	Detector string `mapstructure:"detector"`
+	// Verdict is "allow", "deny" or "quarantine".
+	Verdict string `mapstructure:"verdict"`
+	Reason  string `mapstructure:"reason"`
+}
+
+// WebSocketConfig tunes event delivery to WebSocket clients. A zero
+// SendBufferSize means the default of 256 queued events per client.
+type WebSocketConfig struct {
+	SendBufferSize       int  `mapstructure:"send_buffer_size"`
+	DisconnectOnOverflow bool `mapstructure:"disconnect_on_overflow"`
+}
+
+// DocsConfig controls what the server advertises outside the API. Public
+// deployments can set DisableSwagger to stop serving the API documentation
+// under /swagger. The root page redirects to LandingRedirect when it is set,
+// otherwise shows LandingText; with neither set it shows the default page
+// pointing to the documentation, or answers 404 when Swagger is disabled.
+type DocsConfig struct {
+	DisableSwagger  bool   `mapstructure:"disable_swagger"`
+	LandingRedirect string `mapstructure:"landing_redirect"`
+	LandingText     string `mapstructure:"landing_text"`
+}
+
+// GeoIPConfig points to a MaxMind DB file (e.g. GeoLite2-City.mmdb) used to
+// show where sessions were started from. An empty DatabasePath disables the
+// lookups. Language picks the place names, English by default, and
+// CacheSize the number of remembered addresses, 10000 when zero.
+type GeoIPConfig struct {
+	DatabasePath string `mapstructure:"database_path"`
+	Language     string `mapstructure:"language"`
+	CacheSize    int    `mapstructure:"cache_size"`
+}
+
+// MailConfig sends email through an SMTP server, used to verify the users'
+// addresses and to reset forgotten passwords. An empty Host disables email.
+// VerifyURL and ResetURL are links to the client's pages, with "{token}"
+// replaced by the token; without them the emails contain only the token.
+// Tokens are valid for VerifyTokenTTLHours (24 when zero) and
+// ResetTokenTTLMinutes (30 when zero). MaxPerHour limits the emails of each
+// kind sent to one account per hour, 3 when zero.
+type MailConfig struct {
+	Host                 string `mapstructure:"host"`
+	Port                 int    `mapstructure:"port"`
+	Username             string `mapstructure:"username"`
+	Password             string `mapstructure:"password"`
+	From                 string `mapstructure:"from"`
+	VerifyURL            string `mapstructure:"verify_url"`
+	ResetURL             string `mapstructure:"reset_url"`
+	VerifyTokenTTLHours  int    `mapstructure:"verify_token_ttl_hours"`
+	ResetTokenTTLMinutes int    `mapstructure:"reset_token_ttl_minutes"`
+	MaxPerHour           int    `mapstructure:"max_per_hour"`
+}
+
+// PublicLinksConfig describes link previews. BaseURL is the public address
+// of the links, e.g. "https://pliki.example.com/api/v1/public"; without it
+// the address is taken from the request. PreviewsPerMinute limits the
+// previews served to one address per minute, 60 when zero.
+type PublicLinksConfig struct {
+	BaseURL           string `mapstructure:"base_url"`
+	PreviewsPerMinute int    `mapstructure:"previews_per_minute"`
 }
 
 func Load() (*Config, error) {
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// reloadDebounce is how long Watch waits for writes to the configuration file
+// to settle, as editors often save a file in several steps.
+const reloadDebounce = 500 * time.Millisecond
+
+// Change is a configuration value that differs between two configurations,
+// keyed by its dotted path in the settings file, e.g. "limits.max_folder_depth".
+type Change struct {
+	Key string      `json:"key" example:"limits.max_folder_depth"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Reload reads the settings file found by Load again. Environment variables
+// still take precedence over it.
+func Reload() (*Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ApplyReloadable returns a copy of current with the sections that are safe to
+// change at runtime taken from loaded. Everything else is read once at startup
+// (database, storage locations, clients of external services) and keeps its
+// current value. It also returns the changes applied and the keys whose new
+// values only take effect after a restart.
+func ApplyReloadable(current, loaded *Config) (*Config, []Change, []string) {
+	next := *current
+	next.Limits = loaded.Limits
+	next.CORS = loaded.CORS
+	next.Archive = loaded.Archive
+	next.AccessLog = loaded.AccessLog
+	next.ShareReview = loaded.ShareReview
+	next.Versions = loaded.Versions
+	next.Undo = loaded.Undo
+	next.Quota = loaded.Quota
+	next.Features = loaded.Features
+	next.Errors = loaded.Errors
+	next.ContentTypes = loaded.ContentTypes
+	next.Storage.UploadSessionTTLHours = loaded.Storage.UploadSessionTTLHours
+	next.Storage.MaxRequestSizeMB = loaded.Storage.MaxRequestSizeMB
+	next.Storage.MaxFileSizeMB = loaded.Storage.MaxFileSizeMB
+	next.Storage.MaxFilesPerRequest = loaded.Storage.MaxFilesPerRequest
+
+	var restartRequired []string
+	for _, change := range Diff(&next, loaded) {
+		restartRequired = append(restartRequired, change.Key)
+	}
+	return &next, Diff(current, &next), restartRequired
+}
+
+// Diff lists the values that differ between two configurations. Structs are
+// compared field by field; lists and maps are compared as a whole.
+func Diff(from, to *Config) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(*from), reflect.ValueOf(*to), &changes)
+	return changes
+}
+
+func diffValues(key string, from, to reflect.Value, changes *[]Change) {
+	if from.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(from.Interface(), to.Interface()) {
+			*changes = append(*changes, Change{Key: key, Old: from.Interface(), New: to.Interface()})
+		}
+		return
+	}
+	for i := 0; i < from.NumField(); i++ {
+		field := from.Type().Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			name = field.Name
+		}
+		if key != "" {
+			name = key + "." + name
+		}
+		diffValues(name, from.Field(i), to.Field(i), changes)
+	}
+}
+
+// Watch calls onChange whenever the settings file found by Load is written,
+// replaced or renamed into place, until ctx is done. The directory is watched
+// rather than the file, so a file replaced atomically by an editor keeps being
+// followed.
+func Watch(ctx context.Context, onChange func()) error {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return errors.New("no settings file was loaded")
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("watching %s: %w", filepath.Dir(file), err)
+	}
+
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				pending = time.After(reloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("WARN: Watching the settings file failed: %v", err)
+		case <-pending:
+			pending = nil
+			onChange()
+		}
+	}
+}
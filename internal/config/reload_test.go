@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyReloadable(t *testing.T) {
+	current := &Config{
+		DB:      DBConfig{Source: "postgres://old"},
+		Storage: StorageConfig{Path: "/storage", MaxRequestSizeMB: 1024},
+		Limits:  LimitsConfig{MaxFolderDepth: 64},
+		CORS:    CORSConfig{AllowedOrigins: []string{"https://a.example.com"}},
+	}
+	loaded := &Config{
+		DB:      DBConfig{Source: "postgres://new"},
+		Storage: StorageConfig{Path: "/elsewhere", MaxRequestSizeMB: 512},
+		Limits:  LimitsConfig{MaxFolderDepth: 32},
+		CORS:    CORSConfig{AllowedOrigins: []string{"https://a.example.com", "https://b.example.com"}},
+	}
+
+	next, applied, restartRequired := ApplyReloadable(current, loaded)
+	require.Equal(t, []Change{
+		{Key: "storage.max_request_size_mb", Old: int64(1024), New: int64(512)},
+		{Key: "limits.max_folder_depth", Old: 64, New: 32},
+		{Key: "cors.allowed_origins", Old: current.CORS.AllowedOrigins, New: loaded.CORS.AllowedOrigins},
+	}, applied)
+	require.Equal(t, []string{"db.source", "storage.path"}, restartRequired)
+
+	require.Equal(t, "postgres://old", next.DB.Source)
+	require.Equal(t, "/storage", next.Storage.Path)
+	require.Equal(t, 32, next.Limits.MaxFolderDepth)
+	require.Equal(t, 64, current.Limits.MaxFolderDepth, "The running configuration is not modified")
+
+	_, applied, restartRequired = ApplyReloadable(next, loaded)
+	require.Empty(t, applied)
+	require.Len(t, restartRequired, 2, "Settings needing a restart stay pending")
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "settings.yml")
+	require.NoError(t, os.WriteFile(file, []byte("limits:\n  max_folder_depth: 64\n"), 0o644))
+	viper.SetConfigFile(file)
+	defer viper.Reset()
+	_, err := Reload()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go Watch(ctx, func() { changed <- struct{}{} })
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(file, []byte("limits:\n  max_folder_depth: 8\n"), 0o644))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change of the settings file was not noticed")
+	}
+	cfg, err := Reload()
+	require.NoError(t, err)
+	require.Equal(t, 8, cfg.Limits.MaxFolderDepth)
+}
@@ -0,0 +1,46 @@
+// Package contentpolicy decides whether file content may be stored or shared.
+// Policies are consulted when a file is uploaded and when it is shared, and
+// answer with a verdict: allow, deny, or quarantine, which keeps the file but
+// blocks its downloads and shares until an administrator releases it.
+package contentpolicy
+
+import (
+	"context"
+	"io"
+)
+
+type Verdict string
+
+const (
+	Allow      Verdict = "allow"
+	Deny       Verdict = "deny"
+	Quarantine Verdict = "quarantine"
+)
+
+// Actions a policy is consulted for.
+const (
+	ActionUpload = "upload"
+	ActionShare  = "share"
+)
+
+// File describes the file being evaluated.
+type File struct {
+	Name      string
+	MimeType  string
+	SizeBytes int64
+}
+
+// Decision is a policy's verdict on a file. Rule and Reason identify what
+// matched and are empty when the file is allowed by default.
+type Decision struct {
+	Verdict Verdict `json:"verdict" example:"deny"`
+	Rule    string  `json:"rule,omitempty" example:"karty-platnicze"`
+	Reason  string  `json:"reason,omitempty" example:"The file contains payment card numbers"`
+}
+
+// Policy evaluates a file for an action. content yields the file's content
+// from the start; a policy need not read it all. Implementations may scan
+// for viruses, look for sensitive data or restrict file types.
+type Policy interface {
+	Evaluate(ctx context.Context, action string, file File, content io.Reader) (Decision, error)
+}
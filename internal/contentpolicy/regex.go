@@ -0,0 +1,208 @@
+package contentpolicy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// DefaultMaxScanBytes is how much of a file's content is searched when the
+// policy does not set a limit.
+const DefaultMaxScanBytes = 10 << 20
+
+// DetectorCreditCard finds payment card numbers: 13 to 19 digits, optionally
+// grouped with spaces or dashes, that pass the Luhn check.
+const DetectorCreditCard = "credit_card"
+
+var creditCardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// Rule is one entry of a RegexPolicy. Every condition that is set must match
+// for the rule to apply.
+type Rule struct {
+	Name string
+	// Actions limits the rule to uploads or shares; empty means both.
+	Actions []string
+	// FileName is matched against the file name.
+	FileName string
+	// MimeTypes are exact types such as "application/pdf" or patterns such
+	// as "image/*".
+	MimeTypes []string
+	// Content is searched for in the content.
+	Content string
+	// Detector is a built-in content check, DetectorCreditCard.
+	Detector string
+	Verdict  Verdict
+	// Reason is reported to the user; it defaults to a generic message.
+	Reason string
+}
+
+type compiledRule struct {
+	Rule
+	fileName *regexp.Regexp
+	content  *regexp.Regexp
+	detect   func([]byte) bool
+}
+
+func (r *compiledRule) inspectsContent() bool {
+	return r.content != nil || r.detect != nil
+}
+
+// RegexPolicy is the reference Policy. It applies the first rule matching a
+// file and allows files no rule matches. Content is searched up to a limit,
+// so data past it is not found.
+type RegexPolicy struct {
+	rules        []compiledRule
+	maxScanBytes int64
+}
+
+// NewRegexPolicy compiles rules. A maxScanBytes of zero means
+// DefaultMaxScanBytes.
+func NewRegexPolicy(rules []Rule, maxScanBytes int64) (*RegexPolicy, error) {
+	if maxScanBytes <= 0 {
+		maxScanBytes = DefaultMaxScanBytes
+	}
+	policy := &RegexPolicy{maxScanBytes: maxScanBytes}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		compiled := compiledRule{Rule: rule}
+		switch rule.Verdict {
+		case Allow, Deny, Quarantine:
+		default:
+			return nil, fmt.Errorf("rule %q: verdict must be allow, deny or quarantine, not %q", rule.Name, rule.Verdict)
+		}
+		for _, action := range rule.Actions {
+			if action != ActionUpload && action != ActionShare {
+				return nil, fmt.Errorf("rule %q: unknown action %q", rule.Name, action)
+			}
+		}
+		var err error
+		if rule.FileName != "" {
+			if compiled.fileName, err = regexp.Compile(rule.FileName); err != nil {
+				return nil, fmt.Errorf("rule %q: invalid file name pattern: %w", rule.Name, err)
+			}
+		}
+		if rule.Content != "" {
+			if compiled.content, err = regexp.Compile(rule.Content); err != nil {
+				return nil, fmt.Errorf("rule %q: invalid content pattern: %w", rule.Name, err)
+			}
+		}
+		switch rule.Detector {
+		case "":
+		case DetectorCreditCard:
+			compiled.detect = containsCreditCard
+		default:
+			return nil, fmt.Errorf("rule %q: unknown detector %q", rule.Name, rule.Detector)
+		}
+		policy.rules = append(policy.rules, compiled)
+	}
+	return policy, nil
+}
+
+func (p *RegexPolicy) Evaluate(_ context.Context, action string, file File, content io.Reader) (Decision, error) {
+	var head []byte
+	scanned := false
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if !rule.appliesTo(action, file) {
+			continue
+		}
+		if rule.inspectsContent() {
+			if !scanned {
+				var err error
+				if head, err = io.ReadAll(io.LimitReader(content, p.maxScanBytes)); err != nil {
+					return Decision{}, err
+				}
+				scanned = true
+			}
+			if rule.content != nil && !rule.content.Match(head) {
+				continue
+			}
+			if rule.detect != nil && !rule.detect(head) {
+				continue
+			}
+		}
+		reason := rule.Reason
+		if reason == "" && rule.Verdict != Allow {
+			reason = "The file is not allowed by the content policy"
+		}
+		return Decision{Verdict: rule.Verdict, Rule: rule.Name, Reason: reason}, nil
+	}
+	return Decision{Verdict: Allow}, nil
+}
+
+func (r *compiledRule) appliesTo(action string, file File) bool {
+	if len(r.Actions) > 0 && !contains(r.Actions, action) {
+		return false
+	}
+	if r.fileName != nil && !r.fileName.MatchString(file.Name) {
+		return false
+	}
+	if len(r.MimeTypes) > 0 && !matchesMimeType(r.MimeTypes, file.MimeType) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMimeType(patterns []string, mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = strings.ToLower(mimeType)
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsCreditCard reports whether content has a digit sequence that looks
+// like a payment card number and passes the Luhn check, which rules out most
+// phone numbers, IDs and amounts.
+func containsCreditCard(content []byte) bool {
+	for _, match := range creditCardPattern.FindAll(content, -1) {
+		digits := bytes.Map(func(r rune) rune {
+			if r == ' ' || r == '-' {
+				return -1
+			}
+			return r
+		}, match)
+		if luhnValid(digits) {
+			return true
+		}
+	}
+	return false
+}
+
+func luhnValid(digits []byte) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,65 @@
+package contentpolicy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexPolicy(t *testing.T) {
+	policy, err := NewRegexPolicy([]Rule{
+		{Name: "zaufane", FileName: `^zaufane/`, Verdict: Allow},
+		{Name: "wykonywalne", Actions: []string{ActionUpload}, MimeTypes: []string{"application/x-executable"}, Verdict: Quarantine},
+		{Name: "karty", Actions: []string{ActionShare}, Detector: DetectorCreditCard, Verdict: Deny, Reason: "Plik zawiera numery kart"},
+		{Name: "tajne", MimeTypes: []string{"text/*"}, Content: `(?i)ściśle tajne`, Verdict: Deny},
+	}, 0)
+	require.NoError(t, err)
+
+	evaluate := func(action, name, mimeType, content string) Decision {
+		decision, err := policy.Evaluate(context.Background(), action, File{Name: name, MimeType: mimeType}, strings.NewReader(content))
+		require.NoError(t, err)
+		return decision
+	}
+
+	require.Equal(t, Decision{Verdict: Allow}, evaluate(ActionUpload, "notatka.txt", "text/plain", "lista zakupów"))
+	require.Equal(t, Decision{Verdict: Quarantine, Rule: "wykonywalne", Reason: "The file is not allowed by the content policy"},
+		evaluate(ActionUpload, "narzedzie", "application/x-executable", "\x7fELF"))
+	require.Equal(t, Allow, evaluate(ActionShare, "narzedzie", "application/x-executable", "\x7fELF").Verdict, "The rule only applies to uploads")
+
+	card := "Numer karty: 4111 1111 1111 1111, ważna do 12/29"
+	require.Equal(t, Allow, evaluate(ActionUpload, "dane.txt", "text/plain", card).Verdict)
+	require.Equal(t, Decision{Verdict: Deny, Rule: "karty", Reason: "Plik zawiera numery kart"}, evaluate(ActionShare, "dane.txt", "text/plain", card))
+	require.Equal(t, Allow, evaluate(ActionShare, "dane.txt", "text/plain", "Telefon: 4111 1111 1111 1112").Verdict, "Numbers failing the Luhn check are not cards")
+	require.Equal(t, Allow, evaluate(ActionShare, "zaufane/dane.txt", "text/plain", card).Verdict, "The first matching rule wins")
+
+	require.Equal(t, "tajne", evaluate(ActionShare, "plan.md", "text/markdown; charset=utf-8", "Dokument ŚCIŚLE TAJNE").Rule)
+	require.Equal(t, Allow, evaluate(ActionShare, "plan.pdf", "application/pdf", "Dokument ŚCIŚLE TAJNE").Verdict)
+}
+
+func TestRegexPolicyScanLimit(t *testing.T) {
+	policy, err := NewRegexPolicy([]Rule{{Content: "sekret", Verdict: Deny}}, 16)
+	require.NoError(t, err)
+
+	decision, err := policy.Evaluate(context.Background(), ActionUpload, File{}, strings.NewReader("sekret"))
+	require.NoError(t, err)
+	require.Equal(t, Decision{Verdict: Deny, Rule: "rule-1", Reason: "The file is not allowed by the content policy"}, decision)
+
+	decision, err = policy.Evaluate(context.Background(), ActionUpload, File{}, strings.NewReader(strings.Repeat("x", 16)+"sekret"))
+	require.NoError(t, err)
+	require.Equal(t, Allow, decision.Verdict)
+}
+
+func TestNewRegexPolicyValidation(t *testing.T) {
+	for _, rule := range []Rule{
+		{Verdict: "block"},
+		{Verdict: Deny, Actions: []string{"download"}},
+		{Verdict: Deny, Content: "("},
+		{Verdict: Deny, FileName: "["},
+		{Verdict: Deny, Detector: "pesel"},
+	} {
+		_, err := NewRegexPolicy([]Rule{rule}, 0)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"serwer-plikow/internal/chaos"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// chaosDB fails and delays the statements sent through it, for chaos tests.
+// An injected failure happens before the statement reaches the database.
+type chaosDB struct {
+	db       DBTX
+	injector *chaos.Injector
+}
+
+func (c chaosDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if err := c.injector.Fault(chaos.DBExec); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	return c.db.Exec(ctx, sql, args...)
+}
+
+func (c chaosDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if err := c.injector.Fault(chaos.DBQuery); err != nil {
+		return nil, err
+	}
+	return c.db.Query(ctx, sql, args...)
+}
+
+func (c chaosDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if err := c.injector.Fault(chaos.DBQuery); err != nil {
+		return errorRow{err}
+	}
+	return c.db.QueryRow(ctx, sql, args...)
+}
+
+type errorRow struct{ err error }
+
+func (r errorRow) Scan(...any) error { return r.err }
+
+// WithChaos returns a store over the same pools whose statements, and the
+// transactions of ExecTx, can be delayed and fail as the injector decides.
+// It is meant for chaos tests only.
+func (s *Store) WithChaos(injector *chaos.Injector) *Store {
+	store := &Store{
+		pool:        s.pool,
+		Queries:     New(chaosDB{db: s.pool, injector: injector}),
+		replicaPool: s.replicaPool,
+		chaos:       injector,
+	}
+	if s.replica != nil {
+		store.replica = New(chaosDB{db: s.replicaPool, injector: injector})
+	}
+	return store
+}
@@ -46,6 +46,44 @@ func (q *Queries) LogEvent(ctx context.Context, userID int64, eventType string,
 	return nil
 }
 
+type EventEntry struct {
+	UserID    int64
+	EventType string
+	Payload   interface{}
+}
+
+// LogEvents journals many events with a single insert, keeping their order.
+func (q *Queries) LogEvents(ctx context.Context, entries []EventEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	userIDs := make([]int64, len(entries))
+	eventTypes := make([]string, len(entries))
+	payloads := make([]string, len(entries))
+	for i, entry := range entries {
+		eventBytes, err := json.Marshal(map[string]interface{}{
+			"event_type": entry.EventType,
+			"payload":    entry.Payload,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal event payload: %w", err)
+		}
+		userIDs[i] = entry.UserID
+		eventTypes[i] = entry.EventType
+		payloads[i] = string(eventBytes)
+	}
+
+	query := `
+		INSERT INTO event_journal (user_id, event_type, payload)
+		SELECT e.user_id, e.event_type, e.payload::jsonb
+		FROM unnest($1::int[], $2::text[], $3::text[]) WITH ORDINALITY AS e(user_id, event_type, payload, ord)
+		ORDER BY e.ord
+	`
+	_, err := q.db.Exec(ctx, query, userIDs, eventTypes, payloads)
+	return err
+}
+
 type Event struct {
 	ID        int64           `json:"id"`
 	EventType string          `json:"event_type"`
@@ -53,15 +91,17 @@ type Event struct {
 	Payload   json.RawMessage `json:"payload"`
 }
 
-func (q *Queries) GetEventsSince(ctx context.Context, userID int64, sinceID int64) ([]Event, error) {
+// GetEventsSince returns up to limit of the user's events following sinceID,
+// oldest first.
+func (q *Queries) GetEventsSince(ctx context.Context, userID int64, sinceID int64, limit int) ([]Event, error) {
 	query := `
 		SELECT id, event_type, event_time, payload
 		FROM event_journal
 		WHERE user_id = $1 AND id > $2
 		ORDER BY id ASC
-		LIMIT 100
+		LIMIT $3
 	`
-	rows, err := q.db.Query(ctx, query, userID, sinceID)
+	rows, err := q.db.Query(ctx, query, userID, sinceID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -126,14 +166,37 @@ func (q *Queries) RemoveFavorite(ctx context.Context, userID int64, nodeID strin
 	return res.RowsAffected() > 0, nil
 }
 
+// ListFavorites returns the user's favorites that they can still access;
+// favorites of nodes whose share has been revoked are left out.
 func (q *Queries) ListFavorites(ctx context.Context, userID int64, limit int, offset int) ([]models.Node, error) {
 	query := `
+		WITH RECURSIVE favorite_ancestors AS (
+			SELECT f.node_id, n.id AS ancestor_id, n.parent_id
+			FROM user_favorites f
+			JOIN nodes n ON n.id = f.node_id
+			WHERE f.user_id = $1 AND n.owner_id <> $1
+
+			UNION ALL
+
+			SELECT fa.node_id, p.id, p.parent_id
+			FROM favorite_ancestors fa
+			JOIN nodes p ON p.id = fa.parent_id
+		)
 		SELECT 
 			n.id, n.owner_id, n.parent_id, n.name, n.node_type, 
 			n.size_bytes, n.mime_type, n.created_at, n.modified_at
 		FROM nodes n
 		JOIN user_favorites f ON n.id = f.node_id
 		WHERE f.user_id = $1 AND n.deleted_at IS NULL
+		  AND (
+			n.owner_id = $1
+			OR EXISTS (
+				SELECT 1
+				FROM favorite_ancestors fa
+				JOIN share_grants s ON s.node_id = fa.ancestor_id
+				WHERE fa.node_id = n.id AND s.recipient_id = $1
+			)
+		  )
 		ORDER BY n.name LIMIT $2 OFFSET $3
 	`
 	rows, err := q.db.Query(ctx, query, userID, limit, offset)
@@ -175,15 +238,19 @@ type ShareNodeParams struct {
 	SharerID    int64
 	RecipientID int64
 	Permissions string
+	Message     *string
+	// PinnedVersion, when set, fixes the shared file at that version so later
+	// edits are not visible to the recipient.
+	PinnedVersion *int
 }
 
 func (q *Queries) ShareNode(ctx context.Context, arg ShareNodeParams) (*models.Share, error) {
 	query := `
-		INSERT INTO shares (node_id, sharer_id, recipient_id, permissions)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, node_id, sharer_id, recipient_id, permissions, shared_at
+		INSERT INTO shares (node_id, sharer_id, recipient_id, permissions, message, pinned_version)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, node_id, sharer_id, recipient_id, permissions, message, pinned_version, shared_at
 	`
-	row := q.db.QueryRow(ctx, query, arg.NodeID, arg.SharerID, arg.RecipientID, arg.Permissions)
+	row := q.db.QueryRow(ctx, query, arg.NodeID, arg.SharerID, arg.RecipientID, arg.Permissions, arg.Message, arg.PinnedVersion)
 
 	var share models.Share
 	var err = row.Scan(
@@ -192,6 +259,8 @@ func (q *Queries) ShareNode(ctx context.Context, arg ShareNodeParams) (*models.S
 		&share.SharerID,
 		&share.RecipientID,
 		&share.Permissions,
+		&share.Message,
+		&share.PinnedVersion,
 		&share.SharedAt,
 	)
 
@@ -221,9 +290,9 @@ func (q *Queries) GetSharingUsers(ctx context.Context, recipientID int64, limit
 			u.id,
 			u.username,
 			u.display_name
-		FROM shares s
+		FROM share_grants s
 		JOIN users u ON s.sharer_id = u.id
-		WHERE s.recipient_id = $1
+		WHERE s.recipient_id = $1 AND s.sharer_id <> $1
 		ORDER BY u.id LIMIT $2 OFFSET $3
 	`
 	rows, err := q.db.Query(ctx, query, recipientID, limit, offset)
@@ -252,7 +321,18 @@ func (q *Queries) GetSharingUsers(ctx context.Context, recipientID int64, limit
 	return users, nil
 }
 
-func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64, sharerID int64, limit int, offset int) ([]models.Node, error) {
+// SharedNode is a node shared directly with a recipient or with a group they
+// are in, along with the permissions and message of that share. For shares
+// pinned to a version the size, MIME type and modification time describe
+// that version.
+type SharedNode struct {
+	models.Node
+	SharePermissions string  `json:"share_permissions"`
+	ShareMessage     *string `json:"share_message,omitempty"`
+	PinnedVersion    *int    `json:"pinned_version,omitempty"`
+}
+
+func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64, sharerID int64, limit int, offset int) ([]SharedNode, error) {
 	query := `
 		SELECT 
 			n.id, 
@@ -260,13 +340,22 @@ func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64
 			n.parent_id, 
 			n.name, 
 			n.node_type, 
-			n.size_bytes, 
-			n.mime_type,
+			COALESCE(v.size_bytes, n.size_bytes),
+			CASE WHEN v.node_id IS NULL THEN n.mime_type ELSE v.mime_type END,
 			n.created_at,
-			n.modified_at
+			COALESCE(v.created_at, n.modified_at),
+			s.permissions,
+			s.message,
+			s.pinned_version
 		FROM nodes n
-		JOIN shares s ON n.id = s.node_id
-		WHERE s.recipient_id = $1 AND s.sharer_id = $2 AND n.deleted_at IS NULL
+		JOIN (
+			SELECT DISTINCT ON (node_id) node_id, permissions, message, pinned_version
+			FROM share_grants
+			WHERE recipient_id = $1 AND sharer_id = $2
+			ORDER BY node_id, permissions = 'manage' DESC, permissions = 'write' DESC, group_id NULLS FIRST
+		) s ON n.id = s.node_id
+		LEFT JOIN node_versions v ON v.node_id = n.id AND v.version = s.pinned_version
+		WHERE n.deleted_at IS NULL
 		ORDER BY n.node_type DESC, n.name LIMIT $3 OFFSET $4
 	`
 
@@ -276,9 +365,9 @@ func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64
 	}
 	defer rows.Close()
 
-	var nodes []models.Node
+	var nodes []SharedNode
 	for rows.Next() {
-		var node models.Node
+		var node SharedNode
 		err := rows.Scan(
 			&node.ID,
 			&node.OwnerID,
@@ -289,6 +378,9 @@ func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64
 			&node.MimeType,
 			&node.CreatedAt,
 			&node.ModifiedAt,
+			&node.SharePermissions,
+			&node.ShareMessage,
+			&node.PinnedVersion,
 		)
 		if err != nil {
 			return nil, err
@@ -301,12 +393,104 @@ func (q *Queries) ListDirectlySharedNodes(ctx context.Context, recipientID int64
 	}
 
 	if nodes == nil {
-		return []models.Node{}, nil
+		return []SharedNode{}, nil
 	}
 
 	return nodes, nil
 }
 
+// IncomingShare is a share made to a user, directly or through a group
+// they are in, with its sharer and the shared node. For shares pinned to a
+// version the node's size, MIME type and modification time describe that
+// version.
+type IncomingShare struct {
+	ID                int64
+	GroupID           *int64
+	SharerID          int64
+	SharerUsername    string
+	SharerDisplayName *string
+	Permissions       string
+	Message           *string
+	PinnedVersion     *int
+	SharedAt          time.Time
+	Node              models.Node
+}
+
+// IncomingShareSort names an ordering of ListIncomingShares.
+type IncomingShareSort string
+
+const (
+	IncomingShareSortSharedAt IncomingShareSort = "shared_at"
+	IncomingShareSortName     IncomingShareSort = "name"
+	IncomingShareSortSharer   IncomingShareSort = "sharer"
+	IncomingShareSortSize     IncomingShareSort = "size"
+)
+
+var incomingShareOrderColumns = map[IncomingShareSort]string{
+	IncomingShareSortSharedAt: "g.shared_at",
+	IncomingShareSortName:     "lower(n.name)",
+	IncomingShareSortSharer:   "lower(u.username)",
+	IncomingShareSortSize:     "COALESCE(v.size_bytes, n.size_bytes, 0)",
+}
+
+// ValidIncomingShareSort reports whether sort is an ordering
+// ListIncomingShares supports.
+func ValidIncomingShareSort(sort IncomingShareSort) bool {
+	_, ok := incomingShareOrderColumns[sort]
+	return ok
+}
+
+// ListIncomingShares lists every share of a node not in the trash made to
+// the user by someone else, ordered by sort, descending when desc is set.
+func (q *Queries) ListIncomingShares(ctx context.Context, recipientID int64, sort IncomingShareSort, desc bool, limit int, offset int) ([]IncomingShare, error) {
+	column, ok := incomingShareOrderColumns[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown incoming share sort %q", sort)
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			g.id, g.group_id, g.sharer_id, u.username, u.display_name,
+			g.permissions, g.message, g.pinned_version, g.shared_at,
+			n.id, n.owner_id, n.parent_id, n.name, n.node_type,
+			COALESCE(v.size_bytes, n.size_bytes),
+			CASE WHEN v.node_id IS NULL THEN n.mime_type ELSE v.mime_type END,
+			n.created_at,
+			COALESCE(v.created_at, n.modified_at)
+		FROM share_grants g
+		JOIN nodes n ON n.id = g.node_id
+		JOIN users u ON u.id = g.sharer_id
+		LEFT JOIN node_versions v ON v.node_id = n.id AND v.version = g.pinned_version
+		WHERE g.recipient_id = $1 AND g.sharer_id <> $1 AND n.deleted_at IS NULL
+		ORDER BY %s %s, g.id %s LIMIT $2 OFFSET $3
+	`, column, direction, direction)
+
+	rows, err := q.db.Query(ctx, query, recipientID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []IncomingShare{}
+	for rows.Next() {
+		var share IncomingShare
+		err := rows.Scan(
+			&share.ID, &share.GroupID, &share.SharerID, &share.SharerUsername, &share.SharerDisplayName,
+			&share.Permissions, &share.Message, &share.PinnedVersion, &share.SharedAt,
+			&share.Node.ID, &share.Node.OwnerID, &share.Node.ParentID, &share.Node.Name, &share.Node.NodeType,
+			&share.Node.SizeBytes, &share.Node.MimeType, &share.Node.CreatedAt, &share.Node.ModifiedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
 func (q *Queries) HasAccessToNode(ctx context.Context, nodeID string, recipientID int64) (bool, error) {
 	query := `
 		WITH RECURSIVE node_parents AS (
@@ -322,7 +506,7 @@ func (q *Queries) HasAccessToNode(ctx context.Context, nodeID string, recipientI
 		)
 		SELECT EXISTS (
 			SELECT 1
-			FROM shares s
+			FROM share_grants s
 			WHERE s.recipient_id = $2 AND s.node_id IN (SELECT id FROM node_parents)
 		);
 	`
@@ -338,10 +522,17 @@ type OutgoingShare struct {
 	RecipientUsername string `json:"recipient_username"`
 }
 
-func (q *Queries) GetOutgoingShares(ctx context.Context, sharerID int64, limit int, offset int) ([]OutgoingShare, error) {
+// OutgoingShareFilter narrows a sharer's shares to one recipient and/or one
+// permission level. Nil fields match every share.
+type OutgoingShareFilter struct {
+	RecipientUsername *string
+	Permissions       *string
+}
+
+func (q *Queries) GetOutgoingShares(ctx context.Context, sharerID int64, filter OutgoingShareFilter, limit int, offset int) ([]OutgoingShare, error) {
 	query := `
 		SELECT 
-			s.id, s.node_id, s.sharer_id, s.recipient_id, s.permissions, s.shared_at,
+			s.id, s.node_id, s.sharer_id, s.recipient_id, s.permissions, s.message, s.pinned_version, s.shared_at,
 			n.name AS node_name,
 			n.node_type AS node_type,
 			u.username AS recipient_username
@@ -349,9 +540,11 @@ func (q *Queries) GetOutgoingShares(ctx context.Context, sharerID int64, limit i
 		JOIN nodes n ON s.node_id = n.id
 		JOIN users u ON s.recipient_id = u.id
 		WHERE s.sharer_id = $1
-		ORDER BY s.shared_at DESC LIMIT $2 OFFSET $3
+		  AND ($2::text IS NULL OR u.username = $2)
+		  AND ($3::text IS NULL OR s.permissions = $3)
+		ORDER BY s.shared_at DESC LIMIT $4 OFFSET $5
 	`
-	rows, err := q.db.Query(ctx, query, sharerID, limit, offset)
+	rows, err := q.db.Query(ctx, query, sharerID, filter.RecipientUsername, filter.Permissions, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -361,7 +554,7 @@ func (q *Queries) GetOutgoingShares(ctx context.Context, sharerID int64, limit i
 	for rows.Next() {
 		var share OutgoingShare
 		err := rows.Scan(
-			&share.ID, &share.NodeID, &share.SharerID, &share.RecipientID, &share.Permissions, &share.SharedAt,
+			&share.ID, &share.NodeID, &share.SharerID, &share.RecipientID, &share.Permissions, &share.Message, &share.PinnedVersion, &share.SharedAt,
 			&share.NodeName, &share.NodeType, &share.RecipientUsername,
 		)
 		if err != nil {
@@ -389,7 +582,7 @@ func (q *Queries) DeleteShare(ctx context.Context, shareID int64, sharerID int64
 
 func (q *Queries) GetShareByID(ctx context.Context, shareID int64, sharerID int64) (*models.Share, error) {
 	query := `
-		SELECT id, node_id, sharer_id, recipient_id, permissions, shared_at
+		SELECT id, node_id, sharer_id, recipient_id, permissions, message, pinned_version, shared_at
 		FROM shares
 		WHERE id = $1 AND sharer_id = $2
 	`
@@ -400,6 +593,36 @@ func (q *Queries) GetShareByID(ctx context.Context, shareID int64, sharerID int6
 		&share.SharerID,
 		&share.RecipientID,
 		&share.Permissions,
+		&share.Message,
+		&share.PinnedVersion,
+		&share.SharedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+// UpdateSharePermissions changes the permissions of a share made by the
+// sharer, keeping its shared_at. It returns nil when there is no such share.
+func (q *Queries) UpdateSharePermissions(ctx context.Context, shareID int64, sharerID int64, permissions string) (*models.Share, error) {
+	query := `
+		UPDATE shares SET permissions = $3
+		WHERE id = $1 AND sharer_id = $2
+		RETURNING id, node_id, sharer_id, recipient_id, permissions, message, pinned_version, shared_at
+	`
+	var share models.Share
+	err := q.db.QueryRow(ctx, query, shareID, sharerID, permissions).Scan(
+		&share.ID,
+		&share.NodeID,
+		&share.SharerID,
+		&share.RecipientID,
+		&share.Permissions,
+		&share.Message,
+		&share.PinnedVersion,
 		&share.SharedAt,
 	)
 	if err != nil {
@@ -421,13 +644,21 @@ type CreateNodeParams struct {
 	NodeType  string
 	SizeBytes *int64
 	MimeType  *string
+	// StorageBackend is where the file's content is stored; empty means the
+	// local storage.
+	StorageBackend string
+	// ContentSHA256 is the hex SHA-256 checksum of a file's content.
+	ContentSHA256 *string
+	// StorageKey is the shared content blob holding a deduplicated file's
+	// content; nil means the content is stored under the node ID.
+	StorageKey *string
 }
 
 func (q *Queries) CreateNode(ctx context.Context, arg CreateNodeParams) (*models.Node, error) {
 	query := `
-		INSERT INTO nodes (id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at, original_parent_id
+		INSERT INTO nodes (id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, storage_backend, content_sha256, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(NULLIF($10, ''), 'local'), $11, $12)
+		RETURNING id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at, original_parent_id, content_sha256
 	`
 	now := time.Now()
 
@@ -441,6 +672,9 @@ func (q *Queries) CreateNode(ctx context.Context, arg CreateNodeParams) (*models
 		arg.MimeType,
 		now,
 		now,
+		arg.StorageBackend,
+		arg.ContentSHA256,
+		arg.StorageKey,
 	)
 
 	var node models.Node
@@ -456,6 +690,7 @@ func (q *Queries) CreateNode(ctx context.Context, arg CreateNodeParams) (*models
 		&node.ModifiedAt,
 		&node.DeletedAt,
 		&node.OriginalParentID,
+		&node.ContentSHA256,
 	)
 	if err != nil {
 		return nil, err
@@ -470,14 +705,14 @@ func (q *Queries) GetNodesByParentID(ctx context.Context, ownerID int64, parentI
 	var err error
 
 	if parentID == nil {
-		query = `SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at 
+		query = `SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at, content_sha256
 				 FROM nodes 
 				 WHERE owner_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
 				 ORDER BY node_type DESC, name
 				 LIMIT $2 OFFSET $3`
 		rows, err = q.db.Query(ctx, query, ownerID, limit, offset)
 	} else {
-		query = `SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at 
+		query = `SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at, content_sha256
 				 FROM nodes 
 				 WHERE owner_id = $1 AND parent_id = $2 AND deleted_at IS NULL
 				 ORDER BY node_type DESC, name
@@ -501,6 +736,7 @@ func (q *Queries) GetNodesByParentID(ctx context.Context, ownerID int64, parentI
 			&node.MimeType,
 			&node.CreatedAt,
 			&node.ModifiedAt,
+			&node.ContentSHA256,
 		)
 		if err != nil {
 			return nil, err
@@ -531,7 +767,7 @@ func (q *Queries) NodeExists(ctx context.Context, id string) (bool, error) {
 
 func (q *Queries) GetNodeByID(ctx context.Context, id string, ownerID int64) (*models.Node, error) {
 	query := `
-		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, content_sha256
 		FROM nodes
 		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL
 	`
@@ -547,6 +783,7 @@ func (q *Queries) GetNodeByID(ctx context.Context, id string, ownerID int64) (*m
 		&node.MimeType,
 		&node.CreatedAt,
 		&node.ModifiedAt,
+		&node.ContentSHA256,
 	)
 
 	if err != nil {
@@ -560,6 +797,12 @@ func (q *Queries) GetNodeByID(ctx context.Context, id string, ownerID int64) (*m
 }
 
 func (q *Queries) MoveNodeToTrash(ctx context.Context, id string, ownerID int64) (bool, error) {
+	return q.MoveNodeToTrashInBatch(ctx, id, ownerID, uuid.New())
+}
+
+// MoveNodeToTrashInBatch trashes a node with its subtree, recording batchID on
+// every trashed node so the whole deletion can be listed and restored together.
+func (q *Queries) MoveNodeToTrashInBatch(ctx context.Context, id string, ownerID int64, batchID uuid.UUID) (bool, error) {
 	query := `
 		WITH RECURSIVE nodes_to_delete AS (
 			SELECT n.id
@@ -576,12 +819,13 @@ func (q *Queries) MoveNodeToTrash(ctx context.Context, id string, ownerID int64)
 		SET 
 			deleted_at = $3,
 			original_parent_id = parent_id,
-			parent_id = NULL
+			parent_id = NULL,
+			deletion_batch_id = $4
 		WHERE id IN (SELECT id FROM nodes_to_delete)
 	`
 
 	now := time.Now()
-	res, err := q.db.Exec(ctx, query, id, ownerID, now)
+	res, err := q.db.Exec(ctx, query, id, ownerID, now, batchID)
 	if err != nil {
 		return false, err
 	}
@@ -673,14 +917,16 @@ func (q *Queries) MoveNode(ctx context.Context, id string, ownerID int64, newPar
 	return res.RowsAffected() > 0, nil
 }
 
-func (q *Queries) ListTrash(ctx context.Context, ownerID int64, limit int, offset int) ([]models.Node, error) {
+// ListTrash lists the user's trashed nodes, optionally only those trashed in
+// the given deletion batch.
+func (q *Queries) ListTrash(ctx context.Context, ownerID int64, batchID *uuid.UUID, limit int, offset int) ([]models.Node, error) {
 	query := `
-		SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at
+		SELECT id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at, deletion_batch_id
 		FROM nodes
-		WHERE owner_id = $1 AND deleted_at IS NOT NULL
-		ORDER BY deleted_at DESC LIMIT $2 OFFSET $3
+		WHERE owner_id = $1 AND deleted_at IS NOT NULL AND ($2::uuid IS NULL OR deletion_batch_id = $2)
+		ORDER BY deleted_at DESC LIMIT $3 OFFSET $4
 	`
-	rows, err := q.db.Query(ctx, query, ownerID, limit, offset)
+	rows, err := q.db.Query(ctx, query, ownerID, batchID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -698,6 +944,7 @@ func (q *Queries) ListTrash(ctx context.Context, ownerID int64, limit int, offse
 			&node.CreatedAt,
 			&node.ModifiedAt,
 			&node.DeletedAt,
+			&node.DeletionBatchID,
 		)
 		if err != nil {
 			return nil, err
@@ -723,7 +970,8 @@ func (q *Queries) RestoreNode(ctx context.Context, id string, ownerID int64) (bo
 		SET 
 			deleted_at = NULL,
 			parent_id = original_parent_id,
-			original_parent_id = NULL
+			original_parent_id = NULL,
+			deletion_batch_id = NULL
 		WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL
 	`
 	res, err := q.db.Exec(ctx, query, id, ownerID)
@@ -740,14 +988,14 @@ func (q *Queries) RestoreNode(ctx context.Context, id string, ownerID int64) (bo
 
 func (q *Queries) GetNodeIfAccessible(ctx context.Context, nodeID string, userID int64) (*models.Node, error) {
 	query := `
-		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, content_sha256
 		FROM nodes
 		WHERE id = $1 AND deleted_at IS NULL
 	`
 	var node models.Node
 	err := q.db.QueryRow(ctx, query, nodeID).Scan(
 		&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType,
-		&node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt,
+		&node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt, &node.ContentSHA256,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -780,7 +1028,10 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (*mode
 			display_name, 
 			created_at, 
 			storage_quota_bytes, 
-			storage_used_bytes
+			storage_used_bytes,
+			is_admin,
+			ldap_dn,
+			disabled_at
 		FROM users
 		WHERE username = $1
 	`
@@ -794,6 +1045,9 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (*mode
 		&user.CreatedAt,
 		&user.StorageQuotaBytes,
 		&user.StorageUsedBytes,
+		&user.IsAdmin,
+		&user.LDAPDN,
+		&user.DisabledAt,
 	)
 
 	if err != nil {
@@ -839,22 +1093,139 @@ type CreateSessionParams struct {
 	UserAgent    string
 	ClientIP     string
 	ExpiresAt    time.Time
+	// FamilyID links sessions created by rotating each other's refresh
+	// tokens. It defaults to ID for a session started by a login.
+	FamilyID uuid.UUID
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
 	query := `
-		INSERT INTO sessions (id, user_id, refresh_token, user_agent, client_ip, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO sessions (id, user_id, refresh_token, user_agent, client_ip, expires_at, family_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
-	_, err := q.db.Exec(ctx, query, arg.ID, arg.UserID, arg.RefreshToken, arg.UserAgent, arg.ClientIP, arg.ExpiresAt)
+	familyID := arg.FamilyID
+	if familyID == uuid.Nil {
+		familyID = arg.ID
+	}
+	_, err := q.db.Exec(ctx, query, arg.ID, arg.UserID, arg.RefreshToken, arg.UserAgent, arg.ClientIP, arg.ExpiresAt, familyID)
 	return err
 }
 
+type SessionFamily struct {
+	FamilyID uuid.UUID
+	UserID   int64
+}
+
+// ConsumeRefreshToken deletes the session owning a valid refresh token and
+// remembers the token's hash as rotated, so a later reuse can be detected.
+// It returns nil if the token does not belong to an active session.
+func (q *Queries) ConsumeRefreshToken(ctx context.Context, refreshToken string, tokenHash string) (*SessionFamily, error) {
+	query := `
+		WITH consumed AS (
+			DELETE FROM sessions
+			WHERE refresh_token = $1 AND expires_at > NOW()
+			RETURNING family_id, user_id, expires_at
+		)
+		INSERT INTO rotated_refresh_tokens (token_hash, family_id, user_id, expires_at)
+		SELECT $2, family_id, user_id, expires_at FROM consumed
+		RETURNING family_id, user_id
+	`
+	var family SessionFamily
+	err := q.db.QueryRow(ctx, query, refreshToken, tokenHash).Scan(&family.FamilyID, &family.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &family, nil
+}
+
+// FindRotatedRefreshToken returns the session family of an already rotated,
+// not yet expired refresh token, or nil if the hash is unknown.
+func (q *Queries) FindRotatedRefreshToken(ctx context.Context, tokenHash string) (*SessionFamily, error) {
+	query := `
+		SELECT family_id, user_id
+		FROM rotated_refresh_tokens
+		WHERE token_hash = $1 AND expires_at > NOW()
+	`
+	var family SessionFamily
+	err := q.db.QueryRow(ctx, query, tokenHash).Scan(&family.FamilyID, &family.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &family, nil
+}
+
+// RevokeSessionFamily deletes every session of a family together with its
+// rotated tokens and returns the number of sessions that were still active.
+func (q *Queries) RevokeSessionFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM sessions WHERE family_id = $1`, familyID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := q.db.Exec(ctx, `DELETE FROM rotated_refresh_tokens WHERE family_id = $1`, familyID); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// IsSessionFamilyActive reports whether a session family still has a
+// non-expired session, i.e. whether its access tokens may be accepted.
+func (q *Queries) IsSessionFamilyActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE family_id = $1 AND expires_at > NOW())`
+	var active bool
+	err := q.db.QueryRow(ctx, query, familyID).Scan(&active)
+	return active, err
+}
+
+// TouchSessionFamily works like IsSessionFamilyActive and additionally moves
+// the family's last_used_at to now when it is older than staleBefore, so
+// activity is written at most once per interval instead of on every request.
+func (q *Queries) TouchSessionFamily(ctx context.Context, familyID uuid.UUID, staleBefore time.Time) (bool, error) {
+	query := `
+		WITH touched AS (
+			UPDATE sessions SET last_used_at = NOW()
+			WHERE family_id = $1 AND expires_at > NOW() AND last_used_at < $2
+		)
+		SELECT EXISTS (SELECT 1 FROM sessions WHERE family_id = $1 AND expires_at > NOW())
+	`
+	var active bool
+	err := q.db.QueryRow(ctx, query, familyID, staleBefore).Scan(&active)
+	return active, err
+}
+
+// DeleteSessionByRefreshTokenForUser deletes the user's session holding the
+// given refresh token and returns its family, or nil if there is none.
+func (q *Queries) DeleteSessionByRefreshTokenForUser(ctx context.Context, refreshToken string, userID int64) (*uuid.UUID, error) {
+	query := `DELETE FROM sessions WHERE refresh_token = $1 AND user_id = $2 RETURNING family_id`
+	var familyID uuid.UUID
+	err := q.db.QueryRow(ctx, query, refreshToken, userID).Scan(&familyID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &familyID, nil
+}
+
+func (q *Queries) DeleteExpiredRotatedRefreshTokens(ctx context.Context, now time.Time) (int64, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM rotated_refresh_tokens WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
 func (q *Queries) GetUserByRefreshToken(ctx context.Context, refreshToken string) (*models.User, error) {
 	query := `
 		SELECT 
 			u.id, u.username, u.password_hash, u.display_name, u.created_at, 
-			u.storage_quota_bytes, u.storage_used_bytes
+			u.storage_quota_bytes, u.storage_used_bytes, u.is_admin
 		FROM users u
 		JOIN sessions s ON u.id = s.user_id
 		WHERE s.refresh_token = $1 AND s.expires_at > NOW()
@@ -862,7 +1233,7 @@ func (q *Queries) GetUserByRefreshToken(ctx context.Context, refreshToken string
 	var user models.User
 	err := q.db.QueryRow(ctx, query, refreshToken).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName, &user.CreatedAt,
-		&user.StorageQuotaBytes, &user.StorageUsedBytes,
+		&user.StorageQuotaBytes, &user.StorageUsedBytes, &user.IsAdmin,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -875,7 +1246,7 @@ func (q *Queries) GetUserByRefreshToken(ctx context.Context, refreshToken string
 
 func (q *Queries) ListSessionsForUser(ctx context.Context, userID int64) ([]models.Session, error) {
 	query := `
-		SELECT id, user_agent, client_ip, expires_at, created_at
+		SELECT id, user_agent, client_ip, expires_at, created_at, last_used_at
 		FROM sessions
 		WHERE user_id = $1 AND expires_at > NOW()
 		ORDER BY created_at DESC
@@ -895,6 +1266,7 @@ func (q *Queries) ListSessionsForUser(ctx context.Context, userID int64) ([]mode
 			&session.ClientIP,
 			&session.ExpiresAt,
 			&session.CreatedAt,
+			&session.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -958,8 +1330,8 @@ func (q *Queries) CheckWritePermission(ctx context.Context, userID int64, parent
 			LIMIT 1
 		) OR EXISTS (
 			SELECT 1
-			FROM shares s
-			WHERE s.recipient_id = $2 AND s.permissions = 'write' AND s.node_id IN (SELECT id FROM node_parents)
+			FROM share_grants s
+			WHERE s.recipient_id = $2 AND s.permissions IN ('write', 'manage') AND s.node_id IN (SELECT id FROM node_parents)
 			LIMIT 1
 		)
 	`
@@ -968,18 +1340,49 @@ func (q *Queries) CheckWritePermission(ctx context.Context, userID int64, parent
 	return hasPermission, err
 }
 
+// CheckManagePermission reports whether the user owns the node or was given
+// manage permission on it or one of its ancestors. Manage permission lets a
+// recipient share the node further and delete nodes inside the shared tree.
+func (q *Queries) CheckManagePermission(ctx context.Context, userID int64, nodeID string) (bool, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id, owner_id
+			FROM nodes
+			WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id, n.owner_id
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		)
+		SELECT EXISTS (
+			SELECT 1 FROM node_parents WHERE owner_id = $2
+			LIMIT 1
+		) OR EXISTS (
+			SELECT 1
+			FROM share_grants s
+			WHERE s.recipient_id = $2 AND s.permissions = 'manage' AND s.node_id IN (SELECT id FROM node_parents)
+			LIMIT 1
+		)
+	`
+	var hasPermission bool
+	err := q.db.QueryRow(ctx, query, nodeID, userID).Scan(&hasPermission)
+	return hasPermission, err
+}
+
 func (q *Queries) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
 	query := `
 		SELECT 
 			id, username, password_hash, display_name, created_at, 
-			storage_quota_bytes, storage_used_bytes
+			storage_quota_bytes, storage_used_bytes, is_admin, ldap_dn, disabled_at
 		FROM users
 		WHERE id = $1
 	`
 	var user models.User
 	err := q.db.QueryRow(ctx, query, id).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName, &user.CreatedAt,
-		&user.StorageQuotaBytes, &user.StorageUsedBytes,
+		&user.StorageQuotaBytes, &user.StorageUsedBytes, &user.IsAdmin, &user.LDAPDN, &user.DisabledAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -989,3 +1392,5137 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (*models.User, erro
 	}
 	return &user, nil
 }
+
+type LogAccessParams struct {
+	UserID    int64
+	NodeID    string
+	OwnerID   int64
+	Action    string
+	ClientIP  string
+	UserAgent string
+}
+
+func (q *Queries) LogAccess(ctx context.Context, arg LogAccessParams) error {
+	query := `
+		INSERT INTO access_log (user_id, node_id, owner_id, action, client_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := q.db.Exec(ctx, query, arg.UserID, arg.NodeID, arg.OwnerID, arg.Action, arg.ClientIP, arg.UserAgent)
+	return err
+}
+
+// LogAccessMany records the same access to several nodes in one insert.
+func (q *Queries) LogAccessMany(ctx context.Context, arg LogAccessParams, nodeIDs []string) error {
+	query := `
+		INSERT INTO access_log (user_id, node_id, owner_id, action, client_ip, user_agent)
+		SELECT $1, node_id, $3, $4, $5, $6 FROM unnest($2::varchar[]) AS node_id
+	`
+	_, err := q.db.Exec(ctx, query, arg.UserID, nodeIDs, arg.OwnerID, arg.Action, arg.ClientIP, arg.UserAgent)
+	return err
+}
+
+type AccessLogEntry struct {
+	ID         int64     `json:"id"`
+	UserID     *int64    `json:"user_id"`
+	Username   *string   `json:"username"`
+	NodeID     string    `json:"node_id"`
+	Action     string    `json:"action"`
+	ClientIP   *string   `json:"client_ip"`
+	UserAgent  *string   `json:"user_agent"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+func (q *Queries) ListAccessLogForNode(ctx context.Context, nodeID string, ownerID int64, limit int, offset int) ([]AccessLogEntry, error) {
+	query := `
+		SELECT a.id, a.user_id, u.username, a.node_id, a.action, a.client_ip, a.user_agent, a.accessed_at
+		FROM access_log a
+		LEFT JOIN users u ON a.user_id = u.id
+		WHERE a.node_id = $1 AND a.owner_id = $2
+		ORDER BY a.accessed_at DESC LIMIT $3 OFFSET $4
+	`
+	rows, err := q.db.Query(ctx, query, nodeID, ownerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AccessLogEntry
+	for rows.Next() {
+		var entry AccessLogEntry
+		err := rows.Scan(
+			&entry.ID, &entry.UserID, &entry.Username, &entry.NodeID, &entry.Action,
+			&entry.ClientIP, &entry.UserAgent, &entry.AccessedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		return []AccessLogEntry{}, nil
+	}
+
+	return entries, nil
+}
+
+func (q *Queries) DeleteAccessLogsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM access_log WHERE accessed_at < $1`
+	res, err := q.db.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+type TrashSummary struct {
+	TotalItems     int64      `json:"total_items"`
+	TotalBytes     int64      `json:"total_bytes"`
+	OldestDeletion *time.Time `json:"oldest_deletion"`
+}
+
+func (q *Queries) GetTrashSummary(ctx context.Context, ownerID int64) (*TrashSummary, error) {
+	query := `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(size_bytes) FILTER (WHERE node_type = 'file'), 0),
+			MIN(deleted_at)
+		FROM nodes
+		WHERE owner_id = $1 AND deleted_at IS NOT NULL
+	`
+	var summary TrashSummary
+	err := q.db.QueryRow(ctx, query, ownerID).Scan(
+		&summary.TotalItems,
+		&summary.TotalBytes,
+		&summary.OldestDeletion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+type DerivedArtifact struct {
+	ID          int64     `json:"id"`
+	NodeID      string    `json:"node_id"`
+	ContentHash string    `json:"content_hash"`
+	Kind        string    `json:"kind"`
+	StorageKey  string    `json:"-"`
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RegisterDerivedArtifactParams struct {
+	NodeID      string
+	ContentHash string
+	Kind        string
+	StorageKey  string
+	SizeBytes   int64
+}
+
+// RegisterDerivedArtifact stores a reference to a blob derived from a node's
+// content (thumbnail, preview, extracted text). If an artifact of the same kind
+// already exists for this content, the previous storage key is returned so the
+// caller can delete the replaced blob.
+func (q *Queries) RegisterDerivedArtifact(ctx context.Context, arg RegisterDerivedArtifactParams) (*string, error) {
+	var previousKey *string
+	err := q.db.QueryRow(ctx,
+		`SELECT storage_key FROM derived_artifacts WHERE node_id = $1 AND content_hash = $2 AND kind = $3`,
+		arg.NodeID, arg.ContentHash, arg.Kind,
+	).Scan(&previousKey)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO derived_artifacts (node_id, content_hash, kind, storage_key, size_bytes)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (node_id, content_hash, kind)
+		DO UPDATE SET storage_key = EXCLUDED.storage_key, size_bytes = EXCLUDED.size_bytes, created_at = NOW()
+	`
+	_, err = q.db.Exec(ctx, query, arg.NodeID, arg.ContentHash, arg.Kind, arg.StorageKey, arg.SizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return previousKey, nil
+}
+
+func (q *Queries) GetDerivedArtifact(ctx context.Context, nodeID string, contentHash string, kind string) (*DerivedArtifact, error) {
+	query := `
+		SELECT id, node_id, content_hash, kind, storage_key, size_bytes, created_at
+		FROM derived_artifacts
+		WHERE node_id = $1 AND content_hash = $2 AND kind = $3
+	`
+	var artifact DerivedArtifact
+	err := q.db.QueryRow(ctx, query, nodeID, contentHash, kind).Scan(
+		&artifact.ID, &artifact.NodeID, &artifact.ContentHash, &artifact.Kind,
+		&artifact.StorageKey, &artifact.SizeBytes, &artifact.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// DeleteDerivedArtifactsForNodes removes the registry entries of the given
+// nodes and returns the storage keys of the blobs that must be deleted.
+func (q *Queries) DeleteDerivedArtifactsForNodes(ctx context.Context, nodeIDs []string) ([]string, error) {
+	if len(nodeIDs) == 0 {
+		return []string{}, nil
+	}
+	query := `DELETE FROM derived_artifacts WHERE node_id = ANY($1) RETURNING storage_key`
+	return q.collectStrings(ctx, query, nodeIDs)
+}
+
+// InvalidateDerivedArtifacts removes artifacts of a node that were derived from
+// content other than currentContentHash, e.g. after the node's content was replaced.
+func (q *Queries) InvalidateDerivedArtifacts(ctx context.Context, nodeID string, currentContentHash string) ([]string, error) {
+	query := `DELETE FROM derived_artifacts WHERE node_id = $1 AND content_hash <> $2 RETURNING storage_key`
+	return q.collectStrings(ctx, query, nodeID, currentContentHash)
+}
+
+func (q *Queries) collectStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+var ErrUploadSessionNotFound = errors.New("upload session not found or expired")
+
+type CreateUploadSessionParams struct {
+	ID             uuid.UUID
+	UserID         int64
+	OwnerID        int64
+	ParentID       *string
+	FileName       string
+	MimeType       *string
+	TotalSize      int64
+	ExpectedSHA256 *string
+	TempLocation   string
+	ExpiresAt      time.Time
+}
+
+func (q *Queries) CreateUploadSession(ctx context.Context, arg CreateUploadSessionParams) (*models.UploadSession, error) {
+	query := `
+		INSERT INTO upload_sessions (id, user_id, owner_id, parent_id, file_name, mime_type, total_size, expected_sha256, temp_location, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, user_id, owner_id, parent_id, file_name, mime_type, total_size, expected_sha256, temp_location, created_at, updated_at, expires_at
+	`
+	var session models.UploadSession
+	err := q.db.QueryRow(ctx, query,
+		arg.ID, arg.UserID, arg.OwnerID, arg.ParentID, arg.FileName, arg.MimeType, arg.TotalSize, arg.ExpectedSHA256, arg.TempLocation, arg.ExpiresAt,
+	).Scan(
+		&session.ID, &session.UserID, &session.OwnerID, &session.ParentID, &session.FileName, &session.MimeType,
+		&session.TotalSize, &session.ExpectedSHA256, &session.TempLocation, &session.CreatedAt, &session.UpdatedAt, &session.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.Chunks = []models.UploadChunk{}
+	return &session, nil
+}
+
+// GetUploadSession returns an active upload session of the given uploader
+// together with the byte ranges received so far.
+func (q *Queries) GetUploadSession(ctx context.Context, id uuid.UUID, userID int64) (*models.UploadSession, error) {
+	query := `
+		SELECT id, user_id, owner_id, parent_id, file_name, mime_type, total_size, expected_sha256, temp_location, created_at, updated_at, expires_at
+		FROM upload_sessions
+		WHERE id = $1 AND user_id = $2 AND expires_at > NOW()
+	`
+	var session models.UploadSession
+	err := q.db.QueryRow(ctx, query, id, userID).Scan(
+		&session.ID, &session.UserID, &session.OwnerID, &session.ParentID, &session.FileName, &session.MimeType,
+		&session.TotalSize, &session.ExpectedSHA256, &session.TempLocation, &session.CreatedAt, &session.UpdatedAt, &session.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	session.Chunks, err = q.ListUploadChunks(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range session.Chunks {
+		session.ReceivedBytes += chunk.SizeBytes
+	}
+
+	return &session, nil
+}
+
+func (q *Queries) ListUploadChunks(ctx context.Context, sessionID uuid.UUID) ([]models.UploadChunk, error) {
+	query := `
+		SELECT offset_bytes, size_bytes, checksum
+		FROM upload_chunks
+		WHERE session_id = $1
+		ORDER BY offset_bytes
+	`
+	rows, err := q.db.Query(ctx, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []models.UploadChunk{}
+	for rows.Next() {
+		var chunk models.UploadChunk
+		if err := rows.Scan(&chunk.Offset, &chunk.SizeBytes, &chunk.Checksum); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// RecordUploadChunk marks a byte range as received and extends the session's
+// expiry, so that actively progressing uploads are never reaped by the janitor.
+// The checksum is the client-declared SHA-256 of the chunk, verified when the
+// upload is assembled.
+func (q *Queries) RecordUploadChunk(ctx context.Context, sessionID uuid.UUID, offset int64, size int64, checksum *string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO upload_chunks (session_id, offset_bytes, size_bytes, checksum)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (session_id, offset_bytes)
+		DO UPDATE SET size_bytes = EXCLUDED.size_bytes, checksum = EXCLUDED.checksum, received_at = NOW()
+	`
+	if _, err := q.db.Exec(ctx, query, sessionID, offset, size, checksum); err != nil {
+		return err
+	}
+
+	res, err := q.db.Exec(ctx, `UPDATE upload_sessions SET updated_at = NOW(), expires_at = $2 WHERE id = $1`, sessionID, expiresAt)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUploadSessionNotFound
+	}
+	return nil
+}
+
+func (q *Queries) DeleteUploadSession(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, `DELETE FROM upload_sessions WHERE id = $1`, id)
+	return err
+}
+
+// DeleteExpiredUploadSessions removes abandoned sessions and returns the
+// temporary locations whose chunks must be removed from storage.
+func (q *Queries) DeleteExpiredUploadSessions(ctx context.Context, now time.Time) ([]string, error) {
+	query := `DELETE FROM upload_sessions WHERE expires_at <= $1 RETURNING temp_location`
+	return q.collectStrings(ctx, query, now)
+}
+
+func (q *Queries) UploadSessionExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	var exists bool
+	err := q.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM upload_sessions WHERE id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+// UpdateNodeContent records new content of a file. contentSHA256 replaces the
+// stored checksum; nil clears it.
+func (q *Queries) UpdateNodeContent(ctx context.Context, id string, ownerID int64, sizeBytes int64, mimeType *string, contentSHA256 *string) (*models.Node, error) {
+	query := `
+		UPDATE nodes
+		SET size_bytes = $3, mime_type = COALESCE($4, mime_type), content_sha256 = $5, modified_at = NOW()
+		WHERE id = $1 AND owner_id = $2 AND node_type = 'file' AND deleted_at IS NULL
+		RETURNING id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, content_sha256
+	`
+	var node models.Node
+	err := q.db.QueryRow(ctx, query, id, ownerID, sizeBytes, mimeType, contentSHA256).Scan(
+		&node.ID,
+		&node.OwnerID,
+		&node.ParentID,
+		&node.Name,
+		&node.NodeType,
+		&node.SizeBytes,
+		&node.MimeType,
+		&node.CreatedAt,
+		&node.ModifiedAt,
+		&node.ContentSHA256,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+var nodeChangeEventTypes = []string{"node_created", "node_renamed", "node_moved", "node_trashed", "node_restored"}
+
+// ListNodeChangeEventsSince returns structural node events (creations,
+// renames, moves, trashing and restores) from a user's journal, oldest first.
+// Payload holds the inner event payload only.
+func (q *Queries) ListNodeChangeEventsSince(ctx context.Context, userID int64, sinceID int64, limit int) ([]Event, error) {
+	query := `
+		SELECT id, event_type, event_time, payload->'payload'
+		FROM event_journal
+		WHERE user_id = $1 AND id > $2 AND event_type = ANY($3)
+		ORDER BY id ASC
+		LIMIT $4
+	`
+	rows, err := q.db.Query(ctx, query, userID, sinceID, nodeChangeEventTypes, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.EventType, &event.EventTime, &event.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (q *Queries) GetLatestEventID(ctx context.Context, userID int64) (int64, error) {
+	var latestID int64
+	err := q.db.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM event_journal WHERE user_id = $1`, userID).Scan(&latestID)
+	return latestID, err
+}
+
+func (q *Queries) ListChildrenByIDs(ctx context.Context, parentID string, ids []string) ([]models.Node, error) {
+	query := `
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at
+		FROM nodes
+		WHERE parent_id = $1 AND id = ANY($2) AND deleted_at IS NULL
+		ORDER BY node_type DESC, name ASC
+	`
+	rows, err := q.db.Query(ctx, query, parentID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(
+			&node.ID,
+			&node.OwnerID,
+			&node.ParentID,
+			&node.Name,
+			&node.NodeType,
+			&node.SizeBytes,
+			&node.MimeType,
+			&node.CreatedAt,
+			&node.ModifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+var ErrInvalidDigestFrequency = errors.New("digest frequency must be 'daily' or 'weekly'")
+
+// WatchNode subscribes a user to changes in a folder they can access. Watching
+// an already watched folder updates its digest frequency.
+func (q *Queries) WatchNode(ctx context.Context, userID int64, nodeID string, frequency string) error {
+	if frequency != "daily" && frequency != "weekly" {
+		return ErrInvalidDigestFrequency
+	}
+
+	node, err := q.GetNodeIfAccessible(ctx, nodeID, userID)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.NodeType != "folder" {
+		return ErrNodeNotFound
+	}
+
+	query := `
+		INSERT INTO node_watches (user_id, node_id, digest_frequency)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, node_id) DO UPDATE SET digest_frequency = EXCLUDED.digest_frequency
+	`
+	_, err = q.db.Exec(ctx, query, userID, nodeID, frequency)
+	return err
+}
+
+func (q *Queries) UnwatchNode(ctx context.Context, userID int64, nodeID string) (bool, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM node_watches WHERE user_id = $1 AND node_id = $2`, userID, nodeID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// ListWatchersForNodes returns users watching any of the given nodes or their
+// ancestors who can still access them.
+func (q *Queries) ListWatchersForNodes(ctx context.Context, nodeIDs []string) ([]int64, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, owner_id
+			FROM nodes
+			WHERE id = ANY($1)
+
+			UNION
+
+			SELECT n.id, n.parent_id, n.owner_id
+			FROM nodes n
+			JOIN ancestors a ON n.id = a.parent_id
+		)
+		SELECT DISTINCT w.user_id
+		FROM node_watches w
+		JOIN ancestors a ON a.id = w.node_id
+		WHERE w.user_id = a.owner_id
+		   OR EXISTS (
+				SELECT 1 FROM share_grants s
+				WHERE s.recipient_id = w.user_id AND s.node_id IN (SELECT id FROM ancestors)
+		   )
+	`
+	rows, err := q.db.Query(ctx, query, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+type NodeWatch struct {
+	UserID          int64     `json:"user_id"`
+	NodeID          string    `json:"node_id"`
+	NodeName        string    `json:"node_name"`
+	OwnerID         int64     `json:"owner_id"`
+	DigestFrequency string    `json:"digest_frequency"`
+	LastDigestAt    time.Time `json:"last_digest_at"`
+}
+
+// ListDueWatches returns watches whose daily or weekly digest period has
+// elapsed at now.
+func (q *Queries) ListDueWatches(ctx context.Context, now time.Time) ([]NodeWatch, error) {
+	query := `
+		SELECT w.user_id, w.node_id, n.name, n.owner_id, w.digest_frequency, w.last_digest_at
+		FROM node_watches w
+		JOIN nodes n ON n.id = w.node_id
+		WHERE n.deleted_at IS NULL
+		  AND w.last_digest_at <= $1 - CASE w.digest_frequency WHEN 'weekly' THEN INTERVAL '7 days' ELSE INTERVAL '1 day' END
+		ORDER BY w.last_digest_at
+	`
+	rows, err := q.db.Query(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watches := []NodeWatch{}
+	for rows.Next() {
+		var watch NodeWatch
+		if err := rows.Scan(&watch.UserID, &watch.NodeID, &watch.NodeName, &watch.OwnerID, &watch.DigestFrequency, &watch.LastDigestAt); err != nil {
+			return nil, err
+		}
+		watches = append(watches, watch)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return watches, nil
+}
+
+func (q *Queries) MarkWatchDigested(ctx context.Context, userID int64, nodeID string, at time.Time) error {
+	_, err := q.db.Exec(ctx, `UPDATE node_watches SET last_digest_at = $3 WHERE user_id = $1 AND node_id = $2`, userID, nodeID, at)
+	return err
+}
+
+// ListSubtreeEventsSince returns node events from the owner's journal that
+// touched the folder rootID or anything below it within (since, until].
+func (q *Queries) ListSubtreeEventsSince(ctx context.Context, ownerID int64, rootID string, since time.Time, until time.Time, limit int) ([]Event, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM nodes WHERE id = $2
+
+			UNION ALL
+
+			SELECT n.id
+			FROM nodes n
+			JOIN subtree s ON n.parent_id = s.id
+		)
+		SELECT id, event_type, event_time, payload->'payload'
+		FROM event_journal
+		WHERE user_id = $1 AND event_time > $3 AND event_time <= $4
+		  AND event_type = ANY($5)
+		  AND (
+			payload->'payload'->>'id' IN (SELECT id FROM subtree)
+			OR payload->'payload'->>'parent_id' IN (SELECT id FROM subtree)
+			OR payload->'payload'->>'old_parent_id' IN (SELECT id FROM subtree)
+			OR payload->'payload'->>'new_parent_id' IN (SELECT id FROM subtree)
+		  )
+		ORDER BY id ASC
+		LIMIT $6
+	`
+	eventTypes := append([]string{"node_updated"}, nodeChangeEventTypes...)
+	rows, err := q.db.Query(ctx, query, ownerID, rootID, since, until, eventTypes, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.EventType, &event.EventTime, &event.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+type RemovedFavorite struct {
+	UserID int64
+	NodeID string
+}
+
+// DeleteInaccessibleFavorites removes favorites of nodes the user neither owns
+// nor can reach through a share anymore, and returns what was removed.
+func (q *Queries) DeleteInaccessibleFavorites(ctx context.Context) ([]RemovedFavorite, error) {
+	query := `
+		WITH RECURSIVE favorite_ancestors AS (
+			SELECT f.user_id, f.node_id, n.id AS ancestor_id, n.parent_id
+			FROM user_favorites f
+			JOIN nodes n ON n.id = f.node_id
+			WHERE n.owner_id <> f.user_id
+
+			UNION ALL
+
+			SELECT fa.user_id, fa.node_id, p.id, p.parent_id
+			FROM favorite_ancestors fa
+			JOIN nodes p ON p.id = fa.parent_id
+		)
+		DELETE FROM user_favorites f
+		WHERE (f.user_id, f.node_id) IN (SELECT user_id, node_id FROM favorite_ancestors)
+		  AND NOT EXISTS (
+			SELECT 1
+			FROM favorite_ancestors fa
+			JOIN share_grants s ON s.node_id = fa.ancestor_id AND s.recipient_id = fa.user_id
+			WHERE fa.user_id = f.user_id AND fa.node_id = f.node_id
+		  )
+		RETURNING f.user_id, f.node_id
+	`
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	removed := []RemovedFavorite{}
+	for rows.Next() {
+		var favorite RemovedFavorite
+		if err := rows.Scan(&favorite.UserID, &favorite.NodeID); err != nil {
+			return nil, err
+		}
+		removed = append(removed, favorite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+const subtreeCTE = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM nodes WHERE id = $2
+
+		UNION ALL
+
+		SELECT n.id
+		FROM nodes n
+		JOIN subtree s ON n.parent_id = s.id
+	)
+`
+
+// ListWatchesInSubtree returns the nodes within rootID's subtree (inclusive)
+// that the user is watching.
+func (q *Queries) ListWatchesInSubtree(ctx context.Context, userID int64, rootID string) ([]string, error) {
+	query := subtreeCTE + `
+		SELECT node_id FROM node_watches
+		WHERE user_id = $1 AND node_id IN (SELECT id FROM subtree)
+	`
+	return q.collectStrings(ctx, query, userID, rootID)
+}
+
+// ListFavoritesInSubtree returns the nodes within rootID's subtree
+// (inclusive) that the user has marked as favorites.
+func (q *Queries) ListFavoritesInSubtree(ctx context.Context, userID int64, rootID string) ([]string, error) {
+	query := subtreeCTE + `
+		SELECT node_id FROM user_favorites
+		WHERE user_id = $1 AND node_id IN (SELECT id FROM subtree)
+	`
+	return q.collectStrings(ctx, query, userID, rootID)
+}
+
+// ListUploadSessionsInSubtree returns the user's pending upload sessions that
+// target a folder within rootID's subtree (inclusive).
+func (q *Queries) ListUploadSessionsInSubtree(ctx context.Context, userID int64, rootID string) ([]models.UploadSession, error) {
+	query := subtreeCTE + `
+		SELECT id, parent_id, temp_location
+		FROM upload_sessions
+		WHERE user_id = $1 AND parent_id IN (SELECT id FROM subtree)
+	`
+	rows, err := q.db.Query(ctx, query, userID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.UploadSession{}
+	for rows.Next() {
+		var session models.UploadSession
+		if err := rows.Scan(&session.ID, &session.ParentID, &session.TempLocation); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RecoveryFolderName is the root folder that orphaned nodes are moved into.
+const RecoveryFolderName = "Odzyskane"
+
+type OrphanedNode struct {
+	models.Node
+	Issue string `json:"issue"`
+}
+
+// ListOrphanedNodes finds nodes whose place in the tree is inconsistent: active
+// nodes under a trashed parent, under a file or under another user's folder,
+// and trashed nodes whose original parent no longer exists.
+func (q *Queries) ListOrphanedNodes(ctx context.Context, limit int, offset int) ([]OrphanedNode, error) {
+	query := `
+		SELECT n.id, n.owner_id, n.parent_id, n.name, n.node_type, n.size_bytes, n.mime_type,
+			n.created_at, n.modified_at, n.deleted_at, n.original_parent_id,
+			CASE
+				WHEN n.deleted_at IS NOT NULL THEN 'missing_original_parent'
+				WHEN p.deleted_at IS NOT NULL THEN 'parent_trashed'
+				WHEN p.node_type <> 'folder' THEN 'parent_not_folder'
+				ELSE 'owner_mismatch'
+			END AS issue
+		FROM nodes n
+		LEFT JOIN nodes p ON p.id = n.parent_id
+		WHERE (
+			n.deleted_at IS NULL AND p.id IS NOT NULL
+			AND (p.deleted_at IS NOT NULL OR p.node_type <> 'folder' OR p.owner_id <> n.owner_id)
+		) OR (
+			n.deleted_at IS NOT NULL AND n.original_parent_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM nodes o WHERE o.id = n.original_parent_id)
+		)
+		ORDER BY n.owner_id, n.id
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := q.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orphans := []OrphanedNode{}
+	for rows.Next() {
+		var orphan OrphanedNode
+		err := rows.Scan(
+			&orphan.ID, &orphan.OwnerID, &orphan.ParentID, &orphan.Name, &orphan.NodeType,
+			&orphan.SizeBytes, &orphan.MimeType, &orphan.CreatedAt, &orphan.ModifiedAt,
+			&orphan.DeletedAt, &orphan.OriginalParentID, &orphan.Issue,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orphans, nil
+}
+
+// GetOrCreateRecoveryFolder returns the owner's recovery folder in the root,
+// creating it with newID if it does not exist yet. created reports whether it
+// had to be created.
+func (q *Queries) GetOrCreateRecoveryFolder(ctx context.Context, ownerID int64, newID string) (folder *models.Node, created bool, err error) {
+	query := `
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at, original_parent_id
+		FROM nodes
+		WHERE owner_id = $1 AND parent_id IS NULL AND name = $2 AND node_type = 'folder' AND deleted_at IS NULL
+	`
+	var node models.Node
+	err = q.db.QueryRow(ctx, query, ownerID, RecoveryFolderName).Scan(
+		&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes,
+		&node.MimeType, &node.CreatedAt, &node.ModifiedAt, &node.DeletedAt, &node.OriginalParentID,
+	)
+	if err == nil {
+		return &node, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	folder, err = q.CreateNode(ctx, CreateNodeParams{
+		ID:       newID,
+		OwnerID:  ownerID,
+		Name:     RecoveryFolderName,
+		NodeType: "folder",
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return folder, true, nil
+}
+
+// ReparentOrphanedNode moves an active orphan into the recovery folder,
+// suffixing its name with its ID if the name is taken there. For a trashed
+// orphan only the original parent is replaced, so restoring it works again.
+func (q *Queries) ReparentOrphanedNode(ctx context.Context, id string, recoveryFolderID string) (*models.Node, error) {
+	query := `
+		UPDATE nodes n
+		SET
+			parent_id = CASE WHEN n.deleted_at IS NULL THEN $2 ELSE n.parent_id END,
+			original_parent_id = CASE WHEN n.deleted_at IS NULL THEN n.original_parent_id ELSE $2 END,
+			name = CASE
+				WHEN n.deleted_at IS NULL AND EXISTS (
+					SELECT 1 FROM nodes c
+					WHERE c.owner_id = n.owner_id AND c.parent_id = $2 AND c.name = n.name
+				) THEN LEFT(n.name, 230) || ' (' || n.id || ')'
+				ELSE n.name
+			END,
+			modified_at = NOW()
+		WHERE n.id = $1
+		RETURNING id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at, deleted_at, original_parent_id
+	`
+	var node models.Node
+	err := q.db.QueryRow(ctx, query, id, recoveryFolderID).Scan(
+		&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes,
+		&node.MimeType, &node.CreatedAt, &node.ModifiedAt, &node.DeletedAt, &node.OriginalParentID,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateNodeName
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetNodeDepth returns how deep a node sits in the tree, counting root-level
+// nodes as depth 1.
+func (q *Queries) GetNodeDepth(ctx context.Context, nodeID string) (int, error) {
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, 1 AS depth FROM nodes WHERE id = $1
+
+			UNION ALL
+
+			SELECT p.id, p.parent_id, a.depth + 1
+			FROM nodes p
+			JOIN ancestors a ON p.id = a.parent_id
+		)
+		SELECT COALESCE(MAX(depth), 0) FROM ancestors
+	`
+	var depth int
+	err := q.db.QueryRow(ctx, query, nodeID).Scan(&depth)
+	return depth, err
+}
+
+// GetSubtreeHeight returns the number of levels in a node's subtree, the node
+// itself included, so a file or an empty folder has height 1.
+func (q *Queries) GetSubtreeHeight(ctx context.Context, nodeID string) (int, error) {
+	query := `
+		WITH RECURSIVE descendants AS (
+			SELECT id, 1 AS level FROM nodes WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, d.level + 1
+			FROM nodes n
+			JOIN descendants d ON n.parent_id = d.id
+			WHERE n.deleted_at IS NULL
+		)
+		SELECT COALESCE(MAX(level), 0) FROM descendants
+	`
+	var height int
+	err := q.db.QueryRow(ctx, query, nodeID).Scan(&height)
+	return height, err
+}
+
+// CountChildren returns the number of active nodes directly in a folder, or
+// in the owner's root when parentID is nil.
+func (q *Queries) CountChildren(ctx context.Context, ownerID int64, parentID *string) (int64, error) {
+	var count int64
+	var err error
+	if parentID == nil {
+		err = q.db.QueryRow(ctx, `SELECT COUNT(*) FROM nodes WHERE owner_id = $1 AND parent_id IS NULL AND deleted_at IS NULL`, ownerID).Scan(&count)
+	} else {
+		err = q.db.QueryRow(ctx, `SELECT COUNT(*) FROM nodes WHERE parent_id = $1 AND deleted_at IS NULL`, *parentID).Scan(&count)
+	}
+	return count, err
+}
+
+// NodeVersion is an archived revision of a file's content. The current content
+// is not listed here; its version number is one past the latest archived one.
+type NodeVersion struct {
+	NodeID     string    `json:"node_id"`
+	Version    int       `json:"version"`
+	StorageKey string    `json:"-"`
+	SizeBytes  int64     `json:"size_bytes"`
+	MimeType   *string   `json:"mime_type"`
+	CreatedAt  time.Time `json:"created_at"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// ArchiveNodeVersion records the node's current content, kept in storage under
+// storageKey, as its next archived version and returns that version number.
+func (q *Queries) ArchiveNodeVersion(ctx context.Context, node *models.Node, storageKey string) (int, error) {
+	query := `
+		INSERT INTO node_versions (node_id, version, storage_key, size_bytes, mime_type, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5
+		FROM node_versions
+		WHERE node_id = $1
+		RETURNING version
+	`
+	var size int64
+	if node.SizeBytes != nil {
+		size = *node.SizeBytes
+	}
+	var version int
+	err := q.db.QueryRow(ctx, query, node.ID, storageKey, size, node.MimeType, node.ModifiedAt).Scan(&version)
+	return version, err
+}
+
+// CurrentNodeVersion returns the version number of a file's current content.
+func (q *Queries) CurrentNodeVersion(ctx context.Context, nodeID string) (int, error) {
+	var version int
+	err := q.db.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) + 1 FROM node_versions WHERE node_id = $1`, nodeID).Scan(&version)
+	return version, err
+}
+
+func (q *Queries) GetNodeVersion(ctx context.Context, nodeID string, version int) (*NodeVersion, error) {
+	query := `
+		SELECT node_id, version, storage_key, size_bytes, mime_type, created_at, archived_at
+		FROM node_versions
+		WHERE node_id = $1 AND version = $2
+	`
+	var v NodeVersion
+	err := q.db.QueryRow(ctx, query, nodeID, version).Scan(
+		&v.NodeID, &v.Version, &v.StorageKey, &v.SizeBytes, &v.MimeType, &v.CreatedAt, &v.ArchivedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ListNodeVersions returns the archived versions of a file, newest first.
+func (q *Queries) ListNodeVersions(ctx context.Context, nodeID string) ([]NodeVersion, error) {
+	query := `
+		SELECT node_id, version, storage_key, size_bytes, mime_type, created_at, archived_at
+		FROM node_versions
+		WHERE node_id = $1
+		ORDER BY version DESC
+	`
+	rows, err := q.db.Query(ctx, query, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []NodeVersion{}
+	for rows.Next() {
+		var v NodeVersion
+		if err := rows.Scan(&v.NodeID, &v.Version, &v.StorageKey, &v.SizeBytes, &v.MimeType, &v.CreatedAt, &v.ArchivedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// DeleteNodeVersionsForNodes removes the archived versions of the given nodes
+// and returns the storage keys of their blobs.
+func (q *Queries) DeleteNodeVersionsForNodes(ctx context.Context, nodeIDs []string) ([]string, error) {
+	if len(nodeIDs) == 0 {
+		return []string{}, nil
+	}
+	query := `DELETE FROM node_versions WHERE node_id = ANY($1) RETURNING storage_key`
+	return q.collectStrings(ctx, query, nodeIDs)
+}
+
+// GetPinnedShareVersion returns the version a recipient sees a node at. It is
+// nil when the recipient reaches the node through at least one share that is
+// not pinned to a version, or has no share covering it at all.
+func (q *Queries) GetPinnedShareVersion(ctx context.Context, nodeID string, recipientID int64) (*int, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id
+			FROM nodes
+			WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		)
+		SELECT s.pinned_version
+		FROM shares s
+		WHERE s.recipient_id = $2 AND s.node_id IN (SELECT id FROM node_parents)
+		ORDER BY s.pinned_version NULLS FIRST
+		LIMIT 1
+	`
+	var version *int
+	err := q.db.QueryRow(ctx, query, nodeID, recipientID).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return version, nil
+}
+
+// VersionPolicy is a user's own retention policy for archived versions. Nil
+// fields fall back to the global policy.
+type VersionPolicy struct {
+	MaxVersionsPerFile *int   `json:"max_versions_per_file"`
+	MaxBytes           *int64 `json:"max_bytes"`
+	MaxAgeDays         *int   `json:"max_age_days"`
+}
+
+func (q *Queries) GetVersionPolicy(ctx context.Context, userID int64) (*VersionPolicy, error) {
+	query := `SELECT max_versions_per_file, max_bytes, max_age_days FROM version_policies WHERE user_id = $1`
+	var policy VersionPolicy
+	err := q.db.QueryRow(ctx, query, userID).Scan(&policy.MaxVersionsPerFile, &policy.MaxBytes, &policy.MaxAgeDays)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (q *Queries) SetVersionPolicy(ctx context.Context, userID int64, policy VersionPolicy) error {
+	query := `
+		INSERT INTO version_policies (user_id, max_versions_per_file, max_bytes, max_age_days)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET max_versions_per_file = EXCLUDED.max_versions_per_file,
+			max_bytes = EXCLUDED.max_bytes,
+			max_age_days = EXCLUDED.max_age_days,
+			updated_at = NOW()
+	`
+	_, err := q.db.Exec(ctx, query, userID, policy.MaxVersionsPerFile, policy.MaxBytes, policy.MaxAgeDays)
+	return err
+}
+
+// ListUsersWithNodeVersions returns the owners of files that have archived
+// versions.
+func (q *Queries) ListUsersWithNodeVersions(ctx context.Context) ([]int64, error) {
+	query := `
+		SELECT DISTINCT n.owner_id
+		FROM node_versions v
+		JOIN nodes n ON n.id = v.node_id
+		ORDER BY n.owner_id
+	`
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// PruneNodeVersions deletes a user's archived versions beyond maxPerFile per
+// file, beyond maxBytes in total (oldest first) or archived more than
+// maxAgeDays ago, and returns the storage keys of their blobs. Zero disables a
+// limit. Versions pinned by a share are never pruned and do not count towards
+// the limits.
+func (q *Queries) PruneNodeVersions(ctx context.Context, ownerID int64, maxPerFile int, maxBytes int64, maxAgeDays int) ([]string, error) {
+	query := `
+		WITH candidates AS (
+			SELECT
+				v.node_id,
+				v.version,
+				v.archived_at,
+				ROW_NUMBER() OVER (PARTITION BY v.node_id ORDER BY v.version DESC) AS file_rank,
+				SUM(v.size_bytes) OVER (ORDER BY v.archived_at DESC, v.node_id, v.version DESC) AS newer_bytes
+			FROM node_versions v
+			JOIN nodes n ON n.id = v.node_id
+			WHERE n.owner_id = $1
+			  AND NOT EXISTS (
+				SELECT 1 FROM shares s WHERE s.node_id = v.node_id AND s.pinned_version = v.version
+			  )
+		)
+		DELETE FROM node_versions v
+		USING candidates c
+		WHERE v.node_id = c.node_id AND v.version = c.version
+		  AND (
+			($2::int > 0 AND c.file_rank > $2::int)
+			OR ($3::bigint > 0 AND c.newer_bytes > $3::bigint)
+			OR ($4::int > 0 AND c.archived_at < NOW() - make_interval(days => $4::int))
+		  )
+		RETURNING v.storage_key
+	`
+	return q.collectStrings(ctx, query, ownerID, maxPerFile, maxBytes, maxAgeDays)
+}
+
+type VersionStorageUsage struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// GetVersionStorageUsage sums up the archived versions of a user's files.
+func (q *Queries) GetVersionStorageUsage(ctx context.Context, ownerID int64) (*VersionStorageUsage, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(v.size_bytes), 0)
+		FROM node_versions v
+		JOIN nodes n ON n.id = v.node_id
+		WHERE n.owner_id = $1
+	`
+	var usage VersionStorageUsage
+	if err := q.db.QueryRow(ctx, query, ownerID).Scan(&usage.Count, &usage.Bytes); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// TrashBatch summarizes the nodes trashed by a single delete operation. The
+// root is the node the user deleted; the rest of the batch is its subtree.
+type TrashBatch struct {
+	BatchID    uuid.UUID `json:"batch_id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	ItemCount  int64     `json:"item_count"`
+	TotalBytes int64     `json:"total_bytes"`
+	RootID     string    `json:"root_id"`
+	RootName   string    `json:"root_name"`
+	RootType   string    `json:"root_type"`
+}
+
+func (q *Queries) ListTrashBatches(ctx context.Context, ownerID int64, limit int, offset int) ([]TrashBatch, error) {
+	query := `
+		SELECT g.batch_id, g.deleted_at, g.item_count, g.total_bytes, r.id, r.name, r.node_type
+		FROM (
+			SELECT
+				deletion_batch_id AS batch_id,
+				MAX(deleted_at) AS deleted_at,
+				COUNT(*) AS item_count,
+				COALESCE(SUM(size_bytes) FILTER (WHERE node_type = 'file'), 0) AS total_bytes
+			FROM nodes
+			WHERE owner_id = $1 AND deleted_at IS NOT NULL AND deletion_batch_id IS NOT NULL
+			GROUP BY deletion_batch_id
+		) g
+		JOIN LATERAL (
+			SELECT n.id, n.name, n.node_type
+			FROM nodes n
+			WHERE n.deletion_batch_id = g.batch_id AND n.deleted_at IS NOT NULL
+			  AND NOT EXISTS (
+				SELECT 1 FROM nodes p
+				WHERE p.id = n.original_parent_id AND p.deletion_batch_id = g.batch_id
+			  )
+			ORDER BY n.created_at
+			LIMIT 1
+		) r ON TRUE
+		ORDER BY g.deleted_at DESC, g.batch_id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := q.db.Query(ctx, query, ownerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []TrashBatch{}
+	for rows.Next() {
+		var b TrashBatch
+		if err := rows.Scan(&b.BatchID, &b.DeletedAt, &b.ItemCount, &b.TotalBytes, &b.RootID, &b.RootName, &b.RootType); err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return batches, nil
+}
+
+// RestoreTrashBatch restores every node still in the trash from a deletion
+// batch to its original location and returns the IDs of the restored batch
+// roots, i.e. the nodes whose parent is not part of the batch.
+func (q *Queries) RestoreTrashBatch(ctx context.Context, batchID uuid.UUID, ownerID int64) ([]string, error) {
+	query := `
+		WITH batch AS (
+			SELECT id, original_parent_id
+			FROM nodes
+			WHERE deletion_batch_id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL
+		),
+		restored AS (
+			UPDATE nodes n
+			SET
+				deleted_at = NULL,
+				parent_id = n.original_parent_id,
+				original_parent_id = NULL,
+				deletion_batch_id = NULL
+			FROM batch b
+			WHERE n.id = b.id
+			RETURNING n.id, b.original_parent_id
+		)
+		SELECT id FROM restored
+		WHERE original_parent_id IS NULL OR original_parent_id NOT IN (SELECT id FROM batch)
+	`
+	roots, err := q.collectStrings(ctx, query, batchID, ownerID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateNodeName
+		}
+		return nil, err
+	}
+	return roots, nil
+}
+
+const (
+	UndoOperationTrash  = "trash"
+	UndoOperationUpdate = "update"
+)
+
+// NodePlacement is the name and location of a node recorded before and after
+// an undoable operation. DeletionBatchID is only set after a trash operation.
+type NodePlacement struct {
+	Name            string     `json:"name"`
+	ParentID        *string    `json:"parent_id"`
+	DeletionBatchID *uuid.UUID `json:"deletion_batch_id,omitempty"`
+}
+
+type UndoToken struct {
+	Token     string
+	UserID    int64
+	NodeID    string
+	OwnerID   int64
+	Operation string
+	Before    NodePlacement
+	After     NodePlacement
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateUndoToken(ctx context.Context, token UndoToken) error {
+	before, err := json.Marshal(token.Before)
+	if err != nil {
+		return fmt.Errorf("failed to marshal undo state: %w", err)
+	}
+	after, err := json.Marshal(token.After)
+	if err != nil {
+		return fmt.Errorf("failed to marshal undo state: %w", err)
+	}
+
+	query := `
+		INSERT INTO undo_tokens (token, user_id, node_id, owner_id, operation, before_state, after_state, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = q.db.Exec(ctx, query, token.Token, token.UserID, token.NodeID, token.OwnerID, token.Operation, before, after, token.ExpiresAt)
+	return err
+}
+
+// ConsumeUndoToken deletes an unexpired undo token issued to the user and
+// returns it, or nil if there is no such token.
+func (q *Queries) ConsumeUndoToken(ctx context.Context, token string, userID int64) (*UndoToken, error) {
+	query := `
+		DELETE FROM undo_tokens
+		WHERE token = $1 AND user_id = $2 AND expires_at > NOW()
+		RETURNING token, user_id, node_id, owner_id, operation, before_state, after_state, expires_at
+	`
+	var t UndoToken
+	var before, after []byte
+	err := q.db.QueryRow(ctx, query, token, userID).Scan(&t.Token, &t.UserID, &t.NodeID, &t.OwnerID, &t.Operation, &before, &after, &t.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(before, &t.Before); err != nil {
+		return nil, fmt.Errorf("failed to decode undo state: %w", err)
+	}
+	if err := json.Unmarshal(after, &t.After); err != nil {
+		return nil, fmt.Errorf("failed to decode undo state: %w", err)
+	}
+	return &t, nil
+}
+
+func (q *Queries) DeleteExpiredUndoTokens(ctx context.Context) (int64, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM undo_tokens WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// RestoreNodePlacement sets the name and parent of an active node in a single
+// statement, so that reverting a combined rename and move cannot trip over the
+// intermediate state.
+func (q *Queries) RestoreNodePlacement(ctx context.Context, id string, ownerID int64, name string, parentID *string) (bool, error) {
+	query := `
+		UPDATE nodes
+		SET name = $1, parent_id = $2, modified_at = $3
+		WHERE id = $4 AND owner_id = $5 AND deleted_at IS NULL
+	`
+	res, err := q.db.Exec(ctx, query, name, parentID, time.Now(), id, ownerID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return false, fmt.Errorf("target folder does not exist")
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return false, ErrDuplicateNodeName
+		}
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// ListSubtreeNodes returns the active nodes of a subtree, the root included,
+// ordered so that every folder comes before its contents.
+func (q *Queries) ListSubtreeNodes(ctx context.Context, rootID string) ([]models.Node, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, 0 AS depth FROM nodes WHERE id = $1 AND deleted_at IS NULL
+
+			UNION ALL
+
+			SELECT n.id, s.depth + 1
+			FROM nodes n
+			JOIN subtree s ON n.parent_id = s.id
+			WHERE n.deleted_at IS NULL
+		)
+		SELECT n.id, n.owner_id, n.parent_id, n.name, n.node_type, n.size_bytes, n.mime_type, n.created_at, n.modified_at, n.content_sha256
+		FROM subtree s
+		JOIN nodes n ON n.id = s.id
+		ORDER BY s.depth, n.name
+	`
+	rows, err := q.db.Query(ctx, query, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt, &node.ContentSHA256); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+const (
+	ClipboardCut  = "cut"
+	ClipboardCopy = "copy"
+)
+
+type Clipboard struct {
+	Operation string
+	NodeIDs   []string
+	UpdatedAt time.Time
+}
+
+// SetClipboard replaces the user's clipboard.
+func (q *Queries) SetClipboard(ctx context.Context, userID int64, operation string, nodeIDs []string) (*Clipboard, error) {
+	query := `
+		INSERT INTO clipboards (user_id, operation, node_ids, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET operation = EXCLUDED.operation, node_ids = EXCLUDED.node_ids, updated_at = EXCLUDED.updated_at
+		RETURNING operation, node_ids, updated_at
+	`
+	var clipboard Clipboard
+	err := q.db.QueryRow(ctx, query, userID, operation, nodeIDs).Scan(&clipboard.Operation, &clipboard.NodeIDs, &clipboard.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &clipboard, nil
+}
+
+func (q *Queries) GetClipboard(ctx context.Context, userID int64) (*Clipboard, error) {
+	query := `SELECT operation, node_ids, updated_at FROM clipboards WHERE user_id = $1`
+	var clipboard Clipboard
+	err := q.db.QueryRow(ctx, query, userID).Scan(&clipboard.Operation, &clipboard.NodeIDs, &clipboard.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &clipboard, nil
+}
+
+func (q *Queries) ClearClipboard(ctx context.Context, userID int64) error {
+	_, err := q.db.Exec(ctx, `DELETE FROM clipboards WHERE user_id = $1`, userID)
+	return err
+}
+
+// AddNodeTags attaches tags to a node, ignoring the ones it already has.
+func (q *Queries) AddNodeTags(ctx context.Context, nodeID string, tags []string) error {
+	query := `
+		INSERT INTO node_tags (node_id, tag)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`
+	_, err := q.db.Exec(ctx, query, nodeID, tags)
+	return err
+}
+
+func (q *Queries) ListNodeTags(ctx context.Context, nodeID string) ([]string, error) {
+	return q.collectStrings(ctx, `SELECT tag FROM node_tags WHERE node_id = $1 ORDER BY tag`, nodeID)
+}
+
+// OrganizationRule moves and tags files uploaded to SourceFolderID (the
+// owner's root when nil) whose name matches NamePattern.
+type OrganizationRule struct {
+	ID             int64     `json:"id" example:"1"`
+	Name           string    `json:"name" example:"Faktury PDF"`
+	NamePattern    string    `json:"name_pattern" example:"*.pdf"`
+	SourceFolderID *string   `json:"source_folder_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	TargetFolderID *string   `json:"target_folder_id,omitempty" example:"bNowyFolderRodzic123"`
+	Tags           []string  `json:"tags" example:"invoice"`
+	Enabled        bool      `json:"enabled" example:"true"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type CreateOrganizationRuleParams struct {
+	OwnerID        int64
+	Name           string
+	NamePattern    string
+	SourceFolderID *string
+	TargetFolderID *string
+	Tags           []string
+	Enabled        bool
+}
+
+const organizationRuleColumns = `id, name, name_pattern, source_folder_id, target_folder_id, tags, enabled, created_at`
+
+func scanOrganizationRule(row pgx.Row) (*OrganizationRule, error) {
+	var rule OrganizationRule
+	err := row.Scan(&rule.ID, &rule.Name, &rule.NamePattern, &rule.SourceFolderID, &rule.TargetFolderID, &rule.Tags, &rule.Enabled, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (q *Queries) CreateOrganizationRule(ctx context.Context, arg CreateOrganizationRuleParams) (*OrganizationRule, error) {
+	query := `
+		INSERT INTO organization_rules (owner_id, name, name_pattern, source_folder_id, target_folder_id, tags, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + organizationRuleColumns
+	return scanOrganizationRule(q.db.QueryRow(ctx, query, arg.OwnerID, arg.Name, arg.NamePattern, arg.SourceFolderID, arg.TargetFolderID, arg.Tags, arg.Enabled))
+}
+
+func (q *Queries) GetOrganizationRule(ctx context.Context, id int64, ownerID int64) (*OrganizationRule, error) {
+	query := `SELECT ` + organizationRuleColumns + ` FROM organization_rules WHERE id = $1 AND owner_id = $2`
+	rule, err := scanOrganizationRule(q.db.QueryRow(ctx, query, id, ownerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return rule, err
+}
+
+// ListOrganizationRules returns the owner's rules in the order they are
+// evaluated. When enabledOnly is set, disabled rules are left out.
+func (q *Queries) ListOrganizationRules(ctx context.Context, ownerID int64, enabledOnly bool) ([]OrganizationRule, error) {
+	query := `
+		SELECT ` + organizationRuleColumns + `
+		FROM organization_rules
+		WHERE owner_id = $1 AND (enabled OR NOT $2)
+		ORDER BY id
+	`
+	rows, err := q.db.Query(ctx, query, ownerID, enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []OrganizationRule{}
+	for rows.Next() {
+		rule, err := scanOrganizationRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (q *Queries) DeleteOrganizationRule(ctx context.Context, id int64, ownerID int64) (bool, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM organization_rules WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// FolderCleanupPolicy trashes files directly in a folder that were last
+// modified more than MaxAgeDays ago or that fall outside the KeepNewest most
+// recently modified ones. A nil limit is not applied.
+type FolderCleanupPolicy struct {
+	FolderID   string    `json:"folder_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID    int64     `json:"-"`
+	MaxAgeDays *int      `json:"max_age_days,omitempty" example:"90"`
+	KeepNewest *int      `json:"keep_newest,omitempty" example:"50"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+const folderCleanupPolicyColumns = `folder_id, owner_id, max_age_days, keep_newest, updated_at`
+
+func (q *Queries) SetFolderCleanupPolicy(ctx context.Context, folderID string, ownerID int64, maxAgeDays *int, keepNewest *int) (*FolderCleanupPolicy, error) {
+	query := `
+		INSERT INTO folder_cleanup_policies (folder_id, owner_id, max_age_days, keep_newest, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (folder_id) DO UPDATE
+		SET max_age_days = EXCLUDED.max_age_days, keep_newest = EXCLUDED.keep_newest, updated_at = EXCLUDED.updated_at
+		RETURNING ` + folderCleanupPolicyColumns
+	var p FolderCleanupPolicy
+	err := q.db.QueryRow(ctx, query, folderID, ownerID, maxAgeDays, keepNewest).Scan(&p.FolderID, &p.OwnerID, &p.MaxAgeDays, &p.KeepNewest, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (q *Queries) GetFolderCleanupPolicy(ctx context.Context, folderID string) (*FolderCleanupPolicy, error) {
+	query := `SELECT ` + folderCleanupPolicyColumns + ` FROM folder_cleanup_policies WHERE folder_id = $1`
+	var p FolderCleanupPolicy
+	err := q.db.QueryRow(ctx, query, folderID).Scan(&p.FolderID, &p.OwnerID, &p.MaxAgeDays, &p.KeepNewest, &p.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (q *Queries) DeleteFolderCleanupPolicy(ctx context.Context, folderID string) (bool, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM folder_cleanup_policies WHERE folder_id = $1`, folderID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// ListFolderCleanupPolicies returns the policies of folders that are not in
+// the trash.
+func (q *Queries) ListFolderCleanupPolicies(ctx context.Context) ([]FolderCleanupPolicy, error) {
+	query := `
+		SELECT p.folder_id, p.owner_id, p.max_age_days, p.keep_newest, p.updated_at
+		FROM folder_cleanup_policies p
+		JOIN nodes n ON n.id = p.folder_id
+		WHERE n.deleted_at IS NULL
+		ORDER BY p.folder_id
+	`
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []FolderCleanupPolicy{}
+	for rows.Next() {
+		var p FolderCleanupPolicy
+		if err := rows.Scan(&p.FolderID, &p.OwnerID, &p.MaxAgeDays, &p.KeepNewest, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// ListCleanupCandidates returns the files of a folder that its cleanup policy
+// would trash, oldest first.
+func (q *Queries) ListCleanupCandidates(ctx context.Context, policy FolderCleanupPolicy) ([]models.Node, error) {
+	query := `
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at
+		FROM (
+			SELECT n.*, ROW_NUMBER() OVER (ORDER BY n.modified_at DESC, n.id) AS position
+			FROM nodes n
+			WHERE n.parent_id = $1 AND n.node_type = 'file' AND n.deleted_at IS NULL
+		) f
+		WHERE ($2::int IS NOT NULL AND f.modified_at < NOW() - make_interval(days => $2::int))
+		   OR ($3::int IS NOT NULL AND f.position > $3::int)
+		ORDER BY f.modified_at, f.id
+	`
+	rows, err := q.db.Query(ctx, query, policy.FolderID, policy.MaxAgeDays, policy.KeepNewest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+const (
+	TranscriptionQueued    = "queued"
+	TranscriptionRunning   = "running"
+	TranscriptionCompleted = "completed"
+	TranscriptionFailed    = "failed"
+)
+
+type TranscriptionJob struct {
+	ID           uuid.UUID `json:"id"`
+	NodeID       string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	RequestedBy  int64     `json:"-"`
+	Format       string    `json:"format" example:"vtt"`
+	Status       string    `json:"status" example:"running"`
+	Progress     int       `json:"progress" example:"10"`
+	ResultNodeID *string   `json:"result_node_id,omitempty" example:"bNowyPlikNapisow12345"`
+	Error        *string   `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const transcriptionJobColumns = `id, node_id, requested_by, format, status, progress, result_node_id, error, created_at, updated_at`
+
+func scanTranscriptionJob(row pgx.Row) (*TranscriptionJob, error) {
+	var job TranscriptionJob
+	err := row.Scan(&job.ID, &job.NodeID, &job.RequestedBy, &job.Format, &job.Status, &job.Progress, &job.ResultNodeID, &job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *Queries) CreateTranscriptionJob(ctx context.Context, nodeID string, requestedBy int64, format string) (*TranscriptionJob, error) {
+	query := `
+		INSERT INTO transcription_jobs (id, node_id, requested_by, format)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + transcriptionJobColumns
+	return scanTranscriptionJob(q.db.QueryRow(ctx, query, uuid.New(), nodeID, requestedBy, format))
+}
+
+func (q *Queries) GetTranscriptionJob(ctx context.Context, id uuid.UUID, requestedBy int64) (*TranscriptionJob, error) {
+	query := `SELECT ` + transcriptionJobColumns + ` FROM transcription_jobs WHERE id = $1 AND requested_by = $2`
+	return scanTranscriptionJob(q.db.QueryRow(ctx, query, id, requestedBy))
+}
+
+// ClaimTranscriptionJob marks the oldest queued job as running and returns it,
+// or nil when the queue is empty. Jobs left running for longer than staleAfter,
+// e.g. by a crashed server, are claimed again.
+func (q *Queries) ClaimTranscriptionJob(ctx context.Context, staleAfter time.Duration) (*TranscriptionJob, error) {
+	query := `
+		UPDATE transcription_jobs
+		SET status = 'running', progress = 0, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM transcription_jobs
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + transcriptionJobColumns
+	return scanTranscriptionJob(q.db.QueryRow(ctx, query, time.Now().Add(-staleAfter)))
+}
+
+func (q *Queries) UpdateTranscriptionJob(ctx context.Context, id uuid.UUID, status string, progress int, resultNodeID *string, errorMessage *string) (*TranscriptionJob, error) {
+	query := `
+		UPDATE transcription_jobs
+		SET status = $2, progress = $3, result_node_id = $4, error = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + transcriptionJobColumns
+	return scanTranscriptionJob(q.db.QueryRow(ctx, query, id, status, progress, resultNodeID, errorMessage))
+}
+
+// NodeNameTaken reports whether the name is already used in the folder, or in
+// the owner's root when parentID is nil. Like the unique name indexes, it also
+// counts trashed nodes, which keep a NULL parent.
+func (q *Queries) NodeNameTaken(ctx context.Context, ownerID int64, parentID *string, name string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM nodes
+			WHERE owner_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3
+		)
+	`
+	var taken bool
+	err := q.db.QueryRow(ctx, query, ownerID, parentID, name).Scan(&taken)
+	return taken, err
+}
+
+// GetNodeStorageBackend returns the name of the storage backend holding the
+// content of a node and the key it is stored under, which is the node ID
+// unless the content is deduplicated. Both are empty when the node does not
+// exist.
+func (q *Queries) GetNodeStorageBackend(ctx context.Context, id string) (string, string, error) {
+	var backend, key string
+	err := q.db.QueryRow(ctx, `SELECT storage_backend, COALESCE(storage_key, id) FROM nodes WHERE id = $1`, id).Scan(&backend, &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	return backend, key, err
+}
+
+func (q *Queries) SetNodeStorageBackend(ctx context.Context, id string, backend string) error {
+	_, err := q.db.Exec(ctx, `UPDATE nodes SET storage_backend = $2 WHERE id = $1`, id, backend)
+	return err
+}
+
+// ListTrashedFileBackends maps the IDs of files in the owner's trash to the
+// storage backends holding their content, so it can be removed after a purge.
+// Deduplicated files are left to ReleaseTrashedContentBlobs.
+func (q *Queries) ListTrashedFileBackends(ctx context.Context, ownerID int64) (map[string]string, error) {
+	query := `
+		SELECT id, storage_backend FROM nodes
+		WHERE owner_id = $1 AND deleted_at IS NOT NULL AND node_type = 'file' AND storage_key IS NULL
+	`
+	rows, err := q.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backends := make(map[string]string)
+	for rows.Next() {
+		var id, backend string
+		if err := rows.Scan(&id, &backend); err != nil {
+			return nil, err
+		}
+		backends[id] = backend
+	}
+	return backends, rows.Err()
+}
+
+// ListOwnedTree returns all of the owner's active nodes reachable from their
+// root folder, parents before children.
+func (q *Queries) ListOwnedTree(ctx context.Context, ownerID int64) ([]models.Node, error) {
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, 0 AS depth FROM nodes
+			WHERE owner_id = $1 AND parent_id IS NULL AND deleted_at IS NULL
+
+			UNION ALL
+
+			SELECT n.id, t.depth + 1
+			FROM nodes n
+			JOIN tree t ON n.parent_id = t.id
+			WHERE n.deleted_at IS NULL
+		)
+		SELECT n.id, n.owner_id, n.parent_id, n.name, n.node_type, n.size_bytes, n.mime_type, n.created_at, n.modified_at
+		FROM tree t
+		JOIN nodes n ON n.id = t.id
+		ORDER BY t.depth, n.name
+	`
+	rows, err := q.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// CountChildrenOf returns the number of active direct children of each of the
+// given folders. Folders without children are missing from the result.
+func (q *Queries) CountChildrenOf(ctx context.Context, parentIDs []string) (map[string]int64, error) {
+	query := `
+		SELECT parent_id, COUNT(*)
+		FROM nodes
+		WHERE parent_id = ANY($1) AND deleted_at IS NULL
+		GROUP BY parent_id
+	`
+	rows, err := q.db.Query(ctx, query, parentIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64, len(parentIDs))
+	for rows.Next() {
+		var parentID string
+		var count int64
+		if err := rows.Scan(&parentID, &count); err != nil {
+			return nil, err
+		}
+		counts[parentID] = count
+	}
+	return counts, rows.Err()
+}
+
+// NodeAccessState describes why a user cannot use a node that exists.
+type NodeAccessState struct {
+	Trashed bool
+	// Readable is true when the user owns the node or it is shared with them.
+	Readable bool
+}
+
+// GetNodeAccessState returns the user's access to a node, or nil when the
+// node does not exist.
+func (q *Queries) GetNodeAccessState(ctx context.Context, nodeID string, userID int64) (*NodeAccessState, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id FROM nodes WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		)
+		SELECT
+			n.deleted_at IS NOT NULL,
+			n.owner_id = $2 OR EXISTS (
+				SELECT 1 FROM share_grants s
+				WHERE s.recipient_id = $2 AND s.node_id IN (SELECT id FROM node_parents)
+			)
+		FROM nodes n
+		WHERE n.id = $1
+	`
+	var state NodeAccessState
+	err := q.db.QueryRow(ctx, query, nodeID, userID).Scan(&state.Trashed, &state.Readable)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+const (
+	ArchiveImportQueued    = "queued"
+	ArchiveImportRunning   = "running"
+	ArchiveImportCompleted = "completed"
+	ArchiveImportFailed    = "failed"
+)
+
+type ArchiveImport struct {
+	ID          uuid.UUID `json:"id"`
+	RequestedBy int64     `json:"-"`
+	ParentID    *string   `json:"parent_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Format      string    `json:"format" example:"zip"`
+	StagedID    string    `json:"-"`
+	// SourceNodeID is the archive file being extracted, nil for archives
+	// uploaded for import.
+	SourceNodeID *string   `json:"source_node_id,omitempty" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	TotalEntries int       `json:"total_entries" example:"42"`
+	TotalBytes   int64     `json:"total_bytes" example:"10485760"`
+	Status       string    `json:"status" example:"running"`
+	Progress     int       `json:"progress" example:"50"`
+	CreatedNodes int       `json:"created_nodes" example:"21"`
+	Error        *string   `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type CreateArchiveImportParams struct {
+	RequestedBy  int64
+	ParentID     *string
+	Format       string
+	StagedID     string
+	SourceNodeID *string
+	TotalEntries int
+	TotalBytes   int64
+}
+
+const archiveImportColumns = `id, requested_by, parent_id, format, staged_id, source_node_id, total_entries, total_bytes, status, progress, created_nodes, error, created_at, updated_at`
+
+func scanArchiveImport(row pgx.Row) (*ArchiveImport, error) {
+	var job ArchiveImport
+	err := row.Scan(&job.ID, &job.RequestedBy, &job.ParentID, &job.Format, &job.StagedID, &job.SourceNodeID, &job.TotalEntries, &job.TotalBytes,
+		&job.Status, &job.Progress, &job.CreatedNodes, &job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *Queries) CreateArchiveImport(ctx context.Context, arg CreateArchiveImportParams) (*ArchiveImport, error) {
+	query := `
+		INSERT INTO archive_imports (id, requested_by, parent_id, format, staged_id, source_node_id, total_entries, total_bytes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + archiveImportColumns
+	return scanArchiveImport(q.db.QueryRow(ctx, query, uuid.New(), arg.RequestedBy, arg.ParentID, arg.Format, arg.StagedID, arg.SourceNodeID, arg.TotalEntries, arg.TotalBytes))
+}
+
+func (q *Queries) GetArchiveImport(ctx context.Context, id uuid.UUID, requestedBy int64) (*ArchiveImport, error) {
+	query := `SELECT ` + archiveImportColumns + ` FROM archive_imports WHERE id = $1 AND requested_by = $2`
+	return scanArchiveImport(q.db.QueryRow(ctx, query, id, requestedBy))
+}
+
+// ClaimArchiveImport marks the oldest queued import as running and returns it,
+// or nil when the queue is empty. Imports left running for longer than
+// staleAfter are claimed again.
+func (q *Queries) ClaimArchiveImport(ctx context.Context, staleAfter time.Duration) (*ArchiveImport, error) {
+	query := `
+		UPDATE archive_imports
+		SET status = 'running', progress = 0, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM archive_imports
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + archiveImportColumns
+	return scanArchiveImport(q.db.QueryRow(ctx, query, time.Now().Add(-staleAfter)))
+}
+
+func (q *Queries) UpdateArchiveImport(ctx context.Context, id uuid.UUID, status string, progress int, createdNodes int, errorMessage *string) (*ArchiveImport, error) {
+	query := `
+		UPDATE archive_imports
+		SET status = $2, progress = $3, created_nodes = $4, error = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + archiveImportColumns
+	return scanArchiveImport(q.db.QueryRow(ctx, query, id, status, progress, createdNodes, errorMessage))
+}
+
+// GetChildFolderByName returns the live folder with the given name in a
+// folder, or in the owner's root when parentID is nil.
+func (q *Queries) GetChildFolderByName(ctx context.Context, ownerID int64, parentID *string, name string) (*models.Node, error) {
+	query := `
+		SELECT id, owner_id, parent_id, name, node_type, size_bytes, mime_type, created_at, modified_at
+		FROM nodes
+		WHERE owner_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3
+		  AND node_type = 'folder' AND deleted_at IS NULL
+	`
+	var node models.Node
+	err := q.db.QueryRow(ctx, query, ownerID, parentID, name).Scan(
+		&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType,
+		&node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// FolderHook calls URL for every file that arrives directly in a folder. A
+// non-empty MimePrefixes limits it to files whose type starts with one of them.
+type FolderHook struct {
+	FolderID     string    `json:"folder_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID      int64     `json:"-"`
+	URL          string    `json:"url" example:"http://invoices.internal/ingest"`
+	Secret       string    `json:"secret" example:"k3J9sZ0qL8xYwV2mN5bR7tU1oP4aE6cD9fG0hI2j"`
+	MimePrefixes []string  `json:"mime_prefixes" example:"application/pdf"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const folderHookColumns = `folder_id, owner_id, url, secret, mime_prefixes, updated_at`
+
+func scanFolderHook(row pgx.Row) (*FolderHook, error) {
+	var h FolderHook
+	err := row.Scan(&h.FolderID, &h.OwnerID, &h.URL, &h.Secret, &h.MimePrefixes, &h.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &h, nil
+}
+
+// SetFolderHook creates or replaces the hook of a folder. The secret is only
+// used when the hook is created; an existing hook keeps its secret.
+func (q *Queries) SetFolderHook(ctx context.Context, folderID string, ownerID int64, url string, secret string, mimePrefixes []string) (*FolderHook, error) {
+	query := `
+		INSERT INTO folder_hooks (folder_id, owner_id, url, secret, mime_prefixes, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (folder_id) DO UPDATE
+		SET url = EXCLUDED.url, mime_prefixes = EXCLUDED.mime_prefixes, updated_at = EXCLUDED.updated_at
+		RETURNING ` + folderHookColumns
+	return scanFolderHook(q.db.QueryRow(ctx, query, folderID, ownerID, url, secret, mimePrefixes))
+}
+
+func (q *Queries) GetFolderHook(ctx context.Context, folderID string) (*FolderHook, error) {
+	query := `SELECT ` + folderHookColumns + ` FROM folder_hooks WHERE folder_id = $1`
+	return scanFolderHook(q.db.QueryRow(ctx, query, folderID))
+}
+
+func (q *Queries) DeleteFolderHook(ctx context.Context, folderID string) (bool, error) {
+	res, err := q.db.Exec(ctx, `DELETE FROM folder_hooks WHERE folder_id = $1`, folderID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// EnqueueFolderHookDeliveries queues a delivery for each of the given files
+// that sits directly in a folder with a matching hook, and returns how many
+// were queued.
+func (q *Queries) EnqueueFolderHookDeliveries(ctx context.Context, folderID string, nodeIDs []string) (int64, error) {
+	query := `
+		INSERT INTO folder_hook_deliveries (folder_id, node_id)
+		SELECT h.folder_id, n.id
+		FROM folder_hooks h
+		JOIN nodes n ON n.parent_id = h.folder_id
+		WHERE h.folder_id = $1 AND n.id = ANY($2) AND n.node_type = 'file'
+		  AND (
+			cardinality(h.mime_prefixes) = 0
+			OR EXISTS (SELECT 1 FROM unnest(h.mime_prefixes) AS p(prefix) WHERE n.mime_type LIKE p.prefix || '%')
+		  )
+	`
+	res, err := q.db.Exec(ctx, query, folderID, nodeIDs)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// FolderHookDelivery is a queued call of a folder hook for one file.
+type FolderHookDelivery struct {
+	ID       int64
+	FolderID string
+	NodeID   string
+	OwnerID  int64
+	URL      string
+	Secret   string
+	// Attempts counts this attempt too.
+	Attempts int
+}
+
+// ClaimFolderHookDeliveries takes up to limit due deliveries and postpones
+// them until leaseUntil, so other workers skip them while they are sent.
+func (q *Queries) ClaimFolderHookDeliveries(ctx context.Context, limit int, leaseUntil time.Time) ([]FolderHookDelivery, error) {
+	query := `
+		UPDATE folder_hook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = $2
+		FROM folder_hooks h
+		WHERE h.folder_id = d.folder_id AND d.id IN (
+			SELECT id FROM folder_hook_deliveries
+			WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.folder_id, d.node_id, h.owner_id, h.url, h.secret, d.attempts
+	`
+	rows, err := q.db.Query(ctx, query, limit, leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []FolderHookDelivery{}
+	for rows.Next() {
+		var d FolderHookDelivery
+		if err := rows.Scan(&d.ID, &d.FolderID, &d.NodeID, &d.OwnerID, &d.URL, &d.Secret, &d.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (q *Queries) DeleteFolderHookDelivery(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, `DELETE FROM folder_hook_deliveries WHERE id = $1`, id)
+	return err
+}
+
+func (q *Queries) RetryFolderHookDelivery(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	_, err := q.db.Exec(ctx, `UPDATE folder_hook_deliveries SET next_attempt_at = $2, last_error = $3 WHERE id = $1`, id, nextAttemptAt, lastError)
+	return err
+}
+
+// GetShareForParticipant returns a share the user either created or received.
+func (q *Queries) GetShareForParticipant(ctx context.Context, shareID int64, userID int64) (*models.Share, error) {
+	query := `
+		SELECT id, node_id, sharer_id, recipient_id, permissions, message, pinned_version, shared_at
+		FROM shares
+		WHERE id = $1 AND (sharer_id = $2 OR recipient_id = $2)
+	`
+	var share models.Share
+	err := q.db.QueryRow(ctx, query, shareID, userID).Scan(
+		&share.ID, &share.NodeID, &share.SharerID, &share.RecipientID,
+		&share.Permissions, &share.Message, &share.PinnedVersion, &share.SharedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ListSubtreeEvents returns up to limit of the owner's events of the given
+// types that concern rootID or anything below it, newest first and older than
+// beforeID when it is positive. An event concerns a node when the node is the
+// payload's subject or the folder it was created in, moved to or trashed from.
+// Nil eventTypes matches events of every type.
+func (q *Queries) ListSubtreeEvents(ctx context.Context, ownerID int64, rootID string, eventTypes []string, beforeID int64, limit int) ([]Event, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM nodes WHERE id = $2
+
+			UNION ALL
+
+			SELECT n.id
+			FROM nodes n
+			JOIN subtree st ON n.parent_id = st.id
+		)
+		SELECT e.id, e.event_type, e.event_time, e.payload
+		FROM event_journal e
+		WHERE e.user_id = $1
+		  AND ($3::text[] IS NULL OR e.event_type = ANY($3))
+		  AND ($4 <= 0 OR e.id < $4)
+		  AND EXISTS (
+			SELECT 1 FROM subtree st
+			WHERE st.id IN (
+				e.payload->>'id', e.payload->>'node_id', e.payload->>'folder_id',
+				e.payload->>'parent_id', e.payload->>'new_parent_id'
+			)
+		  )
+		ORDER BY e.id DESC
+		LIMIT $5
+	`
+	rows, err := q.db.Query(ctx, query, ownerID, rootID, eventTypes, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.ID, &event.EventType, &event.EventTime, &event.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// NodeAncestor is a node on the path from a node up to its root, with the
+// share of that node to a given user, if any.
+type NodeAncestor struct {
+	NodeID  string `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Name    string `json:"name" example:"Projekty"`
+	Depth   int    `json:"depth" example:"0"`
+	OwnerID int64  `json:"owner_id" example:"2"`
+	Trashed bool   `json:"trashed" example:"false"`
+	// The fields below describe the user's share of this node.
+	ShareID       *int64  `json:"share_id,omitempty" example:"42"`
+	Permissions   *string `json:"permissions,omitempty" example:"write"`
+	PinnedVersion *int    `json:"pinned_version,omitempty" example:"3"`
+	// GroupID is set when the share was made to a group the user is in;
+	// ShareID is then the group share's ID.
+	GroupID *int64 `json:"group_id,omitempty" example:"7"`
+}
+
+// ListNodeAncestry returns the node followed by its ancestors up to the root,
+// each with the user's share of it, preferring the highest permission when
+// the user has several. It is empty when the node does not exist.
+func (q *Queries) ListNodeAncestry(ctx context.Context, nodeID string, userID int64) ([]NodeAncestor, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id, name, owner_id, deleted_at, 0 AS depth
+			FROM nodes
+			WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id, n.name, n.owner_id, n.deleted_at, np.depth + 1
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		)
+		SELECT np.id, np.name, np.depth, np.owner_id, np.deleted_at IS NOT NULL, s.id, s.permissions, s.pinned_version, s.group_id
+		FROM node_parents np
+		LEFT JOIN LATERAL (
+			SELECT id, permissions, pinned_version, group_id
+			FROM share_grants
+			WHERE node_id = np.id AND recipient_id = $2
+			ORDER BY permissions = 'manage' DESC, permissions = 'write' DESC, group_id NULLS FIRST
+			LIMIT 1
+		) s ON TRUE
+		ORDER BY np.depth
+	`
+	rows, err := q.db.Query(ctx, query, nodeID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ancestry := []NodeAncestor{}
+	for rows.Next() {
+		var a NodeAncestor
+		if err := rows.Scan(&a.NodeID, &a.Name, &a.Depth, &a.OwnerID, &a.Trashed, &a.ShareID, &a.Permissions, &a.PinnedVersion, &a.GroupID); err != nil {
+			return nil, err
+		}
+		ancestry = append(ancestry, a)
+	}
+	return ancestry, rows.Err()
+}
+
+// GetSubtreeTotals counts the live nodes in the subtrees of the given roots and
+// sums the size of their files. Overlapping subtrees are counted once.
+func (q *Queries) GetSubtreeTotals(ctx context.Context, rootIDs []string) (int64, int64, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, node_type, size_bytes FROM nodes WHERE id = ANY($1) AND deleted_at IS NULL
+
+			UNION
+
+			SELECT n.id, n.node_type, n.size_bytes
+			FROM nodes n
+			JOIN subtree s ON n.parent_id = s.id
+			WHERE n.deleted_at IS NULL
+		)
+		SELECT COUNT(*), COALESCE(SUM(size_bytes) FILTER (WHERE node_type = 'file'), 0)::BIGINT
+		FROM subtree
+	`
+	var entries, totalBytes int64
+	err := q.db.QueryRow(ctx, query, rootIDs).Scan(&entries, &totalBytes)
+	return entries, totalBytes, err
+}
+
+const (
+	LegalExportQueued    = "queued"
+	LegalExportRunning   = "running"
+	LegalExportCompleted = "completed"
+	LegalExportFailed    = "failed"
+)
+
+// LegalExport is a bundle of the content, version history and audit trail of a
+// subtree, prepared for a litigation hold. A completed export is never
+// modified again.
+type LegalExport struct {
+	ID          uuid.UUID  `json:"id"`
+	RequestedBy *int64     `json:"requested_by" example:"1"`
+	NodeID      string     `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID     *int64     `json:"owner_id" example:"2"`
+	Reason      string     `json:"reason" example:"Sprawa sądowa 123/2025"`
+	Status      string     `json:"status" example:"completed"`
+	StorageKey  *string    `json:"-"`
+	SizeBytes   *int64     `json:"size_bytes,omitempty" example:"10485760"`
+	SHA256      *string    `json:"sha256,omitempty"`
+	Entries     int        `json:"entries" example:"42"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+const legalExportColumns = `id, requested_by, node_id, owner_id, reason, status, storage_key, size_bytes, sha256, entries, error, created_at, updated_at, completed_at`
+
+func scanLegalExport(row pgx.Row) (*LegalExport, error) {
+	var e LegalExport
+	err := row.Scan(&e.ID, &e.RequestedBy, &e.NodeID, &e.OwnerID, &e.Reason, &e.Status, &e.StorageKey, &e.SizeBytes,
+		&e.SHA256, &e.Entries, &e.Error, &e.CreatedAt, &e.UpdatedAt, &e.CompletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CreateLegalExport queues an export of nodeID, trashed or not, and its
+// subtree. It returns nil when the node does not exist.
+func (q *Queries) CreateLegalExport(ctx context.Context, requestedBy int64, nodeID string, reason string) (*LegalExport, error) {
+	query := `
+		INSERT INTO legal_exports (id, requested_by, node_id, owner_id, reason)
+		SELECT $1, $2, id, owner_id, $3 FROM nodes WHERE id = $4
+		RETURNING ` + legalExportColumns
+	return scanLegalExport(q.db.QueryRow(ctx, query, uuid.New(), requestedBy, reason, nodeID))
+}
+
+func (q *Queries) GetLegalExport(ctx context.Context, id uuid.UUID) (*LegalExport, error) {
+	query := `SELECT ` + legalExportColumns + ` FROM legal_exports WHERE id = $1`
+	return scanLegalExport(q.db.QueryRow(ctx, query, id))
+}
+
+func (q *Queries) ListLegalExports(ctx context.Context, limit int, offset int) ([]LegalExport, error) {
+	query := `SELECT ` + legalExportColumns + ` FROM legal_exports ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	rows, err := q.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []LegalExport{}
+	for rows.Next() {
+		export, err := scanLegalExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *export)
+	}
+	return exports, rows.Err()
+}
+
+// ClaimLegalExport marks the oldest queued export as running and returns it,
+// or nil when the queue is empty. Exports left running for longer than
+// staleAfter are claimed again.
+func (q *Queries) ClaimLegalExport(ctx context.Context, staleAfter time.Duration) (*LegalExport, error) {
+	query := `
+		UPDATE legal_exports
+		SET status = 'running', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM legal_exports
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + legalExportColumns
+	return scanLegalExport(q.db.QueryRow(ctx, query, time.Now().Add(-staleAfter)))
+}
+
+// CompleteLegalExport records the finished bundle. It does nothing to an
+// export that is already completed, so a bundle cannot be replaced.
+func (q *Queries) CompleteLegalExport(ctx context.Context, id uuid.UUID, storageKey string, sizeBytes int64, sha256 string, entries int) (*LegalExport, error) {
+	query := `
+		UPDATE legal_exports
+		SET status = 'completed', storage_key = $2, size_bytes = $3, sha256 = $4, entries = $5,
+		    error = NULL, updated_at = NOW(), completed_at = NOW()
+		WHERE id = $1 AND status <> 'completed'
+		RETURNING ` + legalExportColumns
+	return scanLegalExport(q.db.QueryRow(ctx, query, id, storageKey, sizeBytes, sha256, entries))
+}
+
+func (q *Queries) FailLegalExport(ctx context.Context, id uuid.UUID, errorMessage string) error {
+	_, err := q.db.Exec(ctx, `UPDATE legal_exports SET status = 'failed', error = $2, updated_at = NOW() WHERE id = $1 AND status <> 'completed'`, id, errorMessage)
+	return err
+}
+
+// ListSubtreeNodesForExport returns rootID and everything below it, trashed
+// nodes included, parents before their children.
+func (q *Queries) ListSubtreeNodesForExport(ctx context.Context, rootID string) ([]models.Node, error) {
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id, 0 AS depth FROM nodes WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, s.depth + 1
+			FROM nodes n
+			JOIN subtree s ON n.parent_id = s.id
+		)
+		SELECT n.id, n.owner_id, n.parent_id, n.name, n.node_type, n.size_bytes, n.mime_type, n.created_at, n.modified_at, n.deleted_at
+		FROM subtree s
+		JOIN nodes n ON n.id = s.id
+		ORDER BY s.depth, n.name, n.id
+	`
+	rows, err := q.db.Query(ctx, query, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt, &node.DeletedAt); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// ListAccessLogForNodes returns every access log entry of the given nodes,
+// oldest first.
+func (q *Queries) ListAccessLogForNodes(ctx context.Context, nodeIDs []string) ([]AccessLogEntry, error) {
+	query := `
+		SELECT a.id, a.user_id, u.username, a.node_id, a.action, a.client_ip, a.user_agent, a.accessed_at
+		FROM access_log a
+		LEFT JOIN users u ON a.user_id = u.id
+		WHERE a.node_id = ANY($1)
+		ORDER BY a.accessed_at, a.id
+	`
+	rows, err := q.db.Query(ctx, query, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AccessLogEntry{}
+	for rows.Next() {
+		var entry AccessLogEntry
+		err := rows.Scan(
+			&entry.ID, &entry.UserID, &entry.Username, &entry.NodeID, &entry.Action,
+			&entry.ClientIP, &entry.UserAgent, &entry.AccessedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+type Announcement struct {
+	ID        int64      `json:"id" example:"1"`
+	Message   string     `json:"message" example:"Przerwa techniczna w sobotę 22:00-23:00"`
+	Level     string     `json:"level" example:"warning"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	CreatedBy *int64     `json:"created_by,omitempty" example:"1"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const announcementColumns = `id, message, level, starts_at, ends_at, created_by, created_at`
+
+func scanAnnouncements(rows pgx.Rows) ([]Announcement, error) {
+	defer rows.Close()
+	announcements := []Announcement{}
+	for rows.Next() {
+		var a Announcement
+		if err := rows.Scan(&a.ID, &a.Message, &a.Level, &a.StartsAt, &a.EndsAt, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+type CreateAnnouncementParams struct {
+	Message   string
+	Level     string
+	StartsAt  *time.Time
+	EndsAt    *time.Time
+	CreatedBy int64
+}
+
+// CreateAnnouncement stores an announcement; without StartsAt it starts
+// immediately.
+func (q *Queries) CreateAnnouncement(ctx context.Context, arg CreateAnnouncementParams) (*Announcement, error) {
+	query := `
+		INSERT INTO announcements (message, level, starts_at, ends_at, created_by)
+		VALUES ($1, $2, COALESCE($3, NOW()), $4, $5)
+		RETURNING ` + announcementColumns
+	var a Announcement
+	err := q.db.QueryRow(ctx, query, arg.Message, arg.Level, arg.StartsAt, arg.EndsAt, arg.CreatedBy).Scan(
+		&a.ID, &a.Message, &a.Level, &a.StartsAt, &a.EndsAt, &a.CreatedBy, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ListActiveAnnouncements returns the announcements shown right now, the most
+// severe and then the newest first.
+func (q *Queries) ListActiveAnnouncements(ctx context.Context) ([]Announcement, error) {
+	query := `
+		SELECT ` + announcementColumns + `
+		FROM announcements
+		WHERE starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW())
+		ORDER BY CASE level WHEN 'critical' THEN 0 WHEN 'warning' THEN 1 ELSE 2 END, starts_at DESC, id DESC
+	`
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanAnnouncements(rows)
+}
+
+// ListAnnouncements returns all announcements, including scheduled and
+// expired ones, newest first.
+func (q *Queries) ListAnnouncements(ctx context.Context, limit int, offset int) ([]Announcement, error) {
+	query := `SELECT ` + announcementColumns + ` FROM announcements ORDER BY created_at DESC, id DESC LIMIT $1 OFFSET $2`
+	rows, err := q.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanAnnouncements(rows)
+}
+
+// ClaimDueAnnouncements marks active announcements that have not been
+// published yet as published and returns them.
+func (q *Queries) ClaimDueAnnouncements(ctx context.Context) ([]Announcement, error) {
+	query := `
+		UPDATE announcements
+		SET published_at = NOW()
+		WHERE published_at IS NULL AND starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW())
+		RETURNING ` + announcementColumns
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanAnnouncements(rows)
+}
+
+func (q *Queries) DeleteAnnouncement(ctx context.Context, id int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM announcements WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetOnboardingTemplates replaces the nodes copied into the root of new users.
+func (q *Queries) SetOnboardingTemplates(ctx context.Context, addedBy int64, nodeIDs []string) error {
+	if _, err := q.db.Exec(ctx, `DELETE FROM onboarding_templates`); err != nil {
+		return err
+	}
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+	_, err := q.db.Exec(ctx, `
+		INSERT INTO onboarding_templates (node_id, added_by)
+		SELECT node_id, $2 FROM unnest($1::varchar[]) AS node_id
+	`, nodeIDs, addedBy)
+	return err
+}
+
+// ListOnboardingTemplates returns the template nodes that are not in the
+// trash, by name.
+func (q *Queries) ListOnboardingTemplates(ctx context.Context) ([]models.Node, error) {
+	query := `
+		SELECT n.id, n.owner_id, n.parent_id, n.name, n.node_type, n.size_bytes, n.mime_type, n.created_at, n.modified_at
+		FROM onboarding_templates t
+		JOIN nodes n ON n.id = t.node_id
+		WHERE n.deleted_at IS NULL
+		ORDER BY n.name, n.id
+	`
+	rows, err := q.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []models.Node{}
+	for rows.Next() {
+		var node models.Node
+		if err := rows.Scan(&node.ID, &node.OwnerID, &node.ParentID, &node.Name, &node.NodeType, &node.SizeBytes, &node.MimeType, &node.CreatedAt, &node.ModifiedAt); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// ClaimUsersToOnboard marks up to limit users that have not been onboarded
+// yet as onboarded and returns their IDs.
+func (q *Queries) ClaimUsersToOnboard(ctx context.Context, limit int) ([]int64, error) {
+	query := `
+		UPDATE users SET onboarded_at = NOW()
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE onboarded_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+	rows, err := q.db.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+// FederatedShare is a share of a local node with a user of a peer instance.
+type FederatedShare struct {
+	ID        int64     `json:"id" example:"1"`
+	Token     string    `json:"-"`
+	NodeID    string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	SharerID  int64     `json:"sharer_id" example:"1"`
+	Peer      string    `json:"peer" example:"partner"`
+	Recipient string    `json:"recipient" example:"anna"`
+	SharedAt  time.Time `json:"shared_at"`
+}
+
+const federatedShareColumns = `id, token, node_id, sharer_id, peer, recipient, shared_at`
+
+func scanFederatedShare(row pgx.Row) (*FederatedShare, error) {
+	var share FederatedShare
+	err := row.Scan(&share.ID, &share.Token, &share.NodeID, &share.SharerID, &share.Peer, &share.Recipient, &share.SharedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (q *Queries) CreateFederatedShare(ctx context.Context, token, nodeID string, sharerID int64, peer, recipient string) (*FederatedShare, error) {
+	query := `
+		INSERT INTO federated_shares (token, node_id, sharer_id, peer, recipient)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + federatedShareColumns
+	return scanFederatedShare(q.db.QueryRow(ctx, query, token, nodeID, sharerID, peer, recipient))
+}
+
+// GetFederatedShareByToken returns the share a peer refers to, or nil when
+// the token does not belong to a share with that peer.
+func (q *Queries) GetFederatedShareByToken(ctx context.Context, peer, token string) (*FederatedShare, error) {
+	query := `SELECT ` + federatedShareColumns + ` FROM federated_shares WHERE token = $1 AND peer = $2`
+	return scanFederatedShare(q.db.QueryRow(ctx, query, token, peer))
+}
+
+func (q *Queries) ListFederatedShares(ctx context.Context, sharerID int64, limit int, offset int) ([]FederatedShare, error) {
+	query := `SELECT ` + federatedShareColumns + ` FROM federated_shares WHERE sharer_id = $1 ORDER BY shared_at DESC, id DESC LIMIT $2 OFFSET $3`
+	rows, err := q.db.Query(ctx, query, sharerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []FederatedShare{}
+	for rows.Next() {
+		share, err := scanFederatedShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteFederatedShare removes a share of the sharer and returns it, or nil
+// when there was none.
+func (q *Queries) DeleteFederatedShare(ctx context.Context, id int64, sharerID int64) (*FederatedShare, error) {
+	query := `DELETE FROM federated_shares WHERE id = $1 AND sharer_id = $2 RETURNING ` + federatedShareColumns
+	return scanFederatedShare(q.db.QueryRow(ctx, query, id, sharerID))
+}
+
+// RemoteShare is a share offered to a local user by a peer instance.
+type RemoteShare struct {
+	ID               int64     `json:"id" example:"1"`
+	Peer             string    `json:"peer" example:"partner"`
+	Token            string    `json:"-"`
+	RecipientID      int64     `json:"recipient_id" example:"2"`
+	Owner            string    `json:"owner" example:"jan"`
+	OwnerDisplayName *string   `json:"owner_display_name,omitempty" example:"Jan Kowalski"`
+	Name             string    `json:"name" example:"Projekty"`
+	NodeType         string    `json:"node_type" example:"folder"`
+	SizeBytes        *int64    `json:"size_bytes,omitempty"`
+	MimeType         *string   `json:"mime_type,omitempty"`
+	Permissions      string    `json:"permissions" example:"read"`
+	SharedAt         time.Time `json:"shared_at"`
+}
+
+const remoteShareColumns = `id, peer, token, recipient_id, owner, owner_display_name, name, node_type, size_bytes, mime_type, permissions, shared_at`
+
+func scanRemoteShare(row pgx.Row) (*RemoteShare, error) {
+	var share RemoteShare
+	err := row.Scan(&share.ID, &share.Peer, &share.Token, &share.RecipientID, &share.Owner, &share.OwnerDisplayName,
+		&share.Name, &share.NodeType, &share.SizeBytes, &share.MimeType, &share.Permissions, &share.SharedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+type CreateRemoteShareParams struct {
+	Peer             string
+	Token            string
+	RecipientID      int64
+	Owner            string
+	OwnerDisplayName *string
+	Name             string
+	NodeType         string
+	SizeBytes        *int64
+	MimeType         *string
+	Permissions      string
+}
+
+// CreateRemoteShare records a share offered by a peer. An offer repeated with
+// the same token updates the existing record.
+func (q *Queries) CreateRemoteShare(ctx context.Context, arg CreateRemoteShareParams) (*RemoteShare, error) {
+	query := `
+		INSERT INTO remote_shares (peer, token, recipient_id, owner, owner_display_name, name, node_type, size_bytes, mime_type, permissions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (peer, token) DO UPDATE
+		SET owner_display_name = EXCLUDED.owner_display_name, name = EXCLUDED.name,
+		    size_bytes = EXCLUDED.size_bytes, mime_type = EXCLUDED.mime_type
+		WHERE remote_shares.recipient_id = EXCLUDED.recipient_id
+		RETURNING ` + remoteShareColumns
+	return scanRemoteShare(q.db.QueryRow(ctx, query, arg.Peer, arg.Token, arg.RecipientID, arg.Owner, arg.OwnerDisplayName,
+		arg.Name, arg.NodeType, arg.SizeBytes, arg.MimeType, arg.Permissions))
+}
+
+func (q *Queries) GetRemoteShare(ctx context.Context, id int64, recipientID int64) (*RemoteShare, error) {
+	query := `SELECT ` + remoteShareColumns + ` FROM remote_shares WHERE id = $1 AND recipient_id = $2`
+	return scanRemoteShare(q.db.QueryRow(ctx, query, id, recipientID))
+}
+
+func (q *Queries) ListRemoteShares(ctx context.Context, recipientID int64, limit int, offset int) ([]RemoteShare, error) {
+	query := `SELECT ` + remoteShareColumns + ` FROM remote_shares WHERE recipient_id = $1 ORDER BY shared_at DESC, id DESC LIMIT $2 OFFSET $3`
+	rows, err := q.db.Query(ctx, query, recipientID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []RemoteShare{}
+	for rows.Next() {
+		share, err := scanRemoteShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteRemoteShareByToken removes a share revoked by its peer and returns
+// it, or nil when there was none.
+func (q *Queries) DeleteRemoteShareByToken(ctx context.Context, peer, token string) (*RemoteShare, error) {
+	query := `DELETE FROM remote_shares WHERE peer = $1 AND token = $2 RETURNING ` + remoteShareColumns
+	return scanRemoteShare(q.db.QueryRow(ctx, query, peer, token))
+}
+
+// DirectoryAccount is a user account synchronized from an LDAP directory.
+type DirectoryAccount struct {
+	ID          int64
+	Username    string
+	LDAPDN      string
+	DisplayName *string
+	DisabledAt  *time.Time
+}
+
+func (q *Queries) ListDirectoryAccounts(ctx context.Context) ([]DirectoryAccount, error) {
+	rows, err := q.db.Query(ctx, `
+		SELECT id, username, ldap_dn, display_name, disabled_at
+		FROM users
+		WHERE ldap_dn IS NOT NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []DirectoryAccount{}
+	for rows.Next() {
+		var account DirectoryAccount
+		if err := rows.Scan(&account.ID, &account.Username, &account.LDAPDN, &account.DisplayName, &account.DisabledAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// CreateDirectoryAccount creates an account for a directory entry. The
+// account has no usable local password. It returns false when the username
+// or the DN is already taken.
+func (q *Queries) CreateDirectoryAccount(ctx context.Context, username string, displayName *string, dn string) (bool, error) {
+	tag, err := q.db.Exec(ctx, `
+		INSERT INTO users (username, password_hash, display_name, ldap_dn)
+		VALUES ($1, '!', $2, $3)
+		ON CONFLICT DO NOTHING
+	`, username, displayName, dn)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UpdateDirectoryAccount applies the directory's username and display name
+// to an account and enables it again if it was disabled.
+func (q *Queries) UpdateDirectoryAccount(ctx context.Context, id int64, username string, displayName *string) error {
+	_, err := q.db.Exec(ctx, `
+		UPDATE users SET username = $2, display_name = $3, disabled_at = NULL
+		WHERE id = $1
+	`, id, username, displayName)
+	return err
+}
+
+// DisableUser stops an account from signing in. It returns false when the
+// account was already disabled.
+func (q *Queries) DisableUser(ctx context.Context, id int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `UPDATE users SET disabled_at = NOW() WHERE id = $1 AND disabled_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SetNodeContentSHA256 records the checksum computed for a file stored
+// without one, unless its content changed since modifiedAt.
+func (q *Queries) SetNodeContentSHA256(ctx context.Context, id string, modifiedAt time.Time, contentSHA256 string) error {
+	_, err := q.db.Exec(ctx, `
+		UPDATE nodes SET content_sha256 = $3
+		WHERE id = $1 AND modified_at = $2 AND content_sha256 IS NULL
+	`, id, modifiedAt, contentSHA256)
+	return err
+}
+
+// QuarantinedNode is a file held by the content policy.
+type QuarantinedNode struct {
+	NodeID        string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID       int64     `json:"owner_id" example:"2"`
+	Name          string    `json:"name" example:"faktura.exe"`
+	MimeType      *string   `json:"mime_type,omitempty" example:"application/x-executable"`
+	SizeBytes     *int64    `json:"size_bytes,omitempty" example:"4096"`
+	Action        string    `json:"action" example:"upload"`
+	Rule          string    `json:"rule" example:"wykonywalne"`
+	Reason        string    `json:"reason" example:"Executables are reviewed before they can be shared"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// QuarantineNode holds a file for review, replacing an earlier quarantine
+// record of the same file.
+func (q *Queries) QuarantineNode(ctx context.Context, nodeID, action, rule, reason string) error {
+	_, err := q.db.Exec(ctx, `
+		INSERT INTO quarantined_nodes (node_id, action, rule, reason)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (node_id) DO UPDATE
+		SET action = EXCLUDED.action, rule = EXCLUDED.rule, reason = EXCLUDED.reason, quarantined_at = NOW()
+	`, nodeID, action, rule, reason)
+	return err
+}
+
+// ReleaseQuarantinedNode lifts the quarantine of a file and returns the
+// record that was removed, or nil when the file was not quarantined.
+func (q *Queries) ReleaseQuarantinedNode(ctx context.Context, nodeID string) (*QuarantinedNode, error) {
+	var node QuarantinedNode
+	err := q.db.QueryRow(ctx, `
+		DELETE FROM quarantined_nodes qn
+		USING nodes n
+		WHERE qn.node_id = $1 AND n.id = qn.node_id
+		RETURNING n.id, n.owner_id, n.name, n.mime_type, n.size_bytes, qn.action, qn.rule, qn.reason, qn.quarantined_at
+	`, nodeID).Scan(&node.NodeID, &node.OwnerID, &node.Name, &node.MimeType, &node.SizeBytes, &node.Action, &node.Rule, &node.Reason, &node.QuarantinedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &node, nil
+}
+
+// QuarantinedNodeIDs returns which of the given nodes are quarantined.
+func (q *Queries) QuarantinedNodeIDs(ctx context.Context, nodeIDs []string) ([]string, error) {
+	rows, err := q.db.Query(ctx, `SELECT node_id FROM quarantined_nodes WHERE node_id = ANY($1)`, nodeIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quarantined := []string{}
+	for rows.Next() {
+		var nodeID string
+		if err := rows.Scan(&nodeID); err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, nodeID)
+	}
+	return quarantined, rows.Err()
+}
+
+// ListQuarantinedNodes returns quarantined files, most recent first.
+func (q *Queries) ListQuarantinedNodes(ctx context.Context, limit int, offset int) ([]QuarantinedNode, error) {
+	rows, err := q.db.Query(ctx, `
+		SELECT n.id, n.owner_id, n.name, n.mime_type, n.size_bytes, qn.action, qn.rule, qn.reason, qn.quarantined_at
+		FROM quarantined_nodes qn
+		JOIN nodes n ON n.id = qn.node_id
+		ORDER BY qn.quarantined_at DESC, n.id
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []QuarantinedNode{}
+	for rows.Next() {
+		var node QuarantinedNode
+		if err := rows.Scan(&node.NodeID, &node.OwnerID, &node.Name, &node.MimeType, &node.SizeBytes, &node.Action, &node.Rule, &node.Reason, &node.QuarantinedAt); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// SetNodeStorageKey points a file at the shared content blob holding its
+// content; nil means its own blob stored under the node ID.
+func (q *Queries) SetNodeStorageKey(ctx context.Context, id string, key *string) error {
+	_, err := q.db.Exec(ctx, `UPDATE nodes SET storage_key = $2 WHERE id = $1`, id, key)
+	return err
+}
+
+// AcquireContentBlob adds a reference to the content blob stored under key in
+// a backend, registering the blob if it is new, and returns the number of
+// references. One means the caller has to store the content.
+func (q *Queries) AcquireContentBlob(ctx context.Context, backend, key string, sizeBytes int64) (int, error) {
+	query := `
+		INSERT INTO content_blobs (storage_backend, storage_key, size_bytes, ref_count)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (storage_backend, storage_key) DO UPDATE SET ref_count = content_blobs.ref_count + 1
+		RETURNING ref_count
+	`
+	var refs int
+	err := q.db.QueryRow(ctx, query, backend, key, sizeBytes).Scan(&refs)
+	return refs, err
+}
+
+// ReleaseContentBlob removes a reference to a content blob and returns the
+// number of references left. The blob is unregistered when none are left, and
+// the caller has to remove its content.
+func (q *Queries) ReleaseContentBlob(ctx context.Context, backend, key string) (int, error) {
+	query := `
+		UPDATE content_blobs SET ref_count = ref_count - 1
+		WHERE storage_backend = $1 AND storage_key = $2
+		RETURNING ref_count
+	`
+	var refs int
+	err := q.db.QueryRow(ctx, query, backend, key).Scan(&refs)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil || refs > 0 {
+		return refs, err
+	}
+	_, err = q.db.Exec(ctx, `DELETE FROM content_blobs WHERE storage_backend = $1 AND storage_key = $2`, backend, key)
+	return 0, err
+}
+
+// ContentBlobRegistered reports whether a content blob is registered.
+func (q *Queries) ContentBlobRegistered(ctx context.Context, backend, key string) (bool, error) {
+	var registered bool
+	err := q.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM content_blobs WHERE storage_backend = $1 AND storage_key = $2)`, backend, key).Scan(&registered)
+	return registered, err
+}
+
+// ContentBlob identifies a deduplicated content blob.
+type ContentBlob struct {
+	StorageBackend string
+	StorageKey     string
+}
+
+// ReleaseTrashedContentBlobs removes the references the deduplicated files in
+// the owner's trash hold, ahead of a purge, and returns the blobs no longer
+// referenced. They stay registered with no references until removed with
+// DeleteUnreferencedContentBlob, which an upload of the same content in the
+// meantime prevents.
+func (q *Queries) ReleaseTrashedContentBlobs(ctx context.Context, ownerID int64) ([]ContentBlob, error) {
+	query := `
+		WITH released AS (
+			SELECT storage_backend, storage_key, count(*) AS refs FROM nodes
+			WHERE owner_id = $1 AND deleted_at IS NOT NULL AND node_type = 'file' AND storage_key IS NOT NULL
+			GROUP BY storage_backend, storage_key
+		)
+		UPDATE content_blobs b SET ref_count = GREATEST(b.ref_count - r.refs, 0)
+		FROM released r
+		WHERE b.storage_backend = r.storage_backend AND b.storage_key = r.storage_key
+		RETURNING b.storage_backend, b.storage_key, b.ref_count
+	`
+	rows, err := q.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unreferenced := []ContentBlob{}
+	for rows.Next() {
+		var blob ContentBlob
+		var refs int
+		if err := rows.Scan(&blob.StorageBackend, &blob.StorageKey, &refs); err != nil {
+			return nil, err
+		}
+		if refs == 0 {
+			unreferenced = append(unreferenced, blob)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return unreferenced, nil
+}
+
+// DeleteUnreferencedContentBlob unregisters a content blob left without
+// references by ReleaseTrashedContentBlobs and reports whether it did. The row
+// stays locked until the transaction ends, so the caller removes the content
+// before committing, while uploads of the same content wait.
+func (q *Queries) DeleteUnreferencedContentBlob(ctx context.Context, backend, key string) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM content_blobs WHERE storage_backend = $1 AND storage_key = $2 AND ref_count = 0`, backend, key)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// OfflinePin is a node a device of the user keeps available offline.
+type OfflinePin struct {
+	models.Node
+	DeviceID string    `json:"device_id" example:"laptop-anna"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// PinNodeOffline marks a node the user can access as kept offline on a
+// device. It reports whether the pin is new; pinning again is a no-op.
+func (q *Queries) PinNodeOffline(ctx context.Context, userID int64, deviceID, nodeID string) (bool, error) {
+	node, err := q.GetNodeIfAccessible(ctx, nodeID, userID)
+	if err != nil {
+		return false, err
+	}
+	if node == nil {
+		return false, ErrNodeNotFound
+	}
+
+	query := `INSERT INTO offline_pins (user_id, device_id, node_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	res, err := q.db.Exec(ctx, query, userID, deviceID, nodeID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (q *Queries) UnpinNodeOffline(ctx context.Context, userID int64, deviceID, nodeID string) (bool, error) {
+	query := `DELETE FROM offline_pins WHERE user_id = $1 AND device_id = $2 AND node_id = $3`
+	res, err := q.db.Exec(ctx, query, userID, deviceID, nodeID)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// ListOfflinePins returns the user's pins of nodes that are not in the trash,
+// of one device or, with an empty deviceID, of all devices. As with
+// favorites, pins of nodes the user can no longer access are left out.
+func (q *Queries) ListOfflinePins(ctx context.Context, userID int64, deviceID string, limit int, offset int) ([]OfflinePin, error) {
+	query := `
+		WITH RECURSIVE pin_ancestors AS (
+			SELECT p.node_id, n.id AS ancestor_id, n.parent_id
+			FROM offline_pins p
+			JOIN nodes n ON n.id = p.node_id
+			WHERE p.user_id = $1 AND n.owner_id <> $1
+
+			UNION ALL
+
+			SELECT pa.node_id, a.id, a.parent_id
+			FROM pin_ancestors pa
+			JOIN nodes a ON a.id = pa.parent_id
+		)
+		SELECT
+			n.id, n.owner_id, n.parent_id, n.name, n.node_type,
+			n.size_bytes, n.mime_type, n.created_at, n.modified_at, n.content_sha256,
+			p.device_id, p.pinned_at
+		FROM nodes n
+		JOIN offline_pins p ON n.id = p.node_id
+		WHERE p.user_id = $1 AND ($2 = '' OR p.device_id = $2) AND n.deleted_at IS NULL
+		  AND (
+			n.owner_id = $1
+			OR EXISTS (
+				SELECT 1
+				FROM pin_ancestors pa
+				JOIN share_grants s ON s.node_id = pa.ancestor_id
+				WHERE pa.node_id = n.id AND s.recipient_id = $1
+			)
+		  )
+		ORDER BY p.device_id, n.name LIMIT $3 OFFSET $4
+	`
+	rows, err := q.db.Query(ctx, query, userID, deviceID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []OfflinePin{}
+	for rows.Next() {
+		var pin OfflinePin
+		err := rows.Scan(
+			&pin.ID, &pin.OwnerID, &pin.ParentID, &pin.Name, &pin.NodeType,
+			&pin.SizeBytes, &pin.MimeType, &pin.CreatedAt, &pin.ModifiedAt, &pin.ContentSHA256,
+			&pin.DeviceID, &pin.PinnedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// FindOwnedContent looks for deduplicated content with the given checksum and
+// size among the files of a user, including those in the trash, and returns
+// where it is stored together with the type detected for it, or nil when the
+// user stores no such content.
+func (q *Queries) FindOwnedContent(ctx context.Context, ownerID int64, contentSHA256 string, sizeBytes int64) (*ContentBlob, *string, error) {
+	query := `
+		SELECT storage_backend, storage_key, mime_type FROM nodes
+		WHERE owner_id = $1 AND node_type = 'file' AND content_sha256 = $2 AND size_bytes = $3 AND storage_key IS NOT NULL
+		ORDER BY deleted_at NULLS FIRST
+		LIMIT 1
+	`
+	var blob ContentBlob
+	var mimeType *string
+	err := q.db.QueryRow(ctx, query, ownerID, contentSHA256, sizeBytes).Scan(&blob.StorageBackend, &blob.StorageKey, &mimeType)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return &blob, mimeType, nil
+}
+
+const (
+	URLImportQueued    = "queued"
+	URLImportRunning   = "running"
+	URLImportCompleted = "completed"
+	URLImportFailed    = "failed"
+)
+
+// URLImport is a file the server fetches from a remote URL into a folder.
+type URLImport struct {
+	ID          uuid.UUID `json:"id"`
+	RequestedBy int64     `json:"-"`
+	ParentID    *string   `json:"parent_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	URL         string    `json:"url" example:"https://example.com/raport.pdf"`
+	// FileName is the name requested for the file; without one the name is
+	// taken from the response or the URL.
+	FileName  *string   `json:"file_name,omitempty" example:"raport.pdf"`
+	Status    string    `json:"status" example:"completed"`
+	NodeID    *string   `json:"node_id,omitempty" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	SizeBytes *int64    `json:"size_bytes,omitempty" example:"1048576"`
+	Error     *string   `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const urlImportColumns = `id, requested_by, parent_id, url, file_name, status, node_id, size_bytes, error, created_at, updated_at`
+
+func scanURLImport(row pgx.Row) (*URLImport, error) {
+	var job URLImport
+	err := row.Scan(&job.ID, &job.RequestedBy, &job.ParentID, &job.URL, &job.FileName, &job.Status, &job.NodeID, &job.SizeBytes,
+		&job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *Queries) CreateURLImport(ctx context.Context, requestedBy int64, parentID *string, url string, fileName *string) (*URLImport, error) {
+	query := `
+		INSERT INTO url_imports (id, requested_by, parent_id, url, file_name)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + urlImportColumns
+	return scanURLImport(q.db.QueryRow(ctx, query, uuid.New(), requestedBy, parentID, url, fileName))
+}
+
+func (q *Queries) GetURLImport(ctx context.Context, id uuid.UUID, requestedBy int64) (*URLImport, error) {
+	query := `SELECT ` + urlImportColumns + ` FROM url_imports WHERE id = $1 AND requested_by = $2`
+	return scanURLImport(q.db.QueryRow(ctx, query, id, requestedBy))
+}
+
+// ClaimURLImport marks the oldest queued URL import as running and returns
+// it, or nil when the queue is empty. Imports left running for longer than
+// staleAfter are claimed again.
+func (q *Queries) ClaimURLImport(ctx context.Context, staleAfter time.Duration) (*URLImport, error) {
+	query := `
+		UPDATE url_imports
+		SET status = 'running', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM url_imports
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + urlImportColumns
+	return scanURLImport(q.db.QueryRow(ctx, query, time.Now().Add(-staleAfter)))
+}
+
+func (q *Queries) UpdateURLImport(ctx context.Context, id uuid.UUID, status string, nodeID *string, sizeBytes *int64, errorMessage *string) (*URLImport, error) {
+	query := `
+		UPDATE url_imports
+		SET status = $2, node_id = $3, size_bytes = $4, error = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + urlImportColumns
+	return scanURLImport(q.db.QueryRow(ctx, query, id, status, nodeID, sizeBytes, errorMessage))
+}
+
+// OutgoingShareStats sums up what a user's shares expose.
+type OutgoingShareStats struct {
+	Shares int64 `json:"shares" example:"12"`
+	// SharedNodes counts the distinct files and folders shared.
+	SharedNodes int64 `json:"shared_nodes" example:"8"`
+	Recipients  int64 `json:"recipients" example:"3"`
+	// SharedFiles and SharedBytes count the user's own files reachable
+	// through the shares, below shared folders included, each once however
+	// many shares reach it. Trashed files are left out.
+	SharedFiles int64 `json:"shared_files" example:"140"`
+	SharedBytes int64 `json:"shared_bytes" example:"524288000"`
+}
+
+func (q *Queries) GetOutgoingShareStats(ctx context.Context, sharerID int64, filter OutgoingShareFilter) (*OutgoingShareStats, error) {
+	query := `
+		WITH RECURSIVE filtered AS (
+			SELECT s.node_id, s.recipient_id
+			FROM shares s
+			JOIN users u ON s.recipient_id = u.id
+			WHERE s.sharer_id = $1
+			  AND ($2::text IS NULL OR u.username = $2)
+			  AND ($3::text IS NULL OR s.permissions = $3)
+		),
+		exposed AS (
+			SELECT n.id, n.node_type, n.size_bytes
+			FROM nodes n
+			WHERE n.id IN (SELECT node_id FROM filtered) AND n.owner_id = $1 AND n.deleted_at IS NULL
+
+			UNION
+
+			SELECT c.id, c.node_type, c.size_bytes
+			FROM nodes c
+			JOIN exposed e ON c.parent_id = e.id
+			WHERE c.deleted_at IS NULL
+		)
+		SELECT
+			(SELECT COUNT(*) FROM filtered),
+			(SELECT COUNT(DISTINCT node_id) FROM filtered),
+			(SELECT COUNT(DISTINCT recipient_id) FROM filtered),
+			(SELECT COUNT(*) FROM exposed WHERE node_type = 'file'),
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM exposed WHERE node_type = 'file')
+	`
+	var stats OutgoingShareStats
+	err := q.db.QueryRow(ctx, query, sharerID, filter.RecipientUsername, filter.Permissions).Scan(
+		&stats.Shares, &stats.SharedNodes, &stats.Recipients, &stats.SharedFiles, &stats.SharedBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// StaleShare is a share due for review, with the last time its recipient
+// opened anything in it; LastActivityAt is nil when no access is on record.
+type StaleShare struct {
+	OutgoingShare
+	LastActivityAt *time.Time `json:"last_activity_at"`
+}
+
+// ListStaleShares returns shares created before sharedBefore, not included
+// in a review reminder since remindedBefore, whose recipient has accessed
+// neither the shared node nor anything below it since inactiveSince. The
+// shares are ordered by sharer so they can be grouped into one reminder each.
+func (q *Queries) ListStaleShares(ctx context.Context, sharedBefore, inactiveSince, remindedBefore time.Time, limit int) ([]StaleShare, error) {
+	query := `
+		WITH RECURSIVE candidates AS (
+			SELECT id, node_id, recipient_id FROM shares
+			WHERE shared_at < $1 AND (review_reminded_at IS NULL OR review_reminded_at < $3)
+		), reach AS (
+			SELECT c.id AS share_id, c.node_id FROM candidates c
+			UNION
+			SELECT r.share_id, n.id FROM nodes n JOIN reach r ON n.parent_id = r.node_id
+		), activity AS (
+			SELECT r.share_id, MAX(a.accessed_at) AS last_at
+			FROM reach r
+			JOIN candidates c ON c.id = r.share_id
+			JOIN access_log a ON a.node_id = r.node_id AND a.user_id = c.recipient_id
+			GROUP BY r.share_id
+		)
+		SELECT
+			s.id, s.node_id, s.sharer_id, s.recipient_id, s.permissions, s.message, s.pinned_version, s.shared_at,
+			n.name, n.node_type, u.username, act.last_at
+		FROM candidates c
+		JOIN shares s ON s.id = c.id
+		JOIN nodes n ON s.node_id = n.id
+		JOIN users u ON s.recipient_id = u.id
+		LEFT JOIN activity act ON act.share_id = s.id
+		WHERE act.last_at IS NULL OR act.last_at < $2
+		ORDER BY s.sharer_id, s.shared_at, s.id
+		LIMIT $4
+	`
+	rows, err := q.db.Query(ctx, query, sharedBefore, inactiveSince, remindedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []StaleShare
+	for rows.Next() {
+		var share StaleShare
+		err := rows.Scan(
+			&share.ID, &share.NodeID, &share.SharerID, &share.RecipientID, &share.Permissions, &share.Message, &share.PinnedVersion, &share.SharedAt,
+			&share.NodeName, &share.NodeType, &share.RecipientUsername, &share.LastActivityAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (q *Queries) MarkSharesReviewReminded(ctx context.Context, shareIDs []int64, at time.Time) error {
+	query := `UPDATE shares SET review_reminded_at = $2 WHERE id = ANY($1)`
+	_, err := q.db.Exec(ctx, query, shareIDs, at)
+	return err
+}
+
+// ListAdminIDs returns the IDs of the administrators whose accounts are not
+// disabled.
+func (q *Queries) ListAdminIDs(ctx context.Context) ([]int64, error) {
+	rows, err := q.db.Query(ctx, `SELECT id FROM users WHERE is_admin AND disabled_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// StorageBreakdown splits the storage of a user between live files, the trash
+// and archived versions, and counts the entries that take no space.
+type StorageBreakdown struct {
+	LiveFiles      int64
+	LiveBytes      int64
+	ZeroByteFiles  int64
+	Folders        int64
+	EmptyFolders   int64
+	TrashedFiles   int64
+	TrashedFolders int64
+	TrashedBytes   int64
+	Versions       int64
+	VersionBytes   int64
+}
+
+func (q *Queries) GetStorageBreakdown(ctx context.Context, ownerID int64) (*StorageBreakdown, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE n.deleted_at IS NULL AND n.node_type = 'file'),
+			COALESCE(SUM(n.size_bytes) FILTER (WHERE n.deleted_at IS NULL AND n.node_type = 'file'), 0),
+			COUNT(*) FILTER (WHERE n.deleted_at IS NULL AND n.node_type = 'file' AND COALESCE(n.size_bytes, 0) = 0),
+			COUNT(*) FILTER (WHERE n.deleted_at IS NULL AND n.node_type = 'folder'),
+			COUNT(*) FILTER (WHERE n.deleted_at IS NULL AND n.node_type = 'folder' AND NOT EXISTS (
+				SELECT 1 FROM nodes c WHERE c.parent_id = n.id AND c.deleted_at IS NULL
+			)),
+			COUNT(*) FILTER (WHERE n.deleted_at IS NOT NULL AND n.node_type = 'file'),
+			COUNT(*) FILTER (WHERE n.deleted_at IS NOT NULL AND n.node_type = 'folder'),
+			COALESCE(SUM(n.size_bytes) FILTER (WHERE n.deleted_at IS NOT NULL AND n.node_type = 'file'), 0)
+		FROM nodes n
+		WHERE n.owner_id = $1
+	`
+	var breakdown StorageBreakdown
+	err := q.db.QueryRow(ctx, query, ownerID).Scan(
+		&breakdown.LiveFiles,
+		&breakdown.LiveBytes,
+		&breakdown.ZeroByteFiles,
+		&breakdown.Folders,
+		&breakdown.EmptyFolders,
+		&breakdown.TrashedFiles,
+		&breakdown.TrashedFolders,
+		&breakdown.TrashedBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := q.GetVersionStorageUsage(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	breakdown.Versions = versions.Count
+	breakdown.VersionBytes = versions.Bytes
+	return &breakdown, nil
+}
+
+// ListUserIDs returns the IDs of all users.
+func (q *Queries) ListUserIDs(ctx context.Context) ([]int64, error) {
+	rows, err := q.db.Query(ctx, `SELECT id FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ReconcileStorageUsage recomputes the storage used by a user from their files,
+// leaving trashed files out when excludeTrash is set, and stores it when it
+// differs from the recorded value. It returns the recorded and the recomputed
+// usage. It must run in a transaction: the user row is locked first, so
+// uploads committed meanwhile are either seen by the sum or applied on top of
+// it afterwards.
+func (q *Queries) ReconcileStorageUsage(ctx context.Context, userID int64, excludeTrash bool) (int64, int64, error) {
+	var previous int64
+	err := q.db.QueryRow(ctx, `SELECT storage_used_bytes FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&previous)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	query := `
+		SELECT COALESCE(SUM(size_bytes), 0)
+		FROM nodes
+		WHERE owner_id = $1 AND node_type = 'file' AND (NOT $2::boolean OR deleted_at IS NULL)
+	`
+	var current int64
+	if err := q.db.QueryRow(ctx, query, userID, excludeTrash).Scan(&current); err != nil {
+		return 0, 0, err
+	}
+
+	if current != previous {
+		if _, err := q.db.Exec(ctx, `UPDATE users SET storage_used_bytes = $1 WHERE id = $2`, current, userID); err != nil {
+			return 0, 0, err
+		}
+	}
+	return previous, current, nil
+}
+
+// FeatureFlagOverride is a flag state set by an administrator, replacing the
+// configured one.
+type FeatureFlagOverride struct {
+	Name           string    `json:"name" example:"resumable_uploads"`
+	Enabled        bool      `json:"enabled" example:"true"`
+	RolloutPercent int       `json:"rollout_percent" example:"25"`
+	UpdatedBy      *int64    `json:"updated_by,omitempty" example:"1"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (q *Queries) ListFeatureFlagOverrides(ctx context.Context) ([]FeatureFlagOverride, error) {
+	rows, err := q.db.Query(ctx, `SELECT name, enabled, rollout_percent, updated_by, updated_at FROM feature_flags ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []FeatureFlagOverride{}
+	for rows.Next() {
+		var o FeatureFlagOverride
+		if err := rows.Scan(&o.Name, &o.Enabled, &o.RolloutPercent, &o.UpdatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+func (q *Queries) GetFeatureFlagOverride(ctx context.Context, name string) (*FeatureFlagOverride, error) {
+	var o FeatureFlagOverride
+	err := q.db.QueryRow(ctx, `SELECT name, enabled, rollout_percent, updated_by, updated_at FROM feature_flags WHERE name = $1`, name).
+		Scan(&o.Name, &o.Enabled, &o.RolloutPercent, &o.UpdatedBy, &o.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (q *Queries) SetFeatureFlagOverride(ctx context.Context, name string, enabled bool, rolloutPercent int, updatedBy int64) (*FeatureFlagOverride, error) {
+	query := `
+		INSERT INTO feature_flags (name, enabled, rollout_percent, updated_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE
+		SET enabled = EXCLUDED.enabled, rollout_percent = EXCLUDED.rollout_percent,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING name, enabled, rollout_percent, updated_by, updated_at
+	`
+	var o FeatureFlagOverride
+	err := q.db.QueryRow(ctx, query, name, enabled, rolloutPercent, updatedBy).
+		Scan(&o.Name, &o.Enabled, &o.RolloutPercent, &o.UpdatedBy, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (q *Queries) DeleteFeatureFlagOverride(ctx context.Context, name string) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM feature_flags WHERE name = $1`, name)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// FeatureFlagUser forces a flag on or off for one user, whatever its rollout.
+type FeatureFlagUser struct {
+	UserID    int64     `json:"user_id" example:"2"`
+	Username  string    `json:"username" example:"jkowalski"`
+	Enabled   bool      `json:"enabled" example:"true"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (q *Queries) ListFeatureFlagUsers(ctx context.Context, name string) ([]FeatureFlagUser, error) {
+	query := `
+		SELECT f.user_id, u.username, f.enabled, f.created_at
+		FROM feature_flag_users f
+		JOIN users u ON u.id = f.user_id
+		WHERE f.flag_name = $1
+		ORDER BY u.username
+	`
+	rows, err := q.db.Query(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []FeatureFlagUser{}
+	for rows.Next() {
+		var u FeatureFlagUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Enabled, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetFeatureFlagUserOverrides returns the flags forced on or off for a user.
+func (q *Queries) GetFeatureFlagUserOverrides(ctx context.Context, userID int64) (map[string]bool, error) {
+	rows, err := q.db.Query(ctx, `SELECT flag_name, enabled FROM feature_flag_users WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, err
+		}
+		overrides[name] = enabled
+	}
+	return overrides, rows.Err()
+}
+
+// GetFeatureFlagUserOverride returns whether a flag is forced on or off for a
+// user, or nil when it is not.
+func (q *Queries) GetFeatureFlagUserOverride(ctx context.Context, name string, userID int64) (*bool, error) {
+	var enabled bool
+	err := q.db.QueryRow(ctx, `SELECT enabled FROM feature_flag_users WHERE flag_name = $1 AND user_id = $2`, name, userID).Scan(&enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &enabled, nil
+}
+
+func (q *Queries) SetFeatureFlagUser(ctx context.Context, name string, userID int64, enabled bool) error {
+	query := `
+		INSERT INTO feature_flag_users (flag_name, user_id, enabled)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (flag_name, user_id) DO UPDATE SET enabled = EXCLUDED.enabled, created_at = NOW()
+	`
+	_, err := q.db.Exec(ctx, query, name, userID, enabled)
+	return err
+}
+
+func (q *Queries) DeleteFeatureFlagUser(ctx context.Context, name string, userID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM feature_flag_users WHERE flag_name = $1 AND user_id = $2`, name, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// PublicLink gives anyone with its token read access to a node, or for
+// upload links the right to add files to a folder. The node's name and type
+// are included for listing the owner's links. A link can be protected by a
+// password, expire, allow a limited number of downloads, and cap the bytes
+// it serves; on upload links DownloadCount and MaxDownloads count the
+// uploaded files instead.
+type PublicLink struct {
+	ID             int64      `json:"id" example:"1"`
+	Token          string     `json:"token" example:"q8ZJ3s0bVn2mP6xW1yT4cA9dF7gH5kLr"`
+	NodeID         string     `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	NodeName       string     `json:"node_name" example:"Raport_Q3.pdf"`
+	NodeType       string     `json:"node_type" example:"file"`
+	OwnerID        int64      `json:"owner_id" example:"1"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DownloadCount  int64      `json:"download_count" example:"3"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	PasswordHash   *string    `json:"-"`
+	HasPassword    bool       `json:"has_password" example:"true"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads   *int64     `json:"max_downloads,omitempty" example:"10"`
+	// BytesServed counts the file content downloaded through the link.
+	BytesServed      int64  `json:"bytes_served" example:"52428800"`
+	MaxTransferBytes *int64 `json:"max_transfer_bytes,omitempty" example:"10737418240"`
+	// LinkType is PublicLinkDownload or PublicLinkUpload.
+	LinkType string `json:"link_type" example:"download"`
+	// Slug is a short name that opens the link instead of its token.
+	Slug *string `json:"slug,omitempty" example:"raport-q3"`
+}
+
+// Types of public links. A download link gives read access to a file or
+// folder; an upload link lets visitors add files to a folder without seeing
+// its contents.
+const (
+	PublicLinkDownload = "download"
+	PublicLinkUpload   = "upload"
+)
+
+// Expired reports whether the link's expiry time has passed.
+func (l *PublicLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// Exhausted reports whether the link has used up its downloads.
+func (l *PublicLink) Exhausted() bool {
+	return l.MaxDownloads != nil && l.DownloadCount >= *l.MaxDownloads
+}
+
+// TransferCapReached reports whether the link has served as many bytes as
+// its transfer cap allows. The cap is soft: a download is counted once it
+// ends, so the one crossing the cap is completed.
+func (l *PublicLink) TransferCapReached() bool {
+	return l.MaxTransferBytes != nil && l.BytesServed >= *l.MaxTransferBytes
+}
+
+const publicLinkColumns = `l.id, l.token, l.node_id, n.name, n.node_type, l.owner_id, l.created_at, l.download_count, l.last_accessed_at,
+	l.password_hash, l.expires_at, l.max_downloads, l.bytes_served, l.max_transfer_bytes, l.link_type, l.slug`
+
+func scanPublicLink(row pgx.Row) (*PublicLink, error) {
+	var link PublicLink
+	err := row.Scan(&link.ID, &link.Token, &link.NodeID, &link.NodeName, &link.NodeType, &link.OwnerID,
+		&link.CreatedAt, &link.DownloadCount, &link.LastAccessedAt,
+		&link.PasswordHash, &link.ExpiresAt, &link.MaxDownloads, &link.BytesServed, &link.MaxTransferBytes, &link.LinkType, &link.Slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	link.HasPassword = link.PasswordHash != nil
+	return &link, nil
+}
+
+// PublicLinkSettings are the optional restrictions of a public link. Nil
+// fields leave the link unrestricted.
+type PublicLinkSettings struct {
+	PasswordHash *string
+	ExpiresAt    *time.Time
+	MaxDownloads *int64
+	// MaxTransferBytes caps the bytes served through the link.
+	MaxTransferBytes *int64
+	Slug             *string
+}
+
+// ErrPublicLinkSlugTaken is returned when another link already has the slug.
+var ErrPublicLinkSlugTaken = errors.New("another link already has this slug")
+
+// publicLinkError translates a unique violation of the slug.
+func publicLinkError(link *PublicLink, err error) (*PublicLink, error) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrPublicLinkSlugTaken
+	}
+	return link, err
+}
+
+func (q *Queries) CreatePublicLink(ctx context.Context, token, nodeID string, ownerID int64, linkType string, settings PublicLinkSettings) (*PublicLink, error) {
+	query := `
+		WITH l AS (
+			INSERT INTO public_links (token, node_id, owner_id, link_type, password_hash, expires_at, max_downloads, max_transfer_bytes, slug)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING *
+		)
+		SELECT ` + publicLinkColumns + `
+		FROM l JOIN nodes n ON n.id = l.node_id
+	`
+	return publicLinkError(scanPublicLink(q.db.QueryRow(ctx, query, token, nodeID, ownerID, linkType,
+		settings.PasswordHash, settings.ExpiresAt, settings.MaxDownloads, settings.MaxTransferBytes, settings.Slug)))
+}
+
+// PublicLinkUpdate changes the restrictions of a public link. Each setting is
+// only written when its Set flag is true, so a nil value with the flag set
+// removes the restriction.
+type PublicLinkUpdate struct {
+	SetPassword     bool
+	SetExpiresAt    bool
+	SetMaxDownloads bool
+	// SetMaxTransferBytes also clears the transfer cap notification, so the
+	// owner is told again when the new cap is reached.
+	SetMaxTransferBytes bool
+	SetSlug             bool
+	PublicLinkSettings
+}
+
+// UpdatePublicLink changes a link of the owner, returning nil when there is
+// no such link.
+func (q *Queries) UpdatePublicLink(ctx context.Context, id int64, ownerID int64, update PublicLinkUpdate) (*PublicLink, error) {
+	query := `
+		WITH l AS (
+			UPDATE public_links SET
+				password_hash = CASE WHEN $3::boolean THEN $4::varchar ELSE password_hash END,
+				expires_at = CASE WHEN $5::boolean THEN $6::timestamptz ELSE expires_at END,
+				max_downloads = CASE WHEN $7::boolean THEN $8::integer ELSE max_downloads END,
+				max_transfer_bytes = CASE WHEN $9::boolean THEN $10::bigint ELSE max_transfer_bytes END,
+				transfer_cap_notified_at = CASE WHEN $9::boolean THEN NULL ELSE transfer_cap_notified_at END,
+				slug = CASE WHEN $11::boolean THEN $12::varchar ELSE slug END
+			WHERE id = $1 AND owner_id = $2
+			RETURNING *
+		)
+		SELECT ` + publicLinkColumns + `
+		FROM l JOIN nodes n ON n.id = l.node_id
+	`
+	return publicLinkError(scanPublicLink(q.db.QueryRow(ctx, query, id, ownerID,
+		update.SetPassword, update.PasswordHash,
+		update.SetExpiresAt, update.ExpiresAt,
+		update.SetMaxDownloads, update.MaxDownloads,
+		update.SetMaxTransferBytes, update.MaxTransferBytes,
+		update.SetSlug, update.Slug)))
+}
+
+// GetPublicLinkByToken returns the link with the token or, ignoring case,
+// the slug, or nil when there is none.
+func (q *Queries) GetPublicLinkByToken(ctx context.Context, token string) (*PublicLink, error) {
+	query := `SELECT ` + publicLinkColumns + ` FROM public_links l JOIN nodes n ON n.id = l.node_id WHERE l.token = $1 OR l.slug = LOWER($1)`
+	return scanPublicLink(q.db.QueryRow(ctx, query, token))
+}
+
+func (q *Queries) ListPublicLinks(ctx context.Context, ownerID int64, limit int, offset int) ([]PublicLink, error) {
+	query := `
+		SELECT ` + publicLinkColumns + `
+		FROM public_links l
+		JOIN nodes n ON n.id = l.node_id
+		WHERE l.owner_id = $1
+		ORDER BY l.created_at DESC, l.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := q.db.Query(ctx, query, ownerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []PublicLink{}
+	for rows.Next() {
+		link, err := scanPublicLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+// DeletePublicLink removes a link of the owner and reports whether there was
+// one.
+func (q *Queries) DeletePublicLink(ctx context.Context, id int64, ownerID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM public_links WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordPublicLinkDownload counts a download, or an uploaded file, through a
+// link. It reports false, counting nothing, when the link has expired or used
+// up its downloads in the meantime, so concurrent requests cannot exceed the
+// limit.
+func (q *Queries) RecordPublicLinkDownload(ctx context.Context, id int64) (bool, error) {
+	query := `
+		UPDATE public_links
+		SET download_count = download_count + 1, last_accessed_at = NOW()
+		WHERE id = $1
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		  AND (max_downloads IS NULL OR download_count < max_downloads)
+	`
+	tag, err := q.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordPublicLinkTransfer adds bytes served through a link. It reports
+// true exactly once per cap, for the transfer that made the link reach it, so
+// the owner is notified a single time however many downloads run at once.
+func (q *Queries) RecordPublicLinkTransfer(ctx context.Context, id int64, bytes int64) (bool, error) {
+	query := `
+		WITH updated AS (
+			UPDATE public_links
+			SET bytes_served = bytes_served + $2,
+				transfer_cap_notified_at = CASE
+					WHEN transfer_cap_notified_at IS NULL AND bytes_served + $2 >= max_transfer_bytes THEN NOW()
+					ELSE transfer_cap_notified_at
+				END
+			WHERE id = $1
+			RETURNING transfer_cap_notified_at
+		)
+		SELECT COALESCE(transfer_cap_notified_at = NOW(), false) FROM updated
+	`
+	var capReached bool
+	err := q.db.QueryRow(ctx, query, id, bytes).Scan(&capReached)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return capReached, err
+}
+
+// ListUsers returns the accounts ordered by ID, for administration.
+func (q *Queries) ListUsers(ctx context.Context, limit int, offset int) ([]models.User, error) {
+	query := `
+		SELECT
+			id, username, password_hash, display_name, created_at,
+			storage_quota_bytes, storage_used_bytes, is_admin, ldap_dn, disabled_at
+		FROM users
+		ORDER BY id
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := q.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName, &user.CreatedAt,
+			&user.StorageQuotaBytes, &user.StorageUsedBytes, &user.IsAdmin, &user.LDAPDN, &user.DisabledAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// CreateUser creates a local account. A nil quota keeps the default one. It
+// returns nil when the username is already taken.
+func (q *Queries) CreateUser(ctx context.Context, username, passwordHash string, displayName *string, quotaBytes *int64) (*models.User, error) {
+	query := `
+		INSERT INTO users (username, password_hash, display_name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO NOTHING
+		RETURNING id
+	`
+	args := []interface{}{username, passwordHash, displayName}
+	if quotaBytes != nil {
+		query = `
+			INSERT INTO users (username, password_hash, display_name, storage_quota_bytes)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (username) DO NOTHING
+			RETURNING id
+		`
+		args = append(args, *quotaBytes)
+	}
+	var id int64
+	if err := q.db.QueryRow(ctx, query, args...).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return q.GetUserByID(ctx, id)
+}
+
+// UpdateUserQuota sets the storage quota of an account. It returns false when
+// there is no such account.
+func (q *Queries) UpdateUserQuota(ctx context.Context, id int64, quotaBytes int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `UPDATE users SET storage_quota_bytes = $2 WHERE id = $1`, id, quotaBytes)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// EnableUser lets a disabled account sign in again. It returns false when the
+// account was not disabled.
+func (q *Queries) EnableUser(ctx context.Context, id int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `UPDATE users SET disabled_at = NULL WHERE id = $1 AND disabled_at IS NOT NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SystemStats summarizes the whole server for administrators.
+type SystemStats struct {
+	Users         int64 `json:"users" example:"42"`
+	AdminUsers    int64 `json:"admin_users" example:"2"`
+	DisabledUsers int64 `json:"disabled_users" example:"3"`
+	Files         int64 `json:"files" example:"15320"`
+	Folders       int64 `json:"folders" example:"1204"`
+	UsedBytes     int64 `json:"used_bytes" example:"53687091200"`
+	TrashedFiles  int64 `json:"trashed_files" example:"310"`
+	TrashedBytes  int64 `json:"trashed_bytes" example:"1073741824"`
+	Versions      int64 `json:"versions" example:"2048"`
+	VersionBytes  int64 `json:"version_bytes" example:"4294967296"`
+	QuotaBytes    int64 `json:"quota_bytes" example:"225485783040"`
+	// ChargedBytes is the storage counted against the users' quotas.
+	ChargedBytes   int64 `json:"charged_bytes" example:"54760833024"`
+	Shares         int64 `json:"shares" example:"512"`
+	PublicLinks    int64 `json:"public_links" example:"64"`
+	ActiveSessions int64 `json:"active_sessions" example:"37"`
+	// StoredBlobBytes is the size of the deduplicated content objects.
+	StoredBlobBytes int64 `json:"stored_blob_bytes" example:"48318382080"`
+	PendingUploads  int64 `json:"pending_uploads" example:"4"`
+}
+
+func (q *Queries) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE is_admin),
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE deleted_at IS NULL AND node_type = 'file'),
+			COUNT(*) FILTER (WHERE deleted_at IS NULL AND node_type = 'folder'),
+			COALESCE(SUM(size_bytes) FILTER (WHERE deleted_at IS NULL AND node_type = 'file'), 0),
+			COUNT(*) FILTER (WHERE deleted_at IS NOT NULL AND node_type = 'file'),
+			COALESCE(SUM(size_bytes) FILTER (WHERE deleted_at IS NOT NULL AND node_type = 'file'), 0),
+			(SELECT COUNT(*) FROM node_versions),
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM node_versions),
+			(SELECT COALESCE(SUM(storage_quota_bytes), 0) FROM users),
+			(SELECT COALESCE(SUM(storage_used_bytes), 0) FROM users),
+			(SELECT COUNT(*) FROM shares),
+			(SELECT COUNT(*) FROM public_links),
+			(SELECT COUNT(*) FROM sessions WHERE expires_at > NOW()),
+			(SELECT COALESCE(SUM(size_bytes), 0) FROM content_blobs WHERE ref_count > 0),
+			(SELECT COUNT(*) FROM upload_sessions)
+		FROM nodes
+	`
+	var stats SystemStats
+	err := q.db.QueryRow(ctx, query).Scan(
+		&stats.Users, &stats.AdminUsers, &stats.DisabledUsers,
+		&stats.Files, &stats.Folders, &stats.UsedBytes, &stats.TrashedFiles, &stats.TrashedBytes,
+		&stats.Versions, &stats.VersionBytes, &stats.QuotaBytes, &stats.ChargedBytes,
+		&stats.Shares, &stats.PublicLinks, &stats.ActiveSessions, &stats.StoredBlobBytes, &stats.PendingUploads,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Invariants checked by CheckInvariants.
+const (
+	// InvariantQuotaDrift: a user's storage_used_bytes differs from the size
+	// of the files counted against the quota.
+	InvariantQuotaDrift = "quota_drift"
+	// InvariantBlobRefCount: a content blob's ref_count differs from the
+	// number of files using it.
+	InvariantBlobRefCount = "blob_ref_count"
+	// InvariantUnregisteredBlob: files use a blob missing from content_blobs.
+	InvariantUnregisteredBlob = "unregistered_blob"
+)
+
+// InvariantViolation is an inconsistency found by CheckInvariants.
+type InvariantViolation struct {
+	Invariant string
+	// Subject is the user ID or the backend and key of the blob.
+	Subject  string
+	Expected int64
+	Actual   int64
+}
+
+func (v InvariantViolation) String() string {
+	return fmt.Sprintf("%s %s: expected %d, got %d", v.Invariant, v.Subject, v.Expected, v.Actual)
+}
+
+// CheckInvariants verifies the bookkeeping that has to stay consistent when
+// a transaction fails half way: the storage usage of users and the reference
+// counts of content blobs. excludeTrash is the quota.exclude_trash setting.
+// Only the given users and the blobs their files use are checked; with no
+// users given, everything is, including blobs no file uses anymore. It is
+// meant for tests and diagnostics and reads whole tables.
+func (q *Queries) CheckInvariants(ctx context.Context, excludeTrash bool, userIDs ...int64) ([]InvariantViolation, error) {
+	violations := []InvariantViolation{}
+	all := len(userIDs) == 0
+
+	quotaQuery := `
+		SELECT u.id, COALESCE(SUM(n.size_bytes), 0), u.storage_used_bytes
+		FROM users u
+		LEFT JOIN nodes n ON n.owner_id = u.id AND n.node_type = 'file' AND (NOT $1::boolean OR n.deleted_at IS NULL)
+		WHERE $2::boolean OR u.id = ANY($3)
+		GROUP BY u.id
+		HAVING COALESCE(SUM(n.size_bytes), 0) <> u.storage_used_bytes
+		ORDER BY u.id
+	`
+	rows, err := q.db.Query(ctx, quotaQuery, excludeTrash, all, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID int64
+		v := InvariantViolation{Invariant: InvariantQuotaDrift}
+		if err := rows.Scan(&userID, &v.Expected, &v.Actual); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		v.Subject = fmt.Sprintf("user %d", userID)
+		violations = append(violations, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	blobQuery := `
+		WITH refs AS (
+			SELECT storage_backend, storage_key, count(*) AS files
+			FROM nodes
+			WHERE node_type = 'file' AND storage_key IS NOT NULL
+			GROUP BY storage_backend, storage_key
+		), scope AS (
+			SELECT DISTINCT storage_backend, storage_key FROM nodes
+			WHERE $1::boolean OR (owner_id = ANY($2) AND node_type = 'file' AND storage_key IS NOT NULL)
+		)
+		SELECT
+			COALESCE(r.storage_backend, b.storage_backend), COALESCE(r.storage_key, b.storage_key),
+			COALESCE(r.files, 0), b.ref_count
+		FROM refs r
+		FULL JOIN content_blobs b ON b.storage_backend = r.storage_backend AND b.storage_key = r.storage_key
+		WHERE b.ref_count IS DISTINCT FROM COALESCE(r.files, 0)
+		  AND ($1::boolean OR EXISTS (
+			SELECT 1 FROM scope s WHERE s.storage_backend = r.storage_backend AND s.storage_key = r.storage_key
+		  ))
+		ORDER BY 1, 2
+	`
+	rows, err = q.db.Query(ctx, blobQuery, all, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var backend, key string
+		var refCount *int64
+		v := InvariantViolation{Invariant: InvariantBlobRefCount}
+		if err := rows.Scan(&backend, &key, &v.Expected, &refCount); err != nil {
+			return nil, err
+		}
+		if refCount == nil {
+			v.Invariant = InvariantUnregisteredBlob
+		} else {
+			v.Actual = *refCount
+		}
+		v.Subject = backend + "/" + key
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
+
+// UnreferencedStorageKeys returns the keys, out of those stored in a backend,
+// that nothing in the database refers to: the orphaned blobs left behind by
+// failed writes. Archived versions, derived artifacts and legal exports are
+// looked up only for the default backend, which holds them.
+func (q *Queries) UnreferencedStorageKeys(ctx context.Context, backend string, keys []string) ([]string, error) {
+	query := `
+		SELECT k FROM unnest($2::text[]) AS k
+		WHERE NOT EXISTS (
+			SELECT 1 FROM nodes n
+			WHERE n.storage_backend = $1 AND n.node_type = 'file'
+			  AND (n.storage_key = k OR (n.storage_key IS NULL AND n.id = k))
+		)
+		AND NOT EXISTS (SELECT 1 FROM content_blobs b WHERE b.storage_backend = $1 AND b.storage_key = k)
+		AND ($1 <> 'local' OR (
+			NOT EXISTS (SELECT 1 FROM node_versions v WHERE v.storage_key = k)
+			AND NOT EXISTS (SELECT 1 FROM derived_artifacts a WHERE a.storage_key = k)
+			AND NOT EXISTS (SELECT 1 FROM legal_exports e WHERE e.storage_key = k)
+		))
+		ORDER BY k
+	`
+	rows, err := q.db.Query(ctx, query, backend, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unreferenced := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		unreferenced = append(unreferenced, key)
+	}
+	return unreferenced, rows.Err()
+}
+
+var ErrGroupExists = errors.New("you already have a group with this name")
+
+const groupColumns = `g.id, g.owner_id, g.name, (SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id), g.ldap_dn IS NOT NULL, g.created_at`
+
+func scanGroup(row pgx.Row) (*models.Group, error) {
+	var group models.Group
+	err := row.Scan(&group.ID, &group.OwnerID, &group.Name, &group.MemberCount, &group.Directory, &group.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// CreateGroup creates a group with its owner as the only member.
+func (q *Queries) CreateGroup(ctx context.Context, ownerID int64, name string) (*models.Group, error) {
+	query := `
+		WITH g AS (
+			INSERT INTO groups (owner_id, name) VALUES ($1, $2)
+			RETURNING id, owner_id, name, created_at
+		), m AS (
+			INSERT INTO group_members (group_id, user_id) SELECT id, owner_id FROM g
+		)
+		SELECT id, owner_id, name, 1, false, created_at FROM g
+	`
+	group, err := scanGroup(q.db.QueryRow(ctx, query, ownerID, name))
+	if isUniqueViolation(err) {
+		return nil, ErrGroupExists
+	}
+	return group, err
+}
+
+// ListGroupsForUser returns the groups the user is a member of, including
+// the ones they own.
+func (q *Queries) ListGroupsForUser(ctx context.Context, userID int64) ([]models.Group, error) {
+	query := `
+		SELECT ` + groupColumns + `
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id AND gm.user_id = $1
+		ORDER BY g.name, g.id
+	`
+	rows, err := q.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroupForMember returns a group the user is a member of, or nil.
+func (q *Queries) GetGroupForMember(ctx context.Context, groupID, userID int64) (*models.Group, error) {
+	query := `
+		SELECT ` + groupColumns + `
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id AND gm.user_id = $2
+		WHERE g.id = $1
+	`
+	return scanGroup(q.db.QueryRow(ctx, query, groupID, userID))
+}
+
+// RenameGroup renames a group of the owner. It returns nil when the owner has
+// no such group.
+func (q *Queries) RenameGroup(ctx context.Context, groupID, ownerID int64, name string) (*models.Group, error) {
+	query := `
+		UPDATE groups g SET name = $3
+		WHERE g.id = $1 AND g.owner_id = $2
+		RETURNING ` + groupColumns
+	group, err := scanGroup(q.db.QueryRow(ctx, query, groupID, ownerID, name))
+	if isUniqueViolation(err) {
+		return nil, ErrGroupExists
+	}
+	return group, err
+}
+
+// DeleteGroup deletes a group of the owner along with its shares.
+func (q *Queries) DeleteGroup(ctx context.Context, groupID, ownerID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM groups WHERE id = $1 AND owner_id = $2`, groupID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (q *Queries) ListGroupMembers(ctx context.Context, groupID int64) ([]models.GroupMember, error) {
+	query := `
+		SELECT u.id, u.username, u.display_name, gm.added_at
+		FROM group_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = $1
+		ORDER BY u.username
+	`
+	rows, err := q.db.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.GroupMember{}
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.DisplayName, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// AddGroupMember adds a user to a group. It returns false when the user
+// already is a member.
+func (q *Queries) AddGroupMember(ctx context.Context, groupID, userID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `
+		INSERT INTO group_members (group_id, user_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (q *Queries) RemoveGroupMember(ctx context.Context, groupID, userID int64) (bool, error) {
+	tag, err := q.db.Exec(ctx, `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DirectoryGroup is a group synchronized from an LDAP directory.
+type DirectoryGroup struct {
+	ID     int64
+	Name   string
+	LDAPDN string
+}
+
+func (q *Queries) ListDirectoryGroups(ctx context.Context) ([]DirectoryGroup, error) {
+	rows, err := q.db.Query(ctx, `SELECT id, name, ldap_dn FROM groups WHERE ldap_dn IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []DirectoryGroup{}
+	for rows.Next() {
+		var group DirectoryGroup
+		if err := rows.Scan(&group.ID, &group.Name, &group.LDAPDN); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// CreateDirectoryGroup creates a group for a directory entry, without an
+// owner, and returns its ID.
+func (q *Queries) CreateDirectoryGroup(ctx context.Context, name, dn string) (int64, error) {
+	var id int64
+	err := q.db.QueryRow(ctx, `INSERT INTO groups (name, ldap_dn) VALUES ($1, $2) RETURNING id`, name, dn).Scan(&id)
+	return id, err
+}
+
+func (q *Queries) RenameDirectoryGroup(ctx context.Context, id int64, name string) error {
+	_, err := q.db.Exec(ctx, `UPDATE groups SET name = $2 WHERE id = $1 AND ldap_dn IS NOT NULL`, id, name)
+	return err
+}
+
+// SetGroupMembers makes the users the only members of a group and returns
+// the users added and removed.
+func (q *Queries) SetGroupMembers(ctx context.Context, groupID int64, userIDs []int64) (added, removed []int64, err error) {
+	query := `
+		WITH removed AS (
+			DELETE FROM group_members
+			WHERE group_id = $1 AND NOT (user_id = ANY($2::bigint[]))
+			RETURNING user_id
+		), added AS (
+			INSERT INTO group_members (group_id, user_id)
+			SELECT $1, unnest($2::bigint[])
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		)
+		SELECT user_id, true FROM added
+		UNION ALL
+		SELECT user_id, false FROM removed
+	`
+	rows, err := q.db.Query(ctx, query, groupID, userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID int64
+		var isAdded bool
+		if err := rows.Scan(&userID, &isAdded); err != nil {
+			return nil, nil, err
+		}
+		if isAdded {
+			added = append(added, userID)
+		} else {
+			removed = append(removed, userID)
+		}
+	}
+	return added, removed, rows.Err()
+}
+
+// DeleteDirectoryGroup deletes a group synchronized from the directory along
+// with its shares and returns the IDs of its former members.
+func (q *Queries) DeleteDirectoryGroup(ctx context.Context, id int64) ([]int64, error) {
+	query := `
+		WITH members AS (
+			SELECT user_id FROM group_members WHERE group_id = $1
+		), deleted AS (
+			DELETE FROM groups WHERE id = $1 AND ldap_dn IS NOT NULL
+		)
+		SELECT user_id FROM members
+	`
+	rows, err := q.db.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []int64{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		members = append(members, userID)
+	}
+	return members, rows.Err()
+}
+
+type ShareNodeWithGroupParams struct {
+	NodeID      string
+	SharerID    int64
+	GroupID     int64
+	Permissions string
+	Message     *string
+}
+
+const groupShareColumns = `id, node_id, sharer_id, group_id, permissions, message, shared_at`
+
+func scanGroupShare(row pgx.Row) (*models.GroupShare, error) {
+	var share models.GroupShare
+	err := row.Scan(&share.ID, &share.NodeID, &share.SharerID, &share.GroupID, &share.Permissions, &share.Message, &share.SharedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (q *Queries) ShareNodeWithGroup(ctx context.Context, arg ShareNodeWithGroupParams) (*models.GroupShare, error) {
+	query := `
+		INSERT INTO group_shares (node_id, sharer_id, group_id, permissions, message)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + groupShareColumns
+	share, err := scanGroupShare(q.db.QueryRow(ctx, query, arg.NodeID, arg.SharerID, arg.GroupID, arg.Permissions, arg.Message))
+	if isUniqueViolation(err) {
+		return nil, ErrShareAlreadyExists
+	}
+	return share, err
+}
+
+// GroupSharedNode is a node shared with a group, with the share's details.
+type GroupSharedNode struct {
+	models.GroupShare
+	NodeName string `json:"node_name"`
+	NodeType string `json:"node_type"`
+}
+
+// ListGroupShares returns the live nodes shared with a group, newest first.
+func (q *Queries) ListGroupShares(ctx context.Context, groupID int64) ([]GroupSharedNode, error) {
+	query := `
+		SELECT s.id, s.node_id, s.sharer_id, s.group_id, s.permissions, s.message, s.shared_at, n.name, n.node_type
+		FROM group_shares s
+		JOIN nodes n ON n.id = s.node_id
+		WHERE s.group_id = $1 AND n.deleted_at IS NULL
+		ORDER BY s.shared_at DESC, s.id DESC
+	`
+	rows, err := q.db.Query(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []GroupSharedNode{}
+	for rows.Next() {
+		var share GroupSharedNode
+		if err := rows.Scan(&share.ID, &share.NodeID, &share.SharerID, &share.GroupID, &share.Permissions, &share.Message, &share.SharedAt, &share.NodeName, &share.NodeType); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteGroupShare removes a share of a group made by the user, or any share
+// of a group the user owns. It returns the deleted share, or nil.
+func (q *Queries) DeleteGroupShare(ctx context.Context, shareID, groupID, userID int64) (*models.GroupShare, error) {
+	query := `
+		DELETE FROM group_shares s
+		WHERE s.id = $1 AND s.group_id = $2
+		  AND (s.sharer_id = $3 OR EXISTS (SELECT 1 FROM groups g WHERE g.id = s.group_id AND g.owner_id = $3))
+		RETURNING ` + groupShareColumns
+	return scanGroupShare(q.db.QueryRow(ctx, query, shareID, groupID, userID))
+}
+
+// Kinds of download transfers: a whole file, the rest of a file from an
+// offset, or any other range.
+const (
+	TransferFull    = "full"
+	TransferResumed = "resumed"
+	TransferPartial = "partial"
+)
+
+type RecordDownloadTransferParams struct {
+	NodeID  string
+	OwnerID int64
+	// UserID is nil for downloads through a public link.
+	UserID         *int64
+	PublicLink     bool
+	Kind           string
+	RangeStart     int64
+	BytesRequested int64
+	BytesServed    int64
+}
+
+func (q *Queries) RecordDownloadTransfer(ctx context.Context, arg RecordDownloadTransferParams) error {
+	query := `
+		INSERT INTO download_transfers (node_id, owner_id, user_id, public_link, kind, range_start, bytes_requested, bytes_served)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := q.db.Exec(ctx, query, arg.NodeID, arg.OwnerID, arg.UserID, arg.PublicLink, arg.Kind, arg.RangeStart, arg.BytesRequested, arg.BytesServed)
+	return err
+}
+
+// NodeTransferStats sums up the content served for a node since a point in
+// time.
+type NodeTransferStats struct {
+	FullDownloads    int64 `json:"full_downloads" example:"120"`
+	ResumedDownloads int64 `json:"resumed_downloads" example:"14"`
+	PartialRequests  int64 `json:"partial_requests" example:"310"`
+	// Interrupted counts transfers that ended before every requested byte
+	// was sent, usually because the client disconnected.
+	Interrupted         int64      `json:"interrupted" example:"9"`
+	PublicLinkDownloads int64      `json:"public_link_downloads" example:"40"`
+	UniqueUsers         int64      `json:"unique_users" example:"25"`
+	BytesServed         int64      `json:"bytes_served" example:"52428800000"`
+	LastServedAt        *time.Time `json:"last_served_at,omitempty"`
+}
+
+func (q *Queries) GetNodeTransferStats(ctx context.Context, nodeID string, since time.Time) (*NodeTransferStats, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE kind = 'full'),
+			COUNT(*) FILTER (WHERE kind = 'resumed'),
+			COUNT(*) FILTER (WHERE kind = 'partial'),
+			COUNT(*) FILTER (WHERE bytes_served < bytes_requested),
+			COUNT(*) FILTER (WHERE public_link),
+			COUNT(DISTINCT user_id),
+			COALESCE(SUM(bytes_served), 0),
+			MAX(served_at)
+		FROM download_transfers
+		WHERE node_id = $1 AND served_at >= $2
+	`
+	var stats NodeTransferStats
+	err := q.db.QueryRow(ctx, query, nodeID, since).Scan(
+		&stats.FullDownloads, &stats.ResumedDownloads, &stats.PartialRequests, &stats.Interrupted,
+		&stats.PublicLinkDownloads, &stats.UniqueUsers, &stats.BytesServed, &stats.LastServedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (q *Queries) DeleteDownloadTransfersBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `DELETE FROM download_transfers WHERE served_at < $1`
+	res, err := q.db.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// EffectiveAccess is a user's access to a node through shares of it or its
+// ancestors: the share granting the highest permission, the nearest one
+// among equals, and how many shares grant access in total.
+type EffectiveAccess struct {
+	UserID      int64   `json:"user_id" example:"2"`
+	Username    string  `json:"username" example:"user2"`
+	DisplayName *string `json:"display_name,omitempty" example:"Jan Kowalski"`
+	Permissions string  `json:"permissions" example:"write" enums:"read,write,manage"`
+	ShareID     int64   `json:"share_id" example:"42"`
+	// GroupID is set when the winning share was made to a group; ShareID
+	// is then the group share's ID.
+	GroupID       *int64 `json:"group_id,omitempty" example:"7"`
+	SharerID      int64  `json:"sharer_id" example:"1"`
+	PinnedVersion *int   `json:"pinned_version,omitempty" example:"3"`
+	// ViaNodeID is the shared node, the node itself at depth 0 or an
+	// ancestor.
+	ViaNodeID   string `json:"via_node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	ViaNodeName string `json:"via_node_name" example:"Projekty"`
+	Depth       int    `json:"depth" example:"1"`
+	// Grants counts every share giving the user access; more than one means
+	// overlapping shares, where revoking one may not remove access.
+	Grants int `json:"grants" example:"2"`
+}
+
+// ListEffectiveAccess lists the users a node is shared with, directly, through
+// an ancestor or through a group, with their effective permission.
+func (q *Queries) ListEffectiveAccess(ctx context.Context, nodeID string) ([]EffectiveAccess, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id, name, 0 AS depth
+			FROM nodes
+			WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id, n.name, np.depth + 1
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		), ranked AS (
+			SELECT DISTINCT ON (g.recipient_id)
+				g.recipient_id, g.permissions, g.id, g.group_id, g.sharer_id, g.pinned_version,
+				np.id AS via_node_id, np.name AS via_node_name, np.depth,
+				COUNT(*) OVER (PARTITION BY g.recipient_id) AS grants
+			FROM share_grants g
+			JOIN node_parents np ON np.id = g.node_id
+			ORDER BY g.recipient_id, g.permissions = 'manage' DESC, g.permissions = 'write' DESC, np.depth, g.group_id NULLS FIRST
+		)
+		SELECT r.recipient_id, u.username, u.display_name, r.permissions, r.id, r.group_id, r.sharer_id, r.pinned_version,
+			r.via_node_id, r.via_node_name, r.depth, r.grants
+		FROM ranked r
+		JOIN users u ON u.id = r.recipient_id
+		ORDER BY u.username
+	`
+	rows, err := q.db.Query(ctx, query, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	access := []EffectiveAccess{}
+	for rows.Next() {
+		var a EffectiveAccess
+		err := rows.Scan(&a.UserID, &a.Username, &a.DisplayName, &a.Permissions, &a.ShareID, &a.GroupID, &a.SharerID, &a.PinnedVersion,
+			&a.ViaNodeID, &a.ViaNodeName, &a.Depth, &a.Grants)
+		if err != nil {
+			return nil, err
+		}
+		access = append(access, a)
+	}
+	return access, rows.Err()
+}
+
+// NodeShare is a share giving access to a node, made on the node itself or
+// on one of its ancestor folders, to a user or to a group.
+type NodeShare struct {
+	ShareID int64 `json:"share_id" example:"42"`
+	// RecipientType is "user" or "group"; RecipientID and RecipientName
+	// identify the user or the group accordingly.
+	RecipientType string    `json:"recipient_type" example:"user" enums:"user,group"`
+	RecipientID   int64     `json:"recipient_id" example:"2"`
+	RecipientName string    `json:"recipient_name" example:"user2"`
+	Permissions   string    `json:"permissions" example:"read" enums:"read,write,manage"`
+	SharerID      int64     `json:"sharer_id" example:"1"`
+	SharedAt      time.Time `json:"shared_at"`
+	PinnedVersion *int      `json:"pinned_version,omitempty" example:"3"`
+	// OriginNodeID is the shared node: the node itself for a direct share,
+	// otherwise the ancestor folder the share is inherited from.
+	OriginNodeID   string `json:"origin_node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OriginNodeName string `json:"origin_node_name" example:"Projekty"`
+	Inherited      bool   `json:"inherited" example:"true"`
+}
+
+// ListNodeShares lists the shares of a node and of its ancestors, direct
+// shares first, then by distance of the origin node and recipient name.
+func (q *Queries) ListNodeShares(ctx context.Context, nodeID string) ([]NodeShare, error) {
+	query := `
+		WITH RECURSIVE node_parents AS (
+			SELECT id, parent_id, name, 0 AS depth
+			FROM nodes
+			WHERE id = $1
+
+			UNION ALL
+
+			SELECT n.id, n.parent_id, n.name, np.depth + 1
+			FROM nodes n
+			JOIN node_parents np ON n.id = np.parent_id
+		), node_shares AS (
+			SELECT s.id, 'user' AS recipient_type, u.id AS recipient_id, u.username AS recipient_name,
+				s.permissions, s.sharer_id, s.shared_at, s.pinned_version, s.node_id
+			FROM shares s
+			JOIN users u ON u.id = s.recipient_id
+			UNION ALL
+			SELECT gs.id, 'group', g.id, g.name, gs.permissions, gs.sharer_id, gs.shared_at, NULL::INTEGER, gs.node_id
+			FROM group_shares gs
+			JOIN groups g ON g.id = gs.group_id
+		)
+		SELECT ns.id, ns.recipient_type, ns.recipient_id, ns.recipient_name, ns.permissions, ns.sharer_id, ns.shared_at,
+			ns.pinned_version, np.id, np.name, np.depth > 0
+		FROM node_shares ns
+		JOIN node_parents np ON np.id = ns.node_id
+		ORDER BY np.depth, ns.recipient_name, ns.recipient_type DESC
+	`
+	rows, err := q.db.Query(ctx, query, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []NodeShare{}
+	for rows.Next() {
+		var s NodeShare
+		err := rows.Scan(&s.ShareID, &s.RecipientType, &s.RecipientID, &s.RecipientName, &s.Permissions, &s.SharerID, &s.SharedAt,
+			&s.PinnedVersion, &s.OriginNodeID, &s.OriginNodeName, &s.Inherited)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+const (
+	BlobMigrationQueued    = "queued"
+	BlobMigrationRunning   = "running"
+	BlobMigrationCompleted = "completed"
+	BlobMigrationFailed    = "failed"
+)
+
+// BlobMigration moves the current content of files from one storage backend
+// to another, one blob at a time, while the server keeps serving them.
+type BlobMigration struct {
+	ID          uuid.UUID `json:"id"`
+	RequestedBy *int64    `json:"requested_by" example:"1"`
+	FromBackend string    `json:"from_backend" example:"local"`
+	ToBackend   string    `json:"to_backend" example:"archive"`
+	// OwnerID limits the migration to one user's files.
+	OwnerID *int64 `json:"owner_id,omitempty" example:"2"`
+	Status  string `json:"status" example:"running" enums:"queued,running,completed,failed"`
+	// TotalBlobs is counted when the migration is queued; MigratedBlobs and
+	// FailedBlobs grow as it runs. A blob shared by several files counts once.
+	TotalBlobs    int        `json:"total_blobs" example:"1200"`
+	MigratedBlobs int        `json:"migrated_blobs" example:"800"`
+	MigratedBytes int64      `json:"migrated_bytes" example:"5368709120"`
+	FailedBlobs   int        `json:"failed_blobs" example:"0"`
+	LastKey       *string    `json:"-"`
+	Error         *string    `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+const blobMigrationColumns = `id, requested_by, from_backend, to_backend, owner_id, status, total_blobs, migrated_blobs, migrated_bytes, failed_blobs, last_key, error, created_at, updated_at, completed_at`
+
+func scanBlobMigration(row pgx.Row) (*BlobMigration, error) {
+	var m BlobMigration
+	err := row.Scan(&m.ID, &m.RequestedBy, &m.FromBackend, &m.ToBackend, &m.OwnerID, &m.Status, &m.TotalBlobs, &m.MigratedBlobs,
+		&m.MigratedBytes, &m.FailedBlobs, &m.LastKey, &m.Error, &m.CreatedAt, &m.UpdatedAt, &m.CompletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// CreateBlobMigration queues a migration of the blobs in fromBackend, of
+// ownerID's files or of all files when ownerID is nil.
+func (q *Queries) CreateBlobMigration(ctx context.Context, requestedBy int64, fromBackend, toBackend string, ownerID *int64) (*BlobMigration, error) {
+	query := `
+		INSERT INTO blob_migrations (id, requested_by, from_backend, to_backend, owner_id, total_blobs)
+		SELECT $1, $2, $3, $4, $5, COUNT(DISTINCT COALESCE(storage_key, id))
+		FROM nodes
+		WHERE node_type = 'file' AND storage_backend = $3 AND ($5::INTEGER IS NULL OR owner_id = $5)
+		RETURNING ` + blobMigrationColumns
+	return scanBlobMigration(q.db.QueryRow(ctx, query, uuid.New(), requestedBy, fromBackend, toBackend, ownerID))
+}
+
+func (q *Queries) GetBlobMigration(ctx context.Context, id uuid.UUID) (*BlobMigration, error) {
+	query := `SELECT ` + blobMigrationColumns + ` FROM blob_migrations WHERE id = $1`
+	return scanBlobMigration(q.db.QueryRow(ctx, query, id))
+}
+
+func (q *Queries) ListBlobMigrations(ctx context.Context, limit int, offset int) ([]BlobMigration, error) {
+	query := `SELECT ` + blobMigrationColumns + ` FROM blob_migrations ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	rows, err := q.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations := []BlobMigration{}
+	for rows.Next() {
+		migration, err := scanBlobMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, *migration)
+	}
+	return migrations, rows.Err()
+}
+
+// ClaimBlobMigration marks the oldest queued migration as running and returns
+// it, or nil when the queue is empty. Migrations whose progress has not been
+// recorded for longer than staleAfter are claimed again and resume after
+// their last processed blob.
+func (q *Queries) ClaimBlobMigration(ctx context.Context, staleAfter time.Duration) (*BlobMigration, error) {
+	query := `
+		UPDATE blob_migrations
+		SET status = 'running', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM blob_migrations
+			WHERE status = 'queued' OR (status = 'running' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + blobMigrationColumns
+	return scanBlobMigration(q.db.QueryRow(ctx, query, time.Now().Add(-staleAfter)))
+}
+
+// RecordBlobMigrationProgress stores the counters of a running migration and
+// the key of the last blob it processed.
+func (q *Queries) RecordBlobMigrationProgress(ctx context.Context, m *BlobMigration) error {
+	query := `
+		UPDATE blob_migrations
+		SET migrated_blobs = $2, migrated_bytes = $3, failed_blobs = $4, last_key = $5, updated_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`
+	_, err := q.db.Exec(ctx, query, m.ID, m.MigratedBlobs, m.MigratedBytes, m.FailedBlobs, m.LastKey)
+	return err
+}
+
+func (q *Queries) CompleteBlobMigration(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, `UPDATE blob_migrations SET status = 'completed', error = NULL, updated_at = NOW(), completed_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (q *Queries) FailBlobMigration(ctx context.Context, id uuid.UUID, errorMessage string) error {
+	_, err := q.db.Exec(ctx, `UPDATE blob_migrations SET status = 'failed', error = $2, updated_at = NOW() WHERE id = $1 AND status <> 'completed'`, id, errorMessage)
+	return err
+}
+
+// MigrationBlob is a blob in a storage backend holding the content of one or
+// more files.
+type MigrationBlob struct {
+	Key string
+	// Deduplicated blobs are reference counted in content_blobs; the others
+	// hold the content of a single file stored under its node ID.
+	Deduplicated bool
+	SizeBytes    int64
+	// SHA256 is the recorded checksum of the content, when known.
+	SHA256 *string
+}
+
+// ListMigrationBlobs returns up to limit blobs in backend referenced by
+// ownerID's files, or by any file when ownerID is nil, ordered by key and
+// starting after afterKey. Trashed files are included.
+func (q *Queries) ListMigrationBlobs(ctx context.Context, backend string, ownerID *int64, afterKey string, limit int) ([]MigrationBlob, error) {
+	query := `
+		SELECT COALESCE(storage_key, id) AS key, bool_or(storage_key IS NOT NULL),
+			COALESCE(MAX(size_bytes), 0), MIN(content_sha256)
+		FROM nodes
+		WHERE node_type = 'file' AND storage_backend = $1 AND ($2::INTEGER IS NULL OR owner_id = $2)
+			AND COALESCE(storage_key, id) > $3
+		GROUP BY key
+		ORDER BY key
+		LIMIT $4
+	`
+	rows, err := q.db.Query(ctx, query, backend, ownerID, afterKey, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []MigrationBlob{}
+	for rows.Next() {
+		var b MigrationBlob
+		if err := rows.Scan(&b.Key, &b.Deduplicated, &b.SizeBytes, &b.SHA256); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// MoveBlobReferences points the files referencing blob.Key in fromBackend,
+// limited to ownerID's files when it is set, at the copy of the blob in
+// toBackend and moves their references between the backends. It returns how
+// many files were moved and how many references are left on the source blob;
+// the source can be removed when files were moved and none are left.
+func (q *Queries) MoveBlobReferences(ctx context.Context, blob MigrationBlob, fromBackend, toBackend string, ownerID *int64) (int, int, error) {
+	match := `id = $3 AND storage_key IS NULL`
+	if blob.Deduplicated {
+		match = `storage_key = $3`
+	}
+	query := `
+		UPDATE nodes SET storage_backend = $2
+		WHERE node_type = 'file' AND storage_backend = $1 AND ` + match + `
+			AND ($4::INTEGER IS NULL OR owner_id = $4)
+	`
+	tag, err := q.db.Exec(ctx, query, fromBackend, toBackend, blob.Key, ownerID)
+	if err != nil {
+		return 0, 0, err
+	}
+	moved := int(tag.RowsAffected())
+	if moved == 0 || !blob.Deduplicated {
+		return moved, 0, nil
+	}
+
+	_, err = q.db.Exec(ctx, `
+		INSERT INTO content_blobs (storage_backend, storage_key, size_bytes, ref_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (storage_backend, storage_key) DO UPDATE SET ref_count = content_blobs.ref_count + EXCLUDED.ref_count
+	`, toBackend, blob.Key, blob.SizeBytes, moved)
+	if err != nil {
+		return 0, 0, err
+	}
+	var remaining int
+	err = q.db.QueryRow(ctx, `
+		UPDATE content_blobs SET ref_count = GREATEST(ref_count - $3, 0)
+		WHERE storage_backend = $1 AND storage_key = $2
+		RETURNING ref_count
+	`, fromBackend, blob.Key, moved).Scan(&remaining)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return moved, 0, nil
+	}
+	if err != nil || remaining > 0 {
+		return moved, remaining, err
+	}
+	_, err = q.db.Exec(ctx, `DELETE FROM content_blobs WHERE storage_backend = $1 AND storage_key = $2`, fromBackend, blob.Key)
+	return moved, 0, err
+}
+
+var ErrEmailTaken = errors.New("the email address is already verified for another account")
+
+// Purposes of email tokens.
+const (
+	EmailTokenVerify        = "verify_email"
+	EmailTokenPasswordReset = "password_reset"
+)
+
+// UserEmail is the email address of an account. VerifiedAt is nil until the
+// address is confirmed through the link sent to it.
+type UserEmail struct {
+	Email      *string    `json:"email" example:"jan.kowalski@example.com"`
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+func (q *Queries) GetUserEmail(ctx context.Context, userID int64) (*UserEmail, error) {
+	var email UserEmail
+	err := q.db.QueryRow(ctx, `SELECT email, email_verified_at FROM users WHERE id = $1`, userID).Scan(&email.Email, &email.VerifiedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &email, nil
+}
+
+// SetUserEmail changes the email address of an account. A new address is
+// unverified; setting the current address again keeps its verification.
+// Changing the address invalidates the password reset tokens sent to the
+// previous one.
+func (q *Queries) SetUserEmail(ctx context.Context, userID int64, email string) (*UserEmail, error) {
+	query := `
+		WITH invalidated AS (
+			UPDATE email_tokens SET used_at = NOW()
+			WHERE user_id = $1 AND purpose = 'password_reset' AND used_at IS NULL AND email <> $2
+		)
+		UPDATE users
+		SET email_verified_at = CASE WHEN email = $2 THEN email_verified_at END, email = $2
+		WHERE id = $1
+		RETURNING email, email_verified_at
+	`
+	var result UserEmail
+	err := q.db.QueryRow(ctx, query, userID, email).Scan(&result.Email, &result.VerifiedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+// VerifyUserEmail marks the address of an account verified, provided it is
+// still the given one. It returns ErrEmailTaken when another account verified
+// the address first.
+func (q *Queries) VerifyUserEmail(ctx context.Context, userID int64, email string) (bool, error) {
+	tag, err := q.db.Exec(ctx, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1 AND email = $2`, userID, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return false, ErrEmailTaken
+		}
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetUserByVerifiedEmail returns the account whose verified address is email,
+// or nil when there is none.
+func (q *Queries) GetUserByVerifiedEmail(ctx context.Context, email string) (*models.User, error) {
+	var id int64
+	err := q.db.QueryRow(ctx, `SELECT id FROM users WHERE email = $1 AND email_verified_at IS NOT NULL`, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return q.GetUserByID(ctx, id)
+}
+
+type CreateEmailTokenParams struct {
+	TokenHash string
+	UserID    int64
+	Purpose   string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// CreateEmailToken stores a token unless the account was sent maxPerHour
+// tokens for the same purpose during the last hour. It reports whether the
+// token was stored. It must run in a transaction: the user row is locked
+// before counting, so concurrent requests for the same account are counted
+// one after another and cannot all slip under the limit.
+func (q *Queries) CreateEmailToken(ctx context.Context, arg CreateEmailTokenParams, maxPerHour int) (bool, error) {
+	var id int64
+	err := q.db.QueryRow(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, arg.UserID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	query := `
+		INSERT INTO email_tokens (token_hash, user_id, purpose, email, expires_at)
+		SELECT $1, $2, $3, $4, $5
+		WHERE (
+			SELECT COUNT(*) FROM email_tokens
+			WHERE user_id = $2 AND purpose = $3 AND created_at > NOW() - INTERVAL '1 hour'
+		) < $6
+	`
+	tag, err := q.db.Exec(ctx, query, arg.TokenHash, arg.UserID, arg.Purpose, arg.Email, arg.ExpiresAt, maxPerHour)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// EmailToken is a consumed email token: the account and address it was sent
+// for.
+type EmailToken struct {
+	UserID int64
+	Email  string
+}
+
+// ConsumeEmailToken marks a valid token of the purpose used and returns it,
+// or nil when the token is unknown, expired or already used.
+func (q *Queries) ConsumeEmailToken(ctx context.Context, tokenHash, purpose string) (*EmailToken, error) {
+	query := `
+		UPDATE email_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email
+	`
+	var token EmailToken
+	if err := q.db.QueryRow(ctx, query, tokenHash, purpose).Scan(&token.UserID, &token.Email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// InvalidateEmailTokens marks the unused tokens of an account for the purpose
+// used, e.g. the other reset links once the password was reset.
+func (q *Queries) InvalidateEmailTokens(ctx context.Context, userID int64, purpose string) error {
+	_, err := q.db.Exec(ctx, `UPDATE email_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose)
+	return err
+}
+
+// DeleteStaleEmailTokens removes tokens that expired or were used more than
+// an hour ago, once they no longer count towards the rate limit.
+func (q *Queries) DeleteStaleEmailTokens(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM email_tokens
+		WHERE created_at <= NOW() - INTERVAL '1 hour'
+		  AND (used_at IS NOT NULL OR expires_at <= NOW())
+	`
+	res, err := q.db.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
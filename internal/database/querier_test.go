@@ -1,15 +1,20 @@
 package database
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"os"
 	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/chaos"
 	"serwer-plikow/internal/models"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/require"
 )
 
@@ -439,7 +444,7 @@ func TestGetOutgoingShares(t *testing.T) {
 	createTestShare(t, ShareNodeParams{NodeID: node1.ID, SharerID: sharer.ID, RecipientID: recipient1.ID, Permissions: "read"})
 	createTestShare(t, ShareNodeParams{NodeID: node2.ID, SharerID: sharer.ID, RecipientID: recipient2.ID, Permissions: "write"})
 
-	shares, err := testStore.GetOutgoingShares(context.Background(), sharer.ID, 100, 0)
+	shares, err := testStore.GetOutgoingShares(context.Background(), sharer.ID, OutgoingShareFilter{}, 100, 0)
 	require.NoError(t, err)
 	require.Len(t, shares, 2)
 
@@ -541,10 +546,15 @@ func TestLogAndGetEvents(t *testing.T) {
 	err = testStore.LogEvent(context.Background(), user.ID, "NODE_DELETE", payload2)
 	require.NoError(t, err)
 
-	events, err := testStore.GetEventsSince(context.Background(), user.ID, 0)
+	events, err := testStore.GetEventsSince(context.Background(), user.ID, 0, 100)
 	require.NoError(t, err)
 	require.Len(t, events, 2)
 
+	limited, err := testStore.GetEventsSince(context.Background(), user.ID, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+	require.Equal(t, events[0].ID, limited[0].ID)
+
 	type EventPayloadWrapper struct {
 		EventType string            `json:"event_type"`
 		Payload   map[string]string `json:"payload"`
@@ -562,12 +572,12 @@ func TestLogAndGetEvents(t *testing.T) {
 	require.Equal(t, "NODE_DELETE", wrapper2.EventType)
 	require.Equal(t, payload2, wrapper2.Payload)
 
-	eventsSince, err := testStore.GetEventsSince(context.Background(), user.ID, events[0].ID)
+	eventsSince, err := testStore.GetEventsSince(context.Background(), user.ID, events[0].ID, 100)
 	require.NoError(t, err)
 	require.Len(t, eventsSince, 1)
 	require.Equal(t, events[1].ID, eventsSince[0].ID)
 
-	noEvents, err := testStore.GetEventsSince(context.Background(), otherUser.ID, 0)
+	noEvents, err := testStore.GetEventsSince(context.Background(), otherUser.ID, 0, 100)
 	require.NoError(t, err)
 	require.Len(t, noEvents, 0)
 }
@@ -659,13 +669,57 @@ func TestListTrash(t *testing.T) {
 	_, err = testStore.MoveNodeToTrash(context.Background(), node2.ID, user.ID)
 	require.NoError(t, err)
 
-	trashedNodes, err := testStore.ListTrash(context.Background(), user.ID, 10, 0)
+	trashedNodes, err := testStore.ListTrash(context.Background(), user.ID, nil, 10, 0)
 	require.NoError(t, err)
 	require.Len(t, trashedNodes, 2)
 	require.Equal(t, "second_to_trash", trashedNodes[0].Name)
 	require.Equal(t, "first_to_trash", trashedNodes[1].Name)
 }
 
+func TestTrashBatches(t *testing.T) {
+	user := createTestUser(t, "user_trash_batches")
+	folder := createTestNode(t, CreateNodeParams{ID: "batch_folder", OwnerID: user.ID, Name: "Projekt", NodeType: "folder"})
+	size := int64(100)
+	createTestNode(t, CreateNodeParams{ID: "batch_file_1", OwnerID: user.ID, ParentID: &folder.ID, Name: "a.txt", NodeType: "file", SizeBytes: &size})
+	subfolder := createTestNode(t, CreateNodeParams{ID: "batch_subfolder", OwnerID: user.ID, ParentID: &folder.ID, Name: "Szkice", NodeType: "folder"})
+	createTestNode(t, CreateNodeParams{ID: "batch_file_2", OwnerID: user.ID, ParentID: &subfolder.ID, Name: "b.txt", NodeType: "file", SizeBytes: &size})
+	single := createTestNode(t, CreateNodeParams{ID: "batch_single", OwnerID: user.ID, Name: "osobny.txt", NodeType: "file", SizeBytes: &size})
+
+	folderBatch := uuid.New()
+	success, err := testStore.MoveNodeToTrashInBatch(context.Background(), folder.ID, user.ID, folderBatch)
+	require.NoError(t, err)
+	require.True(t, success)
+	time.Sleep(10 * time.Millisecond)
+	_, err = testStore.MoveNodeToTrash(context.Background(), single.ID, user.ID)
+	require.NoError(t, err)
+
+	batches, err := testStore.ListTrashBatches(context.Background(), user.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	require.Equal(t, single.ID, batches[0].RootID)
+	require.Equal(t, int64(1), batches[0].ItemCount)
+	require.Equal(t, folderBatch, batches[1].BatchID)
+	require.Equal(t, folder.ID, batches[1].RootID)
+	require.Equal(t, int64(4), batches[1].ItemCount)
+	require.Equal(t, int64(200), batches[1].TotalBytes)
+
+	inBatch, err := testStore.ListTrash(context.Background(), user.ID, &folderBatch, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, inBatch, 4)
+
+	roots, err := testStore.RestoreTrashBatch(context.Background(), folderBatch, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{folder.ID}, roots)
+
+	children, err := testStore.GetNodesByParentID(context.Background(), user.ID, &subfolder.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, children, 1, "The whole subtree should be restored to its original place")
+
+	roots, err = testStore.RestoreTrashBatch(context.Background(), folderBatch, user.ID)
+	require.NoError(t, err)
+	require.Empty(t, roots)
+}
+
 func TestIsDescendantOf(t *testing.T) {
 	user := createTestUser(t, "user_descendant")
 	folder1 := createTestNode(t, CreateNodeParams{ID: "desc_1", OwnerID: user.ID, Name: "F1", NodeType: "folder"})
@@ -927,3 +981,657 @@ func TestGetUserByID(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, notFoundUser)
 }
+
+func TestAccessLog(t *testing.T) {
+	owner := createTestUser(t, "access_log_owner")
+	reader := createTestUser(t, "access_log_reader")
+	node := createTestNode(t, CreateNodeParams{ID: "access_log_node", OwnerID: owner.ID, Name: "logged.txt", NodeType: "file"})
+
+	err := testStore.LogAccess(context.Background(), LogAccessParams{
+		UserID: reader.ID, NodeID: node.ID, OwnerID: owner.ID, Action: "download", ClientIP: "10.0.0.0", UserAgent: "test",
+	})
+	require.NoError(t, err)
+
+	entries, err := testStore.ListAccessLogForNode(context.Background(), node.ID, owner.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "download", entries[0].Action)
+	require.Equal(t, reader.Username, *entries[0].Username)
+
+	entries, err = testStore.ListAccessLogForNode(context.Background(), node.ID, reader.ID, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Only the owner should see the access log of a node")
+
+	deleted, err := testStore.DeleteAccessLogsBefore(context.Background(), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	deleted, err = testStore.DeleteAccessLogsBefore(context.Background(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+}
+
+func TestGetTrashSummary(t *testing.T) {
+	user := createTestUser(t, "user_trash_summary")
+
+	summary, err := testStore.GetTrashSummary(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Zero(t, summary.TotalItems)
+	require.Zero(t, summary.TotalBytes)
+	require.Nil(t, summary.OldestDeletion)
+
+	var size int64 = 300
+	folder := createTestNode(t, CreateNodeParams{ID: "trash_sum_folder", OwnerID: user.ID, Name: "Folder", NodeType: "folder"})
+	createTestNode(t, CreateNodeParams{ID: "trash_sum_file1", OwnerID: user.ID, ParentID: &folder.ID, Name: "a.txt", NodeType: "file", SizeBytes: &size})
+	createTestNode(t, CreateNodeParams{ID: "trash_sum_file2", OwnerID: user.ID, Name: "b.txt", NodeType: "file", SizeBytes: &size})
+
+	_, err = testStore.MoveNodeToTrash(context.Background(), folder.ID, user.ID)
+	require.NoError(t, err)
+
+	summary, err = testStore.GetTrashSummary(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), summary.TotalItems)
+	require.Equal(t, size, summary.TotalBytes)
+	require.NotNil(t, summary.OldestDeletion)
+}
+
+func TestDerivedArtifactsLifecycle(t *testing.T) {
+	user := createTestUser(t, "user_derived_artifacts")
+	node := createTestNode(t, CreateNodeParams{ID: "derived_node_1", OwnerID: user.ID, Name: "photo.jpg", NodeType: "file"})
+
+	previous, err := testStore.RegisterDerivedArtifact(context.Background(), RegisterDerivedArtifactParams{
+		NodeID: node.ID, ContentHash: "hash_v1", Kind: "thumbnail", StorageKey: "thumb_key_v1", SizeBytes: 10,
+	})
+	require.NoError(t, err)
+	require.Nil(t, previous)
+
+	previous, err = testStore.RegisterDerivedArtifact(context.Background(), RegisterDerivedArtifactParams{
+		NodeID: node.ID, ContentHash: "hash_v1", Kind: "thumbnail", StorageKey: "thumb_key_v1b", SizeBytes: 12,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, previous)
+	require.Equal(t, "thumb_key_v1", *previous)
+
+	_, err = testStore.RegisterDerivedArtifact(context.Background(), RegisterDerivedArtifactParams{
+		NodeID: node.ID, ContentHash: "hash_v2", Kind: "thumbnail", StorageKey: "thumb_key_v2", SizeBytes: 10,
+	})
+	require.NoError(t, err)
+
+	staleKeys, err := testStore.InvalidateDerivedArtifacts(context.Background(), node.ID, "hash_v2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"thumb_key_v1b"}, staleKeys)
+
+	artifact, err := testStore.GetDerivedArtifact(context.Background(), node.ID, "hash_v2", "thumbnail")
+	require.NoError(t, err)
+	require.NotNil(t, artifact)
+	require.Equal(t, "thumb_key_v2", artifact.StorageKey)
+
+	purgedKeys, err := testStore.DeleteDerivedArtifactsForNodes(context.Background(), []string{node.ID})
+	require.NoError(t, err)
+	require.Equal(t, []string{"thumb_key_v2"}, purgedKeys)
+
+	artifact, err = testStore.GetDerivedArtifact(context.Background(), node.ID, "hash_v2", "thumbnail")
+	require.NoError(t, err)
+	require.Nil(t, artifact)
+}
+
+func TestUploadSessionPersistence(t *testing.T) {
+	user := createTestUser(t, "user_upload_session")
+	otherUser := createTestUser(t, "other_user_upload_session")
+	mimeType := "video/mp4"
+
+	session, err := testStore.CreateUploadSession(context.Background(), CreateUploadSessionParams{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		OwnerID:      user.ID,
+		FileName:     "movie.mp4",
+		MimeType:     &mimeType,
+		TotalSize:    10,
+		TempLocation: ".uploads/test",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	err = testStore.RecordUploadChunk(context.Background(), session.ID, 0, 4, &checksum, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	err = testStore.RecordUploadChunk(context.Background(), session.ID, 4, 3, nil, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+
+	loaded, err := testStore.GetUploadSession(context.Background(), session.ID, user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	require.Equal(t, int64(7), loaded.ReceivedBytes)
+	require.Equal(t, []models.UploadChunk{{Offset: 0, SizeBytes: 4, Checksum: &checksum}, {Offset: 4, SizeBytes: 3}}, loaded.Chunks)
+	require.True(t, loaded.ExpiresAt.After(session.ExpiresAt), "Receiving a chunk should extend the session")
+
+	notOwned, err := testStore.GetUploadSession(context.Background(), session.ID, otherUser.ID)
+	require.NoError(t, err)
+	require.Nil(t, notOwned)
+
+	err = testStore.RecordUploadChunk(context.Background(), uuid.New(), 0, 1, nil, time.Now())
+	require.Error(t, err)
+
+	expired, err := testStore.CreateUploadSession(context.Background(), CreateUploadSessionParams{
+		ID: uuid.New(), UserID: user.ID, OwnerID: user.ID, FileName: "old.bin", TotalSize: 1,
+		TempLocation: ".uploads/expired", ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	locations, err := testStore.DeleteExpiredUploadSessions(context.Background(), time.Now())
+	require.NoError(t, err)
+	require.Contains(t, locations, ".uploads/expired")
+
+	exists, err := testStore.UploadSessionExists(context.Background(), expired.ID)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	exists, err = testStore.UploadSessionExists(context.Background(), session.ID)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestListNodeChangeEventsSince(t *testing.T) {
+	user := createTestUser(t, "user_node_change_events")
+	folder := createTestNode(t, CreateNodeParams{ID: "node_change_folder_01", OwnerID: user.ID, Name: "Folder", NodeType: "folder"})
+	child := createTestNode(t, CreateNodeParams{ID: "node_change_child_001", OwnerID: user.ID, ParentID: &folder.ID, Name: "child.txt", NodeType: "file"})
+	createTestNode(t, CreateNodeParams{ID: "node_change_other_001", OwnerID: user.ID, Name: "other.txt", NodeType: "file"})
+
+	cursor, err := testStore.GetLatestEventID(context.Background(), user.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, testStore.LogEvent(context.Background(), user.ID, "node_created", child))
+	require.NoError(t, testStore.LogEvent(context.Background(), user.ID, "favorite_added", map[string]string{"node_id": child.ID}))
+	require.NoError(t, testStore.LogEvent(context.Background(), user.ID, "node_renamed", map[string]string{"id": child.ID, "old_name": "a", "new_name": "child.txt"}))
+
+	events, err := testStore.ListNodeChangeEventsSince(context.Background(), user.ID, cursor, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2, "Only structural node events should be returned")
+	require.Equal(t, "node_created", events[0].EventType)
+	require.Contains(t, string(events[0].Payload), child.ID)
+
+	latest, err := testStore.GetLatestEventID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, events[1].ID, latest)
+
+	children, err := testStore.ListChildrenByIDs(context.Background(), folder.ID, []string{child.ID, "node_change_other_001"})
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Equal(t, child.ID, children[0].ID)
+}
+
+func TestNodeWatches(t *testing.T) {
+	owner := createTestUser(t, "user_watch_owner")
+	watcher := createTestUser(t, "user_watch_recipient")
+	folder := createTestNode(t, CreateNodeParams{ID: "watch_folder_00000001", OwnerID: owner.ID, Name: "Watched", NodeType: "folder"})
+	subfolder := createTestNode(t, CreateNodeParams{ID: "watch_subfolder_00001", OwnerID: owner.ID, ParentID: &folder.ID, Name: "Sub", NodeType: "folder"})
+	file := createTestNode(t, CreateNodeParams{ID: "watch_file_0000000001", OwnerID: owner.ID, ParentID: &subfolder.ID, Name: "doc.txt", NodeType: "file"})
+	outside := createTestNode(t, CreateNodeParams{ID: "watch_outside_0000001", OwnerID: owner.ID, Name: "outside.txt", NodeType: "file"})
+
+	err := testStore.WatchNode(context.Background(), watcher.ID, folder.ID, "daily")
+	require.ErrorIs(t, err, ErrNodeNotFound, "Users without access cannot watch a folder")
+
+	createTestShare(t, ShareNodeParams{NodeID: folder.ID, SharerID: owner.ID, RecipientID: watcher.ID, Permissions: "read"})
+
+	require.ErrorIs(t, testStore.WatchNode(context.Background(), watcher.ID, folder.ID, "hourly"), ErrInvalidDigestFrequency)
+	require.ErrorIs(t, testStore.WatchNode(context.Background(), watcher.ID, file.ID, "daily"), ErrNodeNotFound)
+	require.NoError(t, testStore.WatchNode(context.Background(), watcher.ID, folder.ID, "daily"))
+	require.NoError(t, testStore.WatchNode(context.Background(), owner.ID, subfolder.ID, "weekly"))
+
+	watchers, err := testStore.ListWatchersForNodes(context.Background(), []string{file.ID})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int64{owner.ID, watcher.ID}, watchers)
+
+	watchers, err = testStore.ListWatchersForNodes(context.Background(), []string{outside.ID})
+	require.NoError(t, err)
+	require.Empty(t, watchers)
+
+	since := time.Now().Add(-time.Minute)
+	require.NoError(t, testStore.LogEvent(context.Background(), owner.ID, "node_renamed", map[string]string{"id": file.ID, "old_name": "a", "new_name": "doc.txt"}))
+	require.NoError(t, testStore.LogEvent(context.Background(), owner.ID, "node_renamed", map[string]string{"id": outside.ID, "old_name": "b", "new_name": "outside.txt"}))
+
+	events, err := testStore.ListSubtreeEventsSince(context.Background(), owner.ID, folder.ID, since, time.Now().Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Contains(t, string(events[0].Payload), file.ID)
+
+	due, err := testStore.ListDueWatches(context.Background(), time.Now().Add(2*24*time.Hour))
+	require.NoError(t, err)
+	var dueForWatcher, dueForOwner bool
+	for _, watch := range due {
+		dueForWatcher = dueForWatcher || (watch.UserID == watcher.ID && watch.NodeID == folder.ID)
+		dueForOwner = dueForOwner || (watch.UserID == owner.ID && watch.NodeID == subfolder.ID)
+	}
+	require.True(t, dueForWatcher, "Daily watch should be due after two days")
+	require.False(t, dueForOwner, "Weekly watch should not be due after two days")
+
+	require.NoError(t, testStore.MarkWatchDigested(context.Background(), watcher.ID, folder.ID, time.Now().Add(2*24*time.Hour)))
+	due, err = testStore.ListDueWatches(context.Background(), time.Now().Add(2*24*time.Hour))
+	require.NoError(t, err)
+	for _, watch := range due {
+		require.False(t, watch.UserID == watcher.ID && watch.NodeID == folder.ID)
+	}
+
+	removed, err := testStore.UnwatchNode(context.Background(), watcher.ID, folder.ID)
+	require.NoError(t, err)
+	require.True(t, removed)
+	removed, err = testStore.UnwatchNode(context.Background(), watcher.ID, folder.ID)
+	require.NoError(t, err)
+	require.False(t, removed)
+}
+
+func TestShareMessage(t *testing.T) {
+	sharer := createTestUser(t, "sharer_with_message")
+	recipient := createTestUser(t, "recipient_with_message")
+	node := createTestNode(t, CreateNodeParams{ID: "share_message_node_01", OwnerID: sharer.ID, Name: "Audit", NodeType: "folder"})
+
+	message := "for the audit, read-only until Friday"
+	share := createTestShare(t, ShareNodeParams{NodeID: node.ID, SharerID: sharer.ID, RecipientID: recipient.ID, Permissions: "read", Message: &message})
+	require.NotNil(t, share.Message)
+	require.Equal(t, message, *share.Message)
+
+	outgoing, err := testStore.GetOutgoingShares(context.Background(), sharer.ID, OutgoingShareFilter{}, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, outgoing, 1)
+	require.Equal(t, message, *outgoing[0].Message)
+
+	incoming, err := testStore.ListDirectlySharedNodes(context.Background(), recipient.ID, sharer.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, incoming, 1)
+	require.Equal(t, "read", incoming[0].SharePermissions)
+	require.Equal(t, message, *incoming[0].ShareMessage)
+
+	fetched, err := testStore.GetShareByID(context.Background(), share.ID, sharer.ID)
+	require.NoError(t, err)
+	require.Equal(t, message, *fetched.Message)
+}
+
+func TestFavoritesOfRevokedShares(t *testing.T) {
+	user := createTestUser(t, "user_fav_revoked")
+	sharer := createTestUser(t, "sharer_fav_revoked")
+
+	ownNode := createTestNode(t, CreateNodeParams{ID: "fav_revoked_own", OwnerID: user.ID, Name: "Own", NodeType: "file"})
+	sharedFolder := createTestNode(t, CreateNodeParams{ID: "fav_revoked_folder", OwnerID: sharer.ID, Name: "Shared", NodeType: "folder"})
+	nestedFile := createTestNode(t, CreateNodeParams{ID: "fav_revoked_nested", OwnerID: sharer.ID, ParentID: &sharedFolder.ID, Name: "Nested", NodeType: "file"})
+	share := createTestShare(t, ShareNodeParams{NodeID: sharedFolder.ID, SharerID: sharer.ID, RecipientID: user.ID, Permissions: "read"})
+
+	require.NoError(t, testStore.AddFavorite(context.Background(), user.ID, ownNode.ID))
+	require.NoError(t, testStore.AddFavorite(context.Background(), user.ID, nestedFile.ID))
+
+	favorites, err := testStore.ListFavorites(context.Background(), user.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, favorites, 2)
+
+	require.NoError(t, testStore.DeleteShare(context.Background(), share.ID, sharer.ID))
+
+	favorites, err = testStore.ListFavorites(context.Background(), user.ID, 100, 0)
+	require.NoError(t, err)
+	require.Len(t, favorites, 1, "A favorite of a no longer shared node must not be listed")
+	require.Equal(t, ownNode.ID, favorites[0].ID)
+
+	removed, err := testStore.DeleteInaccessibleFavorites(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, removed, RemovedFavorite{UserID: user.ID, NodeID: nestedFile.ID})
+	require.NotContains(t, removed, RemovedFavorite{UserID: user.ID, NodeID: ownNode.ID})
+
+	stillThere, err := testStore.RemoveFavorite(context.Background(), user.ID, nestedFile.ID)
+	require.NoError(t, err)
+	require.False(t, stillThere, "The favorite should have been purged")
+}
+
+func TestOrphanedNodesRepair(t *testing.T) {
+	ctx := context.Background()
+	owner := createTestUser(t, "owner_orphans")
+
+	parent := createTestNode(t, CreateNodeParams{ID: "orphan_parent", OwnerID: owner.ID, Name: "Parent", NodeType: "folder"})
+	orphan := createTestNode(t, CreateNodeParams{ID: "orphan_child", OwnerID: owner.ID, ParentID: &parent.ID, Name: "Child.txt", NodeType: "file"})
+	healthy := createTestNode(t, CreateNodeParams{ID: "orphan_healthy", OwnerID: owner.ID, Name: "Healthy", NodeType: "folder"})
+
+	// Simulate the inconsistency: the parent is trashed without its child.
+	_, err := testStore.GetPool().Exec(ctx, "UPDATE nodes SET deleted_at = NOW(), original_parent_id = parent_id, parent_id = NULL WHERE id = $1", parent.ID)
+	require.NoError(t, err)
+
+	orphans, err := testStore.ListOrphanedNodes(ctx, 1000, 0)
+	require.NoError(t, err)
+	issues := map[string]string{}
+	for _, o := range orphans {
+		issues[o.ID] = o.Issue
+	}
+	require.Equal(t, "parent_trashed", issues[orphan.ID])
+	require.NotContains(t, issues, healthy.ID)
+
+	folder, created, err := testStore.GetOrCreateRecoveryFolder(ctx, owner.ID, "orphan_recovery")
+	require.NoError(t, err)
+	require.True(t, created)
+	again, created, err := testStore.GetOrCreateRecoveryFolder(ctx, owner.ID, "orphan_recovery_2")
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, folder.ID, again.ID)
+
+	createTestNode(t, CreateNodeParams{ID: "orphan_name_taken", OwnerID: owner.ID, ParentID: &folder.ID, Name: "Child.txt", NodeType: "file"})
+
+	repaired, err := testStore.ReparentOrphanedNode(ctx, orphan.ID, folder.ID)
+	require.NoError(t, err)
+	require.NotNil(t, repaired)
+	require.Equal(t, folder.ID, *repaired.ParentID)
+	require.Equal(t, "Child.txt (orphan_child)", repaired.Name)
+
+	orphans, err = testStore.ListOrphanedNodes(ctx, 1000, 0)
+	require.NoError(t, err)
+	for _, o := range orphans {
+		require.NotEqual(t, orphan.ID, o.ID)
+	}
+
+	user, err := testStore.GetUserByID(ctx, owner.ID)
+	require.NoError(t, err)
+	require.False(t, user.IsAdmin)
+}
+
+func TestLogEventsBatch(t *testing.T) {
+	ctx := context.Background()
+	user := createTestUser(t, "user_log_events_batch")
+	other := createTestUser(t, "other_log_events_batch")
+
+	cursor, err := testStore.GetLatestEventID(ctx, user.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, testStore.LogEvents(ctx, nil))
+	require.NoError(t, testStore.LogEvents(ctx, []EventEntry{
+		{UserID: user.ID, EventType: "node_created", Payload: map[string]string{"id": "batch_first"}},
+		{UserID: other.ID, EventType: "node_created", Payload: map[string]string{"id": "batch_other"}},
+		{UserID: user.ID, EventType: "node_created", Payload: map[string]string{"id": "batch_second"}},
+	}))
+
+	events, err := testStore.ListNodeChangeEventsSince(ctx, user.ID, cursor, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.JSONEq(t, `{"id":"batch_first"}`, string(events[0].Payload))
+	require.JSONEq(t, `{"id":"batch_second"}`, string(events[1].Payload))
+}
+
+func TestQueryTracer(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tracer := NewQueryTracer(time.Nanosecond)
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{
+		SQL:  "SELECT id\n\t\tFROM users WHERE password_hash = $1",
+		Args: []any{"super-secret-hash"},
+	})
+	time.Sleep(time.Millisecond)
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+	require.Contains(t, logs.String(), "Slow query select")
+	require.Contains(t, logs.String(), "SELECT id FROM users WHERE password_hash = $1")
+	require.NotContains(t, logs.String(), "super-secret-hash", "Bound parameters must be redacted")
+
+	user := createTestUser(t, "user_query_tracer")
+	require.Equal(t, "GetUserByID", func() string {
+		var name string
+		tracing := &nameCapturingDB{DBTX: testStore.pool, name: &name}
+		_, err := New(tracing).GetUserByID(context.Background(), user.ID)
+		require.NoError(t, err)
+		return name
+	}())
+}
+
+// nameCapturingDB records the query name the tracer would assign to the
+// statements issued through it.
+type nameCapturingDB struct {
+	DBTX
+	name *string
+}
+
+func (db *nameCapturingDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	*db.name = queryName(sql)
+	return db.DBTX.QueryRow(ctx, sql, args...)
+}
+
+func TestRefreshTokenRotationFamily(t *testing.T) {
+	ctx := context.Background()
+	user := createTestUser(t, "user_token_family")
+
+	loginID := uuid.New()
+	require.NoError(t, testStore.CreateSession(ctx, CreateSessionParams{ID: loginID, UserID: user.ID, RefreshToken: "family_token_1", ExpiresAt: time.Now().Add(time.Hour)}))
+
+	family, err := testStore.ConsumeRefreshToken(ctx, "family_token_1", "family_hash_1")
+	require.NoError(t, err)
+	require.NotNil(t, family)
+	require.Equal(t, loginID, family.FamilyID, "A login session starts its own family")
+
+	again, err := testStore.ConsumeRefreshToken(ctx, "family_token_1", "family_hash_1")
+	require.NoError(t, err)
+	require.Nil(t, again, "A token can only be consumed once")
+
+	require.NoError(t, testStore.CreateSession(ctx, CreateSessionParams{ID: uuid.New(), UserID: user.ID, RefreshToken: "family_token_2", ExpiresAt: time.Now().Add(time.Hour), FamilyID: family.FamilyID}))
+
+	rotated, err := testStore.FindRotatedRefreshToken(ctx, "family_hash_1")
+	require.NoError(t, err)
+	require.NotNil(t, rotated)
+	require.Equal(t, family.FamilyID, rotated.FamilyID)
+
+	revoked, err := testStore.RevokeSessionFamily(ctx, family.FamilyID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), revoked)
+
+	rotated, err = testStore.FindRotatedRefreshToken(ctx, "family_hash_1")
+	require.NoError(t, err)
+	require.Nil(t, rotated)
+}
+
+func TestFolderCleanupCandidates(t *testing.T) {
+	user := createTestUser(t, "user_folder_cleanup")
+	folder := createTestNode(t, CreateNodeParams{ID: "cleanup_folder", OwnerID: user.ID, Name: "Logi", NodeType: "folder"})
+	createTestNode(t, CreateNodeParams{ID: "cleanup_sub", OwnerID: user.ID, ParentID: &folder.ID, Name: "Archiwum", NodeType: "folder"})
+	for i, id := range []string{"cleanup_file_1", "cleanup_file_2", "cleanup_file_3"} {
+		createTestNode(t, CreateNodeParams{ID: id, OwnerID: user.ID, ParentID: &folder.ID, Name: fmt.Sprintf("log-%d.txt", i), NodeType: "file"})
+	}
+	_, err := testStore.pool.Exec(context.Background(), `UPDATE nodes SET modified_at = NOW() - INTERVAL '100 days' WHERE id = 'cleanup_file_1'`)
+	require.NoError(t, err)
+
+	keepNewest, maxAgeDays := 1, 90
+	policy, err := testStore.SetFolderCleanupPolicy(context.Background(), folder.ID, user.ID, nil, &keepNewest)
+	require.NoError(t, err)
+
+	candidates, err := testStore.ListCleanupCandidates(context.Background(), *policy)
+	require.NoError(t, err)
+	require.Len(t, candidates, 2, "Folders are never cleaned up and the newest file is kept")
+	require.Equal(t, "cleanup_file_1", candidates[0].ID)
+
+	policy, err = testStore.SetFolderCleanupPolicy(context.Background(), folder.ID, user.ID, &maxAgeDays, nil)
+	require.NoError(t, err)
+	candidates, err = testStore.ListCleanupCandidates(context.Background(), *policy)
+	require.NoError(t, err)
+	require.Len(t, candidates, 1)
+	require.Equal(t, "cleanup_file_1", candidates[0].ID)
+
+	policies, err := testStore.ListFolderCleanupPolicies(context.Background())
+	require.NoError(t, err)
+	found := false
+	for _, p := range policies {
+		if p.FolderID == folder.ID {
+			found = true
+			require.Equal(t, maxAgeDays, *p.MaxAgeDays)
+			require.Nil(t, p.KeepNewest)
+		}
+	}
+	require.True(t, found)
+}
+
+func TestNodeStorageBackend(t *testing.T) {
+	user := createTestUser(t, "storage_backend_user")
+	ctx := context.Background()
+
+	var fileSize int64 = 100
+	video := createTestNode(t, CreateNodeParams{ID: "backend_video", OwnerID: user.ID, Name: "film.mp4", NodeType: "file", SizeBytes: &fileSize, StorageBackend: "archive"})
+	doc := createTestNode(t, CreateNodeParams{ID: "backend_doc", OwnerID: user.ID, Name: "notatka.txt", NodeType: "file", SizeBytes: &fileSize})
+
+	backend, key, err := testStore.GetNodeStorageBackend(ctx, video.ID)
+	require.NoError(t, err)
+	require.Equal(t, "archive", backend)
+	require.Equal(t, video.ID, key, "Content is stored under the node ID unless it is deduplicated")
+	backend, _, err = testStore.GetNodeStorageBackend(ctx, doc.ID)
+	require.NoError(t, err)
+	require.Equal(t, "local", backend, "Nodes without a backend are stored locally")
+	backend, key, err = testStore.GetNodeStorageBackend(ctx, "backend_missing")
+	require.NoError(t, err)
+	require.Empty(t, backend)
+	require.Empty(t, key)
+
+	require.NoError(t, testStore.SetNodeStorageBackend(ctx, doc.ID, "archive"))
+	_, err = testStore.MoveNodeToTrash(ctx, doc.ID, user.ID)
+	require.NoError(t, err)
+
+	trashed, err := testStore.ListTrashedFileBackends(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{doc.ID: "archive"}, trashed)
+}
+
+func TestContentBlobRefCounts(t *testing.T) {
+	user := createTestUser(t, "content_blob_user")
+	ctx := context.Background()
+
+	refs, err := testStore.AcquireContentBlob(ctx, "local", "sha256-aaa", 100)
+	require.NoError(t, err)
+	require.Equal(t, 1, refs, "The first reference registers the blob")
+	refs, err = testStore.AcquireContentBlob(ctx, "local", "sha256-aaa", 100)
+	require.NoError(t, err)
+	require.Equal(t, 2, refs)
+	refs, err = testStore.AcquireContentBlob(ctx, "archive", "sha256-aaa", 100)
+	require.NoError(t, err)
+	require.Equal(t, 1, refs, "Blobs are counted per backend")
+
+	refs, err = testStore.ReleaseContentBlob(ctx, "local", "sha256-aaa")
+	require.NoError(t, err)
+	require.Equal(t, 1, refs)
+	refs, err = testStore.ReleaseContentBlob(ctx, "local", "sha256-aaa")
+	require.NoError(t, err)
+	require.Equal(t, 0, refs)
+	refs, err = testStore.AcquireContentBlob(ctx, "local", "sha256-aaa", 100)
+	require.NoError(t, err)
+	require.Equal(t, 1, refs, "A blob released by every file is unregistered")
+
+	var fileSize int64 = 100
+	key := "sha256-bbb"
+	for _, id := range []string{"blob_first", "blob_second", "blob_third"} {
+		createTestNode(t, CreateNodeParams{ID: id, OwnerID: user.ID, Name: id + ".txt", NodeType: "file", SizeBytes: &fileSize, StorageKey: &key})
+		_, err := testStore.AcquireContentBlob(ctx, "local", key, fileSize)
+		require.NoError(t, err)
+	}
+	backend, storedKey, err := testStore.GetNodeStorageBackend(ctx, "blob_first")
+	require.NoError(t, err)
+	require.Equal(t, "local", backend)
+	require.Equal(t, key, storedKey)
+
+	_, err = testStore.MoveNodeToTrash(ctx, "blob_first", user.ID)
+	require.NoError(t, err)
+	_, err = testStore.MoveNodeToTrash(ctx, "blob_second", user.ID)
+	require.NoError(t, err)
+	trashed, err := testStore.ListTrashedFileBackends(ctx, user.ID)
+	require.NoError(t, err)
+	require.Empty(t, trashed, "Deduplicated files are not removed one by one")
+	released, err := testStore.ReleaseTrashedContentBlobs(ctx, user.ID)
+	require.NoError(t, err)
+	require.Empty(t, released, "The blob is still referenced by a file outside the trash")
+	_, _, err = testStore.PurgeTrash(ctx, user.ID)
+	require.NoError(t, err)
+
+	_, err = testStore.MoveNodeToTrash(ctx, "blob_third", user.ID)
+	require.NoError(t, err)
+	released, err = testStore.ReleaseTrashedContentBlobs(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, []ContentBlob{{StorageBackend: "local", StorageKey: key}}, released)
+
+	refs, err = testStore.AcquireContentBlob(ctx, "local", key, 3)
+	require.NoError(t, err)
+	require.Equal(t, 1, refs, "Content uploaded again after the release has to be stored")
+	deleted, err := testStore.DeleteUnreferencedContentBlob(ctx, "local", key)
+	require.NoError(t, err)
+	require.False(t, deleted, "A blob referenced again is kept")
+	_, err = testStore.ReleaseContentBlob(ctx, "local", key)
+	require.NoError(t, err)
+	registered, err := testStore.ContentBlobRegistered(ctx, "local", key)
+	require.NoError(t, err)
+	require.False(t, registered)
+}
+
+func TestStoreReadReplica(t *testing.T) {
+	require.Same(t, testStore.Queries, testStore.ReadReplica(), "Without a replica reads go to the primary")
+	require.Nil(t, testStore.GetReplicaPool())
+
+	withReplica := NewStoreWithReplica(testStore.pool, testStore.pool)
+	require.NotSame(t, withReplica.Queries, withReplica.ReadReplica())
+	require.Equal(t, testStore.pool, withReplica.GetReplicaPool())
+
+	user := createTestUser(t, "replica_user")
+	createTestNode(t, CreateNodeParams{ID: "replica_node", OwnerID: user.ID, Name: "plik.txt", NodeType: "file"})
+	nodes, err := withReplica.ReadReplica().GetNodesByParentID(context.Background(), user.ID, nil, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+}
+
+func TestCheckInvariants(t *testing.T) {
+	user := createTestUser(t, "invariants_user")
+	ctx := context.Background()
+
+	var fileSize int64 = 100
+	key := "sha256-invariants"
+	createTestNode(t, CreateNodeParams{ID: "invariant_file", OwnerID: user.ID, Name: "a.txt", NodeType: "file", SizeBytes: &fileSize, StorageKey: &key})
+	createTestNode(t, CreateNodeParams{ID: "invariant_legacy", OwnerID: user.ID, Name: "b.txt", NodeType: "file", SizeBytes: &fileSize})
+
+	violations, err := testStore.CheckInvariants(ctx, false, user.ID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []InvariantViolation{
+		{Invariant: InvariantQuotaDrift, Subject: fmt.Sprintf("user %d", user.ID), Expected: 200, Actual: 0},
+		{Invariant: InvariantUnregisteredBlob, Subject: "local/" + key, Expected: 1},
+	}, violations)
+
+	_, err = testStore.AcquireContentBlob(ctx, "local", key, fileSize)
+	require.NoError(t, err)
+	_, err = testStore.AcquireContentBlob(ctx, "local", key, fileSize)
+	require.NoError(t, err)
+	require.NoError(t, testStore.UpdateUserStorage(ctx, user.ID, 200))
+	violations, err = testStore.CheckInvariants(ctx, false, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, []InvariantViolation{{Invariant: InvariantBlobRefCount, Subject: "local/" + key, Expected: 1, Actual: 2}}, violations)
+
+	_, err = testStore.ReleaseContentBlob(ctx, "local", key)
+	require.NoError(t, err)
+	violations, err = testStore.CheckInvariants(ctx, false, user.ID)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+
+	unreferenced, err := testStore.UnreferencedStorageKeys(ctx, "local", []string{key, "invariant_legacy", "invariant_orphan"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"invariant_orphan"}, unreferenced)
+	unreferenced, err = testStore.UnreferencedStorageKeys(ctx, "archive", []string{key})
+	require.NoError(t, err)
+	require.Equal(t, []string{key}, unreferenced, "Keys are looked up per backend")
+}
+
+func TestStoreWithChaos(t *testing.T) {
+	user := createTestUser(t, "chaos_store_user")
+	ctx := context.Background()
+
+	injector := chaos.New(1, 1, 0)
+	chaotic := testStore.WithChaos(injector)
+	_, err := chaotic.GetUserByID(ctx, user.ID)
+	require.ErrorIs(t, err, chaos.ErrInjected)
+	err = chaotic.ExecTx(ctx, func(q *Queries) error { return nil })
+	require.ErrorIs(t, err, chaos.ErrInjected)
+
+	commitOnly := testStore.WithChaos(chaos.New(1, 1, 0, chaos.DBCommit))
+	err = commitOnly.ExecTx(ctx, func(q *Queries) error {
+		return q.UpdateUserStorage(ctx, user.ID, 500)
+	})
+	require.ErrorIs(t, err, chaos.ErrInjected)
+	stored, err := testStore.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, stored.StorageUsedBytes, "A failed commit rolls the transaction back")
+
+	injector.SetFailureRate(0)
+	stored, err = chaotic.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user.ID, stored.ID)
+}
@@ -3,13 +3,20 @@ package database
 import (
 	"context"
 	"fmt"
+	"serwer-plikow/internal/chaos"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Store struct {
 	pool *pgxpool.Pool
 	*Queries
+	replicaPool *pgxpool.Pool
+	replica     *Queries
+	// chaos is set on stores created by WithChaos.
+	chaos *chaos.Injector
 }
 
 func NewStore(pool *pgxpool.Pool) *Store {
@@ -19,7 +26,32 @@ func NewStore(pool *pgxpool.Pool) *Store {
 	}
 }
 
+// NewStoreWithReplica creates a store whose staleness-tolerant reads can be
+// served by a read replica; see ReadReplica.
+func NewStoreWithReplica(pool, replicaPool *pgxpool.Pool) *Store {
+	store := NewStore(pool)
+	store.replicaPool = replicaPool
+	store.replica = New(replicaPool)
+	return store
+}
+
+// ReadReplica returns queries served by the read replica, or by the primary
+// when no replica is configured. A replica lags behind the primary, so it may
+// only be used for reads that tolerate slightly stale results and never for
+// a read that a following write depends on.
+func (s *Store) ReadReplica() *Queries {
+	if s.replica == nil {
+		return s.Queries
+	}
+	return s.replica
+}
+
 func (s *Store) ExecTx(ctx context.Context, fn func(*Queries) error) error {
+	if s.chaos != nil {
+		if err := s.chaos.Fault(chaos.DBBegin); err != nil {
+			return err
+		}
+	}
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return err
@@ -27,6 +59,9 @@ func (s *Store) ExecTx(ctx context.Context, fn func(*Queries) error) error {
 	defer tx.Rollback(ctx)
 
 	q := New(tx)
+	if s.chaos != nil {
+		q = New(chaosDB{db: tx, injector: s.chaos})
+	}
 	err = fn(q)
 	if err != nil {
 		if rbErr := tx.Rollback(ctx); rbErr != nil {
@@ -35,9 +70,33 @@ func (s *Store) ExecTx(ctx context.Context, fn func(*Queries) error) error {
 		return err
 	}
 
+	if s.chaos != nil {
+		if err := s.chaos.Fault(chaos.DBCommit); err != nil {
+			return err
+		}
+	}
 	return tx.Commit(ctx)
 }
 
 func (s *Store) GetPool() *pgxpool.Pool {
 	return s.pool
 }
+
+// GetReplicaPool returns the read replica's pool, or nil when none is
+// configured.
+func (s *Store) GetReplicaPool() *pgxpool.Pool {
+	return s.replicaPool
+}
+
+// CopyNodes inserts node records with a single COPY, which is much faster
+// than one INSERT per node for large batches. Parents must come before their
+// children. The batch is inserted entirely or not at all; the owners'
+// storage usage is not updated.
+func (s *Store) CopyNodes(ctx context.Context, nodes []CreateNodeParams) (int64, error) {
+	now := time.Now()
+	columns := []string{"id", "owner_id", "parent_id", "name", "node_type", "size_bytes", "mime_type", "created_at", "modified_at"}
+	return s.pool.CopyFrom(ctx, pgx.Identifier{"nodes"}, columns, pgx.CopyFromSlice(len(nodes), func(i int) ([]any, error) {
+		n := nodes[i]
+		return []any{n.ID, n.OwnerID, n.ParentID, n.Name, n.NodeType, n.SizeBytes, n.MimeType, now, now}, nil
+	}))
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of SQL queries, labeled by the Queries method that issued them.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	},
+	[]string{"query", "status"},
+)
+
+const queriesMethodPrefix = "serwer-plikow/internal/database.(*Queries)."
+
+type queryTraceKey struct{}
+
+type queryTrace struct {
+	name  string
+	sql   string
+	args  int
+	start time.Time
+}
+
+// QueryTracer is a pgx tracer that records query latencies in Prometheus and
+// logs queries slower than SlowThreshold. Bound parameters are never logged.
+// A zero SlowThreshold disables slow-query logging.
+type QueryTracer struct {
+	SlowThreshold time.Duration
+}
+
+func NewQueryTracer(slowThreshold time.Duration) *QueryTracer {
+	return &QueryTracer{SlowThreshold: slowThreshold}
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, &queryTrace{
+		name:  queryName(data.SQL),
+		sql:   data.SQL,
+		args:  len(data.Args),
+		start: time.Now(),
+	})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(trace.start)
+
+	status := "ok"
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		status = "error"
+	}
+	queryDuration.WithLabelValues(trace.name, status).Observe(elapsed.Seconds())
+
+	if t.SlowThreshold > 0 && elapsed >= t.SlowThreshold {
+		log.Printf("WARN: Slow query %s took %s (%d parameters redacted): %s", trace.name, elapsed.Round(time.Millisecond), trace.args, compactSQL(trace.sql))
+	}
+}
+
+// queryName identifies a query by the exported Queries method on the call
+// stack, falling back to the SQL verb for statements issued elsewhere (such as
+// transaction control).
+func queryName(sql string) string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if method, ok := strings.CutPrefix(frame.Function, queriesMethodPrefix); ok && method != "" && unicode.IsUpper(rune(method[0])) {
+			return method
+		}
+		if !more {
+			break
+		}
+	}
+
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		return strings.ToLower(fields[0])
+	}
+	return "unknown"
+}
+
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
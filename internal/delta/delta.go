@@ -0,0 +1,290 @@
+// Package delta implements an rsync-style block signature and binary patch
+// format used to update large files without transferring their whole content.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	MinBlockSize = 2 << 10
+	MaxBlockSize = 1 << 20
+
+	strongHashBytes = 16
+)
+
+var patchMagic = []byte("FSD1")
+
+const (
+	opEnd     byte = 0x00
+	opCopy    byte = 0x01
+	opLiteral byte = 0x02
+)
+
+var ErrInvalidPatch = errors.New("invalid delta patch")
+
+type BlockSignature struct {
+	Index  int64  `json:"index"`
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+type Signature struct {
+	BlockSize int              `json:"block_size"`
+	FileSize  int64            `json:"file_size"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// DefaultBlockSize picks a block size close to the square root of the file
+// size, which keeps both the signature and the patch reasonably small.
+func DefaultBlockSize(fileSize int64) int {
+	size := int(math.Sqrt(float64(fileSize)))
+	if size < MinBlockSize {
+		return MinBlockSize
+	}
+	if size > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return size
+}
+
+func weakChecksum(block []byte) (a, b uint32) {
+	n := uint32(len(block))
+	for i, c := range block {
+		a += uint32(c)
+		b += (n - uint32(i)) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+func strongChecksum(block []byte) string {
+	sum := sha256.Sum256(block)
+	return hex.EncodeToString(sum[:strongHashBytes])
+}
+
+func ComputeSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return nil, fmt.Errorf("block size must be between %d and %d bytes", MinBlockSize, MaxBlockSize)
+	}
+
+	sig := &Signature{BlockSize: blockSize, Blocks: []BlockSignature{}}
+	buf := make([]byte, blockSize)
+	var index int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			a, b := weakChecksum(buf[:n])
+			sig.Blocks = append(sig.Blocks, BlockSignature{
+				Index:  index,
+				Weak:   a | b<<16,
+				Strong: strongChecksum(buf[:n]),
+			})
+			sig.FileSize += int64(n)
+			index++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteDelta encodes newContent as a patch against the file described by sig.
+// It keeps newContent in memory and is meant for clients and tests; the server
+// only ever applies patches.
+func WriteDelta(sig *Signature, newContent []byte, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(patchMagic); err != nil {
+		return err
+	}
+
+	byWeak := make(map[uint32][]BlockSignature, len(sig.Blocks))
+	for _, block := range sig.Blocks {
+		byWeak[block.Weak] = append(byWeak[block.Weak], block)
+	}
+
+	blockSize := sig.BlockSize
+	var literal bytes.Buffer
+	var copyStart, copyCount int64 = -1, 0
+
+	flushLiteral := func() error {
+		if literal.Len() == 0 {
+			return nil
+		}
+		if err := writeOp(bw, opLiteral, uint64(literal.Len())); err != nil {
+			return err
+		}
+		_, err := bw.Write(literal.Bytes())
+		literal.Reset()
+		return err
+	}
+	flushCopy := func() error {
+		if copyCount == 0 {
+			return nil
+		}
+		err := writeOp(bw, opCopy, uint64(copyStart), uint64(copyCount))
+		copyStart, copyCount = -1, 0
+		return err
+	}
+
+	pos := 0
+	var a, b uint32
+	rolling := false
+	for pos < len(newContent) {
+		end := pos + blockSize
+		if end > len(newContent) {
+			end = len(newContent)
+		}
+		window := newContent[pos:end]
+
+		if !rolling {
+			a, b = weakChecksum(window)
+			rolling = true
+		}
+
+		if matched, ok := findBlock(byWeak[a|b<<16], window); ok {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+			if copyCount > 0 && copyStart+copyCount == matched.Index {
+				copyCount++
+			} else {
+				if err := flushCopy(); err != nil {
+					return err
+				}
+				copyStart, copyCount = matched.Index, 1
+			}
+			pos = end
+			rolling = false
+			continue
+		}
+
+		if err := flushCopy(); err != nil {
+			return err
+		}
+		out := newContent[pos]
+		literal.WriteByte(out)
+		pos++
+
+		if end < len(newContent) && end-pos+1 == blockSize {
+			in := newContent[end]
+			a = (a - uint32(out) + uint32(in)) & 0xffff
+			b = (b - uint32(blockSize)*uint32(out) + a) & 0xffff
+		} else {
+			rolling = false
+		}
+	}
+
+	if err := flushCopy(); err != nil {
+		return err
+	}
+	if err := flushLiteral(); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(opEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func findBlock(candidates []BlockSignature, window []byte) (BlockSignature, bool) {
+	if len(candidates) == 0 {
+		return BlockSignature{}, false
+	}
+	strong := strongChecksum(window)
+	for _, candidate := range candidates {
+		if candidate.Strong == strong {
+			return candidate, true
+		}
+	}
+	return BlockSignature{}, false
+}
+
+func writeOp(w *bufio.Writer, op byte, args ...uint64) error {
+	if err := w.WriteByte(op); err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, arg := range args {
+		n := binary.PutUvarint(buf, arg)
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply reconstructs the new content from base (the current file, baseSize
+// bytes long) and a patch produced by WriteDelta. It returns the number of
+// bytes written to out.
+func Apply(base io.ReaderAt, baseSize int64, blockSize int, patch io.Reader, out io.Writer) (int64, error) {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return 0, fmt.Errorf("block size must be between %d and %d bytes", MinBlockSize, MaxBlockSize)
+	}
+
+	br := bufio.NewReader(patch)
+	magic := make([]byte, len(patchMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, patchMagic) {
+		return 0, ErrInvalidPatch
+	}
+
+	var written int64
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return written, fmt.Errorf("%w: unexpected end of patch", ErrInvalidPatch)
+		}
+
+		switch op {
+		case opEnd:
+			return written, nil
+
+		case opCopy:
+			index, err1 := binary.ReadUvarint(br)
+			count, err2 := binary.ReadUvarint(br)
+			if err1 != nil || err2 != nil {
+				return written, fmt.Errorf("%w: malformed copy operation", ErrInvalidPatch)
+			}
+			start := int64(index) * int64(blockSize)
+			length := int64(count) * int64(blockSize)
+			if start < 0 || length <= 0 || start >= baseSize {
+				return written, fmt.Errorf("%w: copy references blocks outside of the base file", ErrInvalidPatch)
+			}
+			if start+length > baseSize {
+				length = baseSize - start
+			}
+			n, err := io.Copy(out, io.NewSectionReader(base, start, length))
+			written += n
+			if err != nil {
+				return written, err
+			}
+
+		case opLiteral:
+			length, err := binary.ReadUvarint(br)
+			if err != nil || length > math.MaxInt64 {
+				return written, fmt.Errorf("%w: malformed literal operation", ErrInvalidPatch)
+			}
+			n, err := io.CopyN(out, br, int64(length))
+			written += n
+			if err != nil {
+				if err == io.EOF {
+					return written, fmt.Errorf("%w: truncated literal data", ErrInvalidPatch)
+				}
+				return written, err
+			}
+
+		default:
+			return written, fmt.Errorf("%w: unknown operation 0x%02x", ErrInvalidPatch, op)
+		}
+	}
+}
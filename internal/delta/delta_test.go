@@ -0,0 +1,91 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func roundTrip(t *testing.T, base, updated []byte, blockSize int) []byte {
+	t.Helper()
+
+	sig, err := ComputeSignature(bytes.NewReader(base), blockSize)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(base)), sig.FileSize)
+
+	var patch bytes.Buffer
+	require.NoError(t, WriteDelta(sig, updated, &patch))
+
+	var out bytes.Buffer
+	n, err := Apply(bytes.NewReader(base), int64(len(base)), blockSize, bytes.NewReader(patch.Bytes()), &out)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(updated)), n)
+	require.Equal(t, string(updated), out.String())
+
+	return patch.Bytes()
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	blockSize := MinBlockSize
+	base := randomBytes(1, 20*blockSize+123)
+
+	t.Run("identical content produces a tiny patch", func(t *testing.T) {
+		patch := roundTrip(t, base, base, blockSize)
+		require.Less(t, len(patch), 32)
+	})
+
+	t.Run("insertion in the middle", func(t *testing.T) {
+		updated := append([]byte{}, base[:5*blockSize+17]...)
+		updated = append(updated, []byte("inserted bytes")...)
+		updated = append(updated, base[5*blockSize+17:]...)
+
+		patch := roundTrip(t, base, updated, blockSize)
+		require.Less(t, len(patch), 3*blockSize)
+	})
+
+	t.Run("appended and truncated content", func(t *testing.T) {
+		roundTrip(t, base, append(append([]byte{}, base...), randomBytes(2, 1000)...), blockSize)
+		roundTrip(t, base, base[:7*blockSize+5], blockSize)
+	})
+
+	t.Run("completely different and empty content", func(t *testing.T) {
+		roundTrip(t, base, randomBytes(3, 3*blockSize), blockSize)
+		roundTrip(t, base, []byte{}, blockSize)
+		roundTrip(t, []byte{}, randomBytes(4, 100), blockSize)
+	})
+}
+
+func TestApplyRejectsInvalidPatches(t *testing.T) {
+	blockSize := MinBlockSize
+	base := randomBytes(5, 2*blockSize)
+
+	cases := map[string][]byte{
+		"bad magic":          []byte("XXXX\x00"),
+		"missing end":        []byte("FSD1"),
+		"copy out of bounds": append([]byte("FSD1"), opCopy, 9, 1, opEnd),
+		"truncated literal":  append([]byte("FSD1"), opLiteral, 10, 'a'),
+		"unknown operation":  append([]byte("FSD1"), 0x7f),
+	}
+
+	for name, patch := range cases {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			_, err := Apply(bytes.NewReader(base), int64(len(base)), blockSize, bytes.NewReader(patch), &out)
+			require.ErrorIs(t, err, ErrInvalidPatch)
+		})
+	}
+}
+
+func TestDefaultBlockSize(t *testing.T) {
+	require.Equal(t, MinBlockSize, DefaultBlockSize(0))
+	require.Equal(t, 10000, DefaultBlockSize(100_000_000))
+	require.Equal(t, MaxBlockSize, DefaultBlockSize(1<<50))
+}
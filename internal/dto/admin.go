@@ -0,0 +1,242 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"time"
+)
+
+// Announcement is a message administrators show to every user while it is
+// active.
+type Announcement struct {
+	ID        int64      `json:"id" example:"1"`
+	Message   string     `json:"message" example:"Przerwa techniczna w sobotę 22:00-23:00"`
+	Level     string     `json:"level" example:"warning"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	CreatedBy *int64     `json:"created_by,omitempty" example:"1"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func AnnouncementFrom(announcement database.Announcement) Announcement {
+	return Announcement{
+		ID:        announcement.ID,
+		Message:   announcement.Message,
+		Level:     announcement.Level,
+		StartsAt:  announcement.StartsAt,
+		EndsAt:    announcement.EndsAt,
+		CreatedBy: announcement.CreatedBy,
+		CreatedAt: announcement.CreatedAt,
+	}
+}
+
+func Announcements(announcements []database.Announcement) []Announcement {
+	mapped := make([]Announcement, 0, len(announcements))
+	for _, announcement := range announcements {
+		mapped = append(mapped, AnnouncementFrom(announcement))
+	}
+	return mapped
+}
+
+// SystemStats summarizes the whole server for administrators.
+type SystemStats struct {
+	Users         int64 `json:"users" example:"42"`
+	AdminUsers    int64 `json:"admin_users" example:"2"`
+	DisabledUsers int64 `json:"disabled_users" example:"3"`
+	Files         int64 `json:"files" example:"15320"`
+	Folders       int64 `json:"folders" example:"1204"`
+	UsedBytes     int64 `json:"used_bytes" example:"53687091200"`
+	TrashedFiles  int64 `json:"trashed_files" example:"310"`
+	TrashedBytes  int64 `json:"trashed_bytes" example:"1073741824"`
+	Versions      int64 `json:"versions" example:"2048"`
+	VersionBytes  int64 `json:"version_bytes" example:"4294967296"`
+	QuotaBytes    int64 `json:"quota_bytes" example:"225485783040"`
+	// ChargedBytes is the storage counted against the users' quotas.
+	ChargedBytes   int64 `json:"charged_bytes" example:"54760833024"`
+	Shares         int64 `json:"shares" example:"512"`
+	PublicLinks    int64 `json:"public_links" example:"64"`
+	ActiveSessions int64 `json:"active_sessions" example:"37"`
+	// StoredBlobBytes is the size of the deduplicated content objects.
+	StoredBlobBytes int64 `json:"stored_blob_bytes" example:"48318382080"`
+	PendingUploads  int64 `json:"pending_uploads" example:"4"`
+}
+
+func SystemStatsFrom(stats database.SystemStats) SystemStats {
+	return SystemStats{
+		Users:           stats.Users,
+		AdminUsers:      stats.AdminUsers,
+		DisabledUsers:   stats.DisabledUsers,
+		Files:           stats.Files,
+		Folders:         stats.Folders,
+		UsedBytes:       stats.UsedBytes,
+		TrashedFiles:    stats.TrashedFiles,
+		TrashedBytes:    stats.TrashedBytes,
+		Versions:        stats.Versions,
+		VersionBytes:    stats.VersionBytes,
+		QuotaBytes:      stats.QuotaBytes,
+		ChargedBytes:    stats.ChargedBytes,
+		Shares:          stats.Shares,
+		PublicLinks:     stats.PublicLinks,
+		ActiveSessions:  stats.ActiveSessions,
+		StoredBlobBytes: stats.StoredBlobBytes,
+		PendingUploads:  stats.PendingUploads,
+	}
+}
+
+// QuarantinedNode is a file held by the content policy.
+type QuarantinedNode struct {
+	NodeID        string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID       int64     `json:"owner_id" example:"2"`
+	Name          string    `json:"name" example:"faktura.exe"`
+	MimeType      *string   `json:"mime_type,omitempty" example:"application/x-executable"`
+	SizeBytes     *int64    `json:"size_bytes,omitempty" example:"4096"`
+	Action        string    `json:"action" example:"upload"`
+	Rule          string    `json:"rule" example:"wykonywalne"`
+	Reason        string    `json:"reason" example:"Executables are reviewed before they can be shared"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+func QuarantinedNodeFrom(node database.QuarantinedNode) QuarantinedNode {
+	return QuarantinedNode{
+		NodeID:        node.NodeID,
+		OwnerID:       node.OwnerID,
+		Name:          node.Name,
+		MimeType:      node.MimeType,
+		SizeBytes:     node.SizeBytes,
+		Action:        node.Action,
+		Rule:          node.Rule,
+		Reason:        node.Reason,
+		QuarantinedAt: node.QuarantinedAt,
+	}
+}
+
+func QuarantinedNodes(nodes []database.QuarantinedNode) []QuarantinedNode {
+	mapped := make([]QuarantinedNode, 0, len(nodes))
+	for _, node := range nodes {
+		mapped = append(mapped, QuarantinedNodeFrom(node))
+	}
+	return mapped
+}
+
+// OrphanedNode is a node whose parent is missing or in another owner's tree,
+// with what is wrong with it.
+type OrphanedNode struct {
+	Node
+	Issue string `json:"issue" example:"missing_parent"`
+}
+
+func OrphanedNodes(nodes []database.OrphanedNode) []OrphanedNode {
+	mapped := make([]OrphanedNode, 0, len(nodes))
+	for _, node := range nodes {
+		mapped = append(mapped, OrphanedNode{Node: NodeFrom(node.Node), Issue: node.Issue})
+	}
+	return mapped
+}
+
+// AccessLogEntry records a read of a file: a download, preview or public
+// link access.
+type AccessLogEntry struct {
+	ID         int64     `json:"id" example:"1"`
+	UserID     *int64    `json:"user_id" example:"2"`
+	Username   *string   `json:"username" example:"user2"`
+	NodeID     string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Action     string    `json:"action" example:"download"`
+	ClientIP   *string   `json:"client_ip" example:"203.0.113.7"`
+	UserAgent  *string   `json:"user_agent" example:"Mozilla/5.0"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+func AccessLogEntries(entries []database.AccessLogEntry) []AccessLogEntry {
+	mapped := make([]AccessLogEntry, 0, len(entries))
+	for _, entry := range entries {
+		mapped = append(mapped, AccessLogEntry{
+			ID:         entry.ID,
+			UserID:     entry.UserID,
+			Username:   entry.Username,
+			NodeID:     entry.NodeID,
+			Action:     entry.Action,
+			ClientIP:   entry.ClientIP,
+			UserAgent:  entry.UserAgent,
+			AccessedAt: entry.AccessedAt,
+		})
+	}
+	return mapped
+}
+
+// NodeAncestor is a folder on the path to a node, with the user's share of
+// it when there is one.
+type NodeAncestor struct {
+	NodeID        string  `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Name          string  `json:"name" example:"Projekty"`
+	Depth         int     `json:"depth" example:"0"`
+	OwnerID       int64   `json:"owner_id" example:"2"`
+	Trashed       bool    `json:"trashed" example:"false"`
+	ShareID       *int64  `json:"share_id,omitempty" example:"42"`
+	Permissions   *string `json:"permissions,omitempty" example:"write"`
+	PinnedVersion *int    `json:"pinned_version,omitempty" example:"3"`
+	// GroupID is set when the share was made to a group the user is in;
+	// ShareID is then the group share's ID.
+	GroupID *int64 `json:"group_id,omitempty" example:"7"`
+}
+
+func NodeAncestorFrom(ancestor database.NodeAncestor) NodeAncestor {
+	return NodeAncestor{
+		NodeID:        ancestor.NodeID,
+		Name:          ancestor.Name,
+		Depth:         ancestor.Depth,
+		OwnerID:       ancestor.OwnerID,
+		Trashed:       ancestor.Trashed,
+		ShareID:       ancestor.ShareID,
+		Permissions:   ancestor.Permissions,
+		PinnedVersion: ancestor.PinnedVersion,
+		GroupID:       ancestor.GroupID,
+	}
+}
+
+func NodeAncestors(ancestors []database.NodeAncestor) []NodeAncestor {
+	mapped := make([]NodeAncestor, 0, len(ancestors))
+	for _, ancestor := range ancestors {
+		mapped = append(mapped, NodeAncestorFrom(ancestor))
+	}
+	return mapped
+}
+
+// FeatureFlagOverride is the stored state of a feature flag, replacing its
+// configured default.
+type FeatureFlagOverride struct {
+	Name           string    `json:"name" example:"resumable_uploads"`
+	Enabled        bool      `json:"enabled" example:"true"`
+	RolloutPercent int       `json:"rollout_percent" example:"25"`
+	UpdatedBy      *int64    `json:"updated_by,omitempty" example:"1"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func FeatureFlagOverrideFrom(override database.FeatureFlagOverride) FeatureFlagOverride {
+	return FeatureFlagOverride{
+		Name:           override.Name,
+		Enabled:        override.Enabled,
+		RolloutPercent: override.RolloutPercent,
+		UpdatedBy:      override.UpdatedBy,
+		UpdatedAt:      override.UpdatedAt,
+	}
+}
+
+// FeatureFlagUser turns a feature flag on or off for a single user.
+type FeatureFlagUser struct {
+	UserID    int64     `json:"user_id" example:"2"`
+	Username  string    `json:"username" example:"jkowalski"`
+	Enabled   bool      `json:"enabled" example:"true"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func FeatureFlagUsers(users []database.FeatureFlagUser) []FeatureFlagUser {
+	mapped := make([]FeatureFlagUser, 0, len(users))
+	for _, user := range users {
+		mapped = append(mapped, FeatureFlagUser{
+			UserID:    user.UserID,
+			Username:  user.Username,
+			Enabled:   user.Enabled,
+			CreatedAt: user.CreatedAt,
+		})
+	}
+	return mapped
+}
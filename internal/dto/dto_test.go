@@ -0,0 +1,45 @@
+package dto
+
+import (
+	"encoding/json"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodesEncodesEmptyListing(t *testing.T) {
+	body, err := json.Marshal(Nodes(nil))
+	require.NoError(t, err)
+	require.JSONEq(t, `[]`, string(body))
+}
+
+func TestNodeFromOmitsInternalFields(t *testing.T) {
+	parentID := "parent"
+	checksum := "abc"
+	node := NodeFrom(models.Node{ID: "node", Name: "a.txt", NodeType: "file", OriginalParentID: &parentID, ContentSHA256: &checksum})
+
+	body, err := json.Marshal(node)
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(body, &fields))
+	require.Equal(t, "abc", fields["sha256"])
+	require.NotContains(t, fields, "original_parent_id")
+	require.Nil(t, NodePtr(nil))
+}
+
+func TestFolderHookSecretOnlyWhenSet(t *testing.T) {
+	hook := database.FolderHook{FolderID: "folder", URL: "http://example.com", Secret: "s3cret"}
+
+	body, err := json.Marshal(FolderHookFrom(hook))
+	require.NoError(t, err)
+	require.NotContains(t, string(body), "s3cret")
+
+	body, err = json.Marshal(FolderHookWithSecretFrom(hook))
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(body, &fields))
+	require.Equal(t, "s3cret", fields["secret"])
+	require.Equal(t, "folder", fields["folder_id"])
+}
@@ -0,0 +1,74 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"time"
+)
+
+// FederatedShare is a share of a local node with a user of a peer instance.
+type FederatedShare struct {
+	ID        int64     `json:"id" example:"1"`
+	NodeID    string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	SharerID  int64     `json:"sharer_id" example:"1"`
+	Peer      string    `json:"peer" example:"partner"`
+	Recipient string    `json:"recipient" example:"anna"`
+	SharedAt  time.Time `json:"shared_at"`
+}
+
+func FederatedShareFrom(share database.FederatedShare) FederatedShare {
+	return FederatedShare{
+		ID:        share.ID,
+		NodeID:    share.NodeID,
+		SharerID:  share.SharerID,
+		Peer:      share.Peer,
+		Recipient: share.Recipient,
+		SharedAt:  share.SharedAt,
+	}
+}
+
+func FederatedShares(shares []database.FederatedShare) []FederatedShare {
+	mapped := make([]FederatedShare, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, FederatedShareFrom(share))
+	}
+	return mapped
+}
+
+// RemoteShare is a share offered to the user by a peer instance.
+type RemoteShare struct {
+	ID               int64     `json:"id" example:"1"`
+	Peer             string    `json:"peer" example:"partner"`
+	RecipientID      int64     `json:"recipient_id" example:"2"`
+	Owner            string    `json:"owner" example:"jan"`
+	OwnerDisplayName *string   `json:"owner_display_name,omitempty" example:"Jan Kowalski"`
+	Name             string    `json:"name" example:"Projekty"`
+	NodeType         string    `json:"node_type" example:"folder"`
+	SizeBytes        *int64    `json:"size_bytes,omitempty"`
+	MimeType         *string   `json:"mime_type,omitempty"`
+	Permissions      string    `json:"permissions" example:"read"`
+	SharedAt         time.Time `json:"shared_at"`
+}
+
+func RemoteShareFrom(share database.RemoteShare) RemoteShare {
+	return RemoteShare{
+		ID:               share.ID,
+		Peer:             share.Peer,
+		RecipientID:      share.RecipientID,
+		Owner:            share.Owner,
+		OwnerDisplayName: share.OwnerDisplayName,
+		Name:             share.Name,
+		NodeType:         share.NodeType,
+		SizeBytes:        share.SizeBytes,
+		MimeType:         share.MimeType,
+		Permissions:      share.Permissions,
+		SharedAt:         share.SharedAt,
+	}
+}
+
+func RemoteShares(shares []database.RemoteShare) []RemoteShare {
+	mapped := make([]RemoteShare, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, RemoteShareFrom(share))
+	}
+	return mapped
+}
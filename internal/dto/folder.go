@@ -0,0 +1,105 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"time"
+)
+
+// FolderHook calls URL for every file that arrives directly in a folder. A
+// non-empty MimePrefixes limits it to files whose type starts with one of
+// them.
+type FolderHook struct {
+	FolderID     string    `json:"folder_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	URL          string    `json:"url" example:"http://invoices.internal/ingest"`
+	MimePrefixes []string  `json:"mime_prefixes" example:"application/pdf"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func FolderHookFrom(hook database.FolderHook) FolderHook {
+	return FolderHook{
+		FolderID:     hook.FolderID,
+		URL:          hook.URL,
+		MimePrefixes: hook.MimePrefixes,
+		UpdatedAt:    hook.UpdatedAt,
+	}
+}
+
+// FolderHookWithSecret is a folder hook with the secret its deliveries are
+// signed with, returned only to the owner setting the hook.
+type FolderHookWithSecret struct {
+	FolderHook
+	Secret string `json:"secret" example:"k3J9sZ0qL8xYwV2mN5bR7tU1oP4aE6cD9fG0hI2j"`
+}
+
+func FolderHookWithSecretFrom(hook database.FolderHook) FolderHookWithSecret {
+	return FolderHookWithSecret{FolderHook: FolderHookFrom(hook), Secret: hook.Secret}
+}
+
+// FolderCleanupPolicy trashes files directly in a folder that were last
+// modified more than MaxAgeDays ago or that fall outside the KeepNewest most
+// recently modified ones. A missing limit is not applied.
+type FolderCleanupPolicy struct {
+	FolderID   string    `json:"folder_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	MaxAgeDays *int      `json:"max_age_days,omitempty" example:"90"`
+	KeepNewest *int      `json:"keep_newest,omitempty" example:"50"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func FolderCleanupPolicyFrom(policy database.FolderCleanupPolicy) FolderCleanupPolicy {
+	return FolderCleanupPolicy{
+		FolderID:   policy.FolderID,
+		MaxAgeDays: policy.MaxAgeDays,
+		KeepNewest: policy.KeepNewest,
+		UpdatedAt:  policy.UpdatedAt,
+	}
+}
+
+// OrganizationRule moves and tags files uploaded to SourceFolderID (the
+// owner's root when missing) whose name matches NamePattern.
+type OrganizationRule struct {
+	ID             int64     `json:"id" example:"1"`
+	Name           string    `json:"name" example:"Faktury PDF"`
+	NamePattern    string    `json:"name_pattern" example:"*.pdf"`
+	SourceFolderID *string   `json:"source_folder_id,omitempty" example:"V1StGXR8_Z5jdHi6B-myT"`
+	TargetFolderID *string   `json:"target_folder_id,omitempty" example:"bNowyFolderRodzic123"`
+	Tags           []string  `json:"tags" example:"invoice"`
+	Enabled        bool      `json:"enabled" example:"true"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func OrganizationRuleFrom(rule database.OrganizationRule) OrganizationRule {
+	return OrganizationRule{
+		ID:             rule.ID,
+		Name:           rule.Name,
+		NamePattern:    rule.NamePattern,
+		SourceFolderID: rule.SourceFolderID,
+		TargetFolderID: rule.TargetFolderID,
+		Tags:           rule.Tags,
+		Enabled:        rule.Enabled,
+		CreatedAt:      rule.CreatedAt,
+	}
+}
+
+func OrganizationRules(rules []database.OrganizationRule) []OrganizationRule {
+	mapped := make([]OrganizationRule, 0, len(rules))
+	for _, rule := range rules {
+		mapped = append(mapped, OrganizationRuleFrom(rule))
+	}
+	return mapped
+}
+
+// VersionPolicy limits the archived versions kept of each file. A missing
+// limit is not applied.
+type VersionPolicy struct {
+	MaxVersionsPerFile *int   `json:"max_versions_per_file" example:"10"`
+	MaxBytes           *int64 `json:"max_bytes" example:"1073741824"`
+	MaxAgeDays         *int   `json:"max_age_days" example:"365"`
+}
+
+func VersionPolicyFrom(policy database.VersionPolicy) VersionPolicy {
+	return VersionPolicy{
+		MaxVersionsPerFile: policy.MaxVersionsPerFile,
+		MaxBytes:           policy.MaxBytes,
+		MaxAgeDays:         policy.MaxAgeDays,
+	}
+}
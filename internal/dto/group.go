@@ -0,0 +1,107 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/models"
+	"time"
+)
+
+type Group struct {
+	ID int64 `json:"id" example:"7"`
+	// OwnerID is omitted for groups synchronized from the directory.
+	OwnerID     *int64 `json:"owner_id,omitempty" example:"1"`
+	Name        string `json:"name" example:"Dział marketingu"`
+	MemberCount int    `json:"member_count" example:"5"`
+	// Directory is set for groups synchronized from the directory, whose
+	// name and members cannot be changed through the API.
+	Directory bool      `json:"directory" example:"false"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func GroupFrom(group models.Group) Group {
+	return Group{
+		ID:          group.ID,
+		OwnerID:     group.OwnerID,
+		Name:        group.Name,
+		MemberCount: group.MemberCount,
+		Directory:   group.Directory,
+		CreatedAt:   group.CreatedAt,
+	}
+}
+
+func Groups(groups []models.Group) []Group {
+	mapped := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		mapped = append(mapped, GroupFrom(group))
+	}
+	return mapped
+}
+
+type GroupMember struct {
+	UserID      int64     `json:"user_id" example:"2"`
+	Username    string    `json:"username" example:"user2"`
+	DisplayName *string   `json:"display_name,omitempty" example:"Jan Kowalski"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// GroupDetails is a group with its members.
+type GroupDetails struct {
+	Group
+	Members []GroupMember `json:"members"`
+}
+
+func GroupDetailsFrom(group models.Group, members []models.GroupMember) GroupDetails {
+	details := GroupDetails{Group: GroupFrom(group), Members: make([]GroupMember, 0, len(members))}
+	for _, member := range members {
+		details.Members = append(details.Members, GroupMember{
+			UserID:      member.UserID,
+			Username:    member.Username,
+			DisplayName: member.DisplayName,
+			AddedAt:     member.AddedAt,
+		})
+	}
+	return details
+}
+
+// GroupShare grants every current member of a group access to a node.
+type GroupShare struct {
+	ID          int64     `json:"id" example:"12"`
+	NodeID      string    `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	SharerID    int64     `json:"sharer_id" example:"1"`
+	GroupID     int64     `json:"group_id" example:"7"`
+	Permissions string    `json:"permissions" example:"write" enums:"read,write,manage"`
+	Message     *string   `json:"message,omitempty" example:"Materiały do kampanii"`
+	SharedAt    time.Time `json:"shared_at"`
+}
+
+func GroupShareFrom(share models.GroupShare) GroupShare {
+	return GroupShare{
+		ID:          share.ID,
+		NodeID:      share.NodeID,
+		SharerID:    share.SharerID,
+		GroupID:     share.GroupID,
+		Permissions: share.Permissions,
+		Message:     share.Message,
+		SharedAt:    share.SharedAt,
+	}
+}
+
+// GroupSharedNode is a share of a group with the name and type of the shared
+// node.
+type GroupSharedNode struct {
+	GroupShare
+	NodeName string `json:"node_name" example:"Kampania 2025"`
+	NodeType string `json:"node_type" example:"folder"`
+}
+
+func GroupSharedNodes(shares []database.GroupSharedNode) []GroupSharedNode {
+	mapped := make([]GroupSharedNode, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, GroupSharedNode{
+			GroupShare: GroupShareFrom(share.GroupShare),
+			NodeName:   share.NodeName,
+			NodeType:   share.NodeType,
+		})
+	}
+	return mapped
+}
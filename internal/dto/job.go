@@ -0,0 +1,194 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveImport is a ZIP or tar archive being extracted into a folder.
+type ArchiveImport struct {
+	ID       uuid.UUID `json:"id"`
+	ParentID *string   `json:"parent_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Format   string    `json:"format" example:"zip"`
+	// SourceNodeID is the archive file being extracted, nil for archives
+	// uploaded for import.
+	SourceNodeID *string   `json:"source_node_id,omitempty" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	TotalEntries int       `json:"total_entries" example:"42"`
+	TotalBytes   int64     `json:"total_bytes" example:"10485760"`
+	Status       string    `json:"status" example:"running"`
+	Progress     int       `json:"progress" example:"50"`
+	CreatedNodes int       `json:"created_nodes" example:"21"`
+	Error        *string   `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func ArchiveImportFrom(job database.ArchiveImport) ArchiveImport {
+	return ArchiveImport{
+		ID:           job.ID,
+		ParentID:     job.ParentID,
+		Format:       job.Format,
+		SourceNodeID: job.SourceNodeID,
+		TotalEntries: job.TotalEntries,
+		TotalBytes:   job.TotalBytes,
+		Status:       job.Status,
+		Progress:     job.Progress,
+		CreatedNodes: job.CreatedNodes,
+		Error:        job.Error,
+		CreatedAt:    job.CreatedAt,
+		UpdatedAt:    job.UpdatedAt,
+	}
+}
+
+// URLImport is a file the server fetches from a remote URL into a folder.
+type URLImport struct {
+	ID       uuid.UUID `json:"id"`
+	ParentID *string   `json:"parent_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	URL      string    `json:"url" example:"https://example.com/raport.pdf"`
+	// FileName is the name requested for the file; without one the name is
+	// taken from the response or the URL.
+	FileName  *string   `json:"file_name,omitempty" example:"raport.pdf"`
+	Status    string    `json:"status" example:"completed"`
+	NodeID    *string   `json:"node_id,omitempty" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	SizeBytes *int64    `json:"size_bytes,omitempty" example:"1048576"`
+	Error     *string   `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func URLImportFrom(job database.URLImport) URLImport {
+	return URLImport{
+		ID:        job.ID,
+		ParentID:  job.ParentID,
+		URL:       job.URL,
+		FileName:  job.FileName,
+		Status:    job.Status,
+		NodeID:    job.NodeID,
+		SizeBytes: job.SizeBytes,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+}
+
+// TranscriptionJob transcribes an audio or video file into a subtitle file
+// stored next to it.
+type TranscriptionJob struct {
+	ID           uuid.UUID `json:"id"`
+	NodeID       string    `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	Format       string    `json:"format" example:"vtt"`
+	Status       string    `json:"status" example:"running"`
+	Progress     int       `json:"progress" example:"10"`
+	ResultNodeID *string   `json:"result_node_id,omitempty" example:"bNowyPlikNapisow12345"`
+	Error        *string   `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func TranscriptionJobFrom(job database.TranscriptionJob) TranscriptionJob {
+	return TranscriptionJob{
+		ID:           job.ID,
+		NodeID:       job.NodeID,
+		Format:       job.Format,
+		Status:       job.Status,
+		Progress:     job.Progress,
+		ResultNodeID: job.ResultNodeID,
+		Error:        job.Error,
+		CreatedAt:    job.CreatedAt,
+		UpdatedAt:    job.UpdatedAt,
+	}
+}
+
+// LegalExport is a bundle of the content, version history and audit trail of
+// a subtree, prepared for a litigation hold.
+type LegalExport struct {
+	ID          uuid.UUID  `json:"id"`
+	RequestedBy *int64     `json:"requested_by" example:"1"`
+	NodeID      string     `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OwnerID     *int64     `json:"owner_id" example:"2"`
+	Reason      string     `json:"reason" example:"Sprawa sądowa 123/2025"`
+	Status      string     `json:"status" example:"completed"`
+	SizeBytes   *int64     `json:"size_bytes,omitempty" example:"10485760"`
+	SHA256      *string    `json:"sha256,omitempty"`
+	Entries     int        `json:"entries" example:"42"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func LegalExportFrom(export database.LegalExport) LegalExport {
+	return LegalExport{
+		ID:          export.ID,
+		RequestedBy: export.RequestedBy,
+		NodeID:      export.NodeID,
+		OwnerID:     export.OwnerID,
+		Reason:      export.Reason,
+		Status:      export.Status,
+		SizeBytes:   export.SizeBytes,
+		SHA256:      export.SHA256,
+		Entries:     export.Entries,
+		Error:       export.Error,
+		CreatedAt:   export.CreatedAt,
+		UpdatedAt:   export.UpdatedAt,
+		CompletedAt: export.CompletedAt,
+	}
+}
+
+func LegalExports(exports []database.LegalExport) []LegalExport {
+	mapped := make([]LegalExport, 0, len(exports))
+	for _, export := range exports {
+		mapped = append(mapped, LegalExportFrom(export))
+	}
+	return mapped
+}
+
+// BlobMigration moves the current content of files from one storage backend
+// to another while the server keeps serving them.
+type BlobMigration struct {
+	ID          uuid.UUID `json:"id"`
+	RequestedBy *int64    `json:"requested_by" example:"1"`
+	FromBackend string    `json:"from_backend" example:"local"`
+	ToBackend   string    `json:"to_backend" example:"archive"`
+	// OwnerID limits the migration to one user's files.
+	OwnerID *int64 `json:"owner_id,omitempty" example:"2"`
+	Status  string `json:"status" example:"running" enums:"queued,running,completed,failed"`
+	// A blob shared by several files counts once.
+	TotalBlobs    int        `json:"total_blobs" example:"1200"`
+	MigratedBlobs int        `json:"migrated_blobs" example:"800"`
+	MigratedBytes int64      `json:"migrated_bytes" example:"5368709120"`
+	FailedBlobs   int        `json:"failed_blobs" example:"0"`
+	Error         *string    `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+func BlobMigrationFrom(migration database.BlobMigration) BlobMigration {
+	return BlobMigration{
+		ID:            migration.ID,
+		RequestedBy:   migration.RequestedBy,
+		FromBackend:   migration.FromBackend,
+		ToBackend:     migration.ToBackend,
+		OwnerID:       migration.OwnerID,
+		Status:        migration.Status,
+		TotalBlobs:    migration.TotalBlobs,
+		MigratedBlobs: migration.MigratedBlobs,
+		MigratedBytes: migration.MigratedBytes,
+		FailedBlobs:   migration.FailedBlobs,
+		Error:         migration.Error,
+		CreatedAt:     migration.CreatedAt,
+		UpdatedAt:     migration.UpdatedAt,
+		CompletedAt:   migration.CompletedAt,
+	}
+}
+
+func BlobMigrations(migrations []database.BlobMigration) []BlobMigration {
+	mapped := make([]BlobMigration, 0, len(migrations))
+	for _, migration := range migrations {
+		mapped = append(mapped, BlobMigrationFrom(migration))
+	}
+	return mapped
+}
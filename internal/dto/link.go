@@ -0,0 +1,60 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"time"
+)
+
+// PublicLink is a link opening a node without an account. A link can be
+// protected by a password, expire, allow a limited number of downloads and
+// cap the bytes it serves; on upload links DownloadCount and MaxDownloads
+// count the uploaded files instead.
+type PublicLink struct {
+	ID             int64      `json:"id" example:"1"`
+	Token          string     `json:"token" example:"q8ZJ3s0bVn2mP6xW1yT4cA9dF7gH5kLr"`
+	NodeID         string     `json:"node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	NodeName       string     `json:"node_name" example:"Raport_Q3.pdf"`
+	NodeType       string     `json:"node_type" example:"file"`
+	OwnerID        int64      `json:"owner_id" example:"1"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DownloadCount  int64      `json:"download_count" example:"3"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	HasPassword    bool       `json:"has_password" example:"true"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads   *int64     `json:"max_downloads,omitempty" example:"10"`
+	// BytesServed counts the file content downloaded through the link.
+	BytesServed      int64  `json:"bytes_served" example:"52428800"`
+	MaxTransferBytes *int64 `json:"max_transfer_bytes,omitempty" example:"10737418240"`
+	LinkType         string `json:"link_type" example:"download" enums:"download,upload"`
+	// Slug is a short name that opens the link instead of its token.
+	Slug *string `json:"slug,omitempty" example:"raport-q3"`
+}
+
+func PublicLinkFrom(link database.PublicLink) PublicLink {
+	return PublicLink{
+		ID:               link.ID,
+		Token:            link.Token,
+		NodeID:           link.NodeID,
+		NodeName:         link.NodeName,
+		NodeType:         link.NodeType,
+		OwnerID:          link.OwnerID,
+		CreatedAt:        link.CreatedAt,
+		DownloadCount:    link.DownloadCount,
+		LastAccessedAt:   link.LastAccessedAt,
+		HasPassword:      link.HasPassword,
+		ExpiresAt:        link.ExpiresAt,
+		MaxDownloads:     link.MaxDownloads,
+		BytesServed:      link.BytesServed,
+		MaxTransferBytes: link.MaxTransferBytes,
+		LinkType:         link.LinkType,
+		Slug:             link.Slug,
+	}
+}
+
+func PublicLinks(links []database.PublicLink) []PublicLink {
+	mapped := make([]PublicLink, 0, len(links))
+	for _, link := range links {
+		mapped = append(mapped, PublicLinkFrom(link))
+	}
+	return mapped
+}
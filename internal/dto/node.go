@@ -0,0 +1,112 @@
+// Package dto defines the JSON bodies the API returns. Handlers map models,
+// database rows and token claims to these types with the mappers below
+// instead of encoding them directly, so internal fields stay out of responses
+// and the documented contract is the one the server actually writes. Slice
+// mappers never return nil, so empty listings are encoded as [] rather than
+// null.
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Node struct {
+	ID         string    `json:"id" example:"_vx2a-43VqRT5wz_s9u4"`
+	OwnerID    int64     `json:"owner_id" example:"1"`
+	ParentID   *string   `json:"parent_id" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	Name       string    `json:"name" example:"Raport_Q3.docx"`
+	NodeType   string    `json:"node_type" example:"file" enums:"file,folder"`
+	SizeBytes  *int64    `json:"size_bytes" example:"123456"`
+	MimeType   *string   `json:"mime_type" example:"application/vnd.openxmlformats-officedocument.wordprocessingml.document"`
+	SHA256     *string   `json:"sha256,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	// DeletedAt and DeletionBatchID are set for nodes in the trash.
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	DeletionBatchID *uuid.UUID `json:"deletion_batch_id,omitempty"`
+	// ChildCount is the number of direct children of a folder, present only
+	// when a listing asks for it.
+	ChildCount *int64 `json:"child_count,omitempty" example:"12"`
+}
+
+func NodeFrom(node models.Node) Node {
+	return Node{
+		ID:              node.ID,
+		OwnerID:         node.OwnerID,
+		ParentID:        node.ParentID,
+		Name:            node.Name,
+		NodeType:        node.NodeType,
+		SizeBytes:       node.SizeBytes,
+		MimeType:        node.MimeType,
+		SHA256:          node.ContentSHA256,
+		CreatedAt:       node.CreatedAt,
+		ModifiedAt:      node.ModifiedAt,
+		DeletedAt:       node.DeletedAt,
+		DeletionBatchID: node.DeletionBatchID,
+		ChildCount:      node.ChildCount,
+	}
+}
+
+// NodePtr maps a node that may be missing.
+func NodePtr(node *models.Node) *Node {
+	if node == nil {
+		return nil
+	}
+	mapped := NodeFrom(*node)
+	return &mapped
+}
+
+func Nodes(nodes []models.Node) []Node {
+	mapped := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		mapped = append(mapped, NodeFrom(node))
+	}
+	return mapped
+}
+
+// TrashBatch is a group of nodes deleted together, described by the node the
+// user deleted.
+type TrashBatch struct {
+	BatchID    uuid.UUID `json:"batch_id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	ItemCount  int64     `json:"item_count" example:"12"`
+	TotalBytes int64     `json:"total_bytes" example:"1048576"`
+	RootID     string    `json:"root_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	RootName   string    `json:"root_name" example:"Projekty"`
+	RootType   string    `json:"root_type" example:"folder"`
+}
+
+func TrashBatches(batches []database.TrashBatch) []TrashBatch {
+	mapped := make([]TrashBatch, 0, len(batches))
+	for _, batch := range batches {
+		mapped = append(mapped, TrashBatch{
+			BatchID:    batch.BatchID,
+			DeletedAt:  batch.DeletedAt,
+			ItemCount:  batch.ItemCount,
+			TotalBytes: batch.TotalBytes,
+			RootID:     batch.RootID,
+			RootName:   batch.RootName,
+			RootType:   batch.RootType,
+		})
+	}
+	return mapped
+}
+
+// OfflinePin is a node a device keeps available offline.
+type OfflinePin struct {
+	Node
+	DeviceID string    `json:"device_id" example:"laptop-anna"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+func OfflinePins(pins []database.OfflinePin) []OfflinePin {
+	mapped := make([]OfflinePin, 0, len(pins))
+	for _, pin := range pins {
+		mapped = append(mapped, OfflinePin{Node: NodeFrom(pin.Node), DeviceID: pin.DeviceID, PinnedAt: pin.PinnedAt})
+	}
+	return mapped
+}
@@ -0,0 +1,207 @@
+package dto
+
+import (
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/models"
+	"time"
+)
+
+type Share struct {
+	ID          int64   `json:"id" example:"42"`
+	NodeID      string  `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	SharerID    int64   `json:"sharer_id" example:"1"`
+	RecipientID int64   `json:"recipient_id" example:"2"`
+	Permissions string  `json:"permissions" example:"read" enums:"read,write,manage"`
+	Message     *string `json:"message,omitempty" example:"Do audytu, tylko do odczytu do piątku"`
+	// PinnedVersion is set for shares of a fixed file version.
+	PinnedVersion *int      `json:"pinned_version,omitempty" example:"3"`
+	SharedAt      time.Time `json:"shared_at"`
+}
+
+func ShareFrom(share models.Share) Share {
+	return Share{
+		ID:            share.ID,
+		NodeID:        share.NodeID,
+		SharerID:      share.SharerID,
+		RecipientID:   share.RecipientID,
+		Permissions:   share.Permissions,
+		Message:       share.Message,
+		PinnedVersion: share.PinnedVersion,
+		SharedAt:      share.SharedAt,
+	}
+}
+
+// OutgoingShare is a share made by the user, with what it shares and whom
+// with.
+type OutgoingShare struct {
+	ID                int64     `json:"id" example:"42"`
+	NodeID            string    `json:"node_id" example:"_vx2a-43VqRT5wz_s9u4"`
+	NodeName          string    `json:"node_name" example:"Wspólny Projekt"`
+	NodeType          string    `json:"node_type" example:"folder"`
+	RecipientID       int64     `json:"recipient_id" example:"2"`
+	RecipientUsername string    `json:"recipient_username" example:"user2"`
+	Permissions       string    `json:"permissions" example:"write" enums:"read,write,manage"`
+	Message           *string   `json:"message,omitempty" example:"Do audytu, tylko do odczytu do piątku"`
+	PinnedVersion     *int      `json:"pinned_version,omitempty" example:"3"`
+	SharedAt          time.Time `json:"shared_at"`
+}
+
+func OutgoingShares(shares []database.OutgoingShare) []OutgoingShare {
+	mapped := make([]OutgoingShare, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, OutgoingShare{
+			ID:                share.ID,
+			NodeID:            share.NodeID,
+			NodeName:          share.NodeName,
+			NodeType:          share.NodeType,
+			RecipientID:       share.RecipientID,
+			RecipientUsername: share.RecipientUsername,
+			Permissions:       share.Permissions,
+			Message:           share.Message,
+			PinnedVersion:     share.PinnedVersion,
+			SharedAt:          share.SharedAt,
+		})
+	}
+	return mapped
+}
+
+// SharedNode is a node shared with the user, with the permissions and
+// message of the share. For shares pinned to a version the size, MIME type
+// and modification time describe that version.
+type SharedNode struct {
+	Node
+	SharePermissions string  `json:"share_permissions" example:"read" enums:"read,write,manage"`
+	ShareMessage     *string `json:"share_message,omitempty" example:"Do audytu, tylko do odczytu do piątku"`
+	PinnedVersion    *int    `json:"pinned_version,omitempty" example:"3"`
+}
+
+func SharedNodes(nodes []database.SharedNode) []SharedNode {
+	mapped := make([]SharedNode, 0, len(nodes))
+	for _, node := range nodes {
+		mapped = append(mapped, SharedNode{
+			Node:             NodeFrom(node.Node),
+			SharePermissions: node.SharePermissions,
+			ShareMessage:     node.ShareMessage,
+			PinnedVersion:    node.PinnedVersion,
+		})
+	}
+	return mapped
+}
+
+// IncomingShare is a share made to the user, with who shared what. GroupID is
+// set for shares reaching the user through a group.
+type IncomingShare struct {
+	ID                int64     `json:"id" example:"42"`
+	GroupID           *int64    `json:"group_id,omitempty" example:"7"`
+	SharerID          int64     `json:"sharer_id" example:"1"`
+	SharerUsername    string    `json:"sharer_username" example:"admin"`
+	SharerDisplayName *string   `json:"sharer_display_name,omitempty" example:"Jan Kowalski"`
+	Permissions       string    `json:"permissions" example:"read" enums:"read,write,manage"`
+	Message           *string   `json:"message,omitempty" example:"Do audytu, tylko do odczytu do piątku"`
+	PinnedVersion     *int      `json:"pinned_version,omitempty" example:"3"`
+	SharedAt          time.Time `json:"shared_at"`
+	Node              Node      `json:"node"`
+}
+
+func IncomingShares(shares []database.IncomingShare) []IncomingShare {
+	mapped := make([]IncomingShare, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, IncomingShare{
+			ID:                share.ID,
+			GroupID:           share.GroupID,
+			SharerID:          share.SharerID,
+			SharerUsername:    share.SharerUsername,
+			SharerDisplayName: share.SharerDisplayName,
+			Permissions:       share.Permissions,
+			Message:           share.Message,
+			PinnedVersion:     share.PinnedVersion,
+			SharedAt:          share.SharedAt,
+			Node:              NodeFrom(share.Node),
+		})
+	}
+	return mapped
+}
+
+// NodeShare is a share giving access to a node, made on the node itself or
+// inherited from one of its folders.
+type NodeShare struct {
+	ShareID int64 `json:"share_id" example:"42"`
+	// RecipientType is "user" or "group"; RecipientID and RecipientName
+	// identify the user or the group accordingly.
+	RecipientType string    `json:"recipient_type" example:"user" enums:"user,group"`
+	RecipientID   int64     `json:"recipient_id" example:"2"`
+	RecipientName string    `json:"recipient_name" example:"user2"`
+	Permissions   string    `json:"permissions" example:"read" enums:"read,write,manage"`
+	SharerID      int64     `json:"sharer_id" example:"1"`
+	SharedAt      time.Time `json:"shared_at"`
+	PinnedVersion *int      `json:"pinned_version,omitempty" example:"3"`
+	// OriginNodeID is the shared node: the node itself for a direct share,
+	// otherwise the ancestor folder the share is inherited from.
+	OriginNodeID   string `json:"origin_node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	OriginNodeName string `json:"origin_node_name" example:"Projekty"`
+	Inherited      bool   `json:"inherited" example:"true"`
+}
+
+func NodeShares(shares []database.NodeShare) []NodeShare {
+	mapped := make([]NodeShare, 0, len(shares))
+	for _, share := range shares {
+		mapped = append(mapped, NodeShare{
+			ShareID:        share.ShareID,
+			RecipientType:  share.RecipientType,
+			RecipientID:    share.RecipientID,
+			RecipientName:  share.RecipientName,
+			Permissions:    share.Permissions,
+			SharerID:       share.SharerID,
+			SharedAt:       share.SharedAt,
+			PinnedVersion:  share.PinnedVersion,
+			OriginNodeID:   share.OriginNodeID,
+			OriginNodeName: share.OriginNodeName,
+			Inherited:      share.Inherited,
+		})
+	}
+	return mapped
+}
+
+// EffectiveAccess is the strongest access a user has to a node and the share
+// granting it.
+type EffectiveAccess struct {
+	UserID      int64   `json:"user_id" example:"2"`
+	Username    string  `json:"username" example:"user2"`
+	DisplayName *string `json:"display_name,omitempty" example:"Jan Kowalski"`
+	Permissions string  `json:"permissions" example:"write" enums:"read,write,manage"`
+	ShareID     int64   `json:"share_id" example:"42"`
+	// GroupID is set when the winning share was made to a group; ShareID
+	// is then the group share's ID.
+	GroupID       *int64 `json:"group_id,omitempty" example:"7"`
+	SharerID      int64  `json:"sharer_id" example:"1"`
+	PinnedVersion *int   `json:"pinned_version,omitempty" example:"3"`
+	// ViaNodeID is the shared node, the node itself at depth 0 or an
+	// ancestor.
+	ViaNodeID   string `json:"via_node_id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	ViaNodeName string `json:"via_node_name" example:"Projekty"`
+	Depth       int    `json:"depth" example:"1"`
+	// Grants counts every share giving the user access; more than one means
+	// overlapping shares, where revoking one may not remove access.
+	Grants int `json:"grants" example:"2"`
+}
+
+func EffectiveAccesses(access []database.EffectiveAccess) []EffectiveAccess {
+	mapped := make([]EffectiveAccess, 0, len(access))
+	for _, entry := range access {
+		mapped = append(mapped, EffectiveAccess{
+			UserID:        entry.UserID,
+			Username:      entry.Username,
+			DisplayName:   entry.DisplayName,
+			Permissions:   entry.Permissions,
+			ShareID:       entry.ShareID,
+			GroupID:       entry.GroupID,
+			SharerID:      entry.SharerID,
+			PinnedVersion: entry.PinnedVersion,
+			ViaNodeID:     entry.ViaNodeID,
+			ViaNodeName:   entry.ViaNodeName,
+			Depth:         entry.Depth,
+			Grants:        entry.Grants,
+		})
+	}
+	return mapped
+}
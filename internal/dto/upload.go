@@ -0,0 +1,53 @@
+package dto
+
+import (
+	"serwer-plikow/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadSession is a resumable upload and the chunks received so far.
+type UploadSession struct {
+	ID             uuid.UUID     `json:"id" example:"a1b2c3d4-e5f6-7890-1234-567890abcdef"`
+	UserID         int64         `json:"user_id" example:"1"`
+	OwnerID        int64         `json:"owner_id" example:"1"`
+	ParentID       *string       `json:"parent_id" example:"fLW5kAh2ia9vYmjMnU4nZ"`
+	FileName       string        `json:"file_name" example:"film.mp4"`
+	MimeType       *string       `json:"mime_type" example:"video/mp4"`
+	TotalSize      int64         `json:"total_size" example:"104857600"`
+	ExpectedSHA256 *string       `json:"expected_sha256,omitempty"`
+	ReceivedBytes  int64         `json:"received_bytes" example:"52428800"`
+	Chunks         []UploadChunk `json:"chunks"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+}
+
+type UploadChunk struct {
+	Offset    int64   `json:"offset" example:"0"`
+	SizeBytes int64   `json:"size_bytes" example:"5242880"`
+	Checksum  *string `json:"checksum,omitempty"`
+}
+
+func UploadSessionFrom(session models.UploadSession) UploadSession {
+	chunks := make([]UploadChunk, 0, len(session.Chunks))
+	for _, chunk := range session.Chunks {
+		chunks = append(chunks, UploadChunk{Offset: chunk.Offset, SizeBytes: chunk.SizeBytes, Checksum: chunk.Checksum})
+	}
+	return UploadSession{
+		ID:             session.ID,
+		UserID:         session.UserID,
+		OwnerID:        session.OwnerID,
+		ParentID:       session.ParentID,
+		FileName:       session.FileName,
+		MimeType:       session.MimeType,
+		TotalSize:      session.TotalSize,
+		ExpectedSHA256: session.ExpectedSHA256,
+		ReceivedBytes:  session.ReceivedBytes,
+		Chunks:         chunks,
+		CreatedAt:      session.CreatedAt,
+		UpdatedAt:      session.UpdatedAt,
+		ExpiresAt:      session.ExpiresAt,
+	}
+}
@@ -0,0 +1,70 @@
+package dto
+
+import (
+	"serwer-plikow/internal/auth"
+	"serwer-plikow/internal/database"
+	"serwer-plikow/internal/geoip"
+	"serwer-plikow/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CurrentUser describes the authenticated user from their access token.
+type CurrentUser struct {
+	UserID   int64  `json:"user_id" example:"1"`
+	Username string `json:"username" example:"admin"`
+	// SessionID is the session the token was issued for, empty for tokens
+	// not bound to a session.
+	SessionID string `json:"session_id,omitempty" example:"a1b2c3d4-e5f6-7890-1234-567890abcdef"`
+	// ExpiresAt is when the access token expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func CurrentUserFrom(claims *auth.AppClaims) CurrentUser {
+	user := CurrentUser{UserID: claims.UserID, Username: claims.Username, SessionID: claims.SessionID}
+	if claims.ExpiresAt != nil {
+		expiresAt := claims.ExpiresAt.Time
+		user.ExpiresAt = &expiresAt
+	}
+	return user
+}
+
+type Session struct {
+	ID        uuid.UUID `json:"id" example:"a1b2c3d4-e5f6-7890-1234-567890abcdef"`
+	UserAgent string    `json:"user_agent" example:"Mozilla/5.0 (Windows NT 10.0; Win64; x64) ..."`
+	ClientIP  string    `json:"client_ip" example:"198.51.100.10"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt is the last token refresh or, updated every few minutes,
+	// the last API request, so dormant sessions stand out.
+	LastUsedAt time.Time `json:"last_used_at"`
+	// Location is where ClientIP is, present when the server has a GeoIP
+	// database that knows the address.
+	Location *geoip.Location `json:"location,omitempty"`
+}
+
+func Sessions(sessions []models.Session) []Session {
+	mapped := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		mapped = append(mapped, Session{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			ClientIP:   session.ClientIP,
+			ExpiresAt:  session.ExpiresAt,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+		})
+	}
+	return mapped
+}
+
+// UserEmail is the user's email address and when it was verified.
+type UserEmail struct {
+	Email      *string    `json:"email" example:"jan.kowalski@example.com"`
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+func UserEmailFrom(email database.UserEmail) UserEmail {
+	return UserEmail{Email: email.Email, VerifiedAt: email.VerifiedAt}
+}
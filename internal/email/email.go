@@ -0,0 +1,103 @@
+// Package email sends the server's emails, such as address verification and
+// password reset messages, through an SMTP server.
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer delivers messages through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it. Credentials are only
+// sent over TLS or to localhost.
+type SMTPMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		addr:     net.JoinHostPort(host, fmt.Sprint(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	data, err := buildMessage(from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	// net/smtp takes no context, so a cancelled send is only abandoned.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(m.addr, auth, from.Address, []string{msg.To}, data) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// buildMessage formats a message with the headers mail clients expect.
+// Header values with line breaks are rejected, so they cannot inject headers.
+func buildMessage(from *mail.Address, msg Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("email header contains a line break")
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// NormalizeAddress validates an email address and returns it in lower case,
+// without a display name.
+func NormalizeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil || parsed.Name != "" || len(parsed.Address) > 254 {
+		return "", errors.New("invalid email address")
+	}
+	return strings.ToLower(parsed.Address), nil
+}
@@ -0,0 +1,90 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveSMTP accepts one SMTP session without authentication or TLS and
+// returns the recipient and data of the message sent.
+func serveSMTP(listener net.Listener) <-chan [2]string {
+	received := make(chan [2]string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 localhost ESMTP")
+		var recipient string
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "RCPT":
+				recipient = strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">")
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, _ := text.ReadDotBytes()
+				text.PrintfLine("250 OK")
+				received <- [2]string{recipient, string(data)}
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return received
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := serveSMTP(listener)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	mailer := NewSMTPMailer("127.0.0.1", addr.Port, "", "", "Serwer Plików <noreply@example.com>")
+	err = mailer.Send(context.Background(), Message{To: "jan@example.com", Subject: "Reset hasła", Body: "Kod: 123\nDo zobaczenia"})
+	require.NoError(t, err)
+
+	message := <-received
+	require.Equal(t, "jan@example.com", message[0])
+	headers, err := textproto.NewReader(bufio.NewReader(strings.NewReader(message[1]))).ReadMIMEHeader()
+	require.NoError(t, err)
+	require.Equal(t, "=?utf-8?q?Reset_has=C5=82a?=", headers.Get("Subject"))
+	require.Equal(t, "=?utf-8?q?Serwer_Plik=C3=B3w?= <noreply@example.com>", headers.Get("From"))
+	require.Contains(t, message[1], "Kod: 123\nDo zobaczenia")
+}
+
+func TestBuildMessageRejectsHeaderInjection(t *testing.T) {
+	_, err := buildMessage(&mail.Address{Address: "noreply@example.com"}, Message{To: "jan@example.com\r\nBcc: eve@example.com", Subject: "x"}, time.Now())
+	require.Error(t, err)
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	address, err := NormalizeAddress("  Jan.Kowalski@Example.COM ")
+	require.NoError(t, err)
+	require.Equal(t, "jan.kowalski@example.com", address)
+
+	for _, invalid := range []string{"", "jan", "Jan <jan@example.com>", "a@b@c"} {
+		_, err := NormalizeAddress(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,41 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnabledFor(t *testing.T) {
+	require.False(t, Flag{}.EnabledFor(ResumableUploads, 1))
+	require.False(t, Flag{RolloutPercent: 100}.EnabledFor(ResumableUploads, 1), "A disabled flag ignores its rollout")
+	require.True(t, Flag{Enabled: true}.EnabledFor(ResumableUploads, 1))
+	require.True(t, Flag{Enabled: true, RolloutPercent: 100}.EnabledFor(ResumableUploads, 1))
+
+	included := 0
+	for userID := int64(1); userID <= 1000; userID++ {
+		quarter := Flag{Enabled: true, RolloutPercent: 25}.EnabledFor(DeltaUploads, userID)
+		half := Flag{Enabled: true, RolloutPercent: 50}.EnabledFor(DeltaUploads, userID)
+		if quarter {
+			included++
+			require.True(t, half, "Raising the rollout keeps the users already included")
+		}
+	}
+	require.InDelta(t, 250, included, 60)
+}
+
+func TestEvaluate(t *testing.T) {
+	on, off := true, false
+	require.True(t, Evaluate(InstantUploads, Flag{}, &on, 7))
+	require.False(t, Evaluate(InstantUploads, Flag{Enabled: true}, &off, 7))
+	require.True(t, Evaluate(InstantUploads, Flag{Enabled: true}, nil, 7))
+	require.Equal(t, Bucket(InstantUploads, 7), Bucket(InstantUploads, 7))
+}
+
+func TestLookup(t *testing.T) {
+	def, ok := Lookup(ResumableUploads)
+	require.True(t, ok)
+	require.True(t, def.Default.Enabled)
+	_, ok = Lookup("no_such_flag")
+	require.False(t, ok)
+}
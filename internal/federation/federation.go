@@ -0,0 +1,227 @@
+// Package federation implements an experimental server-to-server protocol,
+// loosely modelled on the Open Cloud Mesh, that lets users of one deployment
+// share files and folders with users of a trusted peer. Peers authenticate
+// every request with an HMAC over the request and a shared secret.
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderInstance  = "X-Federation-Instance"
+	HeaderTimestamp = "X-Federation-Timestamp"
+	HeaderSignature = "X-Federation-Signature"
+
+	// MaxClockSkew is how far a request's timestamp may be from the
+	// receiver's clock.
+	MaxClockSkew = 5 * time.Minute
+)
+
+// maxErrorBody bounds how much of an error response is quoted in errors.
+const maxErrorBody = 512
+
+var (
+	ErrInvalidSignature = errors.New("invalid federation signature")
+	ErrStaleRequest     = errors.New("federation request timestamp is outside the allowed clock skew")
+)
+
+// Peer is a trusted instance. URL is the base of its API, e.g.
+// "https://files.example.com/api/v1".
+type Peer struct {
+	Name   string
+	URL    string
+	Secret string
+}
+
+// ShareOffer announces a share to the recipient's instance.
+type ShareOffer struct {
+	// Token identifies the share in all later requests to the owner's
+	// instance.
+	Token            string  `json:"token"`
+	Owner            string  `json:"owner" example:"jan"`
+	OwnerDisplayName *string `json:"owner_display_name,omitempty" example:"Jan Kowalski"`
+	// Recipient is the username on the receiving instance.
+	Recipient   string  `json:"recipient" example:"anna"`
+	Name        string  `json:"name" example:"Projekty"`
+	NodeType    string  `json:"node_type" example:"folder"`
+	SizeBytes   *int64  `json:"size_bytes,omitempty"`
+	MimeType    *string `json:"mime_type,omitempty"`
+	Permissions string  `json:"permissions" example:"read"`
+}
+
+// RemoteNode is a node inside a federated share as listed by the owner's
+// instance.
+type RemoteNode struct {
+	ID         string    `json:"id" example:"V1StGXR8_Z5jdHi6B-myT"`
+	ParentID   *string   `json:"parent_id"`
+	Name       string    `json:"name" example:"plan.pdf"`
+	NodeType   string    `json:"node_type" example:"file"`
+	SizeBytes  *int64    `json:"size_bytes,omitempty"`
+	MimeType   *string   `json:"mime_type,omitempty"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// ParseAddress splits a federated address "user@instance".
+func ParseAddress(address string) (user, instance string, err error) {
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		return "", "", fmt.Errorf("%q is not a federated address of the form user@instance", address)
+	}
+	return address[:at], address[at+1:], nil
+}
+
+// Sign returns the signature of a request: the hex HMAC-SHA256, prefixed with
+// "sha256=", of the method, the request URI, the Unix timestamp and the body,
+// separated by newlines.
+func Sign(secret, method, requestURI string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n", method, requestURI, timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a received request
+// whose body has already been read.
+func Verify(secret string, r *http.Request, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return ErrStaleRequest
+	}
+	expected := Sign(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Client makes signed requests to peers on behalf of this instance.
+type Client struct {
+	instance   string
+	httpClient *http.Client
+}
+
+// NewClient returns a client that identifies itself to peers as instance.
+// The timeout does not apply to downloads, which are bounded by their
+// context instead.
+func NewClient(instance string, timeout time.Duration) *Client {
+	return &Client{
+		instance:   instance,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) do(ctx context.Context, client *http.Client, peer Peer, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	target, err := url.Parse(strings.TrimRight(peer.URL, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	if len(query) > 0 {
+		target.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderInstance, c.instance)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(peer.Secret, method, req.URL.RequestURI(), timestamp, body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to peer %s failed: %w", peer.Name, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, &PeerError{Peer: peer.Name, StatusCode: resp.StatusCode, Detail: strings.TrimSpace(string(detail))}
+	}
+	return resp, nil
+}
+
+// PeerError is a non-2xx answer of a peer.
+type PeerError struct {
+	Peer       string
+	StatusCode int
+	Detail     string
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer %s returned %d: %s", e.Peer, e.StatusCode, e.Detail)
+}
+
+// OfferShare tells the recipient's instance about a new share.
+func (c *Client) OfferShare(ctx context.Context, peer Peer, offer ShareOffer) error {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, c.httpClient, peer, http.MethodPost, "/federation/shares", nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RevokeShare tells the recipient's instance that a share was removed.
+func (c *Client) RevokeShare(ctx context.Context, peer Peer, token string) error {
+	resp, err := c.do(ctx, c.httpClient, peer, http.MethodDelete, "/federation/shares/"+url.PathEscape(token), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListNodes lists a folder inside a share on the owner's instance: the shared
+// folder itself when parentID is empty.
+func (c *Client) ListNodes(ctx context.Context, peer Peer, token, parentID string) ([]RemoteNode, error) {
+	query := url.Values{}
+	if parentID != "" {
+		query.Set("parent_id", parentID)
+	}
+	resp, err := c.do(ctx, c.httpClient, peer, http.MethodGet, "/federation/shares/"+url.PathEscape(token)+"/nodes", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var nodes []RemoteNode
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+		return nil, fmt.Errorf("invalid node list from peer %s: %w", peer.Name, err)
+	}
+	return nodes, nil
+}
+
+// Download opens a file inside a share on the owner's instance: the shared
+// file itself when nodeID is empty. The caller closes the response body.
+func (c *Client) Download(ctx context.Context, peer Peer, token, nodeID string) (*http.Response, error) {
+	query := url.Values{}
+	if nodeID != "" {
+		query.Set("node_id", nodeID)
+	}
+	streaming := &http.Client{Transport: c.httpClient.Transport}
+	return c.do(ctx, streaming, peer, http.MethodGet, "/federation/shares/"+url.PathEscape(token)+"/download", query, nil)
+}
@@ -0,0 +1,90 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	user, instance, err := ParseAddress("anna.nowak@partner")
+	require.NoError(t, err)
+	require.Equal(t, "anna.nowak", user)
+	require.Equal(t, "partner", instance)
+
+	for _, invalid := range []string{"anna", "@partner", "anna@"} {
+		_, _, err := ParseAddress(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"token":"abc"}`)
+	req := httptest.NewRequest("POST", "/api/v1/federation/shares?x=1", nil)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign("secret", "POST", "/api/v1/federation/shares?x=1", now.Unix(), body))
+
+	require.NoError(t, Verify("secret", req, body, now))
+	require.ErrorIs(t, Verify("other", req, body, now), ErrInvalidSignature)
+	require.ErrorIs(t, Verify("secret", req, []byte(`{"token":"xyz"}`), now), ErrInvalidSignature)
+	require.ErrorIs(t, Verify("secret", req, body, now.Add(MaxClockSkew+time.Second)), ErrStaleRequest)
+
+	req.Header.Del(HeaderTimestamp)
+	require.ErrorIs(t, Verify("secret", req, body, now), ErrInvalidSignature)
+}
+
+func TestClientSignsRequests(t *testing.T) {
+	var received ShareOffer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.Equal(t, "home", r.Header.Get(HeaderInstance))
+		require.NoError(t, Verify("secret", r, body, time.Now()))
+
+		switch r.URL.Path {
+		case "/api/v1/federation/shares":
+			require.NoError(t, json.Unmarshal(body, &received))
+			w.WriteHeader(http.StatusCreated)
+		case "/api/v1/federation/shares/tok/nodes":
+			require.Equal(t, "folder-1", r.URL.Query().Get("parent_id"))
+			json.NewEncoder(w).Encode([]RemoteNode{{ID: "n1", Name: "plan.pdf", NodeType: "file"}})
+		case "/api/v1/federation/shares/tok/download":
+			io.WriteString(w, "content")
+		default:
+			http.Error(w, "no such share", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	peer := Peer{Name: "partner", URL: server.URL + "/api/v1/", Secret: "secret"}
+	client := NewClient("home", time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, client.OfferShare(ctx, peer, ShareOffer{Token: "tok", Owner: "jan", Recipient: "anna", Name: "Projekty", NodeType: "folder", Permissions: "read"}))
+	require.Equal(t, "anna", received.Recipient)
+
+	nodes, err := client.ListNodes(ctx, peer, "tok", "folder-1")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	require.Equal(t, "plan.pdf", nodes[0].Name)
+
+	resp, err := client.Download(ctx, peer, "tok", "")
+	require.NoError(t, err)
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, "content", string(content))
+
+	err = client.RevokeShare(ctx, peer, "missing")
+	var peerErr *PeerError
+	require.True(t, errors.As(err, &peerErr))
+	require.Equal(t, http.StatusNotFound, peerErr.StatusCode)
+	require.Contains(t, peerErr.Detail, "no such share")
+}
@@ -0,0 +1,136 @@
+// Package geoip resolves IP addresses to a country and city using a local
+// MaxMind DB file, such as GeoLite2-City.mmdb, so no address ever leaves the
+// server. It reads the file format directly and keeps the file in memory.
+package geoip
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// Location is where the database places an IP address. Fields the database
+// does not know are empty.
+type Location struct {
+	CountryCode string `json:"country_code,omitempty" example:"PL"`
+	Country     string `json:"country,omitempty" example:"Poland"`
+	City        string `json:"city,omitempty" example:"Kraków"`
+}
+
+// Locator resolves IP addresses. Locate returns nil for addresses the
+// database has no location for, such as private networks.
+type Locator interface {
+	Locate(ip string) (*Location, error)
+}
+
+// Database is a Locator reading a MaxMind DB file with the GeoIP2 or
+// GeoLite2 country or city layout.
+type Database struct {
+	db *mmdb
+	// language picks the place names, falling back to English.
+	language string
+}
+
+// Open loads a MaxMind DB file. An empty language means English.
+func Open(path, language string) (*Database, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if language == "" {
+		language = "en"
+	}
+	return &Database{db: db, language: language}, nil
+}
+
+func (d *Database) Locate(ip string) (*Location, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, nil
+	}
+	value, err := d.db.lookup(parsed)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	if record == nil {
+		return nil, nil
+	}
+
+	var location Location
+	if country, ok := record["country"].(map[string]any); ok {
+		location.CountryCode, _ = country["iso_code"].(string)
+		location.Country = d.name(country)
+	}
+	if city, ok := record["city"].(map[string]any); ok {
+		location.City = d.name(city)
+	}
+	if location == (Location{}) {
+		return nil, nil
+	}
+	return &location, nil
+}
+
+func (d *Database) name(place map[string]any) string {
+	names, _ := place["names"].(map[string]any)
+	if name, ok := names[d.language].(string); ok {
+		return name
+	}
+	name, _ := names["en"].(string)
+	return name
+}
+
+// Cache remembers the results of a Locator for the most recently looked up
+// addresses, including addresses without a location.
+type Cache struct {
+	locator Locator
+	size    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cacheEntry struct {
+	ip       string
+	location *Location
+}
+
+// NewCache wraps a Locator with a cache of up to size addresses.
+func NewCache(locator Locator, size int) *Cache {
+	return &Cache{locator: locator, size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *Cache) Locate(ip string) (*Location, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[ip]; ok {
+		c.order.MoveToFront(element)
+		location := element.Value.(*cacheEntry).location
+		c.mu.Unlock()
+		return location, nil
+	}
+	c.mu.Unlock()
+
+	location, err := c.locator.Locate(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[ip]; !ok {
+		c.entries[ip] = c.order.PushFront(&cacheEntry{ip: ip, location: location})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).ip)
+		}
+	}
+	return location, nil
+}
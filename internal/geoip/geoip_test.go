@@ -0,0 +1,150 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func encodeControl(typ byte, size int) []byte {
+	switch {
+	case size < 29:
+		return []byte{typ<<5 | byte(size)}
+	case size < 285:
+		return []byte{typ<<5 | 29, byte(size - 29)}
+	default:
+		size -= 285
+		return []byte{typ<<5 | 30, byte(size >> 8), byte(size)}
+	}
+}
+
+func encodeString(s string) []byte {
+	return append(encodeControl(typeString, len(s)), s...)
+}
+
+func encodeUint(typ byte, v uint64) []byte {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append(encodeControl(typ, len(b)), b...)
+}
+
+func encodeMap(pairs ...[]byte) []byte {
+	out := encodeControl(typeMap, len(pairs)/2)
+	for _, pair := range pairs {
+		out = append(out, pair...)
+	}
+	return out
+}
+
+// buildDatabase writes an IPv4 database with 24-bit records holding one
+// record for the given /24 network.
+func buildDatabase(t *testing.T, network [3]byte, record []byte) string {
+	const nodeCount = 24
+	dataPointer := nodeCount + dataSectionSeparator
+
+	var tree []byte
+	for depth := 0; depth < 24; depth++ {
+		bit := (network[depth/8] >> (7 - depth%8)) & 1
+		next := depth + 1
+		if depth == 23 {
+			next = dataPointer
+		}
+		records := [2]int{nodeCount, nodeCount}
+		records[bit] = next
+		for _, r := range records {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	buf := append(tree, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, record...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encodeMap(
+		encodeString("node_count"), encodeUint(typeUint32, nodeCount),
+		encodeString("record_size"), encodeUint(typeUint16, 24),
+		encodeString("ip_version"), encodeUint(typeUint16, 4),
+	)...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	require.NoError(t, os.WriteFile(path, buf, 0o600))
+	return path
+}
+
+func TestDatabaseLocate(t *testing.T) {
+	city := strings.Repeat("Kraków ", 6)
+	record := encodeMap(
+		encodeString("country"), encodeMap(
+			encodeString("iso_code"), encodeString("PL"),
+			encodeString("names"), encodeMap(encodeString("en"), encodeString("Poland"), encodeString("de"), encodeString("Polen")),
+		),
+		encodeString("city"), encodeMap(
+			encodeString("names"), encodeMap(encodeString("en"), encodeString(city)),
+		),
+	)
+	path := buildDatabase(t, [3]byte{81, 2, 69}, record)
+
+	db, err := Open(path, "de")
+	require.NoError(t, err)
+
+	location, err := db.Locate("81.2.69.160")
+	require.NoError(t, err)
+	require.Equal(t, &Location{CountryCode: "PL", Country: "Polen", City: city}, location, "Names fall back to English")
+
+	for _, ip := range []string{"81.2.70.1", "10.0.0.1", "2001:db8::1", "not-an-ip"} {
+		location, err = db.Locate(ip)
+		require.NoError(t, err)
+		require.Nil(t, location, ip)
+	}
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.mmdb"), "")
+	require.Error(t, err)
+}
+
+func TestDecoderPointers(t *testing.T) {
+	data := append(encodeString("Warszawa"), encodeMap(encodeString("city"), []byte{typePointer << 5, 0})...)
+	value, next, err := decoder{data: data}.decode(9, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"city": "Warszawa"}, value)
+	require.Equal(t, uint(len(data)), next)
+
+	_, _, err = decoder{data: []byte{typePointer << 5, 0}}.decode(0, 0)
+	require.ErrorIs(t, err, errCorruptDatabase, "A pointer to itself is rejected")
+}
+
+func TestMMDBRecord28(t *testing.T) {
+	db := &mmdb{recordSize: 28, tree: []byte{0x12, 0x34, 0x56, 0xab, 0x78, 0x9a, 0xbc}}
+	require.Equal(t, uint(0xa123456), db.record(0, 0))
+	require.Equal(t, uint(0xb789abc), db.record(0, 1))
+}
+
+type countingLocator struct {
+	calls int
+}
+
+func (l *countingLocator) Locate(ip string) (*Location, error) {
+	l.calls++
+	if ip == "192.0.2.1" {
+		return nil, nil
+	}
+	return &Location{CountryCode: ip}, nil
+}
+
+func TestCache(t *testing.T) {
+	locator := &countingLocator{}
+	cache := NewCache(locator, 2)
+
+	for _, ip := range []string{"a", "b", "a", "192.0.2.1", "192.0.2.1", "a", "b"} {
+		_, err := cache.Locate(ip)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, locator.calls, "b is evicted by the third address, the rest come from the cache")
+
+	location, err := cache.Locate("a")
+	require.NoError(t, err)
+	require.Equal(t, "a", location.CountryCode)
+}
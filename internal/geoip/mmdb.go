@@ -0,0 +1,312 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+)
+
+// Data types of the MaxMind DB data section.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// dataSectionSeparator is the run of zero bytes between the search tree and
+// the data section.
+const dataSectionSeparator = 16
+
+// maxDecodeDepth bounds nested maps, arrays and pointers, so a corrupt file
+// cannot recurse without end.
+const maxDecodeDepth = 64
+
+var (
+	metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+	errCorruptDatabase = errors.New("corrupt MaxMind DB file")
+)
+
+// mmdb is a MaxMind DB file held in memory. Only the parts needed for
+// lookups are implemented: the binary search tree and the data section
+// decoder.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func parseMMDB(buf []byte) (*mmdb, error) {
+	metaStart := bytes.LastIndex(buf, metadataMarker)
+	if metaStart < 0 {
+		return nil, errors.New("not a MaxMind DB file: metadata marker not found")
+	}
+	value, _, err := decoder{data: buf[metaStart+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, errCorruptDatabase
+	}
+
+	db := &mmdb{}
+	for key, target := range map[string]*uint{"node_count": &db.nodeCount, "record_size": &db.recordSize, "ip_version": &db.ipVersion} {
+		number, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("metadata field %s is missing", key)
+		}
+		*target = uint(number)
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(metaStart) {
+		return nil, errCorruptDatabase
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+dataSectionSeparator : metaStart]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases.
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a tree node.
+func (db *mmdb) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+bit*4:]))
+	}
+}
+
+// lookup returns the decoded record of the network holding ip, or nil when
+// the database has none.
+func (db *mmdb) lookup(ip net.IP) (any, error) {
+	node := uint(0)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, uint(bit))
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errCorruptDatabase
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, errCorruptDatabase
+	}
+	value, _, err := decoder{data: db.data}.decode(offset, 0)
+	return value, err
+}
+
+// decoder reads values of the MaxMind DB data format. Pointers are offsets
+// into data.
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value at offset and the offset following it.
+func (d decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errCorruptDatabase
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+	return d.value(typ, size, offset, depth)
+}
+
+// control reads a control byte with its extended type and size bytes. For
+// pointers size holds the low five bits of the control byte.
+func (d decoder) control(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, errCorruptDatabase
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ = uint(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errCorruptDatabase
+		}
+		typ = 7 + uint(d.data[offset])
+		offset++
+	}
+	size = uint(ctrl & 0x1f)
+	if typ == typePointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	n := size - 28
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, 0, errCorruptDatabase
+	}
+	extra := uint(0)
+	for _, b := range d.data[offset : offset+n] {
+		extra = extra<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + extra
+	case 30:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+	return typ, size, offset + n, nil
+}
+
+func (d decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, errCorruptDatabase
+	}
+	b := d.data[offset : offset+n]
+	high := bits & 0x7
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = high<<8 | uint(b[0])
+	case 2:
+		pointer = (high<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		pointer = (high<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		pointer = uint(binary.BigEndian.Uint32(b))
+	}
+	return pointer, offset + n, nil
+}
+
+func (d decoder) value(typ, size, offset uint, depth int) (any, uint, error) {
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errCorruptDatabase
+			}
+			m[name], offset, err = d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			item, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, item)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errCorruptDatabase
+	}
+	b := d.data[offset : offset+size]
+	next := offset + size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorruptDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorruptDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, errCorruptDatabase
+		}
+		number := uint64(0)
+		for _, c := range b {
+			number = number<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int32(uint32(number)), next, nil
+		}
+		return number, next, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
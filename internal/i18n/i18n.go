@@ -0,0 +1,2570 @@
+// Package i18n translates API error messages. Every message is keyed by a
+// stable machine-readable code, so clients can rely on the code while the
+// text follows the language negotiated from Accept-Language.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	English = "en"
+	Polish  = "pl"
+
+	// DefaultLanguage is used when the client accepts none of the supported
+	// languages.
+	DefaultLanguage = English
+)
+
+// Error codes.
+const (
+	AuthorizationRequired    = "authorization_required"
+	InvalidAuthorization     = "invalid_authorization_header"
+	InvalidToken             = "invalid_token"
+	SessionCheckFailed       = "session_check_failed"
+	SessionTerminated        = "session_terminated"
+	Unauthorized             = "unauthorized"
+	AdminRequired            = "admin_required"
+	InvalidCredentials       = "invalid_credentials"
+	InvalidRequestBody       = "invalid_request_body"
+	InternalError            = "internal_error"
+	PermissionCheckFailed    = "permission_check_failed"
+	NodeLookupFailed         = "node_lookup_failed"
+	CreatePermissionDenied   = "create_permission_denied"
+	NodeTrashed              = "node_trashed"
+	NotShared                = "not_shared"
+	InsufficientPermission   = "insufficient_permission"
+	StorageQuotaExceeded     = "storage_quota_exceeded"
+	InvalidParentID          = "invalid_parent_id"
+	NodeIDRequired           = "node_id_required"
+	FileMissingFromStorage   = "file_missing_from_storage"
+	FileMetadataLookupFailed = "file_metadata_lookup_failed"
+	FeatureDisabled          = "feature_disabled"
+
+	// Node access.
+	FileNotAccessible         = "file_not_accessible"
+	FolderNotAccessible       = "folder_not_accessible"
+	ArchiveNotAccessible      = "archive_not_accessible"
+	NodeNotAccessible         = "node_not_accessible"
+	NodeNotFoundOrDenied      = "node_not_found_or_denied"
+	NodeNotOwnedByYou         = "node_not_owned_by_you"
+	NodeNotDeletable          = "node_not_deletable"
+	NodeNotModifiable         = "node_not_modifiable"
+	ParentFolderNotAccessible = "parent_folder_not_accessible"
+	SharedFolderNotAccessible = "shared_folder_not_accessible"
+	NodeIDNotAccessible       = "node_id_not_accessible"
+	NodeWithIDNotAccessible   = "node_with_id_not_accessible"
+
+	// Email.
+	EmailLookupFailed          = "email_lookup_failed"
+	EmailNotConfigured         = "email_not_configured"
+	InvalidEmail               = "invalid_email"
+	EmailUpdateFailed          = "email_update_failed"
+	TooManyEmails              = "too_many_emails"
+	VerificationEmailFailed    = "verification_email_failed"
+	EmailVerificationFailed    = "email_verification_failed"
+	EmailTaken                 = "email_taken"
+	PasswordResetRequestFailed = "password_reset_request_failed"
+	NewPasswordTooShort        = "new_password_too_short"
+	PasswordResetFailed        = "password_reset_failed"
+
+	// Folder hooks.
+	HookLookupFailed        = "hook_lookup_failed"
+	HookNotFound            = "hook_not_found"
+	HookURLNotAllowed       = "hook_url_not_allowed"
+	HookSecretFailed        = "hook_secret_failed"
+	HookSaveFailed          = "hook_save_failed"
+	HookRemoveFailed        = "hook_remove_failed"
+	TooManyHookMimePrefixes = "too_many_hook_mime_prefixes"
+
+	// Public links.
+	TooManyLinkPreviews      = "too_many_link_previews"
+	LinkLookupFailed         = "link_lookup_failed"
+	LinkNotFound             = "link_not_found"
+	LinkExpired              = "link_expired"
+	LinkDownloadLimitReached = "link_download_limit_reached"
+	LinkNoPreviewImage       = "link_no_preview_image"
+	ThumbnailFailed          = "thumbnail_failed"
+	QRCodeFailed             = "qr_code_failed"
+	InvalidLinkType          = "invalid_link_type"
+	TransferCapOnUploadLink  = "transfer_cap_on_upload_link"
+	UploadLinkToFile         = "upload_link_to_file"
+	LinkTokenFailed          = "link_token_failed"
+	LinkCreateFailed         = "link_create_failed"
+	LinkListFailed           = "link_list_failed"
+	InvalidLinkID            = "invalid_link_id"
+	EmptyLinkPassword        = "empty_link_password"
+	LinkUpdateFailed         = "link_update_failed"
+	LinkRevokeFailed         = "link_revoke_failed"
+	LinkNodeNotFound         = "link_node_not_found"
+	LinkPasswordRequired     = "link_password_required"
+	TooManyLinkPasswords     = "too_many_link_passwords"
+	WrongLinkPassword        = "wrong_link_password"
+	LinkUploadNotAllowed     = "link_upload_not_allowed"
+	LinkUploadLimitReached   = "link_upload_limit_reached"
+	LinkExpiryInPast         = "link_expiry_in_past"
+	InvalidLinkMaxDownloads  = "invalid_link_max_downloads"
+	InvalidLinkTransferCap   = "invalid_link_transfer_cap"
+	LinkTransferCapReached   = "link_transfer_cap_reached"
+	LinkSlugTaken            = "link_slug_taken"
+	InvalidSlugLength        = "invalid_slug_length"
+	InvalidSlugCharacters    = "invalid_slug_characters"
+
+	// Uploads.
+	ContentLengthRequired = "content_length_required"
+	UploadStageFailed     = "upload_stage_failed"
+	FileStoreFailed       = "file_store_failed"
+	UploadReadFailed      = "upload_read_failed"
+	UploadCheckFailed     = "upload_check_failed"
+	MultipartParseFailed  = "multipart_parse_failed"
+	InvalidChecksumHeader = "invalid_checksum_header"
+	ChecksumMismatch      = "checksum_mismatch"
+	ContentTypeNotAllowed = "content_type_not_allowed"
+	ContentQuarantined    = "content_quarantined"
+	ContentBlocked        = "content_blocked"
+
+	// Shares.
+	InvalidPermissionsValue     = "invalid_permissions_value"
+	RecipientAndGroup           = "recipient_and_group"
+	NodeAccessCheckFailed       = "node_access_check_failed"
+	ShareRequiresManage         = "share_requires_manage"
+	GroupShareVersionPinned     = "group_share_version_pinned"
+	VersionPinOnFolder          = "version_pin_on_folder"
+	PinnedShareReadOnly         = "pinned_share_read_only"
+	FixedVersionShare           = "fixed_version_share"
+	FileVersionsLookupFailed    = "file_versions_lookup_failed"
+	RecipientLookupFailed       = "recipient_lookup_failed"
+	RecipientUserNotFound       = "recipient_user_not_found"
+	ShareWithSelf               = "share_with_self"
+	ShareWithOwner              = "share_with_owner"
+	AccessViewRequiresManage    = "access_view_requires_manage"
+	EffectiveAccessLookupFailed = "effective_access_lookup_failed"
+	SharersLookupFailed         = "sharers_lookup_failed"
+	InvalidSharedSort           = "invalid_shared_sort"
+	InvalidOrder                = "invalid_order"
+	IncomingSharesListFailed    = "incoming_shares_list_failed"
+	SharerUsernameRequired      = "sharer_username_required"
+	SharerNotFound              = "sharer_not_found"
+	SharedNodesListFailed       = "shared_nodes_list_failed"
+	AccessCheckFailed           = "access_check_failed"
+	SharedContentListFailed     = "shared_content_list_failed"
+	InvalidPermission           = "invalid_permission"
+	OutgoingSharesLookupFailed  = "outgoing_shares_lookup_failed"
+	ShareStatsFailed            = "share_stats_failed"
+	ShareLookupFailed           = "share_lookup_failed"
+	ShareNotUpdatable           = "share_not_updatable"
+	ShareChangeRequiresManage   = "share_change_requires_manage"
+	PermissionAboveOwn          = "permission_above_own"
+	ShareUpdateFailed           = "share_update_failed"
+	InvalidBefore               = "invalid_before"
+	SharedNodeGone              = "shared_node_gone"
+	ActivityLookupFailed        = "activity_lookup_failed"
+	ShareListRequiresManage     = "share_list_requires_manage"
+	SharesLookupFailed          = "shares_lookup_failed"
+	ShareNotFound               = "share_not_found"
+	InvalidShareIDFormat        = "invalid_share_id_format"
+	ShareDeleteFailed           = "share_delete_failed"
+	ShareNotDeletable           = "share_not_deletable"
+	ShareNodeFailed             = "share_node_failed"
+	ShareMessageTooLong         = "share_message_too_long"
+	VersionNotFound             = "version_not_found"
+	VersionGone                 = "version_gone"
+	AlreadySharedThroughFolder  = "already_shared_through_folder"
+	ShareRevokeFailed           = "share_revoke_failed"
+	QuarantinedFile             = "quarantined_file"
+	AlreadyShared               = "already_shared"
+
+	// Access log.
+	NodeNotOwned          = "node_not_owned"
+	AccessLogLookupFailed = "access_log_lookup_failed"
+	ConfigReloadRejected  = "config_reload_rejected"
+
+	// Admin.
+	OwnerLookupFailed         = "owner_lookup_failed"
+	OwnerNotFound             = "owner_not_found"
+	ParentLookupFailed        = "parent_lookup_failed"
+	ParentNotFound            = "parent_not_found"
+	BulkCreateFailed          = "bulk_create_failed"
+	InvalidBulkFolders        = "invalid_bulk_folders"
+	InvalidBulkFilesPerFolder = "invalid_bulk_files_per_folder"
+	TooManyBulkNodes          = "too_many_bulk_nodes"
+	OrphansListFailed         = "orphans_list_failed"
+	RecoveryFolderExists      = "recovery_folder_exists"
+	OrphansRepairFailed       = "orphans_repair_failed"
+	UserAndNodeRequired       = "user_and_node_required"
+	UserLookupFailed          = "user_lookup_failed"
+	UserNotFound              = "user_not_found"
+	StatisticsLookupFailed    = "statistics_lookup_failed"
+	UsersListFailed           = "users_list_failed"
+	UsernameRequired          = "username_required"
+	PasswordTooShort          = "password_too_short"
+	NegativeStorageQuota      = "negative_storage_quota"
+	PasswordHashFailed        = "password_hash_failed"
+	UserCreateFailed          = "user_create_failed"
+	UsernameTaken             = "username_taken"
+	InvalidUserID             = "invalid_user_id"
+	DisableOwnAccount         = "disable_own_account"
+	UserDataLookupFailed      = "user_data_lookup_failed"
+	DirectoryManagedAccount   = "directory_managed_account"
+	UserUpdateFailed          = "user_update_failed"
+
+	// Announcements.
+	AnnouncementsListFailed     = "announcements_list_failed"
+	InvalidAnnouncementLevel    = "invalid_announcement_level"
+	AnnouncementEndsBeforeStart = "announcement_ends_before_start"
+	AnnouncementCreateFailed    = "announcement_create_failed"
+	InvalidAnnouncementID       = "invalid_announcement_id"
+	AnnouncementRemoveFailed    = "announcement_remove_failed"
+	AnnouncementNotFound        = "announcement_not_found"
+	InvalidAnnouncementLength   = "invalid_announcement_length"
+
+	// Archives.
+	InvalidArchiveFormat      = "invalid_archive_format"
+	ArchiveStageFailed        = "archive_stage_failed"
+	ArchiveReceiveFailed      = "archive_receive_failed"
+	NotAnArchive              = "not_an_archive"
+	EmptyArchive              = "empty_archive"
+	QuotaOwnerCheckFailed     = "quota_owner_check_failed"
+	ImportQueueFailed         = "import_queue_failed"
+	InvalidImportID           = "invalid_import_id"
+	ArchiveImportLookupFailed = "archive_import_lookup_failed"
+	ArchiveImportNotFound     = "archive_import_not_found"
+	ArchiveTooManyEntries     = "archive_too_many_entries"
+	ArchiveExpandsTooMuch     = "archive_expands_too_much"
+	InvalidArchive            = "invalid_archive"
+
+	// Authentication.
+	ServerConfigError     = "server_config_error"
+	AccessTokenFailed     = "access_token_failed"
+	TokenGenerationFailed = "token_generation_failed"
+	LoginSessionFailed    = "login_session_failed"
+	RefreshTokenRequired  = "refresh_token_required"
+	TokenRefreshFailed    = "token_refresh_failed"
+	LogoutFailed          = "logout_failed"
+	InvalidRefreshToken   = "invalid_refresh_token"
+
+	// Blob migrations.
+	BackendsRequired      = "backends_required"
+	SameBackends          = "same_backends"
+	MigrationQueueFailed  = "migration_queue_failed"
+	MigrationsListFailed  = "migrations_list_failed"
+	InvalidMigrationID    = "invalid_migration_id"
+	MigrationLookupFailed = "migration_lookup_failed"
+	MigrationNotFound     = "migration_not_found"
+	UnknownStorageBackend = "unknown_storage_backend"
+
+	// Checksums.
+	ChecksumOnFolder = "checksum_on_folder"
+	ChecksumFailed   = "checksum_failed"
+
+	// Cleanup policies.
+	FolderLookupFailed        = "folder_lookup_failed"
+	FolderNotOwned            = "folder_not_owned"
+	CleanupPolicyLookupFailed = "cleanup_policy_lookup_failed"
+	CleanupPolicyNotFound     = "cleanup_policy_not_found"
+	CleanupPolicyEmpty        = "cleanup_policy_empty"
+	CleanupPolicySaveFailed   = "cleanup_policy_save_failed"
+	CleanupPolicyRemoveFailed = "cleanup_policy_remove_failed"
+	InvalidCleanupLimits      = "invalid_cleanup_limits"
+	CleanupPreviewFailed      = "cleanup_preview_failed"
+	FolderOnlySetting         = "folder_only_setting"
+	InvalidParameter          = "invalid_parameter"
+
+	// Clipboard.
+	InvalidClipboardOperation = "invalid_clipboard_operation"
+	ClipboardSaveFailed       = "clipboard_save_failed"
+	ClipboardLookupFailed     = "clipboard_lookup_failed"
+	ClipboardEmpty            = "clipboard_empty"
+	ClipboardClearFailed      = "clipboard_clear_failed"
+	InvalidClipboardSize      = "invalid_clipboard_size"
+
+	// File content.
+	ReplaceFolderContent     = "replace_folder_content"
+	ModifyPermissionDenied   = "modify_permission_denied"
+	FileModifiedSinceVersion = "file_modified_since_version"
+	FileOwnerQuotaExceeded   = "file_owner_quota_exceeded"
+	ContentStageFailed       = "content_stage_failed"
+	ContentStoreFailed       = "content_store_failed"
+	ContentUpdateFailed      = "content_update_failed"
+	SignatureOnFolder        = "signature_on_folder"
+	SignatureFailed          = "signature_failed"
+	DeltaUnsupported         = "delta_unsupported"
+	DeltaApplyFailed         = "delta_apply_failed"
+	DeltaPatchRejected       = "delta_patch_rejected"
+	InvalidBlockSize         = "invalid_block_size"
+
+	// Content policy.
+	QuarantineListFailed    = "quarantine_list_failed"
+	QuarantineReleaseFailed = "quarantine_release_failed"
+	FileNotQuarantined      = "file_not_quarantined"
+
+	// Changes and events.
+	InvalidSinceEvent         = "invalid_since_event"
+	FolderChangesFailed       = "folder_changes_failed"
+	TooManyChanges            = "too_many_changes"
+	InvalidDays               = "invalid_days"
+	TransferStatsLookupFailed = "transfer_stats_lookup_failed"
+	InvalidSince              = "invalid_since"
+	EventsLookupFailed        = "events_lookup_failed"
+	InvalidSinceNonNegative   = "invalid_since_non_negative"
+
+	// Favorites.
+	FavoriteAddFailed    = "favorite_add_failed"
+	FavoriteRemoveFailed = "favorite_remove_failed"
+	FavoritesListFailed  = "favorites_list_failed"
+	AlreadyFavorite      = "already_favorite"
+
+	// Feature flags.
+	UnknownFeatureFlag              = "unknown_feature_flag"
+	FeaturesLookupFailed            = "features_lookup_failed"
+	FeatureFlagsListFailed          = "feature_flags_list_failed"
+	InvalidRolloutPercent           = "invalid_rollout_percent"
+	FeatureFlagOverrideFailed       = "feature_flag_override_failed"
+	FeatureFlagOverrideRemoveFailed = "feature_flag_override_remove_failed"
+	FeatureFlagForceFailed          = "feature_flag_force_failed"
+	FeatureFlagUpdateFailed         = "feature_flag_update_failed"
+	FeatureFlagNotForced            = "feature_flag_not_forced"
+	FeatureFlagLookupFailed         = "feature_flag_lookup_failed"
+
+	// Federation.
+	FederationDisabled         = "federation_disabled"
+	UnknownInstance            = "unknown_instance"
+	FederatedShareLookupFailed = "federated_share_lookup_failed"
+	InvalidShareOffer          = "invalid_share_offer"
+	RecipientNotFound          = "recipient_not_found"
+	ShareRecordFailed          = "share_record_failed"
+	ShareTokenTaken            = "share_token_taken"
+	ShareNodeNotFound          = "share_node_not_found"
+	NotAFolder                 = "not_a_folder"
+	FolderListFailed           = "folder_list_failed"
+	NotAFile                   = "not_a_file"
+	SharedContentCheckFailed   = "shared_content_check_failed"
+	ShareTokenFailed           = "share_token_failed"
+	ShareCreateFailed          = "share_create_failed"
+	SharesListFailed           = "shares_list_failed"
+	InvalidShareID             = "invalid_share_id"
+	PeerNotFound               = "peer_not_found"
+	PeerUnreachable            = "peer_unreachable"
+	UnknownInstanceName        = "unknown_instance_name"
+	AlreadySharedWith          = "already_shared_with"
+	PeerNoLongerTrusted        = "peer_no_longer_trusted"
+	FederationRequestRejected  = "federation_request_rejected"
+	InvalidFederatedAddress    = "invalid_federated_address"
+
+	// Folder comparison.
+	CompareIDsRequired      = "compare_i_ds_required"
+	CompareFailed           = "compare_failed"
+	InvalidCompareLimit     = "invalid_compare_limit"
+	FolderTooLargeToCompare = "folder_too_large_to_compare"
+
+	// Groups.
+	InvalidGroupIDFormat    = "invalid_group_id_format"
+	GroupLookupFailed       = "group_lookup_failed"
+	GroupNotFound           = "group_not_found"
+	GroupOwnerRequired      = "group_owner_required"
+	GroupCreateFailed       = "group_create_failed"
+	GroupsListFailed        = "groups_list_failed"
+	GroupMembersListFailed  = "group_members_list_failed"
+	GroupRenameFailed       = "group_rename_failed"
+	GroupDeleteFailed       = "group_delete_failed"
+	UserFindFailed          = "user_find_failed"
+	GroupMemberAddFailed    = "group_member_add_failed"
+	AlreadyGroupMember      = "already_group_member"
+	InvalidUserIDFormat     = "invalid_user_id_format"
+	GroupOwnerCannotLeave   = "group_owner_cannot_leave"
+	DirectoryManagedGroup   = "directory_managed_group"
+	GroupMemberRemoveFailed = "group_member_remove_failed"
+	NotGroupMember          = "not_group_member"
+	GroupSharesListFailed   = "group_shares_list_failed"
+	AlreadySharedWithGroup  = "already_shared_with_group"
+	GroupNameRequired       = "group_name_required"
+	GroupNameTooLong        = "group_name_too_long"
+	GroupExists             = "group_exists"
+
+	// Instant upload.
+	InvalidFileNameLength    = "invalid_file_name_length"
+	NonPositiveSize          = "non_positive_size"
+	InvalidSHA256            = "invalid_sha256"
+	StoredContentCheckFailed = "stored_content_check_failed"
+	FileCreateFailed         = "file_create_failed"
+	DuplicateNodeName        = "duplicate_node_name"
+	DuplicateNameInFolder    = "duplicate_name_in_folder"
+
+	// Legal exports.
+	InvalidExportID         = "invalid_export_id"
+	LegalExportLookupFailed = "legal_export_lookup_failed"
+	LegalExportNotFound     = "legal_export_not_found"
+	NodeAndReasonRequired   = "node_and_reason_required"
+	LegalExportQueueFailed  = "legal_export_queue_failed"
+	NodeNotFound            = "node_not_found"
+	LegalExportsListFailed  = "legal_exports_list_failed"
+	ExportNotCompleted      = "export_not_completed"
+	ExportBundleOpenFailed  = "export_bundle_open_failed"
+
+	// Limits.
+	FolderLimitsCheckFailed = "folder_limits_check_failed"
+	FolderDepthExceeded     = "folder_depth_exceeded"
+	FolderChildrenExceeded  = "folder_children_exceeded"
+	ResponseEncodeFailed    = "response_encode_failed"
+
+	// Nodes.
+	EmptyFolderName             = "empty_folder_name"
+	ParentFolderMissing         = "parent_folder_missing"
+	DuplicateFolderName         = "duplicate_folder_name"
+	FolderCreateFailed          = "folder_create_failed"
+	NodesListFailed             = "nodes_list_failed"
+	NoFilesUploaded             = "no_files_uploaded"
+	NoFilesProcessed            = "no_files_processed"
+	DownloadFolder              = "download_folder"
+	SharedVersionGone           = "shared_version_gone"
+	PreviewUnsupported          = "preview_unsupported"
+	RangeNotSatisfiable         = "range_not_satisfiable"
+	DeleteLookupFailed          = "delete_lookup_failed"
+	DeletePermissionDenied      = "delete_permission_denied"
+	NodeDeleteFailed            = "node_delete_failed"
+	RenamePermissionCheckFailed = "rename_permission_check_failed"
+	RenamePermissionDenied      = "rename_permission_denied"
+	EmptyName                   = "empty_name"
+	NodeRenameFailed            = "node_rename_failed"
+	NodeMoveFailed              = "node_move_failed"
+	NodeCopyFailed              = "node_copy_failed"
+	TargetFolderNotAccessible   = "target_folder_not_accessible"
+	TargetFolderMissing         = "target_folder_missing"
+	CrossOwnerMove              = "cross_owner_move"
+	MovePermissionDenied        = "move_permission_denied"
+	MoveIntoPermissionDenied    = "move_into_permission_denied"
+	CopyIntoPermissionDenied    = "copy_into_permission_denied"
+	MoveIntoItself              = "move_into_itself"
+	CopyIntoItself              = "copy_into_itself"
+	DuplicateNameInTarget       = "duplicate_name_in_target"
+	TargetQuotaExceeded         = "target_quota_exceeded"
+	NoUpdateSpecified           = "no_update_specified"
+	NodeIDsRequired             = "node_i_ds_required"
+	InvalidDownloadFormat       = "invalid_download_format"
+	ArchiveMeasureFailed        = "archive_measure_failed"
+	ArchiveCreateFailed         = "archive_create_failed"
+	ArchiveTooLarge             = "archive_too_large"
+	SingleFileChecksumHeader    = "single_file_checksum_header"
+
+	// Offline pins.
+	OfflinePinFailed      = "offline_pin_failed"
+	OfflineUnpinFailed    = "offline_unpin_failed"
+	NotPinnedOffline      = "not_pinned_offline"
+	OfflinePinsListFailed = "offline_pins_list_failed"
+	DeviceUnknown         = "device_unknown"
+	DeviceIDTooLong       = "device_id_too_long"
+
+	// Onboarding.
+	OnboardingListFailed       = "onboarding_list_failed"
+	OnboardingSaveFailed       = "onboarding_save_failed"
+	TooManyOnboardingTemplates = "too_many_onboarding_templates"
+	NodeNotAmongYourFiles      = "node_not_among_your_files"
+
+	// Preflight.
+	NegativeSize              = "negative_size"
+	FileNameTooLong           = "file_name_too_long"
+	MoveNodeRequired          = "move_node_required"
+	InvalidPreflightOperation = "invalid_preflight_operation"
+	PreflightFailed           = "preflight_failed"
+
+	// Ranges.
+	ContentMissing = "content_missing"
+
+	// Rules.
+	InvalidRuleID      = "invalid_rule_id"
+	RulesListFailed    = "rules_list_failed"
+	RuleCreateFailed   = "rule_create_failed"
+	RuleDeleteFailed   = "rule_delete_failed"
+	RuleNotFound       = "rule_not_found"
+	RuleEvaluateFailed = "rule_evaluate_failed"
+	RuleLookupFailed   = "rule_lookup_failed"
+	RuleRunFailed      = "rule_run_failed"
+	TagsListFailed     = "tags_list_failed"
+	RuleValidateFailed = "rule_validate_failed"
+	EmptyRuleName      = "empty_rule_name"
+	EmptyNamePattern   = "empty_name_pattern"
+	InvalidNamePattern = "invalid_name_pattern"
+	RuleFolderNotFound = "rule_folder_not_found"
+	RuleSameFolders    = "rule_same_folders"
+	InvalidTagLength   = "invalid_tag_length"
+	TooManyRuleTags    = "too_many_rule_tags"
+	RuleWithoutAction  = "rule_without_action"
+
+	// Sessions.
+	SessionsLookupFailed    = "sessions_lookup_failed"
+	InvalidSessionIDFormat  = "invalid_session_id_format"
+	SessionDeleteFailed     = "session_delete_failed"
+	SessionsTerminateFailed = "sessions_terminate_failed"
+
+	// Share review.
+	InvalidShareIDsCount = "invalid_share_i_ds_count"
+	SharesRevokeFailed   = "shares_revoke_failed"
+
+	// Storage.
+	StorageBreakdownFailed = "storage_breakdown_failed"
+	SnapshotCreateFailed   = "snapshot_create_failed"
+
+	// Thumbnails.
+	InvalidThumbnailBatch = "invalid_thumbnail_batch"
+	InvalidThumbnailSize  = "invalid_thumbnail_size"
+	NoThumbnail           = "no_thumbnail"
+
+	// Transcriptions.
+	TranscriptionNotConfigured = "transcription_not_configured"
+	InvalidTranscriptFormat    = "invalid_transcript_format"
+	NotTranscribable           = "not_transcribable"
+	TranscriptionQueueFailed   = "transcription_queue_failed"
+	InvalidJobID               = "invalid_job_id"
+	TranscriptionLookupFailed  = "transcription_lookup_failed"
+	TranscriptionNotFound      = "transcription_not_found"
+	TranscriptionTooLarge      = "transcription_too_large"
+
+	// Trash.
+	TrashPurgeFailed         = "trash_purge_failed"
+	InvalidBatchIDFormat     = "invalid_batch_id_format"
+	TrashListFailed          = "trash_list_failed"
+	TrashSummaryFailed       = "trash_summary_failed"
+	NodeNotInTrash           = "node_not_in_trash"
+	RestoreNameTaken         = "restore_name_taken"
+	NodeRestoreFailed        = "node_restore_failed"
+	BatchNotInTrash          = "batch_not_in_trash"
+	RestoreNameTakenInOrigin = "restore_name_taken_in_origin"
+	BatchRestoreFailed       = "batch_restore_failed"
+
+	// Undo.
+	UndoTokenNotFound = "undo_token_not_found"
+	UndoNameTaken     = "undo_name_taken"
+	UndoFailed        = "undo_failed"
+	UndoConflict      = "undo_conflict"
+	UndoForbidden     = "undo_forbidden"
+
+	// Upload sessions.
+	InvalidUploadID           = "invalid_upload_id"
+	UploadSessionLookupFailed = "upload_session_lookup_failed"
+	UploadSessionNotFound     = "upload_session_not_found"
+	NonPositiveTotalSize      = "non_positive_total_size"
+	UploadStartFailed         = "upload_start_failed"
+	InvalidUploadOffset       = "invalid_upload_offset"
+	InvalidChunkSHA256        = "invalid_chunk_sha256"
+	ChunkPastEnd              = "chunk_past_end"
+	ChunkStoreFailed          = "chunk_store_failed"
+	EmptyChunk                = "empty_chunk"
+	UploadAssembleFailed      = "upload_assemble_failed"
+	UploadCancelFailed        = "upload_cancel_failed"
+	ChunkOverlap              = "chunk_overlap"
+	ChecksumMismatchHeader    = "checksum_mismatch_header"
+	UploadIncomplete          = "upload_incomplete"
+
+	// URL imports.
+	InvalidImportURL      = "invalid_import_url"
+	InvalidImportFileName = "invalid_import_file_name"
+	URLImportLookupFailed = "url_import_lookup_failed"
+	URLImportNotFound     = "url_import_not_found"
+
+	// Users.
+	TokenUserLookupFailed     = "token_user_lookup_failed"
+	VersionUsageLookupFailed  = "version_usage_lookup_failed"
+	VersionPolicyLookupFailed = "version_policy_lookup_failed"
+	UserFindError             = "user_find_error"
+	DirectoryManagedPassword  = "directory_managed_password"
+	OldPasswordMismatch       = "old_password_mismatch"
+	NewPasswordHashFailed     = "new_password_hash_failed"
+	PasswordUpdateFailed      = "password_update_failed"
+
+	// Versions.
+	VersionsOnFolder        = "versions_on_folder"
+	VersionsListFailed      = "versions_list_failed"
+	InvalidVersionRange     = "invalid_version_range"
+	VersionReadFailed       = "version_read_failed"
+	InvalidVersionPolicy    = "invalid_version_policy"
+	VersionPolicySaveFailed = "version_policy_save_failed"
+	VersionNotText          = "version_not_text"
+	VersionTooLargeToDiff   = "version_too_large_to_diff"
+
+	// Watches.
+	WatchFailed            = "watch_failed"
+	UnwatchFailed          = "unwatch_failed"
+	FolderNotWatched       = "folder_not_watched"
+	InvalidDigestFrequency = "invalid_digest_frequency"
+)
+
+var messages = map[string]map[string]string{
+	AuthorizationRequired: {
+		English: "Authorization header required",
+		Polish:  "Wymagany jest nagłówek Authorization",
+	},
+	InvalidAuthorization: {
+		English: "Invalid Authorization header format",
+		Polish:  "Nieprawidłowy format nagłówka Authorization",
+	},
+	InvalidToken: {
+		English: "Invalid or expired token",
+		Polish:  "Nieprawidłowy lub wygasły token",
+	},
+	SessionCheckFailed: {
+		English: "Failed to verify session",
+		Polish:  "Nie udało się zweryfikować sesji",
+	},
+	SessionTerminated: {
+		English: "Session has been terminated",
+		Polish:  "Sesja została zakończona",
+	},
+	Unauthorized: {
+		English: "Unauthorized",
+		Polish:  "Brak autoryzacji",
+	},
+	AdminRequired: {
+		English: "Administrator privileges required",
+		Polish:  "Wymagane są uprawnienia administratora",
+	},
+	InvalidCredentials: {
+		English: "Invalid username or password",
+		Polish:  "Nieprawidłowa nazwa użytkownika lub hasło",
+	},
+	InvalidRequestBody: {
+		English: "Invalid request body",
+		Polish:  "Nieprawidłowa treść żądania",
+	},
+	InternalError: {
+		English: "Internal server error",
+		Polish:  "Wewnętrzny błąd serwera",
+	},
+	PermissionCheckFailed: {
+		English: "Failed to verify permissions",
+		Polish:  "Nie udało się sprawdzić uprawnień",
+	},
+	NodeLookupFailed: {
+		English: "Failed to retrieve node",
+		Polish:  "Nie udało się pobrać elementu",
+	},
+	CreatePermissionDenied: {
+		English: "You do not have permission to create items in this folder",
+		Polish:  "Nie masz uprawnień do tworzenia elementów w tym folderze",
+	},
+	NodeTrashed: {
+		English: "This node is in the trash",
+		Polish:  "Ten element znajduje się w koszu",
+	},
+	NotShared: {
+		English: "This node is not shared with you",
+		Polish:  "Ten element nie został Ci udostępniony",
+	},
+	InsufficientPermission: {
+		English: "You do not have permission to perform this operation on this node",
+		Polish:  "Nie masz uprawnień do wykonania tej operacji na tym elemencie",
+	},
+	StorageQuotaExceeded: {
+		English: "Storage quota for the owner of this folder is exceeded",
+		Polish:  "Przekroczono limit miejsca właściciela tego folderu",
+	},
+	InvalidParentID: {
+		English: "Invalid ParentID format",
+		Polish:  "Nieprawidłowy format ParentID",
+	},
+	NodeIDRequired: {
+		English: "Node ID is required",
+		Polish:  "Wymagany jest identyfikator elementu",
+	},
+	FileMissingFromStorage: {
+		English: "File not found on storage",
+		Polish:  "Nie znaleziono pliku w magazynie",
+	},
+	FileMetadataLookupFailed: {
+		English: "Failed to retrieve file metadata",
+		Polish:  "Nie udało się pobrać metadanych pliku",
+	},
+	FeatureDisabled: {
+		English: "This feature is not enabled for your account",
+		Polish:  "Ta funkcja nie jest włączona dla Twojego konta",
+	},
+	FileNotAccessible: {
+		English: "File not found or you do not have permission to access it",
+		Polish:  "Nie znaleziono pliku lub nie masz do niego dostępu",
+	},
+	FolderNotAccessible: {
+		English: "Folder not found or you do not have permission to access it",
+		Polish:  "Nie znaleziono folderu lub nie masz do niego dostępu",
+	},
+	ArchiveNotAccessible: {
+		English: "Archive not found or you do not have permission to access it",
+		Polish:  "Nie znaleziono archiwum lub nie masz do niego dostępu",
+	},
+	NodeNotAccessible: {
+		English: "Node not found or you do not have permission to access it",
+		Polish:  "Nie znaleziono elementu lub nie masz do niego dostępu",
+	},
+	NodeNotFoundOrDenied: {
+		English: "Node not found or access denied",
+		Polish:  "Nie znaleziono elementu lub odmowa dostępu",
+	},
+	NodeNotOwnedByYou: {
+		English: "Node not found or you are not its owner",
+		Polish:  "Nie znaleziono elementu lub nie jesteś jego właścicielem",
+	},
+	NodeNotDeletable: {
+		English: "Node not found or you do not have permission to delete it",
+		Polish:  "Nie znaleziono elementu lub nie masz uprawnień do jego usunięcia",
+	},
+	NodeNotModifiable: {
+		English: "Node not found or you do not have permission to modify it",
+		Polish:  "Nie znaleziono elementu lub nie masz uprawnień do jego zmiany",
+	},
+	ParentFolderNotAccessible: {
+		English: "Parent folder not found or access denied",
+		Polish:  "Nie znaleziono folderu nadrzędnego lub odmowa dostępu",
+	},
+	SharedFolderNotAccessible: {
+		English: "Shared folder not found or access denied",
+		Polish:  "Nie znaleziono udostępnionego folderu lub odmowa dostępu",
+	},
+	NodeIDNotAccessible: {
+		English: "Node %s not found or access denied",
+		Polish:  "Nie znaleziono elementu %s lub odmowa dostępu",
+	},
+	NodeWithIDNotAccessible: {
+		English: "node with ID %s not found or you do not have permission to access it",
+		Polish:  "Nie znaleziono elementu o ID %s lub nie masz do niego dostępu",
+	},
+	EmailLookupFailed: {
+		English: "Failed to retrieve email address",
+		Polish:  "Nie udało się pobrać adresu e-mail",
+	},
+	EmailNotConfigured: {
+		English: "Email is not configured on this server",
+		Polish:  "Wysyłanie e-maili nie jest skonfigurowane na tym serwerze",
+	},
+	InvalidEmail: {
+		English: "Invalid email address",
+		Polish:  "Nieprawidłowy adres e-mail",
+	},
+	EmailUpdateFailed: {
+		English: "Failed to set email address",
+		Polish:  "Nie udało się ustawić adresu e-mail",
+	},
+	TooManyEmails: {
+		English: "Too many verification emails were sent, try again later",
+		Polish:  "Wysłano zbyt wiele e-maili weryfikacyjnych, spróbuj ponownie później",
+	},
+	VerificationEmailFailed: {
+		English: "Failed to send verification email",
+		Polish:  "Nie udało się wysłać e-maila weryfikacyjnego",
+	},
+	EmailVerificationFailed: {
+		English: "Failed to verify email address",
+		Polish:  "Nie udało się zweryfikować adresu e-mail",
+	},
+	EmailTaken: {
+		English: "the email address is already verified for another account",
+		Polish:  "ten adres e-mail jest już zweryfikowany dla innego konta",
+	},
+	PasswordResetRequestFailed: {
+		English: "Failed to request password reset",
+		Polish:  "Nie udało się zlecić resetu hasła",
+	},
+	NewPasswordTooShort: {
+		English: "New password must be at least 8 characters long",
+		Polish:  "Nowe hasło musi mieć co najmniej 8 znaków",
+	},
+	PasswordResetFailed: {
+		English: "Failed to reset password",
+		Polish:  "Nie udało się zresetować hasła",
+	},
+	HookLookupFailed: {
+		English: "Failed to retrieve hook",
+		Polish:  "Nie udało się pobrać webhooka",
+	},
+	HookNotFound: {
+		English: "This folder has no hook",
+		Polish:  "Ten folder nie ma webhooka",
+	},
+	HookURLNotAllowed: {
+		English: "The URL must be an http(s) URL on a host listed in hooks.allowed_hosts",
+		Polish:  "Adres musi być adresem http(s) na hoście z listy hooks.allowed_hosts",
+	},
+	HookSecretFailed: {
+		English: "Failed to generate hook secret",
+		Polish:  "Nie udało się wygenerować sekretu webhooka",
+	},
+	HookSaveFailed: {
+		English: "Failed to save hook",
+		Polish:  "Nie udało się zapisać webhooka",
+	},
+	HookRemoveFailed: {
+		English: "Failed to remove hook",
+		Polish:  "Nie udało się usunąć webhooka",
+	},
+	TooManyHookMimePrefixes: {
+		English: "At most %d MIME prefixes are allowed",
+		Polish:  "Dozwolonych jest najwyżej %d prefiksów MIME",
+	},
+	TooManyLinkPreviews: {
+		English: "Too many link previews, try again later",
+		Polish:  "Zbyt wiele podglądów linków, spróbuj ponownie później",
+	},
+	LinkLookupFailed: {
+		English: "Failed to retrieve link",
+		Polish:  "Nie udało się pobrać linku",
+	},
+	LinkNotFound: {
+		English: "Link not found",
+		Polish:  "Nie znaleziono linku",
+	},
+	LinkExpired: {
+		English: "This link has expired",
+		Polish:  "Ten link wygasł",
+	},
+	LinkDownloadLimitReached: {
+		English: "This link has reached its download limit",
+		Polish:  "Ten link osiągnął limit pobrań",
+	},
+	LinkNoPreviewImage: {
+		English: "This link has no preview image",
+		Polish:  "Ten link nie ma obrazu podglądu",
+	},
+	ThumbnailFailed: {
+		English: "Failed to make thumbnail",
+		Polish:  "Nie udało się utworzyć miniatury",
+	},
+	QRCodeFailed: {
+		English: "Failed to make QR code",
+		Polish:  "Nie udało się utworzyć kodu QR",
+	},
+	InvalidLinkType: {
+		English: "type must be download or upload",
+		Polish:  "type musi mieć wartość download lub upload",
+	},
+	TransferCapOnUploadLink: {
+		English: "max_transfer_bytes only applies to download links",
+		Polish:  "max_transfer_bytes dotyczy tylko linków do pobierania",
+	},
+	UploadLinkToFile: {
+		English: "Upload links can only point to folders",
+		Polish:  "Linki do przesyłania mogą wskazywać tylko foldery",
+	},
+	LinkTokenFailed: {
+		English: "Failed to generate link token",
+		Polish:  "Nie udało się wygenerować tokenu linku",
+	},
+	LinkCreateFailed: {
+		English: "Failed to create link",
+		Polish:  "Nie udało się utworzyć linku",
+	},
+	LinkListFailed: {
+		English: "Failed to list links",
+		Polish:  "Nie udało się pobrać listy linków",
+	},
+	InvalidLinkID: {
+		English: "Invalid link ID",
+		Polish:  "Nieprawidłowe ID linku",
+	},
+	EmptyLinkPassword: {
+		English: "password cannot be empty; send null to remove it",
+		Polish:  "password nie może być puste; wyślij null, aby usunąć hasło",
+	},
+	LinkUpdateFailed: {
+		English: "Failed to update link",
+		Polish:  "Nie udało się zmienić linku",
+	},
+	LinkRevokeFailed: {
+		English: "Failed to revoke link",
+		Polish:  "Nie udało się odwołać linku",
+	},
+	LinkNodeNotFound: {
+		English: "Node not found behind this link",
+		Polish:  "Nie znaleziono elementu pod tym linkiem",
+	},
+	LinkPasswordRequired: {
+		English: "This link is protected by a password",
+		Polish:  "Ten link jest chroniony hasłem",
+	},
+	TooManyLinkPasswords: {
+		English: "Too many wrong passwords, try again later",
+		Polish:  "Zbyt wiele błędnych haseł, spróbuj ponownie później",
+	},
+	WrongLinkPassword: {
+		English: "Wrong link password",
+		Polish:  "Błędne hasło linku",
+	},
+	LinkUploadNotAllowed: {
+		English: "This link does not accept uploads",
+		Polish:  "Ten link nie przyjmuje przesyłanych plików",
+	},
+	LinkUploadLimitReached: {
+		English: "This link has reached its upload limit",
+		Polish:  "Ten link osiągnął limit przesłanych plików",
+	},
+	LinkExpiryInPast: {
+		English: "expires_at must be in the future",
+		Polish:  "expires_at musi być datą w przyszłości",
+	},
+	InvalidLinkMaxDownloads: {
+		English: "max_downloads must be positive",
+		Polish:  "max_downloads musi być dodatnie",
+	},
+	InvalidLinkTransferCap: {
+		English: "max_transfer_bytes must be positive",
+		Polish:  "max_transfer_bytes musi być dodatnie",
+	},
+	LinkTransferCapReached: {
+		English: "This link has reached its transfer limit. Ask the person who shared it for a new link.",
+		Polish:  "Ten link osiągnął limit transferu. Poproś osobę, która go udostępniła, o nowy link.",
+	},
+	LinkSlugTaken: {
+		English: "another link already has this slug",
+		Polish:  "inny link ma już ten slug",
+	},
+	InvalidSlugLength: {
+		English: "slug must be %d to %d characters long",
+		Polish:  "slug musi mieć od %d do %d znaków",
+	},
+	InvalidSlugCharacters: {
+		English: "slug can only contain letters, digits and dashes",
+		Polish:  "slug może zawierać tylko litery, cyfry i myślniki",
+	},
+	ContentLengthRequired: {
+		English: "Content-Length is required",
+		Polish:  "Wymagany jest nagłówek Content-Length",
+	},
+	UploadStageFailed: {
+		English: "Failed to stage uploaded file",
+		Polish:  "Nie udało się przyjąć przesłanego pliku",
+	},
+	FileStoreFailed: {
+		English: "Failed to store file",
+		Polish:  "Nie udało się zapisać pliku",
+	},
+	UploadReadFailed: {
+		English: "Failed to read uploaded file %s",
+		Polish:  "Nie udało się odczytać przesłanego pliku %s",
+	},
+	UploadCheckFailed: {
+		English: "Failed to check uploaded file %s",
+		Polish:  "Nie udało się sprawdzić przesłanego pliku %s",
+	},
+	MultipartParseFailed: {
+		English: "Error parsing multipart form: %s",
+		Polish:  "Błąd odczytu formularza multipart: %s",
+	},
+	InvalidChecksumHeader: {
+		English: "%s must be a hex SHA-256 checksum",
+		Polish:  "%s musi być sumą kontrolną SHA-256 w zapisie szesnastkowym",
+	},
+	ChecksumMismatch: {
+		English: "Checksum mismatch for %s: expected SHA-256 %s, but the received content has %s",
+		Polish:  "Niezgodna suma kontrolna pliku %s: oczekiwano SHA-256 %s, a otrzymana zawartość ma %s",
+	},
+	ContentTypeNotAllowed: {
+		English: "%s has the type %s, which is not allowed on this server",
+		Polish:  "%s ma typ %s, który nie jest dozwolony na tym serwerze",
+	},
+	ContentQuarantined: {
+		English: "%s is quarantined by the content policy: %s",
+		Polish:  "%s trafił do kwarantanny zgodnie z zasadami treści: %s",
+	},
+	ContentBlocked: {
+		English: "%s was blocked by the content policy: %s",
+		Polish:  "%s został zablokowany przez zasady treści: %s",
+	},
+	InvalidPermissionsValue: {
+		English: "Invalid permissions value. Must be 'read', 'write' or 'manage'",
+		Polish:  "Nieprawidłowe uprawnienia. Dozwolone: 'read', 'write' lub 'manage'",
+	},
+	RecipientAndGroup: {
+		English: "Share with either a recipient or a group, not both",
+		Polish:  "Udostępnij użytkownikowi albo grupie, nie obu naraz",
+	},
+	NodeAccessCheckFailed: {
+		English: "Internal server error while checking node access",
+		Polish:  "Wewnętrzny błąd serwera podczas sprawdzania dostępu do elementu",
+	},
+	ShareRequiresManage: {
+		English: "Sharing this node requires manage permission",
+		Polish:  "Udostępnienie tego elementu wymaga uprawnienia manage",
+	},
+	GroupShareVersionPinned: {
+		English: "Group shares cannot be pinned to a version",
+		Polish:  "Udostępnień grupom nie można przypiąć do wersji",
+	},
+	VersionPinOnFolder: {
+		English: "Only files can be shared at a specific version",
+		Polish:  "Tylko pliki można udostępnić w określonej wersji",
+	},
+	PinnedShareReadOnly: {
+		English: "Shares pinned to a version are read-only",
+		Polish:  "Udostępnienia przypięte do wersji są tylko do odczytu",
+	},
+	FixedVersionShare: {
+		English: "This file is shared with you at a fixed version",
+		Polish:  "Ten plik udostępniono Ci w ustalonej wersji",
+	},
+	FileVersionsLookupFailed: {
+		English: "Failed to retrieve file versions",
+		Polish:  "Nie udało się pobrać wersji pliku",
+	},
+	RecipientLookupFailed: {
+		English: "Internal server error while finding recipient",
+		Polish:  "Wewnętrzny błąd serwera podczas wyszukiwania odbiorcy",
+	},
+	RecipientUserNotFound: {
+		English: "Recipient user not found",
+		Polish:  "Nie znaleziono odbiorcy",
+	},
+	ShareWithSelf: {
+		English: "Cannot share a node with yourself",
+		Polish:  "Nie można udostępnić elementu samemu sobie",
+	},
+	ShareWithOwner: {
+		English: "Cannot share a node with its owner",
+		Polish:  "Nie można udostępnić elementu jego właścicielowi",
+	},
+	AccessViewRequiresManage: {
+		English: "Viewing who has access requires manage permission",
+		Polish:  "Sprawdzenie, kto ma dostęp, wymaga uprawnienia manage",
+	},
+	EffectiveAccessLookupFailed: {
+		English: "Failed to retrieve effective access",
+		Polish:  "Nie udało się pobrać efektywnego dostępu",
+	},
+	SharersLookupFailed: {
+		English: "Failed to retrieve list of sharing users",
+		Polish:  "Nie udało się pobrać listy udostępniających użytkowników",
+	},
+	InvalidSharedSort: {
+		English: "sort must be 'shared_at', 'name', 'sharer' or 'size'",
+		Polish:  "sort musi mieć wartość 'shared_at', 'name', 'sharer' lub 'size'",
+	},
+	InvalidOrder: {
+		English: "order must be 'asc' or 'desc'",
+		Polish:  "order musi mieć wartość 'asc' lub 'desc'",
+	},
+	IncomingSharesListFailed: {
+		English: "Failed to list incoming shares",
+		Polish:  "Nie udało się pobrać listy otrzymanych udostępnień",
+	},
+	SharerUsernameRequired: {
+		English: "sharer_username is required",
+		Polish:  "Wymagane jest sharer_username",
+	},
+	SharerNotFound: {
+		English: "Sharer not found",
+		Polish:  "Nie znaleziono udostępniającego",
+	},
+	SharedNodesListFailed: {
+		English: "Failed to list shared nodes",
+		Polish:  "Nie udało się pobrać listy udostępnionych elementów",
+	},
+	AccessCheckFailed: {
+		English: "Failed to check access permissions",
+		Polish:  "Nie udało się sprawdzić uprawnień dostępu",
+	},
+	SharedContentListFailed: {
+		English: "Failed to list shared nodes content",
+		Polish:  "Nie udało się pobrać zawartości udostępnionych elementów",
+	},
+	InvalidPermission: {
+		English: "permission must be 'read', 'write' or 'manage'",
+		Polish:  "permission musi mieć wartość 'read', 'write' lub 'manage'",
+	},
+	OutgoingSharesLookupFailed: {
+		English: "Failed to retrieve outgoing shares",
+		Polish:  "Nie udało się pobrać wysłanych udostępnień",
+	},
+	ShareStatsFailed: {
+		English: "Failed to compute share statistics",
+		Polish:  "Nie udało się obliczyć statystyk udostępnień",
+	},
+	ShareLookupFailed: {
+		English: "Failed to retrieve share information",
+		Polish:  "Nie udało się pobrać informacji o udostępnieniu",
+	},
+	ShareNotUpdatable: {
+		English: "Share not found or you do not have permission to update it",
+		Polish:  "Nie znaleziono udostępnienia lub nie masz uprawnień do jego zmiany",
+	},
+	ShareChangeRequiresManage: {
+		English: "Changing this share requires manage permission",
+		Polish:  "Zmiana tego udostępnienia wymaga uprawnienia manage",
+	},
+	PermissionAboveOwn: {
+		English: "Cannot grant a higher permission than your own",
+		Polish:  "Nie można nadać wyższego uprawnienia niż własne",
+	},
+	ShareUpdateFailed: {
+		English: "Failed to update share",
+		Polish:  "Nie udało się zmienić udostępnienia",
+	},
+	InvalidBefore: {
+		English: "Invalid 'before' parameter, must be a number",
+		Polish:  "Nieprawidłowy parametr 'before', musi być liczbą",
+	},
+	SharedNodeGone: {
+		English: "The shared node is no longer available",
+		Polish:  "Udostępniony element nie jest już dostępny",
+	},
+	ActivityLookupFailed: {
+		English: "Failed to retrieve activity",
+		Polish:  "Nie udało się pobrać aktywności",
+	},
+	ShareListRequiresManage: {
+		English: "Listing the shares of a node requires manage permission",
+		Polish:  "Wyświetlenie udostępnień elementu wymaga uprawnienia manage",
+	},
+	SharesLookupFailed: {
+		English: "Failed to retrieve shares",
+		Polish:  "Nie udało się pobrać udostępnień",
+	},
+	ShareNotFound: {
+		English: "Share not found",
+		Polish:  "Nie znaleziono udostępnienia",
+	},
+	InvalidShareIDFormat: {
+		English: "Invalid share ID format",
+		Polish:  "Nieprawidłowy format ID udostępnienia",
+	},
+	ShareDeleteFailed: {
+		English: "Failed to delete share",
+		Polish:  "Nie udało się usunąć udostępnienia",
+	},
+	ShareNotDeletable: {
+		English: "Share not found or you do not have permission to delete it",
+		Polish:  "Nie znaleziono udostępnienia lub nie masz uprawnień do jego usunięcia",
+	},
+	ShareNodeFailed: {
+		English: "Failed to share node",
+		Polish:  "Nie udało się udostępnić elementu",
+	},
+	ShareMessageTooLong: {
+		English: "Share message cannot be longer than %d characters",
+		Polish:  "Wiadomość udostępnienia nie może mieć więcej niż %d znaków",
+	},
+	VersionNotFound: {
+		English: "Version %d of this file does not exist",
+		Polish:  "Wersja %d tego pliku nie istnieje",
+	},
+	VersionGone: {
+		English: "Version %d of this file is no longer available",
+		Polish:  "Wersja %d tego pliku nie jest już dostępna",
+	},
+	AlreadySharedThroughFolder: {
+		English: "%s already has %s access to this node through the share of folder '%s' (share %d); sharing it again is only possible to grant a higher permission",
+		Polish:  "%s ma już dostęp %s do tego elementu przez udostępnienie folderu '%s' (udostępnienie %d); ponowne udostępnienie jest możliwe tylko z wyższym uprawnieniem",
+	},
+	ShareRevokeFailed: {
+		English: "Failed to remove share",
+		Polish:  "Nie udało się usunąć udostępnienia",
+	},
+	QuarantinedFile: {
+		English: "This file is quarantined by the content policy until an administrator releases it",
+		Polish:  "Ten plik jest w kwarantannie zasad treści, dopóki administrator go nie zwolni",
+	},
+	AlreadyShared: {
+		English: "this node is already shared with the recipient",
+		Polish:  "ten element jest już udostępniony temu odbiorcy",
+	},
+	NodeNotOwned: {
+		English: "Node not found or you are not the owner",
+		Polish:  "Nie znaleziono elementu lub nie jesteś jego właścicielem",
+	},
+	AccessLogLookupFailed: {
+		English: "Failed to retrieve access log",
+		Polish:  "Nie udało się pobrać dziennika dostępu",
+	},
+	ConfigReloadRejected: {
+		English: "The configuration was not reloaded: %v",
+		Polish:  "Konfiguracja nie została przeładowana: %v",
+	},
+	OwnerLookupFailed: {
+		English: "Failed to retrieve the owner",
+		Polish:  "Nie udało się pobrać właściciela",
+	},
+	OwnerNotFound: {
+		English: "Owner not found",
+		Polish:  "Nie znaleziono właściciela",
+	},
+	ParentLookupFailed: {
+		English: "Failed to retrieve the parent folder",
+		Polish:  "Nie udało się pobrać folderu nadrzędnego",
+	},
+	ParentNotFound: {
+		English: "Parent folder not found",
+		Polish:  "Nie znaleziono folderu nadrzędnego",
+	},
+	BulkCreateFailed: {
+		English: "Failed to create the nodes",
+		Polish:  "Nie udało się utworzyć elementów",
+	},
+	InvalidBulkFolders: {
+		English: "folders must be between 1 and %d",
+		Polish:  "folders musi mieścić się w przedziale od 1 do %d",
+	},
+	InvalidBulkFilesPerFolder: {
+		English: "files_per_folder must be between 0 and %d",
+		Polish:  "files_per_folder musi mieścić się w przedziale od 0 do %d",
+	},
+	TooManyBulkNodes: {
+		English: "A batch cannot create more than %d nodes",
+		Polish:  "Jedna partia nie może utworzyć więcej niż %d elementów",
+	},
+	OrphansListFailed: {
+		English: "Failed to list orphaned nodes",
+		Polish:  "Nie udało się pobrać listy osieroconych elementów",
+	},
+	RecoveryFolderExists: {
+		English: "Cannot create the recovery folder, a node with the same name already exists",
+		Polish:  "Nie można utworzyć folderu odzyskiwania, element o tej nazwie już istnieje",
+	},
+	OrphansRepairFailed: {
+		English: "Failed to repair orphaned nodes",
+		Polish:  "Nie udało się naprawić osieroconych elementów",
+	},
+	UserAndNodeRequired: {
+		English: "Both 'user' and 'node' parameters are required",
+		Polish:  "Wymagane są oba parametry 'user' i 'node'",
+	},
+	UserLookupFailed: {
+		English: "Failed to retrieve user",
+		Polish:  "Nie udało się pobrać użytkownika",
+	},
+	UserNotFound: {
+		English: "User not found",
+		Polish:  "Nie znaleziono użytkownika",
+	},
+	StatisticsLookupFailed: {
+		English: "Failed to retrieve statistics",
+		Polish:  "Nie udało się pobrać statystyk",
+	},
+	UsersListFailed: {
+		English: "Failed to list users",
+		Polish:  "Nie udało się pobrać listy użytkowników",
+	},
+	UsernameRequired: {
+		English: "Username is required",
+		Polish:  "Wymagana jest nazwa użytkownika",
+	},
+	PasswordTooShort: {
+		English: "Password must be at least 8 characters long",
+		Polish:  "Hasło musi mieć co najmniej 8 znaków",
+	},
+	NegativeStorageQuota: {
+		English: "storage_quota_bytes cannot be negative",
+		Polish:  "storage_quota_bytes nie może być ujemne",
+	},
+	PasswordHashFailed: {
+		English: "Failed to hash password",
+		Polish:  "Nie udało się zaszyfrować hasła",
+	},
+	UserCreateFailed: {
+		English: "Failed to create user",
+		Polish:  "Nie udało się utworzyć użytkownika",
+	},
+	UsernameTaken: {
+		English: "The username is already taken",
+		Polish:  "Ta nazwa użytkownika jest już zajęta",
+	},
+	InvalidUserID: {
+		English: "Invalid user ID",
+		Polish:  "Nieprawidłowe ID użytkownika",
+	},
+	DisableOwnAccount: {
+		English: "You cannot disable your own account",
+		Polish:  "Nie możesz wyłączyć własnego konta",
+	},
+	UserDataLookupFailed: {
+		English: "Failed to retrieve user data",
+		Polish:  "Nie udało się pobrać danych użytkownika",
+	},
+	DirectoryManagedAccount: {
+		English: "This account is managed by the directory",
+		Polish:  "To konto jest zarządzane przez katalog",
+	},
+	UserUpdateFailed: {
+		English: "Failed to update user",
+		Polish:  "Nie udało się zmienić użytkownika",
+	},
+	AnnouncementsListFailed: {
+		English: "Failed to list announcements",
+		Polish:  "Nie udało się pobrać listy ogłoszeń",
+	},
+	InvalidAnnouncementLevel: {
+		English: "The level must be 'info', 'warning' or 'critical'",
+		Polish:  "Poziom musi mieć wartość 'info', 'warning' lub 'critical'",
+	},
+	AnnouncementEndsBeforeStart: {
+		English: "'ends_at' must be later than the start",
+		Polish:  "'ends_at' musi być późniejsze niż początek",
+	},
+	AnnouncementCreateFailed: {
+		English: "Failed to create announcement",
+		Polish:  "Nie udało się utworzyć ogłoszenia",
+	},
+	InvalidAnnouncementID: {
+		English: "Invalid announcement ID",
+		Polish:  "Nieprawidłowe ID ogłoszenia",
+	},
+	AnnouncementRemoveFailed: {
+		English: "Failed to remove announcement",
+		Polish:  "Nie udało się usunąć ogłoszenia",
+	},
+	AnnouncementNotFound: {
+		English: "Announcement not found",
+		Polish:  "Nie znaleziono ogłoszenia",
+	},
+	InvalidAnnouncementLength: {
+		English: "The message must have between 1 and %d characters",
+		Polish:  "Wiadomość musi mieć od 1 do %d znaków",
+	},
+	InvalidArchiveFormat: {
+		English: "Archive format must be 'zip', 'tar' or 'tar.gz'",
+		Polish:  "Format archiwum musi mieć wartość 'zip', 'tar' lub 'tar.gz'",
+	},
+	ArchiveStageFailed: {
+		English: "Failed to stage the archive",
+		Polish:  "Nie udało się przyjąć archiwum",
+	},
+	ArchiveReceiveFailed: {
+		English: "Failed to receive the archive",
+		Polish:  "Nie udało się odebrać archiwum",
+	},
+	NotAnArchive: {
+		English: "Only ZIP, tar and tar.gz files can be extracted",
+		Polish:  "Rozpakować można tylko pliki ZIP, tar i tar.gz",
+	},
+	EmptyArchive: {
+		English: "The archive is empty",
+		Polish:  "Archiwum jest puste",
+	},
+	QuotaOwnerCheckFailed: {
+		English: "Could not verify owner for quota check",
+		Polish:  "Nie udało się sprawdzić właściciela na potrzeby limitu miejsca",
+	},
+	ImportQueueFailed: {
+		English: "Failed to queue the import",
+		Polish:  "Nie udało się zlecić importu",
+	},
+	InvalidImportID: {
+		English: "Invalid import ID",
+		Polish:  "Nieprawidłowe ID importu",
+	},
+	ArchiveImportLookupFailed: {
+		English: "Failed to retrieve archive import",
+		Polish:  "Nie udało się pobrać importu archiwum",
+	},
+	ArchiveImportNotFound: {
+		English: "Archive import not found",
+		Polish:  "Nie znaleziono importu archiwum",
+	},
+	ArchiveTooManyEntries: {
+		English: "the archive has too many entries: at most %d entries can be extracted",
+		Polish:  "archiwum ma zbyt wiele elementów: rozpakować można najwyżej %d elementów",
+	},
+	ArchiveExpandsTooMuch: {
+		English: "the archive expands to far more data than it holds and looks like a zip bomb",
+		Polish:  "archiwum rozpakowuje się do znacznie większej ilości danych, niż zawiera, i wygląda na bombę zip",
+	},
+	InvalidArchive: {
+		English: "Invalid archive: %s",
+		Polish:  "Nieprawidłowe archiwum: %s",
+	},
+	ServerConfigError: {
+		English: "Server configuration error",
+		Polish:  "Błąd konfiguracji serwera",
+	},
+	AccessTokenFailed: {
+		English: "Failed to generate access token",
+		Polish:  "Nie udało się wygenerować tokenu dostępu",
+	},
+	TokenGenerationFailed: {
+		English: "Internal server error (token generation)",
+		Polish:  "Wewnętrzny błąd serwera (generowanie tokenu)",
+	},
+	LoginSessionFailed: {
+		English: "Failed to process login session",
+		Polish:  "Nie udało się przetworzyć sesji logowania",
+	},
+	RefreshTokenRequired: {
+		English: "Refresh token is required",
+		Polish:  "Wymagany jest token odświeżania",
+	},
+	TokenRefreshFailed: {
+		English: "Failed to refresh token",
+		Polish:  "Nie udało się odświeżyć tokenu",
+	},
+	LogoutFailed: {
+		English: "Failed to log out",
+		Polish:  "Nie udało się wylogować",
+	},
+	InvalidRefreshToken: {
+		English: "invalid or expired refresh token",
+		Polish:  "nieprawidłowy lub wygasły token odświeżania",
+	},
+	BackendsRequired: {
+		English: "Both 'from_backend' and 'to_backend' are required",
+		Polish:  "Wymagane są oba pola 'from_backend' i 'to_backend'",
+	},
+	SameBackends: {
+		English: "The source and target backends must differ",
+		Polish:  "Magazyn źródłowy i docelowy muszą być różne",
+	},
+	MigrationQueueFailed: {
+		English: "Failed to queue blob migration",
+		Polish:  "Nie udało się zlecić migracji danych",
+	},
+	MigrationsListFailed: {
+		English: "Failed to list blob migrations",
+		Polish:  "Nie udało się pobrać listy migracji danych",
+	},
+	InvalidMigrationID: {
+		English: "Invalid migration ID",
+		Polish:  "Nieprawidłowe ID migracji",
+	},
+	MigrationLookupFailed: {
+		English: "Failed to retrieve blob migration",
+		Polish:  "Nie udało się pobrać migracji danych",
+	},
+	MigrationNotFound: {
+		English: "Blob migration not found",
+		Polish:  "Nie znaleziono migracji danych",
+	},
+	UnknownStorageBackend: {
+		English: "unknown storage backend %q",
+		Polish:  "nieznany magazyn danych %q",
+	},
+	ChecksumOnFolder: {
+		English: "Checksums are only available for files",
+		Polish:  "Sumy kontrolne są dostępne tylko dla plików",
+	},
+	ChecksumFailed: {
+		English: "Failed to compute file checksum",
+		Polish:  "Nie udało się obliczyć sumy kontrolnej pliku",
+	},
+	FolderLookupFailed: {
+		English: "Failed to retrieve folder",
+		Polish:  "Nie udało się pobrać folderu",
+	},
+	FolderNotOwned: {
+		English: "Folder not found or you are not its owner",
+		Polish:  "Nie znaleziono folderu lub nie jesteś jego właścicielem",
+	},
+	CleanupPolicyLookupFailed: {
+		English: "Failed to retrieve cleanup policy",
+		Polish:  "Nie udało się pobrać zasady czyszczenia",
+	},
+	CleanupPolicyNotFound: {
+		English: "This folder has no cleanup policy",
+		Polish:  "Ten folder nie ma zasady czyszczenia",
+	},
+	CleanupPolicyEmpty: {
+		English: "Provide a positive max_age_days, keep_newest or both",
+		Polish:  "Podaj dodatnie max_age_days, keep_newest lub oba",
+	},
+	CleanupPolicySaveFailed: {
+		English: "Failed to save cleanup policy",
+		Polish:  "Nie udało się zapisać zasady czyszczenia",
+	},
+	CleanupPolicyRemoveFailed: {
+		English: "Failed to remove cleanup policy",
+		Polish:  "Nie udało się usunąć zasady czyszczenia",
+	},
+	InvalidCleanupLimits: {
+		English: "Cleanup limits must be positive",
+		Polish:  "Limity czyszczenia muszą być dodatnie",
+	},
+	CleanupPreviewFailed: {
+		English: "Failed to preview cleanup",
+		Polish:  "Nie udało się przygotować podglądu czyszczenia",
+	},
+	FolderOnlySetting: {
+		English: "%s can only be set on folders",
+		Polish:  "%s można ustawić tylko dla folderów",
+	},
+	InvalidParameter: {
+		English: "Invalid %s",
+		Polish:  "Nieprawidłowy parametr %s",
+	},
+	InvalidClipboardOperation: {
+		English: "Operation must be 'cut' or 'copy'",
+		Polish:  "Operacja musi mieć wartość 'cut' lub 'copy'",
+	},
+	ClipboardSaveFailed: {
+		English: "Failed to save clipboard",
+		Polish:  "Nie udało się zapisać schowka",
+	},
+	ClipboardLookupFailed: {
+		English: "Failed to retrieve clipboard",
+		Polish:  "Nie udało się pobrać schowka",
+	},
+	ClipboardEmpty: {
+		English: "Clipboard is empty",
+		Polish:  "Schowek jest pusty",
+	},
+	ClipboardClearFailed: {
+		English: "Failed to clear clipboard",
+		Polish:  "Nie udało się wyczyścić schowka",
+	},
+	InvalidClipboardSize: {
+		English: "Provide between 1 and %d node IDs",
+		Polish:  "Podaj od 1 do %d identyfikatorów elementów",
+	},
+	ReplaceFolderContent: {
+		English: "Only file content can be replaced",
+		Polish:  "Zastąpić można tylko zawartość pliku",
+	},
+	ModifyPermissionDenied: {
+		English: "You do not have permission to modify this file",
+		Polish:  "Nie masz uprawnień do zmiany tego pliku",
+	},
+	FileModifiedSinceVersion: {
+		English: "File has been modified since the given version",
+		Polish:  "Plik został zmieniony od podanej wersji",
+	},
+	FileOwnerQuotaExceeded: {
+		English: "Storage quota for the owner of this file is exceeded",
+		Polish:  "Przekroczono limit miejsca właściciela tego pliku",
+	},
+	ContentStageFailed: {
+		English: "Failed to stage new content",
+		Polish:  "Nie udało się przyjąć nowej zawartości",
+	},
+	ContentStoreFailed: {
+		English: "Failed to store file content",
+		Polish:  "Nie udało się zapisać zawartości pliku",
+	},
+	ContentUpdateFailed: {
+		English: "Failed to update file content",
+		Polish:  "Nie udało się zmienić zawartości pliku",
+	},
+	SignatureOnFolder: {
+		English: "Signatures are only available for files",
+		Polish:  "Sygnatury są dostępne tylko dla plików",
+	},
+	SignatureFailed: {
+		English: "Failed to compute file signature",
+		Polish:  "Nie udało się obliczyć sygnatury pliku",
+	},
+	DeltaUnsupported: {
+		English: "Storage does not support delta updates",
+		Polish:  "Magazyn nie obsługuje aktualizacji różnicowych",
+	},
+	DeltaApplyFailed: {
+		English: "Failed to apply delta patch",
+		Polish:  "Nie udało się zastosować łatki różnicowej",
+	},
+	DeltaPatchRejected: {
+		English: "The delta patch cannot be applied: %v",
+		Polish:  "Nie można zastosować łatki różnicowej: %v",
+	},
+	InvalidBlockSize: {
+		English: "block_size must be between %d and %d",
+		Polish:  "block_size musi mieścić się w przedziale od %d do %d",
+	},
+	QuarantineListFailed: {
+		English: "Failed to list quarantined files",
+		Polish:  "Nie udało się pobrać listy plików w kwarantannie",
+	},
+	QuarantineReleaseFailed: {
+		English: "Failed to release the file",
+		Polish:  "Nie udało się zwolnić pliku",
+	},
+	FileNotQuarantined: {
+		English: "File is not quarantined",
+		Polish:  "Plik nie jest w kwarantannie",
+	},
+	InvalidSinceEvent: {
+		English: "Invalid 'since_event' parameter, must be a non-negative number",
+		Polish:  "Nieprawidłowy parametr 'since_event', musi być nieujemną liczbą",
+	},
+	FolderChangesFailed: {
+		English: "Failed to compute folder changes",
+		Polish:  "Nie udało się obliczyć zmian w folderze",
+	},
+	TooManyChanges: {
+		English: "Too many changes since the given cursor, please refetch the folder",
+		Polish:  "Zbyt wiele zmian od podanego kursora, pobierz folder ponownie",
+	},
+	InvalidDays: {
+		English: "days must be a number between 1 and 365",
+		Polish:  "days musi być liczbą od 1 do 365",
+	},
+	TransferStatsLookupFailed: {
+		English: "Failed to retrieve transfer stats",
+		Polish:  "Nie udało się pobrać statystyk transferu",
+	},
+	InvalidSince: {
+		English: "Invalid 'since' parameter, must be a number",
+		Polish:  "Nieprawidłowy parametr 'since', musi być liczbą",
+	},
+	EventsLookupFailed: {
+		English: "Failed to retrieve events",
+		Polish:  "Nie udało się pobrać zdarzeń",
+	},
+	InvalidSinceNonNegative: {
+		English: "Invalid 'since' parameter, must be a non-negative number",
+		Polish:  "Nieprawidłowy parametr 'since', musi być nieujemną liczbą",
+	},
+	FavoriteAddFailed: {
+		English: "Failed to add to favorites",
+		Polish:  "Nie udało się dodać do ulubionych",
+	},
+	FavoriteRemoveFailed: {
+		English: "Failed to remove from favorites",
+		Polish:  "Nie udało się usunąć z ulubionych",
+	},
+	FavoritesListFailed: {
+		English: "Failed to list favorites",
+		Polish:  "Nie udało się pobrać listy ulubionych",
+	},
+	AlreadyFavorite: {
+		English: "this node is already in favorites",
+		Polish:  "ten element jest już w ulubionych",
+	},
+	UnknownFeatureFlag: {
+		English: "Unknown feature flag",
+		Polish:  "Nieznana flaga funkcji",
+	},
+	FeaturesLookupFailed: {
+		English: "Failed to retrieve features",
+		Polish:  "Nie udało się pobrać funkcji",
+	},
+	FeatureFlagsListFailed: {
+		English: "Failed to list feature flags",
+		Polish:  "Nie udało się pobrać listy flag funkcji",
+	},
+	InvalidRolloutPercent: {
+		English: "rollout_percent must be between 0 and 100",
+		Polish:  "rollout_percent musi mieścić się w przedziale od 0 do 100",
+	},
+	FeatureFlagOverrideFailed: {
+		English: "Failed to override feature flag",
+		Polish:  "Nie udało się nadpisać flagi funkcji",
+	},
+	FeatureFlagOverrideRemoveFailed: {
+		English: "Failed to remove feature flag override",
+		Polish:  "Nie udało się usunąć nadpisania flagi funkcji",
+	},
+	FeatureFlagForceFailed: {
+		English: "Failed to force feature flag",
+		Polish:  "Nie udało się wymusić flagi funkcji",
+	},
+	FeatureFlagUpdateFailed: {
+		English: "Failed to update feature flag",
+		Polish:  "Nie udało się zmienić flagi funkcji",
+	},
+	FeatureFlagNotForced: {
+		English: "The feature flag is not forced for this user",
+		Polish:  "Flaga funkcji nie jest wymuszona dla tego użytkownika",
+	},
+	FeatureFlagLookupFailed: {
+		English: "Failed to retrieve feature flag",
+		Polish:  "Nie udało się pobrać flagi funkcji",
+	},
+	FederationDisabled: {
+		English: "Federation is disabled",
+		Polish:  "Federacja jest wyłączona",
+	},
+	UnknownInstance: {
+		English: "Unknown instance",
+		Polish:  "Nieznana instancja",
+	},
+	FederatedShareLookupFailed: {
+		English: "Failed to retrieve share",
+		Polish:  "Nie udało się pobrać udostępnienia",
+	},
+	InvalidShareOffer: {
+		English: "Invalid share offer",
+		Polish:  "Nieprawidłowa oferta udostępnienia",
+	},
+	RecipientNotFound: {
+		English: "Recipient not found",
+		Polish:  "Nie znaleziono odbiorcy",
+	},
+	ShareRecordFailed: {
+		English: "Failed to record share",
+		Polish:  "Nie udało się zapisać udostępnienia",
+	},
+	ShareTokenTaken: {
+		English: "The token is used by another share",
+		Polish:  "Token jest używany przez inne udostępnienie",
+	},
+	ShareNodeNotFound: {
+		English: "Node not found in this share",
+		Polish:  "Nie znaleziono elementu w tym udostępnieniu",
+	},
+	NotAFolder: {
+		English: "Not a folder",
+		Polish:  "To nie jest folder",
+	},
+	FolderListFailed: {
+		English: "Failed to list folder",
+		Polish:  "Nie udało się wylistować folderu",
+	},
+	NotAFile: {
+		English: "Not a file",
+		Polish:  "To nie jest plik",
+	},
+	SharedContentCheckFailed: {
+		English: "Failed to check the shared content",
+		Polish:  "Nie udało się sprawdzić udostępnianej zawartości",
+	},
+	ShareTokenFailed: {
+		English: "Failed to generate share token",
+		Polish:  "Nie udało się wygenerować tokenu udostępnienia",
+	},
+	ShareCreateFailed: {
+		English: "Failed to create share",
+		Polish:  "Nie udało się utworzyć udostępnienia",
+	},
+	SharesListFailed: {
+		English: "Failed to list shares",
+		Polish:  "Nie udało się pobrać listy udostępnień",
+	},
+	InvalidShareID: {
+		English: "Invalid share ID",
+		Polish:  "Nieprawidłowe ID udostępnienia",
+	},
+	PeerNotFound: {
+		English: "Not found on instance %s",
+		Polish:  "Nie znaleziono na instancji %s",
+	},
+	PeerUnreachable: {
+		English: "Instance %s could not be reached",
+		Polish:  "Nie udało się połączyć z instancją %s",
+	},
+	UnknownInstanceName: {
+		English: "Unknown instance %s",
+		Polish:  "Nieznana instancja %s",
+	},
+	AlreadySharedWith: {
+		English: "This node is already shared with %s",
+		Polish:  "Ten element jest już udostępniony dla %s",
+	},
+	PeerNoLongerTrusted: {
+		English: "Instance %s is no longer trusted",
+		Polish:  "Instancja %s nie jest już zaufana",
+	},
+	FederationRequestRejected: {
+		English: "Federation request rejected: %v",
+		Polish:  "Odrzucono żądanie federacji: %v",
+	},
+	InvalidFederatedAddress: {
+		English: "Invalid recipient address: %v",
+		Polish:  "Nieprawidłowy adres odbiorcy: %v",
+	},
+	CompareIDsRequired: {
+		English: "Both 'left_id' and 'right_id' are required",
+		Polish:  "Wymagane są oba parametry 'left_id' i 'right_id'",
+	},
+	CompareFailed: {
+		English: "Failed to compare folders",
+		Polish:  "Nie udało się porównać folderów",
+	},
+	InvalidCompareLimit: {
+		English: "'limit' must be between 1 and %d",
+		Polish:  "'limit' musi mieścić się w przedziale od 1 do %d",
+	},
+	FolderTooLargeToCompare: {
+		English: "Folder %s has more than %d items to compare",
+		Polish:  "Folder %s ma więcej niż %d elementów do porównania",
+	},
+	InvalidGroupIDFormat: {
+		English: "Invalid group ID format",
+		Polish:  "Nieprawidłowy format ID grupy",
+	},
+	GroupLookupFailed: {
+		English: "Failed to retrieve group",
+		Polish:  "Nie udało się pobrać grupy",
+	},
+	GroupNotFound: {
+		English: "Group not found",
+		Polish:  "Nie znaleziono grupy",
+	},
+	GroupOwnerRequired: {
+		English: "Only the group owner can do this",
+		Polish:  "Tylko właściciel grupy może to zrobić",
+	},
+	GroupCreateFailed: {
+		English: "Failed to create group",
+		Polish:  "Nie udało się utworzyć grupy",
+	},
+	GroupsListFailed: {
+		English: "Failed to list groups",
+		Polish:  "Nie udało się pobrać listy grup",
+	},
+	GroupMembersListFailed: {
+		English: "Failed to list group members",
+		Polish:  "Nie udało się pobrać listy członków grupy",
+	},
+	GroupRenameFailed: {
+		English: "Failed to rename group",
+		Polish:  "Nie udało się zmienić nazwy grupy",
+	},
+	GroupDeleteFailed: {
+		English: "Failed to delete group",
+		Polish:  "Nie udało się usunąć grupy",
+	},
+	UserFindFailed: {
+		English: "Internal server error while finding user",
+		Polish:  "Wewnętrzny błąd serwera podczas wyszukiwania użytkownika",
+	},
+	GroupMemberAddFailed: {
+		English: "Failed to add group member",
+		Polish:  "Nie udało się dodać członka grupy",
+	},
+	AlreadyGroupMember: {
+		English: "The user already is a member of this group",
+		Polish:  "Użytkownik jest już członkiem tej grupy",
+	},
+	InvalidUserIDFormat: {
+		English: "Invalid user ID format",
+		Polish:  "Nieprawidłowy format ID użytkownika",
+	},
+	GroupOwnerCannotLeave: {
+		English: "The owner cannot leave the group; delete it instead",
+		Polish:  "Właściciel nie może opuścić grupy; zamiast tego usuń grupę",
+	},
+	DirectoryManagedGroup: {
+		English: "This group is managed by the directory",
+		Polish:  "Ta grupa jest zarządzana przez katalog",
+	},
+	GroupMemberRemoveFailed: {
+		English: "Failed to remove group member",
+		Polish:  "Nie udało się usunąć członka grupy",
+	},
+	NotGroupMember: {
+		English: "The user is not a member of this group",
+		Polish:  "Użytkownik nie jest członkiem tej grupy",
+	},
+	GroupSharesListFailed: {
+		English: "Failed to list group shares",
+		Polish:  "Nie udało się pobrać listy udostępnień grupy",
+	},
+	AlreadySharedWithGroup: {
+		English: "This node is already shared with the group",
+		Polish:  "Ten element jest już udostępniony tej grupie",
+	},
+	GroupNameRequired: {
+		English: "Group name cannot be empty",
+		Polish:  "Nazwa grupy nie może być pusta",
+	},
+	GroupNameTooLong: {
+		English: "Group name cannot be longer than %d characters",
+		Polish:  "Nazwa grupy nie może mieć więcej niż %d znaków",
+	},
+	GroupExists: {
+		English: "you already have a group with this name",
+		Polish:  "masz już grupę o tej nazwie",
+	},
+	InvalidFileNameLength: {
+		English: "File name must be between 1 and 255 characters",
+		Polish:  "Nazwa pliku musi mieć od 1 do 255 znaków",
+	},
+	NonPositiveSize: {
+		English: "size_bytes must be positive",
+		Polish:  "size_bytes musi być dodatnie",
+	},
+	InvalidSHA256: {
+		English: "sha256 must be a hex SHA-256 checksum",
+		Polish:  "sha256 musi być sumą kontrolną SHA-256 w zapisie szesnastkowym",
+	},
+	StoredContentCheckFailed: {
+		English: "Failed to check for stored content",
+		Polish:  "Nie udało się sprawdzić zapisanej zawartości",
+	},
+	FileCreateFailed: {
+		English: "Failed to create file",
+		Polish:  "Nie udało się utworzyć pliku",
+	},
+	DuplicateNodeName: {
+		English: "A node with the same name already exists in this location",
+		Polish:  "Element o tej nazwie już istnieje w tym miejscu",
+	},
+	DuplicateNameInFolder: {
+		English: "a node with the same name already exists in this folder",
+		Polish:  "element o tej nazwie już istnieje w tym folderze",
+	},
+	InvalidExportID: {
+		English: "Invalid export ID",
+		Polish:  "Nieprawidłowe ID eksportu",
+	},
+	LegalExportLookupFailed: {
+		English: "Failed to retrieve legal export",
+		Polish:  "Nie udało się pobrać eksportu prawnego",
+	},
+	LegalExportNotFound: {
+		English: "Legal export not found",
+		Polish:  "Nie znaleziono eksportu prawnego",
+	},
+	NodeAndReasonRequired: {
+		English: "Both 'node_id' and 'reason' are required",
+		Polish:  "Wymagane są oba pola 'node_id' i 'reason'",
+	},
+	LegalExportQueueFailed: {
+		English: "Failed to queue legal export",
+		Polish:  "Nie udało się zlecić eksportu prawnego",
+	},
+	NodeNotFound: {
+		English: "Node not found",
+		Polish:  "Nie znaleziono elementu",
+	},
+	LegalExportsListFailed: {
+		English: "Failed to list legal exports",
+		Polish:  "Nie udało się pobrać listy eksportów prawnych",
+	},
+	ExportNotCompleted: {
+		English: "The export is not completed",
+		Polish:  "Eksport nie został ukończony",
+	},
+	ExportBundleOpenFailed: {
+		English: "Failed to open export bundle",
+		Polish:  "Nie udało się otworzyć paczki eksportu",
+	},
+	FolderLimitsCheckFailed: {
+		English: "Failed to verify folder limits",
+		Polish:  "Nie udało się sprawdzić limitów folderu",
+	},
+	FolderDepthExceeded: {
+		English: "folder depth limit exceeded: folders can be nested at most %d levels deep",
+		Polish:  "przekroczono limit zagnieżdżenia: foldery można zagnieżdżać najwyżej na %d poziomach",
+	},
+	FolderChildrenExceeded: {
+		English: "folder children limit exceeded: a folder can contain at most %d items",
+		Polish:  "przekroczono limit zawartości: folder może zawierać najwyżej %d elementów",
+	},
+	ResponseEncodeFailed: {
+		English: "Failed to encode response",
+		Polish:  "Nie udało się zakodować odpowiedzi",
+	},
+	EmptyFolderName: {
+		English: "Folder name cannot be empty",
+		Polish:  "Nazwa folderu nie może być pusta",
+	},
+	ParentFolderMissing: {
+		English: "Parent folder does not exist",
+		Polish:  "Folder nadrzędny nie istnieje",
+	},
+	DuplicateFolderName: {
+		English: "A folder with the same name already exists in this location",
+		Polish:  "Folder o tej nazwie już istnieje w tym miejscu",
+	},
+	FolderCreateFailed: {
+		English: "Failed to create folder",
+		Polish:  "Nie udało się utworzyć folderu",
+	},
+	NodesListFailed: {
+		English: "Failed to list nodes",
+		Polish:  "Nie udało się pobrać listy elementów",
+	},
+	NoFilesUploaded: {
+		English: "No files uploaded",
+		Polish:  "Nie przesłano żadnych plików",
+	},
+	NoFilesProcessed: {
+		English: "None of the files could be processed",
+		Polish:  "Nie udało się przetworzyć żadnego z plików",
+	},
+	DownloadFolder: {
+		English: "Cannot download a folder",
+		Polish:  "Nie można pobrać folderu",
+	},
+	SharedVersionGone: {
+		English: "The shared version of this file is no longer available",
+		Polish:  "Udostępniona wersja tego pliku nie jest już dostępna",
+	},
+	PreviewUnsupported: {
+		English: "This file type cannot be previewed, download it instead",
+		Polish:  "Tego typu pliku nie można wyświetlić, pobierz go",
+	},
+	RangeNotSatisfiable: {
+		English: "Requested range not satisfiable",
+		Polish:  "Żądanego zakresu nie można obsłużyć",
+	},
+	DeleteLookupFailed: {
+		English: "Failed to retrieve node to delete",
+		Polish:  "Nie udało się pobrać elementu do usunięcia",
+	},
+	DeletePermissionDenied: {
+		English: "You do not have permission to delete items in this folder",
+		Polish:  "Nie masz uprawnień do usuwania elementów w tym folderze",
+	},
+	NodeDeleteFailed: {
+		English: "Failed to delete node",
+		Polish:  "Nie udało się usunąć elementu",
+	},
+	RenamePermissionCheckFailed: {
+		English: "Failed to verify permissions for renaming",
+		Polish:  "Nie udało się sprawdzić uprawnień do zmiany nazwy",
+	},
+	RenamePermissionDenied: {
+		English: "You do not have permission to rename items in this folder",
+		Polish:  "Nie masz uprawnień do zmiany nazw elementów w tym folderze",
+	},
+	EmptyName: {
+		English: "Name cannot be empty",
+		Polish:  "Nazwa nie może być pusta",
+	},
+	NodeRenameFailed: {
+		English: "Failed to rename node",
+		Polish:  "Nie udało się zmienić nazwy elementu",
+	},
+	NodeMoveFailed: {
+		English: "Failed to move node",
+		Polish:  "Nie udało się przenieść elementu",
+	},
+	NodeCopyFailed: {
+		English: "Failed to copy node",
+		Polish:  "Nie udało się skopiować elementu",
+	},
+	TargetFolderNotAccessible: {
+		English: "Target folder not found or access denied",
+		Polish:  "Nie znaleziono folderu docelowego lub odmowa dostępu",
+	},
+	TargetFolderMissing: {
+		English: "Target folder does not exist",
+		Polish:  "Folder docelowy nie istnieje",
+	},
+	CrossOwnerMove: {
+		English: "Moving files between different owners is not allowed. Please copy the file instead.",
+		Polish:  "Przenoszenie plików między różnymi właścicielami jest niedozwolone. Skopiuj plik.",
+	},
+	MovePermissionDenied: {
+		English: "You do not have permission to move this item",
+		Polish:  "Nie masz uprawnień do przeniesienia tego elementu",
+	},
+	MoveIntoPermissionDenied: {
+		English: "You do not have permission to move items into the target folder",
+		Polish:  "Nie masz uprawnień do przenoszenia elementów do folderu docelowego",
+	},
+	CopyIntoPermissionDenied: {
+		English: "You do not have permission to copy items into the target folder",
+		Polish:  "Nie masz uprawnień do kopiowania elementów do folderu docelowego",
+	},
+	MoveIntoItself: {
+		English: "Cannot move a folder into itself or one of its subfolders",
+		Polish:  "Nie można przenieść folderu do niego samego ani do jego podfolderu",
+	},
+	CopyIntoItself: {
+		English: "Cannot copy a folder into itself or one of its subfolders",
+		Polish:  "Nie można skopiować folderu do niego samego ani do jego podfolderu",
+	},
+	DuplicateNameInTarget: {
+		English: "A node with the same name already exists in the target folder",
+		Polish:  "Element o tej nazwie już istnieje w folderze docelowym",
+	},
+	TargetQuotaExceeded: {
+		English: "Storage quota for the owner of the target folder is exceeded",
+		Polish:  "Przekroczono limit miejsca właściciela folderu docelowego",
+	},
+	NoUpdateSpecified: {
+		English: "No update operation specified (provide 'name' or 'parent_id')",
+		Polish:  "Nie podano zmiany (podaj 'name' lub 'parent_id')",
+	},
+	NodeIDsRequired: {
+		English: "Node IDs are required",
+		Polish:  "Wymagane są identyfikatory elementów",
+	},
+	InvalidDownloadFormat: {
+		English: "format must be zip, tar or tar.gz",
+		Polish:  "format musi mieć wartość zip, tar lub tar.gz",
+	},
+	ArchiveMeasureFailed: {
+		English: "Failed to measure the archive",
+		Polish:  "Nie udało się obliczyć rozmiaru archiwum",
+	},
+	ArchiveCreateFailed: {
+		English: "Failed to create the archive",
+		Polish:  "Nie udało się utworzyć archiwum",
+	},
+	ArchiveTooLarge: {
+		English: "The archive would contain %d entries and %d bytes; at most %d entries and %d bytes are allowed",
+		Polish:  "Archiwum zawierałoby %d elementów i %d bajtów; dozwolone jest najwyżej %d elementów i %d bajtów",
+	},
+	SingleFileChecksumHeader: {
+		English: "%s applies to a single file; send it with each part instead",
+		Polish:  "%s dotyczy pojedynczego pliku; wyślij go z każdą częścią osobno",
+	},
+	OfflinePinFailed: {
+		English: "Failed to keep the node offline",
+		Polish:  "Nie udało się zachować elementu offline",
+	},
+	OfflineUnpinFailed: {
+		English: "Failed to stop keeping the node offline",
+		Polish:  "Nie udało się przestać przechowywać elementu offline",
+	},
+	NotPinnedOffline: {
+		English: "Node is not kept offline on this device",
+		Polish:  "Element nie jest przechowywany offline na tym urządzeniu",
+	},
+	OfflinePinsListFailed: {
+		English: "Failed to list nodes kept offline",
+		Polish:  "Nie udało się pobrać listy elementów przechowywanych offline",
+	},
+	DeviceUnknown: {
+		English: "The device could not be determined; send the %s header",
+		Polish:  "Nie udało się ustalić urządzenia; wyślij nagłówek %s",
+	},
+	DeviceIDTooLong: {
+		English: "%s must be at most 64 characters long",
+		Polish:  "%s może mieć najwyżej 64 znaki",
+	},
+	OnboardingListFailed: {
+		English: "Failed to list onboarding templates",
+		Polish:  "Nie udało się pobrać listy szablonów startowych",
+	},
+	OnboardingSaveFailed: {
+		English: "Failed to save onboarding templates",
+		Polish:  "Nie udało się zapisać szablonów startowych",
+	},
+	TooManyOnboardingTemplates: {
+		English: "At most %d templates are allowed",
+		Polish:  "Dozwolonych jest najwyżej %d szablonów",
+	},
+	NodeNotAmongYourFiles: {
+		English: "Node %s not found among your files",
+		Polish:  "Nie znaleziono elementu %s wśród Twoich plików",
+	},
+	NegativeSize: {
+		English: "size_bytes cannot be negative",
+		Polish:  "size_bytes nie może być ujemne",
+	},
+	FileNameTooLong: {
+		English: "File name must be at most 255 characters",
+		Polish:  "Nazwa pliku może mieć najwyżej 255 znaków",
+	},
+	MoveNodeRequired: {
+		English: "node_id is required to check a move",
+		Polish:  "Do sprawdzenia przeniesienia wymagane jest node_id",
+	},
+	InvalidPreflightOperation: {
+		English: "operation must be upload or move",
+		Polish:  "operation musi mieć wartość upload lub move",
+	},
+	PreflightFailed: {
+		English: "Failed to check the operation",
+		Polish:  "Nie udało się sprawdzić operacji",
+	},
+	ContentMissing: {
+		English: "File content is missing from storage",
+		Polish:  "Brak zawartości pliku w magazynie",
+	},
+	InvalidRuleID: {
+		English: "Invalid rule ID",
+		Polish:  "Nieprawidłowe ID reguły",
+	},
+	RulesListFailed: {
+		English: "Failed to list rules",
+		Polish:  "Nie udało się pobrać listy reguł",
+	},
+	RuleCreateFailed: {
+		English: "Failed to create rule",
+		Polish:  "Nie udało się utworzyć reguły",
+	},
+	RuleDeleteFailed: {
+		English: "Failed to delete rule",
+		Polish:  "Nie udało się usunąć reguły",
+	},
+	RuleNotFound: {
+		English: "Rule not found",
+		Polish:  "Nie znaleziono reguły",
+	},
+	RuleEvaluateFailed: {
+		English: "Failed to evaluate rule",
+		Polish:  "Nie udało się ocenić reguły",
+	},
+	RuleLookupFailed: {
+		English: "Failed to retrieve rule",
+		Polish:  "Nie udało się pobrać reguły",
+	},
+	RuleRunFailed: {
+		English: "Failed to run rule",
+		Polish:  "Nie udało się uruchomić reguły",
+	},
+	TagsListFailed: {
+		English: "Failed to list tags",
+		Polish:  "Nie udało się pobrać listy tagów",
+	},
+	RuleValidateFailed: {
+		English: "Failed to validate rule",
+		Polish:  "Nie udało się sprawdzić reguły",
+	},
+	EmptyRuleName: {
+		English: "Rule name cannot be empty",
+		Polish:  "Nazwa reguły nie może być pusta",
+	},
+	EmptyNamePattern: {
+		English: "Name pattern cannot be empty",
+		Polish:  "Wzorzec nazwy nie może być pusty",
+	},
+	InvalidNamePattern: {
+		English: "Invalid name pattern",
+		Polish:  "Nieprawidłowy wzorzec nazwy",
+	},
+	RuleFolderNotFound: {
+		English: "Folder %s not found",
+		Polish:  "Nie znaleziono folderu %s",
+	},
+	RuleSameFolders: {
+		English: "Target folder must differ from the source folder",
+		Polish:  "Folder docelowy musi być inny niż folder źródłowy",
+	},
+	InvalidTagLength: {
+		English: "Tags must be between 1 and %d characters long",
+		Polish:  "Tagi muszą mieć od 1 do %d znaków",
+	},
+	TooManyRuleTags: {
+		English: "A rule can add at most %d tags",
+		Polish:  "Reguła może dodać najwyżej %d tagów",
+	},
+	RuleWithoutAction: {
+		English: "A rule needs a target folder or at least one tag",
+		Polish:  "Reguła wymaga folderu docelowego lub co najmniej jednego tagu",
+	},
+	SessionsLookupFailed: {
+		English: "Failed to retrieve sessions",
+		Polish:  "Nie udało się pobrać sesji",
+	},
+	InvalidSessionIDFormat: {
+		English: "Invalid session ID format",
+		Polish:  "Nieprawidłowy format ID sesji",
+	},
+	SessionDeleteFailed: {
+		English: "Failed to delete session",
+		Polish:  "Nie udało się usunąć sesji",
+	},
+	SessionsTerminateFailed: {
+		English: "Failed to terminate all sessions",
+		Polish:  "Nie udało się zakończyć wszystkich sesji",
+	},
+	InvalidShareIDsCount: {
+		English: "share_ids must list between 1 and 1000 shares",
+		Polish:  "share_ids musi zawierać od 1 do 1000 udostępnień",
+	},
+	SharesRevokeFailed: {
+		English: "Failed to revoke shares",
+		Polish:  "Nie udało się odwołać udostępnień",
+	},
+	StorageBreakdownFailed: {
+		English: "Failed to retrieve storage breakdown",
+		Polish:  "Nie udało się pobrać podziału zajętego miejsca",
+	},
+	SnapshotCreateFailed: {
+		English: "Failed to create snapshot",
+		Polish:  "Nie udało się utworzyć migawki",
+	},
+	InvalidThumbnailBatch: {
+		English: "node_ids must list between 1 and %d files",
+		Polish:  "node_ids musi zawierać od 1 do %d plików",
+	},
+	InvalidThumbnailSize: {
+		English: "size must be one of 64, 128, 256 or 512",
+		Polish:  "size musi mieć wartość 64, 128, 256 lub 512",
+	},
+	NoThumbnail: {
+		English: "no thumbnail can be made of this file",
+		Polish:  "nie można utworzyć miniatury tego pliku",
+	},
+	TranscriptionNotConfigured: {
+		English: "Transcription is not configured on this server",
+		Polish:  "Transkrypcja nie jest skonfigurowana na tym serwerze",
+	},
+	InvalidTranscriptFormat: {
+		English: "Format must be 'vtt' or 'txt'",
+		Polish:  "Format musi mieć wartość 'vtt' lub 'txt'",
+	},
+	NotTranscribable: {
+		English: "Only audio and video files can be transcribed",
+		Polish:  "Transkrypcja jest możliwa tylko dla plików audio i wideo",
+	},
+	TranscriptionQueueFailed: {
+		English: "Failed to queue transcription",
+		Polish:  "Nie udało się zlecić transkrypcji",
+	},
+	InvalidJobID: {
+		English: "Invalid job ID",
+		Polish:  "Nieprawidłowe ID zadania",
+	},
+	TranscriptionLookupFailed: {
+		English: "Failed to retrieve transcription",
+		Polish:  "Nie udało się pobrać transkrypcji",
+	},
+	TranscriptionNotFound: {
+		English: "Transcription not found",
+		Polish:  "Nie znaleziono transkrypcji",
+	},
+	TranscriptionTooLarge: {
+		English: "Files larger than %d MB cannot be transcribed",
+		Polish:  "Pliki większe niż %d MB nie mogą być transkrybowane",
+	},
+	TrashPurgeFailed: {
+		English: "Failed to purge trash",
+		Polish:  "Nie udało się opróżnić kosza",
+	},
+	InvalidBatchIDFormat: {
+		English: "Invalid batch ID format",
+		Polish:  "Nieprawidłowy format ID partii",
+	},
+	TrashListFailed: {
+		English: "Failed to list trash contents",
+		Polish:  "Nie udało się pobrać zawartości kosza",
+	},
+	TrashSummaryFailed: {
+		English: "Failed to retrieve trash summary",
+		Polish:  "Nie udało się pobrać podsumowania kosza",
+	},
+	NodeNotInTrash: {
+		English: "Node not found in trash...",
+		Polish:  "Nie znaleziono elementu w koszu...",
+	},
+	RestoreNameTaken: {
+		English: "Cannot restore: a node with the same name already exists...",
+		Polish:  "Nie można przywrócić: element o tej nazwie już istnieje...",
+	},
+	NodeRestoreFailed: {
+		English: "Failed to restore node",
+		Polish:  "Nie udało się przywrócić elementu",
+	},
+	BatchNotInTrash: {
+		English: "Deletion batch not found in trash",
+		Polish:  "Nie znaleziono partii usunięć w koszu",
+	},
+	RestoreNameTakenInOrigin: {
+		English: "Cannot restore: a node with the same name already exists in the original location",
+		Polish:  "Nie można przywrócić: element o tej nazwie już istnieje w pierwotnym miejscu",
+	},
+	BatchRestoreFailed: {
+		English: "Failed to restore deletion batch",
+		Polish:  "Nie udało się przywrócić partii usunięć",
+	},
+	UndoTokenNotFound: {
+		English: "Undo token not found or expired",
+		Polish:  "Nie znaleziono tokenu cofania lub wygasł",
+	},
+	UndoNameTaken: {
+		English: "Cannot undo: a node with the same name already exists in the original location",
+		Polish:  "Nie można cofnąć: element o tej nazwie już istnieje w pierwotnym miejscu",
+	},
+	UndoFailed: {
+		English: "Failed to undo operation",
+		Polish:  "Nie udało się cofnąć operacji",
+	},
+	UndoConflict: {
+		English: "the node has changed since the operation and it can no longer be undone",
+		Polish:  "element zmienił się od czasu operacji i nie można jej już cofnąć",
+	},
+	UndoForbidden: {
+		English: "you no longer have permission to restore this item",
+		Polish:  "nie masz już uprawnień do przywrócenia tego elementu",
+	},
+	InvalidUploadID: {
+		English: "Invalid upload ID",
+		Polish:  "Nieprawidłowe ID przesyłania",
+	},
+	UploadSessionLookupFailed: {
+		English: "Failed to retrieve upload session",
+		Polish:  "Nie udało się pobrać sesji przesyłania",
+	},
+	UploadSessionNotFound: {
+		English: "Upload session not found or expired",
+		Polish:  "Nie znaleziono sesji przesyłania lub wygasła",
+	},
+	NonPositiveTotalSize: {
+		English: "total_size must be positive",
+		Polish:  "total_size musi być dodatnie",
+	},
+	UploadStartFailed: {
+		English: "Failed to start upload",
+		Polish:  "Nie udało się rozpocząć przesyłania",
+	},
+	InvalidUploadOffset: {
+		English: "Upload-Offset must be an offset within the file",
+		Polish:  "Upload-Offset musi wskazywać miejsce w pliku",
+	},
+	InvalidChunkSHA256: {
+		English: "X-Chunk-SHA256 must be a hex SHA-256 checksum",
+		Polish:  "X-Chunk-SHA256 musi być sumą kontrolną SHA-256 w zapisie szesnastkowym",
+	},
+	ChunkPastEnd: {
+		English: "Chunk extends past the end of the file",
+		Polish:  "Fragment wykracza poza koniec pliku",
+	},
+	ChunkStoreFailed: {
+		English: "Failed to store chunk",
+		Polish:  "Nie udało się zapisać fragmentu",
+	},
+	EmptyChunk: {
+		English: "Chunk is empty",
+		Polish:  "Fragment jest pusty",
+	},
+	UploadAssembleFailed: {
+		English: "Failed to assemble upload",
+		Polish:  "Nie udało się złożyć przesłanego pliku",
+	},
+	UploadCancelFailed: {
+		English: "Failed to cancel upload",
+		Polish:  "Nie udało się anulować przesyłania",
+	},
+	ChunkOverlap: {
+		English: "Chunk overlaps the chunk received at offset %d",
+		Polish:  "Fragment nakłada się na fragment otrzymany od pozycji %d",
+	},
+	ChecksumMismatchHeader: {
+		English: "%s differs from the checksum the upload session was created with",
+		Polish:  "%s różni się od sumy kontrolnej podanej przy tworzeniu sesji przesyłania",
+	},
+	UploadIncomplete: {
+		English: "Upload is incomplete: %d of %d bytes received",
+		Polish:  "Przesyłanie jest niepełne: otrzymano %d z %d bajtów",
+	},
+	InvalidImportURL: {
+		English: "url must be an absolute http or https URL",
+		Polish:  "url musi być pełnym adresem http lub https",
+	},
+	InvalidImportFileName: {
+		English: "file_name must be a valid file name of at most 255 characters",
+		Polish:  "file_name musi być poprawną nazwą pliku o długości najwyżej 255 znaków",
+	},
+	URLImportLookupFailed: {
+		English: "Failed to retrieve URL import",
+		Polish:  "Nie udało się pobrać importu z adresu",
+	},
+	URLImportNotFound: {
+		English: "URL import not found",
+		Polish:  "Nie znaleziono importu z adresu",
+	},
+	TokenUserLookupFailed: {
+		English: "Could not retrieve user from token",
+		Polish:  "Nie udało się odczytać użytkownika z tokenu",
+	},
+	VersionUsageLookupFailed: {
+		English: "Failed to retrieve version storage usage",
+		Polish:  "Nie udało się pobrać miejsca zajętego przez wersje",
+	},
+	VersionPolicyLookupFailed: {
+		English: "Failed to retrieve version policy",
+		Polish:  "Nie udało się pobrać zasad wersji",
+	},
+	UserFindError: {
+		English: "Could not find user",
+		Polish:  "Nie udało się znaleźć użytkownika",
+	},
+	DirectoryManagedPassword: {
+		English: "The password of this account is managed by the directory",
+		Polish:  "Hasło tego konta jest zarządzane przez katalog",
+	},
+	OldPasswordMismatch: {
+		English: "Old password does not match",
+		Polish:  "Stare hasło jest nieprawidłowe",
+	},
+	NewPasswordHashFailed: {
+		English: "Failed to hash new password",
+		Polish:  "Nie udało się zaszyfrować nowego hasła",
+	},
+	PasswordUpdateFailed: {
+		English: "Failed to update password",
+		Polish:  "Nie udało się zmienić hasła",
+	},
+	VersionsOnFolder: {
+		English: "Versions are only available for files",
+		Polish:  "Wersje są dostępne tylko dla plików",
+	},
+	VersionsListFailed: {
+		English: "Failed to list file versions",
+		Polish:  "Nie udało się pobrać listy wersji pliku",
+	},
+	InvalidVersionRange: {
+		English: "from and to must be positive version numbers",
+		Polish:  "from i to muszą być dodatnimi numerami wersji",
+	},
+	VersionReadFailed: {
+		English: "Failed to read file version",
+		Polish:  "Nie udało się odczytać wersji pliku",
+	},
+	InvalidVersionPolicy: {
+		English: "Version policy limits must be positive",
+		Polish:  "Limity zasad wersji muszą być dodatnie",
+	},
+	VersionPolicySaveFailed: {
+		English: "Failed to save version policy",
+		Polish:  "Nie udało się zapisać zasad wersji",
+	},
+	VersionNotText: {
+		English: "Version %d of this file is not a text file",
+		Polish:  "Wersja %d tego pliku nie jest plikiem tekstowym",
+	},
+	VersionTooLargeToDiff: {
+		English: "Version %d of this file is too large to diff",
+		Polish:  "Wersja %d tego pliku jest zbyt duża, by ją porównać",
+	},
+	WatchFailed: {
+		English: "Failed to watch folder",
+		Polish:  "Nie udało się obserwować folderu",
+	},
+	UnwatchFailed: {
+		English: "Failed to stop watching folder",
+		Polish:  "Nie udało się przestać obserwować folderu",
+	},
+	FolderNotWatched: {
+		English: "Folder is not watched",
+		Polish:  "Folder nie jest obserwowany",
+	},
+	InvalidDigestFrequency: {
+		English: "digest frequency must be 'daily' or 'weekly'",
+		Polish:  "częstotliwość podsumowań musi mieć wartość 'daily' lub 'weekly'",
+	},
+}
+
+// Message returns the message for code in lang, falling back to English and
+// finally to the code itself.
+func Message(code, lang string) string {
+	translations, ok := messages[code]
+	if !ok {
+		return code
+	}
+	if message, ok := translations[lang]; ok {
+		return message
+	}
+	return translations[DefaultLanguage]
+}
+
+// Messagef formats the message for code in lang with args, which fill the
+// verbs of the message the same way in every language.
+func Messagef(code, lang string, args ...any) string {
+	return fmt.Sprintf(Message(code, lang), args...)
+}
+
+// Negotiate picks the supported language the client prefers most according
+// to an Accept-Language header such as "pl-PL,pl;q=0.9,en;q=0.8".
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		lang, _, _ := strings.Cut(tag, "-")
+		if lang != English && lang != Polish {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{lang, quality})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].quality > candidates[j].quality })
+	return candidates[0].lang
+}
@@ -0,0 +1,40 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	require.Equal(t, English, Negotiate(""))
+	require.Equal(t, Polish, Negotiate("pl-PL,pl;q=0.9,en;q=0.8"))
+	require.Equal(t, English, Negotiate("de-DE,en-US;q=0.7,pl;q=0.5"))
+	require.Equal(t, Polish, Negotiate("en;q=0.2, PL;q=0.6"))
+	require.Equal(t, English, Negotiate("pl;q=0, fr"), "q=0 rules a language out")
+}
+
+func TestMessages(t *testing.T) {
+	for code, translations := range messages {
+		require.NotEmpty(t, translations[English], "Missing English message for %s", code)
+		require.NotEmpty(t, translations[Polish], "Missing Polish message for %s", code)
+		require.Equal(t, formatVerbs(translations[English]), formatVerbs(translations[Polish]), "Format verbs of %s differ", code)
+	}
+
+	require.Equal(t, "Nieprawidłowa treść żądania", Message(InvalidRequestBody, Polish))
+	require.Equal(t, "Invalid request body", Message(InvalidRequestBody, "de"))
+	require.Equal(t, "unknown_code", Message("unknown_code", Polish))
+	require.Equal(t, "Dozwolonych jest najwyżej 5 szablonów", Messagef(TooManyOnboardingTemplates, Polish, 5))
+}
+
+var formatVerb = regexp.MustCompile(`%(\[\d+\])?[a-z]`)
+
+// formatVerbs counts the verbs of a message, which translations must keep.
+func formatVerbs(message string) map[string]int {
+	verbs := map[string]int{}
+	for _, verb := range formatVerb.FindAllString(message, -1) {
+		verbs[verb]++
+	}
+	return verbs
+}
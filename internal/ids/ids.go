@@ -0,0 +1,98 @@
+package ids
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jaevor/go-nanoid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// StandardAlphabet is the URL-safe nanoid alphabet.
+	StandardAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// MaxLength is the width of the node ID columns.
+	MaxLength     = 21
+	DefaultLength = MaxLength
+	// TokenLength is the length of refresh and undo tokens.
+	TokenLength = 40
+
+	maxAttempts = 10
+)
+
+var collisionRetries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "id_generation_collisions_total",
+		Help: "Generated IDs that were already taken and had to be drawn again, labeled by kind.",
+	},
+	[]string{"kind"},
+)
+
+// Generator draws random IDs of a fixed length from an alphabet.
+type Generator struct {
+	kind     string
+	generate func() string
+}
+
+// New returns a generator for IDs of the given kind, used as the metrics
+// label. An empty alphabet means StandardAlphabet and a zero length means
+// DefaultLength. The alphabet must be URL-safe, since IDs end up in paths.
+func New(kind, alphabet string, length int) (*Generator, error) {
+	if alphabet == "" {
+		alphabet = StandardAlphabet
+	}
+	if length == 0 {
+		length = DefaultLength
+	}
+	if length < 2 || length > MaxLength {
+		return nil, fmt.Errorf("id length must be between 2 and %d, got %d", MaxLength, length)
+	}
+	for _, c := range alphabet {
+		if !strings.ContainsRune(StandardAlphabet, c) {
+			return nil, fmt.Errorf("id alphabet contains %q, only letters, digits, '_' and '-' are allowed", c)
+		}
+	}
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("id alphabet must have at least 2 characters")
+	}
+
+	generate, err := nanoid.CustomASCII(alphabet, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize nanoid generator: %w", err)
+	}
+	return &Generator{kind: kind, generate: generate}, nil
+}
+
+// NewID returns a random ID without checking it for collisions.
+func (g *Generator) NewID() string {
+	return g.generate()
+}
+
+// Unique draws IDs until exists reports one as free, giving up after a fixed
+// number of attempts. Every retry is counted in the collisions metric.
+func (g *Generator) Unique(ctx context.Context, exists func(context.Context, string) (bool, error)) (string, error) {
+	for i := 0; i < maxAttempts; i++ {
+		id := g.generate()
+		taken, err := exists(ctx, id)
+		if err != nil {
+			return "", fmt.Errorf("failed to check for id existence: %w", err)
+		}
+		if !taken {
+			return id, nil
+		}
+		collisionRetries.WithLabelValues(g.kind).Inc()
+	}
+	return "", fmt.Errorf("failed to generate a unique ID after %d attempts", maxAttempts)
+}
+
+// Token returns a random standard-alphabet token of TokenLength characters,
+// used for refresh and undo tokens.
+func Token() (string, error) {
+	generate, err := nanoid.Standard(TokenLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize nanoid generator: %w", err)
+	}
+	return generate(), nil
+}
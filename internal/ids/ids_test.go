@@ -0,0 +1,49 @@
+package ids
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	g, err := New("test", "", 0)
+	require.NoError(t, err)
+	require.Len(t, g.NewID(), DefaultLength)
+
+	g, err = New("test", "abc", 8)
+	require.NoError(t, err)
+	id := g.NewID()
+	require.Len(t, id, 8)
+	require.Empty(t, strings.Trim(id, "abc"))
+
+	_, err = New("test", "", MaxLength+1)
+	require.Error(t, err)
+	_, err = New("test", "ab/", 8)
+	require.Error(t, err, "Characters outside the URL-safe alphabet are rejected")
+}
+
+func TestUnique(t *testing.T) {
+	g, err := New("test", "", 0)
+	require.NoError(t, err)
+
+	calls := 0
+	id, err := g.Unique(context.Background(), func(ctx context.Context, id string) (bool, error) {
+		calls++
+		return calls < 3, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, id, DefaultLength)
+	require.Equal(t, 3, calls)
+
+	_, err = g.Unique(context.Background(), func(ctx context.Context, id string) (bool, error) {
+		return true, nil
+	})
+	require.Error(t, err)
+
+	token, err := Token()
+	require.NoError(t, err)
+	require.Len(t, token, TokenLength)
+}
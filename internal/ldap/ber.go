@@ -0,0 +1,167 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAP messages (RFC 4511).
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest      = 0x60
+	tagBindResponse     = 0x61
+	tagUnbindRequest    = 0x42
+	tagSearchRequest    = 0x63
+	tagSearchEntry      = 0x64
+	tagSearchDone       = 0x65
+	tagSearchReference  = 0x73
+	tagControls         = 0xa0
+	tagSimpleAuth       = 0x80
+	tagExtendedResponse = 0x78
+)
+
+// maxPacketSize bounds a single message from the server.
+const maxPacketSize = 16 << 20
+
+var errMalformedPacket = errors.New("malformed BER packet")
+
+// packet is a decoded BER element.
+type packet struct {
+	tag   byte
+	value []byte
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var digits []byte
+	for ; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	out := append([]byte{tag}, encodeLength(len(value))...)
+	return append(out, value...)
+}
+
+func berSeq(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	return berTLV(tag, value)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+func berInt(tag byte, n int64) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if (n < 0x80 && n >= -0x80) || len(value) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return berTLV(tag, value)
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berTLV(tagBoolean, []byte{0xff})
+	}
+	return berTLV(tagBoolean, []byte{0x00})
+}
+
+// parsePacket decodes the first element of data and returns the rest.
+func parsePacket(data []byte) (packet, []byte, error) {
+	if len(data) < 2 {
+		return packet{}, nil, errMalformedPacket
+	}
+	tag, length, header := data[0], int(data[1]), 2
+	if length&0x80 != 0 {
+		digits := length & 0x7f
+		if digits == 0 || digits > 4 || len(data) < 2+digits {
+			return packet{}, nil, errMalformedPacket
+		}
+		length = 0
+		for _, b := range data[2 : 2+digits] {
+			length = length<<8 | int(b)
+		}
+		header += digits
+	}
+	if length < 0 || len(data)-header < length {
+		return packet{}, nil, errMalformedPacket
+	}
+	return packet{tag: tag, value: data[header : header+length]}, data[header+length:], nil
+}
+
+// children decodes the elements of a constructed packet.
+func (p packet) children() ([]packet, error) {
+	var children []packet
+	rest := p.value
+	for len(rest) > 0 {
+		child, next, err := parsePacket(rest)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		rest = next
+	}
+	return children, nil
+}
+
+func (p packet) int() (int64, error) {
+	if len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errMalformedPacket
+	}
+	n := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// readPacket reads one whole element from a stream.
+func readPacket(r *bufio.Reader) (packet, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return packet{}, err
+	}
+	length := int(head[1])
+	if length&0x80 != 0 {
+		digits := length & 0x7f
+		if digits == 0 || digits > 4 {
+			return packet{}, errMalformedPacket
+		}
+		lengthBytes := make([]byte, digits)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return packet{}, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("LDAP message of %d bytes exceeds the limit", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return packet{}, err
+	}
+	return packet{tag: head[0], value: value}, nil
+}
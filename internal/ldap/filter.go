@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1).
+const (
+	filterAnd            = 0xa0
+	filterOr             = 0xa1
+	filterNot            = 0xa2
+	filterEquality       = 0xa3
+	filterSubstrings     = 0xa4
+	filterGreaterOrEqual = 0xa5
+	filterLessOrEqual    = 0xa6
+	filterPresent        = 0x87
+	filterApprox         = 0xa8
+	filterExtensible     = 0xa9
+)
+
+// compileFilter encodes a string filter (RFC 4515), such as
+// "(&(objectClass=person)(!(uid=guest)))", as BER.
+func compileFilter(filter string) ([]byte, error) {
+	p := &filterParser{s: filter}
+	encoded, err := p.filter()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, p.errorf("unexpected text after the filter")
+	}
+	return encoded, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid LDAP filter %q at offset %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) filter() ([]byte, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] != '(' {
+		return nil, p.errorf("expected '('")
+	}
+	p.pos++
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("unterminated filter")
+	}
+
+	var encoded []byte
+	var err error
+	switch p.s[p.pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if p.s[p.pos] == '|' {
+			tag = filterOr
+		}
+		p.pos++
+		var children [][]byte
+		for p.pos < len(p.s) && p.s[p.pos] == '(' {
+			child, err := p.filter()
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+		encoded = berSeq(tag, children...)
+	case '!':
+		p.pos++
+		child, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		encoded = berSeq(filterNot, child)
+	default:
+		end := strings.IndexByte(p.s[p.pos:], ')')
+		if end < 0 {
+			return nil, p.errorf("unterminated filter")
+		}
+		encoded, err = p.item(p.s[p.pos : p.pos+end])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += end
+	}
+
+	if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+		return nil, p.errorf("expected ')'")
+	}
+	p.pos++
+	return encoded, nil
+}
+
+// item encodes a simple filter such as "uid=jan", "cn=*Kowal*" or
+// "userAccountControl:1.2.840.113556.1.4.803:=2".
+func (p *filterParser) item(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, p.errorf("expected attribute=value")
+	}
+	attribute, rawValue := item[:eq], item[eq+1:]
+
+	switch attribute[len(attribute)-1] {
+	case '~', '>', '<':
+		tag := map[byte]byte{'~': filterApprox, '>': filterGreaterOrEqual, '<': filterLessOrEqual}[attribute[len(attribute)-1]]
+		value, err := unescapeValue(rawValue)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return berSeq(tag, berString(tagOctetString, attribute[:len(attribute)-1]), berString(tagOctetString, value)), nil
+	case ':':
+		return p.extensible(attribute[:len(attribute)-1], rawValue)
+	}
+
+	if rawValue == "*" {
+		return berString(filterPresent, attribute), nil
+	}
+	parts := strings.Split(rawValue, "*")
+	if len(parts) == 1 {
+		value, err := unescapeValue(rawValue)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return berSeq(filterEquality, berString(tagOctetString, attribute), berString(tagOctetString, value)), nil
+	}
+
+	var substrings [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		value, err := unescapeValue(part)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		tag := byte(0x81) // any
+		if i == 0 {
+			tag = 0x80 // initial
+		} else if i == len(parts)-1 {
+			tag = 0x82 // final
+		}
+		substrings = append(substrings, berString(tag, value))
+	}
+	return berSeq(filterSubstrings, berString(tagOctetString, attribute), berSeq(tagSequence, substrings...)), nil
+}
+
+// extensible encodes "attr[:dn][:rule]:=value" with the trailing ':' removed.
+func (p *filterParser) extensible(spec, rawValue string) ([]byte, error) {
+	fields := strings.Split(spec, ":")
+	var attribute, rule string
+	var dnAttributes bool
+	attribute = fields[0]
+	for _, field := range fields[1:] {
+		switch {
+		case strings.EqualFold(field, "dn"):
+			dnAttributes = true
+		case field != "" && rule == "":
+			rule = field
+		default:
+			return nil, p.errorf("invalid extensible match %q", spec)
+		}
+	}
+	if attribute == "" && rule == "" {
+		return nil, p.errorf("an extensible match needs an attribute or a matching rule")
+	}
+	value, err := unescapeValue(rawValue)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+
+	var fieldsBER [][]byte
+	if rule != "" {
+		fieldsBER = append(fieldsBER, berString(0x81, rule))
+	}
+	if attribute != "" {
+		fieldsBER = append(fieldsBER, berString(0x82, attribute))
+	}
+	fieldsBER = append(fieldsBER, berString(0x83, value))
+	if dnAttributes {
+		fieldsBER = append(fieldsBER, berTLV(0x84, []byte{0xff}))
+	}
+	return berSeq(filterExtensible, fieldsBER...), nil
+}
+
+// unescapeValue decodes the \XX escapes of a filter value.
+func unescapeValue(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("incomplete escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		out.Write(decoded)
+		i += 2
+	}
+	return out.String(), nil
+}
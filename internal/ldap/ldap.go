@@ -0,0 +1,426 @@
+// Package ldap reads user accounts and groups from an LDAP directory, such as
+// OpenLDAP or Active Directory, and checks passwords with a bind. It implements the
+// small part of LDAPv3 it needs: simple binds and paged subtree searches.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// pageSize is the page size requested with the paged results control;
+	// Active Directory returns at most 1000 entries per search without it.
+	pageSize = 500
+
+	pagedResultsOID = "1.2.840.113556.1.4.319"
+
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+var ErrInvalidCredentials = errors.New("invalid LDAP credentials")
+
+// ResultError is an LDAP operation that did not succeed.
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// Config describes where and how users are read from the directory.
+type Config struct {
+	// URL is "ldap://host[:389]" or "ldaps://host[:636]".
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter selects the synchronized accounts, e.g.
+	// "(objectClass=inetOrgPerson)".
+	UserFilter           string
+	UsernameAttribute    string
+	DisplayNameAttribute string
+	// GroupFilter selects the synchronized groups, e.g.
+	// "(objectClass=groupOfNames)"; groups are not read without it.
+	GroupFilter        string
+	GroupNameAttribute string
+	// GroupMemberAttribute lists the members of a group, either by DN, as
+	// "member" does, or by username, as "memberUid" does.
+	GroupMemberAttribute string
+	// Timeout bounds a whole operation, including all pages of a search.
+	Timeout time.Duration
+}
+
+// User is an account found in the directory.
+type User struct {
+	DN       string
+	Username string
+	// DisplayName is empty when the entry has none.
+	DisplayName string
+}
+
+// Group is a group found in the directory.
+type Group struct {
+	DN   string
+	Name string
+	// Members holds the values of the member attribute: DNs or usernames.
+	Members []string
+}
+
+// UserDirectory lists accounts and groups and verifies passwords.
+type UserDirectory interface {
+	Users(ctx context.Context) ([]User, error)
+	Groups(ctx context.Context) ([]Group, error)
+	Authenticate(ctx context.Context, dn, password string) error
+}
+
+// Directory is a UserDirectory backed by an LDAP server.
+type Directory struct {
+	config Config
+}
+
+func NewDirectory(config Config) *Directory {
+	return &Directory{config: config}
+}
+
+// Users returns the entries matching the user filter that have a username.
+// Usernames are lowercased.
+func (d *Directory) Users(ctx context.Context) ([]User, error) {
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if err := conn.bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("service bind failed: %w", err)
+	}
+	entries, err := conn.search(d.config.BaseDN, d.config.UserFilter, []string{d.config.UsernameAttribute, d.config.DisplayNameAttribute})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(entries))
+	for _, entry := range entries {
+		username := strings.ToLower(strings.TrimSpace(entry.get(d.config.UsernameAttribute)))
+		if username == "" {
+			continue
+		}
+		users = append(users, User{DN: entry.dn, Username: username, DisplayName: strings.TrimSpace(entry.get(d.config.DisplayNameAttribute))})
+	}
+	return users, nil
+}
+
+// Groups returns the entries matching the group filter that have a name, or
+// nil when no group filter is configured.
+func (d *Directory) Groups(ctx context.Context) ([]Group, error) {
+	if d.config.GroupFilter == "" {
+		return nil, nil
+	}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if err := conn.bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("service bind failed: %w", err)
+	}
+	entries, err := conn.search(d.config.BaseDN, d.config.GroupFilter, []string{d.config.GroupNameAttribute, d.config.GroupMemberAttribute})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]Group, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSpace(entry.get(d.config.GroupNameAttribute))
+		if name == "" {
+			continue
+		}
+		groups = append(groups, Group{DN: entry.dn, Name: name, Members: entry.attributes[strings.ToLower(d.config.GroupMemberAttribute)]})
+	}
+	return groups, nil
+}
+
+// Authenticate binds as dn with password. An empty password is rejected
+// since servers treat it as an anonymous bind that always succeeds.
+func (d *Directory) Authenticate(ctx context.Context, dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	return conn.bind(dn, password)
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	nextID  int64
+	stop    func() bool
+}
+
+func (d *Directory) dial(ctx context.Context) (*conn, error) {
+	target, err := url.Parse(d.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+	host := target.Host
+	dialer := &net.Dialer{Timeout: d.config.Timeout}
+	var netConn net.Conn
+	switch target.Scheme {
+	case "ldap":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "389")
+		}
+		netConn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "636")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: target.Hostname()}}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", target.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+	if d.config.Timeout > 0 {
+		netConn.SetDeadline(time.Now().Add(d.config.Timeout))
+	}
+	return &conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		stop:    context.AfterFunc(ctx, func() { netConn.Close() }),
+	}, nil
+}
+
+func (c *conn) close() {
+	c.stop()
+	c.send(berTLV(tagUnbindRequest, nil), nil)
+	c.netConn.Close()
+}
+
+func (c *conn) send(op, controls []byte) (int64, error) {
+	c.nextID++
+	message := berSeq(tagSequence, berInt(tagInteger, c.nextID), op)
+	if controls != nil {
+		message = berSeq(tagSequence, berInt(tagInteger, c.nextID), op, controls)
+	}
+	_, err := c.netConn.Write(message)
+	return c.nextID, err
+}
+
+// receive reads the next message for id and returns its operation and
+// controls, skipping unsolicited notifications.
+func (c *conn) receive(id int64) (packet, []packet, error) {
+	for {
+		message, err := readPacket(c.reader)
+		if err != nil {
+			return packet{}, nil, err
+		}
+		fields, err := message.children()
+		if err != nil || len(fields) < 2 {
+			return packet{}, nil, errMalformedPacket
+		}
+		messageID, err := fields[0].int()
+		if err != nil {
+			return packet{}, nil, err
+		}
+		if messageID == 0 && fields[1].tag == tagExtendedResponse {
+			return packet{}, nil, errors.New("the LDAP server closed the connection")
+		}
+		if messageID != id {
+			continue
+		}
+		var controls []packet
+		if len(fields) > 2 && fields[2].tag == tagControls {
+			if controls, err = fields[2].children(); err != nil {
+				return packet{}, nil, err
+			}
+		}
+		return fields[1], controls, nil
+	}
+}
+
+// result checks the LDAPResult of a response.
+func result(op packet) error {
+	fields, err := op.children()
+	if err != nil || len(fields) < 3 {
+		return errMalformedPacket
+	}
+	code, err := fields[0].int()
+	if err != nil {
+		return err
+	}
+	switch code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		return &ResultError{Code: code, Message: string(fields[2].value)}
+	}
+}
+
+func (c *conn) bind(dn, password string) error {
+	id, err := c.send(berSeq(tagBindRequest,
+		berInt(tagInteger, 3),
+		berString(tagOctetString, dn),
+		berString(tagSimpleAuth, password),
+	), nil)
+	if err != nil {
+		return err
+	}
+	op, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != tagBindResponse {
+		return errMalformedPacket
+	}
+	return result(op)
+}
+
+type entry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// get returns the first value of an attribute, matching its name without
+// regard to case.
+func (e entry) get(attribute string) string {
+	if values := e.attributes[strings.ToLower(attribute)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// search runs a subtree search, following the paged results cookie until
+// all entries were returned.
+func (c *conn) search(baseDN, filter string, attributes []string) ([]entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var attributeList [][]byte
+	for _, attribute := range attributes {
+		if attribute != "" {
+			attributeList = append(attributeList, berString(tagOctetString, attribute))
+		}
+	}
+
+	var entries []entry
+	var cookie []byte
+	for {
+		request := berSeq(tagSearchRequest,
+			berString(tagOctetString, baseDN),
+			berInt(tagEnumerated, 2), // wholeSubtree
+			berInt(tagEnumerated, 0), // neverDerefAliases
+			berInt(tagInteger, 0),
+			berInt(tagInteger, 0),
+			berBool(false),
+			compiled,
+			berSeq(tagSequence, attributeList...),
+		)
+		paging := berSeq(tagControls, berSeq(tagSequence,
+			berString(tagOctetString, pagedResultsOID),
+			berString(tagOctetString, string(berSeq(tagSequence, berInt(tagInteger, pageSize), berTLV(tagOctetString, cookie)))),
+		))
+		id, err := c.send(request, paging)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			op, controls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			switch op.tag {
+			case tagSearchEntry:
+				parsed, err := parseEntry(op)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, parsed)
+				continue
+			case tagSearchReference:
+				continue
+			case tagSearchDone:
+				if err := result(op); err != nil {
+					return nil, err
+				}
+				cookie = pagingCookie(controls)
+			default:
+				return nil, errMalformedPacket
+			}
+			break
+		}
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+func parseEntry(op packet) (entry, error) {
+	fields, err := op.children()
+	if err != nil || len(fields) < 2 {
+		return entry{}, errMalformedPacket
+	}
+	parsed := entry{dn: string(fields[0].value), attributes: map[string][]string{}}
+	attributes, err := fields[1].children()
+	if err != nil {
+		return entry{}, err
+	}
+	for _, attribute := range attributes {
+		parts, err := attribute.children()
+		if err != nil || len(parts) < 2 {
+			return entry{}, errMalformedPacket
+		}
+		values, err := parts[1].children()
+		if err != nil {
+			return entry{}, err
+		}
+		name := strings.ToLower(string(parts[0].value))
+		for _, value := range values {
+			parsed.attributes[name] = append(parsed.attributes[name], string(value.value))
+		}
+	}
+	return parsed, nil
+}
+
+// pagingCookie returns the cookie of the paged results control, which is
+// empty after the last page.
+func pagingCookie(controls []packet) []byte {
+	for _, control := range controls {
+		fields, err := control.children()
+		if err != nil || len(fields) < 2 || string(fields[0].value) != pagedResultsOID {
+			continue
+		}
+		value, _, err := parsePacket(fields[len(fields)-1].value)
+		if err != nil {
+			return nil
+		}
+		parts, err := value.children()
+		if err != nil || len(parts) < 2 {
+			return nil
+		}
+		return parts[1].value
+	}
+	return nil
+}
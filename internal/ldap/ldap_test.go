@@ -0,0 +1,182 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilter(t *testing.T) {
+	tests := map[string]string{
+		"(uid=jan)":     "a30a0403756964" + "04036a616e",
+		"(mail=*)":      "87046d61696c",
+		"(cn=a*b*c)":    "a40f0402636e" + "3009800161810162820163",
+		"(!(uid=a))":    "a20a" + "a3080403756964040161",
+		"(&(a=1)(b=2))": "a010" + "a306040161040131" + "a306040162040132",
+		"(cn=\\2a)":     "a3070402636e04012a",
+		"(userAccountControl:1.2.840.113556.1.4.803:=2)": "a92f" +
+			"8116312e322e3834302e3131333535362e312e342e383033" +
+			"821275736572416363" + "6f756e74436f6e74726f6c" + "830132",
+	}
+	for filter, expected := range tests {
+		compiled, err := compileFilter(filter)
+		require.NoError(t, err, filter)
+		require.Equal(t, expected, hex.EncodeToString(compiled), filter)
+	}
+
+	for _, invalid := range []string{"uid=jan", "(uid=jan", "(=jan)", "(uid=jan))", "(cn=\\2)"} {
+		_, err := compileFilter(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+// fakeServer answers binds and searches like a directory with the given
+// entries, returning one entry per page.
+func fakeServer(t *testing.T, passwords map[string]string, entries []entry) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(netConn, passwords, entries)
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func serveFake(netConn net.Conn, passwords map[string]string, entries []entry) {
+	defer netConn.Close()
+	reader := bufio.NewReader(netConn)
+	reply := func(id int64, op []byte, controls ...[]byte) {
+		netConn.Write(berSeq(tagSequence, append([][]byte{berInt(tagInteger, id), op}, controls...)...))
+	}
+	ldapResult := func(tag byte, code int64) []byte {
+		return berSeq(tag, berInt(tagEnumerated, code), berString(tagOctetString, ""), berString(tagOctetString, ""))
+	}
+
+	for {
+		message, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		fields, _ := message.children()
+		id, _ := fields[0].int()
+		op := fields[1]
+		switch op.tag {
+		case tagBindRequest:
+			parts, _ := op.children()
+			code := int64(resultInvalidCredentials)
+			if password, ok := passwords[string(parts[1].value)]; ok && password == string(parts[2].value) {
+				code = resultSuccess
+			}
+			reply(id, ldapResult(tagBindResponse, code))
+		case tagSearchRequest:
+			controls, _ := fields[2].children()
+			control, _ := controls[0].children()
+			paging, _, _ := parsePacket(control[1].value)
+			pagingFields, _ := paging.children()
+			page := 0
+			if len(pagingFields[1].value) > 0 {
+				page = int(pagingFields[1].value[0])
+			}
+			e := entries[page]
+			var attributes [][]byte
+			for name, values := range e.attributes {
+				var encoded [][]byte
+				for _, value := range values {
+					encoded = append(encoded, berString(tagOctetString, value))
+				}
+				attributes = append(attributes, berSeq(tagSequence, berString(tagOctetString, name), berSeq(tagSet, encoded...)))
+			}
+			reply(id, berSeq(tagSearchEntry, berString(tagOctetString, e.dn), berSeq(tagSequence, attributes...)))
+
+			var cookie []byte
+			if page+1 < len(entries) {
+				cookie = []byte{byte(page + 1)}
+			}
+			reply(id, ldapResult(tagSearchDone, resultSuccess), berSeq(tagControls, berSeq(tagSequence,
+				berString(tagOctetString, pagedResultsOID),
+				berString(tagOctetString, string(berSeq(tagSequence, berInt(tagInteger, 0), berTLV(tagOctetString, cookie)))),
+			)))
+		case tagUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	url := fakeServer(t,
+		map[string]string{"cn=sync,dc=example,dc=com": "service", "uid=jan,ou=people,dc=example,dc=com": "tajne"},
+		[]entry{
+			{dn: "uid=jan,ou=people,dc=example,dc=com", attributes: map[string][]string{"uid": {"Jan"}, "displayName": {"Jan Kowalski"}}},
+			{dn: "uid=anna,ou=people,dc=example,dc=com", attributes: map[string][]string{"uid": {"anna"}}},
+			{dn: "cn=printer,dc=example,dc=com", attributes: map[string][]string{"cn": {"printer"}}},
+		})
+	directory := NewDirectory(Config{
+		URL:                  url,
+		BindDN:               "cn=sync,dc=example,dc=com",
+		BindPassword:         "service",
+		BaseDN:               "dc=example,dc=com",
+		UserFilter:           "(objectClass=person)",
+		UsernameAttribute:    "uid",
+		DisplayNameAttribute: "displayName",
+		Timeout:              5 * time.Second,
+	})
+	ctx := context.Background()
+
+	users, err := directory.Users(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []User{
+		{DN: "uid=jan,ou=people,dc=example,dc=com", Username: "jan", DisplayName: "Jan Kowalski"},
+		{DN: "uid=anna,ou=people,dc=example,dc=com", Username: "anna"},
+	}, users, "Entries come from all pages, those without a username are skipped")
+
+	require.NoError(t, directory.Authenticate(ctx, "uid=jan,ou=people,dc=example,dc=com", "tajne"))
+	require.ErrorIs(t, directory.Authenticate(ctx, "uid=jan,ou=people,dc=example,dc=com", "zle"), ErrInvalidCredentials)
+	require.ErrorIs(t, directory.Authenticate(ctx, "uid=jan,ou=people,dc=example,dc=com", ""), ErrInvalidCredentials)
+
+	groups, err := directory.Groups(ctx)
+	require.NoError(t, err)
+	require.Nil(t, groups, "Groups are not read without a group filter")
+
+	wrongService := NewDirectory(Config{URL: url, BindDN: "cn=sync,dc=example,dc=com", BindPassword: "wrong", UserFilter: "(uid=*)", UsernameAttribute: "uid"})
+	_, err = wrongService.Users(ctx)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestDirectoryGroups(t *testing.T) {
+	url := fakeServer(t,
+		map[string]string{"cn=sync,dc=example,dc=com": "service"},
+		[]entry{
+			{dn: "cn=ksiegowosc,ou=groups,dc=example,dc=com", attributes: map[string][]string{"cn": {"Księgowość"}, "member": {"uid=jan,ou=people,dc=example,dc=com", "uid=anna,ou=people,dc=example,dc=com"}}},
+			{dn: "cn=pusta,ou=groups,dc=example,dc=com", attributes: map[string][]string{"cn": {"Pusta"}}},
+			{dn: "ou=groups,dc=example,dc=com", attributes: map[string][]string{"ou": {"groups"}}},
+		})
+	directory := NewDirectory(Config{
+		URL:                  url,
+		BindDN:               "cn=sync,dc=example,dc=com",
+		BindPassword:         "service",
+		BaseDN:               "dc=example,dc=com",
+		GroupFilter:          "(objectClass=groupOfNames)",
+		GroupNameAttribute:   "cn",
+		GroupMemberAttribute: "member",
+		Timeout:              5 * time.Second,
+	})
+
+	groups, err := directory.Groups(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Group{
+		{DN: "cn=ksiegowosc,ou=groups,dc=example,dc=com", Name: "Księgowość", Members: []string{"uid=jan,ou=people,dc=example,dc=com", "uid=anna,ou=people,dc=example,dc=com"}},
+		{DN: "cn=pusta,ou=groups,dc=example,dc=com", Name: "Pusta"},
+	}, groups, "Entries without a name are skipped")
+}
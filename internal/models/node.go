@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Node struct {
 	ID               string     `json:"id"`
@@ -13,5 +17,12 @@ type Node struct {
 	CreatedAt        time.Time  `json:"created_at"`
 	ModifiedAt       time.Time  `json:"modified_at"`
 	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	DeletionBatchID  *uuid.UUID `json:"deletion_batch_id,omitempty"`
 	OriginalParentID *string    `json:"-"`
+	// ContentSHA256 is the hex SHA-256 checksum of a file's content, unknown
+	// for files stored before checksums were recorded.
+	ContentSHA256 *string `json:"sha256,omitempty"`
+	// ChildCount is the number of direct children of a folder, filled in only
+	// when a listing asks for it.
+	ChildCount *int64 `json:"child_count,omitempty"`
 }
@@ -12,4 +12,7 @@ type Session struct {
 	ClientIP  string    `json:"client_ip" example:"198.51.100.10"`
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
+	// LastUsedAt is when the session last refreshed its tokens or, with a
+	// delay of up to a few minutes, made an API request.
+	LastUsedAt time.Time `json:"last_used_at"`
 }
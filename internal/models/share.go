@@ -3,10 +3,43 @@ package models
 import "time"
 
 type Share struct {
+	ID          int64   `json:"id"`
+	NodeID      string  `json:"node_id"`
+	SharerID    int64   `json:"sharer_id"`
+	RecipientID int64   `json:"recipient_id"`
+	Permissions string  `json:"permissions"`
+	Message     *string `json:"message,omitempty"`
+	// PinnedVersion is set for shares of a fixed file version.
+	PinnedVersion *int      `json:"pinned_version,omitempty"`
+	SharedAt      time.Time `json:"shared_at"`
+}
+
+// Group is a team of users that nodes can be shared with at once. Its owner
+// manages the members and is one of them.
+type Group struct {
+	ID int64 `json:"id"`
+	// OwnerID is nil for groups synchronized from the directory.
+	OwnerID     *int64    `json:"owner_id,omitempty"`
+	Name        string    `json:"name"`
+	MemberCount int       `json:"member_count"`
+	Directory   bool      `json:"directory"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type GroupMember struct {
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// GroupShare grants every current member of a group access to a node.
+type GroupShare struct {
 	ID          int64     `json:"id"`
 	NodeID      string    `json:"node_id"`
 	SharerID    int64     `json:"sharer_id"`
-	RecipientID int64     `json:"recipient_id"`
+	GroupID     int64     `json:"group_id"`
 	Permissions string    `json:"permissions"`
+	Message     *string   `json:"message,omitempty"`
 	SharedAt    time.Time `json:"shared_at"`
 }
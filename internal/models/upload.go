@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UploadSession struct {
+	ID             uuid.UUID     `json:"id"`
+	UserID         int64         `json:"user_id"`
+	OwnerID        int64         `json:"owner_id"`
+	ParentID       *string       `json:"parent_id"`
+	FileName       string        `json:"file_name"`
+	MimeType       *string       `json:"mime_type"`
+	TotalSize      int64         `json:"total_size"`
+	ExpectedSHA256 *string       `json:"expected_sha256,omitempty"`
+	ReceivedBytes  int64         `json:"received_bytes"`
+	Chunks         []UploadChunk `json:"chunks"`
+	TempLocation   string        `json:"-"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+}
+
+type UploadChunk struct {
+	Offset    int64   `json:"offset"`
+	SizeBytes int64   `json:"size_bytes"`
+	Checksum  *string `json:"checksum,omitempty"`
+}
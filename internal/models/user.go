@@ -10,4 +10,8 @@ type User struct {
 	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 	StorageQuotaBytes int64     `json:"storage_quota_bytes" db:"storage_quota_bytes"`
 	StorageUsedBytes  int64     `json:"storage_used_bytes" db:"storage_used_bytes"`
+	IsAdmin           bool      `json:"is_admin" db:"is_admin"`
+	// LDAPDN is set for accounts synchronized from an LDAP directory.
+	LDAPDN     *string    `json:"-" db:"ldap_dn"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
 }
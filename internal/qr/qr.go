@@ -0,0 +1,242 @@
+// Package qr encodes text as QR codes (ISO/IEC 18004) for printing links or
+// scanning them with a phone. It uses byte mode and error correction level M
+// in versions 1 to 10, which holds up to 213 bytes: enough for link addresses.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+const (
+	minVersion = 1
+	maxVersion = 10
+	// quietZone is the light border around the symbol, in modules.
+	quietZone = 4
+	// eclBitsM are the format bits of error correction level M.
+	eclBitsM = 0
+)
+
+// ErrTooLong is returned for text that does not fit in the largest version.
+var ErrTooLong = errors.New("text is too long for a QR code")
+
+// eccPerBlock and numBlocks describe the error correction of level M in
+// versions 1 to 10, indexed by version.
+var (
+	eccPerBlock = [maxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	numBlocks   = [maxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// Code is an encoded QR code symbol.
+type Code struct {
+	version    int
+	size       int
+	modules    [][]bool // dark modules, indexed by row and column
+	isFunction [][]bool
+}
+
+// Encode returns the smallest QR code holding text.
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version) {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * dataCodewords(version)
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+	c.drawCodewords(addECCAndInterleave(bits.bytes(), version))
+	c.applyMask(c.bestMask())
+	return c, nil
+}
+
+// Size returns the width and height of the symbol in modules, without the
+// quiet zone.
+func (c *Code) Size() int { return c.size }
+
+// Dark reports whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.size && y < c.size && c.modules[y][x]
+}
+
+// Image draws the symbol with its quiet zone, scale pixels per module.
+func (c *Code) Image(scale int) image.Image {
+	scale = max(scale, 1)
+	width := (c.size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			shade := color.Gray{Y: 255}
+			if c.Dark(x/scale-quietZone, y/scale-quietZone) {
+				shade.Y = 0
+			}
+			img.SetGray(x, y, shade)
+		}
+	}
+	return img
+}
+
+// PNG returns the symbol as a PNG image, scale pixels per module.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// countBits is the width of the character count of byte mode.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawCodewords is the number of codewords, data and error correction, that
+// fit in a symbol of the version.
+func rawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		modules -= (25*align-10)*align - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+func dataCodewords(version int) int {
+	return rawCodewords(version) - eccPerBlock[version]*numBlocks[version]
+}
+
+// alignmentPositions are the centres of the alignment patterns on each axis.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// addECCAndInterleave splits the data into blocks, appends their error
+// correction codewords and interleaves the blocks.
+func addECCAndInterleave(data []byte, version int) []byte {
+	blocks, eccLen, raw := numBlocks[version], eccPerBlock[version], rawCodewords(version)
+	shortBlocks := blocks - raw%blocks
+	shortLen := raw / blocks
+	divisor := reedSolomonDivisor(eccLen)
+
+	all := make([][]byte, 0, blocks)
+	for i, k := 0, 0; i < blocks; i++ {
+		dataLen := shortLen - eccLen
+		if i >= shortBlocks {
+			dataLen++
+		}
+		block := append([]byte{}, data[k:k+dataLen]...)
+		k += dataLen
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < shortBlocks {
+			block = append(block, 0) // keeps the blocks aligned, skipped below
+		}
+		all = append(all, append(block, ecc...))
+	}
+
+	result := make([]byte, 0, raw)
+	for i := range all[0] {
+		for j, block := range all {
+			if i != shortLen-eccLen || j >= shortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// from the highest to the lowest power, without the leading 1.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of data.
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" as 1-M from the standard's annex.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	require.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, reedSolomonRemainder(data, reedSolomonDivisor(10)))
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	require.Equal(t, "101010000010010", fmt.Sprintf("%015b", formatBits(0)))
+	require.Equal(t, "000111110010010100", fmt.Sprintf("%018b", versionBits(7)))
+}
+
+func TestLayout(t *testing.T) {
+	expected := map[int]struct{ data, raw int }{1: {16, 26}, 4: {64, 100}, 7: {124, 196}, 10: {216, 346}}
+	for version, want := range expected {
+		require.Equal(t, want.raw, rawCodewords(version), "version %d", version)
+		require.Equal(t, want.data, dataCodewords(version), "version %d", version)
+	}
+	require.Equal(t, []int{6, 22, 38}, alignmentPositions(7))
+	require.Equal(t, []int{6, 28, 50}, alignmentPositions(10))
+}
+
+// readCodewords undoes the mask of an encoded symbol and reads its codewords
+// back in placement order.
+func readCodewords(c *Code) []byte {
+	mask := -1
+	for m := 0; m < 8; m++ {
+		bits := formatBits(m)
+		matches := true
+		for i := 0; i < 8; i++ {
+			if c.modules[8][c.size-1-i] != (bits>>i&1 != 0) {
+				matches = false
+			}
+		}
+		if matches {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		return nil
+	}
+	var bits bitBuffer
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if !c.isFunction[y][x] {
+					bits = append(bits, c.modules[y][x] != maskedAt(mask, x, y))
+				}
+			}
+		}
+	}
+	return bits.bytes()[:rawCodewords(c.version)]
+}
+
+func TestEncode(t *testing.T) {
+	for _, text := range []string{"https://pliki.example.com/api/v1/public/abc", strings.Repeat("x", 200)} {
+		code, err := Encode(text)
+		require.NoError(t, err)
+		require.Equal(t, code.version*4+17, code.Size())
+		require.True(t, code.Dark(0, 0) && code.Dark(6, 6) && !code.Dark(7, 7), "The finder pattern is drawn")
+		require.True(t, code.Dark(8, code.Size()-8), "The dark module is drawn")
+
+		var data bitBuffer
+		data.append(0b0100, 4)
+		data.append(len(text), countBits(code.version))
+		for _, b := range []byte(text) {
+			data.append(int(b), 8)
+		}
+		data.append(0, (8-len(data)%8)%8)
+		encoded := data.bytes()
+		if numBlocks[code.version] == 1 {
+			require.Equal(t, encoded, readCodewords(code)[:len(encoded)], "The data is placed in order")
+		} else {
+			require.Equal(t, encoded[0], readCodewords(code)[0], "The first block is placed first")
+		}
+	}
+
+	code, err := Encode("HELLO")
+	require.NoError(t, err)
+	require.Equal(t, 1, code.version)
+	require.Equal(t, []byte{0x40, 0x54, 0x84, 0x54, 0xC4, 0xC4, 0xF0, 0xEC, 0x11}, readCodewords(code)[:9], "The data ends with the terminator and padding")
+
+	_, err = Encode(strings.Repeat("x", 214))
+	require.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode("https://pliki.example.com")
+	require.NoError(t, err)
+	data, err := code.PNG(4)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, (code.Size()+2*quietZone)*4, img.Bounds().Dx())
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.NotZero(t, r, "The quiet zone is light")
+	r, _, _, _ = img.At(quietZone*4, quietZone*4).RGBA()
+	require.Zero(t, r, "The finder pattern is dark")
+}
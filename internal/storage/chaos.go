@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"io"
+	"serwer-plikow/internal/chaos"
+)
+
+// ChaosBackend wraps a backend so the injector can delay its operations and
+// make them fail, for chaos tests. A failed Save stores nothing.
+type ChaosBackend struct {
+	Backend
+	injector *chaos.Injector
+}
+
+func NewChaosBackend(backend Backend, injector *chaos.Injector) *ChaosBackend {
+	return &ChaosBackend{Backend: backend, injector: injector}
+}
+
+func (b *ChaosBackend) Save(id string, data io.Reader) error {
+	if err := b.injector.Fault(chaos.StorageSave); err != nil {
+		return err
+	}
+	return b.Backend.Save(id, data)
+}
+
+func (b *ChaosBackend) Get(id string) (io.ReadCloser, error) {
+	if err := b.injector.Fault(chaos.StorageGet); err != nil {
+		return nil, err
+	}
+	return b.Backend.Get(id)
+}
+
+func (b *ChaosBackend) GetRange(id string, offset, length int64) (io.ReadCloser, error) {
+	if err := b.injector.Fault(chaos.StorageGet); err != nil {
+		return nil, err
+	}
+	return b.Backend.GetRange(id, offset, length)
+}
+
+func (b *ChaosBackend) Delete(id string) error {
+	if err := b.injector.Fault(chaos.StorageDelete); err != nil {
+		return err
+	}
+	return b.Backend.Delete(id)
+}
+
+func (b *ChaosBackend) Rename(fromID, toID string) error {
+	if err := b.injector.Fault(chaos.StorageRename); err != nil {
+		return err
+	}
+	return b.Backend.Rename(fromID, toID)
+}
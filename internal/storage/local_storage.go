@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"crypto/rand"
 	"fmt"
 	"io"
 	"os"
@@ -10,13 +11,33 @@ import (
 
 type LocalStorage struct {
 	basePath string
+	// uploadsPath holds the chunks of resumable uploads. It is kept out of
+	// basePath, so upload areas never share a namespace with blobs.
+	uploadsPath string
+	// shredPasses is how many times deleted files are overwritten with random
+	// data before being unlinked; zero unlinks them directly.
+	shredPasses int
 }
 
 func NewLocalStorage(basePath string) (*LocalStorage, error) {
 	if err := os.MkdirAll(basePath, os.ModePerm); err != nil {
 		return nil, err
 	}
-	return &LocalStorage{basePath: basePath}, nil
+	return &LocalStorage{basePath: basePath, uploadsPath: filepath.Clean(basePath) + uploadsDirSuffix}, nil
+}
+
+// SetUploadsPath moves the chunks of resumable uploads to dir, which must not
+// be inside the storage root. Areas of uploads in progress are not moved.
+func (ls *LocalStorage) SetUploadsPath(dir string) error {
+	rel, err := filepath.Rel(ls.basePath, dir)
+	if err != nil {
+		return err
+	}
+	if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+		return fmt.Errorf("uploads path %s must be outside the storage root %s", dir, ls.basePath)
+	}
+	ls.uploadsPath = dir
+	return nil
 }
 
 func (ls *LocalStorage) getPathFromID(id string) string {
@@ -43,6 +64,12 @@ func (ls *LocalStorage) Save(id string, data io.Reader) error {
 }
 
 func (ls *LocalStorage) Get(id string) (io.ReadCloser, error) {
+	return ls.Open(id)
+}
+
+// Open returns the blob as a file, for readers that need random access such
+// as archive/zip.
+func (ls *LocalStorage) Open(id string) (*os.File, error) {
 	filePath := ls.getPathFromID(id)
 
 	file, err := os.Open(filePath)
@@ -56,9 +83,72 @@ func (ls *LocalStorage) Get(id string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (ls *LocalStorage) GetRange(id string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d of file %s", offset, length, id)
+	}
+	file, err := ls.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return rangeReader{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// EnableShredding makes every delete overwrite the file passes times with
+// random data first, so its content cannot be recovered from the disk. It is
+// meant for directly attached disks: copy-on-write file systems, SSD wear
+// levelling and snapshots may still keep the old blocks.
+func (ls *LocalStorage) EnableShredding(passes int) {
+	ls.shredPasses = max(passes, 0)
+}
+
+// shred overwrites a file in place. A missing file is not an error.
+func (ls *LocalStorage) shred(filePath string) error {
+	if ls.shredPasses == 0 {
+		return nil
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	for pass := 0; pass < ls.shredPasses; pass++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(file, rand.Reader, info.Size()); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ls *LocalStorage) Delete(id string) error {
 	filePath := ls.getPathFromID(id)
 
+	if err := ls.shred(filePath); err != nil {
+		return fmt.Errorf("failed to shred file with id %s: %w", id, err)
+	}
 	err := os.Remove(filePath)
 	if os.IsNotExist(err) {
 		return nil
@@ -66,3 +156,37 @@ func (ls *LocalStorage) Delete(id string) error {
 
 	return err
 }
+
+// Rename atomically replaces the blob stored under toID with the one stored
+// under fromID.
+func (ls *LocalStorage) Rename(fromID, toID string) error {
+	toPath := ls.getPathFromID(toID)
+	if err := os.MkdirAll(filepath.Dir(toPath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(ls.getPathFromID(fromID), toPath)
+}
+
+// Keys returns the IDs of all stored blobs, in lexical order. Upload areas
+// and other directories starting with a dot are skipped.
+func (ls *LocalStorage) Keys() ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(ls.basePath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(ls.basePath, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if rel != "." && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		keys = append(keys, strings.ReplaceAll(rel, string(filepath.Separator), ""))
+		return nil
+	})
+	return keys, err
+}
@@ -2,10 +2,14 @@ package storage
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -93,3 +97,226 @@ func TestLocalStorage_SaveWithLargeData(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, int64(len(largeContent)), fileInfo.Size())
 }
+
+func TestLocalStorage_UploadChunks(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalStorage(tempDir)
+	require.NoError(t, err)
+
+	location, err := storage.CreateUploadArea("session-1")
+	require.NoError(t, err)
+	require.Equal(t, UploadAreaLocation("session-1"), location)
+
+	written, err := storage.SaveChunk(location, 0, strings.NewReader("hello "))
+	require.NoError(t, err)
+	require.Equal(t, int64(6), written)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Chunks are kept outside the storage root")
+	_, err = storage.SaveChunk(location, 6, strings.NewReader("world"))
+	require.NoError(t, err)
+
+	var assembled bytes.Buffer
+	for _, offset := range []int64{0, 6} {
+		chunk, err := storage.OpenChunk(location, offset)
+		require.NoError(t, err)
+		_, err = io.Copy(&assembled, chunk)
+		require.NoError(t, err)
+		chunk.Close()
+	}
+	require.Equal(t, "hello world", assembled.String())
+
+	stale, err := storage.ListUploadAreasOlderThan(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"session-1"}, stale)
+
+	stale, err = storage.ListUploadAreasOlderThan(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	err = storage.DeleteUploadArea(location)
+	require.NoError(t, err)
+	_, err = storage.OpenChunk(location, 0)
+	require.Error(t, err)
+}
+
+func TestLocalStorage_Rename(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Save("target_id", strings.NewReader("old")))
+	require.NoError(t, storage.Save("staged_id", strings.NewReader("new")))
+
+	require.NoError(t, storage.Rename("staged_id", "target_id"))
+
+	readCloser, err := storage.Get("target_id")
+	require.NoError(t, err)
+	content, err := io.ReadAll(readCloser)
+	readCloser.Close()
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+
+	_, err = storage.Get("staged_id")
+	require.Error(t, err)
+}
+
+func TestLocalStorage_GetRange(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, storage.Save("range_id", strings.NewReader("0123456789")))
+
+	read := func(offset, length int64) string {
+		readCloser, err := storage.GetRange("range_id", offset, length)
+		require.NoError(t, err)
+		defer readCloser.Close()
+		content, err := io.ReadAll(readCloser)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	require.Equal(t, "345", read(3, 3))
+	require.Equal(t, "89", read(8, 10), "A range past the end is cut short")
+	require.Equal(t, "", read(20, 5))
+
+	_, err = storage.GetRange("range_id", -1, 5)
+	require.Error(t, err)
+	_, err = storage.GetRange("missing_id", 0, 5)
+	require.Error(t, err)
+}
+
+func TestLocalStorage_Shredding(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	content := strings.Repeat("tajne dane ", 1000)
+	require.NoError(t, storage.Save("shred_id", strings.NewReader(content)))
+
+	storage.EnableShredding(2)
+	path := storage.getPathFromID("shred_id")
+	require.NoError(t, storage.shred(path))
+	overwritten, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, overwritten, len(content), "Shredding keeps the size")
+	require.NotContains(t, string(overwritten), "tajne dane")
+
+	require.NoError(t, storage.Delete("shred_id"))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, storage.Delete("shred_id"), "Deleting a missing file is not an error")
+
+	location, err := storage.CreateUploadArea("shred-session")
+	require.NoError(t, err)
+	_, err = storage.SaveChunk(location, 0, strings.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, storage.DeleteUploadArea(location))
+	require.NoError(t, storage.DeleteUploadArea(location), "Deleting a missing area is not an error")
+}
+
+func TestLocalStorage_AssembleUpload(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	location, err := storage.CreateUploadArea("session-assemble")
+	require.NoError(t, err)
+
+	content := strings.Repeat("0123456789", 100)
+	var parts []ChunkPart
+	for offset := 0; offset < len(content); offset += 128 {
+		end := min(offset+128, len(content))
+		_, err := storage.SaveChunk(location, int64(offset), strings.NewReader(content[offset:end]))
+		require.NoError(t, err)
+		parts = append(parts, ChunkPart{Offset: int64(offset), Size: int64(end - offset)})
+	}
+
+	hashes, err := storage.AssembleUpload(location, "assembled_id", parts, 3)
+	require.NoError(t, err)
+	require.Len(t, hashes, len(parts))
+	sum := sha256.Sum256([]byte(content[:128]))
+	require.Equal(t, hex.EncodeToString(sum[:]), hashes[0])
+
+	readCloser, err := storage.Get("assembled_id")
+	require.NoError(t, err)
+	assembled, err := io.ReadAll(readCloser)
+	readCloser.Close()
+	require.NoError(t, err)
+	require.Equal(t, content, string(assembled))
+
+	parts[2].Size = 100
+	hashes, err = storage.AssembleUpload(location, "assembled_id", parts, 2)
+	require.NoError(t, err)
+	require.Empty(t, hashes[2], "A chunk with an unexpected size should not get a hash")
+
+	parts = append(parts, ChunkPart{Offset: 5000, Size: 10})
+	_, err = storage.AssembleUpload(location, "assembled_id", parts, 2)
+	require.Error(t, err)
+	_, err = storage.Get("assembled_id")
+	require.Error(t, err, "A failed assembly should not leave a partial blob behind")
+}
+
+func TestRouter(t *testing.T) {
+	ssd, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	archive, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	router := NewRouter(ssd)
+	require.NoError(t, router.Register("archive", archive))
+	require.Error(t, router.Register("archive", archive))
+	require.Error(t, router.AddRule(RoutingRule{Backend: "s3"}), "Rules must name a registered backend")
+	require.NoError(t, router.AddRule(RoutingRule{Backend: "archive", MimePrefixes: []string{"video/"}}))
+	require.NoError(t, router.AddRule(RoutingRule{Backend: "archive", MinSizeBytes: 1 << 20}))
+
+	require.Equal(t, "archive", router.Select(10, "Video/mp4"))
+	require.Equal(t, "archive", router.Select(2<<20, "application/pdf"))
+	require.Equal(t, DefaultBackend, router.Select(10, "application/pdf"))
+
+	backend, err := router.Backend("archive")
+	require.NoError(t, err)
+	require.Equal(t, archive, backend)
+	_, err = router.Backend("s3")
+	require.Error(t, err)
+
+	require.NoError(t, ssd.Save("blob", strings.NewReader("content")))
+	require.NoError(t, Transfer(ssd, "blob", archive, "moved"))
+	_, err = ssd.Get("blob")
+	require.Error(t, err, "The source is removed after a transfer")
+
+	moved, err := archive.Get("moved")
+	require.NoError(t, err)
+	content, _ := io.ReadAll(moved)
+	moved.Close()
+	require.Equal(t, "content", string(content))
+}
+
+func TestLocalStorage_Keys(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	for _, id := range []string{"sha256-ab12", "V1StGXR8_Z5jdHi6B-myT"} {
+		require.NoError(t, storage.Save(id, strings.NewReader(id)))
+	}
+	_, err = storage.CreateUploadArea("session")
+	require.NoError(t, err)
+	_, err = storage.SaveChunk(UploadAreaLocation("session"), 0, strings.NewReader("chunk"))
+	require.NoError(t, err)
+
+	keys, err := storage.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"V1StGXR8_Z5jdHi6B-myT", "sha256-ab12"}, keys)
+}
+
+func TestLocalStorage_SetUploadsPath(t *testing.T) {
+	root := t.TempDir()
+	storage, err := NewLocalStorage(filepath.Join(root, "blobs"))
+	require.NoError(t, err)
+
+	require.Error(t, storage.SetUploadsPath(filepath.Join(root, "blobs")))
+	require.Error(t, storage.SetUploadsPath(filepath.Join(root, "blobs", "uploads")))
+	require.NoError(t, storage.SetUploadsPath(filepath.Join(root, "chunks")))
+
+	location, err := storage.CreateUploadArea("session")
+	require.NoError(t, err)
+	_, err = storage.SaveChunk(location, 0, strings.NewReader("chunk"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(root, "chunks", "session", "0"))
+	require.NoError(t, err)
+}
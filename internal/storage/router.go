@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultBackend is the name of the backend that holds everything not routed
+// elsewhere: staged uploads, archived versions and derived artifacts live there
+// too.
+const DefaultBackend = "local"
+
+// Backend stores blobs under opaque IDs.
+type Backend interface {
+	Save(id string, data io.Reader) error
+	Get(id string) (io.ReadCloser, error)
+	// GetRange returns length bytes of the blob starting at offset; the stream
+	// ends early if the blob is shorter.
+	GetRange(id string, offset, length int64) (io.ReadCloser, error)
+	Delete(id string) error
+	Rename(fromID, toID string) error
+}
+
+// RoutingRule sends files matching all of its conditions to a backend.
+type RoutingRule struct {
+	Backend string
+	// MimePrefixes match the start of the MIME type, e.g. "video/". Empty
+	// matches any type.
+	MimePrefixes []string
+	// MinSizeBytes and MaxSizeBytes bound the file size; zero disables a bound.
+	MinSizeBytes int64
+	MaxSizeBytes int64
+}
+
+func (rule RoutingRule) matches(sizeBytes int64, mimeType string) bool {
+	if rule.MinSizeBytes > 0 && sizeBytes < rule.MinSizeBytes {
+		return false
+	}
+	if rule.MaxSizeBytes > 0 && sizeBytes > rule.MaxSizeBytes {
+		return false
+	}
+	if len(rule.MimePrefixes) == 0 {
+		return true
+	}
+	mimeType = strings.ToLower(mimeType)
+	for _, prefix := range rule.MimePrefixes {
+		if strings.HasPrefix(mimeType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Router picks the backend a new file is stored in and resolves the backend
+// recorded for existing files.
+type Router struct {
+	backends map[string]Backend
+	rules    []RoutingRule
+}
+
+func NewRouter(primary Backend) *Router {
+	return &Router{backends: map[string]Backend{DefaultBackend: primary}}
+}
+
+func (r *Router) Register(name string, backend Backend) error {
+	if _, exists := r.backends[name]; exists {
+		return fmt.Errorf("storage backend %q is already registered", name)
+	}
+	r.backends[name] = backend
+	return nil
+}
+
+// AddRule appends a routing rule. Rules are checked in the order they were
+// added and the first match wins.
+func (r *Router) AddRule(rule RoutingRule) error {
+	if _, exists := r.backends[rule.Backend]; !exists {
+		return fmt.Errorf("routing rule refers to unknown storage backend %q", rule.Backend)
+	}
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+// Select returns the name of the backend a file of the given size and type
+// should be stored in.
+func (r *Router) Select(sizeBytes int64, mimeType string) string {
+	for _, rule := range r.rules {
+		if rule.matches(sizeBytes, mimeType) {
+			return rule.Backend
+		}
+	}
+	return DefaultBackend
+}
+
+func (r *Router) Backend(name string) (Backend, error) {
+	backend, exists := r.backends[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown storage backend %q", name)
+	}
+	return backend, nil
+}
+
+// Transfer moves a blob between backends. Within one backend it is a rename;
+// otherwise the blob is copied and the source removed.
+func Transfer(from Backend, fromID string, to Backend, toID string) error {
+	if from == to {
+		return from.Rename(fromID, toID)
+	}
+	if err := Copy(from, fromID, to, toID); err != nil {
+		return err
+	}
+	return from.Delete(fromID)
+}
+
+// Copy stores a copy of a blob under toID, in the same or another backend.
+func Copy(from Backend, fromID string, to Backend, toID string) error {
+	blob, err := from.Get(fromID)
+	if err != nil {
+		return err
+	}
+	err = to.Save(toID, blob)
+	blob.Close()
+	if err != nil {
+		to.Delete(toID)
+		return err
+	}
+	return nil
+}
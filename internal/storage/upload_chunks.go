@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// uploadsDirSuffix names the default uploads directory after the storage
+// root, e.g. "/storage-uploads" next to "/storage".
+const uploadsDirSuffix = "-uploads"
+
+// CreateUploadArea prepares a temporary directory for the chunks of a resumable
+// upload session and returns its location relative to the uploads directory.
+func (ls *LocalStorage) CreateUploadArea(sessionID string) (string, error) {
+	location := UploadAreaLocation(sessionID)
+	if err := os.MkdirAll(ls.uploadAreaPath(location), os.ModePerm); err != nil {
+		return "", err
+	}
+	return location, nil
+}
+
+func (ls *LocalStorage) uploadAreaPath(location string) string {
+	return filepath.Join(ls.uploadsPath, location)
+}
+
+func (ls *LocalStorage) chunkPath(location string, offset int64) string {
+	return filepath.Join(ls.uploadAreaPath(location), strconv.FormatInt(offset, 10))
+}
+
+// SaveChunk writes a chunk starting at offset. The chunk becomes visible only
+// after it has been fully written, so an interrupted request never leaves a
+// truncated chunk behind.
+func (ls *LocalStorage) SaveChunk(location string, offset int64, data io.Reader) (int64, error) {
+	finalPath := ls.chunkPath(location, offset)
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(finalPath), ".part-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := tmpFile.Name()
+
+	written, err := io.Copy(tmpFile, data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	return written, nil
+}
+
+func (ls *LocalStorage) OpenChunk(location string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(ls.chunkPath(location, offset))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("chunk at offset %d not found in %s: %w", offset, location, err)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+type ChunkPart struct {
+	Offset int64
+	Size   int64
+}
+
+// AssembleUpload writes the chunks of an upload area into the blob id, using
+// up to workers concurrent readers. It returns the SHA-256 (hex) of every
+// chunk as read from disk, in the order of parts; a chunk whose size on disk
+// differs from the expected one gets an empty hash.
+func (ls *LocalStorage) AssembleUpload(location, id string, parts []ChunkPart, workers int) ([]string, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	filePath := ls.getPathFromID(id)
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	dest, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, part := range parts {
+		if end := part.Offset + part.Size; end > total {
+			total = end
+		}
+	}
+	if err := dest.Truncate(total); err != nil {
+		dest.Close()
+		os.Remove(filePath)
+		return nil, err
+	}
+
+	hashes := make([]string, len(parts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				hash, err := ls.copyChunk(location, parts[i], dest)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				hashes[i] = hash
+			}
+		}()
+	}
+	for i := range parts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := dest.Close(); firstErr == nil {
+		firstErr = err
+	}
+	if firstErr != nil {
+		os.Remove(filePath)
+		return nil, firstErr
+	}
+	return hashes, nil
+}
+
+func (ls *LocalStorage) copyChunk(location string, part ChunkPart, dest *os.File) (string, error) {
+	chunk, err := ls.OpenChunk(location, part.Offset)
+	if err != nil {
+		return "", err
+	}
+	defer chunk.Close()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.NewOffsetWriter(dest, part.Offset), io.TeeReader(io.LimitReader(chunk, part.Size), hasher))
+	if err != nil {
+		return "", err
+	}
+	if n, _ := chunk.Read(make([]byte, 1)); written != part.Size || n > 0 {
+		return "", nil
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (ls *LocalStorage) DeleteUploadArea(location string) error {
+	if location == "" {
+		return fmt.Errorf("empty upload area location")
+	}
+	areaPath := ls.uploadAreaPath(location)
+	if ls.shredPasses > 0 {
+		err := filepath.WalkDir(areaPath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			return ls.shred(path)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(areaPath)
+}
+
+// ListUploadAreasOlderThan returns the session IDs of upload areas that were
+// last modified before cutoff.
+func (ls *LocalStorage) ListUploadAreasOlderThan(cutoff time.Time) ([]string, error) {
+	entries, err := os.ReadDir(ls.uploadsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	sessionIDs := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			sessionIDs = append(sessionIDs, entry.Name())
+		}
+	}
+	return sessionIDs, nil
+}
+
+func UploadAreaLocation(sessionID string) string {
+	return filepath.Base(sessionID)
+}
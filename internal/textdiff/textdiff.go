@@ -0,0 +1,267 @@
+// Package textdiff computes line-based unified diffs between two texts.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change.
+const DefaultContext = 3
+
+// maxEdits bounds the work spent looking for a minimal diff. Texts that differ
+// in more lines are reported as a replacement of the differing region.
+const maxEdits = 2000
+
+const noNewlineMarker = "\\ No newline at end of file\n"
+
+type opKind byte
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	// a and b are the line indexes in the old and new text the operation
+	// applies to.
+	a, b int
+}
+
+// Unified returns the differences between from and to in unified diff format,
+// or an empty string when the texts are equal.
+func Unified(fromName, toName, from, to string, context int) string {
+	a, b := splitLines(from), splitLines(to)
+	ops := diffLines(a, b)
+
+	hunks := groupHunks(ops, context)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		writeHunk(&sb, hunk, a, b)
+	}
+	return sb.String()
+}
+
+// splitLines splits text into lines that keep their terminating newline, so a
+// missing newline at the end of a text counts as a change of its last line.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func diffLines(a, b []string) []op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{kind: opEqual, a: i, b: i})
+	}
+
+	middle, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		middle = middle[:0]
+		for i := prefix; i < len(a)-suffix; i++ {
+			middle = append(middle, op{kind: opDelete, a: i - prefix})
+		}
+		for j := prefix; j < len(b)-suffix; j++ {
+			middle = append(middle, op{kind: opInsert, b: j - prefix})
+		}
+	}
+	for _, o := range middle {
+		ops = append(ops, op{kind: o.kind, a: o.a + prefix, b: o.b + prefix})
+	}
+
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, op{kind: opEqual, a: len(a) - suffix + i, b: len(b) - suffix + i})
+	}
+	return ops
+}
+
+// myers finds a shortest edit script turning a into b. It gives up and returns
+// false when more than maxEdits insertions and deletions are needed.
+func myers(a, b []string) ([]op, bool) {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil, true
+	}
+
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	var reversed []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, op{kind: opEqual, a: x, b: y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			reversed = append(reversed, op{kind: opInsert, a: x, b: prevY})
+		} else {
+			reversed = append(reversed, op{kind: opDelete, a: prevX, b: y})
+		}
+		x, y = prevX, prevY
+	}
+
+	ops := make([]op, len(reversed))
+	for i, o := range reversed {
+		ops[len(reversed)-1-i] = o
+	}
+	return ops, true
+}
+
+// groupHunks splits the edit script into hunks of changes, each surrounded by
+// up to context unchanged lines.
+func groupHunks(ops []op, context int) [][]op {
+	if context < 0 {
+		context = 0
+	}
+
+	var hunks [][]op
+	start, end := -1, -1
+	for i, o := range ops {
+		if o.kind == opEqual {
+			continue
+		}
+		if start >= 0 && i-end > 2*context+1 {
+			hunks = append(hunks, ops[start:min(end+1+context, len(ops))])
+			start = -1
+		}
+		if start < 0 {
+			start = max(i-context, 0)
+		}
+		end = i
+	}
+	if start >= 0 {
+		hunks = append(hunks, ops[start:min(end+1+context, len(ops))])
+	}
+	return hunks
+}
+
+func writeHunk(sb *strings.Builder, hunk []op, a, b []string) {
+	aStart, bStart := -1, -1
+	aCount, bCount := 0, 0
+	for _, o := range hunk {
+		if o.kind != opInsert {
+			if aStart < 0 {
+				aStart = o.a
+			}
+			aCount++
+		}
+		if o.kind != opDelete {
+			if bStart < 0 {
+				bStart = o.b
+			}
+			bCount++
+		}
+	}
+	if aStart < 0 {
+		aStart = hunk[0].a
+	}
+	if bStart < 0 {
+		bStart = hunk[0].b
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, o := range hunk {
+		switch o.kind {
+		case opEqual:
+			writeLine(sb, ' ', a[o.a])
+		case opDelete:
+			writeLine(sb, '-', a[o.a])
+		case opInsert:
+			writeLine(sb, '+', b[o.b])
+		}
+	}
+}
+
+// hunkRange formats a 0-based start and a line count the way unified diffs
+// do: empty ranges point at the line before them.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(sb *strings.Builder, prefix byte, line string) {
+	sb.WriteByte(prefix)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n")
+		sb.WriteString(noNewlineMarker)
+	}
+}
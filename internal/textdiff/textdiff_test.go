@@ -0,0 +1,138 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// applyUnified applies a diff produced by Unified to from, checking that the
+// context and deleted lines match.
+func applyUnified(t *testing.T, from, diff string) string {
+	t.Helper()
+
+	src := splitLines(from)
+	lines := splitLines(diff)
+	require.GreaterOrEqual(t, len(lines), 2)
+	lines = lines[2:]
+
+	var out strings.Builder
+	pos := 0
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "@@") {
+			var aStart, aCount int
+			header := strings.Fields(line)[1]
+			if _, err := fmt.Sscanf(header, "-%d,%d", &aStart, &aCount); err != nil {
+				_, err = fmt.Sscanf(header, "-%d", &aStart)
+				require.NoError(t, err)
+				aCount = 1
+			}
+			target := aStart - 1
+			if aCount == 0 {
+				target = aStart
+			}
+			for pos < target {
+				out.WriteString(src[pos])
+				pos++
+			}
+			continue
+		}
+
+		text := line[1:]
+		if i+1 < len(lines) && lines[i+1] == noNewlineMarker {
+			text = strings.TrimSuffix(text, "\n")
+			i++
+		}
+		switch line[0] {
+		case ' ':
+			require.Equal(t, src[pos], text)
+			out.WriteString(text)
+			pos++
+		case '-':
+			require.Equal(t, src[pos], text)
+			pos++
+		case '+':
+			out.WriteString(text)
+		}
+	}
+	for pos < len(src) {
+		out.WriteString(src[pos])
+		pos++
+	}
+	return out.String()
+}
+
+func numberedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d\n", i+1)
+	}
+	return lines
+}
+
+func TestUnified(t *testing.T) {
+	t.Run("equal texts", func(t *testing.T) {
+		require.Empty(t, Unified("a", "b", "x\ny\n", "x\ny\n", DefaultContext))
+	})
+
+	t.Run("single change", func(t *testing.T) {
+		diff := Unified("a.txt", "b.txt", "one\ntwo\nthree\n", "one\n2\nthree\n", DefaultContext)
+		require.Equal(t, "--- a.txt\n+++ b.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n", diff)
+	})
+
+	t.Run("insert into empty text", func(t *testing.T) {
+		diff := Unified("a", "b", "", "new\n", DefaultContext)
+		require.Equal(t, "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n", diff)
+	})
+
+	t.Run("missing trailing newline", func(t *testing.T) {
+		diff := Unified("a", "b", "x\ny", "x\ny\n", DefaultContext)
+		require.Equal(t, "--- a\n+++ b\n@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+y\n", diff)
+		require.Equal(t, "x\ny\n", applyUnified(t, "x\ny", diff))
+	})
+
+	t.Run("distant changes form separate hunks", func(t *testing.T) {
+		from := numberedLines(40)
+		to := append([]string{}, from...)
+		to[2] = "changed 3\n"
+		to[30] = "changed 31\n"
+		diff := Unified("a", "b", strings.Join(from, ""), strings.Join(to, ""), DefaultContext)
+		require.Equal(t, 2, strings.Count(diff, "@@ -"))
+		require.Contains(t, diff, "@@ -1,6 +1,6 @@")
+		require.Contains(t, diff, "@@ -28,7 +28,7 @@")
+		require.Equal(t, strings.Join(to, ""), applyUnified(t, strings.Join(from, ""), diff))
+	})
+
+	t.Run("round trip of scattered edits", func(t *testing.T) {
+		from := numberedLines(200)
+		var to []string
+		for i, line := range from {
+			switch {
+			case i%17 == 0:
+				continue
+			case i%23 == 0:
+				to = append(to, "inserted\n", line)
+			case i%29 == 0:
+				to = append(to, strings.ToUpper(line))
+			default:
+				to = append(to, line)
+			}
+		}
+		diff := Unified("a", "b", strings.Join(from, ""), strings.Join(to, ""), 2)
+		require.Equal(t, strings.Join(to, ""), applyUnified(t, strings.Join(from, ""), diff))
+	})
+
+	t.Run("too many changes fall back to a replacement", func(t *testing.T) {
+		var from, to strings.Builder
+		for i := 0; i < maxEdits; i++ {
+			fmt.Fprintf(&from, "old %d\n", i)
+			fmt.Fprintf(&to, "new %d\n", i)
+		}
+		diff := Unified("a", "b", from.String(), to.String(), DefaultContext)
+		require.Contains(t, diff, fmt.Sprintf("@@ -1,%d +1,%d @@", maxEdits, maxEdits))
+		require.Equal(t, to.String(), applyUnified(t, from.String(), diff))
+	})
+}
@@ -0,0 +1,114 @@
+// Package transcription turns audio and video into subtitles or plain text
+// through an external speech-to-text service.
+package transcription
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	FormatVTT  = "vtt"
+	FormatText = "txt"
+)
+
+// maxErrorBody bounds how much of an error response is quoted in errors.
+const maxErrorBody = 512
+
+// Transcriber produces a transcript of audio in the given format.
+type Transcriber interface {
+	Transcribe(ctx context.Context, fileName string, audio io.Reader, format string) ([]byte, error)
+}
+
+// WhisperClient talks to an OpenAI Whisper-compatible
+// /v1/audio/transcriptions endpoint.
+type WhisperClient struct {
+	endpoint   string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+func NewWhisperClient(endpoint, apiKey, model string, timeout time.Duration) *WhisperClient {
+	return &WhisperClient{
+		endpoint:   endpoint,
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// responseFormat maps a transcript format to the API's response_format.
+func responseFormat(format string) (string, error) {
+	switch format {
+	case FormatVTT:
+		return "vtt", nil
+	case FormatText:
+		return "text", nil
+	default:
+		return "", fmt.Errorf("unsupported transcript format %q", format)
+	}
+}
+
+// Transcribe uploads audio as a multipart form and returns the response body.
+// The audio is streamed, so it is never held in memory as a whole.
+func (c *WhisperClient) Transcribe(ctx context.Context, fileName string, audio io.Reader, format string) ([]byte, error) {
+	apiFormat, err := responseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		err := writeForm(form, fileName, audio, c.model, apiFormat)
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("transcription request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("transcription service returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func writeForm(form *multipart.Writer, fileName string, audio io.Reader, model, responseFormat string) error {
+	if model != "" {
+		if err := form.WriteField("model", model); err != nil {
+			return err
+		}
+	}
+	if err := form.WriteField("response_format", responseFormat); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return err
+	}
+	return form.Close()
+}
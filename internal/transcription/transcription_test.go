@@ -0,0 +1,52 @@
+package transcription
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhisperClientTranscribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		require.Equal(t, "whisper-1", r.FormValue("model"))
+		require.Equal(t, "vtt", r.FormValue("response_format"))
+
+		file, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer file.Close()
+		require.Equal(t, "nagranie.mp3", header.Filename)
+		audio, _ := io.ReadAll(file)
+		require.Equal(t, "audio-bytes", string(audio))
+
+		io.WriteString(w, "WEBVTT\n\n00:00.000 --> 00:01.000\nDzień dobry\n")
+	}))
+	defer server.Close()
+
+	client := NewWhisperClient(server.URL, "secret", "whisper-1", time.Minute)
+	transcript, err := client.Transcribe(context.Background(), "nagranie.mp3", strings.NewReader("audio-bytes"), FormatVTT)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(transcript), "WEBVTT"))
+}
+
+func TestWhisperClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model overloaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewWhisperClient(server.URL, "", "", time.Minute)
+	_, err := client.Transcribe(context.Background(), "a.wav", strings.NewReader("x"), FormatText)
+	require.ErrorContains(t, err, "503")
+	require.ErrorContains(t, err, "model overloaded")
+
+	_, err = client.Transcribe(context.Background(), "a.wav", strings.NewReader("x"), "srt")
+	require.ErrorContains(t, err, "unsupported transcript format")
+}
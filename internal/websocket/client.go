@@ -1,21 +1,33 @@
 package websocket
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
+// overflowCloseReason tells a client disconnected on overflow that it missed
+// events and has to catch up through /events.
+const overflowCloseReason = "send buffer overflow, resync via /events"
+
 type Client struct {
 	hub    *Hub
 	conn   *websocket.Conn
 	send   chan []byte
 	UserID int64
+	// overflowed is set once the client is being disconnected for falling
+	// behind.
+	overflowed atomic.Bool
+	// closed is set, under the hub's lock, when the send channel is closed.
+	closed bool
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn, userID int64) *Client {
 	return &Client{
 		hub:    hub,
 		conn:   conn,
-		send:   make(chan []byte, 256),
+		send:   make(chan []byte, hub.options.SendBufferSize),
 		UserID: userID,
 	}
 }
@@ -36,10 +48,17 @@ func (c *Client) WritePump() {
 	for {
 		message, ok := <-c.send
 		if !ok {
-			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			closeMessage := []byte{}
+			if c.overflowed.Load() {
+				closeMessage = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, overflowCloseReason)
+			}
+			c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 			return
 		}
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		start := time.Now()
+		err := c.conn.WriteMessage(websocket.TextMessage, message)
+		writeDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
 			return
 		}
 	}
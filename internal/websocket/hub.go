@@ -3,6 +3,7 @@ package websocket
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -14,17 +15,36 @@ var Upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// DefaultSendBufferSize is the number of events queued per client before
+// further events are dropped.
+const DefaultSendBufferSize = 256
+
+type HubOptions struct {
+	// SendBufferSize is the per-client event queue length; zero means
+	// DefaultSendBufferSize.
+	SendBufferSize int
+	// DisconnectOnOverflow closes the connection of a client whose queue is
+	// full instead of silently dropping the event, so the client knows it has
+	// to resync through /events.
+	DisconnectOnOverflow bool
+}
+
 type Hub struct {
 	clients    map[int64]map[*Client]bool
 	mu         sync.RWMutex
+	options    HubOptions
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan []byte
 }
 
-func NewHub() *Hub {
+func NewHub(options HubOptions) *Hub {
+	if options.SendBufferSize <= 0 {
+		options.SendBufferSize = DefaultSendBufferSize
+	}
 	return &Hub{
 		clients:    make(map[int64]map[*Client]bool),
+		options:    options,
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan []byte),
@@ -58,6 +78,7 @@ func (h *Hub) unregisterClient(client *Client) {
 	if userClients, ok := h.clients[client.UserID]; ok {
 		if _, ok := userClients[client]; ok {
 			delete(userClients, client)
+			client.closed = true
 			close(client.send)
 			if len(userClients) == 0 {
 				delete(h.clients, client.UserID)
@@ -70,13 +91,60 @@ func (h *Hub) unregisterClient(client *Client) {
 func (h *Hub) PublishEvent(userID int64, eventData []byte) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if userClients, ok := h.clients[userID]; ok {
+	userLabel := strconv.FormatInt(userID, 10)
+	for client := range h.clients[userID] {
+		h.deliver(client, userLabel, eventData)
+	}
+}
+
+// PublishAll sends an event to every connected client, for system-wide
+// notices that are not tied to a user.
+func (h *Hub) PublishAll(eventData []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for userID, userClients := range h.clients {
+		userLabel := strconv.FormatInt(userID, 10)
 		for client := range userClients {
-			select {
-			case client.send <- eventData:
-			default:
-				log.Printf("WARN: Client for user %d send buffer is full. Dropping message.", userID)
-			}
+			h.deliver(client, userLabel, eventData)
 		}
 	}
 }
+
+// deliver queues an event for one client. The hub lock must be held.
+func (h *Hub) deliver(client *Client, userLabel string, eventData []byte) {
+	sendBufferSaturation.Observe(float64(len(client.send)) / float64(cap(client.send)))
+	select {
+	case client.send <- eventData:
+		messagesTotal.WithLabelValues(userLabel, "delivered").Inc()
+	default:
+		messagesTotal.WithLabelValues(userLabel, "dropped").Inc()
+		if !h.options.DisconnectOnOverflow {
+			log.Printf("WARN: Client for user %d send buffer is full. Dropping message.", client.UserID)
+			return
+		}
+		if client.overflowed.CompareAndSwap(false, true) {
+			log.Printf("WARN: Client for user %d send buffer is full. Disconnecting it.", client.UserID)
+			overflowDisconnectsTotal.Inc()
+			// The hub lock is held here, so the client is unregistered
+			// from another goroutine.
+			go func(c *Client) { h.Unregister <- c }(client)
+		}
+	}
+}
+
+// SendTo queues a message for one client, which may still be waiting to be
+// registered. It reports false when the client was unregistered or its buffer
+// is full, in which case the message is dropped.
+func (h *Hub) SendTo(client *Client, message []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if client.closed {
+		return false
+	}
+	select {
+	case client.send <- message:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishEventOverflow(t *testing.T) {
+	hub := NewHub(HubOptions{SendBufferSize: 1})
+	client := NewClient(hub, nil, 7)
+	hub.registerClient(client)
+
+	hub.PublishEvent(7, []byte("first"))
+	hub.PublishEvent(7, []byte("second"))
+	require.Len(t, client.send, 1, "Events beyond the buffer are dropped")
+	require.False(t, client.overflowed.Load(), "Without disconnect_on_overflow the client stays connected")
+
+	hub = NewHub(HubOptions{SendBufferSize: 1, DisconnectOnOverflow: true})
+	go hub.Run()
+	client = NewClient(hub, nil, 7)
+	hub.registerClient(client)
+
+	hub.PublishEvent(7, []byte("first"))
+	hub.PublishEvent(7, []byte("second"))
+	require.True(t, client.overflowed.Load())
+
+	require.Equal(t, []byte("first"), <-client.send)
+	select {
+	case _, ok := <-client.send:
+		require.False(t, ok, "The overflowing client is unregistered and its queue closed")
+	case <-time.After(time.Second):
+		t.Fatal("The overflowing client was not unregistered")
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	hub := NewHub(HubOptions{SendBufferSize: 1})
+	client := NewClient(hub, nil, 7)
+
+	require.True(t, hub.SendTo(client, []byte("catch_up")), "Clients can be queued for before they are registered")
+	require.False(t, hub.SendTo(client, []byte("more")), "A full buffer drops the message")
+
+	hub.registerClient(client)
+	hub.unregisterClient(client)
+	require.False(t, hub.SendTo(client, []byte("late")))
+}
+
+func TestPublishAll(t *testing.T) {
+	hub := NewHub(HubOptions{SendBufferSize: 2})
+	first := NewClient(hub, nil, 7)
+	second := NewClient(hub, nil, 7)
+	other := NewClient(hub, nil, 8)
+	hub.registerClient(first)
+	hub.registerClient(second)
+	hub.registerClient(other)
+
+	hub.PublishAll([]byte("notice"))
+	for _, client := range []*Client{first, second, other} {
+		require.Equal(t, []byte("notice"), <-client.send)
+	}
+}
@@ -0,0 +1,33 @@
+package websocket
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_messages_total",
+			Help: "Events queued for WebSocket clients, labeled by user and result (delivered or dropped).",
+		},
+		[]string{"user_id", "result"},
+	)
+
+	sendBufferSaturation = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "websocket_send_buffer_saturation",
+		Help:    "Fill ratio of a client's send buffer when an event is queued.",
+		Buckets: []float64{.1, .25, .5, .75, .9, 1},
+	})
+
+	writeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "websocket_write_duration_seconds",
+		Help:    "Time taken to write one message to a WebSocket connection.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	overflowDisconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_overflow_disconnects_total",
+		Help: "Clients disconnected because their send buffer was full.",
+	})
+)